@@ -0,0 +1,142 @@
+package minidb
+
+// ------------------------------------------------------------------------------
+// Access policies of fields in the command API
+// ------------------------------------------------------------------------------
+
+// FieldAccess is the access policy of a field for clients of an Executor. It is stored with
+// the field and does not restrict the direct API, so servers can still maintain protected
+// fields themselves.
+type FieldAccess int
+
+const (
+	// AccessReadWrite fields can be read and written by all commands.
+	AccessReadWrite FieldAccess = iota
+	// AccessReadOnly fields can be read by all commands but not written by any, for fields
+	// maintained by the server such as creation dates or keys.
+	AccessReadOnly
+	// AccessAdminOnly fields can be read by all commands but only written by commands
+	// executed by an executor with admin rights, see Executor.SetAdmin.
+	AccessAdminOnly
+)
+
+// validFieldAccess returns true if the access policy is known.
+func validFieldAccess(access FieldAccess) bool {
+	return access >= AccessReadWrite && access <= AccessAdminOnly
+}
+
+// String returns the name of the access policy as used by ParseFieldDesc.
+func (access FieldAccess) String() string {
+	switch access {
+	case AccessReadWrite:
+		return "readwrite"
+	case AccessReadOnly:
+		return "readonly"
+	case AccessAdminOnly:
+		return "adminonly"
+	}
+	return "unknown"
+}
+
+// SetFieldAccess sets the access policy of an existing field.
+func (tx *Tx) SetFieldAccess(table string, field string, access FieldAccess) error {
+	if !validTable.MatchString(table) {
+		return Fail("invalid table name '%s'", table)
+	}
+	if !validFieldAccess(access) {
+		return Fail("unknown access policy %d", access)
+	}
+	view := tx.View()
+	desc, err := view.getField(table, field)
+	if err != nil {
+		return err
+	}
+	if desc.Access == access {
+		return nil
+	}
+	id, err := view.getTableId(table)
+	if err != nil {
+		return err
+	}
+	_, err = tx.tx.Exec(`UPDATE _COLS SET Access=? WHERE Owner=? AND Name=?;`, access, id, field)
+	if err != nil {
+		return Fail("failed to update maintenance table: %s", err)
+	}
+	err = tx.audit(AuditEntry{Op: AuditFieldAccess, Table: table, Field: field,
+		Old: []Value{NewString(desc.Access.String())}, New: []Value{NewString(access.String())}})
+	if err != nil {
+		return err
+	}
+	tx.notify(ChangeEvent{Kind: ChangeSchema, Table: table, Field: field})
+	return nil
+}
+
+// SetAdmin gives the clients of the executor admin rights if on is true. They may then write
+// fields with the access policy AccessAdminOnly and change access policies with
+// SetFieldAccessCommand. A server may use one executor with and one without admin rights for
+// different kinds of clients.
+func (e *Executor) SetAdmin(on bool) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.admin = on
+}
+
+// isAdmin returns true if the client of the command has admin rights, which are those of the
+// executor unless the client has its own roles, see ExecWithRoles.
+func (e *Executor) isAdmin(cmd *Command) bool {
+	if cmd.roles != nil {
+		return hasRole(cmd.roles, RoleAdmin)
+	}
+	e.mutex.RLock()
+	defer e.mutex.RUnlock()
+	return e.admin || e.hasRoleLocked(RoleAdmin)
+}
+
+// writtenFields returns the table and fields whose values are written by the command.
+func writtenFields(cmd *Command) (string, []string) {
+	switch cmd.ID {
+	case CmdSet, CmdSetIfVersion:
+		if len(cmd.StrArgs) == 2 {
+			return cmd.StrArgs[0], []string{cmd.StrArgs[1]}
+		}
+	case CmdSetItem:
+		if len(cmd.StrArgs) == 1 {
+			fields := make([]string, 0, len(cmd.ValueMap))
+			for field := range cmd.ValueMap {
+				fields = append(fields, field)
+			}
+			return cmd.StrArgs[0], fields
+		}
+	}
+	return "", nil
+}
+
+// checkFieldAccess returns an error if the command writes a field that its access policy
+// protects from the clients of the executor.
+func (e *Executor) checkFieldAccess(db *MDB, cmd *Command) error {
+	if cmd.ID == CmdSetFieldAccess {
+		if !e.isAdmin(cmd) {
+			return Fail("exec failed: access policies can only be changed with admin rights")
+		}
+		return nil
+	}
+	table, fields := writtenFields(cmd)
+	if len(fields) == 0 {
+		return nil
+	}
+	admin := e.isAdmin(cmd)
+	for _, field := range fields {
+		desc, err := db.getField(table, field)
+		if err != nil {
+			// unknown fields are reported by the command itself
+			continue
+		}
+		switch {
+		case desc.Access == AccessReadOnly:
+			return Fail("exec failed: field '%s' in table '%s' is read-only", field, table)
+		case desc.Access == AccessAdminOnly && !admin:
+			return Fail("exec failed: field '%s' in table '%s' can only be written with admin rights", field, table)
+		}
+	}
+	return nil
+}
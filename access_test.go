@@ -0,0 +1,301 @@
+package minidb
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFieldAccess(t *testing.T) {
+	tmp, _ := ioutil.TempFile("", "minidb-access-testing-*")
+	defer os.Remove(tmp.Name())
+	db := CommandDB(tmp.Name())
+	e := NewExecutor()
+	defer e.CloseAllDBs()
+	if r := e.Exec(OpenCommand("sqlite3", tmp.Name())); r.HasError {
+		t.Fatalf("Open command failed: %s", r.Str)
+	}
+	fields, err := ParseFieldDesc([]string{"string", "Name", "string", "Key", "readonly", "int", "Level", "adminonly"})
+	if err != nil || fields[1].Access != AccessReadOnly || fields[2].Access != AccessAdminOnly {
+		t.Errorf("ParseFieldDesc() returned %v (%v), expected access policies", fields, err)
+	}
+	if r := e.Exec(AddTableCommand(db, "Person", fields)); r.HasError {
+		t.Errorf("AddTable command failed: %s", r.Str)
+	}
+	r := e.Exec(GetFieldsCommand(db, "Person"))
+	for _, field := range r.Fields {
+		if field.Name == "Key" && field.Access != AccessReadOnly {
+			t.Errorf("GetFields command returned access %s for Key, expected readonly", field.Access)
+		}
+	}
+	item := e.Exec(NewItemCommand(db, 0, "Person")).Items[0]
+	tx := TxID(e.Exec(BeginCommand(db)).Int)
+	if r := e.Exec(SetCommand(db, tx, "Person", item, "Name", []Value{NewString("John")})); r.HasError {
+		t.Errorf("Set command of a writable field failed: %s", r.Str)
+	}
+	for _, field := range []string{"Key", "Level"} {
+		value := []Value{NewInt(1)}
+		if r := e.Exec(SetCommand(db, tx, "Person", item, field, value)); !r.HasError || r.Int != ErrNotPermitted {
+			t.Errorf("Set command of %s returned %v, expected ErrNotPermitted", field, r)
+		}
+		values := map[string][]Value{"Name": []Value{NewString("Jim")}, field: value}
+		if r := e.Exec(SetItemCommand(db, tx, "Person", item, values)); !r.HasError || r.Int != ErrNotPermitted {
+			t.Errorf("SetItem command with %s returned %v, expected ErrNotPermitted", field, r)
+		}
+	}
+	if r := e.Exec(SetFieldAccessCommand(db, tx, "Person", "Name", AccessReadOnly)); !r.HasError || r.Int != ErrNotPermitted {
+		t.Errorf("SetFieldAccess command without admin rights returned %v, expected ErrNotPermitted", r)
+	}
+
+	e.SetAdmin(true)
+	if r := e.Exec(SetCommand(db, tx, "Person", item, "Level", []Value{NewInt(3)})); r.HasError {
+		t.Errorf("Set command of an admin-only field with admin rights failed: %s", r.Str)
+	}
+	if r := e.Exec(SetCommand(db, tx, "Person", item, "Key", []Value{NewString("k")})); !r.HasError {
+		t.Errorf("Set command of a read-only field with admin rights should fail")
+	}
+	if r := e.Exec(SetFieldAccessCommand(db, tx, "Person", "Key", AccessReadWrite)); r.HasError {
+		t.Errorf("SetFieldAccess command failed: %s", r.Str)
+	}
+	if r := e.Exec(SetCommand(db, tx, "Person", item, "Key", []Value{NewString("k")})); r.HasError {
+		t.Errorf("Set command after SetFieldAccess failed: %s", r.Str)
+	}
+	if r := e.Exec(SetFieldAccessCommand(db, tx, "Person", "Key", FieldAccess(42))); !r.HasError {
+		t.Errorf("SetFieldAccess command with an unknown policy should fail")
+	}
+	if r := e.Exec(CommitCommand(db, tx)); r.HasError {
+		t.Errorf("Commit command failed: %s", r.Str)
+	}
+	if r := e.Exec(GetCommand(db, "Person", item, "Key")); len(r.Values) != 1 || r.Values[0].Str != "k" {
+		t.Errorf("Get command returned %v, expected k", r.Values)
+	}
+}
+
+func TestExecutorRoles(t *testing.T) {
+	tmp, _ := ioutil.TempFile("", "minidb-access-testing-*")
+	defer os.Remove(tmp.Name())
+	db := CommandDB(tmp.Name())
+	e := NewExecutor()
+	defer e.CloseAllDBs()
+	e.SetRoles(RoleReadOnly)
+	if r := e.Exec(OpenCommand("sqlite3", tmp.Name())); r.HasError {
+		t.Fatalf("Open command with role readonly failed: %s", r.Str)
+	}
+	if r := e.Exec(AddTableCommand(db, "Person", []Field{Field{Name: "Name", Sort: DBString}})); !r.HasError || r.Int != ErrNotPermitted {
+		t.Errorf("AddTable command with role readonly returned %v, expected ErrNotPermitted", r)
+	}
+	if r := e.Exec(TableExistsCommand(db, "Person")); r.HasError || r.Bool {
+		t.Errorf("TableExists command with role readonly returned %v", r)
+	}
+	e.SetRoles(RoleReadOnly, RoleUser)
+	if r := e.Exec(AddTableCommand(db, "Person", []Field{Field{Name: "Name", Sort: DBString}})); r.HasError {
+		t.Errorf("AddTable command with role user failed: %s", r.Str)
+	}
+	if r := e.Exec(CompactCommand(db)); !r.HasError || r.Int != ErrNotPermitted {
+		t.Errorf("Compact command with role user returned %v, expected ErrNotPermitted", r)
+	}
+	e.DefineRole("counter", func(id CommandID) bool { return id == CmdCount })
+	e.SetRoles("counter")
+	if r := e.Exec(CountCommand(db, "Person")); r.HasError {
+		t.Errorf("Count command with a custom role failed: %s", r.Str)
+	}
+	if r := e.Exec(ListItemsCommand(db, "Person", 0)); !r.HasError {
+		t.Errorf("ListItems command should not be permitted for a custom role that only counts")
+	}
+	if err := e.DefineRole("not valid", nil); err == nil {
+		t.Errorf("DefineRole() with an invalid role name should fail")
+	}
+	e.SetRoles(RoleAdmin)
+	if !e.isAdmin(&Command{}) {
+		t.Errorf("role admin should give admin rights")
+	}
+	e.ClearRoles()
+	if r := e.Exec(CompactCommand(db)); r.HasError {
+		t.Errorf("Compact command after ClearRoles failed: %s", r.Str)
+	}
+
+	// the roles of a client only restrict its own commands
+	if r := e.ExecWithRoles(CompactCommand(db), []Role{RoleUser}); !r.HasError || r.Int != ErrNotPermitted {
+		t.Errorf("Compact command of a client with role user returned %v, expected ErrNotPermitted", r)
+	}
+	if r := e.ExecWithRoles(CompactCommand(db), nil); r.HasError {
+		t.Errorf("Compact command of a client without roles failed: %s", r.Str)
+	}
+	if e.isAdmin(&Command{roles: []Role{RoleReadOnly}}) || !e.isAdmin(&Command{roles: []Role{RoleAdmin}}) {
+		t.Errorf("the admin rights of a client should follow its roles")
+	}
+	results := e.ExecBatchWithRoles([]Command{*TableExistsCommand(db, "Person"), *CompactCommand(db)}, false, []Role{RoleReadOnly})
+	if len(results) != 2 || results[0].HasError || results[1].Int != ErrNotPermitted {
+		t.Errorf("batch of a client with role readonly returned %v", results)
+	}
+}
+
+func TestReadOnlyExecutor(t *testing.T) {
+	tmp, _ := ioutil.TempFile("", "minidb-access-testing-*")
+	defer os.Remove(tmp.Name())
+	db := CommandDB(tmp.Name())
+	e := NewExecutor()
+	defer e.CloseAllDBs()
+	e.Exec(OpenCommand("sqlite3", tmp.Name()))
+	e.Exec(AddTableCommand(db, "Person", []Field{Field{Name: "Name", Sort: DBString}}))
+	item := e.Exec(NewItemCommand(db, 0, "Person")).Items[0]
+
+	e.SetReadOnly(true)
+	r := e.Exec(SetCommand(db, 0, "Person", item, "Name", []Value{NewString("John")}))
+	if !r.HasError || r.Int != ErrReadOnly || r.Cause != CauseReadOnly || !errors.Is(r.Err(), ErrReadOnlyExecutor) {
+		t.Errorf("Set command of a read-only executor returned %v, expected ErrReadOnly", r)
+	}
+	for _, cmd := range []*Command{AddTableCommand(db, "Asset", nil), RemoveItemCommand(db, 0, "Person", item),
+		BeginCommand(db)} {
+		if r := e.Exec(cmd); !r.HasError || r.Int != ErrReadOnly {
+			t.Errorf("command %d of a read-only executor returned %v, expected ErrReadOnly", cmd.ID, r)
+		}
+	}
+	if r := e.Exec(CountCommand(db, "Person")); r.HasError || r.Int != 1 {
+		t.Errorf("Count command of a read-only executor returned %v", r)
+	}
+	r = e.Exec(BeginReadCommand(db))
+	if r.HasError {
+		t.Fatalf("BeginRead command of a read-only executor failed: %s", r.Str)
+	}
+	if r = e.Exec(CommitCommand(db, TxID(r.Int))); r.HasError {
+		t.Errorf("Commit command of a read-only executor failed: %s", r.Str)
+	}
+	results := e.ExecBatch([]Command{*CountCommand(db, "Person"), *ItemExistsCommand(db, "Person", item)}, true)
+	if len(results) != 2 || results[1].HasError || !results[1].Bool {
+		t.Errorf("a batch in a transaction of a read-only executor returned %v", results)
+	}
+	if info := e.hello(); info.Supports(CmdSet) || !info.Supports(CmdGet) || !info.Supports(CmdBeginRead) {
+		t.Errorf("Hello command of a read-only executor returned the commands %v", info.Commands)
+	}
+
+	e.SetReadOnly(false)
+	if r := e.Exec(NewItemCommand(db, 0, "Person")); r.HasError {
+		t.Errorf("NewItem command after SetReadOnly(false) failed: %s", r.Str)
+	}
+}
+
+func TestAuth(t *testing.T) {
+	e := NewExecutor()
+	defer e.CloseAllDBs()
+	if r := e.CheckAuth(CountCommand("db", "Person"), false); r != nil {
+		t.Errorf("CheckAuth refused a command although no authentication is required: %s", r.Str)
+	}
+	if r := e.Exec(AuthCommand("anything")); r.HasError {
+		t.Errorf("Auth command failed although no authentication is required: %s", r.Str)
+	}
+
+	e.SetAuthTokens("secret", "other secret")
+	r := e.CheckAuth(CountCommand("db", "Person"), false)
+	if r == nil || r.Int != ErrNotAuthenticated || !errors.Is(r.Err(), ErrUnauthenticated) {
+		t.Errorf("CheckAuth returned %v for an unauthenticated connection, expected ErrNotAuthenticated", r)
+	}
+	for _, cmd := range []*Command{PingCommand(), HelloCommand(), AuthCommand("wrong")} {
+		if r := e.CheckAuth(cmd, false); r != nil {
+			t.Errorf("CheckAuth refused command %d on an unauthenticated connection", cmd.ID)
+		}
+	}
+	if r := e.CheckAuth(CountCommand("db", "Person"), true); r != nil {
+		t.Errorf("CheckAuth refused a command on an authenticated connection: %s", r.Str)
+	}
+	if r := e.Exec(AuthCommand("wrong")); !r.HasError || r.Int != ErrAuthFailed {
+		t.Errorf("Auth command with a wrong token returned %v, expected ErrAuthFailed", r)
+	}
+	if r := e.Exec(AuthCommand("other secret")); r.HasError {
+		t.Errorf("Auth command with a valid token failed: %s", r.Str)
+	}
+	if info := e.hello(); !info.HasCapability(CapabilityAuth) || !info.Supports(CmdAuth) {
+		t.Errorf("Hello command of an executor that requires authentication returned %v", info)
+	}
+
+	tmpdir, err := ioutil.TempDir("", "minidb-auth-testing-*")
+	if err != nil {
+		t.Fatalf("could not create temporary directory for testing")
+	}
+	defer os.RemoveAll(tmpdir)
+	m, err := NewMultiDB(tmpdir, "sqlite3")
+	if err != nil {
+		t.Fatalf("error creating MultiDB: %s", err)
+	}
+	defer m.Close()
+	e.SetMultiDB(m)
+	if r := e.Exec(NewUserCommand("John", "john@test.com", "a password")); r.HasError {
+		t.Fatalf("NewUser command failed: %s", r.Str)
+	}
+	if r := e.Exec(AuthUserCommand("John", "a password")); !r.HasError {
+		t.Errorf("Auth command of a user succeeded before SetUserAuth")
+	}
+	e.SetUserAuth(true)
+	if r := e.Exec(AuthUserCommand("John", "wrong password")); !r.HasError || r.Int != ErrAuthFailed {
+		t.Errorf("Auth command with a wrong password returned %v, expected ErrAuthFailed", r)
+	}
+	if r := e.Exec(AuthUserCommand("John", "a password")); r.HasError {
+		t.Errorf("Auth command of a user failed: %s", r.Str)
+	}
+}
+
+func TestAllowedDirs(t *testing.T) {
+	allowed, err := ioutil.TempDir("", "minidb-allowed-testing-*")
+	if err != nil {
+		t.Fatalf("could not create temporary directory for testing")
+	}
+	defer os.RemoveAll(allowed)
+	other, err := ioutil.TempDir("", "minidb-other-testing-*")
+	if err != nil {
+		t.Fatalf("could not create temporary directory for testing")
+	}
+	defer os.RemoveAll(other)
+	e := NewExecutor()
+	defer e.CloseAllDBs()
+	if err := e.SetAllowedDirs(filepath.Join(allowed, "missing")); err == nil {
+		t.Errorf("SetAllowedDirs accepted a directory that does not exist")
+	}
+	if err := e.SetAllowedDirs(allowed); err != nil {
+		t.Fatalf("SetAllowedDirs failed: %s", err)
+	}
+	inside := filepath.Join(allowed, "inside.sqlite")
+	if r := e.Exec(OpenCommand("sqlite3", inside)); r.HasError {
+		t.Errorf("Open command in an allowed directory failed: %s", r.Str)
+	}
+	if err := os.Symlink(other, filepath.Join(allowed, "link")); err != nil {
+		t.Fatalf("could not create symbolic link: %s", err)
+	}
+	for _, name := range []string{filepath.Join(other, "outside.sqlite"), filepath.Join(allowed, "..", "outside.sqlite"),
+		filepath.Join(allowed, "link", "outside.sqlite"), "file:" + inside, ":memory:"} {
+		r := e.Exec(OpenCommand("sqlite3", name))
+		if !r.HasError || r.Int != ErrNotPermitted || !errors.Is(r.Err(), ErrPathNotAllowed) {
+			t.Errorf("Open command of %s returned %v, expected ErrNotPermitted", name, r)
+		}
+	}
+
+	// backups may only be written to the allowed directories
+	if r := e.Exec(BackupCommand(CommandDB(inside), filepath.Join(allowed, "backup.sqlite"))); r.HasError {
+		t.Errorf("Backup command to an allowed directory failed: %s", r.Str)
+	}
+	for _, name := range []string{filepath.Join(other, "backup.sqlite"), filepath.Join(allowed, "..", "backup.sqlite"),
+		filepath.Join(allowed, "link", "backup.sqlite"), "file:" + filepath.Join(allowed, "uri.sqlite")} {
+		r := e.Exec(BackupCommand(CommandDB(inside), name))
+		if !r.HasError || r.Int != ErrNotPermitted || !errors.Is(r.Err(), ErrPathNotAllowed) {
+			t.Errorf("Backup command to %s returned %v, expected ErrNotPermitted", name, r)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(other, "backup.sqlite")); err == nil {
+		t.Errorf("Backup command wrote a file outside the allowed directories")
+	}
+
+	e.SetAllowCreate(false)
+	if r := e.Exec(OpenCommand("sqlite3", filepath.Join(allowed, "new.sqlite"))); !r.HasError || r.Int != ErrNotPermitted {
+		t.Errorf("Open command created a new database although SetAllowCreate(false), returned %v", r)
+	}
+	if r := e.Exec(OpenCommand("sqlite3", inside)); r.HasError {
+		t.Errorf("Open command of an existing database failed after SetAllowCreate(false): %s", r.Str)
+	}
+	if err := e.SetAllowedDirs(); err != nil {
+		t.Fatalf("SetAllowedDirs without directories failed: %s", err)
+	}
+	if r := e.Exec(OpenCommand("sqlite3", filepath.Join(other, "new.sqlite"))); !r.HasError {
+		t.Errorf("Open command created a new database without allowed directories although SetAllowCreate(false)")
+	}
+}
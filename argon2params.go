@@ -0,0 +1,95 @@
+package minidb
+
+// ------------------------------------------------------------------------------
+// Argon2 parameters of the users of multiuser databases
+// ------------------------------------------------------------------------------
+
+// upgrade returns parameters that are at least as strong as both the parameters and the
+// current ones, keeping the external salt length, which is chosen by the caller.
+func (p *Params) upgrade(current *Params) *Params {
+	up := *p
+	if current.Argon2Memory > up.Argon2Memory {
+		up.Argon2Memory = current.Argon2Memory
+	}
+	if current.Argon2Iterations > up.Argon2Iterations {
+		up.Argon2Iterations = current.Argon2Iterations
+	}
+	if current.Argon2Parallelism > up.Argon2Parallelism {
+		up.Argon2Parallelism = current.Argon2Parallelism
+	}
+	if current.KeyLength > up.KeyLength {
+		up.KeyLength = current.KeyLength
+	}
+	if current.InternalSaltLength > up.InternalSaltLength {
+		up.InternalSaltLength = current.InternalSaltLength
+	}
+	return &up
+}
+
+// withParams returns the salted key with other parameters.
+func (key *saltedKey) withParams(p *Params) *saltedKey {
+	return &saltedKey{pwd: key.pwd, sel: key.sel, p: p}
+}
+
+// storeUserParams stores the parameters with which the internal key of the user with the ID
+// has been derived in the transaction.
+func storeUserParams(tx *Tx, id Item, p *Params) (ErrCode, error) {
+	values := map[string][]Value{
+		"User":               []Value{NewInt(int64(id))},
+		"Argon2Memory":       []Value{NewInt(int64(p.Argon2Memory))},
+		"Argon2Iterations":   []Value{NewInt(int64(p.Argon2Iterations))},
+		"Argon2Parallelism":  []Value{NewInt(int64(p.Argon2Parallelism))},
+		"KeyLength":          []Value{NewInt(int64(p.KeyLength))},
+		"InternalSaltLength": []Value{NewInt(int64(p.InternalSaltLength))},
+		"ExternalSaltLength": []Value{NewInt(int64(p.ExternalSaltLength))}}
+	var item int64
+	err := tx.tx.QueryRow(`SELECT Id FROM UserParams WHERE User=?;`, int64(id)).Scan(&item)
+	if err == nil {
+		err = tx.SetItem("UserParams", Item(item), values)
+	} else {
+		row := make([]FieldValue, 0, len(values))
+		for field, v := range values {
+			row = append(row, FieldValue{Field: field, Values: v})
+		}
+		_, err = tx.NewItems("UserParams", [][]FieldValue{row})
+	}
+	if err != nil {
+		return ErrDBFail, Fail(`could not store the key parameters: %s`, err)
+	}
+	return OK, nil
+}
+
+// userParams returns the parameters with which the internal key of the user with the ID has
+// been derived, or nil for users created before they were stored.
+func (m *MultiDB) userParams(id Item) *Params {
+	var p Params
+	err := m.system.reader.QueryRow(`SELECT Argon2Memory,Argon2Iterations,Argon2Parallelism,KeyLength,
+InternalSaltLength,ExternalSaltLength FROM UserParams WHERE User=?;`, int64(id)).Scan(&p.Argon2Memory,
+		&p.Argon2Iterations, &p.Argon2Parallelism, &p.KeyLength, &p.InternalSaltLength, &p.ExternalSaltLength)
+	if err != nil {
+		return nil
+	}
+	return &p
+}
+
+// rehashUser derives the internal key of the user with the ID anew from the salted key with its
+// parameters and wraps the data key of the user anew, if there is one.
+func (m *MultiDB) rehashUser(id Item, key *saltedKey, dataKey []byte) (ErrCode, error) {
+	tx, err := m.Begin()
+	if err != nil {
+		return ErrTransactionFail, err
+	}
+	defer tx.Rollback()
+	if reply, err := setUserKey(tx, id, key); err != nil {
+		return reply, err
+	}
+	if dataKey != nil {
+		if reply, err := storeDataKey(tx, id, key, dataKey); err != nil {
+			return reply, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return ErrTransactionFail, Fail(`multiuser database error: %s`, err)
+	}
+	return OK, nil
+}
@@ -0,0 +1,327 @@
+package minidb
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ------------------------------------------------------------------------------
+// Audit log
+// ------------------------------------------------------------------------------
+
+// AuditOp is the kind of operation recorded in the audit log.
+type AuditOp string
+
+// The operations recorded in the audit log. The Old and New values of an entry depend on the operation.
+const (
+	// AuditNewItem is recorded for an item created by NewItem, UseItem, NewItems or CloneItem.
+	AuditNewItem AuditOp = "new"
+	// AuditSet is recorded for every field set by Set and SetItem, with the old and the new values.
+	AuditSet AuditOp = "set"
+	// AuditRemoveItem is recorded for every item removed by RemoveItem and RemoveItems. It is
+	// followed by an entry with the old values of each field of the item that was not empty.
+	AuditRemoveItem AuditOp = "remove"
+	// AuditLink is recorded by Link with the relation as field and the linked table and item as new values.
+	AuditLink AuditOp = "link"
+	// AuditUnlink is recorded by Unlink with the relation as field and the linked table and item as old values.
+	AuditUnlink AuditOp = "unlink"
+	// AuditAddTable is recorded by AddTable with the names and types of the fields as new values.
+	AuditAddTable AuditOp = "addtable"
+	// AuditRenameTable is recorded by RenameTable for the old table with the new name as new value.
+	AuditRenameTable AuditOp = "renametable"
+	// AuditDropTable is recorded by DropTable.
+	AuditDropTable AuditOp = "droptable"
+	// AuditAddField is recorded when a field is added, with its type as new value.
+	AuditAddField AuditOp = "addfield"
+	// AuditChangeFieldType is recorded by ChangeFieldType with the old and new type.
+	AuditChangeFieldType AuditOp = "changetype"
+	// AuditFieldAccess is recorded by SetFieldAccess with the old and new access policy.
+	AuditFieldAccess AuditOp = "fieldaccess"
+	// AuditSetKV is recorded by the setters of the key-value store. The table is the internal
+	// table of the store, such as _KVINT, and the item is the key. For the stores with string
+	// keys, such as _KVSINT, the field is the key and the item is 0. For buckets, such as
+	// _KVBINT, the field is the name of the bucket.
+	AuditSetKV AuditOp = "kvset"
+	// AuditDeleteKV is recorded by the delete methods of the key-value store like AuditSetKV.
+	AuditDeleteKV AuditOp = "kvdelete"
+	// AuditPrune is recorded by PruneAudit with the time before which entries were removed
+	// and their number as new values.
+	AuditPrune AuditOp = "prune"
+)
+
+// AuditEntry is an operation recorded in the audit log. The time is the time of the operation,
+// not of the commit of its transaction.
+type AuditEntry struct {
+	Time  time.Time `json:"time"`
+	User  string    `json:"user"`
+	Op    AuditOp   `json:"op"`
+	Table string    `json:"table"`
+	Item  Item      `json:"item"`
+	Field string    `json:"field"`
+	Old   []Value   `json:"old"`
+	New   []Value   `json:"new"`
+}
+
+// AuditFilter selects entries of the audit log. Empty strings, a zero item and zero times match all entries.
+// Since is inclusive, Until is exclusive, and a Limit of 0 or less returns all matching entries.
+type AuditFilter struct {
+	Table string
+	Item  Item
+	Field string
+	User  string
+	Op    AuditOp
+	Since time.Time
+	Until time.Time
+	Limit int64
+}
+
+// ErrAuditDisabled is returned by the audit log methods if the audit log has not been enabled.
+var ErrAuditDisabled error = &SubsystemDisabledError{Subsystem: "audit log"}
+
+// createAuditTable creates the internal table of the audit log.
+func (tx *Tx) createAuditTable() error {
+	_, err := tx.tx.Exec(`CREATE TABLE IF NOT EXISTS _AUDIT (Id INTEGER PRIMARY KEY,
+	Time INTEGER NOT NULL,
+	User TEXT NOT NULL,
+	Op TEXT NOT NULL,
+	TableName TEXT NOT NULL,
+	Item INTEGER NOT NULL,
+	Field TEXT NOT NULL,
+	OldVals TEXT,
+	NewVals TEXT)`)
+	if err != nil {
+		return err
+	}
+	_, err = tx.tx.Exec(`CREATE INDEX IF NOT EXISTS _AUDITIDX ON _AUDIT (TableName, Item, Field, Time)`)
+	if err != nil {
+		return err
+	}
+	_, err = tx.tx.Exec(`CREATE INDEX IF NOT EXISTS _AUDITTIMEIDX ON _AUDIT (Time)`)
+	return err
+}
+
+// detectAudit turns on the audit log if the database has an audit table. Once the audit log
+// has been enabled for a database, it stays enabled.
+func (db *MDB) detectAudit(q querier) error {
+	var n int
+	err := q.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE type='table' AND name='_AUDIT';`).Scan(&n)
+	if err != nil {
+		return err
+	}
+	db.audit = n > 0
+	return nil
+}
+
+// AuditEnabled returns true if mutating operations are recorded in the audit log.
+func (db *MDB) AuditEnabled() bool {
+	return db.audit
+}
+
+// SetAuditUser sets the user recorded in the audit log for operations of the database and of
+// transactions begun afterwards. It should be called before the database is used concurrently.
+func (db *MDB) SetAuditUser(user string) {
+	db.auditUser = user
+}
+
+// SetAuditUser sets the user recorded in the audit log for the operations of the transaction
+// and of transactions nested in it that are begun afterwards.
+func (tx *Tx) SetAuditUser(user string) {
+	tx.auditUser = user
+}
+
+// encodeAuditValues encodes values for the audit log. Nil is stored as NULL.
+func encodeAuditValues(data []Value) (interface{}, error) {
+	if data == nil {
+		return nil, nil
+	}
+	encoded, err := json.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+	return string(encoded), nil
+}
+
+// recordAudit adds entries to the audit log if it is enabled. The time and user of the
+// entries are set by this method.
+func (db *MDB) recordAudit(e execer, user string, entries ...AuditEntry) error {
+	if !db.audit {
+		return nil
+	}
+	now := time.Now().UnixNano()
+	for _, entry := range entries {
+		oldVals, err := encodeAuditValues(entry.Old)
+		if err != nil {
+			return Fail("cannot record %s of %s %d in audit log: %s", entry.Op, entry.Table, entry.Item, err)
+		}
+		newVals, err := encodeAuditValues(entry.New)
+		if err != nil {
+			return Fail("cannot record %s of %s %d in audit log: %s", entry.Op, entry.Table, entry.Item, err)
+		}
+		_, err = e.Exec(`INSERT INTO _AUDIT (Time,User,Op,TableName,Item,Field,OldVals,NewVals) VALUES (?,?,?,?,?,?,?,?);`,
+			now, user, string(entry.Op), entry.Table, entry.Item, entry.Field, oldVals, newVals)
+		if err != nil {
+			return Fail("cannot record %s of %s %d in audit log: %s", entry.Op, entry.Table, entry.Item, err)
+		}
+	}
+	return nil
+}
+
+// audit adds entries to the audit log in the transaction.
+func (tx *Tx) audit(entries ...AuditEntry) error {
+	return tx.mdb.recordAudit(tx.tx, tx.auditUser, entries...)
+}
+
+// auditRemoved records the removal of the items together with the old values of their fields.
+func (tx *Tx) auditRemoved(table string, items []Item) error {
+	view := tx.View()
+	for _, item := range items {
+		values, err := view.GetItem(table, item)
+		if err != nil {
+			return err
+		}
+		names := make([]string, 0, len(values))
+		for name := range values {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		entries := []AuditEntry{{Op: AuditRemoveItem, Table: table, Item: item}}
+		for _, name := range names {
+			if len(values[name]) == 0 || (len(values[name]) == 1 && values[name][0].IsNull()) {
+				continue
+			}
+			entries = append(entries, AuditEntry{Op: AuditRemoveItem, Table: table, Item: item, Field: name, Old: values[name]})
+		}
+		if err := tx.audit(entries...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// kvValue returns the value stored for the key in the store of the key-value store, or nil if there is none.
+func (tx *Tx) kvValue(store string, key interface{}) []Value {
+	var raw interface{}
+	if err := tx.tx.QueryRow(`SELECT Value FROM `+store+` WHERE Id=?;`, key).Scan(&raw); err != nil {
+		return nil
+	}
+	v, err := kvRawToValue(store, raw)
+	if err != nil {
+		return nil
+	}
+	return []Value{v}
+}
+
+// kvStoreTypes are the value types of the internal tables of the key-value store.
+var kvStoreTypes = map[string]FieldType{"_KVINT": DBInt, "_KVSTR": DBString, "_KVBLOB": DBBlob, "_KVDATE": DBDate,
+	"_KVSINT": DBInt, "_KVSSTR": DBString, "_KVSBLOB": DBBlob, "_KVSDATE": DBDate,
+	"_KVBINT": DBInt, "_KVBSTR": DBString, "_KVBBLOB": DBBlob, "_KVBDATE": DBDate,
+	"_KVFLOAT": DBString}
+
+// auditKV records a change of the key-value store. It must be called before the change is made.
+// The key is an int64 or a string, depending on the store.
+func (tx *Tx) auditKV(op AuditOp, store string, key interface{}, data []Value) error {
+	if !tx.mdb.audit {
+		return nil
+	}
+	old := tx.kvValue(store, key)
+	if op == AuditDeleteKV && old == nil {
+		return nil
+	}
+	entry := AuditEntry{Op: op, Table: store, Old: old, New: data}
+	switch k := key.(type) {
+	case int64:
+		entry.Item = Item(k)
+	case string:
+		entry.Field = k
+	}
+	return tx.audit(entry)
+}
+
+// AuditLog returns the entries of the audit log that match the filter, oldest first.
+func (db *MDB) AuditLog(filter AuditFilter) ([]AuditEntry, error) {
+	if !db.audit {
+		return nil, ErrAuditDisabled
+	}
+	conditions := make([]string, 0)
+	args := make([]interface{}, 0)
+	for _, c := range []struct {
+		column string
+		value  string
+	}{{"TableName", filter.Table}, {"Field", filter.Field}, {"User", filter.User}, {"Op", string(filter.Op)}} {
+		if c.value != "" {
+			conditions = append(conditions, c.column+"=?")
+			args = append(args, c.value)
+		}
+	}
+	if filter.Item != 0 {
+		conditions = append(conditions, "Item=?")
+		args = append(args, filter.Item)
+	}
+	if !filter.Since.IsZero() {
+		conditions = append(conditions, "Time>=?")
+		args = append(args, filter.Since.UnixNano())
+	}
+	if !filter.Until.IsZero() {
+		conditions = append(conditions, "Time<?")
+		args = append(args, filter.Until.UnixNano())
+	}
+	toExec := `SELECT Time,User,Op,TableName,Item,Field,OldVals,NewVals FROM _AUDIT`
+	if len(conditions) > 0 {
+		toExec += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	toExec += " ORDER BY Time, Id"
+	if filter.Limit > 0 {
+		toExec += fmt.Sprintf(" LIMIT %d", filter.Limit)
+	}
+	rows, err := db.reader.Query(toExec+";", args...)
+	if err != nil {
+		return nil, Fail("cannot read audit log: %s", err)
+	}
+	defer rows.Close()
+	result := make([]AuditEntry, 0)
+	for rows.Next() {
+		var entry AuditEntry
+		var t int64
+		var op string
+		var oldVals, newVals sql.NullString
+		if err := rows.Scan(&t, &entry.User, &op, &entry.Table, &entry.Item, &entry.Field, &oldVals, &newVals); err != nil {
+			return nil, Fail("cannot read audit log: %s", err)
+		}
+		entry.Time = time.Unix(0, t)
+		entry.Op = AuditOp(op)
+		for _, v := range []struct {
+			s    sql.NullString
+			dest *[]Value
+		}{{oldVals, &entry.Old}, {newVals, &entry.New}} {
+			if v.s.Valid {
+				if err := json.Unmarshal([]byte(v.s.String), v.dest); err != nil {
+					return nil, Fail("corrupt audit log entry: %s", err)
+				}
+			}
+		}
+		result = append(result, entry)
+	}
+	return result, rows.Err()
+}
+
+// PruneAudit removes all entries older than the given time from the audit log and returns the
+// number of entries removed. The pruning itself is recorded in the audit log.
+func (tx *Tx) PruneAudit(before time.Time) (int64, error) {
+	if !tx.mdb.audit {
+		return 0, ErrAuditDisabled
+	}
+	result, err := tx.tx.Exec(`DELETE FROM _AUDIT WHERE Time<?;`, before.UnixNano())
+	if err != nil {
+		return 0, Fail("cannot prune audit log: %s", err)
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	err = tx.audit(AuditEntry{Op: AuditPrune, Table: "_AUDIT", New: []Value{NewDate(before), NewInt(n)}})
+	return n, err
+}
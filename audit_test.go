@@ -0,0 +1,108 @@
+package minidb
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestAudit(t *testing.T) {
+	tmp, _ := ioutil.TempFile("", "minidb-audit-testing-*")
+	defer os.Remove(tmp.Name())
+	plain, err := Open("sqlite3", tmp.Name())
+	if err != nil {
+		t.Errorf("Open() failed: %s", err)
+	}
+	if plain.AuditEnabled() {
+		t.Errorf("AuditEnabled() should be false without the Audit option")
+	}
+	if _, err := plain.AuditLog(AuditFilter{}); err != ErrAuditDisabled {
+		t.Errorf("AuditLog() should fail with ErrAuditDisabled, returned %v", err)
+	}
+	plain.Close()
+
+	db, err := OpenWithOptions("sqlite3", tmp.Name(), &Options{Audit: true})
+	if err != nil {
+		t.Errorf("OpenWithOptions() failed: %s", err)
+	}
+	if !db.AuditEnabled() {
+		t.Errorf("AuditEnabled() should be true with the Audit option")
+	}
+	db.SetAuditUser("admin")
+	if err := db.AddTable("Person", []Field{Field{Name: "Name", Sort: DBString}, Field{Name: "Tags", Sort: DBStringList}}); err != nil {
+		t.Errorf("AddTable() failed: %s", err)
+	}
+	item, _ := db.NewItem("Person")
+	tx, _ := db.Begin()
+	tx.SetAuditUser("john")
+	tx.Set("Person", item, "Name", []Value{NewString("John")})
+	tx.SetItem("Person", item, map[string][]Value{"Name": []Value{NewString("Jack")}, "Tags": []Value{NewString("a")}})
+	tx.Link("Person", item, "Person", item, "self")
+	tx.SetInt(7, 1)
+	tx.SetInt(7, 2)
+	tx.DeleteStr(7)
+	tx.Commit()
+
+	entries, err := db.AuditLog(AuditFilter{Table: "Person", Item: item, Field: "Name"})
+	if err != nil || len(entries) != 2 {
+		t.Errorf("AuditLog() returned %v, %v", entries, err)
+	} else {
+		if entries[0].User != "john" || entries[0].Op != AuditSet || len(entries[0].Old) != 1 || !entries[0].Old[0].IsNull() ||
+			entries[0].New[0].String() != "John" {
+			t.Errorf("wrong audit entry for Set(): %+v", entries[0])
+		}
+		if entries[1].Old[0].String() != "John" || entries[1].New[0].String() != "Jack" {
+			t.Errorf("wrong audit entry for SetItem(): %+v", entries[1])
+		}
+	}
+	if entries, _ := db.AuditLog(AuditFilter{User: "admin"}); len(entries) != 2 || entries[0].Op != AuditAddTable ||
+		len(entries[0].New) != 2 || entries[1].Op != AuditNewItem || entries[1].Item != item {
+		t.Errorf("AuditLog() for user admin returned %+v", entries)
+	}
+	if entries, _ := db.AuditLog(AuditFilter{Op: AuditLink}); len(entries) != 1 || entries[0].Field != "self" {
+		t.Errorf("AuditLog() for links returned %+v", entries)
+	}
+	if entries, _ := db.AuditLog(AuditFilter{Table: "_KVINT"}); len(entries) != 2 || entries[1].Old[0].Int() != 1 ||
+		entries[1].New[0].Int() != 2 {
+		t.Errorf("AuditLog() for the key-value store returned %+v", entries)
+	}
+	if entries, _ := db.AuditLog(AuditFilter{Op: AuditDeleteKV}); len(entries) != 0 {
+		t.Errorf("deleting a missing key should not be recorded: %+v", entries)
+	}
+	if entries, _ := db.AuditLog(AuditFilter{Limit: 3}); len(entries) != 3 {
+		t.Errorf("AuditLog() with limit returned %d entries", len(entries))
+	}
+
+	tx, _ = db.Begin()
+	tx.RemoveItem("Person", item)
+	tx.Rollback()
+	if entries, _ := db.AuditLog(AuditFilter{Op: AuditRemoveItem}); len(entries) != 0 {
+		t.Errorf("a rolled back removal was recorded: %+v", entries)
+	}
+	tx, _ = db.Begin()
+	tx.RemoveItems("Person", []Item{item, 999})
+	tx.Commit()
+	entries, _ = db.AuditLog(AuditFilter{Op: AuditRemoveItem})
+	if len(entries) != 3 || entries[0].Field != "" || entries[1].Field != "Name" || entries[1].Old[0].String() != "Jack" ||
+		entries[2].Field != "Tags" {
+		t.Errorf("AuditLog() for removed items returned %+v", entries)
+	}
+	db.Close()
+
+	// the audit log stays enabled
+	db, _ = Open("sqlite3", tmp.Name())
+	defer db.Close()
+	if !db.AuditEnabled() {
+		t.Errorf("AuditEnabled() should be true for a database with an audit log")
+	}
+	tx, _ = db.Begin()
+	n, err := tx.PruneAudit(time.Now().Add(time.Hour))
+	tx.Commit()
+	if err != nil || n == 0 {
+		t.Errorf("PruneAudit() returned %d, %v", n, err)
+	}
+	if entries, _ := db.AuditLog(AuditFilter{}); len(entries) != 1 || entries[0].Op != AuditPrune || entries[0].New[1].Int() != n {
+		t.Errorf("AuditLog() after pruning returned %+v", entries)
+	}
+}
@@ -0,0 +1,134 @@
+package minidb
+
+import "crypto/subtle"
+
+// ------------------------------------------------------------------------------
+// Authentication of the connections to a server
+// ------------------------------------------------------------------------------
+
+// SetAuthTokens sets the API tokens that authenticate connections with an AuthCommand. Once
+// tokens are given, or users are allowed to authenticate with SetUserAuth, a server refuses all
+// commands but Ping, Hello, and Auth on connections that have not been authenticated, see
+// CheckAuth. Calling it without tokens removes the tokens.
+func (e *Executor) SetAuthTokens(tokens ...string) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.authTokens = append([]string{}, tokens...)
+}
+
+// SetUserAuth allows the users of the multiuser database of the executor to authenticate
+// connections with their name and password, see AuthUserCommand and SetMultiDB.
+func (e *Executor) SetUserAuth(on bool) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.authUsers = on
+}
+
+// AuthRequired returns true if connections to a server with the executor must be authenticated.
+func (e *Executor) AuthRequired() bool {
+	e.mutex.RLock()
+	defer e.mutex.RUnlock()
+	return len(e.authTokens) > 0 || e.authUsers
+}
+
+// RequiresAuth returns true if commands of the type are refused on connections that have not been
+// authenticated. Only Ping, Hello, and Auth are not.
+func RequiresAuth(id CommandID) bool {
+	return id != CmdPing && id != CmdHello && id != CmdAuth
+}
+
+// CheckAuth returns nil if the command may be executed on a connection, and otherwise the result
+// of the refused command, which fails with ErrNotAuthenticated and whose Err wraps
+// ErrUnauthenticated. The executor does not know the connections of a server, so the server
+// tells whether the connection has been authenticated by a successful Auth command.
+func (e *Executor) CheckAuth(cmd *Command, authenticated bool) *Result {
+	if authenticated || !RequiresAuth(cmd.ID) || !e.AuthRequired() {
+		return nil
+	}
+	r := Result{HasError: true, Int: ErrNotAuthenticated, Version: CommandVersion}
+	r.setError(Fail("exec failed: command %d needs an authenticated connection: %w", cmd.ID, ErrUnauthenticated))
+	return &r
+}
+
+// auth executes an Auth command. It always succeeds if authentication is not required, so that
+// clients may authenticate with servers that do not ask for it.
+func (e *Executor) auth(cmd *Command) *Result {
+	var r Result
+	if !e.AuthRequired() {
+		return &r
+	}
+	e.mutex.RLock()
+	tokens := e.authTokens
+	users := e.authUsers
+	e.mutex.RUnlock()
+	ok := false
+	var roles []Role
+	switch len(cmd.StrArgs) {
+	case 1:
+		for _, token := range tokens {
+			if subtle.ConstantTimeCompare([]byte(cmd.StrArgs[0]), []byte(token)) == 1 {
+				ok = true
+			}
+		}
+	case 2:
+		m := e.getMultiDB()
+		if users && m != nil {
+			if key, _, err := multiUserKey(m, cmd.StrArgs[0], cmd.StrArgs[1]); err == nil {
+				var user *User
+				if user, _, err = m.Authenticate(cmd.StrArgs[0], key); err == nil {
+					roles, _, err = m.Roles(user)
+					ok = err == nil
+				}
+			}
+		}
+	}
+	if !ok {
+		// the reason is not revealed to the client
+		r.HasError = true
+		r.Int = ErrAuthFailed
+		r.Str = Fail("exec failed: authentication failed").Error()
+		return &r
+	}
+	if roles != nil {
+		r.Bool = true
+		for _, role := range roles {
+			r.Strings = append(r.Strings, string(role))
+		}
+	}
+	return &r
+}
+
+// AuthRoles returns the roles to which a successful Auth command restricts the client, which are
+// the roles of the user for an AuthUserCommand, or nil for an AuthCommand with a token, which does
+// not restrict the client. Servers execute the commands of an authenticated connection with these
+// roles, see Executor.ExecWithRoles.
+func AuthRoles(r *Result) []Role {
+	if r.HasError || !r.Bool {
+		return nil
+	}
+	roles := make([]Role, 0, len(r.Strings))
+	for _, role := range r.Strings {
+		roles = append(roles, Role(role))
+	}
+	return roles
+}
+
+// AuthCommand returns a pointer to a command structure that authenticates the connection with an
+// API token, see Executor.SetAuthTokens.
+func AuthCommand(token string) *Command {
+	return &Command{
+		ID:      CmdAuth,
+		StrArgs: []string{token},
+	}
+}
+
+// AuthUserCommand returns a pointer to a command structure that authenticates the connection with
+// the name and password of a user of the multiuser database, see Executor.SetUserAuth. The
+// connection is then restricted to the roles of the user, which the result lists in Strings, see
+// AuthRoles.
+func AuthUserCommand(username, password string) *Command {
+	return &Command{
+		ID:      CmdAuth,
+		StrArgs: []string{username, password},
+	}
+}
@@ -0,0 +1,185 @@
+package minidb
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ------------------------------------------------------------------------------
+// Scheduled backups
+// ------------------------------------------------------------------------------
+
+// backupTimeFormat is the format of the time stamps in the names of scheduled backups,
+// which sort in the order of their times.
+const backupTimeFormat = "20060102-150405.000"
+
+// BackupSchedule describes when and where a BackupManager writes backups.
+type BackupSchedule struct {
+	// Dir is the directory of the backups, which is created if it does not exist.
+	Dir string
+	// Interval is the time between two backups. It must be positive.
+	Interval time.Duration
+	// Keep is the number of backups that are kept. Older backups are removed after
+	// each backup. Zero keeps all backups.
+	Keep int
+}
+
+// validate returns an error if the schedule cannot be used and creates its directory.
+func (s *BackupSchedule) validate() error {
+	if s.Dir == "" {
+		return Fail("no backup directory given")
+	}
+	if s.Interval <= 0 {
+		return Fail("invalid backup interval %s", s.Interval)
+	}
+	if s.Keep < 0 {
+		return Fail("invalid number of backups to keep %d", s.Keep)
+	}
+	if err := os.MkdirAll(s.Dir, 0755); err != nil {
+		return Fail("cannot create backup directory: %s", err)
+	}
+	return nil
+}
+
+// BackupManager writes full backups of a database at regular intervals with MDB.Backup, so
+// the backups are consistent even while the database is written to. Each backup is a file
+// in the directory of the schedule whose name consists of the name of the database file and
+// the time of the backup in UTC, e.g. db-20240131-235959.000.sqlite, and can be opened like
+// any database. The backups of databases whose files have the same name must be written to
+// different directories.
+type BackupManager struct {
+	db       *MDB
+	schedule BackupSchedule
+	prefix   string
+	mutex    sync.Mutex
+	lastErr  error
+	stop     chan struct{}
+	done     chan struct{}
+	stopOnce sync.Once
+}
+
+// NewBackupManager starts writing backups of the database according to the schedule. The
+// first backup is written after the interval of the schedule has passed. The manager must be
+// stopped with Stop before the database is closed.
+func NewBackupManager(db *MDB, schedule BackupSchedule) (*BackupManager, error) {
+	if err := schedule.validate(); err != nil {
+		return nil, err
+	}
+	prefix := "memory"
+	if !db.InMemory() {
+		prefix = filepath.Base(databasePath(db.location))
+		prefix = strings.TrimSuffix(prefix, filepath.Ext(prefix))
+	}
+	m := &BackupManager{
+		db:       db,
+		schedule: schedule,
+		prefix:   prefix,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	go m.run()
+	return m, nil
+}
+
+func (m *BackupManager) run() {
+	defer close(m.done)
+	ticker := time.NewTicker(m.schedule.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-m.stop:
+			return
+		case <-ticker.C:
+			m.BackupNow()
+		}
+	}
+}
+
+// Stop stops writing backups and waits until a backup in progress is finished.
+func (m *BackupManager) Stop() {
+	m.stopOnce.Do(func() { close(m.stop) })
+	<-m.done
+}
+
+// BackupNow writes a backup immediately, removes backups that are no longer kept, and
+// returns the path of the new backup.
+func (m *BackupManager) BackupNow() (string, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	path := filepath.Join(m.schedule.Dir,
+		m.prefix+"-"+time.Now().UTC().Format(backupTimeFormat)+".sqlite")
+	err := m.db.Backup(path)
+	if err == nil {
+		err = m.prune()
+	}
+	m.lastErr = err
+	if err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+// LastError returns the error of the last backup, or nil if it succeeded or no backup has
+// been written yet. Scheduled backups have no caller to return their errors to.
+func (m *BackupManager) LastError() error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.lastErr
+}
+
+// Backups returns the paths of the backups of the database in the directory of the schedule,
+// the oldest first.
+func (m *BackupManager) Backups() ([]string, error) {
+	paths, err := filepath.Glob(filepath.Join(m.schedule.Dir, m.prefix+"-*.sqlite"))
+	if err != nil {
+		return nil, err
+	}
+	backups := make([]string, 0, len(paths))
+	for _, path := range paths {
+		stamp := strings.TrimSuffix(strings.TrimPrefix(filepath.Base(path), m.prefix+"-"), ".sqlite")
+		if _, err := time.Parse(backupTimeFormat, stamp); err == nil {
+			backups = append(backups, path)
+		}
+	}
+	sort.Strings(backups)
+	return backups, nil
+}
+
+// prune removes the oldest backups that exceed the number of backups to keep.
+func (m *BackupManager) prune() error {
+	if m.schedule.Keep == 0 {
+		return nil
+	}
+	backups, err := m.Backups()
+	if err != nil {
+		return err
+	}
+	for len(backups) > m.schedule.Keep {
+		if err := os.Remove(backups[0]); err != nil {
+			return Fail("cannot remove old backup: %s", err)
+		}
+		backups = backups[1:]
+	}
+	return nil
+}
+
+// SetBackupSchedule makes the executor write backups of every database it opens from now on
+// according to the schedule, see BackupManager. The backups of a database stop when the
+// executor closes it. A nil schedule turns scheduled backups off for databases opened later.
+func (e *Executor) SetBackupSchedule(schedule *BackupSchedule) error {
+	if schedule != nil {
+		if err := schedule.validate(); err != nil {
+			return err
+		}
+		s := *schedule
+		schedule = &s
+	}
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.backupSchedule = schedule
+	return nil
+}
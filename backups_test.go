@@ -0,0 +1,84 @@
+package minidb
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestBackupManager(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "minidb-backups-testing-*")
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "data.sqlite")
+	db, err := Open("sqlite3", file)
+	if err != nil {
+		t.Fatalf("Open() failed: %s", err)
+	}
+	defer db.Close()
+	db.AddTable("Person", []Field{Field{Name: "Name", Sort: DBString}})
+	if _, err := NewBackupManager(db, BackupSchedule{Dir: dir}); err == nil {
+		t.Errorf("NewBackupManager() should fail without an interval")
+	}
+
+	backupDir := filepath.Join(dir, "backups")
+	m, err := NewBackupManager(db, BackupSchedule{Dir: backupDir, Interval: time.Hour, Keep: 2})
+	if err != nil {
+		t.Fatalf("NewBackupManager() failed: %s", err)
+	}
+	paths := make([]string, 0)
+	for i := 0; i < 3; i++ {
+		db.NewItem("Person")
+		path, err := m.BackupNow()
+		if err != nil {
+			t.Fatalf("BackupNow() failed: %s", err)
+		}
+		paths = append(paths, path)
+		time.Sleep(2 * time.Millisecond)
+	}
+	m.Stop()
+	backups, _ := m.Backups()
+	if len(backups) != 2 || backups[0] != paths[1] || backups[1] != paths[2] {
+		t.Errorf("Backups() returned %v, expected the last two of %v", backups, paths)
+	}
+	if filepath.Base(paths[0])[:5] != "data-" {
+		t.Errorf("backup %s is not named after the database", paths[0])
+	}
+	backup, err := OpenExisting("sqlite3", paths[2])
+	if err != nil {
+		t.Fatalf("cannot open backup: %s", err)
+	}
+	if n, _ := backup.Count("Person"); n != 3 {
+		t.Errorf("the last backup contains %d items, expected 3", n)
+	}
+	backup.Close()
+
+	// an executor writes backups of the databases it opens until it closes them
+	scheduledDir := filepath.Join(dir, "scheduled")
+	e := NewExecutor()
+	if err := e.SetBackupSchedule(&BackupSchedule{Dir: scheduledDir, Interval: 10 * time.Millisecond}); err != nil {
+		t.Fatalf("SetBackupSchedule() failed: %s", err)
+	}
+	other := filepath.Join(dir, "other.sqlite")
+	if r := e.Exec(OpenCommand("sqlite3", other)); r.HasError {
+		t.Fatalf("Open command failed: %s", r.Str)
+	}
+	m = &BackupManager{schedule: BackupSchedule{Dir: scheduledDir}, prefix: "other"}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if backups, _ := m.Backups(); len(backups) > 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("the executor has not written a backup")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if r := e.Exec(CloseCommand(CommandDB(other))); r.HasError {
+		t.Errorf("Close command failed: %s", r.Str)
+	}
+	if len(e.openDBs()) != 0 {
+		t.Errorf("the backups of a closed database have not been stopped")
+	}
+}
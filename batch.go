@@ -0,0 +1,93 @@
+package minidb
+
+// ------------------------------------------------------------------------------
+// Batch execution of commands
+// ------------------------------------------------------------------------------
+
+// Batch is a list of commands that are executed together by ExecBatch. Servers accept the JSON
+// encoding of a batch in place of that of a command and reply with the list of results, so that
+// a client can send many commands in one round trip. Atomic is passed as inTx to ExecBatch.
+type Batch struct {
+	Commands []Command `json:"batch"`
+	Atomic   bool      `json:"atomic"`
+}
+
+// txCommands are the write commands that are executed in the transaction given by their Tx field.
+// Read commands also accept a transaction, see Command.InTx.
+var txCommands = map[CommandID]bool{
+	CmdChangeFieldType: true, CmdCloneItem: true, CmdDeleteBlob: true, CmdDeleteBlobKey: true,
+	CmdDeleteDate: true, CmdDeleteDateKey: true, CmdDeleteFloat: true, CmdDeleteInt: true,
+	CmdDeleteIntKey: true, CmdDeleteStr: true, CmdDeleteStrKey: true, CmdDropTable: true,
+	CmdEnableTimestamps: true, CmdImportKV: true, CmdIndex: true, CmdLink: true, CmdReindex: true,
+	CmdRemoveItem: true, CmdRemoveItems: true, CmdRenameTable: true, CmdSet: true, CmdSetBlob: true,
+	CmdSetBlobKey: true, CmdSetDate: true, CmdSetDateKey: true, CmdSetDateStr: true,
+	CmdSetDateStrKey: true, CmdSetFieldAccess: true, CmdSetFloat: true, CmdSetIfVersion: true,
+	CmdSetInt: true, CmdSetIntKey: true, CmdSetItem: true, CmdSetMany: true, CmdSetStr: true,
+	CmdSetStrKey: true, CmdUnlink: true, CmdEnableHistory: true, CmdDisableHistory: true,
+}
+
+// ExecBatch executes the commands in order and returns their results. Execution stops at the
+// first command that fails, so the result of the failed command is the last one returned.
+//
+// If inTx is true, the commands that are not part of a transaction are executed in a
+// transaction of their database, which is begun before the first such command on the database
+// and committed after the last command of the batch, so that either all writes of the batch are
+// made or none. Read commands in the batch see the writes of the commands before them. Write
+// commands that cannot be executed in a transaction, such as AddTable and NewItem, fail with
+// ErrInvalidCommand. If a command fails, all transactions begun for the batch are rolled back,
+// and if committing fails, the result of the Commit command is returned after the results of
+// the commands.
+func (e *Executor) ExecBatch(cmds []Command, inTx bool) []Result {
+	results := make([]Result, 0, len(cmds))
+	begin := BeginCommand
+	if e.isReadOnly() {
+		begin = BeginReadCommand
+	}
+	txs := make(map[CommandDB]TxID)
+	dbs := make([]CommandDB, 0)
+	finish := func(commit bool) {
+		for _, db := range dbs {
+			if !commit {
+				e.Exec(RollbackCommand(db, txs[db]))
+				continue
+			}
+			if r := e.Exec(CommitCommand(db, txs[db])); r.HasError {
+				results = append(results, *r)
+				commit = false
+			}
+		}
+	}
+	for i := range cmds {
+		cmd := cmds[i]
+		if inTx && cmd.Tx == 0 && cmd.DB != "" {
+			if isQueuedWrite(&cmd) && !txCommands[cmd.ID] {
+				r := Result{HasError: true, Int: ErrInvalidCommand, Version: CommandVersion}
+				r.Str = Fail("exec failed: command %d cannot be executed in the transaction of a batch", cmd.ID).Error()
+				results = append(results, r)
+				finish(false)
+				return results
+			}
+			if txCommands[cmd.ID] || readCommands[cmd.ID] {
+				if _, ok := txs[cmd.DB]; !ok {
+					r := e.Exec(begin(cmd.DB))
+					if r.HasError {
+						results = append(results, *r)
+						finish(false)
+						return results
+					}
+					txs[cmd.DB] = TxID(r.Int)
+					dbs = append(dbs, cmd.DB)
+				}
+				cmd.Tx = txs[cmd.DB]
+			}
+		}
+		r := e.Exec(&cmd)
+		results = append(results, *r)
+		if r.HasError {
+			finish(false)
+			return results
+		}
+	}
+	finish(true)
+	return results
+}
@@ -0,0 +1,331 @@
+package minidb
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ------------------------------------------------------------------------------
+// External blob store, see Options.BlobDir
+// ------------------------------------------------------------------------------
+
+// defaultBlobThreshold is the size from which blobs are stored externally if
+// Options.BlobThreshold is zero.
+const defaultBlobThreshold = 16 * 1024
+
+// blobRefPrefix starts the reference stored in the database instead of an external blob. It is
+// followed by the hex encoded SHA-256 hash of the blob, which is the name of its file.
+const blobRefPrefix = "\x00minidb-blob:sha256:"
+
+// blobStore keeps the blobs of blob fields that are at least as large as the threshold in
+// files named by their hash, so that equal blobs are only stored once.
+type blobStore struct {
+	dir       string
+	threshold int
+}
+
+// newBlobStore returns the blob store of the options, or nil if blobs are stored in the database.
+func (options *Options) newBlobStore() (*blobStore, error) {
+	if options.BlobDir == "" {
+		return nil, nil
+	}
+	if options.BlobThreshold < 0 {
+		return nil, Fail("invalid blob threshold %d", options.BlobThreshold)
+	}
+	if err := os.MkdirAll(options.BlobDir, 0755); err != nil {
+		return nil, Fail("cannot create blob directory: %s", err)
+	}
+	s := &blobStore{dir: options.BlobDir, threshold: options.BlobThreshold}
+	if s.threshold == 0 {
+		s.threshold = defaultBlobThreshold
+	}
+	return s, nil
+}
+
+// isBlobRef returns true if the stored blob is a reference to an external blob.
+func isBlobRef(b []byte) bool {
+	return len(b) == len(blobRefPrefix)+2*sha256.Size && bytes.HasPrefix(b, []byte(blobRefPrefix))
+}
+
+// external returns true if the values of the field are stored externally if they are large.
+// Values of custom types are always stored in the database, since they are checked when read.
+func (s *blobStore) external(field Field) bool {
+	return s != nil && (field.Sort == DBBlob || field.Sort == DBBlobList)
+}
+
+// path returns the path of the file of the blob with the hash.
+func (s *blobStore) path(hash string) string {
+	return filepath.Join(s.dir, hash[:2], hash)
+}
+
+// store writes a blob to its file, unless it already exists, and returns its reference.
+func (s *blobStore) store(b []byte) ([]byte, error) {
+	sum := sha256.Sum256(b)
+	hash := hex.EncodeToString(sum[:])
+	path := s.path(hash)
+	if _, err := os.Stat(path); err == nil {
+		return []byte(blobRefPrefix + hash), nil
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, Fail("cannot store blob: %s", err)
+	}
+	// the blob is written to a temporary file first, so a file always has the whole blob
+	tmp, err := os.CreateTemp(filepath.Dir(path), hash+".tmp-*")
+	if err != nil {
+		return nil, Fail("cannot store blob: %s", err)
+	}
+	_, err = tmp.Write(b)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return nil, Fail("cannot store blob: %s", err)
+	}
+	return []byte(blobRefPrefix + hash), nil
+}
+
+// load reads the blob of a reference.
+func (s *blobStore) load(ref []byte) ([]byte, error) {
+	hash := string(ref[len(blobRefPrefix):])
+	b, err := os.ReadFile(s.path(hash))
+	if err != nil {
+		return nil, Fail("cannot read external blob %s: %s", hash, err)
+	}
+	return b, nil
+}
+
+// storeBlobs returns the values to write to the field, where large blobs are replaced by references
+// to their files. A blob that looks like a reference is stored externally regardless of its size,
+// so that the database never holds such a blob itself.
+func (db *MDB) storeBlobs(field Field, data []Value) ([]Value, error) {
+	if !db.blobs.external(field) {
+		return data, nil
+	}
+	stored := make([]Value, len(data))
+	for i, v := range data {
+		stored[i] = v
+		b := v.Bytes()
+		if v.Sort != DBBlob || (len(b) < db.blobs.threshold && !isBlobRef(b)) {
+			continue
+		}
+		ref, err := db.blobs.store(b)
+		if err != nil {
+			return nil, err
+		}
+		stored[i] = NewBytes(ref)
+	}
+	return stored, nil
+}
+
+// storeRowBlobs returns the rows of NewItems with large blobs replaced by references, see storeBlobs.
+func (db *MDB) storeRowBlobs(rows [][]FieldValue, descs map[string]Field) ([][]FieldValue, error) {
+	if db.blobs == nil {
+		return rows, nil
+	}
+	stored := make([][]FieldValue, len(rows))
+	for i, row := range rows {
+		stored[i] = make([]FieldValue, len(row))
+		for j, fv := range row {
+			values, err := db.storeBlobs(descs[fv.Field], fv.Values)
+			if err != nil {
+				return nil, err
+			}
+			stored[i][j] = FieldValue{Field: fv.Field, Values: values}
+		}
+	}
+	return stored, nil
+}
+
+// loadBlobs replaces the references among the values of the field read from the database by
+// the blobs they refer to.
+func (db *MDB) loadBlobs(field Field, values []Value) error {
+	if !db.blobs.external(field) {
+		return nil
+	}
+	for i := range values {
+		if values[i].Sort != DBBlob || !isBlobRef([]byte(values[i].Str)) {
+			continue
+		}
+		b, err := db.blobs.load([]byte(values[i].Str))
+		if err != nil {
+			return err
+		}
+		values[i] = NewBytes(b)
+	}
+	return nil
+}
+
+// blobColumn returns the table and column that hold the values of a field.
+func blobColumn(table string, field Field) (string, string) {
+	if isListFieldType(field.Sort) {
+		return listFieldToTableName(table, field.Name), "Owner"
+	}
+	return table, "Id"
+}
+
+// collectOrphans remembers the external blobs of the fields of the items given as SQL list,
+// whose values are about to be removed or replaced. When the transaction is committed,
+// the files of those that are no longer referenced are removed.
+func (tx *Tx) collectOrphans(table string, in string, fields []Field) error {
+	for _, field := range fields {
+		if !tx.mdb.blobs.external(field) {
+			continue
+		}
+		t, owner := blobColumn(table, field)
+		rows, err := tx.tx.Query(fmt.Sprintf(`SELECT DISTINCT "%[1]s" FROM "%[2]s" WHERE %[3]s IN %[4]s AND substr("%[1]s",1,%[5]d)=?;`,
+			field.Name, t, owner, in, len(blobRefPrefix)), []byte(blobRefPrefix))
+		if err != nil {
+			return Fail("cannot read external blobs of %s %s: %s", table, field.Name, err)
+		}
+		for rows.Next() {
+			var ref []byte
+			if err := rows.Scan(&ref); err != nil {
+				rows.Close()
+				return Fail("cannot read external blobs of %s %s: %s", table, field.Name, err)
+			}
+			if isBlobRef(ref) {
+				tx.orphans = append(tx.orphans, string(ref))
+			}
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return Fail("cannot read external blobs of %s %s: %s", table, field.Name, err)
+		}
+	}
+	return nil
+}
+
+// blobReferenced returns true if a blob field of the database or a recorded change holds the
+// reference.
+func (db *MDB) blobReferenced(ref string) (bool, error) {
+	for _, table := range db.GetTables() {
+		fields, err := db.GetFields(table)
+		if err != nil {
+			return false, err
+		}
+		for _, field := range fields {
+			if !db.blobs.external(field) {
+				continue
+			}
+			t, _ := blobColumn(table, field)
+			var found bool
+			err := db.reader.QueryRow(fmt.Sprintf(`SELECT EXISTS (SELECT 1 FROM "%s" WHERE "%s"=?);`, t, field.Name),
+				[]byte(ref)).Scan(&found)
+			if err != nil {
+				return false, Fail("cannot look up external blob: %s", err)
+			}
+			if found {
+				return true, nil
+			}
+		}
+	}
+	found, err := historyBlobReferenced(db.reader, ref)
+	if err != nil {
+		return false, Fail("cannot look up external blob: %s", err)
+	}
+	return found, nil
+}
+
+// unusedBlobs returns the paths of the files of the orphans of the transaction that are no
+// longer referenced. It is called before the outermost transaction is committed.
+func (tx *Tx) unusedBlobs() ([]string, error) {
+	view := tx.View()
+	seen := make(map[string]bool)
+	var paths []string
+	for _, ref := range tx.orphans {
+		if seen[ref] {
+			continue
+		}
+		seen[ref] = true
+		used, err := view.blobReferenced(ref)
+		if err != nil {
+			return nil, err
+		}
+		if !used {
+			paths = append(paths, tx.mdb.blobs.path(ref[len(blobRefPrefix):]))
+		}
+	}
+	tx.orphans = nil
+	return paths, nil
+}
+
+// CollectBlobs removes the files in the blob directory that are not referenced by any blob
+// field or recorded change, see Options.BlobDir, and returns their number. Files of removed items are removed
+// when the transaction that removes them is committed, but files written by transactions that
+// have been rolled back, or of tables whose field types have changed, remain until this
+// method is called. It waits for the transaction in progress like Begin.
+func (db *MDB) CollectBlobs() (int, error) {
+	if db.blobs == nil {
+		return 0, Fail("the database has no blob directory")
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	view := tx.View()
+	used := make(map[string]bool)
+	for _, table := range view.GetTables() {
+		fields, err := view.GetFields(table)
+		if err != nil {
+			return 0, err
+		}
+		for _, field := range fields {
+			if !db.blobs.external(field) {
+				continue
+			}
+			t, _ := blobColumn(table, field)
+			rows, err := tx.tx.Query(fmt.Sprintf(`SELECT DISTINCT "%[1]s" FROM "%[2]s" WHERE substr("%[1]s",1,%[3]d)=?;`,
+				field.Name, t, len(blobRefPrefix)), []byte(blobRefPrefix))
+			if err != nil {
+				return 0, Fail("cannot read external blobs of %s %s: %s", table, field.Name, err)
+			}
+			for rows.Next() {
+				var ref []byte
+				if err := rows.Scan(&ref); err == nil && isBlobRef(ref) {
+					used[string(ref[len(blobRefPrefix):])] = true
+				}
+			}
+			rows.Close()
+		}
+	}
+	if err := historyBlobs(tx.tx, used); err != nil {
+		return 0, Fail("cannot read external blobs of the history: %s", err)
+	}
+	removed := 0
+	err = filepath.Walk(db.blobs.dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		name := info.Name()
+		if i := strings.Index(name, ".tmp-"); i >= 0 {
+			name = name[:i]
+		} else if used[name] {
+			return nil
+		}
+		if len(name) != 2*sha256.Size {
+			return nil
+		}
+		if err := os.Remove(path); err != nil {
+			return err
+		}
+		removed++
+		return nil
+	})
+	if err != nil {
+		return removed, Fail("cannot remove unused blobs: %s", err)
+	}
+	return removed, nil
+}
@@ -0,0 +1,176 @@
+package minidb
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// countBlobFiles returns the number of blob files in the directory.
+func countBlobFiles(t *testing.T, dir string) int {
+	n := 0
+	filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() {
+			n++
+		}
+		return err
+	})
+	return n
+}
+
+func TestBlobStore(t *testing.T) {
+	dir := t.TempDir()
+	blobDir := filepath.Join(dir, "blobs")
+	db, err := OpenWithOptions("sqlite3", filepath.Join(dir, "blobs.sqlite"),
+		&Options{BlobDir: blobDir, BlobThreshold: 100})
+	if err != nil {
+		t.Fatalf("OpenWithOptions() failed: %s", err)
+	}
+	defer db.Close()
+	err = db.AddTable("Doc", []Field{{Name: "Data", Sort: DBBlob}, {Name: "Pages", Sort: DBBlobList}})
+	if err != nil {
+		t.Fatalf("AddTable() failed: %s", err)
+	}
+	large := bytes.Repeat([]byte("minidb"), 100)
+	other := bytes.Repeat([]byte("blob"), 100)
+	small := []byte("small")
+	a, _ := db.NewItem("Doc")
+	b, _ := db.NewItem("Doc")
+	tx, _ := db.Begin()
+	if err := tx.Set("Doc", a, "Data", []Value{NewBytes(large)}); err != nil {
+		t.Fatalf("Set() failed: %s", err)
+	}
+	if err := tx.Set("Doc", a, "Pages", []Value{NewBytes(other), NewBytes(small)}); err != nil {
+		t.Fatalf("Set() failed: %s", err)
+	}
+	if err := tx.SetItem("Doc", b, map[string][]Value{"Data": {NewBytes(large)}}); err != nil {
+		t.Fatalf("SetItem() failed: %s", err)
+	}
+	tx.Commit()
+	if n := countBlobFiles(t, blobDir); n != 2 {
+		t.Errorf("the blob directory contains %d files, expected 2", n)
+	}
+	var raw []byte
+	db.Base().QueryRow(`SELECT Data FROM Doc WHERE Id=?;`, a).Scan(&raw)
+	if !isBlobRef(raw) {
+		t.Errorf("the database should hold a reference instead of the blob")
+	}
+
+	values, err := db.Get("Doc", a, "Data")
+	if err != nil || !bytes.Equal(values[0].Bytes(), large) {
+		t.Errorf("Get() returned %v, %v", values, err)
+	}
+	values, err = db.Get("Doc", a, "Pages")
+	if err != nil || len(values) != 2 || !bytes.Equal(values[0].Bytes(), other) || !bytes.Equal(values[1].Bytes(), small) {
+		t.Errorf("Get() of the list returned %v, %v", values, err)
+	}
+	item, err := db.GetItem("Doc", b)
+	if err != nil || !bytes.Equal(item["Data"][0].Bytes(), large) {
+		t.Errorf("GetItem() returned %v, %v", item, err)
+	}
+	multi, err := db.GetMulti("Doc", []Item{a, b}, "Data")
+	if err != nil || !bytes.Equal(multi[b][0].Bytes(), large) {
+		t.Errorf("GetMulti() returned %v, %v", multi, err)
+	}
+	created, err := db.Begin()
+	if err != nil {
+		t.Fatalf("Begin() failed: %s", err)
+	}
+	if _, err := created.NewItems("Doc", [][]FieldValue{{{Field: "Pages", Values: []Value{NewBytes(large)}}}}); err != nil {
+		t.Fatalf("NewItems() failed: %s", err)
+	}
+	created.Commit()
+
+	// the blob is still used by the other items
+	tx, _ = db.Begin()
+	if err := tx.RemoveItem("Doc", a); err != nil {
+		t.Fatalf("RemoveItem() failed: %s", err)
+	}
+	tx.Commit()
+	if n := countBlobFiles(t, blobDir); n != 1 {
+		t.Errorf("RemoveItem() should remove the unused blob, found %d files", n)
+	}
+	// a rolled back transaction leaves its blob behind
+	tx, _ = db.Begin()
+	tx.Set("Doc", b, "Data", []Value{NewBytes(other)})
+	tx.Rollback()
+	if n := countBlobFiles(t, blobDir); n != 2 {
+		t.Errorf("the blob directory contains %d files, expected 2", n)
+	}
+	if n, err := db.CollectBlobs(); err != nil || n != 1 {
+		t.Errorf("CollectBlobs() returned %d, %v, expected 1", n, err)
+	}
+	tx, _ = db.Begin()
+	if err := tx.DropTable("Doc"); err != nil {
+		t.Fatalf("DropTable() failed: %s", err)
+	}
+	tx.Commit()
+	if n := countBlobFiles(t, blobDir); n != 0 {
+		t.Errorf("DropTable() should remove the blobs of the table, found %d files", n)
+	}
+}
+
+func TestBlobStoreHistory(t *testing.T) {
+	dir := t.TempDir()
+	blobDir := filepath.Join(dir, "blobs")
+	db, err := OpenWithOptions("sqlite3", filepath.Join(dir, "blobs.sqlite"),
+		&Options{BlobDir: blobDir, BlobThreshold: 100})
+	if err != nil {
+		t.Fatalf("OpenWithOptions() failed: %s", err)
+	}
+	defer db.Close()
+	if err := db.AddTable("Doc", []Field{{Name: "Data", Sort: DBBlob}}); err != nil {
+		t.Fatalf("AddTable() failed: %s", err)
+	}
+	first := bytes.Repeat([]byte("first"), 100)
+	second := bytes.Repeat([]byte("second"), 100)
+	a, _ := db.NewItem("Doc")
+	tx, _ := db.Begin()
+	tx.EnableHistory("Doc", 0)
+	if err := tx.Set("Doc", a, "Data", []Value{NewBytes(first)}); err != nil {
+		t.Fatalf("Set() failed: %s", err)
+	}
+	tx.Commit()
+	tx, _ = db.Begin()
+	if err := tx.SetItem("Doc", a, map[string][]Value{"Data": {NewBytes(second)}}); err != nil {
+		t.Fatalf("SetItem() failed: %s", err)
+	}
+	tx.Commit()
+	var encoded string
+	db.Base().QueryRow(`SELECT Vals FROM _HISTORY ORDER BY Id LIMIT 1;`).Scan(&encoded)
+	if refs := changeBlobRefs(encoded); len(refs) != 1 {
+		t.Errorf("the history should hold a reference instead of the blob, got %d references", len(refs))
+	}
+
+	// the file of the first blob is kept for the history
+	if n := countBlobFiles(t, blobDir); n != 2 {
+		t.Errorf("the blob directory contains %d files, expected 2", n)
+	}
+	if n, err := db.CollectBlobs(); err != nil || n != 0 {
+		t.Errorf("CollectBlobs() returned %d, %v, expected to keep the blobs of the history", n, err)
+	}
+	history, err := db.HistoryOf("Doc", a, "Data")
+	if err != nil || len(history) != 2 || !bytes.Equal(history[0].Values[0].Bytes(), first) ||
+		!bytes.Equal(history[1].Values[0].Bytes(), second) {
+		t.Errorf("HistoryOf() returned %v, %v", history, err)
+	}
+	values, err := db.AsOf(history[0].Time).Get("Doc", a, "Data")
+	if err != nil || !bytes.Equal(values[0].Bytes(), first) {
+		t.Errorf("AsOf().Get() returned %v, %v", values, err)
+	}
+
+	// pruning the change removes the file that is no longer referenced
+	tx, _ = db.Begin()
+	if _, err := tx.PruneHistory(history[1].Time); err != nil {
+		t.Errorf("PruneHistory() failed: %s", err)
+	}
+	tx.Commit()
+	if n := countBlobFiles(t, blobDir); n != 1 {
+		t.Errorf("the blob directory contains %d files after pruning, expected 1", n)
+	}
+	values, err = db.Get("Doc", a, "Data")
+	if err != nil || !bytes.Equal(values[0].Bytes(), second) {
+		t.Errorf("Get() returned %v, %v", values, err)
+	}
+}
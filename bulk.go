@@ -0,0 +1,243 @@
+package minidb
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ------------------------------------------------------------------------------
+// Bulk insert
+// ------------------------------------------------------------------------------
+
+// FieldValue holds the values of a field for NewItems. Single fields take exactly one value.
+type FieldValue struct {
+	Field  string  `json:"field"`
+	Values []Value `json:"values"`
+}
+
+// maxBulkParams is the maximum number of parameters in one statement used by NewItems.
+// It stays below the default host parameter limit of older Sqlite versions.
+const maxBulkParams = 900
+
+// sqlArg returns the argument used to store the value in an SQL statement.
+func sqlArg(v Value) interface{} {
+	switch v.Sort {
+	case DBNull:
+		return nil
+	case DBInt:
+		return v.Num
+	case DBBlob:
+		return v.Bytes()
+	default:
+		return v.Str
+	}
+}
+
+// NewItems creates one item for each row in the table and sets the given field values,
+// using multi-row INSERT statements. Fields that are not given in a row get their default value.
+// This is much faster than creating many items with NewItem and Set, but the changes are not
+// recorded in the change log. The IDs of the new items are returned in the order of the rows.
+func (tx *Tx) NewItems(table string, rows [][]FieldValue) ([]Item, error) {
+	if !validTable.MatchString(table) {
+		return nil, Fail("invalid table name '%s'", table)
+	}
+	// the table is looked up in the transaction, which may have created it
+	view := tx.View()
+	if !view.TableExists(table) {
+		return nil, tableNotFound(table)
+	}
+	fields, err := view.GetFields(table)
+	if err != nil {
+		return nil, err
+	}
+	descs := make(map[string]Field)
+	for _, field := range fields {
+		descs[field.Name] = field
+	}
+	var size int64
+	for i, row := range rows {
+		seen := make(map[string]bool)
+		for _, fv := range row {
+			desc, ok := descs[fv.Field]
+			if !ok {
+				return nil, fieldNotFound(table, fv.Field)
+			}
+			if isTimestampField(fv.Field) {
+				return nil, Fail("field '%s' in table '%s' is maintained automatically and cannot be set", fv.Field, table)
+			}
+			if seen[fv.Field] {
+				return nil, Fail("field '%s' given twice in row %d", fv.Field, i)
+			}
+			seen[fv.Field] = true
+			if desc.Required && len(fv.Values) == 0 {
+				return nil, Fail("field '%s' in table '%s' is required and cannot be empty", fv.Field, table)
+			}
+			if !isListFieldType(desc.Sort) && len(fv.Values) != 1 {
+				return nil, Fail("attempt to set %d values in single field %s %s in row %d, should be just one value",
+					len(fv.Values), table, fv.Field, i)
+			}
+			t := ToBaseType(desc.Sort)
+			for _, v := range fv.Values {
+				if v.Sort != t {
+					return nil, Fail("type error %s %s in row %d: %w", table, fv.Field, i,
+						&TypeMismatchError{Expected: t, Encountered: v.Sort})
+				}
+			}
+			if err := checkCustomValues(desc.Sort, fv.Values); err != nil {
+				return nil, err
+			}
+			if err := tx.mdb.validate(table, fv.Field, fv.Values); err != nil {
+				return nil, err
+			}
+			size += valuesSize(fv.Values)
+		}
+	}
+	if err := tx.mdb.checkSize(tx, size); err != nil {
+		return nil, err
+	}
+	stored, err := tx.mdb.storeRowBlobs(rows, descs)
+	if err != nil {
+		return nil, err
+	}
+	items, err := tx.insertBulkRows(table, stored, descs)
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.insertBulkLists(table, stored, items, fields); err != nil {
+		return nil, err
+	}
+	if err := tx.View().stampNewItems(tx.tx, table, items); err != nil {
+		return nil, err
+	}
+	for _, row := range rows {
+		var data []Value
+		for _, fv := range row {
+			data = append(data, fv.Values...)
+		}
+		tx.mdb.countWrite(table, data)
+	}
+	for i, item := range items {
+		entries := []AuditEntry{{Op: AuditNewItem, Table: table, Item: item}}
+		for _, fv := range rows[i] {
+			entries = append(entries, AuditEntry{Op: AuditSet, Table: table, Item: item, Field: fv.Field,
+				New: append([]Value{}, fv.Values...)})
+		}
+		if err := tx.audit(entries...); err != nil {
+			return nil, err
+		}
+		tx.notify(ChangeEvent{Kind: ChangeNewItem, Table: table, Item: item})
+	}
+	return items, nil
+}
+
+// insertBulkRows inserts the single fields of the rows into the table. Consecutive rows that
+// set the same fields are inserted with one statement.
+func (tx *Tx) insertBulkRows(table string, rows [][]FieldValue, descs map[string]Field) ([]Item, error) {
+	items := make([]Item, 0, len(rows))
+	columnsOf := func(row []FieldValue) []string {
+		cols := make([]string, 0, len(row))
+		for _, fv := range row {
+			if !isListFieldType(descs[fv.Field].Sort) {
+				cols = append(cols, fv.Field)
+			}
+		}
+		return cols
+	}
+	for start := 0; start < len(rows); {
+		cols := columnsOf(rows[start])
+		key := strings.Join(cols, ",")
+		perRow := len(cols)
+		if perRow == 0 {
+			perRow = 1
+		}
+		end := start + 1
+		for end < len(rows) && (end-start+1)*perRow <= maxBulkParams && strings.Join(columnsOf(rows[end]), ",") == key {
+			end++
+		}
+		var toExec string
+		args := make([]interface{}, 0, (end-start)*perRow)
+		if len(cols) == 0 {
+			// Sqlite has no multi-row form of DEFAULT VALUES, so NULL is inserted as Id instead
+			toExec = fmt.Sprintf(`INSERT INTO "%s" (Id) VALUES (NULL)%s;`, table,
+				strings.Repeat(",(NULL)", end-start-1))
+		} else {
+			quoted := make([]string, len(cols))
+			for i := range cols {
+				quoted[i] = `"` + cols[i] + `"`
+			}
+			tuple := "(?" + strings.Repeat(",?", len(cols)-1) + ")"
+			toExec = fmt.Sprintf(`INSERT INTO "%s" (%s) VALUES %s%s;`, table, strings.Join(quoted, ","),
+				tuple, strings.Repeat(","+tuple, end-start-1))
+			for _, row := range rows[start:end] {
+				for _, fv := range row {
+					if !isListFieldType(descs[fv.Field].Sort) {
+						args = append(args, sqlArg(fv.Values[0]))
+					}
+				}
+			}
+		}
+		result, err := tx.tx.Exec(toExec, args...)
+		if err != nil {
+			return nil, Fail("cannot insert items into table '%s': %s", table, err)
+		}
+		last, err := result.LastInsertId()
+		if err != nil {
+			return nil, err
+		}
+		// the rows of one statement get consecutive IDs
+		for i := int64(end - start - 1); i >= 0; i-- {
+			items = append(items, Item(last-i))
+		}
+		start = end
+	}
+	return items, nil
+}
+
+// insertBulkLists inserts the list field values of the rows, or the default values
+// of list fields that are not given in a row.
+func (tx *Tx) insertBulkLists(table string, rows [][]FieldValue, items []Item, fields []Field) error {
+	for _, field := range fields {
+		if !isListFieldType(field.Sort) {
+			continue
+		}
+		args := make([]interface{}, 0)
+		flush := func() error {
+			if len(args) == 0 {
+				return nil
+			}
+			n := len(args) / 2
+			toExec := fmt.Sprintf(`INSERT INTO "%s" ("%s",Owner) VALUES (?,?)%s;`,
+				listFieldToTableName(table, field.Name), field.Name, strings.Repeat(",(?,?)", n-1))
+			if _, err := tx.tx.Exec(toExec, args...); err != nil {
+				return Fail("cannot insert values of list field %s in table %s: %s", field.Name, table, err)
+			}
+			args = args[:0]
+			return nil
+		}
+		for i, row := range rows {
+			values := []Value{}
+			given := false
+			for _, fv := range row {
+				if fv.Field == field.Name {
+					values = fv.Values
+					given = true
+				}
+			}
+			if !given && field.Default != nil {
+				values = []Value{*field.Default}
+			}
+			for _, v := range values {
+				args = append(args, sqlArg(v), items[i])
+				if len(args) >= maxBulkParams {
+					if err := flush(); err != nil {
+						return err
+					}
+				}
+			}
+		}
+		if err := flush(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,89 @@
+package minidb
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestNewItems(t *testing.T) {
+	tmp, _ := ioutil.TempFile("", "minidb-bulk-testing-*")
+	defer os.Remove(tmp.Name())
+	db, err := Open("sqlite3", tmp.Name())
+	if err != nil {
+		t.Errorf("Open() failed: %s", err)
+	}
+	defer db.Close()
+	unknown := NewString("unknown")
+	if err := db.AddTable("Person", []Field{Field{Name: "Name", Sort: DBString}, Field{Name: "Age", Sort: DBInt},
+		Field{Name: "Tags", Sort: DBStringList, Default: &unknown}}); err != nil {
+		t.Errorf("AddTable() failed: %s", err)
+	}
+	existing, _ := db.NewItem("Person")
+	rows := make([][]FieldValue, 0)
+	for i := 0; i < 1000; i++ {
+		rows = append(rows, []FieldValue{
+			FieldValue{Field: "Name", Values: []Value{NewString(fmt.Sprintf("P%d", i))}},
+			FieldValue{Field: "Age", Values: []Value{NewInt(int64(i))}},
+			FieldValue{Field: "Tags", Values: []Value{NewString("a"), NewString("b")}},
+		})
+	}
+	rows = append(rows, []FieldValue{}, []FieldValue{FieldValue{Field: "Name", Values: []Value{NewString("Last")}}})
+	tx, err := db.Begin()
+	if err != nil {
+		t.Errorf("Begin() failed: %s", err)
+	}
+	items, err := tx.NewItems("Person", rows)
+	if err != nil {
+		t.Errorf("NewItems() failed: %s", err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Errorf("Commit() failed: %s", err)
+	}
+	if len(items) != len(rows) {
+		t.Fatalf("NewItems() returned %d items, expected %d", len(items), len(rows))
+	}
+	for i, item := range items {
+		if item == existing {
+			t.Errorf("NewItems() returned existing item %d", item)
+		}
+		if i < 1000 {
+			values, err := db.Get("Person", item, "Age")
+			if err != nil || values[0].Int() != int64(i) {
+				t.Errorf("Get() returned %v, %v for item %d, expected %d", values, err, item, i)
+				break
+			}
+		}
+	}
+	values, err := db.Get("Person", items[999], "Tags")
+	if err != nil || len(values) != 2 || values[1].String() != "b" {
+		t.Errorf("Get() returned wrong list values %v, %v", values, err)
+	}
+	values, err = db.Get("Person", items[1001], "Tags")
+	if err != nil || len(values) != 1 || values[0].String() != "unknown" {
+		t.Errorf("Get() returned %v, %v for a list field with default", values, err)
+	}
+	values, err = db.Get("Person", items[1001], "Name")
+	if err != nil || values[0].String() != "Last" {
+		t.Errorf("Get() returned %v, %v for the last item", values, err)
+	}
+	if n, _ := db.Count("Person"); n != 1003 {
+		t.Errorf("Count() returned %d after NewItems(), expected 1003", n)
+	}
+
+	tx, err = db.Begin()
+	if err != nil {
+		t.Errorf("Begin() failed: %s", err)
+	}
+	if _, err := tx.NewItems("Person", [][]FieldValue{[]FieldValue{FieldValue{Field: "Age", Values: []Value{NewString("x")}}}}); err == nil {
+		t.Errorf("NewItems() should fail for a type error")
+	}
+	if _, err := tx.NewItems("Person", [][]FieldValue{[]FieldValue{FieldValue{Field: "Height", Values: []Value{NewInt(1)}}}}); err == nil {
+		t.Errorf("NewItems() should fail for an unknown field")
+	}
+	if _, err := tx.NewItems("Person", [][]FieldValue{[]FieldValue{FieldValue{Field: "Name"}}}); err == nil {
+		t.Errorf("NewItems() should fail for a single field without value")
+	}
+	tx.Rollback()
+}
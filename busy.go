@@ -0,0 +1,250 @@
+package minidb
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"time"
+
+	sqlite3 "github.com/mattn/go-sqlite3"
+)
+
+// ------------------------------------------------------------------------------
+// Retrying statements on locked databases, see Options.BusyRetries
+// ------------------------------------------------------------------------------
+
+// defaultBusyBackoff is the time waited before the first retry if Options.BusyBackoff is zero.
+const defaultBusyBackoff = 10 * time.Millisecond
+
+// busyRetry is the policy for retrying statements that fail because the database is locked.
+type busyRetry struct {
+	retries int
+	backoff time.Duration
+}
+
+// busyRetry returns the retry policy of the options.
+func (options *Options) busyRetry() (busyRetry, error) {
+	if options.BusyRetries < 0 {
+		return busyRetry{}, Fail("invalid number of busy retries %d", options.BusyRetries)
+	}
+	if options.BusyBackoff < 0 {
+		return busyRetry{}, Fail("invalid busy backoff %s", options.BusyBackoff)
+	}
+	r := busyRetry{retries: options.BusyRetries, backoff: options.BusyBackoff}
+	if r.backoff == 0 {
+		r.backoff = defaultBusyBackoff
+	}
+	return r, nil
+}
+
+// isBusy returns true if the error reports that the database is locked by another connection.
+func isBusy(err error) bool {
+	var e sqlite3.Error
+	if errors.As(err, &e) {
+		return e.Code == sqlite3.ErrBusy || e.Code == sqlite3.ErrLocked
+	}
+	return false
+}
+
+// do calls f until it does not fail because the database is locked or the retries are used up,
+// waiting twice as long before each retry as before the previous one.
+func (r busyRetry) do(ctx context.Context, f func() error) error {
+	wait := r.backoff
+	err := f()
+	for i := 0; i < r.retries && isBusy(err); i++ {
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(wait):
+		}
+		wait *= 2
+		err = f()
+	}
+	return err
+}
+
+// busyConn is a connection whose statements are retried if the database is locked. A statement
+// that fails because the database is locked has not changed anything, so it can be repeated.
+// Only the commit of a transaction is retried, not the transaction as a whole.
+type busyConn struct {
+	driver.Conn
+	retry busyRetry
+}
+
+func (c *busyConn) Prepare(query string) (driver.Stmt, error) {
+	return c.PrepareContext(context.Background(), query)
+}
+
+func (c *busyConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	var stmt driver.Stmt
+	err := c.retry.do(ctx, func() (err error) {
+		if p, ok := c.Conn.(driver.ConnPrepareContext); ok {
+			stmt, err = p.PrepareContext(ctx, query)
+		} else {
+			stmt, err = c.Conn.Prepare(query)
+		}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &busyStmt{Stmt: stmt, retry: c.retry}, nil
+}
+
+func (c *busyConn) Begin() (driver.Tx, error) {
+	return c.BeginTx(context.Background(), driver.TxOptions{})
+}
+
+func (c *busyConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	var tx driver.Tx
+	err := c.retry.do(ctx, func() (err error) {
+		if b, ok := c.Conn.(driver.ConnBeginTx); ok {
+			tx, err = b.BeginTx(ctx, opts)
+		} else {
+			tx, err = c.Conn.Begin()
+		}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &busyTx{Tx: tx, conn: c}, nil
+}
+
+func (c *busyConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	execer, ok := c.Conn.(driver.ExecerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+	var result driver.Result
+	err := c.retry.do(ctx, func() (err error) {
+		result, err = execer.ExecContext(ctx, query, args)
+		return err
+	})
+	return result, err
+}
+
+func (c *busyConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	queryer, ok := c.Conn.(driver.QueryerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+	var rows driver.Rows
+	err := c.retry.do(ctx, func() (err error) {
+		rows, err = queryer.QueryContext(ctx, query, args)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &busyRows{Rows: rows, ctx: ctx, retry: c.retry}, nil
+}
+
+// busyTx is a transaction of a busyConn.
+type busyTx struct {
+	driver.Tx
+	conn *busyConn
+}
+
+// Commit retries the commit like a statement. Like the sqlite3 driver, it rolls back the
+// transaction if the commit fails, since database/sql considers it finished either way.
+func (tx *busyTx) Commit() error {
+	execer, ok := tx.conn.Conn.(driver.ExecerContext)
+	if !ok {
+		return tx.Tx.Commit()
+	}
+	ctx := context.Background()
+	err := tx.conn.retry.do(ctx, func() error {
+		_, err := execer.ExecContext(ctx, "COMMIT;", nil)
+		return err
+	})
+	if err != nil {
+		execer.ExecContext(ctx, "ROLLBACK;", nil)
+	}
+	return err
+}
+
+// busyStmt is a prepared statement of a busyConn.
+type busyStmt struct {
+	driver.Stmt
+	retry busyRetry
+}
+
+func (s *busyStmt) Exec(args []driver.Value) (driver.Result, error) {
+	var result driver.Result
+	err := s.retry.do(context.Background(), func() (err error) {
+		result, err = s.Stmt.Exec(args)
+		return err
+	})
+	return result, err
+}
+
+func (s *busyStmt) Query(args []driver.Value) (driver.Rows, error) {
+	var rows driver.Rows
+	ctx := context.Background()
+	err := s.retry.do(ctx, func() (err error) {
+		rows, err = s.Stmt.Query(args)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &busyRows{Rows: rows, ctx: ctx, retry: s.retry}, nil
+}
+
+func (s *busyStmt) ExecContext(ctx context.Context, args []driver.NamedValue) (driver.Result, error) {
+	execer, ok := s.Stmt.(driver.StmtExecContext)
+	if !ok {
+		return s.Exec(namedValues(args))
+	}
+	var result driver.Result
+	err := s.retry.do(ctx, func() (err error) {
+		result, err = execer.ExecContext(ctx, args)
+		return err
+	})
+	return result, err
+}
+
+func (s *busyStmt) QueryContext(ctx context.Context, args []driver.NamedValue) (driver.Rows, error) {
+	queryer, ok := s.Stmt.(driver.StmtQueryContext)
+	if !ok {
+		return s.Query(namedValues(args))
+	}
+	var rows driver.Rows
+	err := s.retry.do(ctx, func() (err error) {
+		rows, err = queryer.QueryContext(ctx, args)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &busyRows{Rows: rows, ctx: ctx, retry: s.retry}, nil
+}
+
+// namedValues returns the values of the arguments in their order.
+func namedValues(args []driver.NamedValue) []driver.Value {
+	values := make([]driver.Value, len(args))
+	for i := range args {
+		values[i] = args[i].Value
+	}
+	return values
+}
+
+// busyRows retries fetching the first row, since SQLite only reads the database when a query
+// is stepped. The sqlite3 driver resets a statement that failed, so the query starts over.
+type busyRows struct {
+	driver.Rows
+	ctx     context.Context
+	retry   busyRetry
+	started bool
+}
+
+func (r *busyRows) Next(dest []driver.Value) error {
+	if r.started {
+		return r.Rows.Next(dest)
+	}
+	r.started = true
+	return r.retry.do(r.ctx, func() error {
+		return r.Rows.Next(dest)
+	})
+}
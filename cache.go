@@ -0,0 +1,143 @@
+package minidb
+
+import (
+	"sync"
+	"time"
+)
+
+// ------------------------------------------------------------------------------
+// Cache of frequent lookups
+// ------------------------------------------------------------------------------
+
+// maxCacheEntries is the number of cached lookups above which expired entries are removed,
+// and all entries if none have expired.
+const maxCacheEntries = 10000
+
+// The kinds of lookups cached by the lookup cache.
+const (
+	cacheTableExists = iota
+	cacheItemExists
+	cacheCount
+)
+
+// LookupStats are the hits and misses of one kind of lookup in the lookup cache.
+type LookupStats struct {
+	Hits   int64 `json:"hits"`
+	Misses int64 `json:"misses"`
+}
+
+// HitRate returns the fraction of lookups answered by the cache, or 0 if there were none.
+func (s LookupStats) HitRate() float64 {
+	if s.Hits+s.Misses == 0 {
+		return 0
+	}
+	return float64(s.Hits) / float64(s.Hits+s.Misses)
+}
+
+// LookupCacheStats contains the hits and misses of the lookup cache for each cached method.
+type LookupCacheStats struct {
+	TableExists LookupStats `json:"tableExists"`
+	ItemExists  LookupStats `json:"itemExists"`
+	Count       LookupStats `json:"count"`
+}
+
+type cacheKey struct {
+	kind  int
+	table string
+	item  Item
+}
+
+type cacheEntry struct {
+	value   int64
+	expires time.Time
+}
+
+// lookupCache holds the results of TableExists, ItemExists, and Count for a short time. It is shared by
+// an MDB and its views, but only used by the MDB itself because views see uncommitted changes.
+type lookupCache struct {
+	sync.Mutex
+	ttl        time.Duration
+	generation int64
+	entries    map[cacheKey]cacheEntry
+	stats      [3]LookupStats
+}
+
+func newLookupCache(ttl time.Duration) *lookupCache {
+	return &lookupCache{ttl: ttl, entries: make(map[cacheKey]cacheEntry)}
+}
+
+// cached returns the cached value of the lookup if there is one. Otherwise, it returns the
+// generation of the cache, which must be passed to storeCached with the looked up value.
+func (db *MDB) cached(key cacheKey) (int64, int64, bool) {
+	c := db.cache
+	if c == nil || db.reader != db.base {
+		return 0, -1, false
+	}
+	c.Lock()
+	defer c.Unlock()
+	entry, ok := c.entries[key]
+	if ok && time.Now().Before(entry.expires) {
+		c.stats[key.kind].Hits++
+		return entry.value, 0, true
+	}
+	c.stats[key.kind].Misses++
+	return 0, c.generation, false
+}
+
+// storeCached caches the value of a lookup unless the cache has been invalidated since the
+// generation was returned by cached, because then the value may be outdated already.
+func (db *MDB) storeCached(key cacheKey, generation int64, value int64) {
+	c := db.cache
+	if c == nil || generation < 0 {
+		return
+	}
+	c.Lock()
+	defer c.Unlock()
+	if generation != c.generation {
+		return
+	}
+	now := time.Now()
+	if len(c.entries) >= maxCacheEntries {
+		for k, entry := range c.entries {
+			if !now.Before(entry.expires) {
+				delete(c.entries, k)
+			}
+		}
+		if len(c.entries) >= maxCacheEntries {
+			c.entries = make(map[cacheKey]cacheEntry)
+		}
+	}
+	c.entries[key] = cacheEntry{value: value, expires: now.Add(c.ttl)}
+}
+
+// invalidate removes the entries affected by a change. It is registered with OnChange.
+func (c *lookupCache) invalidate(event ChangeEvent) {
+	c.Lock()
+	defer c.Unlock()
+	switch event.Kind {
+	case ChangeNewItem, ChangeRemoveItem:
+		c.generation++
+		delete(c.entries, cacheKey{kind: cacheCount, table: event.Table})
+		delete(c.entries, cacheKey{kind: cacheItemExists, table: event.Table, item: event.Item})
+	case ChangeSchema:
+		// schema changes are rare and may affect list fields of the table as well
+		c.generation++
+		c.entries = make(map[cacheKey]cacheEntry)
+	}
+}
+
+// LookupCacheStats returns the hits and misses of the lookup cache enabled with
+// Options.LookupCacheTTL. All numbers are 0 if the cache is not enabled.
+func (db *MDB) LookupCacheStats() LookupCacheStats {
+	var stats LookupCacheStats
+	c := db.cache
+	if c == nil {
+		return stats
+	}
+	c.Lock()
+	defer c.Unlock()
+	stats.TableExists = c.stats[cacheTableExists]
+	stats.ItemExists = c.stats[cacheItemExists]
+	stats.Count = c.stats[cacheCount]
+	return stats
+}
@@ -0,0 +1,79 @@
+package minidb
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestLookupCache(t *testing.T) {
+	tmp, _ := ioutil.TempFile("", "minidb-cache-testing-*")
+	defer os.Remove(tmp.Name())
+	db, err := OpenWithOptions("sqlite3", tmp.Name(), &Options{LookupCacheTTL: time.Hour})
+	if err != nil {
+		t.Errorf("OpenWithOptions() failed: %s", err)
+	}
+	defer db.Close()
+	if db.TableExists("Person") {
+		t.Errorf("TableExists() returned true for a missing table")
+	}
+	if err := db.AddTable("Person", []Field{Field{Name: "Name", Sort: DBString}}); err != nil {
+		t.Errorf("AddTable() failed: %s", err)
+	}
+	if !db.TableExists("Person") {
+		t.Errorf("a cached TableExists() result was not invalidated by AddTable()")
+	}
+	item, _ := db.NewItem("Person")
+	for i := 0; i < 3; i++ {
+		if n, _ := db.Count("Person"); n != 1 {
+			t.Errorf("Count() returned %d, expected 1", n)
+		}
+		if !db.ItemExists("Person", item) {
+			t.Errorf("ItemExists() returned false for an existing item")
+		}
+	}
+	stats := db.LookupCacheStats()
+	if stats.Count.Hits != 2 || stats.Count.Misses != 1 || stats.ItemExists.Hits != 2 {
+		t.Errorf("unexpected cache statistics %+v", stats)
+	}
+	if rate := stats.Count.HitRate(); rate < 0.66 || rate > 0.67 {
+		t.Errorf("Count hit rate is %f, expected 2/3", rate)
+	}
+
+	// committed changes invalidate the cache, uncommitted ones are not seen
+	tx, _ := db.Begin()
+	tx.RemoveItem("Person", item)
+	if n, _ := tx.View().Count("Person"); n != 0 {
+		t.Errorf("a view got a cached count of %d", n)
+	}
+	if !db.ItemExists("Person", item) {
+		t.Errorf("an uncommitted removal was seen outside of the transaction")
+	}
+	tx.Commit()
+	if n, _ := db.Count("Person"); n != 0 || db.ItemExists("Person", item) {
+		t.Errorf("a removal did not invalidate the cache, count is %d", n)
+	}
+
+	// changes by others are seen after the results expire
+	other, _ := Open("sqlite3", tmp.Name())
+	defer other.Close()
+	other.NewItem("Person")
+	if n, _ := db.Count("Person"); n != 0 {
+		t.Errorf("Count() was not cached")
+	}
+	db.cache.ttl = time.Millisecond
+	db.cache.entries = make(map[cacheKey]cacheEntry)
+	db.Count("Person")
+	time.Sleep(2 * time.Millisecond)
+	if n, _ := db.Count("Person"); n != 1 {
+		t.Errorf("an expired count was returned")
+	}
+
+	plain, _ := Open("sqlite3", tmp.Name())
+	defer plain.Close()
+	plain.Count("Person")
+	if stats := plain.LookupCacheStats(); stats.Count.Misses != 0 {
+		t.Errorf("statistics were collected without a cache: %+v", stats)
+	}
+}
@@ -0,0 +1,127 @@
+package minidb
+
+import (
+	"fmt"
+	"sync"
+)
+
+// ------------------------------------------------------------------------------
+// Change notifications
+// ------------------------------------------------------------------------------
+
+// ChangeKind is the kind of change reported by a ChangeEvent.
+type ChangeKind int
+
+const (
+	// ChangeNewItem is reported for items created by NewItem, UseItem, NewItems and CloneItem.
+	ChangeNewItem ChangeKind = iota + 1
+	// ChangeSet is reported for every field set by Set and SetItem.
+	ChangeSet
+	// ChangeRemoveItem is reported for every item removed by RemoveItem and RemoveItems.
+	ChangeRemoveItem
+	// ChangeSchema is reported when a table is added, renamed or dropped and when a field
+	// is added or changes its type.
+	ChangeSchema
+)
+
+// String returns a readable name of the change kind.
+func (k ChangeKind) String() string {
+	switch k {
+	case ChangeNewItem:
+		return "new item"
+	case ChangeSet:
+		return "set"
+	case ChangeRemoveItem:
+		return "remove item"
+	case ChangeSchema:
+		return "schema"
+	}
+	return fmt.Sprintf("change %d", int(k))
+}
+
+// ChangeEvent describes a change of the database. Item is 0 for schema changes, and Field is
+// empty unless a field was set, added, or changed its type. OldTable is the previous name
+// of a renamed table.
+type ChangeEvent struct {
+	Kind     ChangeKind
+	Table    string
+	Item     Item
+	Field    string
+	OldTable string
+}
+
+type changeListener struct {
+	id int
+	fn func(event ChangeEvent)
+}
+
+// changeHooks holds the functions registered with OnChange. It is shared by an MDB and
+// its views.
+type changeHooks struct {
+	sync.Mutex
+	next      int
+	listeners []changeListener
+}
+
+// OnChange registers a function that is called for every change of the database made through
+// this MDB, and returns a function that removes it again. Changes made in a transaction are
+// reported after the outermost transaction has been committed and are not reported if it
+// is rolled back, so a listener always sees committed data. Listeners are called one after the
+// other in the order of registration from the goroutine that made or committed the change,
+// after all locks have been released, so they may read and write the database themselves.
+func (db *MDB) OnChange(fn func(event ChangeEvent)) func() {
+	h := db.hooks
+	h.Lock()
+	defer h.Unlock()
+	h.next++
+	id := h.next
+	h.listeners = append(h.listeners, changeListener{id: id, fn: fn})
+	return func() {
+		h.Lock()
+		defer h.Unlock()
+		for i := range h.listeners {
+			if h.listeners[i].id == id {
+				h.listeners = append(h.listeners[:i:i], h.listeners[i+1:]...)
+				return
+			}
+		}
+	}
+}
+
+// active returns true if there are listeners, so that callers can skip collecting events.
+func (h *changeHooks) active() bool {
+	if h == nil {
+		return false
+	}
+	h.Lock()
+	defer h.Unlock()
+	return len(h.listeners) > 0
+}
+
+// deliver calls the listeners for each of the events.
+func (h *changeHooks) deliver(events []ChangeEvent) {
+	if h == nil || len(events) == 0 {
+		return
+	}
+	h.Lock()
+	listeners := h.listeners
+	h.Unlock()
+	for _, event := range events {
+		for _, l := range listeners {
+			l.fn(event)
+		}
+	}
+}
+
+// notify reports changes made outside of a transaction immediately.
+func (db *MDB) notify(events ...ChangeEvent) {
+	db.hooks.deliver(events)
+}
+
+// notify records changes made in the transaction, which are reported when the
+// outermost transaction is committed.
+func (tx *Tx) notify(events ...ChangeEvent) {
+	if tx.mdb.hooks.active() {
+		tx.events = append(tx.events, events...)
+	}
+}
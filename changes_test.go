@@ -0,0 +1,94 @@
+package minidb
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestOnChange(t *testing.T) {
+	tmp, _ := ioutil.TempFile("", "minidb-changes-testing-*")
+	defer os.Remove(tmp.Name())
+	db, err := Open("sqlite3", tmp.Name())
+	if err != nil {
+		t.Errorf("Open() failed: %s", err)
+	}
+	defer db.Close()
+	var events []ChangeEvent
+	stop := db.OnChange(func(event ChangeEvent) {
+		events = append(events, event)
+	})
+	expect := func(what string, want ...ChangeEvent) {
+		t.Helper()
+		if !reflect.DeepEqual(events, want) {
+			t.Errorf("%s reported %v, expected %v", what, events, want)
+		}
+		events = nil
+	}
+
+	if err := db.AddTable("Person", []Field{Field{Name: "Name", Sort: DBString}, Field{Name: "Tags", Sort: DBStringList}}); err != nil {
+		t.Errorf("AddTable() failed: %s", err)
+	}
+	expect("AddTable()", ChangeEvent{Kind: ChangeSchema, Table: "Person"})
+	item, _ := db.NewItem("Person")
+	expect("NewItem()", ChangeEvent{Kind: ChangeNewItem, Table: "Person", Item: item})
+	db.UseItem("Person", 100)
+	db.UseItem("Person", 100)
+	expect("UseItem()", ChangeEvent{Kind: ChangeNewItem, Table: "Person", Item: 100})
+
+	tx, _ := db.Begin()
+	tx.Set("Person", item, "Name", []Value{NewString("John")})
+	tx.SetItem("Person", item, map[string][]Value{"Tags": []Value{NewString("a")}, "Name": []Value{NewString("Jim")}})
+	if len(events) != 0 {
+		t.Errorf("changes were reported before the transaction was committed: %v", events)
+	}
+	tx.Commit()
+	expect("Set() and SetItem()",
+		ChangeEvent{Kind: ChangeSet, Table: "Person", Item: item, Field: "Name"},
+		ChangeEvent{Kind: ChangeSet, Table: "Person", Item: item, Field: "Name"},
+		ChangeEvent{Kind: ChangeSet, Table: "Person", Item: item, Field: "Tags"})
+
+	tx, _ = db.Begin()
+	tx.Set("Person", item, "Name", []Value{NewString("Jack")})
+	tx.RemoveItem("Person", 100)
+	tx.Rollback()
+	expect("a rolled back transaction")
+
+	tx, _ = db.Begin()
+	sub, _ := tx.Begin()
+	sub.RemoveItem("Person", 100)
+	sub.Rollback()
+	sub, _ = tx.Begin()
+	sub.RemoveItems("Person", []Item{item, 100, 12345})
+	sub.Commit()
+	tx.Commit()
+	expect("RemoveItems() in a nested transaction",
+		ChangeEvent{Kind: ChangeRemoveItem, Table: "Person", Item: item},
+		ChangeEvent{Kind: ChangeRemoveItem, Table: "Person", Item: 100})
+
+	tx, _ = db.Begin()
+	tx.EnableTimestamps("Person")
+	tx.RenameTable("Person", "People")
+	tx.Commit()
+	expect("EnableTimestamps() and RenameTable()",
+		ChangeEvent{Kind: ChangeSchema, Table: "Person", Field: CreatedField},
+		ChangeEvent{Kind: ChangeSchema, Table: "Person", Field: ModifiedField},
+		ChangeEvent{Kind: ChangeSchema, Table: "People", OldTable: "Person"})
+
+	// listeners may use the database themselves
+	stop()
+	var count int64
+	stop = db.OnChange(func(event ChangeEvent) {
+		count, _ = db.Count(event.Table)
+	})
+	db.NewItem("People")
+	if count != 1 {
+		t.Errorf("a listener could not read the database, count is %d", count)
+	}
+	stop()
+	db.NewItem("People")
+	if len(events) != 0 || count != 1 {
+		t.Errorf("a removed listener was called: %v %d", events, count)
+	}
+}
@@ -0,0 +1,301 @@
+// Package client sends minidb commands to one of several mdbserve servers. If the server in use
+// fails, the client switches to the next responsive server and transparently sends read commands
+// again, so that a primary and a replica server can be used without an external load balancer.
+package client
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	minidb "github.com/rasteric/minidb"
+	mangos "nanomsg.org/go/mangos/v2"
+	"nanomsg.org/go/mangos/v2/protocol/req"
+
+	// register transports
+	_ "nanomsg.org/go/mangos/v2/transport/all"
+)
+
+// ErrNoServer is returned if none of the servers of a client is responsive.
+var ErrNoServer = errors.New("no responsive server")
+
+// DefaultTimeout is the time a client waits for a reply before it considers a server to have failed.
+const DefaultTimeout = time.Minute
+
+// Client sends commands to the first responsive server of a list of server URLs.
+type Client struct {
+	urls     []string
+	current  int
+	sock     mangos.Socket
+	opened   map[minidb.CommandDB]*minidb.Command
+	timeout  time.Duration
+	encoding minidb.Encoding
+	tls      *tls.Config
+	auth     *minidb.Command
+}
+
+// Dial returns a client that is connected to the first responsive server in urls,
+// using DefaultTimeout.
+func Dial(urls ...string) (*Client, error) {
+	return DialTimeout(DefaultTimeout, urls...)
+}
+
+// DialTimeout returns a client that is connected to the first responsive server in urls.
+// A server that does not reply within the given timeout is considered to have failed.
+func DialTimeout(timeout time.Duration, urls ...string) (*Client, error) {
+	return DialTLS(nil, timeout, urls...)
+}
+
+// DialTLS is like DialTimeout, but uses the TLS configuration for the tls+tcp URLs in urls, see
+// TLSConfig. Other URLs are dialed without TLS.
+func DialTLS(config *tls.Config, timeout time.Duration, urls ...string) (*Client, error) {
+	if len(urls) == 0 {
+		return nil, errors.New("no server URL given")
+	}
+	c := &Client{
+		urls:    urls,
+		current: len(urls) - 1,
+		opened:  make(map[minidb.CommandDB]*minidb.Command),
+		timeout: timeout,
+		tls:     config,
+	}
+	if err := c.failover(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// TLSConfig returns a TLS configuration for a client that trusts the certificate authorities in
+// the PEM file caFile, or those of the system if caFile is empty. If certFile and keyFile are not
+// empty, the client presents the certificate in them to servers that require client certificates.
+func TLSConfig(caFile, certFile, keyFile string) (*tls.Config, error) {
+	config := &tls.Config{MinVersion: tls.VersionTLS12}
+	if caFile != "" {
+		pem, err := ioutil.ReadFile(caFile)
+		if err != nil {
+			return nil, fmt.Errorf("cannot read CA file: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in CA file %s", caFile)
+		}
+		config.RootCAs = pool
+	}
+	if certFile != "" || keyFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("cannot load certificate: %w", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	return config, nil
+}
+
+// URL returns the URL of the server in use.
+func (c *Client) URL() string {
+	return c.urls[c.current]
+}
+
+// Close closes the connection to the server.
+func (c *Client) Close() error {
+	if c.sock == nil {
+		return nil
+	}
+	err := c.sock.Close()
+	c.sock = nil
+	return err
+}
+
+// SetEncoding sets the encoding of the messages sent to the servers, which reply in the same
+// encoding. The default is minidb.EncodingJSON, and minidb.EncodingBinary makes messages with
+// blobs considerably smaller, but is not understood by servers of older versions.
+func (c *Client) SetEncoding(enc minidb.Encoding) {
+	c.encoding = enc
+}
+
+// Ping checks that the server in use is responsive and switches to another server if it is not.
+func (c *Client) Ping() error {
+	_, err := c.Exec(minidb.PingCommand())
+	return err
+}
+
+// Exec sends a command to the server in use and returns its result. A Result whose HasError
+// field is true is returned as an error, see Result.Err. If the server fails, the client switches to the next
+// responsive server and opens the databases that have been opened before. Read commands outside
+// of transactions and Open commands are then sent again. Other commands are not repeated because
+// they might have been executed already, so an error is returned for them, just like for
+// commands of a transaction, since transactions do not survive the failure of their server.
+func (c *Client) Exec(cmd *minidb.Command) (*minidb.Result, error) {
+	result, err := c.roundTrip(cmd)
+	if err != nil {
+		if ferr := c.failover(); ferr != nil {
+			return nil, fmt.Errorf("server %s failed (%s) and %s", c.urls[c.current], err, ferr)
+		}
+		if !idempotent(cmd) {
+			return nil, fmt.Errorf("server failed, command may not have been executed: %s", err)
+		}
+		if result, err = c.roundTrip(cmd); err != nil {
+			return nil, err
+		}
+	}
+	if result.HasError {
+		return nil, result.Err()
+	}
+	switch cmd.ID {
+	case minidb.CmdOpen:
+		c.opened[minidb.CommandDB(cmd.StrArgs[1])] = cmd
+	case minidb.CmdAuth:
+		c.auth = cmd
+	}
+	return result, nil
+}
+
+// Auth authenticates the connection with an API token of the servers. The client authenticates
+// again with the token when it switches to another server.
+func (c *Client) Auth(token string) error {
+	_, err := c.Exec(minidb.AuthCommand(token))
+	return err
+}
+
+// AuthUser authenticates the connection with the name and password of a user of the multiuser
+// database of the servers, like Auth.
+func (c *Client) AuthUser(username, password string) error {
+	_, err := c.Exec(minidb.AuthUserCommand(username, password))
+	return err
+}
+
+// Hello returns the version, capabilities, and supported commands of the server in use. Servers
+// of versions without the Hello command return an error.
+func (c *Client) Hello() (*minidb.ServerInfo, error) {
+	result, err := c.Exec(minidb.HelloCommand())
+	if err != nil {
+		return nil, err
+	}
+	return minidb.ParseHello(result), nil
+}
+
+// ExecBatch sends the commands to the server in use as a batch, which the server executes in
+// order, optionally in one transaction per database, see Executor.ExecBatch. It returns the
+// results of the commands up to the first one that failed, whose error is returned as an error.
+// Unlike Exec, a batch is not sent again if the server fails.
+func (c *Client) ExecBatch(cmds []minidb.Command, inTx bool) ([]minidb.Result, error) {
+	_, results, err := c.send(nil, &minidb.Batch{Commands: cmds, Atomic: inTx})
+	if err != nil {
+		if ferr := c.failover(); ferr != nil {
+			return nil, fmt.Errorf("server %s failed (%s) and %s", c.urls[c.current], err, ferr)
+		}
+		return nil, fmt.Errorf("server failed, batch may not have been executed: %s", err)
+	}
+	for _, result := range results {
+		if result.HasError {
+			return results, result.Err()
+		}
+	}
+	return results, nil
+}
+
+// idempotent returns true if the command may be sent to a server again.
+func idempotent(cmd *minidb.Command) bool {
+	switch cmd.ID {
+	case minidb.CmdOpen, minidb.CmdPing, minidb.CmdHello, minidb.CmdListTx, minidb.CmdAuth:
+		return true
+	default:
+		return cmd.Tx == 0 && minidb.IsReadCommand(cmd.ID)
+	}
+}
+
+// roundTrip sends a command to the server in use and waits for the result.
+func (c *Client) roundTrip(cmd *minidb.Command) (*minidb.Result, error) {
+	result, _, err := c.send(cmd, nil)
+	if err != nil {
+		return nil, err
+	}
+	if result == nil {
+		return nil, errors.New("server replied with the results of a batch")
+	}
+	return result, nil
+}
+
+// send sends a command, or the batch if it is not nil, to the server in use and returns the
+// result of the command or the results of the batch.
+func (c *Client) send(cmd *minidb.Command, batch *minidb.Batch) (*minidb.Result, []minidb.Result, error) {
+	if c.sock == nil {
+		return nil, nil, ErrNoServer
+	}
+	msg, err := minidb.EncodeRequest(cmd, batch, c.encoding)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err = c.sock.Send(msg); err != nil {
+		return nil, nil, err
+	}
+	if msg, err = c.sock.Recv(); err != nil {
+		return nil, nil, err
+	}
+	return minidb.DecodeReply(msg)
+}
+
+// failover connects to the next responsive server, trying every server once, starting
+// with the one after the server in use.
+func (c *Client) failover() error {
+	c.Close()
+	for i := 1; i <= len(c.urls); i++ {
+		next := (c.current + i) % len(c.urls)
+		if err := c.connect(c.urls[next]); err != nil {
+			c.Close()
+			continue
+		}
+		c.current = next
+		return nil
+	}
+	return ErrNoServer
+}
+
+// connect connects to the server with the given URL, checks that it is responsive, authenticates
+// the connection if it has been authenticated before, and opens the databases that have been
+// opened before.
+func (c *Client) connect(url string) error {
+	sock, err := req.NewSocket()
+	if err != nil {
+		return err
+	}
+	c.sock = sock
+	// commands are only sent again by Exec, which knows which ones are safe to repeat
+	sock.SetOption(mangos.OptionRetryTime, time.Duration(0))
+	sock.SetOption(mangos.OptionSendDeadline, c.timeout)
+	sock.SetOption(mangos.OptionRecvDeadline, c.timeout)
+	if c.tls != nil && strings.HasPrefix(url, "tls+") {
+		if err := sock.SetOption(mangos.OptionTLSConfig, c.tls); err != nil {
+			return err
+		}
+	}
+	if err := sock.Dial(url); err != nil {
+		return err
+	}
+	if _, err := c.roundTrip(minidb.PingCommand()); err != nil {
+		return err
+	}
+	if c.auth != nil {
+		result, err := c.roundTrip(c.auth)
+		if err != nil {
+			return err
+		}
+		if result.HasError {
+			return errors.New(result.Str)
+		}
+	}
+	for _, cmd := range c.opened {
+		result, err := c.roundTrip(cmd)
+		if err != nil {
+			return err
+		}
+		if result.HasError {
+			return errors.New(result.Str)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,168 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	minidb "github.com/rasteric/minidb"
+	mangos "nanomsg.org/go/mangos/v2"
+	"nanomsg.org/go/mangos/v2/protocol/rep"
+)
+
+// testServer is a minimal server for a test, which executes the commands it receives and
+// records their types.
+type testServer struct {
+	url      string
+	sock     mangos.Socket
+	executor *minidb.Executor
+	mutex    sync.Mutex
+	cmds     []minidb.CommandID
+	stop     func()
+}
+
+// freeURL returns a tcp URL on localhost with a port that is not in use.
+func freeURL(t *testing.T) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("cannot find a free port: %s", err)
+	}
+	defer l.Close()
+	return fmt.Sprintf("tcp://127.0.0.1:%d", l.Addr().(*net.TCPAddr).Port)
+}
+
+// startServer starts a server on the URL that is stopped when the test ends, unless it has been
+// stopped before.
+func startServer(t *testing.T, url string) *testServer {
+	sock, err := rep.NewSocket()
+	if err != nil {
+		t.Fatalf("cannot create socket: %s", err)
+	}
+	if err = sock.Listen(url); err != nil {
+		t.Fatalf("cannot start server: %s", err)
+	}
+	s := &testServer{url: url, sock: sock, executor: minidb.NewExecutor()}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			msg, err := sock.Recv()
+			if err != nil {
+				return
+			}
+			if msg, err = s.reply(msg); err != nil {
+				t.Errorf("invalid request: %s", err)
+				return
+			}
+			if err = sock.Send(msg); err != nil {
+				return
+			}
+		}
+	}()
+	var once sync.Once
+	s.stop = func() {
+		once.Do(func() {
+			sock.Close()
+			<-done
+			s.executor.CloseAllDBs()
+		})
+	}
+	t.Cleanup(s.stop)
+	return s
+}
+
+// reply executes the command of a request and returns the reply in the encoding of the request.
+func (s *testServer) reply(msg []byte) ([]byte, error) {
+	cmd, batch, enc, err := minidb.DecodeRequest(msg)
+	if err != nil {
+		return nil, err
+	}
+	if batch != nil {
+		return minidb.EncodeReply(nil, s.executor.ExecBatch(batch.Commands, batch.Atomic), enc)
+	}
+	s.mutex.Lock()
+	s.cmds = append(s.cmds, cmd.ID)
+	s.mutex.Unlock()
+	return minidb.EncodeReply(s.executor.Exec(cmd), nil, enc)
+}
+
+// received returns true if the server has executed a command of the type.
+func (s *testServer) received(id minidb.CommandID) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for _, cmd := range s.cmds {
+		if cmd == id {
+			return true
+		}
+	}
+	return false
+}
+
+func TestFailover(t *testing.T) {
+	down := freeURL(t)
+	up := startServer(t, freeURL(t))
+	c, err := DialTimeout(time.Second, down, up.url)
+	if err != nil {
+		t.Fatalf("DialTimeout() failed: %s", err)
+	}
+	defer c.Close()
+	if c.URL() != up.url {
+		t.Errorf("the client uses %s, expected the responsive server %s", c.URL(), up.url)
+	}
+	if err := c.Ping(); err != nil {
+		t.Errorf("Ping() failed: %s", err)
+	}
+	if _, err := DialTimeout(time.Second, down); !errors.Is(err, ErrNoServer) {
+		t.Errorf("DialTimeout() without responsive server returned %v, expected ErrNoServer", err)
+	}
+}
+
+func TestRetry(t *testing.T) {
+	a := startServer(t, freeURL(t))
+	b := startServer(t, freeURL(t))
+	c, err := DialTimeout(500*time.Millisecond, a.url, b.url)
+	if err != nil {
+		t.Fatalf("DialTimeout() failed: %s", err)
+	}
+	defer c.Close()
+	if c.URL() != a.url {
+		t.Fatalf("the client uses %s, expected the first server %s", c.URL(), a.url)
+	}
+	file := filepath.Join(t.TempDir(), "test.sqlite")
+	if _, err := c.Exec(minidb.OpenCommand("sqlite3", file)); err != nil {
+		t.Fatalf("Open command failed: %s", err)
+	}
+	db := minidb.CommandDB(file)
+
+	// a write command is not sent again after a failover, since it may have been executed
+	a.stop()
+	if _, err := c.Exec(minidb.AddTableCommand(db, "Person", []minidb.Field{{Name: "Name", Sort: minidb.DBString}})); err == nil {
+		t.Errorf("AddTable command succeeded although the server failed")
+	}
+	if c.URL() != b.url {
+		t.Errorf("the client uses %s after the failure, expected %s", c.URL(), b.url)
+	}
+	if !b.received(minidb.CmdOpen) {
+		t.Errorf("the client did not open the database again on the next server")
+	}
+	if b.received(minidb.CmdAddTable) {
+		t.Errorf("the client sent the AddTable command again to the next server")
+	}
+
+	// a read command is sent again to the next server
+	a = startServer(t, a.url)
+	b.stop()
+	if _, err := c.Exec(minidb.GetTablesCommand(db)); err != nil {
+		t.Errorf("GetTables command failed after a failover: %s", err)
+	}
+	if c.URL() != a.url {
+		t.Errorf("the client uses %s after the failure, expected %s", c.URL(), a.url)
+	}
+	if !a.received(minidb.CmdGetTables) {
+		t.Errorf("the client did not send the GetTables command again to the next server")
+	}
+}
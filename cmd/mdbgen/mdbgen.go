@@ -0,0 +1,77 @@
+// The mdbgen code generator
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	minidb "github.com/rasteric/minidb"
+	kingpin "gopkg.in/alecthomas/kingpin.v2"
+)
+
+// Constants that represent numeric error codes.
+const (
+	ErrNone = iota
+	ErrCannotOpenDB
+	ErrInvalidSchema
+	ErrGenerateFailed
+	ErrIO
+)
+
+func die(errCode int, msg string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, "ERROR "+msg, args...)
+	os.Exit(errCode)
+}
+
+// readSchema returns the schema of a database file or of a JSON file written by ExportSchema.
+func readSchema(source string) *minidb.Schema {
+	if strings.HasSuffix(source, ".json") {
+		data, err := ioutil.ReadFile(source)
+		if err != nil {
+			die(ErrIO, "failed to read %s: %s\n", source, err)
+		}
+		var schema minidb.Schema
+		if err := json.Unmarshal(data, &schema); err != nil {
+			die(ErrInvalidSchema, "invalid schema in %s: %s\n", source, err)
+		}
+		return &schema
+	}
+	if _, err := os.Stat(source); err != nil {
+		die(ErrCannotOpenDB, "cannot open database %s: %s\n", source, err)
+	}
+	db, err := minidb.Open("sqlite3", source)
+	if err != nil {
+		die(ErrCannotOpenDB, "cannot open database %s: %s\n", source, err)
+	}
+	defer db.Close()
+	schema, err := db.GetSchema()
+	if err != nil {
+		die(ErrInvalidSchema, "cannot read schema of %s: %s\n", source, err)
+	}
+	return schema
+}
+
+func main() {
+	app := kingpin.New("mdbgen", "Generate Go constants and typed accessors for the tables and fields of a minidb database.")
+	source := app.Arg("source", "The database file or a JSON schema file (*.json) written by ExportSchema.").Required().String()
+	pkg := app.Flag("package", "The package name of the generated code.").Short('p').Default("models").String()
+	out := app.Flag("output", "The file to write to (omit=standard output).").Short('o').String()
+	kingpin.MustParse(app.Parse(os.Args[1:]))
+
+	schema := readSchema(*source)
+	var buff bytes.Buffer
+	if err := schema.GenerateGo(&buff, *pkg); err != nil {
+		die(ErrGenerateFailed, "failed to generate code: %s\n", err)
+	}
+	if *out == "" {
+		os.Stdout.Write(buff.Bytes())
+	} else if err := ioutil.WriteFile(*out, buff.Bytes(), 0644); err != nil {
+		die(ErrIO, "failed to write %s: %s\n", *out, err)
+	}
+	os.Exit(ErrNone)
+}
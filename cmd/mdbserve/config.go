@@ -0,0 +1,100 @@
+package main
+
+import (
+	"io/ioutil"
+	"strings"
+
+	minidb "github.com/rasteric/minidb"
+	"github.com/rasteric/minidb/server"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// config is the content of a configuration file. Its values are the defaults of the flags, so
+// flags given on the command line take precedence. An example:
+//
+//	url: tls+tcp://0.0.0.0:7873
+//	timeout: none
+//	workers: 16
+//	tx_timeout: 2m
+//	write_backlog: 128
+//	allowed_dirs: [/srv/databases]
+//	create: false
+//	tls:
+//	  cert: /etc/mdbserve/server.pem
+//	  key: /etc/mdbserve/server.key
+//	auth:
+//	  token_file: /etc/mdbserve/tokens
+//	backup:
+//	  dir: /var/backups/mdbserve
+//	  interval: 6h
+//	log:
+//	  level: info
+//	  json: true
+type config struct {
+	URL          string   `yaml:"url"`
+	Timeout      string   `yaml:"timeout"`
+	Workers      int      `yaml:"workers"`
+	TxTimeout    string   `yaml:"tx_timeout"`
+	WriteBacklog int      `yaml:"write_backlog"`
+	ReadOnly     bool     `yaml:"read_only"`
+	UsersDir     string   `yaml:"users_dir"`
+	HealthAddr   string   `yaml:"health_addr"`
+	Metrics      bool     `yaml:"metrics"`
+	AllowedDirs  []string `yaml:"allowed_dirs"`
+	Create       bool     `yaml:"create"`
+	TLS          struct {
+		Cert     string `yaml:"cert"`
+		Key      string `yaml:"key"`
+		ClientCA string `yaml:"client_ca"`
+	} `yaml:"tls"`
+	Auth struct {
+		Tokens    []string `yaml:"tokens"`
+		TokenFile string   `yaml:"token_file"`
+		Users     bool     `yaml:"users"`
+	} `yaml:"auth"`
+	Backup struct {
+		Dir      string `yaml:"dir"`
+		Interval string `yaml:"interval"`
+		Keep     int    `yaml:"keep"`
+	} `yaml:"backup"`
+	Log struct {
+		Level string `yaml:"level"`
+		JSON  bool   `yaml:"json"`
+	} `yaml:"log"`
+}
+
+// loadConfig returns the configuration in the YAML file, with the built-in defaults for the
+// values it does not set, or only the defaults if path is empty. Unknown keys are errors, so
+// that misspelled settings do not go unnoticed.
+func loadConfig(path string) (*config, error) {
+	cfg := config{Workers: server.DefaultWorkers, TxTimeout: minidb.DefaultTxTimeout.String(),
+		WriteBacklog: minidb.DefaultWriteBacklog, Create: true}
+	cfg.Backup.Interval = "1h"
+	cfg.Backup.Keep = 24
+	cfg.Log.Level = "info"
+	if path == "" {
+		return &cfg, nil
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if err = yaml.UnmarshalStrict(data, &cfg); err != nil {
+		return nil, err
+	}
+	return &cfg, nil
+}
+
+// configPath returns the value of the --config flag in the arguments. It is needed before the
+// flags are parsed, since the configuration provides their defaults.
+func configPath(args []string) string {
+	for i, arg := range args {
+		if arg == "--config" && i+1 < len(args) {
+			return args[i+1]
+		}
+		if strings.HasPrefix(arg, "--config=") {
+			return strings.TrimPrefix(arg, "--config=")
+		}
+	}
+	return ""
+}
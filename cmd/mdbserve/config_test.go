@@ -0,0 +1,89 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/rasteric/minidb/server"
+)
+
+func TestLoadConfig(t *testing.T) {
+	cfg, err := loadConfig("")
+	if err != nil {
+		t.Fatalf("loadConfig() without file failed: %s", err)
+	}
+	if cfg.Workers != server.DefaultWorkers || !cfg.Create || cfg.Backup.Interval != "1h" || cfg.Backup.Keep != 24 ||
+		cfg.Log.Level != "info" {
+		t.Errorf("loadConfig() without file returned %+v, expected the defaults", cfg)
+	}
+
+	dir, err := ioutil.TempDir("", "mdbserve-config-testing-*")
+	if err != nil {
+		t.Fatalf("could not create temporary directory for testing")
+	}
+	defer os.RemoveAll(dir)
+	write := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("could not write configuration file: %s", err)
+		}
+		return path
+	}
+	path := write("good.yaml", `url: tcp://127.0.0.1:7873
+workers: 4
+allowed_dirs: [/srv/a, /srv/b]
+create: false
+auth:
+  tokens: [secret1, secret2]
+tls:
+  cert: /etc/mdbserve/server.pem
+backup:
+  interval: 6h
+`)
+	cfg, err = loadConfig(path)
+	if err != nil {
+		t.Fatalf("loadConfig() failed: %s", err)
+	}
+	if cfg.URL != "tcp://127.0.0.1:7873" || cfg.Workers != 4 || len(cfg.Auth.Tokens) != 2 || cfg.Auth.Tokens[1] != "secret2" ||
+		len(cfg.AllowedDirs) != 2 || cfg.AllowedDirs[1] != "/srv/b" || cfg.Create ||
+		cfg.TLS.Cert != "/etc/mdbserve/server.pem" || cfg.Backup.Interval != "6h" {
+		t.Errorf("loadConfig() returned %+v", cfg)
+	}
+	// values the file does not set keep their defaults
+	if cfg.Backup.Keep != 24 || cfg.Log.Level != "info" || cfg.WriteBacklog == 0 {
+		t.Errorf("loadConfig() lost the defaults: %+v", cfg)
+	}
+
+	for name, content := range map[string]string{
+		"misspelled.yaml": "worker: 4\n",
+		"nested.yaml":     "tls:\n  certificate: /etc/mdbserve/server.pem\n",
+		"type.yaml":       "workers: many\n",
+	} {
+		if _, err := loadConfig(write(name, content)); err == nil {
+			t.Errorf("loadConfig() accepted %s: %q", name, content)
+		}
+	}
+	if _, err := loadConfig(filepath.Join(dir, "missing.yaml")); err == nil {
+		t.Errorf("loadConfig() accepted a file that does not exist")
+	}
+}
+
+func TestConfigPath(t *testing.T) {
+	tests := []struct {
+		args     []string
+		expected string
+	}{
+		{[]string{}, ""},
+		{[]string{"--config", "/etc/mdbserve.yaml"}, "/etc/mdbserve.yaml"},
+		{[]string{"--workers", "4", "--config=/etc/mdbserve.yaml"}, "/etc/mdbserve.yaml"},
+		{[]string{"--config"}, ""},
+		{[]string{"--configuration", "/etc/mdbserve.yaml"}, ""},
+	}
+	for _, test := range tests {
+		if path := configPath(test.args); path != test.expected {
+			t.Errorf("configPath(%q) returned %q, expected %q", test.args, path, test.expected)
+		}
+	}
+}
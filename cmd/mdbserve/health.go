@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	minidb "github.com/rasteric/minidb"
+)
+
+// health is the reply of the health check endpoint.
+type health struct {
+	Status  string `json:"status"`
+	Uptime  int64  `json:"uptime_ms"`
+	Version int    `json:"version"`
+}
+
+// serveHealth answers GET requests for /healthz on the address with the state of the executor
+// until the context is done. Orchestration systems can probe the server this way without
+// speaking its protocol. If metrics is true, the statistics of the executor are also served at
+// /metrics in the text format of Prometheus.
+func serveHealth(ctx context.Context, addr string, metrics bool, executor *minidb.Executor, ch chan errmsg) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, req *http.Request) {
+		r := executor.Exec(minidb.PingCommand())
+		if r.HasError {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			json.NewEncoder(w).Encode(health{Status: r.Str})
+			return
+		}
+		info := minidb.ParsePing(r)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(health{Status: "ok", Uptime: info.Uptime.Milliseconds(), Version: info.Version})
+	})
+	if metrics {
+		mux.HandleFunc("/metrics", func(w http.ResponseWriter, req *http.Request) {
+			w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+			executor.Stats().WritePrometheus(w)
+		})
+	}
+	srv := &http.Server{Addr: addr, Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		<-ctx.Done()
+		srv.Close()
+	}()
+	if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		report(ch, ErrListen, "can't serve health checks, "+err.Error())
+	}
+}
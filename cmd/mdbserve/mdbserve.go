@@ -4,21 +4,18 @@ package main
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
+	"io/ioutil"
 	"os"
+	"os/signal"
 	"strconv"
 	"strings"
+	"syscall"
 	"time"
 
 	minidb "github.com/rasteric/minidb"
+	"github.com/rasteric/minidb/server"
 	kingpin "gopkg.in/alecthomas/kingpin.v2"
-
-	"nanomsg.org/go/mangos/v2"
-	"nanomsg.org/go/mangos/v2/protocol/rep"
-
-	// register transports
-	_ "nanomsg.org/go/mangos/v2/transport/all"
 )
 
 // Constants used for indicating different types of network or I/O errors.
@@ -32,6 +29,9 @@ const (
 	ErrUnmarshal
 	ErrMarshal
 	ErrSendIO
+	ErrBackup
+	ErrMultiDB
+	ErrAuth
 )
 
 type errmsg struct {
@@ -39,65 +39,138 @@ type errmsg struct {
 	msg    string
 }
 
-// ServerLoop starts the main server loop, listening for incoming client connections.
-func serverLoop(ctx context.Context, url string, ch chan errmsg, timeout time.Duration) {
-	var sock mangos.Socket
-	var err error
-	var msg []byte
-	if sock, err = rep.NewSocket(); err != nil {
-		ch <- errmsg{ErrNoSocket, fmt.Sprintf("can't get new socket, %s", err)}
-		return
-	}
-	defer sock.Close()
-	if err = sock.Listen(url); err != nil {
-		ch <- errmsg{ErrListen, fmt.Sprintf("can't listen, %s", err.Error())}
-		return
-	}
-	//	sock.SetOption(mangos.OptionRecvDeadline, timeout)
-	//	sock.SetOption(mangos.OptionSendDeadline, timeout)
-	// server loop
-	for {
-		msg, err = sock.Recv()
-		if err != nil {
-			ch <- errmsg{ErrRecv, fmt.Sprintf("i/o error, %s", err.Error())}
-		}
-		cmd := minidb.Command{}
-		err := json.Unmarshal(msg, &cmd)
-		if err != nil {
-			ch <- errmsg{ErrUnmarshal, fmt.Sprintf("unmarshal command failed, %s", err.Error())}
-		}
-		reply := minidb.Exec(&cmd)
-		msg, err = json.Marshal(reply)
-		if err != nil {
-			ch <- errmsg{ErrMarshal, fmt.Sprintf("marshal reply failed, %s", err.Error())}
+// serverOptions configure the executor and the transport of the server.
+type serverOptions struct {
+	backups   *minidb.BackupSchedule
+	usersDir  string
+	txTimeout time.Duration
+	backlog   int
+	dirs      []string
+	create    bool
+	readOnly  bool
+	tokens    []string
+	authUsers bool
+	health    string
+	metrics   bool
+	transport server.Options
+}
+
+// requestErrors are the error numbers of the steps of a request, see server.RequestError.
+var requestErrors = map[string]int{
+	server.OpReceive: ErrRecv, server.OpDecode: ErrUnmarshal, server.OpEncode: ErrMarshal, server.OpSend: ErrSendIO,
+}
+
+// report passes an error to main unless another error is being reported already.
+func report(ch chan errmsg, number int, msg string) {
+	select {
+	case ch <- errmsg{number, msg}:
+	default:
+	}
+}
+
+// ServerLoop starts the main server loop, listening for incoming client connections. It returns
+// once the context is done or a client has shut down the server, after all databases have been
+// closed, and reports errors of requests on ch.
+func serverLoop(ctx context.Context, url string, ch chan errmsg, opts serverOptions) errmsg {
+	opts.transport.OnError = func(err error) {
+		number := ErrServerFail
+		if rerr, ok := err.(*server.RequestError); ok {
+			number = requestErrors[rerr.Op]
 		}
-		err = sock.Send(msg)
+		report(ch, number, err.Error())
+	}
+	srv, err := server.Listen(url, opts.transport)
+	if err != nil {
+		return errmsg{ErrListen, err.Error()}
+	}
+	executor := minidb.NewExecutor()
+	defer executor.CloseAllDBs()
+	if err = executor.SetBackupSchedule(opts.backups); err != nil {
+		srv.Close()
+		return errmsg{ErrBackup, fmt.Sprintf("can't schedule backups, %s", err.Error())}
+	}
+	executor.SetTxTimeout(opts.txTimeout)
+	executor.SetWriteBacklog(opts.backlog)
+	executor.SetAllowCreate(opts.create)
+	if err = executor.SetAllowedDirs(opts.dirs...); err != nil {
+		srv.Close()
+		return errmsg{ErrSyntaxError, err.Error()}
+	}
+	executor.SetReadOnly(opts.readOnly)
+	executor.SetAuthTokens(opts.tokens...)
+	executor.SetUserAuth(opts.authUsers)
+	if opts.usersDir != "" {
+		users, err := minidb.NewMultiDB(opts.usersDir, "sqlite3")
 		if err != nil {
-			ch <- errmsg{ErrSendIO, fmt.Sprintf("can't send reply, %s", err.Error())}
-		}
-		select {
-		case <-ctx.Done():
-			sock.Close()
-			minidb.CloseAllDBs()
-		default:
-			// do nothing
+			srv.Close()
+			return errmsg{ErrMultiDB, fmt.Sprintf("can't open multiuser database, %s", err.Error())}
 		}
+		defer users.Close()
+		executor.SetMultiDB(users)
+	}
+	if opts.health != "" {
+		healthCtx, stopHealth := context.WithCancel(ctx)
+		defer stopHealth()
+		go serveHealth(healthCtx, opts.health, opts.metrics, executor, ch)
+	}
+	if err = srv.Serve(ctx, executor); err != nil {
+		return errmsg{ErrNoSocket, err.Error()}
 	}
+	return errmsg{}
 }
 
 func main() {
 	// parse the command line
 
+	cfg, err := loadConfig(configPath(os.Args[1:]))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "can't read the configuration file, %s\n", err)
+		os.Exit(ErrSyntaxError)
+	}
+
 	app := kingpin.New("mdbserve", "Minidb command line server tool.")
 	//	debug := app.Flag("debug", "Enable debug mode.").Bool()
+	app.Flag("config", "A YAML file with the defaults of the flags, which flags on the command line override.").String()
 	timeout := app.Command("timeout", "Specify how long the server process is kept alive.")
-	timeoutValue := timeout.Arg("value", "The timeout value in seconds, or 'none' to keep running until a ServerQuit command is received.").Required().String()
-	url := app.Flag("url", "A custom url to listen to. If this is not provided, tcp//localhost:7873 is used.").String()
+	timeoutArg := timeout.Arg("value", "The timeout value in seconds, or 'none' to keep running until a ServerShutdown command or SIGTERM is received.")
+	if cfg.Timeout != "" {
+		timeout.Default()
+		timeoutArg.Default(cfg.Timeout)
+	} else {
+		timeoutArg.Required()
+	}
+	timeoutValue := timeoutArg.String()
+	url := app.Flag("url", "A custom url to listen to. If this is not provided, tcp://localhost:7873 is used, or tls+tcp://0.0.0.0:7873 with a TLS certificate.").Default(cfg.URL).String()
+	tlsCert := app.Flag("tls-cert", "A PEM file with the TLS certificate of the server, which then listens on a tls+tcp url.").Default(cfg.TLS.Cert).String()
+	tlsKey := app.Flag("tls-key", "A PEM file with the private key of the TLS certificate.").Default(cfg.TLS.Key).String()
+	tlsClientCA := app.Flag("tls-client-ca", "A PEM file with the certificate authorities of client certificates. If this is provided, clients must present a certificate signed by one of them.").Default(cfg.TLS.ClientCA).String()
+	backupDir := app.Flag("backup-dir", "A directory to which backups of the open databases are written regularly. If this is not provided, no backups are written.").Default(cfg.Backup.Dir).String()
+	backupInterval := app.Flag("backup-interval", "The time between two backups, e.g. 30m or 6h.").Default(cfg.Backup.Interval).Duration()
+	backupKeep := app.Flag("backup-keep", "The number of backups of each database that are kept (0=all).").Default(strconv.Itoa(cfg.Backup.Keep)).Int()
+	txTimeout := app.Flag("tx-timeout", "The time after which a transaction without commands is rolled back (0=never).").Default(cfg.TxTimeout).Duration()
+	writeBacklog := app.Flag("write-backlog", "The number of writes that may wait for execution per database before further writes are rejected (0=no queue).").Default(strconv.Itoa(cfg.WriteBacklog)).Int()
+	readOnly := app.Flag("read-only", "Reject all commands that may change a database, for example for a reporting endpoint.").Default(strconv.FormatBool(cfg.ReadOnly)).Bool()
+	workers := app.Flag("workers", "The number of requests that are handled at the same time.").Default(strconv.Itoa(cfg.Workers)).Int()
+	usersDir := app.Flag("users-dir", "A directory with the databases of users that clients may create and log in to. If this is not provided, the multiuser commands fail.").Default(cfg.UsersDir).String()
+	authTokens := app.Flag("auth-token", "An API token that clients must authenticate with before other commands are accepted. May be given several times.").Default(cfg.Auth.Tokens...).Strings()
+	authTokenFile := app.Flag("auth-token-file", "A file with one API token per line, like --auth-token, which keeps the tokens out of the process list.").Default(cfg.Auth.TokenFile).String()
+	healthAddr := app.Flag("health-addr", "An address such as :8080 on which GET /healthz is answered with the uptime of the server, for orchestration systems. If this is not provided, there is no health check endpoint.").Default(cfg.HealthAddr).String()
+	metrics := app.Flag("metrics", "Also serve the statistics of the commands at /metrics on --health-addr, in the text format of Prometheus.").Default(strconv.FormatBool(cfg.Metrics)).Bool()
+	logLevel := app.Flag("log-level", "The lowest level of the messages that are logged to standard error: debug logs every command, info the failed commands.").Default(cfg.Log.Level).Enum("debug", "info", "warn", "error")
+	logJSON := app.Flag("log-json", "Log one JSON object per line instead of text.").Default(strconv.FormatBool(cfg.Log.JSON)).Bool()
+	allowedDirs := app.Flag("allow-dir", "A directory in which clients may open and back up databases, including its subdirectories. May be given several times. If this is not provided, clients may open databases anywhere.").Default(cfg.AllowedDirs...).Strings()
+	create := app.Flag("create", "Let clients create new database files, which --no-create forbids.").Default(strconv.FormatBool(cfg.Create)).Bool()
+	authUsers := app.Flag("auth-users", "Clients must authenticate as a user of the multiuser database in --users-dir before other commands are accepted.").Default(strconv.FormatBool(cfg.Auth.Users)).Bool()
 
 	command := kingpin.MustParse(app.Parse(os.Args[1:]))
 
 	var tmax int
-	var err error
+	level, err := server.ParseLevel(*logLevel)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "syntax error: %s\n", err)
+		os.Exit(ErrSyntaxError)
+	}
+	logger := server.NewLogger(os.Stderr, level, *logJSON)
 	switch command {
 	case timeout.FullCommand():
 		if strings.ToLower(*timeoutValue) == "none" {
@@ -105,40 +178,94 @@ func main() {
 		} else {
 			tmax, err = strconv.Atoi(*timeoutValue)
 			if err != nil || tmax < 0 {
-				fmt.Fprintf(os.Stderr, "syntax error: timeout value must be a positive number!\n")
-				os.Exit(ErrSyntaxError)
+				fatal(logger, ErrSyntaxError, "syntax error: timeout value must be a positive number")
+			}
+		}
+	}
+	transport := server.Options{Workers: *workers, Logger: logger}
+	if *tlsCert != "" {
+		transport.TLS, err = server.TLSConfig(*tlsCert, *tlsKey, *tlsClientCA)
+		if err != nil {
+			fatal(logger, ErrSyntaxError, "invalid TLS configuration", server.Field{Key: "error", Value: err})
+		}
+	}
+	tokens := *authTokens
+	if *authTokenFile != "" {
+		data, err := ioutil.ReadFile(*authTokenFile)
+		if err != nil {
+			fatal(logger, ErrAuth, "can't read the token file", server.Field{Key: "error", Value: err})
+		}
+		for _, line := range strings.Split(string(data), "\n") {
+			if token := strings.TrimSpace(line); token != "" {
+				tokens = append(tokens, token)
 			}
 		}
 	}
+	if *authUsers && *usersDir == "" {
+		fatal(logger, ErrSyntaxError, "syntax error: --auth-users needs --users-dir")
+	}
 	theURL := ""
-	if url == nil || *url == "" {
-		theURL = "tcp://0.0.0.0:7873"
-	} else {
+	switch {
+	case url != nil && *url != "":
 		theURL = *url
+	case transport.TLS != nil:
+		theURL = "tls+tcp://0.0.0.0:7873"
+	default:
+		// plain connections are only accepted from the local host
+		theURL = "tcp://127.0.0.1:7873"
 	}
 
 	// Start the server loop
 
-	// context for timeout handling of server loop in main and serverloop
+	// the server loop stops when the context is cancelled, after the timeout, on SIGTERM or
+	// SIGINT, or if a request fails, and also if a client sends a ServerShutdown command
 	var ctx, cancel = context.WithCancel(context.Background())
+	defer cancel()
 	var ch = make(chan errmsg, 1)
-	var msg errmsg
+	var stopped = make(chan errmsg, 1)
+	var signals = make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, os.Interrupt)
 
-	go serverLoop(ctx, theURL, ch, time.Duration(tmax)*time.Second)
-	defer cancel()
+	var backups *minidb.BackupSchedule
+	if *backupDir != "" {
+		backups = &minidb.BackupSchedule{Dir: *backupDir, Interval: *backupInterval, Keep: *backupKeep}
+	}
 
-	done := false
-	for done == false {
-		select {
-		case msg := <-ch:
-			fmt.Fprintf(os.Stderr, "%s", msg.msg)
-			done = true
-		case <-time.After(time.Duration(tmax) * time.Second):
-			if tmax > 0 {
-				done = true
-			}
-		}
+	opts := serverOptions{backups: backups, usersDir: *usersDir, txTimeout: *txTimeout, backlog: *writeBacklog,
+		dirs: *allowedDirs, create: *create, readOnly: *readOnly, tokens: tokens, authUsers: *authUsers,
+		health: *healthAddr, metrics: *metrics, transport: transport}
+	logger.Log(server.LevelInfo, "listening", server.Field{Key: "url", Value: theURL})
+	go func() {
+		stopped <- serverLoop(ctx, theURL, ch, opts)
+	}()
+
+	var expired <-chan time.Time
+	if tmax > 0 {
+		expired = time.After(time.Duration(tmax) * time.Second)
+	}
+	var msg errmsg
+	select {
+	case msg = <-stopped:
+	case msg = <-ch:
+		cancel()
+		<-stopped
+	case <-expired:
+		logger.Log(server.LevelInfo, "timeout expired, shutting down")
+		cancel()
+		msg = <-stopped
+	case sig := <-signals:
+		logger.Log(server.LevelInfo, "shutting down", server.Field{Key: "signal", Value: sig.String()})
+		cancel()
+		msg = <-stopped
 	}
-	cancel()
-	os.Exit(msg.number)
+	if msg.number != 0 {
+		fatal(logger, msg.number, msg.msg)
+	}
+	os.Exit(0)
+}
+
+// fatal logs the error and exits with its number.
+func fatal(logger server.Logger, number int, msg string, fields ...server.Field) {
+	logger.Log(server.LevelError, msg, append(fields, server.Field{Key: "code", Value: number})...)
+	os.Exit(number)
 }
@@ -3,10 +3,10 @@
 package main
 
 import (
+	"crypto/tls"
 	"encoding/base64"
-	"encoding/json"
-	"errors"
 	"fmt"
+	"io/ioutil"
 	"os"
 	"os/exec"
 	"strconv"
@@ -14,12 +14,8 @@ import (
 	"time"
 
 	minidb "github.com/rasteric/minidb"
+	"github.com/rasteric/minidb/client"
 	kingpin "gopkg.in/alecthomas/kingpin.v2"
-	mangos "nanomsg.org/go/mangos/v2"
-	"nanomsg.org/go/mangos/v2/protocol/req"
-
-	// register transports
-	_ "nanomsg.org/go/mangos/v2/transport/all"
 )
 
 // Constants that represent numeric error codes.
@@ -46,29 +42,45 @@ const (
 	ErrIO
 	ErrRemoveFailed
 	ErrIndexFailed
+	ErrRenameTableFailed
+	ErrDropTableFailed
+	ErrReindexFailed
+	ErrExportKVFailed
+	ErrImportKVFailed
+	ErrLintFailed
+	ErrEnableTimestampsFailed
+	ErrCloneFailed
+	ErrNormalizeListsFailed
+	ErrCompactFailed
+	ErrDumpFailed
+	ErrPurgeExpiredFailed
+	ErrTLSConfig
+	ErrAuthFailed
+	ErrShutdownFailed
+	ErrStatsFailed
 )
 
-func sendCommand(sock mangos.Socket, cmd *minidb.Command) (*minidb.Result, error) {
-	msg, err := json.Marshal(&cmd)
-	if err != nil {
-		return nil, err
-	}
-	err = sock.Send(msg)
-	if err != nil {
-		return nil, err
-	}
-	if msg, err = sock.Recv(); err != nil {
-		return nil, err
+func sendCommand(conn *client.Client, cmd *minidb.Command) (*minidb.Result, error) {
+	return conn.Exec(cmd)
+}
+
+func sendBatch(conn *client.Client, inTx bool, cmds ...*minidb.Command) ([]minidb.Result, error) {
+	batch := make([]minidb.Command, len(cmds))
+	for i, cmd := range cmds {
+		batch[i] = *cmd
 	}
-	reply := minidb.Result{}
-	err = json.Unmarshal(msg, &reply)
+	return conn.ExecBatch(batch, inTx)
+}
+
+// execInTx sends the command returned by makeCmd as a batch that the server executes within
+// a new transaction, which is committed if the command succeeds and rolled back otherwise.
+// The transaction is begun by the server, so makeCmd is called with transaction 0.
+func execInTx(conn *client.Client, db minidb.CommandDB, makeCmd func(tx minidb.TxID) *minidb.Command) (*minidb.Result, error) {
+	results, err := sendBatch(conn, true, makeCmd(0))
 	if err != nil {
 		return nil, err
 	}
-	if reply.HasError {
-		return nil, errors.New(reply.Str)
-	}
-	return &reply, nil
+	return &results[0], nil
 }
 
 func printItems(items []minidb.Item) {
@@ -85,6 +97,15 @@ func printItems(items []minidb.Item) {
 	fmt.Printf("%s\n", s)
 }
 
+// intKey returns a numeric key of the key-value store given on the command line.
+func intKey(key string) int64 {
+	n, err := strconv.ParseInt(key, 10, 64)
+	if err != nil {
+		die(ErrSyntaxError, "syntax error - the key '%s' is not numeric, use --string-keys for string keys.\n", key)
+	}
+	return n
+}
+
 func toItems(v []int64) []minidb.Item {
 	items := make([]minidb.Item, 0, len(v))
 	for _, n := range v {
@@ -124,9 +145,13 @@ func main() {
 	setField := set.Arg("field", "The field whose value to set.").Required().String()
 	setValues := set.Arg("values", "The value to set (values in case of a list field).").Required().Strings()
 
-	remove := app.Command("remove", "Remove an item.")
-	removeTable := remove.Arg("table", "The table to which the item belongs.").Required().String()
-	removeItem := remove.Arg("item", "The item to remove.").Required().Int64()
+	remove := app.Command("remove", "Remove one or more items.")
+	removeTable := remove.Arg("table", "The table to which the items belong.").Required().String()
+	removeItems := remove.Arg("items", "The items to remove.").Required().Int64List()
+
+	clone := app.Command("clone", "Create a copy of an item and print the new item.")
+	cloneTable := clone.Arg("table", "The table to which the item belongs.").Required().String()
+	cloneItem := clone.Arg("item", "The item to copy.").Required().Int64()
 
 	count := app.Command("count", "Count the number of items in a table.")
 	countTable := count.Arg("table", "The table whose items are to be counted.").Required().String()
@@ -147,40 +172,58 @@ func main() {
 
 	serverTimeout := app.Flag("keep-up", "Time in seconds to keep the database server running before it needs to be restarted. Use 'forever' to keep it running. The default value is 300 (5 minutes).").String()
 	serverExecutable := app.Flag("server", "Path to the minidb-server executable.").String()
-	serverURL := app.Flag("connection", "Mangos-compatible transport URL to connect to the server executable. If this is not provided, tcp://localhost:7873 is used.").String()
+	serverURL := app.Flag("connection", "Mangos-compatible transport URL to connect to the server executable. Several URLs separated by commas may be given, then the next responsive server is used if one fails. If this is not provided, tcp://localhost:7873 is used.").String()
 	serverConnectTrials := app.Flag("connection-trials", "Number of times minidb tries to connect to the database server process before it gives up.").Int32()
+	binaryEncoding := app.Flag("binary", "Exchange messages with the server in a binary encoding, which is smaller than JSON for blobs.").Bool()
+	tlsCA := app.Flag("tls-ca", "PEM file with the certificate authorities that signed the certificate of the server, for tls+tcp connections. The certificate authorities of the system are used if this is not provided.").String()
+	tlsCert := app.Flag("tls-cert", "PEM file with the client certificate for tls+tcp connections to servers that require one.").String()
+	tlsKey := app.Flag("tls-key", "PEM file with the key of the client certificate.").String()
+	authToken := app.Flag("auth-token", "API token to authenticate with servers that require authentication.").Envar("MINIDB_AUTH_TOKEN").String()
+	authUser := app.Flag("auth-user", "Name of a user to authenticate with servers that require authentication. The password is read from MINIDB_AUTH_PASSWORD.").String()
 
 	// key-value store command line parameters
+	stringKeys := app.Flag("string-keys", "Use string keys instead of numeric keys in the key-value store commands. Float values only have numeric keys.").Bool()
 	fetchInt := app.Command("get-int", "Fetch an integer from the key-value store.")
-	fetchIntKey := fetchInt.Arg("key", "The numeric key.").Required().Int64()
+	fetchIntKey := fetchInt.Arg("key", "The key, which is numeric unless --string-keys is given.").Required().String()
 	fetchStr := app.Command("get-str", "Fetch a string value from the key-value store.")
-	fetchStrKey := fetchStr.Arg("key", "The numeric key.").Required().Int64()
+	fetchStrKey := fetchStr.Arg("key", "The key, which is numeric unless --string-keys is given.").Required().String()
 	fetchBlob := app.Command("get-blob", "Fetch a blob value from the key-value store.")
-	fetchBlobKey := fetchBlob.Arg("key", "The numeric key.").Required().Int64()
+	fetchBlobKey := fetchBlob.Arg("key", "The key, which is numeric unless --string-keys is given.").Required().String()
 	fetchDate := app.Command("get-date", "Fetch a date value from the key-value store.")
-	fetchDateKey := fetchDate.Arg("key", "The numeric key.").Required().Int64()
+	fetchDateKey := fetchDate.Arg("key", "The key, which is numeric unless --string-keys is given.").Required().String()
 
 	putInt := app.Command("set-int", "Put an integer into the key-value store.")
-	putIntKey := putInt.Arg("key", "The numeric key.").Required().Int64()
+	putIntKey := putInt.Arg("key", "The key, which is numeric unless --string-keys is given.").Required().String()
 	putIntVal := putInt.Arg("value", "The integer value to store.").Required().Int64()
 	putStr := app.Command("set-str", "Put a string into the key-value store.")
-	putStrKey := putStr.Arg("key", "The numeric key.").Required().Int64()
+	putStrKey := putStr.Arg("key", "The key, which is numeric unless --string-keys is given.").Required().String()
 	putStrVal := putStr.Arg("value", "The string value to store.").Required().String()
 	putBlob := app.Command("set-blob", "Put a string into the key-value store.")
-	putBlobKey := putBlob.Arg("key", "The numeric key.").Required().Int64()
+	putBlobKey := putBlob.Arg("key", "The key, which is numeric unless --string-keys is given.").Required().String()
 	putBlobVal := putBlob.Arg("value", "The blob value to store as base64 encoded string.").Required().String()
 	putDate := app.Command("set-date", "Put a date into the key-value store.")
-	putDateKey := putDate.Arg("key", "The numeric key.").Required().Int64()
+	putDateKey := putDate.Arg("key", "The key, which is numeric unless --string-keys is given.").Required().String()
 	putDateVal := putDate.Arg("value", "The date value to store as RFC3339 datetime string.").Required().String()
 
+	fetchFloat := app.Command("get-float", "Fetch a float value from the key-value store.")
+	fetchFloatKey := fetchFloat.Arg("key", "The numeric key.").Required().Int64()
+	putFloat := app.Command("set-float", "Put a float into the key-value store.")
+	putFloatKey := putFloat.Arg("key", "The numeric key.").Required().Int64()
+	putFloatVal := putFloat.Arg("value", "The float value to store.").Required().String()
+	hasFloat := app.Command("has-float", "Return 0 (true) if a float value is stored under that key, 1 (false) otherwise.")
+	hasFloatKey := hasFloat.Arg("key", "The numeric key.").Required().Int64()
+	listFloat := app.Command("list-float", "Return a list of all keys for float values in the key-value store.")
+	deleteFloat := app.Command("delete-float", "Delete a float value from the key-value store.")
+	deleteFloatKey := deleteFloat.Arg("key", "The numeric key.").Required().Int64()
+
 	hasInt := app.Command("has-int", "Return 0 (true) if an integer value is stored under that key, 1 (false) otherwise.")
-	hasIntKey := hasInt.Arg("key", "The numeric key.").Required().Int64()
+	hasIntKey := hasInt.Arg("key", "The key, which is numeric unless --string-keys is given.").Required().String()
 	hasStr := app.Command("has-str", "Return 0 (true) if a string value is stored under that key, 1 (false) otherwise.")
-	hasStrKey := hasStr.Arg("key", "The numeric key.").Required().Int64()
+	hasStrKey := hasStr.Arg("key", "The key, which is numeric unless --string-keys is given.").Required().String()
 	hasBlob := app.Command("has-blob", "Return 0 (true) if a blob value is stored under that key, 1 (false) otherwise.")
-	hasBlobKey := hasBlob.Arg("key", "The numeric key.").Required().Int64()
+	hasBlobKey := hasBlob.Arg("key", "The key, which is numeric unless --string-keys is given.").Required().String()
 	hasDate := app.Command("has-date", "Return 0 (true) if a date value is stored under that key, 1 (false) otherwise.")
-	hasDateKey := hasDate.Arg("key", "The numeric key.").Required().Int64()
+	hasDateKey := hasDate.Arg("key", "The key, which is numeric unless --string-keys is given.").Required().String()
 
 	listInt := app.Command("list-int", "Return a list of all keys for integer values in the key-value store.")
 	listStr := app.Command("list-str", "Return a list of all keys for string values in the key-value store.")
@@ -188,18 +231,47 @@ func main() {
 	listDate := app.Command("list-date", "Return a list of all keys for date values in the key-value store.")
 
 	deleteInt := app.Command("delete-int", "Delete an integer from the key-value store.")
-	deleteIntKey := deleteInt.Arg("key", "The numeric key.").Required().Int64()
+	deleteIntKey := deleteInt.Arg("key", "The key, which is numeric unless --string-keys is given.").Required().String()
 	deleteStr := app.Command("delete-str", "Delete a string value from the key-value store.")
-	deleteStrKey := deleteStr.Arg("key", "The numeric key.").Required().Int64()
+	deleteStrKey := deleteStr.Arg("key", "The key, which is numeric unless --string-keys is given.").Required().String()
 	deleteBlob := app.Command("delete-blob", "Delete a blob value from the key-value store.")
-	deleteBlobKey := deleteBlob.Arg("key", "The numeric key.").Required().Int64()
+	deleteBlobKey := deleteBlob.Arg("key", "The key, which is numeric unless --string-keys is given.").Required().String()
 	deleteDate := app.Command("delete-date", "Delete a date value from the key-value store.")
-	deleteDateKey := deleteDate.Arg("key", "The numeric key.").Required().Int64()
+	deleteDateKey := deleteDate.Arg("key", "The key, which is numeric unless --string-keys is given.").Required().String()
+
+	exportKV := app.Command("export-kv", "Write the contents of the key-value store as JSON to a file or to standard output.")
+	exportKVFile := exportKV.Arg("file", "The file to write to (omit=standard output).").String()
+	importKV := app.Command("import-kv", "Read key-value pairs from a JSON file written by export-kv, overwriting existing values with the same keys.")
+	importKVFile := importKV.Arg("file", "The file to read.").Required().String()
 
 	index := app.Command("index", "Index a field in a table for faster string queries. If the index already exists, nothing is changed")
 	indexTable := index.Arg("table", "The table in which a field is to be indexed.").Required().String()
 	indexField := index.Arg("field", "The field of the table to index.").Required().String()
 
+	renameTable := app.Command("rename-table", "Rename a table.")
+	renameTableOld := renameTable.Arg("table", "The table to rename.").Required().String()
+	renameTableNew := renameTable.Arg("name", "The new name of the table.").Required().String()
+
+	dropTable := app.Command("drop-table", "Remove a table with all of its items. This cannot be undone.")
+	dropTableName := dropTable.Arg("table", "The table to remove.").Required().String()
+
+	reindex := app.Command("reindex", "Drop and recreate all indices of a table.")
+	reindexTable := reindex.Arg("table", "The table whose indices are rebuilt.").Required().String()
+
+	timestamps := app.Command("timestamps", "Let minidb maintain the _Created and _Modified date fields of the items of a table.")
+	timestampsTable := timestamps.Arg("table", "The table whose items get timestamps.").Required().String()
+
+	lint := app.Command("lint", "Examine the data in all tables and print suggestions for improving their fields.")
+
+	normalizeLists := app.Command("normalize-lists", "Store the values of all list fields in a single table instead of one table per field. This cannot be undone.")
+
+	compact := app.Command("compact", "Reclaim the space of removed data and update the statistics used for queries, and print the number of bytes reclaimed.")
+	dump := app.Command("dump", "Write the database as SQL statements that rebuild it with the sqlite3 shell to a file or to standard output.")
+	dumpFile := dump.Arg("file", "The file to write to (omit=standard output).").String()
+	purgeExpired := app.Command("purge-expired", "Remove the expired values from the key-value store and print their number.")
+	serverStats := app.Command("server-stats", "Print the number, failures, and durations of the commands executed by the server in the text format of Prometheus.")
+	shutdown := app.Command("shutdown-server", "Stop the server after it has answered the requests it is handling and closed all databases.")
+
 	command := kingpin.MustParse(app.Parse(os.Args[1:]))
 
 	var keepUp int = 300
@@ -250,34 +322,48 @@ func main() {
 	}
 
 	// open the connection
-	var sock mangos.Socket
-	if sock, err = req.NewSocket(); err != nil {
-		die(ErrNoSocket, "cannot get a socket to connect to server: %s.\n", err)
-	}
-	defer sock.Close()
 	if *serverURL == "" {
 		*serverURL = "tcp://localhost:7873"
 	}
+	var tlsConfig *tls.Config
+	if *tlsCA != "" || *tlsCert != "" || *tlsKey != "" || strings.Contains(*serverURL, "tls+") {
+		if tlsConfig, err = client.TLSConfig(*tlsCA, *tlsCert, *tlsKey); err != nil {
+			die(ErrTLSConfig, "invalid TLS configuration: %s.\n", err)
+		}
+	}
 	// we try dialing several times before giving up
+	var conn *client.Client
 	var c int32
-	success := false
 	for c < connectTrials {
-		sock.SetOption(mangos.OptionReconnectTime, 10)
-		sock.SetOption(mangos.OptionMaxReconnectTime, 100)
-		if err = sock.Dial(*serverURL); err == nil {
-			success = true
+		if conn, err = client.DialTLS(tlsConfig, client.DefaultTimeout, strings.Split(*serverURL, ",")...); err == nil {
 			break
 		}
 		c++
 		time.Sleep(500 * time.Millisecond)
 	}
-	if !success {
+	if conn == nil {
 		die(ErrNoConnection, "cannot connect to server executable: %s.\n", err)
 	}
+	defer conn.Close()
+	switch {
+	case *authToken != "":
+		err = conn.Auth(*authToken)
+	case *authUser != "":
+		err = conn.AuthUser(*authUser, os.Getenv("MINIDB_AUTH_PASSWORD"))
+	}
+	if err != nil {
+		die(ErrAuthFailed, "could not authenticate: %s\n", err)
+	}
+	if *binaryEncoding {
+		// older servers do not understand the binary encoding
+		if info, err := conn.Hello(); err == nil && info.HasCapability(minidb.CapabilityBinary) {
+			conn.SetEncoding(minidb.EncodingBinary)
+		}
+	}
 
 	// connection established, now send the open command
 	var result *minidb.Result
-	if result, err = sendCommand(sock, minidb.OpenCommand("sqlite3", *dbfile)); err != nil {
+	if result, err = sendCommand(conn, minidb.OpenCommand("sqlite3", *dbfile)); err != nil {
 		die(ErrIO, "could not open database: %s\n", err)
 	}
 	theDB := minidb.CommandDB(*dbfile)
@@ -288,44 +374,59 @@ func main() {
 		if err != nil {
 			die(ErrInvalidFields, "invalid table or field descriptions - %s.\n", err)
 		}
-		result, err = sendCommand(sock, minidb.AddTableCommand(theDB, *tableName, fields))
+		result, err = sendCommand(conn, minidb.AddTableCommand(theDB, *tableName, fields))
 		if err != nil {
 			die(ErrCannotAddTable, "unable to create table - %s.\n", err)
 		}
 	case new.FullCommand():
-		result, err := sendCommand(sock, minidb.NewItemCommand(theDB, *newTable))
+		result, err := sendCommand(conn, minidb.NewItemCommand(theDB, 0, *newTable))
 		if err != nil {
 			die(ErrCannotCreateItem, "unable to create item - %s.\n", err)
 		}
 		fmt.Printf("%d\n", result.Items[0])
+	case clone.FullCommand():
+		result, err := execInTx(conn, theDB, func(tx minidb.TxID) *minidb.Command {
+			return minidb.CloneItemCommand(theDB, tx, *cloneTable, minidb.Item(*cloneItem))
+		})
+		if err != nil {
+			die(ErrCloneFailed, "unable to clone item - %s.\n", err)
+		}
+		fmt.Printf("%d\n", result.Items[0])
 	case get.FullCommand():
+		result, err := sendCommand(conn, minidb.GetFieldsCommand(theDB, *getTable))
+		if err != nil {
+			die(ErrIO, "cannot get fields: %s.\n", err)
+		}
+		fields := result.Fields
+		isList := make(map[string]bool)
+		fieldNames := make([]string, 0)
+		for i := range fields {
+			fieldNames = append(fieldNames, (fields)[i].Name)
+			isList[fields[i].Name] = fields[i].Sort == minidb.DBIntList || fields[i].Sort == minidb.DBStringList ||
+				fields[i].Sort == minidb.DBBlobList || fields[i].Sort == minidb.DBDateList
+		}
 		if len(*getFields) == 0 {
-			result, err := sendCommand(sock, minidb.GetFieldsCommand(theDB, *getTable))
-			if err != nil {
-				die(ErrIO, "cannot get fields: %s.\n", err)
-			}
-			fields := result.Fields
-			fieldNames := make([]string, 0)
-			for i := range fields {
-				fieldNames = append(fieldNames, (fields)[i].Name)
-			}
 			getFields = &fieldNames
 		}
+		result, err = sendCommand(conn, minidb.GetItemCommand(theDB, *getTable, minidb.Item(*getItem)))
+		if err != nil {
+			die(ErrNotFound, "not found - %s.\n", err)
+		}
 		errCount := 0
 		for i := range *getFields {
-			result, err := sendCommand(sock, minidb.GetCommand(theDB, *getTable, minidb.Item(*getItem), (*getFields)[i]))
-			if err != nil {
+			values, ok := result.Item[(*getFields)[i]]
+			if !ok || (len(values) == 0 && !isList[(*getFields)[i]]) {
 				if *dbview == "titled" {
 					fmt.Printf("%s %d %s: 0\n", *getTable, *getItem, (*getFields)[i])
 				}
-				fmt.Fprintf(os.Stderr, "not found - %s.\n", err)
+				fmt.Fprintf(os.Stderr, "not found - no value for %s %d %s.\n", *getTable, *getItem, (*getFields)[i])
 				errCount++
 			} else {
 				if *dbview == "titled" {
-					fmt.Printf("%s %d %s: %d\n", *getTable, *getItem, (*getFields)[i], len(result.Values))
+					fmt.Printf("%s %d %s: %d\n", *getTable, *getItem, (*getFields)[i], len(values))
 				}
-				for j := range result.Values {
-					fmt.Printf("%s\n", result.Values[j].String())
+				for j := range values {
+					fmt.Printf("%s\n", values[j].String())
 				}
 			}
 		}
@@ -333,42 +434,56 @@ func main() {
 			os.Exit(ErrNotFound)
 		}
 	case set.FullCommand():
-		result, err := sendCommand(sock, minidb.ParseFieldValuesCommand(theDB, *setTable, *setField, *setValues))
+		result, err := sendCommand(conn, minidb.ParseFieldValuesCommand(theDB, *setTable, *setField, *setValues))
 		if err != nil {
 			die(ErrSetTypeError, "set failed - %s\n", err)
 		}
-		_, err = sendCommand(sock,
-			minidb.SetCommand(theDB, *setTable, minidb.Item(*setItem), *setField, result.Values))
+		_, err = execInTx(conn, theDB, func(tx minidb.TxID) *minidb.Command {
+			return minidb.SetCommand(theDB, tx, *setTable, minidb.Item(*setItem), *setField, result.Values)
+		})
 		if err != nil {
 			die(ErrSetFailed, "set failed - %s\n", err)
 		}
 	case remove.FullCommand():
-		_, err := sendCommand(sock, minidb.RemoveItemCommand(theDB, *removeTable, minidb.Item(*removeItem)))
+		items := make([]minidb.Item, len(*removeItems))
+		for i, item := range *removeItems {
+			items[i] = minidb.Item(item)
+		}
+		_, err := execInTx(conn, theDB, func(tx minidb.TxID) *minidb.Command {
+			return minidb.RemoveItemsCommand(theDB, tx, *removeTable, items)
+		})
 		if err != nil {
 			die(ErrRemoveFailed, "remove failed - %s\n", err)
 		}
 	case count.FullCommand():
-		result, err := sendCommand(sock, minidb.CountCommand(theDB, *countTable))
+		result, err := sendCommand(conn, minidb.CountCommand(theDB, *countTable))
 		if err != nil {
 			die(ErrCountFailed, "%s\n", err)
 		}
 		fmt.Printf("%d\n", result.Int)
 	case list.FullCommand():
-		result, err := sendCommand(sock, minidb.ListItemsCommand(theDB, *listTable, *listLimit))
+		result, err := sendCommand(conn, minidb.ListItemsCommand(theDB, *listTable, *listLimit))
 		if err != nil {
 			die(ErrListFailed, "%s\n", err)
 		}
 		printItems(result.Items)
 	case listFields.FullCommand():
-		result, err := sendCommand(sock, minidb.GetFieldsCommand(theDB, *listFieldsTable))
+		result, err := sendCommand(conn, minidb.GetFieldsCommand(theDB, *listFieldsTable))
 		if err != nil {
 			die(ErrFailedListFields, "cannot list fields for '%s' - %s.\n", *listFieldsTable, err)
 		}
 		for i := range result.Fields {
-			fmt.Printf("%s %s\n", minidb.GetUserTypeString(result.Fields[i].Sort), result.Fields[i].Name)
+			s := fmt.Sprintf("%s %s", minidb.GetUserTypeString(result.Fields[i].Sort), result.Fields[i].Name)
+			if result.Fields[i].Required {
+				s += " required"
+			}
+			if result.Fields[i].Default != nil {
+				s += " default " + result.Fields[i].Default.String()
+			}
+			fmt.Printf("%s\n", s)
 		}
 	case listTables.FullCommand():
-		if result, err = sendCommand(sock, minidb.GetTablesCommand(theDB)); err != nil {
+		if result, err = sendCommand(conn, minidb.GetTablesCommand(theDB)); err != nil {
 			die(ErrIO, "transport failed: %s\n", err)
 		}
 		tables := result.Strings
@@ -386,43 +501,77 @@ func main() {
 		if err != nil {
 			die(ErrSyntaxError, "syntax error - %s.\n", err)
 		}
-		result, err := sendCommand(sock, minidb.FindCommand(theDB, query, *findLimit))
+		result, err := sendCommand(conn, minidb.FindCommand(theDB, query, *findLimit))
 		if err != nil {
 			die(ErrSearchFail, "search Search '%s' failed - %s.\n", *findQuery, err)
 		}
 		printItems(result.Items)
 		// key-value store cases below
 	case fetchInt.FullCommand():
-		result, err := sendCommand(sock, minidb.GetIntCommand(theDB, *fetchIntKey))
+		cmd := minidb.GetIntKeyCommand(theDB, *fetchIntKey)
+		if !*stringKeys {
+			cmd = minidb.GetIntCommand(theDB, intKey(*fetchIntKey))
+		}
+		result, err := sendCommand(conn, cmd)
 		if err != nil {
 			die(ErrIO, "transport failed: %s\n", err)
 		}
 		fmt.Printf("%d\n", result.Int)
 	case fetchStr.FullCommand():
-		result, err := sendCommand(sock, minidb.GetStrCommand(theDB, *fetchStrKey))
+		cmd := minidb.GetStrKeyCommand(theDB, *fetchStrKey)
+		if !*stringKeys {
+			cmd = minidb.GetStrCommand(theDB, intKey(*fetchStrKey))
+		}
+		result, err := sendCommand(conn, cmd)
 		if err != nil {
 			die(ErrIO, "transport failed: %s\n", err)
 		}
 		fmt.Printf("%s\n", result.Str)
 	case fetchBlob.FullCommand():
-		result, err := sendCommand(sock, minidb.GetBlobCommand(theDB, *fetchBlobKey))
+		cmd := minidb.GetBlobKeyCommand(theDB, *fetchBlobKey)
+		if !*stringKeys {
+			cmd = minidb.GetBlobCommand(theDB, intKey(*fetchBlobKey))
+		}
+		result, err := sendCommand(conn, cmd)
 		if err != nil {
 			die(ErrIO, "transport failed: %s\n", err)
 		}
 		fmt.Printf("%s\n", base64.StdEncoding.EncodeToString(result.Bytes))
 	case fetchDate.FullCommand():
-		result, err := sendCommand(sock, minidb.GetDateCommand(theDB, *fetchDateKey))
+		cmd := minidb.GetDateKeyCommand(theDB, *fetchDateKey)
+		if !*stringKeys {
+			cmd = minidb.GetDateCommand(theDB, intKey(*fetchDateKey))
+		}
+		result, err := sendCommand(conn, cmd)
 		if err != nil {
 			die(ErrIO, "transport failed: %s\n", err)
 		}
 		fmt.Printf("%s\n", result.Str)
 	case putInt.FullCommand():
-		_, err := sendCommand(sock, minidb.SetIntCommand(theDB, *putIntKey, *putIntVal))
+		makeCmd := func(tx minidb.TxID) *minidb.Command {
+			return minidb.SetIntKeyCommand(theDB, tx, *putIntKey, *putIntVal)
+		}
+		if !*stringKeys {
+			key := intKey(*putIntKey)
+			makeCmd = func(tx minidb.TxID) *minidb.Command {
+				return minidb.SetIntCommand(theDB, tx, key, *putIntVal)
+			}
+		}
+		_, err := execInTx(conn, theDB, makeCmd)
 		if err != nil {
 			die(ErrIO, "transport failed: %s\n", err)
 		}
 	case putStr.FullCommand():
-		_, err := sendCommand(sock, minidb.SetStrCommand(theDB, *putStrKey, *putStrVal))
+		makeCmd := func(tx minidb.TxID) *minidb.Command {
+			return minidb.SetStrKeyCommand(theDB, tx, *putStrKey, *putStrVal)
+		}
+		if !*stringKeys {
+			key := intKey(*putStrKey)
+			makeCmd = func(tx minidb.TxID) *minidb.Command {
+				return minidb.SetStrCommand(theDB, tx, key, *putStrVal)
+			}
+		}
+		_, err := execInTx(conn, theDB, makeCmd)
 		if err != nil {
 			die(ErrIO, "transport failed: %s\n", err)
 		}
@@ -431,7 +580,16 @@ func main() {
 		if err != nil {
 			die(ErrSyntaxError, "syntax error - not a valid base64 encoding.\n")
 		}
-		_, err = sendCommand(sock, minidb.SetBlobCommand(theDB, *putBlobKey, b))
+		makeCmd := func(tx minidb.TxID) *minidb.Command {
+			return minidb.SetBlobKeyCommand(theDB, tx, *putBlobKey, b)
+		}
+		if !*stringKeys {
+			key := intKey(*putBlobKey)
+			makeCmd = func(tx minidb.TxID) *minidb.Command {
+				return minidb.SetBlobCommand(theDB, tx, key, b)
+			}
+		}
+		_, err = execInTx(conn, theDB, makeCmd)
 		if err != nil {
 			die(ErrIO, "transport failed: %s\n", err)
 		}
@@ -440,12 +598,25 @@ func main() {
 		if err != nil {
 			die(ErrSyntaxError, "syntax error - not a valid RFC3339 date '%s'.\n", *putDateVal)
 		}
-		_, err = sendCommand(sock, minidb.SetDateCommand(theDB, *putDateKey, d))
+		makeCmd := func(tx minidb.TxID) *minidb.Command {
+			return minidb.SetDateKeyCommand(theDB, tx, *putDateKey, d)
+		}
+		if !*stringKeys {
+			key := intKey(*putDateKey)
+			makeCmd = func(tx minidb.TxID) *minidb.Command {
+				return minidb.SetDateCommand(theDB, tx, key, d)
+			}
+		}
+		_, err = execInTx(conn, theDB, makeCmd)
 		if err != nil {
 			die(ErrIO, "transport failed: %s\n", err)
 		}
 	case hasInt.FullCommand():
-		result, err := sendCommand(sock, minidb.HasIntCommand(theDB, *hasIntKey))
+		cmd := minidb.HasIntKeyCommand(theDB, *hasIntKey)
+		if !*stringKeys {
+			cmd = minidb.HasIntCommand(theDB, intKey(*hasIntKey))
+		}
+		result, err := sendCommand(conn, cmd)
 		if err != nil {
 			die(ErrIO, "transport failed: %s\n", err)
 		}
@@ -457,7 +628,11 @@ func main() {
 			os.Exit(ErrFalse)
 		}
 	case hasStr.FullCommand():
-		result, err := sendCommand(sock, minidb.HasStrCommand(theDB, *hasStrKey))
+		cmd := minidb.HasStrKeyCommand(theDB, *hasStrKey)
+		if !*stringKeys {
+			cmd = minidb.HasStrCommand(theDB, intKey(*hasStrKey))
+		}
+		result, err := sendCommand(conn, cmd)
 		if err != nil {
 			die(ErrIO, "transport failed: %s\n", err)
 		}
@@ -469,7 +644,11 @@ func main() {
 			os.Exit(ErrFalse)
 		}
 	case hasBlob.FullCommand():
-		result, err := sendCommand(sock, minidb.HasBlobCommand(theDB, *hasBlobKey))
+		cmd := minidb.HasBlobKeyCommand(theDB, *hasBlobKey)
+		if !*stringKeys {
+			cmd = minidb.HasBlobCommand(theDB, intKey(*hasBlobKey))
+		}
+		result, err := sendCommand(conn, cmd)
 		if err != nil {
 			die(ErrIO, "transport failed: %s\n", err)
 		}
@@ -481,7 +660,11 @@ func main() {
 			os.Exit(ErrFalse)
 		}
 	case hasDate.FullCommand():
-		result, err := sendCommand(sock, minidb.HasDateCommand(theDB, *hasDateKey))
+		cmd := minidb.HasDateKeyCommand(theDB, *hasDateKey)
+		if !*stringKeys {
+			cmd = minidb.HasDateCommand(theDB, intKey(*hasDateKey))
+		}
+		result, err := sendCommand(conn, cmd)
 		if err != nil {
 			die(ErrIO, "transport failed: %s\n", err)
 		}
@@ -493,53 +676,266 @@ func main() {
 			os.Exit(ErrFalse)
 		}
 	case deleteInt.FullCommand():
-		_, err := sendCommand(sock, minidb.DeleteIntCommand(theDB, *deleteIntKey))
+		makeCmd := func(tx minidb.TxID) *minidb.Command {
+			return minidb.DeleteIntKeyCommand(theDB, tx, *deleteIntKey)
+		}
+		if !*stringKeys {
+			key := intKey(*deleteIntKey)
+			makeCmd = func(tx minidb.TxID) *minidb.Command {
+				return minidb.DeleteIntCommand(theDB, tx, key)
+			}
+		}
+		_, err := execInTx(conn, theDB, makeCmd)
 		if err != nil {
 			die(ErrIO, "transport failed: %s\n", err)
 		}
 	case deleteStr.FullCommand():
-		_, err := sendCommand(sock, minidb.DeleteStrCommand(theDB, *deleteStrKey))
+		makeCmd := func(tx minidb.TxID) *minidb.Command {
+			return minidb.DeleteStrKeyCommand(theDB, tx, *deleteStrKey)
+		}
+		if !*stringKeys {
+			key := intKey(*deleteStrKey)
+			makeCmd = func(tx minidb.TxID) *minidb.Command {
+				return minidb.DeleteStrCommand(theDB, tx, key)
+			}
+		}
+		_, err := execInTx(conn, theDB, makeCmd)
 		if err != nil {
 			die(ErrIO, "transport failed: %s\n", err)
 		}
 	case deleteBlob.FullCommand():
-		_, err := sendCommand(sock, minidb.DeleteBlobCommand(theDB, *deleteBlobKey))
+		makeCmd := func(tx minidb.TxID) *minidb.Command {
+			return minidb.DeleteBlobKeyCommand(theDB, tx, *deleteBlobKey)
+		}
+		if !*stringKeys {
+			key := intKey(*deleteBlobKey)
+			makeCmd = func(tx minidb.TxID) *minidb.Command {
+				return minidb.DeleteBlobCommand(theDB, tx, key)
+			}
+		}
+		_, err := execInTx(conn, theDB, makeCmd)
 		if err != nil {
 			die(ErrIO, "transport failed: %s\n", err)
 		}
 	case deleteDate.FullCommand():
-		_, err := sendCommand(sock, minidb.DeleteDateCommand(theDB, *deleteDateKey))
+		makeCmd := func(tx minidb.TxID) *minidb.Command {
+			return minidb.DeleteDateKeyCommand(theDB, tx, *deleteDateKey)
+		}
+		if !*stringKeys {
+			key := intKey(*deleteDateKey)
+			makeCmd = func(tx minidb.TxID) *minidb.Command {
+				return minidb.DeleteDateCommand(theDB, tx, key)
+			}
+		}
+		_, err := execInTx(conn, theDB, makeCmd)
 		if err != nil {
 			die(ErrIO, "transport failed: %s\n", err)
 		}
 	case listInt.FullCommand():
-		result, err := sendCommand(sock, minidb.ListIntCommand(theDB))
-		if err != nil {
-			die(ErrIO, "failed to list ints: %s\n", err)
+		if *stringKeys {
+			result, err := sendCommand(conn, minidb.ListIntKeysCommand(theDB))
+			if err != nil {
+				die(ErrIO, "failed to list ints: %s\n", err)
+			}
+			for _, key := range result.Strings {
+				fmt.Println(key)
+			}
+		} else {
+			result, err := sendCommand(conn, minidb.ListIntCommand(theDB, 0))
+			if err != nil {
+				die(ErrIO, "failed to list ints: %s\n", err)
+			}
+			printItems(toItems(result.Ints))
 		}
-		printItems(toItems(result.Ints))
 	case listStr.FullCommand():
-		result, err := sendCommand(sock, minidb.ListStrCommand(theDB))
-		if err != nil {
-			die(ErrIO, "failed to list strings: %s\n", err)
+		if *stringKeys {
+			result, err := sendCommand(conn, minidb.ListStrKeysCommand(theDB))
+			if err != nil {
+				die(ErrIO, "failed to list strings: %s\n", err)
+			}
+			for _, key := range result.Strings {
+				fmt.Println(key)
+			}
+		} else {
+			result, err := sendCommand(conn, minidb.ListStrCommand(theDB))
+			if err != nil {
+				die(ErrIO, "failed to list strings: %s\n", err)
+			}
+			printItems(toItems(result.Ints))
 		}
-		printItems(toItems(result.Ints))
 	case listBlob.FullCommand():
-		result, err := sendCommand(sock, minidb.ListBlobCommand(theDB))
-		if err != nil {
-			die(ErrIO, "failed to list blobs: %s\n", err)
+		if *stringKeys {
+			result, err := sendCommand(conn, minidb.ListBlobKeysCommand(theDB))
+			if err != nil {
+				die(ErrIO, "failed to list blobs: %s\n", err)
+			}
+			for _, key := range result.Strings {
+				fmt.Println(key)
+			}
+		} else {
+			result, err := sendCommand(conn, minidb.ListBlobCommand(theDB))
+			if err != nil {
+				die(ErrIO, "failed to list blobs: %s\n", err)
+			}
+			printItems(toItems(result.Ints))
 		}
-		printItems(toItems(result.Ints))
 	case listDate.FullCommand():
-		result, err := sendCommand(sock, minidb.ListDateCommand(theDB))
+		if *stringKeys {
+			result, err := sendCommand(conn, minidb.ListDateKeysCommand(theDB))
+			if err != nil {
+				die(ErrIO, "failed to list dates: %s\n", err)
+			}
+			for _, key := range result.Strings {
+				fmt.Println(key)
+			}
+		} else {
+			result, err := sendCommand(conn, minidb.ListDateCommand(theDB))
+			if err != nil {
+				die(ErrIO, "failed to list dates: %s\n", err)
+			}
+			printItems(toItems(result.Ints))
+		}
+	case fetchFloat.FullCommand():
+		result, err := sendCommand(conn, minidb.GetFloatCommand(theDB, *fetchFloatKey))
 		if err != nil {
-			die(ErrIO, "failed to list dates: %s\n", err)
+			die(ErrIO, "transport failed: %s\n", err)
+		}
+		fmt.Printf("%s\n", result.Str)
+	case putFloat.FullCommand():
+		f, err := strconv.ParseFloat(*putFloatVal, 64)
+		if err != nil {
+			die(ErrSyntaxError, "syntax error - not a valid float '%s'.\n", *putFloatVal)
+		}
+		_, err = execInTx(conn, theDB, func(tx minidb.TxID) *minidb.Command {
+			return minidb.SetFloatCommand(theDB, tx, *putFloatKey, f)
+		})
+		if err != nil {
+			die(ErrIO, "transport failed: %s\n", err)
+		}
+	case hasFloat.FullCommand():
+		result, err := sendCommand(conn, minidb.HasFloatCommand(theDB, *hasFloatKey))
+		if err != nil {
+			die(ErrIO, "transport failed: %s\n", err)
+		}
+		if result.Bool == true {
+			fmt.Print("true\n")
+			os.Exit(ErrNone)
+		} else {
+			fmt.Print("false\n")
+			os.Exit(ErrFalse)
+		}
+	case deleteFloat.FullCommand():
+		_, err := execInTx(conn, theDB, func(tx minidb.TxID) *minidb.Command {
+			return minidb.DeleteFloatCommand(theDB, tx, *deleteFloatKey)
+		})
+		if err != nil {
+			die(ErrIO, "transport failed: %s\n", err)
+		}
+	case listFloat.FullCommand():
+		result, err := sendCommand(conn, minidb.ListFloatCommand(theDB))
+		if err != nil {
+			die(ErrIO, "failed to list floats: %s\n", err)
 		}
 		printItems(toItems(result.Ints))
 	case index.FullCommand():
-		_, err := sendCommand(sock, minidb.IndexCommand(theDB, *indexTable, *indexField))
+		_, err := execInTx(conn, theDB, func(tx minidb.TxID) *minidb.Command {
+			return minidb.IndexCommand(theDB, tx, *indexTable, *indexField)
+		})
 		if err != nil {
 			die(ErrIndexFailed, "failed to create index: %s\n", err)
 		}
+	case renameTable.FullCommand():
+		_, err := execInTx(conn, theDB, func(tx minidb.TxID) *minidb.Command {
+			return minidb.RenameTableCommand(theDB, tx, *renameTableOld, *renameTableNew)
+		})
+		if err != nil {
+			die(ErrRenameTableFailed, "failed to rename table: %s\n", err)
+		}
+	case dropTable.FullCommand():
+		_, err := execInTx(conn, theDB, func(tx minidb.TxID) *minidb.Command {
+			return minidb.DropTableCommand(theDB, tx, *dropTableName)
+		})
+		if err != nil {
+			die(ErrDropTableFailed, "failed to drop table: %s\n", err)
+		}
+	case exportKV.FullCommand():
+		result, err := sendCommand(conn, minidb.ExportKVCommand(theDB))
+		if err != nil {
+			die(ErrExportKVFailed, "failed to export key-value store: %s\n", err)
+		}
+		if *exportKVFile == "" {
+			fmt.Print(result.Str)
+		} else if err := ioutil.WriteFile(*exportKVFile, []byte(result.Str), 0644); err != nil {
+			die(ErrIO, "failed to write %s: %s\n", *exportKVFile, err)
+		}
+	case importKV.FullCommand():
+		data, err := ioutil.ReadFile(*importKVFile)
+		if err != nil {
+			die(ErrIO, "failed to read %s: %s\n", *importKVFile, err)
+		}
+		_, err = execInTx(conn, theDB, func(tx minidb.TxID) *minidb.Command {
+			return minidb.ImportKVCommand(theDB, tx, string(data))
+		})
+		if err != nil {
+			die(ErrImportKVFailed, "failed to import key-value store: %s\n", err)
+		}
+	case reindex.FullCommand():
+		_, err := execInTx(conn, theDB, func(tx minidb.TxID) *minidb.Command {
+			return minidb.ReindexCommand(theDB, tx, *reindexTable)
+		})
+		if err != nil {
+			die(ErrReindexFailed, "failed to rebuild indices: %s\n", err)
+		}
+	case timestamps.FullCommand():
+		_, err := execInTx(conn, theDB, func(tx minidb.TxID) *minidb.Command {
+			return minidb.EnableTimestampsCommand(theDB, tx, *timestampsTable)
+		})
+		if err != nil {
+			die(ErrEnableTimestampsFailed, "failed to enable timestamps: %s\n", err)
+		}
+	case lint.FullCommand():
+		result, err := sendCommand(conn, minidb.LintSchemaCommand(theDB))
+		if err != nil {
+			die(ErrLintFailed, "failed to examine the database: %s\n", err)
+		}
+		for _, s := range result.Strings {
+			fmt.Println(s)
+		}
+	case normalizeLists.FullCommand():
+		if _, err := sendCommand(conn, minidb.NormalizeListsCommand(theDB)); err != nil {
+			die(ErrNormalizeListsFailed, "failed to normalize list fields: %s\n", err)
+		}
+	case compact.FullCommand():
+		result, err := sendCommand(conn, minidb.CompactCommand(theDB))
+		if err != nil {
+			die(ErrCompactFailed, "failed to compact the database: %s\n", err)
+		}
+		fmt.Printf("%d\n", result.Int)
+	case purgeExpired.FullCommand():
+		result, err := sendCommand(conn, minidb.PurgeExpiredCommand(theDB))
+		if err != nil {
+			die(ErrPurgeExpiredFailed, "failed to purge expired values: %s\n", err)
+		}
+		fmt.Printf("%d\n", result.Int)
+	case dump.FullCommand():
+		result, err := sendCommand(conn, minidb.DumpCommand(theDB))
+		if err != nil {
+			die(ErrDumpFailed, "failed to dump the database: %s\n", err)
+		}
+		if *dumpFile == "" {
+			fmt.Print(result.Str)
+		} else if err := ioutil.WriteFile(*dumpFile, []byte(result.Str), 0644); err != nil {
+			die(ErrIO, "failed to write %s: %s\n", *dumpFile, err)
+		}
+	case serverStats.FullCommand():
+		result, err := sendCommand(conn, minidb.StatsCommand())
+		if err != nil {
+			die(ErrStatsFailed, "failed to get the statistics of the server: %s\n", err)
+		}
+		minidb.ParseStats(result).WritePrometheus(os.Stdout)
+	case shutdown.FullCommand():
+		if _, err := sendCommand(conn, minidb.ServerShutdownCommand()); err != nil {
+			die(ErrShutdownFailed, "failed to shut down the server: %s\n", err)
+		}
 	}
 }
@@ -0,0 +1,174 @@
+package minidb
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"io"
+	"unicode"
+	"unicode/utf8"
+)
+
+// ------------------------------------------------------------------------------
+// Code generation
+// ------------------------------------------------------------------------------
+
+// goTypes maps base field types to the Go type, the Value accessor and the Value constructor
+// used in generated code.
+var goTypes = map[FieldType][3]string{
+	DBInt:    {"int64", "Int", "NewInt"},
+	DBString: {"string", "String", "NewString"},
+	DBBlob:   {"[]byte", "Bytes", "NewBytes"},
+	DBDate:   {"time.Time", "Datetime", "NewDate"},
+}
+
+// goIdent turns a table or field name into an exported Go identifier. Names that start
+// with a letter without upper case are prefixed by X. The timestamp fields become Created
+// and Modified.
+func goIdent(name string) string {
+	if isTimestampField(name) {
+		return name[1:]
+	}
+	r, n := utf8.DecodeRuneInString(name)
+	if !unicode.IsUpper(unicode.ToUpper(r)) {
+		return "X" + name
+	}
+	return string(unicode.ToUpper(r)) + name[n:]
+}
+
+// GenerateGo writes Go source code for package pkg to w that provides constants for the table
+// and field names of the schema and a typed item for each table, so that applications get
+// compile-time checks of names and value types. For a table Person with a string field Name
+// and an int list field Scores, the following is generated:
+//
+//	const TablePerson = "Person"  // and PersonName, PersonScores for the field names
+//	type PersonItem minidb.Item
+//	func NewPerson(db *minidb.MDB) (PersonItem, error)
+//	func (item PersonItem) Name(db *minidb.MDB) (string, error)
+//	func (item PersonItem) SetName(tx *minidb.Tx, value string) error
+//	func (item PersonItem) Scores(db *minidb.MDB) ([]int64, error)
+//	func (item PersonItem) SetScores(tx *minidb.Tx, values ...int64) error
+//
+// The timestamp fields of tables with EnableTimestamps only get the getters Created and Modified.
+// An error is returned if two generated identifiers would be the same.
+func (s *Schema) GenerateGo(w io.Writer, pkg string) error {
+	var buff bytes.Buffer
+	usesTime := false
+	for _, ts := range s.Tables {
+		for _, field := range ts.Fields {
+			if ToBaseType(field.Sort) == DBDate {
+				usesTime = true
+			}
+		}
+	}
+	fmt.Fprintf(&buff, "// Code generated by mdbgen. DO NOT EDIT.\n\npackage %s\n\nimport (\n", pkg)
+	if usesTime {
+		fmt.Fprintf(&buff, "\t\"time\"\n\n")
+	}
+	fmt.Fprintf(&buff, "\tminidb \"github.com/rasteric/minidb\"\n)\n")
+	idents := make(map[string]string)
+	declare := func(ident, what string) error {
+		if other, ok := idents[ident]; ok {
+			return Fail("generated identifier %s for %s clashes with %s", ident, what, other)
+		}
+		idents[ident] = what
+		return nil
+	}
+	for _, ts := range s.Tables {
+		t := goIdent(ts.Name)
+		itemType := t + "Item"
+		for _, ident := range []string{"Table" + t, itemType, "New" + t} {
+			if err := declare(ident, "table "+ts.Name); err != nil {
+				return err
+			}
+		}
+		methods := make(map[string]string)
+		fmt.Fprintf(&buff, "\n// Names of table %s and its fields.\nconst (\n\tTable%s = %q\n", ts.Name, t, ts.Name)
+		for _, field := range ts.Fields {
+			f := goIdent(field.Name)
+			if err := declare(t+f, "field "+ts.Name+" "+field.Name); err != nil {
+				return err
+			}
+			accessors := []string{f, "Set" + f}
+			if isTimestampField(field.Name) {
+				accessors = accessors[:1]
+			}
+			for _, m := range accessors {
+				if other, ok := methods[m]; ok {
+					return Fail("generated method %s.%s for field %s clashes with field %s", itemType, m, field.Name, other)
+				}
+				methods[m] = field.Name
+			}
+			fmt.Fprintf(&buff, "\t%s%s = %q\n", t, f, field.Name)
+		}
+		fmt.Fprintf(&buff, ")\n")
+		fmt.Fprintf(&buff, `
+// %[2]s is an item of table %[1]s.
+type %[2]s minidb.Item
+
+// New%[3]s creates a new item in table %[1]s.
+func New%[3]s(db *minidb.MDB) (%[2]s, error) {
+	item, err := db.NewItem(Table%[3]s)
+	return %[2]s(item), err
+}
+`, ts.Name, itemType, t)
+		for _, field := range ts.Fields {
+			f := goIdent(field.Name)
+			gt, ok := goTypes[ToBaseType(field.Sort)]
+			if !ok {
+				return Fail("field %s of table %s has invalid type", field.Name, ts.Name)
+			}
+			if isListFieldType(field.Sort) {
+				fmt.Fprintf(&buff, `
+// %[3]s returns the values of field %[2]s.
+func (item %[4]s) %[3]s(db *minidb.MDB) ([]%[5]s, error) {
+	values, err := db.Get(Table%[1]s, minidb.Item(item), %[1]s%[3]s)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]%[5]s, len(values))
+	for i := range values {
+		result[i] = values[i].%[6]s()
+	}
+	return result, nil
+}
+
+// Set%[3]s sets the values of field %[2]s.
+func (item %[4]s) Set%[3]s(tx *minidb.Tx, values ...%[5]s) error {
+	data := make([]minidb.Value, len(values))
+	for i, value := range values {
+		data[i] = minidb.%[7]s(value)
+	}
+	return tx.Set(Table%[1]s, minidb.Item(item), %[1]s%[3]s, data)
+}
+`, t, field.Name, f, itemType, gt[0], gt[1], gt[2])
+			} else {
+				fmt.Fprintf(&buff, `
+// %[3]s returns the value of field %[2]s.
+func (item %[4]s) %[3]s(db *minidb.MDB) (%[5]s, error) {
+	var result %[5]s
+	values, err := db.Get(Table%[1]s, minidb.Item(item), %[1]s%[3]s)
+	if err != nil || len(values) == 0 || values[0].IsNull() {
+		return result, err
+	}
+	return values[0].%[6]s(), nil
+}
+`, t, field.Name, f, itemType, gt[0], gt[1], gt[2])
+				if !isTimestampField(field.Name) {
+					fmt.Fprintf(&buff, `
+// Set%[3]s sets the value of field %[2]s.
+func (item %[4]s) Set%[3]s(tx *minidb.Tx, value %[5]s) error {
+	return tx.Set(Table%[1]s, minidb.Item(item), %[1]s%[3]s, []minidb.Value{minidb.%[7]s(value)})
+}
+`, t, field.Name, f, itemType, gt[0], gt[1], gt[2])
+				}
+			}
+		}
+	}
+	src, err := format.Source(buff.Bytes())
+	if err != nil {
+		return Fail("cannot format generated code: %s", err)
+	}
+	_, err = w.Write(src)
+	return err
+}
@@ -0,0 +1,48 @@
+package minidb
+
+import (
+	"bytes"
+	"go/parser"
+	gotoken "go/token"
+	"strings"
+	"testing"
+)
+
+func TestGenerateGo(t *testing.T) {
+	schema := &Schema{Tables: []TableSchema{
+		TableSchema{Name: "Person", Fields: []Field{Field{Name: "Name", Sort: DBString},
+			Field{Name: "Born", Sort: DBDate}, Field{Name: "scores", Sort: DBIntList}}},
+		TableSchema{Name: "Asset", Fields: []Field{Field{Name: "Data", Sort: DBBlob}}},
+	}}
+	var buff bytes.Buffer
+	if err := schema.GenerateGo(&buff, "models"); err != nil {
+		t.Errorf("GenerateGo() failed: %s", err)
+	}
+	file, err := parser.ParseFile(gotoken.NewFileSet(), "models.go", buff.Bytes(), 0)
+	if err != nil {
+		t.Errorf("GenerateGo() produced invalid code: %s\n%s", err, buff.String())
+	}
+	if file.Name.Name != "models" || len(file.Imports) != 2 {
+		t.Errorf("GenerateGo() produced wrong package or imports:\n%s", buff.String())
+	}
+	src := strings.Join(strings.Fields(buff.String()), " ")
+	for _, s := range []string{`TablePerson = "Person"`, `PersonScores = "scores"`, "type AssetItem minidb.Item",
+		"func NewPerson(db *minidb.MDB) (PersonItem, error)",
+		"func (item PersonItem) Born(db *minidb.MDB) (time.Time, error)",
+		"func (item PersonItem) SetScores(tx *minidb.Tx, values ...int64) error",
+		"func (item AssetItem) SetData(tx *minidb.Tx, value []byte) error"} {
+		if !strings.Contains(src, s) {
+			t.Errorf("GenerateGo() did not generate %s:\n%s", s, src)
+		}
+	}
+
+	schema = &Schema{Tables: []TableSchema{TableSchema{Name: "Person", Fields: []Field{
+		Field{Name: "Name", Sort: DBString}, Field{Name: "SetName", Sort: DBString}}}}}
+	if err := schema.GenerateGo(&buff, "models"); err == nil {
+		t.Errorf("GenerateGo() should fail for clashing method names")
+	}
+	schema = &Schema{Tables: []TableSchema{TableSchema{Name: "Person", Fields: []Field{Field{Name: "Item", Sort: DBInt}}}}}
+	if err := schema.GenerateGo(&buff, "models"); err == nil {
+		t.Errorf("GenerateGo() should fail for clashing identifiers")
+	}
+}
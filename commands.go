@@ -1,6 +1,10 @@
 package minidb
 
 import (
+	"encoding/json"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 )
@@ -106,8 +110,241 @@ const (
 	CmdRemoveItem
 	// CmdIndex is the type of an Index command struct.
 	CmdIndex
+	// CmdFindWithin is the type of a FindWithin command struct.
+	CmdFindWithin
+	// CmdRenameTable is the type of a RenameTable command struct.
+	CmdRenameTable
+	// CmdDropTable is the type of a DropTable command struct.
+	CmdDropTable
+	// CmdChangeFieldType is the type of a ChangeFieldType command struct.
+	CmdChangeFieldType
+	// CmdLink is the type of a Link command struct.
+	CmdLink
+	// CmdUnlink is the type of an Unlink command struct.
+	CmdUnlink
+	// CmdLinked is the type of a Linked command struct.
+	CmdLinked
+	// CmdReindex is the type of a Reindex command struct.
+	CmdReindex
+	// CmdGetOrEmpty is the type of a GetOrEmpty command struct.
+	CmdGetOrEmpty
+	// CmdGetItem is the type of a GetItem command struct.
+	CmdGetItem
+	// CmdExportKV is the type of an ExportKV command struct.
+	CmdExportKV
+	// CmdImportKV is the type of an ImportKV command struct.
+	CmdImportKV
+	// CmdSetItem is the type of a SetItem command struct.
+	CmdSetItem
+	// CmdGetMulti is the type of a GetMulti command struct.
+	CmdGetMulti
+	// CmdLintSchema is the type of a LintSchema command struct.
+	CmdLintSchema
+	// CmdPing checks that the executor is responsive.
+	CmdPing
+	// CmdEnableTimestamps is the type of an EnableTimestamps command struct.
+	CmdEnableTimestamps
+	// CmdUseItem is the type of a UseItem command struct.
+	CmdUseItem
+	// CmdCloneItem is the type of a CloneItem command struct.
+	CmdCloneItem
+	// CmdRemoveItems is the type of a RemoveItems command struct.
+	CmdRemoveItems
+	// CmdRegisterTemplate is the type of a RegisterTemplate command struct.
+	CmdRegisterTemplate
+	// CmdRunTemplate finds items with a registered query template.
+	CmdRunTemplate
+	// CmdFindStream finds the first chunk of items matching a query and returns a cursor for the rest.
+	CmdFindStream
+	// CmdFindNext returns the next chunk of items of a cursor returned by CmdFindStream.
+	CmdFindNext
+	// CmdFindClose removes a cursor that is no longer needed.
+	CmdFindClose
+	// CmdVersion is the type of a Version command struct.
+	CmdVersion
+	// CmdSetIfVersion is the type of a SetIfVersion command struct.
+	CmdSetIfVersion
+	// CmdNormalizeLists is the type of a NormalizeLists command struct.
+	CmdNormalizeLists
+	// CmdSetFieldAccess is the type of a SetFieldAccess command struct.
+	CmdSetFieldAccess
+	// CmdBeginRead is the type of a BeginRead command struct.
+	CmdBeginRead
+	// CmdCompact is the type of a Compact command struct.
+	CmdCompact
+	// CmdDump is the type of a Dump command struct.
+	CmdDump
+	// CmdGetIntKey is the type of a GetIntKey command struct.
+	CmdGetIntKey
+	// CmdGetStrKey is the type of a GetStrKey command struct.
+	CmdGetStrKey
+	// CmdGetBlobKey is the type of a GetBlobKey command struct.
+	CmdGetBlobKey
+	// CmdGetDateKey is the type of a GetDateKey command struct.
+	CmdGetDateKey
+	// CmdSetIntKey is the type of a SetIntKey command struct.
+	CmdSetIntKey
+	// CmdSetStrKey is the type of a SetStrKey command struct.
+	CmdSetStrKey
+	// CmdSetBlobKey is the type of a SetBlobKey command struct.
+	CmdSetBlobKey
+	// CmdSetDateKey is the type of a SetDateKey command struct.
+	CmdSetDateKey
+	// CmdSetDateStrKey is the type of a SetDateStrKey command struct.
+	CmdSetDateStrKey
+	// CmdHasIntKey is the type of a HasIntKey command struct.
+	CmdHasIntKey
+	// CmdHasStrKey is the type of a HasStrKey command struct.
+	CmdHasStrKey
+	// CmdHasBlobKey is the type of a HasBlobKey command struct.
+	CmdHasBlobKey
+	// CmdHasDateKey is the type of a HasDateKey command struct.
+	CmdHasDateKey
+	// CmdDeleteIntKey is the type of a DeleteIntKey command struct.
+	CmdDeleteIntKey
+	// CmdDeleteStrKey is the type of a DeleteStrKey command struct.
+	CmdDeleteStrKey
+	// CmdDeleteBlobKey is the type of a DeleteBlobKey command struct.
+	CmdDeleteBlobKey
+	// CmdDeleteDateKey is the type of a DeleteDateKey command struct.
+	CmdDeleteDateKey
+	// CmdListIntKeys is the type of a ListIntKeys command struct.
+	CmdListIntKeys
+	// CmdListStrKeys is the type of a ListStrKeys command struct.
+	CmdListStrKeys
+	// CmdListBlobKeys is the type of a ListBlobKeys command struct.
+	CmdListBlobKeys
+	// CmdListDateKeys is the type of a ListDateKeys command struct.
+	CmdListDateKeys
+	// CmdPurgeExpired is the type of a PurgeExpired command struct.
+	CmdPurgeExpired
+	// CmdSetMany is the type of a SetMany command struct.
+	CmdSetMany
+	// CmdGetMany is the type of a GetMany command struct.
+	CmdGetMany
+	// CmdGetFloat is the type of a GetFloat command struct.
+	CmdGetFloat
+	// CmdSetFloat is the type of a SetFloat command struct.
+	CmdSetFloat
+	// CmdHasFloat is the type of a HasFloat command struct.
+	CmdHasFloat
+	// CmdDeleteFloat is the type of a DeleteFloat command struct.
+	CmdDeleteFloat
+	// CmdListFloat is the type of a ListFloat command struct.
+	CmdListFloat
+	// CmdDumpKV is the type of a DumpKV command struct.
+	CmdDumpKV
+	// CmdNewUser creates a user of the multiuser database of the executor.
+	CmdNewUser
+	// CmdAuthenticate authenticates a user of the multiuser database and starts a session.
+	CmdAuthenticate
+	// CmdLogout ends a session started by CmdAuthenticate.
+	CmdLogout
+	// CmdUserDB returns the name of the database of the user of a session.
+	CmdUserDB
+	// CmdDeleteUser deletes the user of a session with all data.
+	CmdDeleteUser
+	// CmdArchiveUser archives the data of a user.
+	CmdArchiveUser
+	// CmdChangePassword changes the password of the user of a session.
+	CmdChangePassword
+	// CmdHello returns the version, capabilities, and supported commands of the executor.
+	CmdHello
+	// CmdListTx lists the open transactions of a database.
+	CmdListTx
+	// CmdFindOpen opens a cursor for the items matching a query without returning any items.
+	CmdFindOpen
+	// CmdFetch returns the next items of a cursor, optionally with the values of a field.
+	CmdFetch
+	// CmdCursorClose removes a cursor that is no longer needed.
+	CmdCursorClose
+	// CmdCountQuery is the type of a CountQuery command struct.
+	CmdCountQuery
+	// CmdTableSize is the type of a TableSize command struct.
+	CmdTableSize
+	// CmdDatabaseSize is the type of a DatabaseSize command struct.
+	CmdDatabaseSize
+	// CmdHasTimestamps is the type of a HasTimestamps command struct.
+	CmdHasTimestamps
+	// CmdListIntRange is the type of a ListIntRange command struct.
+	CmdListIntRange
+	// CmdListStrRange is the type of a ListStrRange command struct.
+	CmdListStrRange
+	// CmdListBlobRange is the type of a ListBlobRange command struct.
+	CmdListBlobRange
+	// CmdListDateRange is the type of a ListDateRange command struct.
+	CmdListDateRange
+	// CmdListFloatRange is the type of a ListFloatRange command struct.
+	CmdListFloatRange
+	// CmdFindStrValue is the type of a FindStrValue command struct.
+	CmdFindStrValue
+	// CmdFindFloatValues is the type of a FindFloatValues command struct.
+	CmdFindFloatValues
+	// CmdHistoryOf is the type of a HistoryOf command struct.
+	CmdHistoryOf
+	// CmdExportJSON is the type of an ExportJSON command struct.
+	CmdExportJSON
+	// CmdSubmit executes a command in the background and returns the ID of its job.
+	CmdSubmit
+	// CmdJobStatus returns the state of a job.
+	CmdJobStatus
+	// CmdJobResult returns the result of a job that is done.
+	CmdJobResult
+	// CmdJobCancel removes a job.
+	CmdJobCancel
+	// CmdAuth authenticates the connection of a client to a server.
+	CmdAuth
+	// CmdServerShutdown stops the server of the executor.
+	CmdServerShutdown
+	// CmdStats returns the statistics of the commands executed by the executor.
+	CmdStats
+	// CmdSubscribe subscribes to the changes of a table.
+	CmdSubscribe
+	// CmdSubscribeQuery subscribes to the changes of the items of a table that match a query.
+	CmdSubscribeQuery
+	// CmdPoll returns the changes reported to a subscription.
+	CmdPoll
+	// CmdUnsubscribe removes a subscription.
+	CmdUnsubscribe
+	// CmdEnableHistory is the type of an EnableHistory command struct.
+	CmdEnableHistory
+	// CmdDisableHistory is the type of a DisableHistory command struct.
+	CmdDisableHistory
+
+	// maxCommand is larger than all command IDs. New commands must be added before it.
+	maxCommand
 )
 
+// readCommands are the commands that only read from the database. If a command of this kind
+// is executed with the TxID of an open transaction, it sees the uncommitted writes of that
+// transaction, see Tx.View.
+var readCommands = map[CommandID]bool{
+	CmdCount: true, CmdFind: true, CmdGet: true, CmdGetTables: true, CmdIsListField: true,
+	CmdItemExists: true, CmdListItems: true, CmdParseFieldValues: true, CmdTableExists: true,
+	CmdToSQL: true, CmdFieldIsNull: true, CmdFieldExists: true, CmdGetFields: true,
+	CmdIsEmptyListField: true, CmdMustGetFieldType: true, CmdGetInt: true, CmdGetStr: true,
+	CmdGetBlob: true, CmdGetDate: true, CmdHasInt: true, CmdHasStr: true, CmdHasBlob: true,
+	CmdHasDate: true, CmdListInt: true, CmdListStr: true, CmdListBlob: true, CmdListDate: true,
+	CmdFieldIsEmpty: true, CmdFindWithin: true, CmdLinked: true, CmdGetOrEmpty: true,
+	CmdGetItem: true, CmdExportKV: true, CmdGetMulti: true, CmdLintSchema: true,
+	CmdRunTemplate: true, CmdFindStream: true, CmdVersion: true, CmdDump: true,
+	CmdGetIntKey: true, CmdGetStrKey: true, CmdGetBlobKey: true, CmdGetDateKey: true,
+	CmdHasIntKey: true, CmdHasStrKey: true, CmdHasBlobKey: true, CmdHasDateKey: true,
+	CmdListIntKeys: true, CmdListStrKeys: true, CmdListBlobKeys: true, CmdListDateKeys: true,
+	CmdGetMany: true, CmdGetFloat: true, CmdHasFloat: true, CmdListFloat: true, CmdDumpKV: true,
+	CmdHello: true, CmdFindOpen: true, CmdCountQuery: true, CmdTableSize: true,
+	CmdDatabaseSize: true, CmdHasTimestamps: true, CmdListIntRange: true, CmdListStrRange: true,
+	CmdListBlobRange: true, CmdListDateRange: true, CmdListFloatRange: true, CmdFindStrValue: true,
+	CmdFindFloatValues: true, CmdHistoryOf: true, CmdExportJSON: true, CmdSubscribe: true,
+	CmdSubscribeQuery: true,
+}
+
+// IsReadCommand returns true if the command only reads from the database. Such commands can be
+// safely sent again if it is unknown whether they have been executed.
+func IsReadCommand(id CommandID) bool {
+	return readCommands[id]
+}
+
 // CommandDB is the database that has been opened.
 type CommandDB string
 
@@ -124,16 +361,36 @@ type TxID int64
 // Result structures have the HasError field set to true if an error has occurred.
 // Commands and results can be serialized to json.
 type Command struct {
-	ID        CommandID `json:"id"`
-	DB        CommandDB `json:"dbid"`
-	Tx        TxID      `json:"txid"`
-	StrArgs   []string  `json:"strings"`
-	ItemArg   Item      `json:"item"`
-	FieldArgs []Field   `json:"fields"`
-	ValueArgs []Value   `json:"values"`
-	QueryArg  Query     `json:"query"`
-	IntArg    int64     `json:"int"`
-	IntArg2   int64     `json:"int2"`
+	ID        CommandID          `json:"id"`
+	DB        CommandDB          `json:"dbid"`
+	Tx        TxID               `json:"txid"`
+	StrArgs   []string           `json:"strings"`
+	ItemArg   Item               `json:"item"`
+	ItemArgs  []Item             `json:"itemlist"`
+	FieldArgs []Field            `json:"fields"`
+	ValueArgs []Value            `json:"values"`
+	QueryArg  Query              `json:"query"`
+	IntArg    int64              `json:"int"`
+	IntArg2   int64              `json:"int2"`
+	ValueMap  map[string][]Value `json:"valuemap"`
+	Version   int                `json:"version"`
+	// roles are the roles of the client of the command, see Executor.ExecWithRoles
+	roles []Role
+}
+
+// CommandVersion is the version of the argument layout of the commands returned by the
+// <Name>Command functions. It is stored in the Version field of commands whose layout has
+// changed, so that Exec can still execute commands created by older versions of this package.
+// In version 0, FieldIsNull and FieldIsEmpty commands lacked the table argument.
+const CommandVersion = 1
+
+// InTx sets the transaction of a command and returns the command. Read commands such as
+// GetCommand normally see only committed data, but see the uncommitted writes of the transaction
+// if one is set with this method. For example, GetCommand(db, "Person", item, "Name").InTx(tx)
+// returns the name set by a previous SetCommand in transaction tx.
+func (cmd *Command) InTx(tx TxID) *Command {
+	cmd.Tx = tx
+	return cmd
 }
 
 // Result is a structure representing the result of a command execution via Exec().
@@ -141,25 +398,24 @@ type Command struct {
 // the numeric error code and the error message string. Otherwise the respective fields
 // are filled in, as corresponding to the return value(s) of the respective function call.
 type Result struct {
-	Str      string   `json:"str"`
-	Strings  []string `json:"strings"`
-	Int      int64    `json:"int64"`
-	Bool     bool     `json:"bool"`
-	Items    []Item   `json:"items"`
-	Values   []Value  `json:"values"`
-	Fields   []Field  `json:"fields"`
-	Bytes    []byte   `json:"binary"`
-	Ints     []int64  `json:"ints"`
-	HasError bool     `json:"iserror"`
+	Str        string             `json:"str"`
+	Strings    []string           `json:"strings"`
+	Int        int64              `json:"int64"`
+	Bool       bool               `json:"bool"`
+	Items      []Item             `json:"items"`
+	Values     []Value            `json:"values"`
+	Fields     []Field            `json:"fields"`
+	Bytes      []byte             `json:"binary"`
+	Ints       []int64            `json:"ints"`
+	Links      []Link             `json:"links"`
+	Item       map[string][]Value `json:"item"`
+	ItemValues map[Item][]Value   `json:"itemvalues"`
+	KVEntries  []KVEntry          `json:"kventries"`
+	HasError   bool               `json:"iserror"`
+	Version    int                `json:"version"`
+	Cause      int64              `json:"cause"`
 }
 
-var openDBs map[CommandDB]*MDB
-var openTxs map[TxID]*Tx
-var connections map[CommandDB]int
-var txCounter TxID
-
-var mutex sync.RWMutex
-
 // Numeric error codes returned by Exec() in a Result structure's Int field.
 const (
 	NoErr int64 = iota + 1
@@ -187,148 +443,386 @@ const (
 	ErrBeginFailed
 	ErrCommitFailed
 	ErrRollbackFailed
+	ErrRenameTableFailed
+	ErrDropTableFailed
+	ErrChangeFieldTypeFailed
+	ErrLinkFailed
+	ErrUnlinkFailed
+	ErrLinkedFailed
+	ErrReindexFailed
+	ErrExportKVFailed
+	ErrImportKVFailed
+	ErrLintFailed
+	ErrEnableTimestampsFailed
+	ErrUseItemFailed
+	ErrInvalidCommand
+	ErrCloneItemFailed
+	ErrRegisterTemplateFailed
+	ErrRunTemplateFailed
+	ErrNotPermitted
+	ErrUnknownCursor
+	ErrWriteQueueFull
+	ErrVersionFailed
+	ErrVersionConflict
+	ErrNormalizeListsFailed
+	ErrSetFieldAccessFailed
+	ErrCompactFailed
+	ErrDumpFailed
+	ErrPurgeExpiredFailed
+	ErrSetManyFailed
+	ErrInvalidFloat
+	ErrDumpKVFailed
+	ErrNoMultiDB
+	ErrNewUserFailed
+	ErrAuthenticateFailed
+	ErrLogoutFailed
+	ErrUserDBFailed
+	ErrDeleteUserFailed
+	ErrArchiveUserFailed
+	ErrChangePasswordFailed
+	ErrUnsupportedCommand
+	ErrTxExpired
+	ErrSizeFailed
+	ErrHistoryFailed
+	ErrReadOnly
+	ErrExportJSONFailed
+	ErrUnknownJob
+	ErrJobNotDone
+	ErrAuthFailed
+	ErrNotAuthenticated
+	ErrSubscribeFailed
+	ErrUnknownSubscription
+	ErrInternal
 )
 
-func getDB(cmd *Command) (*MDB, *Result) {
-	mutex.RLock()
-	defer mutex.RUnlock()
-	theDB, ok := openDBs[cmd.DB]
-	if ok {
-		return theDB, nil
+func (e *Executor) getDB(cmd *Command) (*MDB, *Result) {
+	if IsSessionDB(cmd.DB) {
+		return e.sessionDB(cmd.DB)
+	}
+	if entry := e.lookupDB(cmd.DB); entry != nil {
+		return entry.db, nil
 	}
 	r := Result{HasError: true, Int: ErrUnknownDB}
 	r.Str = Fail("exec failed: db '%s' unknown", cmd.DB).Error()
 	return nil, &r
 }
 
-func getTx(cmd *Command) (*Tx, *Result) {
-	mutex.RLock()
-	defer mutex.RUnlock()
-	theTx, ok := openTxs[cmd.Tx]
-	if ok {
-		return theTx, nil
-	}
-	r := Result{HasError: true, Int: ErrUnknownTx}
-	r.Str = Fail("exec failed: transaction '%d' unknown", int64(cmd.Tx)).Error()
-	return nil, &r
+// getTx returns the transaction of the command, which must be released with releaseTx.
+func (e *Executor) getTx(cmd *Command) (*openTx, *Result) {
+	return e.acquireTx(cmd.Tx)
 }
 
-// CloseAllDBs closes all open DB connections and cleans up resources.
-func CloseAllDBs() {
-	mutex.Lock()
-	defer mutex.Unlock()
-	for tx := range openTxs {
-		openTxs[tx].Commit()
-		openTxs[tx] = nil
+// CloseAllDBs commits all open transactions and closes all databases opened by the executor.
+func (e *Executor) CloseAllDBs() {
+	e.stopWriteQueues()
+	e.registry.Lock()
+	defer e.registry.Unlock()
+	e.txs.Range(func(id, tx interface{}) bool {
+		entry := tx.(*openTx)
+		entry.finish()
+		entry.tx.Commit()
+		e.txs.Delete(id)
+		return true
+	})
+	e.expiredTxs.Range(func(id, _ interface{}) bool {
+		e.expiredTxs.Delete(id)
+		return true
+	})
+	for name, entry := range e.openDBs() {
+		e.dbs.Delete(name)
+		entry.mutex.Lock()
+		entry.close()
+		entry.mutex.Unlock()
+		entry.db.Close()
+	}
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.cursors = make(map[string]*findCursor)
+	for _, j := range e.jobs {
+		j.cancelled = true
 	}
-	for db := range openDBs {
-		openDBs[db].Close()
-		connections[db] = 0
-		openDBs[db] = nil
+	e.jobs = make(map[string]*job)
+	for token := range e.subscriptions {
+		e.removeSubscriptionLocked(token)
 	}
 }
 
-func init() {
-	openDBs = make(map[CommandDB]*MDB)
-	connections = make(map[CommandDB]int)
-	openTxs = make(map[TxID]*Tx)
-	txCounter++
+// Executor executes commands and holds the databases and transactions opened by them.
+// Databases are shared by all clients of the executor and closed when the last client closes them.
+// Every server instance should use its own executor, several executors may coexist in one process.
+// Open databases and transactions are looked up without locking, and each database has its own
+// lock, so commands on different databases do not wait for each other. The mutex of the executor
+// only protects its settings and cursors.
+type Executor struct {
+	dbs            sync.Map // CommandDB -> *openDB
+	txs            sync.Map // TxID -> *openTx
+	expiredTxs     sync.Map // TxID -> time.Time of expiry
+	txCounter      int64
+	txTimeout      int64
+	registry       sync.Mutex
+	templates      map[string]*queryTemplate
+	templatesOnly  bool
+	admin          bool
+	readOnly       bool
+	authTokens     []string
+	authUsers      bool
+	shutdown       func()
+	allowedDirs    []string
+	noCreate       bool
+	started        time.Time
+	metrics        [maxCommand]commandMetrics
+	multiDB        *MultiDB
+	roles          []Role
+	rolePolicies   map[Role]RolePolicy
+	cursors        map[string]*findCursor
+	jobs           map[string]*job
+	subscriptions  map[string]*subscription
+	jobSlots       chan struct{}
+	writeBacklog   int64
+	backupSchedule *BackupSchedule
+	mutex          sync.RWMutex
+}
+
+// NewExecutor returns a new executor without any open databases.
+func NewExecutor() *Executor {
+	return &Executor{
+		txCounter:     1,
+		started:       time.Now(),
+		txTimeout:     int64(DefaultTxTimeout),
+		templates:     make(map[string]*queryTemplate),
+		cursors:       make(map[string]*findCursor),
+		jobs:          make(map[string]*job),
+		subscriptions: make(map[string]*subscription),
+		jobSlots:      make(chan struct{}, maxRunningJobs),
+		writeBacklog:  DefaultWriteBacklog,
+		rolePolicies:  make(map[Role]RolePolicy),
+	}
 }
 
 // Exec takes a Command structure and executes it, returning a Result or an error.
 // This function is a large switch, as a wrapper around the more specific API functions.
 // It incurs a runtime penalty and should only used when needed (e.g. when commands
-// have to be marshalled and unmarshalled).
-func Exec(cmd *Command) *Result {
+// have to be marshalled and unmarshalled). Write commands are serialized per database,
+// see SetWriteBacklog.
+func (e *Executor) Exec(cmd *Command) *Result {
+	start := time.Now()
+	var r *Result
+	var ok bool
+	if isQueuedWrite(cmd) {
+		r, ok = e.enqueueWrite(cmd)
+	}
+	if !ok {
+		r = e.exec(cmd)
+	}
+	r.Version = CommandVersion
+	e.recordCommand(cmd.ID, r.HasError, time.Since(start))
+	return r
+}
+
+// exec executes the command directly.
+func (e *Executor) exec(cmd *Command) *Result {
 	var r Result
 	var theDB *MDB
 	var theTx *Tx
 	var err error
 	var errResult *Result
 
+	if cmd.ID == CmdPing {
+		e.ping(cmd.StrArgs).toResult(&r)
+		return &r
+	}
+
+	if cmd.ID <= 0 || cmd.ID >= maxCommand || cmd.Version > CommandVersion {
+		r.HasError = true
+		r.Int = ErrUnsupportedCommand
+		r.Str = Fail("exec failed: command %d of version %d is not supported by version %d",
+			cmd.ID, cmd.Version, CommandVersion).Error()
+		return &r
+	}
+
+	if cmd.ID == CmdHello {
+		e.hello().toResult(&r)
+		return &r
+	}
+
+	if cmd.ID == CmdAuth {
+		return e.auth(cmd)
+	}
+
+	if !e.permitted(cmd.ID) {
+		r.HasError = true
+		r.Int = ErrNotPermitted
+		r.Str = Fail("exec failed: only query templates may be used").Error()
+		return &r
+	}
+
+	if !e.rolePermits(cmd.ID) || !e.clientPermits(cmd) {
+		r.HasError = true
+		r.Int = ErrNotPermitted
+		r.Str = Fail("exec failed: the command is not permitted for the roles of the client").Error()
+		return &r
+	}
+
+	if e.isReadOnly() && !readOnlyPermits(cmd.ID) {
+		r.HasError = true
+		r.Int = ErrReadOnly
+		r.setError(Fail("exec failed: command %d may change the database: %w", cmd.ID, ErrReadOnlyExecutor))
+		return &r
+	}
+
+	if cmd.ID == CmdServerShutdown {
+		return e.serverShutdown(cmd)
+	}
+
+	if cmd.ID == CmdStats {
+		e.Stats().toResult(&r)
+		return &r
+	}
+
+	if cmd.ID == CmdRegisterTemplate {
+		if len(cmd.StrArgs) != 2 {
+			r.HasError = true
+			r.Int = ErrInvalidCommand
+			r.Str = Fail("expected template name and query, given %d arguments", len(cmd.StrArgs)).Error()
+			return &r
+		}
+		if err := e.RegisterTemplate(cmd.StrArgs[0], cmd.StrArgs[1]); err != nil {
+			r.HasError = true
+			r.Int = ErrRegisterTemplateFailed
+			r.setError(err)
+		}
+		return &r
+	}
+
+	if multiUserCommands[cmd.ID] {
+		return e.execMultiUser(cmd)
+	}
+
 	if cmd.ID == CmdOpen {
-		mutex.Lock()
-		defer mutex.Unlock()
-		if _, ok := openDBs[CommandDB(cmd.StrArgs[1])]; ok {
-			connections[CommandDB(cmd.StrArgs[1])] += 1
-		} else {
-			theDB, err := Open(cmd.StrArgs[0], cmd.StrArgs[1])
-			if err != nil {
-				r.HasError = true
-				r.Int = ErrCannotOpen
-				r.Str = err.Error()
-				return &r
-			}
-			openDBs[CommandDB(cmd.StrArgs[1])] = theDB
-			connections[CommandDB(cmd.StrArgs[1])] = 1
+		if err := e.checkOpenPath(cmd.StrArgs[1]); err != nil {
+			r.HasError = true
+			r.Int = ErrNotPermitted
+			r.setError(err)
+			return &r
+		}
+		if err := e.openDatabase(cmd.StrArgs[0], CommandDB(cmd.StrArgs[1])); err != nil {
+			r.HasError = true
+			r.Int = ErrCannotOpen
+			r.setError(err)
 		}
 		return &r
 	}
 
-	if theDB, errResult = getDB(cmd); errResult != nil {
+	if theDB, errResult = e.getDB(cmd); errResult != nil {
 		return errResult
 	}
-	theTx, errResult = getTx(cmd)
+	txEntry, errResult := e.getTx(cmd)
+	if txEntry != nil {
+		defer e.releaseTx(txEntry)
+		theTx = txEntry.tx
+	}
+	if readCommands[cmd.ID] && cmd.Tx != 0 {
+		if theTx == nil {
+			return errResult
+		}
+		theDB = theTx.View()
+	}
+	if theTx != nil {
+		err = e.checkFieldAccess(theTx.View(), cmd)
+	} else {
+		err = e.checkFieldAccess(theDB, cmd)
+	}
+	if err != nil {
+		r.HasError = true
+		r.Int = ErrNotPermitted
+		r.setError(err)
+		return &r
+	}
 
 	switch cmd.ID {
 	case CmdBegin:
-		mutex.Lock()
-		defer mutex.Unlock()
-		theTx, err = theDB.Begin()
+		// the executor must not be locked while Begin waits for other transactions
+		if cmd.Tx != 0 {
+			if theTx == nil {
+				return errResult
+			}
+			theTx, err = theTx.Begin()
+		} else {
+			theTx, err = theDB.Begin()
+		}
+		if err != nil {
+			r.HasError = true
+			r.Int = ErrBeginFailed
+			r.setError(err)
+			return &r
+		}
+		r.Int = int64(e.registerTx(cmd.DB, theTx))
+
+	case CmdBeginRead:
+		theTx, err = theDB.BeginRead()
 		if err != nil {
 			r.HasError = true
 			r.Int = ErrBeginFailed
-			r.Str = err.Error()
+			r.setError(err)
 			return &r
 		}
-		txCounter++
-		openTxs[txCounter] = theTx
-		r.Int = int64(txCounter)
+		r.Int = int64(e.registerTx(cmd.DB, theTx))
 
 	case CmdCommit:
 		if theTx == nil {
 			return errResult
 		}
+		e.finishTx(cmd.Tx)
 		err = theTx.Commit()
 		if err != nil {
 			r.HasError = true
 			r.Int = ErrCommitFailed
-			r.Str = err.Error()
+			r.setError(err)
 		}
 
 	case CmdRollback:
 		if theTx == nil {
 			return errResult
 		}
+		e.finishTx(cmd.Tx)
 		err = theTx.Rollback()
 		if err != nil {
 			r.HasError = true
 			r.Int = ErrRollbackFailed
-			r.Str = err.Error()
+			r.setError(err)
 		}
 
+	case CmdListTx:
+		txListToResult(e.listTx(cmd.DB), &r)
+
 	case CmdAddTable:
 		err = theDB.AddTable(cmd.StrArgs[0], cmd.FieldArgs)
 		if err != nil {
 			r.HasError = true
 			r.Int = ErrAddTableFailed
-			r.Str = err.Error()
+			r.setError(err)
 		}
 	case CmdClose:
-		err = nil
-		mutex.Lock()
-		defer mutex.Unlock()
-		if connections[cmd.DB] == 1 {
-			err = theDB.Close()
-			delete(openDBs, cmd.DB)
-			delete(connections, cmd.DB)
-		} else {
-			connections[cmd.DB] -= 1
+		if IsSessionDB(cmd.DB) {
+			// user databases are kept open by the multiuser database
+			break
+		}
+		var closed bool
+		if closed, err = e.closeDatabase(cmd.DB); closed {
+			e.mutex.Lock()
+			for token, c := range e.cursors {
+				if c.db == cmd.DB {
+					delete(e.cursors, token)
+				}
+			}
+			e.mutex.Unlock()
+			e.removeJobs(cmd.DB)
+			e.removeSubscriptions(cmd.DB)
 		}
 		if err != nil {
 			r.HasError = true
 			r.Int = ErrClosingDB
-			r.Str = err.Error()
+			r.setError(err)
 		}
 
 	case CmdCount:
@@ -336,7 +830,7 @@ func Exec(cmd *Command) *Result {
 		if err != nil {
 			r.HasError = true
 			r.Int = ErrCountFailed
-			r.Str = err.Error()
+			r.setError(err)
 		}
 
 	case CmdFind:
@@ -344,7 +838,111 @@ func Exec(cmd *Command) *Result {
 		if err != nil {
 			r.HasError = true
 			r.Int = ErrFindFailed
-			r.Str = err.Error()
+			r.setError(err)
+		}
+
+	case CmdFindStream:
+		c := &findCursor{db: cmd.DB, tx: cmd.Tx, query: cmd.QueryArg, remaining: cmd.IntArg, chunk: cmd.IntArg2}
+		r.Items, r.Str, err = e.streamChunk(theDB, c, "")
+		if err != nil {
+			r.HasError = true
+			r.Int = ErrFindFailed
+			r.setError(err)
+		}
+
+	case CmdFindNext:
+		c, err := e.getCursor(cmd.DB, cmd.StrArgs[0])
+		if err != nil {
+			r.HasError = true
+			r.Int = ErrUnknownCursor
+			r.setError(err)
+			return &r
+		}
+		cursorDB, cursorTx, errResult := e.cursorDB(c)
+		if errResult != nil {
+			e.closeCursor(cmd.StrArgs[0])
+			return errResult
+		}
+		defer e.releaseTx(cursorTx)
+		r.Items, r.Str, err = e.streamChunk(cursorDB, c, cmd.StrArgs[0])
+		if err != nil {
+			r.HasError = true
+			r.Int = ErrFindFailed
+			r.setError(err)
+		}
+
+	case CmdFindClose, CmdCursorClose:
+		e.closeCursor(cmd.StrArgs[0])
+
+	case CmdFindOpen:
+		field := ""
+		if len(cmd.StrArgs) > 0 {
+			field = cmd.StrArgs[0]
+		}
+		if err = checkCursorField(theDB, &cmd.QueryArg, field); err != nil {
+			r.HasError = true
+			r.Int = ErrFindFailed
+			r.setError(err)
+			return &r
+		}
+		c := &findCursor{db: cmd.DB, tx: cmd.Tx, query: cmd.QueryArg, field: field, remaining: cmd.IntArg, chunk: cmd.IntArg2}
+		r.Str, err = e.openCursor(c)
+		if err != nil {
+			r.HasError = true
+			r.Int = ErrFindFailed
+			r.setError(err)
+		}
+
+	case CmdFetch:
+		c, err := e.getCursor(cmd.DB, cmd.StrArgs[0])
+		if err != nil {
+			r.HasError = true
+			r.Int = ErrUnknownCursor
+			r.setError(err)
+			return &r
+		}
+		cursorDB, cursorTx, errResult := e.cursorDB(c)
+		if errResult != nil {
+			e.closeCursor(cmd.StrArgs[0])
+			return errResult
+		}
+		defer e.releaseTx(cursorTx)
+		if cmd.IntArg > 0 {
+			c.chunk = cmd.IntArg
+		}
+		r.Items, r.Str, err = e.streamChunk(cursorDB, c, cmd.StrArgs[0])
+		if err == nil {
+			r.ItemValues, err = fetchValues(cursorDB, c, r.Items)
+		}
+		if err != nil {
+			r.HasError = true
+			r.Int = ErrFindFailed
+			r.setError(err)
+		}
+
+	case CmdFindWithin:
+		r.Items, err = theDB.FindWithin(&(cmd.QueryArg), cmd.ItemArgs, cmd.IntArg)
+		if err != nil {
+			r.HasError = true
+			r.Int = ErrFindFailed
+			r.setError(err)
+		}
+
+	case CmdRunTemplate:
+		if len(cmd.StrArgs) != 1 {
+			r.HasError = true
+			r.Int = ErrInvalidCommand
+			r.Str = Fail("expected template name, given %d arguments", len(cmd.StrArgs)).Error()
+			return &r
+		}
+		query, err := e.bindTemplate(cmd.StrArgs[0], cmd.ValueMap)
+		if err == nil {
+			r.Items, err = theDB.Find(query, cmd.IntArg)
+		}
+		if err != nil {
+			r.HasError = true
+			r.Int = ErrRunTemplateFailed
+			r.setError(err)
 		}
 
 	case CmdGet:
@@ -352,14 +950,164 @@ func Exec(cmd *Command) *Result {
 		if err != nil {
 			r.HasError = true
 			r.Int = ErrGetFailed
-			r.Str = err.Error()
+			r.setError(err)
+		}
+	case CmdGetOrEmpty:
+		r.Values, err = theDB.GetOrEmpty(cmd.StrArgs[0], cmd.ItemArg, cmd.StrArgs[1])
+		if err != nil {
+			r.HasError = true
+			r.Int = ErrGetFailed
+			r.setError(err)
+		}
+	case CmdGetItem:
+		r.Item, err = theDB.GetItem(cmd.StrArgs[0], cmd.ItemArg)
+		if err != nil {
+			r.HasError = true
+			r.Int = ErrGetFailed
+			r.setError(err)
+		}
+	case CmdGetMulti:
+		r.ItemValues, err = theDB.GetMulti(cmd.StrArgs[0], cmd.ItemArgs, cmd.StrArgs[1])
+		if err != nil {
+			r.HasError = true
+			r.Int = ErrGetFailed
+			r.setError(err)
+		}
+	case CmdLintSchema:
+		var warnings []LintWarning
+		warnings, err = theDB.LintSchema()
+		if err != nil {
+			r.HasError = true
+			r.Int = ErrLintFailed
+			r.setError(err)
+		} else {
+			r.Strings = make([]string, len(warnings))
+			for i := range warnings {
+				r.Strings[i] = warnings[i].String()
+			}
+		}
+	case CmdExportKV:
+		var buff strings.Builder
+		err = theDB.ExportKV(&buff)
+		if err != nil {
+			r.HasError = true
+			r.Int = ErrExportKVFailed
+			r.setError(err)
+		} else {
+			r.Str = buff.String()
+		}
+	case CmdImportKV:
+		if theTx == nil {
+			return errResult
+		}
+		err = theTx.ImportKV(strings.NewReader(cmd.StrArgs[0]))
+		if err != nil {
+			r.HasError = true
+			r.Int = ErrImportKVFailed
+			r.setError(err)
+		}
+	case CmdSetItem:
+		if theTx == nil {
+			return errResult
+		}
+		err = theTx.SetItem(cmd.StrArgs[0], cmd.ItemArg, cmd.ValueMap)
+		if err != nil {
+			r.HasError = true
+			r.Int = ErrSetFailed
+			r.setError(err)
 		}
 	case CmdBackup:
+		if err = e.checkWritePath(cmd.StrArgs[0]); err != nil {
+			r.HasError = true
+			r.Int = ErrNotPermitted
+			r.setError(err)
+			break
+		}
 		err = theDB.Backup(cmd.StrArgs[0])
 		if err != nil {
 			r.HasError = true
 			r.Int = ErrBackupFailed
-			r.Str = err.Error()
+			r.setError(err)
+		}
+
+	case CmdNormalizeLists:
+		err = theDB.NormalizeLists()
+		if err != nil {
+			r.HasError = true
+			r.Int = ErrNormalizeListsFailed
+			r.setError(err)
+		}
+
+	case CmdCompact:
+		r.Int, err = theDB.Compact()
+		if err != nil {
+			r.HasError = true
+			r.Int = ErrCompactFailed
+			r.setError(err)
+		}
+
+	case CmdSetMany:
+		if theTx == nil {
+			return errResult
+		}
+		if len(cmd.ItemArgs) != len(cmd.ValueArgs) {
+			r.HasError = true
+			r.Int = ErrInvalidCommand
+			r.Str = Fail("expected a value for each key, given %d keys and %d values", len(cmd.ItemArgs), len(cmd.ValueArgs)).Error()
+			return &r
+		}
+		values := make(map[int64]Value, len(cmd.ItemArgs))
+		for i, key := range cmd.ItemArgs {
+			values[int64(key)] = cmd.ValueArgs[i]
+		}
+		if err := theTx.SetMany(FieldType(cmd.IntArg), values); err != nil {
+			r.HasError = true
+			r.Int = ErrSetManyFailed
+			r.setError(err)
+		}
+
+	case CmdGetMany:
+		keys := make([]int64, len(cmd.ItemArgs))
+		for i, key := range cmd.ItemArgs {
+			keys[i] = int64(key)
+		}
+		values, err := theDB.GetMany(FieldType(cmd.IntArg), keys)
+		if err != nil {
+			r.HasError = true
+			r.Int = ErrGetFailed
+			r.setError(err)
+		} else {
+			r.ItemValues = make(map[Item][]Value, len(values))
+			for key, v := range values {
+				r.ItemValues[Item(key)] = []Value{v}
+			}
+		}
+
+	case CmdDumpKV:
+		r.KVEntries, err = theDB.DumpKV()
+		if err != nil {
+			r.HasError = true
+			r.Int = ErrDumpKVFailed
+			r.setError(err)
+		}
+
+	case CmdPurgeExpired:
+		r.Int, err = theDB.PurgeExpired()
+		if err != nil {
+			r.HasError = true
+			r.Int = ErrPurgeExpiredFailed
+			r.setError(err)
+		}
+
+	case CmdDump:
+		var buff strings.Builder
+		err = theDB.Dump(&buff)
+		if err != nil {
+			r.HasError = true
+			r.Int = ErrDumpFailed
+			r.setError(err)
+		} else {
+			r.Str = buff.String()
 		}
 
 	case CmdGetTables:
@@ -376,7 +1124,7 @@ func Exec(cmd *Command) *Result {
 		if err != nil {
 			r.HasError = true
 			r.Int = ErrListItemsFailed
-			r.Str = err.Error()
+			r.setError(err)
 		}
 
 	case CmdNewItem:
@@ -384,18 +1132,74 @@ func Exec(cmd *Command) *Result {
 		if err != nil {
 			r.HasError = true
 			r.Int = ErrNewItemFailed
-			r.Str = err.Error()
+			r.setError(err)
 			return &r
 		}
 		r.Items = make([]Item, 1)
 		r.Items[0] = item
 
+	case CmdUseItem:
+		item, err := theDB.UseItem(cmd.StrArgs[0], uint64(cmd.IntArg))
+		if err != nil {
+			r.HasError = true
+			r.Int = ErrUseItemFailed
+			r.setError(err)
+			return &r
+		}
+		r.Items = []Item{item}
+
+	case CmdCloneItem:
+		if theTx == nil {
+			return errResult
+		}
+		item, err := theTx.CloneItem(cmd.StrArgs[0], cmd.ItemArg)
+		if err != nil {
+			r.HasError = true
+			r.Int = ErrCloneItemFailed
+			r.setError(err)
+			return &r
+		}
+		r.Items = []Item{item}
+
 	case CmdParseFieldValues:
 		r.Values, err = theDB.ParseFieldValues(cmd.StrArgs[0], cmd.StrArgs[1], cmd.StrArgs[2:])
 		if err != nil {
 			r.HasError = true
 			r.Int = ErrParseFieldValuesFailed
-			r.Str = err.Error()
+			r.setError(err)
+		}
+
+	case CmdVersion:
+		r.Int, err = theDB.Version(cmd.StrArgs[0], cmd.ItemArg)
+		if err != nil {
+			r.HasError = true
+			r.Int = ErrVersionFailed
+			r.setError(err)
+		}
+
+	case CmdSetIfVersion:
+		if theTx == nil {
+			return errResult
+		}
+		err = theTx.SetIfVersion(cmd.StrArgs[0], cmd.ItemArg, cmd.StrArgs[1], cmd.ValueArgs, cmd.IntArg)
+		if err != nil {
+			r.HasError = true
+			r.Int = ErrSetFailed
+			if _, ok := err.(*VersionConflictError); ok {
+				r.Int = ErrVersionConflict
+			}
+			r.setError(err)
+		}
+
+	case CmdSetFieldAccess:
+		if theTx == nil {
+			return errResult
+		}
+		err = theTx.SetFieldAccess(cmd.StrArgs[0], cmd.StrArgs[1], FieldAccess(cmd.IntArg))
+		if err != nil {
+			r.HasError = true
+			r.Int = ErrSetFieldAccessFailed
+			r.setError(err)
 		}
 
 	case CmdSet:
@@ -406,7 +1210,7 @@ func Exec(cmd *Command) *Result {
 		if err != nil {
 			r.HasError = true
 			r.Int = ErrSetFailed
-			r.Str = err.Error()
+			r.setError(err)
 		}
 
 	case CmdRemoveItem:
@@ -417,7 +1221,18 @@ func Exec(cmd *Command) *Result {
 		if err != nil {
 			r.HasError = true
 			r.Int = ErrRemoveItemFailed
-			r.Str = err.Error()
+			r.setError(err)
+		}
+
+	case CmdRemoveItems:
+		if theTx == nil {
+			return errResult
+		}
+		err = theTx.RemoveItems(cmd.StrArgs[0], cmd.ItemArgs)
+		if err != nil {
+			r.HasError = true
+			r.Int = ErrRemoveItemFailed
+			r.setError(err)
 		}
 
 	case CmdTableExists:
@@ -428,17 +1243,23 @@ func Exec(cmd *Command) *Result {
 		if err != nil {
 			r.HasError = true
 			r.Int = ErrToSQLFailed
-			r.Str = err.Error()
+			r.setError(err)
 			return &r
 		}
 		r.Strings = make([]string, 1)
 		r.Strings[0] = s
 
-	case CmdFieldIsNull:
-		r.Bool = theDB.FieldIsNull(cmd.StrArgs[0], cmd.ItemArg, cmd.StrArgs[1])
-
-	case CmdFieldIsEmpty:
-		r.Bool = theDB.FieldIsEmpty(cmd.StrArgs[0], cmd.ItemArg, cmd.StrArgs[1])
+	case CmdFieldIsNull, CmdFieldIsEmpty:
+		table, field, err := tableAndField(theDB, cmd)
+		if err != nil {
+			r.HasError = true
+			r.Int = ErrInvalidCommand
+			r.setError(err)
+		} else if cmd.ID == CmdFieldIsNull {
+			r.Bool = theDB.FieldIsNull(table, cmd.ItemArg, field)
+		} else {
+			r.Bool = theDB.FieldIsEmpty(table, cmd.ItemArg, field)
+		}
 
 	case CmdFieldExists:
 		r.Bool = theDB.FieldExists(cmd.StrArgs[0], cmd.StrArgs[1])
@@ -448,7 +1269,7 @@ func Exec(cmd *Command) *Result {
 		if err != nil {
 			r.HasError = true
 			r.Int = ErrGetFieldsFailed
-			r.Str = err.Error()
+			r.setError(err)
 		}
 
 	case CmdIsEmptyListField:
@@ -458,16 +1279,16 @@ func Exec(cmd *Command) *Result {
 		r.Int = int64(theDB.MustGetFieldType(cmd.StrArgs[0], cmd.StrArgs[1]))
 
 	case CmdGetInt:
-		r.Int = theDB.GetInt(cmd.IntArg)
+		r.Int, r.Bool = theDB.LookupInt(cmd.IntArg)
 
 	case CmdGetStr:
-		r.Str = theDB.GetStr(cmd.IntArg)
+		r.Str, r.Bool = theDB.LookupStr(cmd.IntArg)
 
 	case CmdGetBlob:
-		r.Bytes = theDB.GetBlob(cmd.IntArg)
+		r.Bytes, r.Bool = theDB.LookupBlob(cmd.IntArg)
 
 	case CmdGetDate:
-		r.Str = theDB.GetDateStr(cmd.IntArg)
+		r.Str, r.Bool = theDB.lookupStr(cmd.IntArg, "_KVDATE")
 
 	case CmdSetInt:
 		if theTx == nil {
@@ -495,7 +1316,7 @@ func Exec(cmd *Command) *Result {
 		if err != nil {
 			r.HasError = true
 			r.Int = ErrInvalidDate
-			r.Str = err.Error()
+			r.setError(err)
 		} else {
 			theTx.SetDate(cmd.IntArg, t)
 		}
@@ -554,6 +1375,133 @@ func Exec(cmd *Command) *Result {
 	case CmdListDate:
 		r.Ints = theDB.ListDate()
 
+	case CmdGetIntKey:
+		r.Int, r.Bool = theDB.LookupIntKey(cmd.StrArgs[0])
+
+	case CmdGetStrKey:
+		r.Str, r.Bool = theDB.LookupStrKey(cmd.StrArgs[0])
+
+	case CmdGetBlobKey:
+		r.Bytes, r.Bool = theDB.LookupBlobKey(cmd.StrArgs[0])
+
+	case CmdGetDateKey:
+		r.Str, r.Bool = theDB.lookupStr(cmd.StrArgs[0], "_KVSDATE")
+
+	case CmdSetIntKey:
+		if theTx == nil {
+			return errResult
+		}
+		theTx.SetIntKey(cmd.StrArgs[0], cmd.IntArg)
+
+	case CmdSetStrKey:
+		if theTx == nil {
+			return errResult
+		}
+		theTx.SetStrKey(cmd.StrArgs[0], cmd.StrArgs[1])
+
+	case CmdSetBlobKey:
+		if theTx == nil {
+			return errResult
+		}
+		theTx.SetBlobKey(cmd.StrArgs[0], []byte(cmd.StrArgs[1]))
+
+	case CmdSetDateKey:
+		if theTx == nil {
+			return errResult
+		}
+		t, err := ParseTime(cmd.StrArgs[1])
+		if err != nil {
+			r.HasError = true
+			r.Int = ErrInvalidDate
+			r.setError(err)
+		} else {
+			theTx.SetDateKey(cmd.StrArgs[0], t)
+		}
+
+	case CmdSetDateStrKey:
+		if theTx == nil {
+			return errResult
+		}
+		theTx.SetDateStrKey(cmd.StrArgs[0], cmd.StrArgs[1])
+
+	case CmdHasIntKey:
+		r.Bool = theDB.HasIntKey(cmd.StrArgs[0])
+
+	case CmdHasStrKey:
+		r.Bool = theDB.HasStrKey(cmd.StrArgs[0])
+
+	case CmdHasBlobKey:
+		r.Bool = theDB.HasBlobKey(cmd.StrArgs[0])
+
+	case CmdHasDateKey:
+		r.Bool = theDB.HasDateKey(cmd.StrArgs[0])
+
+	case CmdDeleteIntKey:
+		if theTx == nil {
+			return errResult
+		}
+		theTx.DeleteIntKey(cmd.StrArgs[0])
+
+	case CmdDeleteStrKey:
+		if theTx == nil {
+			return errResult
+		}
+		theTx.DeleteStrKey(cmd.StrArgs[0])
+
+	case CmdDeleteBlobKey:
+		if theTx == nil {
+			return errResult
+		}
+		theTx.DeleteBlobKey(cmd.StrArgs[0])
+
+	case CmdDeleteDateKey:
+		if theTx == nil {
+			return errResult
+		}
+		theTx.DeleteDateKey(cmd.StrArgs[0])
+
+	case CmdGetFloat:
+		var f float64
+		f, r.Bool = theDB.LookupFloat(cmd.IntArg)
+		r.Str = formatFloat(f)
+
+	case CmdSetFloat:
+		if theTx == nil {
+			return errResult
+		}
+		f, err := strconv.ParseFloat(cmd.StrArgs[0], 64)
+		if err != nil {
+			r.HasError = true
+			r.Int = ErrInvalidFloat
+			r.setError(err)
+		} else {
+			theTx.SetFloat(cmd.IntArg, f)
+		}
+
+	case CmdHasFloat:
+		r.Bool = theDB.HasFloat(cmd.IntArg)
+
+	case CmdDeleteFloat:
+		if theTx == nil {
+			return errResult
+		}
+		theTx.DeleteFloat(cmd.IntArg)
+
+	case CmdListFloat:
+		r.Ints = theDB.ListFloat()
+
+	case CmdListIntKeys:
+		r.Strings = theDB.ListIntKeys()
+
+	case CmdListStrKeys:
+		r.Strings = theDB.ListStrKeys()
+
+	case CmdListBlobKeys:
+		r.Strings = theDB.ListBlobKeys()
+
+	case CmdListDateKeys:
+		r.Strings = theDB.ListDateKeys()
+
 	case CmdIndex:
 		if theTx == nil {
 			return errResult
@@ -562,16 +1510,307 @@ func Exec(cmd *Command) *Result {
 		if err != nil {
 			r.HasError = true
 			r.Int = ErrIndexFailed
-			r.Str = err.Error()
+			r.setError(err)
+		}
+
+	case CmdRenameTable:
+		if theTx == nil {
+			return errResult
+		}
+		err := theTx.RenameTable(cmd.StrArgs[0], cmd.StrArgs[1])
+		if err != nil {
+			r.HasError = true
+			r.Int = ErrRenameTableFailed
+			r.setError(err)
+		}
+
+	case CmdDropTable:
+		if theTx == nil {
+			return errResult
+		}
+		err := theTx.DropTable(cmd.StrArgs[0])
+		if err != nil {
+			r.HasError = true
+			r.Int = ErrDropTableFailed
+			r.setError(err)
+		}
+
+	case CmdChangeFieldType:
+		if theTx == nil {
+			return errResult
+		}
+		r.Items, err = theTx.ChangeFieldType(cmd.StrArgs[0], cmd.StrArgs[1], FieldType(cmd.IntArg))
+		if err != nil {
+			r.HasError = true
+			r.Int = ErrChangeFieldTypeFailed
+			r.setError(err)
+		}
+
+	case CmdLink:
+		if theTx == nil {
+			return errResult
+		}
+		err := theTx.Link(cmd.StrArgs[0], cmd.ItemArgs[0], cmd.StrArgs[1], cmd.ItemArgs[1], cmd.StrArgs[2])
+		if err != nil {
+			r.HasError = true
+			r.Int = ErrLinkFailed
+			r.setError(err)
+		}
+
+	case CmdUnlink:
+		if theTx == nil {
+			return errResult
+		}
+		err := theTx.Unlink(cmd.StrArgs[0], cmd.ItemArgs[0], cmd.StrArgs[1], cmd.ItemArgs[1], cmd.StrArgs[2])
+		if err != nil {
+			r.HasError = true
+			r.Int = ErrUnlinkFailed
+			r.setError(err)
+		}
+
+	case CmdLinked:
+		r.Links, err = theDB.Linked(cmd.StrArgs[0], cmd.ItemArg, cmd.StrArgs[1])
+		if err != nil {
+			r.HasError = true
+			r.Int = ErrLinkedFailed
+			r.setError(err)
+		}
+
+	case CmdReindex:
+		if theTx == nil {
+			return errResult
+		}
+		err := theTx.Reindex(cmd.StrArgs[0])
+		if err != nil {
+			r.HasError = true
+			r.Int = ErrReindexFailed
+			r.setError(err)
+		}
+
+	case CmdEnableTimestamps:
+		if theTx == nil {
+			return errResult
+		}
+		err := theTx.EnableTimestamps(cmd.StrArgs[0])
+		if err != nil {
+			r.HasError = true
+			r.Int = ErrEnableTimestampsFailed
+			r.setError(err)
+		}
+
+	case CmdEnableHistory, CmdDisableHistory:
+		if theTx == nil {
+			return errResult
+		}
+		if cmd.ID == CmdEnableHistory {
+			err = theTx.EnableHistory(cmd.StrArgs[0], time.Duration(cmd.IntArg)*time.Millisecond)
+		} else {
+			err = theTx.DisableHistory(cmd.StrArgs[0])
+		}
+		if err != nil {
+			r.HasError = true
+			r.Int = ErrHistoryFailed
+			r.setError(err)
+		}
+
+	case CmdCountQuery:
+		r.Int, err = theDB.CountQuery(&(cmd.QueryArg))
+		if err != nil {
+			r.HasError = true
+			r.Int = ErrCountFailed
+			r.setError(err)
+		}
+
+	case CmdTableSize:
+		r.Int, err = theDB.TableSize(cmd.StrArgs[0])
+		if err != nil {
+			r.HasError = true
+			r.Int = ErrSizeFailed
+			r.setError(err)
+		}
+
+	case CmdDatabaseSize:
+		size, err := theDB.DatabaseSize()
+		if err != nil {
+			r.HasError = true
+			r.Int = ErrSizeFailed
+			r.setError(err)
+		} else {
+			r.Ints = []int64{size.FileSize, size.PageSize, size.PageCount, size.FreelistCount}
+		}
+
+	case CmdHasTimestamps:
+		r.Bool = theDB.HasTimestamps(cmd.StrArgs[0])
+
+	case CmdListIntRange:
+		r.Ints = theDB.ListIntRange(cmd.IntArg, cmd.IntArg2)
+
+	case CmdListStrRange:
+		r.Ints = theDB.ListStrRange(cmd.IntArg, cmd.IntArg2)
+
+	case CmdListBlobRange:
+		r.Ints = theDB.ListBlobRange(cmd.IntArg, cmd.IntArg2)
+
+	case CmdListDateRange:
+		r.Ints = theDB.ListDateRange(cmd.IntArg, cmd.IntArg2)
+
+	case CmdListFloatRange:
+		r.Ints = theDB.ListFloatRange(cmd.IntArg, cmd.IntArg2)
+
+	case CmdFindStrValue:
+		r.Ints, err = theDB.FindStrValue(cmd.StrArgs[0])
+		if err != nil {
+			r.HasError = true
+			r.Int = ErrFindFailed
+			r.setError(err)
+		}
+
+	case CmdFindFloatValues:
+		var min, max float64
+		if min, err = strconv.ParseFloat(cmd.StrArgs[0], 64); err == nil {
+			max, err = strconv.ParseFloat(cmd.StrArgs[1], 64)
+		}
+		if err != nil {
+			r.HasError = true
+			r.Int = ErrInvalidFloat
+			r.setError(err)
+			return &r
+		}
+		r.Ints, err = theDB.FindFloatValues(min, max)
+		if err != nil {
+			r.HasError = true
+			r.Int = ErrFindFailed
+			r.setError(err)
+		}
+
+	case CmdExportJSON:
+		var buff strings.Builder
+		err = theDB.ExportJSON(&buff, cmd.StrArgs...)
+		if err != nil {
+			r.HasError = true
+			r.Int = ErrExportJSONFailed
+			r.setError(err)
+		} else {
+			r.Str = buff.String()
+		}
+
+	case CmdSubmit:
+		var submitted Command
+		if err = json.Unmarshal([]byte(cmd.StrArgs[0]), &submitted); err == nil && submitted.DB != cmd.DB {
+			err = Fail("the submitted command is for db '%s', not '%s'", submitted.DB, cmd.DB)
+		}
+		if err == nil {
+			// the job is executed with the roles of the client that submitted it
+			submitted.roles = cmd.roles
+			r.Str, err = e.submitJob(&submitted)
+		}
+		if err != nil {
+			r.HasError = true
+			r.Int = ErrInvalidCommand
+			r.setError(err)
+		}
+
+	case CmdJobStatus, CmdJobResult, CmdJobCancel:
+		j, err := e.getJob(cmd.DB, cmd.StrArgs[0])
+		if err != nil {
+			r.HasError = true
+			r.Int = ErrUnknownJob
+			r.setError(err)
+			return &r
+		}
+		switch cmd.ID {
+		case CmdJobStatus:
+			state := e.jobState(j)
+			r.Int = int64(state)
+			r.Bool = state == JobDone
+		case CmdJobResult:
+			if result := e.takeJobResult(cmd.StrArgs[0], j); result != nil {
+				return result
+			}
+			r.HasError = true
+			r.Int = ErrJobNotDone
+			r.Str = Fail("exec failed: job '%s' is not done yet", cmd.StrArgs[0]).Error()
+		case CmdJobCancel:
+			e.cancelJob(cmd.StrArgs[0], j)
+		}
+
+	case CmdSubscribe, CmdSubscribeQuery:
+		var query *Query
+		table := ""
+		if cmd.ID == CmdSubscribeQuery {
+			query = &cmd.QueryArg
+		} else {
+			table = cmd.StrArgs[0]
+		}
+		token, err := e.subscribe(cmd.DB, theDB, table, query)
+		if err != nil {
+			r.HasError = true
+			r.Int = ErrSubscribeFailed
+			r.setError(err)
+		} else {
+			r.Str = token
+		}
+
+	case CmdPoll, CmdUnsubscribe:
+		s, err := e.getSubscription(cmd.DB, cmd.StrArgs[0])
+		if err != nil {
+			r.HasError = true
+			r.Int = ErrUnknownSubscription
+			r.setError(err)
+			return &r
+		}
+		if cmd.ID == CmdPoll {
+			events, lost := s.poll(time.Duration(cmd.IntArg) * time.Millisecond)
+			eventsToResult(events, lost, &r)
+		} else {
+			e.unsubscribe(cmd.StrArgs[0])
+		}
+
+	case CmdHistoryOf:
+		changes, err := theDB.HistoryOf(cmd.StrArgs[0], cmd.ItemArg, cmd.StrArgs[1])
+		if err != nil {
+			r.HasError = true
+			r.Int = ErrHistoryFailed
+			r.setError(err)
+		} else {
+			historyToResult(changes, &r)
 		}
 
 	default:
 		r.HasError = true
+		r.Int = ErrUnsupportedCommand
 		r.Str = Fail("exec failed: unhandled command").Error()
 	}
 	return &r
 }
 
+// tableAndField returns the table and field arguments of a FieldIsNull or FieldIsEmpty command.
+// Commands of version 0 only contain the field, so the table is the only one that has both the
+// field and the item. An error is returned if there is no such table or more than one.
+func tableAndField(db *MDB, cmd *Command) (string, string, error) {
+	if cmd.Version >= 1 {
+		if len(cmd.StrArgs) != 2 {
+			return "", "", Fail("expected table and field arguments, given %d arguments", len(cmd.StrArgs))
+		}
+		return cmd.StrArgs[0], cmd.StrArgs[1], nil
+	}
+	if len(cmd.StrArgs) != 1 {
+		return "", "", Fail("expected field argument, given %d arguments", len(cmd.StrArgs))
+	}
+	field := cmd.StrArgs[0]
+	tables := make([]string, 0, 1)
+	for _, table := range db.GetTables() {
+		if db.FieldExists(table, field) && db.ItemExists(table, cmd.ItemArg) {
+			tables = append(tables, table)
+		}
+	}
+	if len(tables) != 1 {
+		return "", "", Fail("cannot determine the table of field '%s' and item %d in command without table, found %d candidates",
+			field, cmd.ItemArg, len(tables))
+	}
+	return tables[0], field, nil
+}
+
 // OpenCommand returns a pointer to a command structure for mdb.Open().
 func OpenCommand(driver string, file string) *Command {
 	return &Command{
@@ -580,6 +1819,21 @@ func OpenCommand(driver string, file string) *Command {
 	}
 }
 
+// PingCommand returns a pointer to a command structure that can be used to check that a server
+// is responsive. It does not need an open database or an authenticated connection. The result
+// holds the uptime of the executor and how many clients have opened each of the given databases,
+// see ParsePing.
+func PingCommand(dbs ...CommandDB) *Command {
+	names := make([]string, len(dbs))
+	for i, db := range dbs {
+		names[i] = string(db)
+	}
+	return &Command{
+		ID:      CmdPing,
+		StrArgs: names,
+	}
+}
+
 // BeginCommand returns a pointer to a command structure for mdb.Begin().
 func BeginCommand(db CommandDB) *Command {
 	return &Command{
@@ -588,6 +1842,24 @@ func BeginCommand(db CommandDB) *Command {
 	}
 }
 
+// BeginReadCommand returns a pointer to a command structure for db.BeginRead(). Read commands
+// given the transaction with InTx see the database as it was when the transaction began.
+func BeginReadCommand(db CommandDB) *Command {
+	return &Command{
+		ID: CmdBeginRead,
+		DB: db,
+	}
+}
+
+// BeginNestedCommand returns a pointer to a command structure for tx.Begin().
+func BeginNestedCommand(db CommandDB, tx TxID) *Command {
+	return &Command{
+		ID: CmdBegin,
+		DB: db,
+		Tx: tx,
+	}
+}
+
 // CommitCommand returns a pointer to a command structure for tx.Commit().
 func CommitCommand(db CommandDB, tx TxID) *Command {
 	return &Command{
@@ -643,22 +1915,24 @@ func FieldExistsCommand(db CommandDB, table string, field string) *Command {
 }
 
 // FieldIsNullCommand returns a pointer to a command structure for tx.FieldIsNull().
-func FieldIsNullCommand(db CommandDB, item Item, field string) *Command {
+func FieldIsNullCommand(db CommandDB, table string, item Item, field string) *Command {
 	return &Command{
 		ID:      CmdFieldIsNull,
 		DB:      db,
-		StrArgs: []string{field},
+		StrArgs: []string{table, field},
 		ItemArg: item,
+		Version: CommandVersion,
 	}
 }
 
 // FieldIsEmptyCommand returns a pointer to a command structure for tx.FieldIsEmpty().
-func FieldIsEmptyCommand(db CommandDB, item Item, field string) *Command {
+func FieldIsEmptyCommand(db CommandDB, table string, item Item, field string) *Command {
 	return &Command{
 		ID:      CmdFieldIsEmpty,
 		DB:      db,
-		StrArgs: []string{field},
+		StrArgs: []string{table, field},
 		ItemArg: item,
+		Version: CommandVersion,
 	}
 }
 
@@ -672,34 +1946,154 @@ func FindCommand(db CommandDB, query *Query, limit int64) *Command {
 	}
 }
 
-// GetCommand returns a pointer to a command structure for tx.Get().
-func GetCommand(db CommandDB, table string, item Item, field string) *Command {
+// FindWithinCommand returns a pointer to a command structure for tx.FindWithin().
+func FindWithinCommand(db CommandDB, query *Query, items []Item, limit int64) *Command {
 	return &Command{
-		ID:      CmdGet,
-		DB:      db,
-		StrArgs: []string{table, field},
-		ItemArg: item,
+		ID:       CmdFindWithin,
+		DB:       db,
+		QueryArg: *query,
+		ItemArgs: items,
+		IntArg:   limit,
 	}
 }
 
-// GetFieldsCommand returns a pointer to a command structure for tx.GetFields().
-func GetFieldsCommand(db CommandDB, table string) *Command {
+// RegisterTemplateCommand returns a pointer to a command structure for Executor.RegisterTemplate().
+// Unlike most commands, it does not need an open database.
+func RegisterTemplateCommand(name string, query string) *Command {
 	return &Command{
-		ID:      CmdGetFields,
-		DB:      db,
-		StrArgs: []string{table},
+		ID:      CmdRegisterTemplate,
+		StrArgs: []string{name, query},
 	}
 }
 
-// GetTablesCommand returns a pointer to a command structure for tx.GetTables().
-func GetTablesCommand(db CommandDB) *Command {
+// RunTemplateCommand returns a pointer to a command structure that finds at most limit items
+// with the template registered under the given name, like FindCommand does with a query.
+// The params map the names of the template's parameters, without the $, to their values.
+func RunTemplateCommand(db CommandDB, name string, params map[string]string, limit int64) *Command {
+	values := make(map[string][]Value, len(params))
+	for param, value := range params {
+		values[param] = []Value{NewString(value)}
+	}
 	return &Command{
-		ID: CmdGetTables,
-		DB: db,
+		ID:       CmdRunTemplate,
+		DB:       db,
+		StrArgs:  []string{name},
+		ValueMap: values,
+		IntArg:   limit,
 	}
 }
 
-// IsEmptyListFieldCommand returns a pointer to a command structure for tx.IsEmptyListField().
+// FindStreamCommand returns a pointer to a command structure that finds at most limit items
+// matching the query in ascending order of their IDs, like db.FindAfter(). The result contains
+// up to chunk items, or DefaultStreamChunk items if chunk is 0, and its Str field contains a cursor
+// if more items may follow. The next chunks are returned by FindNextCommand with that cursor until
+// Str is empty. Items that are added or removed while the results are streamed may or may not be found.
+func FindStreamCommand(db CommandDB, query *Query, limit int64, chunk int64) *Command {
+	return &Command{
+		ID:       CmdFindStream,
+		DB:       db,
+		QueryArg: *query,
+		IntArg:   limit,
+		IntArg2:  chunk,
+	}
+}
+
+// FindNextCommand returns a pointer to a command structure that returns the next chunk of
+// items of a cursor returned by FindStreamCommand, see there.
+func FindNextCommand(db CommandDB, cursor string) *Command {
+	return &Command{
+		ID:      CmdFindNext,
+		DB:      db,
+		StrArgs: []string{cursor},
+	}
+}
+
+// FindOpenCommand returns a pointer to a command structure that opens a cursor for at most limit
+// items matching the query in ascending order of their IDs, or all of them if limit is 0. Unlike
+// FindStreamCommand, it returns no items, only the cursor in the Str field of the result. The
+// items are fetched in chunks with FetchCommand, and if field is not empty, the values of that
+// field of the table of the query are fetched with them, so large results never have to fit into
+// one message. Cursors are removed when they are exhausted, see CursorCloseCommand.
+func FindOpenCommand(db CommandDB, query *Query, limit int64, field string) *Command {
+	cmd := &Command{
+		ID:       CmdFindOpen,
+		DB:       db,
+		QueryArg: *query,
+		IntArg:   limit,
+		Version:  CommandVersion,
+	}
+	if field != "" {
+		cmd.StrArgs = []string{field}
+	}
+	return cmd
+}
+
+// FetchCommand returns a pointer to a command structure that returns the next n items of a
+// cursor returned by FindOpenCommand in the Items field of the result, or as many as the previous
+// fetch if n is 0, at first DefaultStreamChunk. If the cursor has a field, the values of the items
+// are returned in ItemValues. The Str field contains the cursor if more items may follow and is
+// empty once the cursor is exhausted.
+func FetchCommand(db CommandDB, cursor string, n int64) *Command {
+	return &Command{
+		ID:      CmdFetch,
+		DB:      db,
+		StrArgs: []string{cursor},
+		IntArg:  n,
+		Version: CommandVersion,
+	}
+}
+
+// CursorCloseCommand returns a pointer to a command structure that removes a cursor returned by
+// FindOpenCommand before all items have been fetched. Cursors that have not been used for ten
+// minutes are removed automatically.
+func CursorCloseCommand(db CommandDB, cursor string) *Command {
+	return &Command{
+		ID:      CmdCursorClose,
+		DB:      db,
+		StrArgs: []string{cursor},
+		Version: CommandVersion,
+	}
+}
+
+// FindCloseCommand returns a pointer to a command structure that removes a cursor returned by
+// FindStreamCommand before all items have been returned. Cursors are removed automatically
+// when they are exhausted or have not been used for ten minutes.
+func FindCloseCommand(db CommandDB, cursor string) *Command {
+	return &Command{
+		ID:      CmdFindClose,
+		DB:      db,
+		StrArgs: []string{cursor},
+	}
+}
+
+// GetCommand returns a pointer to a command structure for tx.Get().
+func GetCommand(db CommandDB, table string, item Item, field string) *Command {
+	return &Command{
+		ID:      CmdGet,
+		DB:      db,
+		StrArgs: []string{table, field},
+		ItemArg: item,
+	}
+}
+
+// GetFieldsCommand returns a pointer to a command structure for tx.GetFields().
+func GetFieldsCommand(db CommandDB, table string) *Command {
+	return &Command{
+		ID:      CmdGetFields,
+		DB:      db,
+		StrArgs: []string{table},
+	}
+}
+
+// GetTablesCommand returns a pointer to a command structure for tx.GetTables().
+func GetTablesCommand(db CommandDB) *Command {
+	return &Command{
+		ID: CmdGetTables,
+		DB: db,
+	}
+}
+
+// IsEmptyListFieldCommand returns a pointer to a command structure for tx.IsEmptyListField().
 func IsEmptyListFieldCommand(db CommandDB, table string, item Item, field string) *Command {
 	return &Command{
 		ID:      CmdIsEmptyListField,
@@ -757,6 +2151,28 @@ func NewItemCommand(db CommandDB, tx TxID, table string) *Command {
 	}
 }
 
+// UseItemCommand returns a pointer to a command structure for mdb.UseItem().
+func UseItemCommand(db CommandDB, tx TxID, table string, id uint64) *Command {
+	return &Command{
+		ID:      CmdUseItem,
+		DB:      db,
+		Tx:      tx,
+		StrArgs: []string{table},
+		IntArg:  int64(id),
+	}
+}
+
+// CloneItemCommand returns a pointer to a command structure for tx.CloneItem().
+func CloneItemCommand(db CommandDB, tx TxID, table string, item Item) *Command {
+	return &Command{
+		ID:      CmdCloneItem,
+		DB:      db,
+		Tx:      tx,
+		StrArgs: []string{table},
+		ItemArg: item,
+	}
+}
+
 // ParseFieldValuesCommand returns a pointer to a command structure for tx.ParseFieldValues().
 func ParseFieldValuesCommand(db CommandDB, table string, field string, data []string) *Command {
 	cmd := Command{
@@ -804,7 +2220,8 @@ func ToSqlCommand(db CommandDB, table string, query *Query, limit int64) *Comman
 	}
 }
 
-// GetIntCommand returns a pointer to a command structure for tx.GetInt().
+// GetIntCommand returns a pointer to a command structure for tx.GetInt(). The result
+// contains true in Bool if the key exists, like db.LookupInt().
 func GetIntCommand(db CommandDB, key int64) *Command {
 	return &Command{
 		ID:     CmdGetInt,
@@ -813,7 +2230,8 @@ func GetIntCommand(db CommandDB, key int64) *Command {
 	}
 }
 
-// GetStrCommand returns a pointer to a command structure for tx.GetStr().
+// GetStrCommand returns a pointer to a command structure for tx.GetStr(). The result
+// contains true in Bool if the key exists, like db.LookupStr().
 func GetStrCommand(db CommandDB, key int64) *Command {
 	return &Command{
 		ID:     CmdGetStr,
@@ -822,7 +2240,8 @@ func GetStrCommand(db CommandDB, key int64) *Command {
 	}
 }
 
-// GetBlobCommand returns a pointer to a command structure for tx.GetBlob().
+// GetBlobCommand returns a pointer to a command structure for tx.GetBlob(). The result
+// contains true in Bool if the key exists, like db.LookupBlob().
 func GetBlobCommand(db CommandDB, key int64) *Command {
 	return &Command{
 		ID:     CmdGetBlob,
@@ -831,7 +2250,8 @@ func GetBlobCommand(db CommandDB, key int64) *Command {
 	}
 }
 
-// GetDateCommand returns a pointer to a command structure for tx.GetDate().
+// GetDateCommand returns a pointer to a command structure for tx.GetDate(). The result
+// contains true in Bool if the key exists, like db.LookupDate().
 func GetDateCommand(db CommandDB, key int64) *Command {
 	return &Command{
 		ID:     CmdGetDate,
@@ -1005,6 +2425,398 @@ func ListDateCommand(db CommandDB) *Command {
 	}
 }
 
+// PurgeExpiredCommand returns a pointer to a command structure for db.PurgeExpired(). The result
+// contains the number of values removed in Int.
+func PurgeExpiredCommand(db CommandDB) *Command {
+	return &Command{
+		ID: CmdPurgeExpired,
+		DB: db,
+	}
+}
+
+// SetManyCommand returns a pointer to a command structure for tx.SetMany().
+func SetManyCommand(db CommandDB, tx TxID, t FieldType, values map[int64]Value) *Command {
+	keys := make([]Item, 0, len(values))
+	for key := range values {
+		keys = append(keys, Item(key))
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i] < keys[j] })
+	args := make([]Value, len(keys))
+	for i, key := range keys {
+		args[i] = values[int64(key)]
+	}
+	return &Command{
+		ID:        CmdSetMany,
+		DB:        db,
+		Tx:        tx,
+		IntArg:    int64(t),
+		ItemArgs:  keys,
+		ValueArgs: args,
+	}
+}
+
+// GetManyCommand returns a pointer to a command structure for db.GetMany(). The values of the
+// keys that exist are returned in ItemValues of the result, each as a list of one value.
+func GetManyCommand(db CommandDB, t FieldType, keys []int64) *Command {
+	items := make([]Item, len(keys))
+	for i, key := range keys {
+		items[i] = Item(key)
+	}
+	return &Command{
+		ID:       CmdGetMany,
+		DB:       db,
+		IntArg:   int64(t),
+		ItemArgs: items,
+	}
+}
+
+// HelloCommand returns a pointer to a command structure that asks the executor for its version,
+// capabilities, and the commands it executes, see ParseHello. Clients should send it after
+// connecting and only use the commands of the reply, since the executor may be of an older
+// or newer version than the client.
+func HelloCommand() *Command {
+	return &Command{
+		ID:      CmdHello,
+		Version: CommandVersion,
+	}
+}
+
+// ListTxCommand returns a pointer to a command structure that lists the open transactions of the
+// database, see ParseListTx. Transactions expire if they are not used, see Executor.SetTxTimeout.
+func ListTxCommand(db CommandDB) *Command {
+	return &Command{
+		ID:      CmdListTx,
+		DB:      db,
+		Version: CommandVersion,
+	}
+}
+
+// NewUserCommand returns a pointer to a command structure that creates a user of the multiuser
+// database of the executor, see Executor.SetMultiDB. The password should have been prepared
+// by the client like for MultiDB.NewUser. The ID of the user is returned in Int of the result.
+func NewUserCommand(username, email, password string) *Command {
+	return &Command{
+		ID:      CmdNewUser,
+		StrArgs: []string{username, email, password},
+	}
+}
+
+// AuthenticateCommand returns a pointer to a command structure that authenticates a user of the
+// multiuser database of the executor and starts a session that is valid for the ttl, or for
+// DefaultSessionTTL if it is 0. The session token is returned in Str and the ID of the user in
+// Int of the result.
+func AuthenticateCommand(username, password string, ttl time.Duration) *Command {
+	return &Command{
+		ID:      CmdAuthenticate,
+		StrArgs: []string{username, password},
+		IntArg:  int64(ttl),
+	}
+}
+
+// LogoutCommand returns a pointer to a command structure that ends the session with the token.
+func LogoutCommand(token string) *Command {
+	return &Command{
+		ID:      CmdLogout,
+		StrArgs: []string{token},
+	}
+}
+
+// UserDBCommand returns a pointer to a command structure that returns the name of the database
+// of the user of the session in Str of the result. The name can be passed to other commands like
+// the name of an open database for as long as the session is valid and needs not be opened or
+// closed.
+func UserDBCommand(token string) *Command {
+	return &Command{
+		ID:      CmdUserDB,
+		StrArgs: []string{token},
+	}
+}
+
+// DeleteUserCommand returns a pointer to a command structure that deletes the user of the
+// session together with all data of the user.
+func DeleteUserCommand(token string) *Command {
+	return &Command{
+		ID:      CmdDeleteUser,
+		StrArgs: []string{token},
+	}
+}
+
+// ArchiveUserCommand returns a pointer to a command structure for MultiDB.ArchiveUser, which
+// needs admin rights, see Executor.SetAdmin.
+func ArchiveUserCommand(username, archivedir string) *Command {
+	return &Command{
+		ID:      CmdArchiveUser,
+		StrArgs: []string{username, archivedir},
+	}
+}
+
+// ChangePasswordCommand returns a pointer to a command structure that changes the password of
+// the user of the session.
+func ChangePasswordCommand(token, oldPassword, newPassword string) *Command {
+	return &Command{
+		ID:      CmdChangePassword,
+		StrArgs: []string{token, oldPassword, newPassword},
+	}
+}
+
+// DumpKVCommand returns a pointer to a command structure for db.DumpKV(). The entries are
+// returned in KVEntries of the result.
+func DumpKVCommand(db CommandDB) *Command {
+	return &Command{
+		ID: CmdDumpKV,
+		DB: db,
+	}
+}
+
+// GetFloatCommand returns a pointer to a command structure for db.GetFloat(). The result contains
+// the value in Str in the shortest form that is parsed to the same float, and true in Bool if
+// the key exists.
+func GetFloatCommand(db CommandDB, key int64) *Command {
+	return &Command{
+		ID:     CmdGetFloat,
+		DB:     db,
+		IntArg: key,
+	}
+}
+
+// SetFloatCommand returns a pointer to a command structure for tx.SetFloat().
+func SetFloatCommand(db CommandDB, tx TxID, key int64, value float64) *Command {
+	return &Command{
+		ID:      CmdSetFloat,
+		DB:      db,
+		Tx:      tx,
+		IntArg:  key,
+		StrArgs: []string{formatFloat(value)},
+	}
+}
+
+// HasFloatCommand returns a pointer to a command structure for db.HasFloat().
+func HasFloatCommand(db CommandDB, key int64) *Command {
+	return &Command{
+		ID:     CmdHasFloat,
+		DB:     db,
+		IntArg: key,
+	}
+}
+
+// DeleteFloatCommand returns a pointer to a command structure for tx.DeleteFloat().
+func DeleteFloatCommand(db CommandDB, tx TxID, key int64) *Command {
+	return &Command{
+		ID:     CmdDeleteFloat,
+		DB:     db,
+		Tx:     tx,
+		IntArg: key,
+	}
+}
+
+// ListFloatCommand returns a pointer to a command structure for db.ListFloat().
+func ListFloatCommand(db CommandDB) *Command {
+	return &Command{
+		ID: CmdListFloat,
+		DB: db,
+	}
+}
+
+// GetIntKeyCommand returns a pointer to a command structure for db.GetIntKey(). The result
+// contains true in Bool if the key exists, like db.LookupIntKey().
+func GetIntKeyCommand(db CommandDB, key string) *Command {
+	return &Command{
+		ID:      CmdGetIntKey,
+		DB:      db,
+		StrArgs: []string{key},
+	}
+}
+
+// GetStrKeyCommand returns a pointer to a command structure for db.GetStrKey(). The result
+// contains true in Bool if the key exists, like db.LookupStrKey().
+func GetStrKeyCommand(db CommandDB, key string) *Command {
+	return &Command{
+		ID:      CmdGetStrKey,
+		DB:      db,
+		StrArgs: []string{key},
+	}
+}
+
+// GetBlobKeyCommand returns a pointer to a command structure for db.GetBlobKey(). The result
+// contains true in Bool if the key exists, like db.LookupBlobKey().
+func GetBlobKeyCommand(db CommandDB, key string) *Command {
+	return &Command{
+		ID:      CmdGetBlobKey,
+		DB:      db,
+		StrArgs: []string{key},
+	}
+}
+
+// GetDateKeyCommand returns a pointer to a command structure for db.GetDateKey(). The result
+// contains true in Bool if the key exists, like db.LookupDateKey().
+func GetDateKeyCommand(db CommandDB, key string) *Command {
+	return &Command{
+		ID:      CmdGetDateKey,
+		DB:      db,
+		StrArgs: []string{key},
+	}
+}
+
+// SetIntKeyCommand returns a pointer to a command structure for tx.SetIntKey().
+func SetIntKeyCommand(db CommandDB, tx TxID, key string, value int64) *Command {
+	return &Command{
+		ID:      CmdSetIntKey,
+		DB:      db,
+		Tx:      tx,
+		IntArg:  value,
+		StrArgs: []string{key},
+	}
+}
+
+// SetStrKeyCommand returns a pointer to a command structure for tx.SetStrKey().
+func SetStrKeyCommand(db CommandDB, tx TxID, key string, value string) *Command {
+	return &Command{
+		ID:      CmdSetStrKey,
+		DB:      db,
+		Tx:      tx,
+		StrArgs: []string{key, value},
+	}
+}
+
+// SetBlobKeyCommand returns a pointer to a command structure for tx.SetBlobKey().
+func SetBlobKeyCommand(db CommandDB, tx TxID, key string, value []byte) *Command {
+	return &Command{
+		ID:      CmdSetBlobKey,
+		DB:      db,
+		Tx:      tx,
+		StrArgs: []string{key, string(value)},
+	}
+}
+
+// SetDateKeyCommand returns a pointer to a command structure for tx.SetDateKey().
+func SetDateKeyCommand(db CommandDB, tx TxID, key string, value time.Time) *Command {
+	d := NewDate(value)
+	return &Command{
+		ID:      CmdSetDateKey,
+		DB:      db,
+		Tx:      tx,
+		StrArgs: []string{key, d.String()},
+	}
+}
+
+// SetDateStrKeyCommand returns a pointer to a command structure for tx.SetDateStrKey().
+func SetDateStrKeyCommand(db CommandDB, tx TxID, key string, value string) *Command {
+	return &Command{
+		ID:      CmdSetDateStrKey,
+		DB:      db,
+		Tx:      tx,
+		StrArgs: []string{key, value},
+	}
+}
+
+// HasIntKeyCommand returns a pointer to a command structure for db.HasIntKey().
+func HasIntKeyCommand(db CommandDB, key string) *Command {
+	return &Command{
+		ID:      CmdHasIntKey,
+		DB:      db,
+		StrArgs: []string{key},
+	}
+}
+
+// HasStrKeyCommand returns a pointer to a command structure for db.HasStrKey().
+func HasStrKeyCommand(db CommandDB, key string) *Command {
+	return &Command{
+		ID:      CmdHasStrKey,
+		DB:      db,
+		StrArgs: []string{key},
+	}
+}
+
+// HasBlobKeyCommand returns a pointer to a command structure for db.HasBlobKey().
+func HasBlobKeyCommand(db CommandDB, key string) *Command {
+	return &Command{
+		ID:      CmdHasBlobKey,
+		DB:      db,
+		StrArgs: []string{key},
+	}
+}
+
+// HasDateKeyCommand returns a pointer to a command structure for db.HasDateKey().
+func HasDateKeyCommand(db CommandDB, key string) *Command {
+	return &Command{
+		ID:      CmdHasDateKey,
+		DB:      db,
+		StrArgs: []string{key},
+	}
+}
+
+// DeleteIntKeyCommand returns a pointer to a command structure for tx.DeleteIntKey().
+func DeleteIntKeyCommand(db CommandDB, tx TxID, key string) *Command {
+	return &Command{
+		ID:      CmdDeleteIntKey,
+		DB:      db,
+		Tx:      tx,
+		StrArgs: []string{key},
+	}
+}
+
+// DeleteStrKeyCommand returns a pointer to a command structure for tx.DeleteStrKey().
+func DeleteStrKeyCommand(db CommandDB, tx TxID, key string) *Command {
+	return &Command{
+		ID:      CmdDeleteStrKey,
+		DB:      db,
+		Tx:      tx,
+		StrArgs: []string{key},
+	}
+}
+
+// DeleteBlobKeyCommand returns a pointer to a command structure for tx.DeleteBlobKey().
+func DeleteBlobKeyCommand(db CommandDB, tx TxID, key string) *Command {
+	return &Command{
+		ID:      CmdDeleteBlobKey,
+		DB:      db,
+		Tx:      tx,
+		StrArgs: []string{key},
+	}
+}
+
+// DeleteDateKeyCommand returns a pointer to a command structure for tx.DeleteDateKey().
+func DeleteDateKeyCommand(db CommandDB, tx TxID, key string) *Command {
+	return &Command{
+		ID:      CmdDeleteDateKey,
+		DB:      db,
+		Tx:      tx,
+		StrArgs: []string{key},
+	}
+}
+
+// ListIntKeysCommand returns a pointer to a command structure for db.ListIntKeys().
+func ListIntKeysCommand(db CommandDB) *Command {
+	return &Command{
+		ID: CmdListIntKeys,
+		DB: db,
+	}
+}
+
+// ListStrKeysCommand returns a pointer to a command structure for db.ListStrKeys().
+func ListStrKeysCommand(db CommandDB) *Command {
+	return &Command{
+		ID: CmdListStrKeys,
+		DB: db,
+	}
+}
+
+// ListBlobKeysCommand returns a pointer to a command structure for db.ListBlobKeys().
+func ListBlobKeysCommand(db CommandDB) *Command {
+	return &Command{
+		ID: CmdListBlobKeys,
+		DB: db,
+	}
+}
+
+// ListDateKeysCommand returns a pointer to a command structure for db.ListDateKeys().
+func ListDateKeysCommand(db CommandDB) *Command {
+	return &Command{
+		ID: CmdListDateKeys,
+		DB: db,
+	}
+}
+
 // BackupCommand returns a pointer to a command structure for tx.Backup().
 func BackupCommand(db CommandDB, destination string) *Command {
 	return &Command{
@@ -1014,6 +2826,32 @@ func BackupCommand(db CommandDB, destination string) *Command {
 	}
 }
 
+// NormalizeListsCommand returns a pointer to a command structure for db.NormalizeLists().
+func NormalizeListsCommand(db CommandDB) *Command {
+	return &Command{
+		ID: CmdNormalizeLists,
+		DB: db,
+	}
+}
+
+// CompactCommand returns a pointer to a command structure for db.Compact(). The result
+// contains the number of bytes reclaimed in Int.
+func CompactCommand(db CommandDB) *Command {
+	return &Command{
+		ID: CmdCompact,
+		DB: db,
+	}
+}
+
+// DumpCommand returns a pointer to a command structure for db.Dump().
+// The SQL statements are returned in the Str field of the result.
+func DumpCommand(db CommandDB) *Command {
+	return &Command{
+		ID: CmdDump,
+		DB: db,
+	}
+}
+
 // RemoveItemCommand returns a pointer to a command structure for tx.RemoveItem().
 func RemoveItemCommand(db CommandDB, tx TxID, table string, item Item) *Command {
 	return &Command{
@@ -1025,6 +2863,17 @@ func RemoveItemCommand(db CommandDB, tx TxID, table string, item Item) *Command
 	}
 }
 
+// RemoveItemsCommand returns a pointer to a command structure for tx.RemoveItems().
+func RemoveItemsCommand(db CommandDB, tx TxID, table string, items []Item) *Command {
+	return &Command{
+		ID:       CmdRemoveItems,
+		DB:       db,
+		Tx:       tx,
+		StrArgs:  []string{table},
+		ItemArgs: items,
+	}
+}
+
 // IndexCommand returns a pointer to a command structure for tx.Index().
 func IndexCommand(db CommandDB, tx TxID, table string, field string) *Command {
 	return &Command{
@@ -1034,3 +2883,369 @@ func IndexCommand(db CommandDB, tx TxID, table string, field string) *Command {
 		StrArgs: []string{table, field},
 	}
 }
+
+// RenameTableCommand returns a pointer to a command structure for tx.RenameTable().
+func RenameTableCommand(db CommandDB, tx TxID, oldName string, newName string) *Command {
+	return &Command{
+		ID:      CmdRenameTable,
+		DB:      db,
+		Tx:      tx,
+		StrArgs: []string{oldName, newName},
+	}
+}
+
+// DropTableCommand returns a pointer to a command structure for tx.DropTable().
+func DropTableCommand(db CommandDB, tx TxID, table string) *Command {
+	return &Command{
+		ID:      CmdDropTable,
+		DB:      db,
+		Tx:      tx,
+		StrArgs: []string{table},
+	}
+}
+
+// ChangeFieldTypeCommand returns a pointer to a command structure for tx.ChangeFieldType().
+func ChangeFieldTypeCommand(db CommandDB, tx TxID, table string, field string, newType FieldType) *Command {
+	return &Command{
+		ID:      CmdChangeFieldType,
+		DB:      db,
+		Tx:      tx,
+		StrArgs: []string{table, field},
+		IntArg:  int64(newType),
+	}
+}
+
+// LinkCommand returns a pointer to a command structure for tx.Link().
+func LinkCommand(db CommandDB, tx TxID, tableA string, itemA Item, tableB string, itemB Item, relation string) *Command {
+	return &Command{
+		ID:       CmdLink,
+		DB:       db,
+		Tx:       tx,
+		StrArgs:  []string{tableA, tableB, relation},
+		ItemArgs: []Item{itemA, itemB},
+	}
+}
+
+// UnlinkCommand returns a pointer to a command structure for tx.Unlink().
+func UnlinkCommand(db CommandDB, tx TxID, tableA string, itemA Item, tableB string, itemB Item, relation string) *Command {
+	return &Command{
+		ID:       CmdUnlink,
+		DB:       db,
+		Tx:       tx,
+		StrArgs:  []string{tableA, tableB, relation},
+		ItemArgs: []Item{itemA, itemB},
+	}
+}
+
+// LinkedCommand returns a pointer to a command structure for mdb.Linked().
+func LinkedCommand(db CommandDB, table string, item Item, relation string) *Command {
+	return &Command{
+		ID:      CmdLinked,
+		DB:      db,
+		StrArgs: []string{table, relation},
+		ItemArg: item,
+	}
+}
+
+// ReindexCommand returns a pointer to a command structure for tx.Reindex().
+func ReindexCommand(db CommandDB, tx TxID, table string) *Command {
+	return &Command{
+		ID:      CmdReindex,
+		DB:      db,
+		Tx:      tx,
+		StrArgs: []string{table},
+	}
+}
+
+// GetOrEmptyCommand returns a pointer to a command structure for mdb.GetOrEmpty().
+func GetOrEmptyCommand(db CommandDB, table string, item Item, field string) *Command {
+	return &Command{
+		ID:      CmdGetOrEmpty,
+		DB:      db,
+		StrArgs: []string{table, field},
+		ItemArg: item,
+	}
+}
+
+// GetItemCommand returns a pointer to a command structure for mdb.GetItem().
+func GetItemCommand(db CommandDB, table string, item Item) *Command {
+	return &Command{
+		ID:      CmdGetItem,
+		DB:      db,
+		StrArgs: []string{table},
+		ItemArg: item,
+	}
+}
+
+// GetMultiCommand returns a pointer to a command structure for mdb.GetMulti().
+func GetMultiCommand(db CommandDB, table string, items []Item, field string) *Command {
+	return &Command{
+		ID:       CmdGetMulti,
+		DB:       db,
+		StrArgs:  []string{table, field},
+		ItemArgs: items,
+	}
+}
+
+// LintSchemaCommand returns a pointer to a command structure for mdb.LintSchema().
+// The warnings are returned in human-readable form in the Strings field of the result.
+func LintSchemaCommand(db CommandDB) *Command {
+	return &Command{
+		ID: CmdLintSchema,
+		DB: db,
+	}
+}
+
+// ExportKVCommand returns a pointer to a command structure for mdb.ExportKV().
+// The JSON document is returned in the Str field of the result.
+func ExportKVCommand(db CommandDB) *Command {
+	return &Command{
+		ID: CmdExportKV,
+		DB: db,
+	}
+}
+
+// ImportKVCommand returns a pointer to a command structure for tx.ImportKV().
+// The JSON document is passed as a string.
+func ImportKVCommand(db CommandDB, tx TxID, data string) *Command {
+	return &Command{
+		ID:      CmdImportKV,
+		DB:      db,
+		Tx:      tx,
+		StrArgs: []string{data},
+	}
+}
+
+// VersionCommand returns a pointer to a command structure for db.Version().
+func VersionCommand(db CommandDB, table string, item Item) *Command {
+	return &Command{
+		ID:      CmdVersion,
+		DB:      db,
+		StrArgs: []string{table},
+		ItemArg: item,
+	}
+}
+
+// SetIfVersionCommand returns a pointer to a command structure for tx.SetIfVersion(). The command
+// fails with ErrVersionConflict if the item no longer has the expected version.
+func SetIfVersionCommand(db CommandDB, tx TxID, table string, item Item, field string, data []Value, expected int64) *Command {
+	return &Command{
+		ID:        CmdSetIfVersion,
+		DB:        db,
+		Tx:        tx,
+		StrArgs:   []string{table, field},
+		ItemArg:   item,
+		ValueArgs: data,
+		IntArg:    expected,
+	}
+}
+
+// SetFieldAccessCommand returns a pointer to a command structure for tx.SetFieldAccess(). The
+// command fails with ErrNotPermitted unless the executor has admin rights, see Executor.SetAdmin.
+func SetFieldAccessCommand(db CommandDB, tx TxID, table string, field string, access FieldAccess) *Command {
+	return &Command{
+		ID:      CmdSetFieldAccess,
+		DB:      db,
+		Tx:      tx,
+		StrArgs: []string{table, field},
+		IntArg:  int64(access),
+	}
+}
+
+// SetItemCommand returns a pointer to a command structure for tx.SetItem().
+func SetItemCommand(db CommandDB, tx TxID, table string, item Item, values map[string][]Value) *Command {
+	return &Command{
+		ID:       CmdSetItem,
+		DB:       db,
+		Tx:       tx,
+		StrArgs:  []string{table},
+		ItemArg:  item,
+		ValueMap: values,
+	}
+}
+
+// EnableTimestampsCommand returns a pointer to a command structure for tx.EnableTimestamps().
+func EnableTimestampsCommand(db CommandDB, tx TxID, table string) *Command {
+	return &Command{
+		ID:      CmdEnableTimestamps,
+		DB:      db,
+		Tx:      tx,
+		StrArgs: []string{table},
+	}
+}
+
+// EnableHistoryCommand returns a pointer to a command structure for tx.EnableHistory(). The
+// retention is sent in milliseconds.
+func EnableHistoryCommand(db CommandDB, tx TxID, table string, retention time.Duration) *Command {
+	return &Command{
+		ID:      CmdEnableHistory,
+		DB:      db,
+		Tx:      tx,
+		StrArgs: []string{table},
+		IntArg:  retention.Milliseconds(),
+	}
+}
+
+// DisableHistoryCommand returns a pointer to a command structure for tx.DisableHistory().
+func DisableHistoryCommand(db CommandDB, tx TxID, table string) *Command {
+	return &Command{
+		ID:      CmdDisableHistory,
+		DB:      db,
+		Tx:      tx,
+		StrArgs: []string{table},
+	}
+}
+
+// CountQueryCommand returns a pointer to a command structure for db.CountQuery().
+func CountQueryCommand(db CommandDB, query *Query) *Command {
+	return &Command{
+		ID:       CmdCountQuery,
+		DB:       db,
+		QueryArg: *query,
+	}
+}
+
+// TableSizeCommand returns a pointer to a command structure for db.TableSize().
+func TableSizeCommand(db CommandDB, table string) *Command {
+	return &Command{
+		ID:      CmdTableSize,
+		DB:      db,
+		StrArgs: []string{table},
+	}
+}
+
+// DatabaseSizeCommand returns a pointer to a command structure for db.DatabaseSize(). The sizes
+// are returned in Ints, see ParseDatabaseSize.
+func DatabaseSizeCommand(db CommandDB) *Command {
+	return &Command{
+		ID: CmdDatabaseSize,
+		DB: db,
+	}
+}
+
+// ParseDatabaseSize returns the sizes in the result of a DatabaseSize command.
+func ParseDatabaseSize(r *Result) DBSize {
+	var size DBSize
+	if len(r.Ints) == 4 {
+		size = DBSize{FileSize: r.Ints[0], PageSize: r.Ints[1], PageCount: r.Ints[2], FreelistCount: r.Ints[3]}
+	}
+	return size
+}
+
+// HasTimestampsCommand returns a pointer to a command structure for db.HasTimestamps().
+func HasTimestampsCommand(db CommandDB, table string) *Command {
+	return &Command{
+		ID:      CmdHasTimestamps,
+		DB:      db,
+		StrArgs: []string{table},
+	}
+}
+
+// ListIntRangeCommand returns a pointer to a command structure for db.ListIntRange().
+func ListIntRangeCommand(db CommandDB, from, to int64) *Command {
+	return &Command{
+		ID:      CmdListIntRange,
+		DB:      db,
+		IntArg:  from,
+		IntArg2: to,
+	}
+}
+
+// ListStrRangeCommand returns a pointer to a command structure for db.ListStrRange().
+func ListStrRangeCommand(db CommandDB, from, to int64) *Command {
+	return &Command{
+		ID:      CmdListStrRange,
+		DB:      db,
+		IntArg:  from,
+		IntArg2: to,
+	}
+}
+
+// ListBlobRangeCommand returns a pointer to a command structure for db.ListBlobRange().
+func ListBlobRangeCommand(db CommandDB, from, to int64) *Command {
+	return &Command{
+		ID:      CmdListBlobRange,
+		DB:      db,
+		IntArg:  from,
+		IntArg2: to,
+	}
+}
+
+// ListDateRangeCommand returns a pointer to a command structure for db.ListDateRange().
+func ListDateRangeCommand(db CommandDB, from, to int64) *Command {
+	return &Command{
+		ID:      CmdListDateRange,
+		DB:      db,
+		IntArg:  from,
+		IntArg2: to,
+	}
+}
+
+// ListFloatRangeCommand returns a pointer to a command structure for db.ListFloatRange().
+func ListFloatRangeCommand(db CommandDB, from, to int64) *Command {
+	return &Command{
+		ID:      CmdListFloatRange,
+		DB:      db,
+		IntArg:  from,
+		IntArg2: to,
+	}
+}
+
+// FindStrValueCommand returns a pointer to a command structure for db.FindStrValue().
+func FindStrValueCommand(db CommandDB, pattern string) *Command {
+	return &Command{
+		ID:      CmdFindStrValue,
+		DB:      db,
+		StrArgs: []string{pattern},
+	}
+}
+
+// FindFloatValuesCommand returns a pointer to a command structure for db.FindFloatValues().
+func FindFloatValuesCommand(db CommandDB, min, max float64) *Command {
+	return &Command{
+		ID:      CmdFindFloatValues,
+		DB:      db,
+		StrArgs: []string{formatFloat(min), formatFloat(max)},
+	}
+}
+
+// HistoryOfCommand returns a pointer to a command structure for db.HistoryOf(). The changes are
+// returned in Ints and ItemValues, see ParseHistory.
+func HistoryOfCommand(db CommandDB, table string, item Item, field string) *Command {
+	return &Command{
+		ID:      CmdHistoryOf,
+		DB:      db,
+		StrArgs: []string{table, field},
+		ItemArg: item,
+	}
+}
+
+// historyToResult stores the changes in the result of a HistoryOf command. Ints holds the time of
+// each change in nanoseconds since the Unix epoch, and ItemValues its values under its position.
+func historyToResult(changes []Change, r *Result) {
+	r.Ints = make([]int64, len(changes))
+	r.ItemValues = make(map[Item][]Value, len(changes))
+	for i, change := range changes {
+		r.Ints[i] = change.Time.UnixNano()
+		r.ItemValues[Item(i)] = change.Values
+	}
+}
+
+// ParseHistory returns the changes in the result of a HistoryOf command, oldest first.
+func ParseHistory(r *Result) []Change {
+	changes := make([]Change, len(r.Ints))
+	for i, t := range r.Ints {
+		changes[i] = Change{Time: time.Unix(0, t), Values: r.ItemValues[Item(i)]}
+	}
+	return changes
+}
+
+// ExportJSONCommand returns a pointer to a command structure for db.ExportJSON(). The document
+// is returned in Str. Exports of large databases should be submitted as jobs, see SubmitCommand.
+func ExportJSONCommand(db CommandDB, tables ...string) *Command {
+	return &Command{
+		ID:      CmdExportJSON,
+		DB:      db,
+		StrArgs: tables,
+	}
+}
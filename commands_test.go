@@ -0,0 +1,1296 @@
+package minidb
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestExecutors(t *testing.T) {
+	tmp, _ := ioutil.TempFile("", "minidb-exec-testing-*")
+	defer os.Remove(tmp.Name())
+	db := CommandDB(tmp.Name())
+	e1 := NewExecutor()
+	e2 := NewExecutor()
+	defer e1.CloseAllDBs()
+	defer e2.CloseAllDBs()
+	if r := e1.Exec(OpenCommand("sqlite3", tmp.Name())); r.HasError {
+		t.Errorf("Open command failed: %s", r.Str)
+	}
+	if r := e1.Exec(AddTableCommand(db, "Person", []Field{Field{Name: "Name", Sort: DBString}})); r.HasError {
+		t.Errorf("AddTable command failed: %s", r.Str)
+	}
+	if r := e2.Exec(PingCommand()); r.HasError {
+		t.Errorf("Ping command failed: %s", r.Str)
+	}
+	if r := e2.Exec(GetTablesCommand(db)); !r.HasError || r.Int != ErrUnknownDB {
+		t.Errorf("GetTables command should fail in an executor that has not opened the database")
+	}
+	r := e1.Exec(BeginCommand(db))
+	if r.HasError {
+		t.Errorf("Begin command failed: %s", r.Str)
+	}
+	tx := TxID(r.Int)
+	r = e1.Exec(BeginNestedCommand(db, tx))
+	if r.HasError || TxID(r.Int) == tx {
+		t.Errorf("nested Begin command failed: %s", r.Str)
+	}
+	if r := e1.Exec(CommitCommand(db, TxID(r.Int))); r.HasError {
+		t.Errorf("Commit command of a nested transaction failed: %s", r.Str)
+	}
+	if r := e2.Exec(CommitCommand(db, tx)); !r.HasError {
+		t.Errorf("Commit command should fail in an executor that has not begun the transaction")
+	}
+	if r := e1.Exec(CommitCommand(db, tx)); r.HasError {
+		t.Errorf("Commit command failed: %s", r.Str)
+	}
+	r = e1.Exec(BeginReadCommand(db))
+	if r.HasError {
+		t.Errorf("BeginRead command failed: %s", r.Str)
+	}
+	if r := e1.Exec(GetTablesCommand(db).InTx(TxID(r.Int))); len(r.Strings) != 1 {
+		t.Errorf("GetTables command in a read-only transaction returned %v", r.Strings)
+	}
+	if r := e1.Exec(RollbackCommand(db, TxID(r.Int))); r.HasError {
+		t.Errorf("Rollback command of a read-only transaction failed: %s", r.Str)
+	}
+	if r := e1.Exec(CloseCommand(db)); r.HasError {
+		t.Errorf("Close command failed: %s", r.Str)
+	}
+	if r := e1.Exec(GetTablesCommand(db)); !r.HasError {
+		t.Errorf("GetTables command should fail after closing the database")
+	}
+}
+
+func TestExecReadYourWrites(t *testing.T) {
+	tmp, _ := ioutil.TempFile("", "minidb-exec-testing-*")
+	defer os.Remove(tmp.Name())
+	db := CommandDB(tmp.Name())
+	e := NewExecutor()
+	defer e.CloseAllDBs()
+	if r := e.Exec(OpenCommand("sqlite3", tmp.Name())); r.HasError {
+		t.Errorf("Open command failed: %s", r.Str)
+	}
+	if r := e.Exec(AddTableCommand(db, "Person", []Field{Field{Name: "Name", Sort: DBString}})); r.HasError {
+		t.Errorf("AddTable command failed: %s", r.Str)
+	}
+	r := e.Exec(NewItemCommand(db, 0, "Person"))
+	if r.HasError {
+		t.Errorf("NewItem command failed: %s", r.Str)
+	}
+	item := r.Items[0]
+	r = e.Exec(BeginCommand(db))
+	if r.HasError {
+		t.Errorf("Begin command failed: %s", r.Str)
+	}
+	tx := TxID(r.Int)
+	if r := e.Exec(SetCommand(db, tx, "Person", item, "Name", []Value{NewString("John")})); r.HasError {
+		t.Errorf("Set command failed: %s", r.Str)
+	}
+	if r := e.Exec(SetStrCommand(db, tx, 1, "hello")); r.HasError {
+		t.Errorf("SetStr command failed: %s", r.Str)
+	}
+	if r := e.Exec(GetCommand(db, "Person", item, "Name").InTx(tx)); r.HasError || len(r.Values) != 1 ||
+		r.Values[0].String() != "John" {
+		t.Errorf("Get command in transaction returned %v, %s", r.Values, r.Str)
+	}
+	if r := e.Exec(GetCommand(db, "Person", item, "Name")); r.HasError || len(r.Values) != 1 || !r.Values[0].IsNull() {
+		t.Errorf("Get command outside of the transaction should not see uncommitted writes, returned %v", r.Values)
+	}
+	query, _ := ParseQuery("Person Name=John")
+	if r := e.Exec(FindCommand(db, query, 0).InTx(tx)); r.HasError || len(r.Items) != 1 || r.Items[0] != item {
+		t.Errorf("Find command in transaction returned %v, %s", r.Items, r.Str)
+	}
+	if r := e.Exec(GetStrCommand(db, 1).InTx(tx)); r.Str != "hello" {
+		t.Errorf("GetStr command in transaction returned '%s', expected 'hello'", r.Str)
+	}
+	if r := e.Exec(RollbackCommand(db, tx)); r.HasError {
+		t.Errorf("Rollback command failed: %s", r.Str)
+	}
+	if r := e.Exec(GetCommand(db, "Person", item, "Name").InTx(tx)); !r.HasError || r.Int != ErrUnknownTx {
+		t.Errorf("Get command should fail for a finished transaction")
+	}
+	if r := e.Exec(GetStrCommand(db, 1)); r.Str != "" {
+		t.Errorf("GetStr command returned '%s' after rollback", r.Str)
+	}
+}
+
+func TestCommandRoundTrip(t *testing.T) {
+	tmp, _ := ioutil.TempFile("", "minidb-exec-testing-*")
+	defer os.Remove(tmp.Name())
+	backup, _ := ioutil.TempFile("", "minidb-exec-testing-*")
+	defer os.Remove(backup.Name())
+	db := CommandDB(tmp.Name())
+	e := NewExecutor()
+	defer e.CloseAllDBs()
+	// every command is sent through JSON like by a client
+	exec := func(name string, cmd *Command) *Result {
+		msg, err := json.Marshal(cmd)
+		if err != nil {
+			t.Errorf("%s command cannot be marshalled: %s", name, err)
+		}
+		var received Command
+		if err := json.Unmarshal(msg, &received); err != nil {
+			t.Errorf("%s command cannot be unmarshalled: %s", name, err)
+		}
+		r := e.Exec(&received)
+		if r.HasError {
+			t.Errorf("%s command failed: %s", name, r.Str)
+		}
+		return r
+	}
+	exec("Open", OpenCommand("sqlite3", tmp.Name()))
+	exec("Ping", PingCommand())
+	exec("AddTable", AddTableCommand(db, "Person", []Field{Field{Name: "Name", Sort: DBString},
+		Field{Name: "Age", Sort: DBInt}, Field{Name: "Tags", Sort: DBStringList}}))
+	exec("AddTable", AddTableCommand(db, "Asset", []Field{Field{Name: "Name", Sort: DBString}}))
+	mdb := e.lookupDB(db).db
+
+	john := exec("NewItem", NewItemCommand(db, 0, "Person")).Items[0]
+	if r := exec("UseItem", UseItemCommand(db, 0, "Person", 42)); len(r.Items) != 1 || r.Items[0] != 42 || !mdb.ItemExists("Person", 42) {
+		t.Errorf("UseItem command returned %v", r.Items)
+	}
+	car := exec("NewItem", NewItemCommand(db, 0, "Asset")).Items[0]
+	if r := exec("FieldIsNull", FieldIsNullCommand(db, "Person", john, "Name")); !r.Bool {
+		t.Errorf("FieldIsNull command returned false for a new item")
+	}
+	if r := exec("FieldIsEmpty", FieldIsEmptyCommand(db, "Person", john, "Tags")); !r.Bool {
+		t.Errorf("FieldIsEmpty command returned false for a new item")
+	}
+	if r := exec("IsEmptyListField", IsEmptyListFieldCommand(db, "Person", john, "Tags")); !r.Bool {
+		t.Errorf("IsEmptyListField command returned false for a new item")
+	}
+
+	tx := TxID(exec("Begin", BeginCommand(db)).Int)
+	exec("Set", SetCommand(db, tx, "Person", john, "Name", []Value{NewString("John")}))
+	exec("SetItem", SetItemCommand(db, tx, "Person", john, map[string][]Value{"Age": []Value{NewInt(30)},
+		"Tags": []Value{NewString("a"), NewString("b")}}))
+	exec("SetInt", SetIntCommand(db, tx, 1, 10))
+	exec("SetStr", SetStrCommand(db, tx, 1, "hello"))
+	exec("SetBlob", SetBlobCommand(db, tx, 1, []byte("data")))
+	exec("SetDate", SetDateCommand(db, tx, 1, time.Date(2019, 1, 2, 3, 4, 5, 0, time.UTC)))
+	exec("SetDateStr", SetDateStrCommand(db, tx, 2, "2020-01-02T03:04:05Z"))
+	exec("SetIntKey", SetIntKeyCommand(db, tx, "answer", 42))
+	exec("SetStrKey", SetStrKeyCommand(db, tx, "greeting", "hi"))
+	exec("SetBlobKey", SetBlobKeyCommand(db, tx, "data", []byte("bytes")))
+	exec("SetDateKey", SetDateKeyCommand(db, tx, "start", time.Date(2019, 1, 2, 3, 4, 5, 0, time.UTC)))
+	exec("SetDateStrKey", SetDateStrKeyCommand(db, tx, "end", "2020-01-02T03:04:05Z"))
+	exec("DeleteStrKey", DeleteStrKeyCommand(db, tx, "missing"))
+	exec("SetFloat", SetFloatCommand(db, tx, 1, 0.1))
+	exec("SetMany", SetManyCommand(db, tx, DBString, map[int64]Value{100: NewString("a"), 101: NewString("b")}))
+	exec("Link", LinkCommand(db, tx, "Person", john, "Asset", car, "owns"))
+	exec("Index", IndexCommand(db, tx, "Person", "Name"))
+	exec("Reindex", ReindexCommand(db, tx, "Person"))
+	exec("EnableTimestamps", EnableTimestampsCommand(db, tx, "Asset"))
+	exec("ImportKV", ImportKVCommand(db, tx, `{"str":{"3":"imported"}}`))
+	if r := exec("ListInt", ListIntCommand(db, tx)); len(r.Ints) != 1 || r.Ints[0] != 1 {
+		t.Errorf("ListInt command in transaction returned %v", r.Ints)
+	}
+	exec("Commit", CommitCommand(db, tx))
+
+	if r := exec("FieldIsNull", FieldIsNullCommand(db, "Person", john, "Name")); r.Bool != mdb.FieldIsNull("Person", john, "Name") || r.Bool {
+		t.Errorf("FieldIsNull command returned true for a field that has been set")
+	}
+	if r := exec("FieldIsEmpty", FieldIsEmptyCommand(db, "Person", john, "Tags")); r.Bool {
+		t.Errorf("FieldIsEmpty command returned true for a list with elements")
+	}
+	if r := exec("IsEmptyListField", IsEmptyListFieldCommand(db, "Person", john, "Tags")); r.Bool {
+		t.Errorf("IsEmptyListField command returned true for a list with elements")
+	}
+	if r := exec("Version", VersionCommand(db, "Person", john)); r.Int != 2 {
+		t.Errorf("Version command returned %d, expected 2", r.Int)
+	}
+	tx = TxID(exec("Begin", BeginCommand(db)).Int)
+	exec("SetIfVersion", SetIfVersionCommand(db, tx, "Person", john, "Age", []Value{NewInt(30)}, 2))
+	if r := e.Exec(SetIfVersionCommand(db, tx, "Person", john, "Age", []Value{NewInt(32)}, 2)); !r.HasError || r.Int != ErrVersionConflict {
+		t.Errorf("SetIfVersion command with an old version should fail with a conflict")
+	}
+	exec("Commit", CommitCommand(db, tx))
+	if r := exec("Count", CountCommand(db, "Person")); r.Int != 2 {
+		t.Errorf("Count command returned %d, expected 2", r.Int)
+	}
+	if r := exec("FieldExists", FieldExistsCommand(db, "Person", "Age")); !r.Bool {
+		t.Errorf("FieldExists command returned false")
+	}
+	query, _ := ParseQuery("Person Name=John")
+	if r := exec("Find", FindCommand(db, query, 0)); len(r.Items) != 1 || r.Items[0] != john {
+		t.Errorf("Find command returned %v", r.Items)
+	}
+	if r := exec("FindWithin", FindWithinCommand(db, query, []Item{42}, 0)); len(r.Items) != 0 {
+		t.Errorf("FindWithin command returned %v", r.Items)
+	}
+	exec("RegisterTemplate", RegisterTemplateCommand("byName", "Person Name=$name"))
+	if r := exec("RunTemplate", RunTemplateCommand(db, "byName", map[string]string{"name": "Jo%"}, 0)); len(r.Items) != 1 || r.Items[0] != john {
+		t.Errorf("RunTemplate command returned %v", r.Items)
+	}
+	if r := exec("FindStream", FindStreamCommand(db, query, 0, 1)); len(r.Items) != 1 || r.Items[0] != john || r.Str != "" {
+		t.Errorf("FindStream command returned %v, cursor '%s'", r.Items, r.Str)
+	}
+	expected, _ := mdb.ToSql("Person", query, 0)
+	if r := exec("ToSql", ToSqlCommand(db, "Person", query, 0)); len(r.Strings) != 1 || r.Strings[0] != expected {
+		t.Errorf("ToSql command returned %v", r.Strings)
+	}
+	if r := exec("Get", GetCommand(db, "Person", john, "Name")); len(r.Values) != 1 || r.Values[0].String() != "John" {
+		t.Errorf("Get command returned %v", r.Values)
+	}
+	if r := exec("GetOrEmpty", GetOrEmptyCommand(db, "Person", 42, "Name")); len(r.Values) != 0 {
+		t.Errorf("GetOrEmpty command returned %v", r.Values)
+	}
+	if r := exec("GetItem", GetItemCommand(db, "Person", john)); len(r.Item["Tags"]) != 2 || r.Item["Age"][0].Int() != 30 {
+		t.Errorf("GetItem command returned %v", r.Item)
+	}
+	if r := exec("GetMulti", GetMultiCommand(db, "Person", []Item{john, 42}, "Tags")); len(r.ItemValues[john]) != 2 || len(r.ItemValues[42]) != 0 {
+		t.Errorf("GetMulti command returned %v", r.ItemValues)
+	}
+	if r := exec("GetFields", GetFieldsCommand(db, "Person")); len(r.Fields) != 3 {
+		t.Errorf("GetFields command returned %v", r.Fields)
+	}
+	if r := exec("GetTables", GetTablesCommand(db)); len(r.Strings) != len(mdb.GetTables()) {
+		t.Errorf("GetTables command returned %v", r.Strings)
+	}
+	if r := exec("IsListField", IsListFieldCommand(db, "Person", "Tags")); !r.Bool {
+		t.Errorf("IsListField command returned false")
+	}
+	if r := exec("ItemExists", ItemExistsCommand(db, "Person", john)); !r.Bool {
+		t.Errorf("ItemExists command returned false")
+	}
+	if r := exec("ListItems", ListItemsCommand(db, "Person", 0)); len(r.Items) != 2 {
+		t.Errorf("ListItems command returned %v", r.Items)
+	}
+	if r := exec("MustGetFieldType", MustGetFieldTypeCommand(db, "Person", "Tags")); FieldType(r.Int) != DBStringList {
+		t.Errorf("MustGetFieldType command returned %d", r.Int)
+	}
+	if r := exec("ParseFieldValues", ParseFieldValuesCommand(db, "Person", "Tags", []string{"x", "y"})); len(r.Values) != 2 {
+		t.Errorf("ParseFieldValues command returned %v", r.Values)
+	}
+	if r := exec("TableExists", TableExistsCommand(db, "Asset")); !r.Bool {
+		t.Errorf("TableExists command returned false")
+	}
+	if r := exec("Linked", LinkedCommand(db, "Person", john, "owns")); len(r.Links) != 1 || r.Links[0].Item != car {
+		t.Errorf("Linked command returned %v", r.Links)
+	}
+	if r := exec("GetInt", GetIntCommand(db, 1)); r.Int != 10 {
+		t.Errorf("GetInt command returned %d", r.Int)
+	}
+	if r := exec("GetStr", GetStrCommand(db, 1)); r.Str != "hello" {
+		t.Errorf("GetStr command returned '%s'", r.Str)
+	}
+	if r := exec("GetBlob", GetBlobCommand(db, 1)); string(r.Bytes) != "data" {
+		t.Errorf("GetBlob command returned %v", r.Bytes)
+	}
+	if r := exec("GetDate", GetDateCommand(db, 1)); r.Str != "2019-01-02T03:04:05Z" {
+		t.Errorf("GetDate command returned '%s'", r.Str)
+	}
+	for name, cmd := range map[string]*Command{"HasInt": HasIntCommand(db, 1), "HasStr": HasStrCommand(db, 1),
+		"HasBlob": HasBlobCommand(db, 1), "HasDate": HasDateCommand(db, 2)} {
+		if r := exec(name, cmd); !r.Bool {
+			t.Errorf("%s command returned false", name)
+		}
+	}
+	for name, cmd := range map[string]*Command{"ListInt": ListIntCommand(db, 0), "ListStr": ListStrCommand(db),
+		"ListBlob": ListBlobCommand(db), "ListDate": ListDateCommand(db)} {
+		if r := exec(name, cmd); len(r.Ints) == 0 {
+			t.Errorf("%s command returned no keys", name)
+		}
+	}
+	if r := exec("GetInt", GetIntCommand(db, 99)); r.Int != 0 || r.Bool {
+		t.Errorf("GetInt command returned %d, %t for a missing key", r.Int, r.Bool)
+	}
+	if r := exec("GetMany", GetManyCommand(db, DBString, []int64{100, 101, 102})); len(r.ItemValues) != 2 || r.ItemValues[101][0].Str != "b" {
+		t.Errorf("GetMany command returned %v", r.ItemValues)
+	}
+	if r := exec("GetFloat", GetFloatCommand(db, 1)); r.Str != "0.1" || !r.Bool {
+		t.Errorf("GetFloat command returned '%s', %t", r.Str, r.Bool)
+	}
+	if r := exec("ListFloat", ListFloatCommand(db)); len(r.Ints) != 1 {
+		t.Errorf("ListFloat command returned %v", r.Ints)
+	}
+	if r := exec("GetIntKey", GetIntKeyCommand(db, "answer")); r.Int != 42 || !r.Bool {
+		t.Errorf("GetIntKey command returned %d", r.Int)
+	}
+	if r := exec("GetStrKey", GetStrKeyCommand(db, "greeting")); r.Str != "hi" {
+		t.Errorf("GetStrKey command returned '%s'", r.Str)
+	}
+	if r := exec("GetBlobKey", GetBlobKeyCommand(db, "data")); string(r.Bytes) != "bytes" {
+		t.Errorf("GetBlobKey command returned %v", r.Bytes)
+	}
+	if r := exec("GetDateKey", GetDateKeyCommand(db, "start")); r.Str != "2019-01-02T03:04:05Z" {
+		t.Errorf("GetDateKey command returned '%s'", r.Str)
+	}
+	for name, cmd := range map[string]*Command{"HasIntKey": HasIntKeyCommand(db, "answer"), "HasStrKey": HasStrKeyCommand(db, "greeting"),
+		"HasBlobKey": HasBlobKeyCommand(db, "data"), "HasDateKey": HasDateKeyCommand(db, "end")} {
+		if r := exec(name, cmd); !r.Bool {
+			t.Errorf("%s command returned false", name)
+		}
+	}
+	if r := exec("HasIntKey", HasIntKeyCommand(db, "greeting")); r.Bool {
+		t.Errorf("HasIntKey command returned true for a missing key")
+	}
+	if r := exec("ListDateKeys", ListDateKeysCommand(db)); len(r.Strings) != 2 || r.Strings[0] != "end" {
+		t.Errorf("ListDateKeys command returned %v", r.Strings)
+	}
+	for name, cmd := range map[string]*Command{"ListIntKeys": ListIntKeysCommand(db), "ListStrKeys": ListStrKeysCommand(db),
+		"ListBlobKeys": ListBlobKeysCommand(db)} {
+		if r := exec(name, cmd); len(r.Strings) != 1 {
+			t.Errorf("%s command returned %v", name, r.Strings)
+		}
+	}
+	if r := exec("DumpKV", DumpKVCommand(db)); len(r.KVEntries) == 0 || r.KVEntries[0].Store != "int" {
+		t.Errorf("DumpKV command returned %v", r.KVEntries)
+	}
+	if r := exec("PurgeExpired", PurgeExpiredCommand(db)); r.Int != 0 {
+		t.Errorf("PurgeExpired command returned %d, expected 0", r.Int)
+	}
+	if r := exec("ExportKV", ExportKVCommand(db)); !strings.Contains(r.Str, "imported") {
+		t.Errorf("ExportKV command returned %s", r.Str)
+	}
+	if r := exec("LintSchema", LintSchemaCommand(db)); r.Strings == nil {
+		t.Errorf("LintSchema command returned no result")
+	}
+
+	tx = TxID(exec("Begin", BeginCommand(db)).Int)
+	exec("Unlink", UnlinkCommand(db, tx, "Person", john, "Asset", car, "owns"))
+	for _, cmd := range []*Command{DeleteIntCommand(db, tx, 1), DeleteStrCommand(db, tx, 1),
+		DeleteBlobCommand(db, tx, 1), DeleteDateCommand(db, tx, 1)} {
+		exec("Delete", cmd)
+	}
+	if r := exec("ChangeFieldType", ChangeFieldTypeCommand(db, tx, "Person", "Age", DBString)); len(r.Items) != 0 {
+		t.Errorf("ChangeFieldType command returned %v", r.Items)
+	}
+	exec("RemoveItem", RemoveItemCommand(db, tx, "Person", 42))
+	exec("RenameTable", RenameTableCommand(db, tx, "Asset", "Thing"))
+	exec("Rollback", RollbackCommand(db, tx))
+	if !mdb.TableExists("Asset") || !mdb.HasInt(1) || !mdb.ItemExists("Person", 42) {
+		t.Errorf("Rollback command did not undo the changes")
+	}
+	tx = TxID(exec("Begin", BeginCommand(db)).Int)
+	exec("RemoveItems", RemoveItemsCommand(db, tx, "Person", []Item{42}))
+	exec("DropTable", DropTableCommand(db, tx, "Asset"))
+	clone := exec("CloneItem", CloneItemCommand(db, tx, "Person", john)).Items[0]
+	exec("Commit", CommitCommand(db, tx))
+	if values, _ := mdb.Get("Person", clone, "Tags"); len(values) != 2 {
+		t.Errorf("CloneItem command did not copy the list field: %v", values)
+	}
+	if mdb.TableExists("Asset") || mdb.ItemExists("Person", 42) {
+		t.Errorf("RemoveItem or DropTable command had no effect")
+	}
+
+	exec("Backup", BackupCommand(db, backup.Name()))
+	if r := exec("Get", GetCommand(db, "Person", john, "Name")); len(r.Values) != 1 || r.Values[0].String() != "John" {
+		t.Errorf("database cannot be used after Backup command: %v", r.Values)
+	}
+	exec("Close", CloseCommand(db))
+}
+
+func TestLegacyCommands(t *testing.T) {
+	tmp, _ := ioutil.TempFile("", "minidb-exec-testing-*")
+	defer os.Remove(tmp.Name())
+	db := CommandDB(tmp.Name())
+	e := NewExecutor()
+	defer e.CloseAllDBs()
+	e.Exec(OpenCommand("sqlite3", tmp.Name()))
+	e.Exec(AddTableCommand(db, "Person", []Field{Field{Name: "Name", Sort: DBString}}))
+	e.Exec(AddTableCommand(db, "Asset", []Field{Field{Name: "Name", Sort: DBString}, Field{Name: "Value", Sort: DBInt}}))
+	item := e.Exec(NewItemCommand(db, 0, "Asset")).Items[0]
+	// a FieldIsNull command of version 0 as sent by an old client
+	var legacy Command
+	json.Unmarshal([]byte(fmt.Sprintf(`{"id":%d,"dbid":%q,"strings":["Value"],"item":%d}`, CmdFieldIsNull, db, item)), &legacy)
+	if r := e.Exec(&legacy); r.HasError || !r.Bool {
+		t.Errorf("FieldIsNull command of version 0 returned %v, %s", r.Bool, r.Str)
+	}
+	legacy.ID = CmdFieldIsEmpty
+	if r := e.Exec(&legacy); r.HasError || !r.Bool {
+		t.Errorf("FieldIsEmpty command of version 0 returned %v, %s", r.Bool, r.Str)
+	}
+	e.Exec(NewItemCommand(db, 0, "Person"))
+	legacy.StrArgs = []string{"Name"}
+	if r := e.Exec(&legacy); !r.HasError || r.Int != ErrInvalidCommand {
+		t.Errorf("FieldIsEmpty command of version 0 should fail if the table is ambiguous")
+	}
+	if r := e.Exec(&Command{ID: CmdFieldIsNull, DB: db, ItemArg: item, Version: CommandVersion,
+		StrArgs: []string{"Value"}}); !r.HasError {
+		t.Errorf("FieldIsNull command should fail for missing arguments")
+	}
+}
+
+func TestTemplates(t *testing.T) {
+	tmp, _ := ioutil.TempFile("", "minidb-exec-testing-*")
+	defer os.Remove(tmp.Name())
+	db := CommandDB(tmp.Name())
+	e := NewExecutor()
+	defer e.CloseAllDBs()
+	e.Exec(OpenCommand("sqlite3", tmp.Name()))
+	e.Exec(AddTableCommand(db, "Person", []Field{Field{Name: "Name", Sort: DBString}, Field{Name: "Tags", Sort: DBStringList}}))
+	mdb := e.lookupDB(db).db
+	john, _ := mdb.NewItem("Person")
+	anna, _ := mdb.NewItem("Person")
+	tx, _ := mdb.Begin()
+	tx.Set("Person", john, "Name", []Value{NewString("John")})
+	tx.Set("Person", john, "Tags", []Value{NewString("admin")})
+	tx.Set("Person", anna, "Name", []Value{NewString("Anna")})
+	tx.Set("Person", anna, "Tags", []Value{NewString("user")})
+	tx.Commit()
+
+	if err := e.RegisterTemplate("byNameOrTag", "Person Name=$name or Tags=$tag"); err != nil {
+		t.Errorf("RegisterTemplate() failed: %s", err)
+	}
+	if params, err := e.TemplateParams("byNameOrTag"); err != nil || strings.Join(params, ",") != "name,tag" {
+		t.Errorf("TemplateParams() returned %v, %v", params, err)
+	}
+	for _, c := range []struct {
+		params   map[string]string
+		expected []Item
+	}{
+		{map[string]string{"name": "Anna", "tag": "admin"}, []Item{john, anna}},
+		{map[string]string{"name": "Ann' OR 1=1 --", "tag": "none"}, []Item{}},
+		{map[string]string{"name": "x or Name=%", "tag": "none"}, []Item{}},
+	} {
+		r := e.Exec(RunTemplateCommand(db, "byNameOrTag", c.params, 0))
+		if r.HasError || len(r.Items) != len(c.expected) {
+			t.Errorf("RunTemplate command with %v returned %v, %s", c.params, r.Items, r.Str)
+		}
+	}
+	for _, params := range []map[string]string{{"name": "Anna"}, {"name": "Anna", "tag": "x", "other": "y"}} {
+		if r := e.Exec(RunTemplateCommand(db, "byNameOrTag", params, 0)); !r.HasError || r.Int != ErrRunTemplateFailed {
+			t.Errorf("RunTemplate command with parameters %v should fail", params)
+		}
+	}
+	if r := e.Exec(RunTemplateCommand(db, "unknown", nil, 0)); !r.HasError || r.Int != ErrRunTemplateFailed {
+		t.Errorf("RunTemplate command should fail for an unknown template")
+	}
+	if r := e.Exec(RegisterTemplateCommand("bad", "Person (Name=$name")); !r.HasError || r.Int != ErrRegisterTemplateFailed {
+		t.Errorf("RegisterTemplate command should fail for an invalid query")
+	}
+
+	e.SetTemplatesOnly(true)
+	query, _ := ParseQuery("Person Name=%")
+	for name, cmd := range map[string]*Command{"Find": FindCommand(db, query, 0),
+		"FindWithin": FindWithinCommand(db, query, []Item{john}, 0), "ToSql": ToSqlCommand(db, "Person", query, 0),
+		"RegisterTemplate": RegisterTemplateCommand("all", "Person Name=%")} {
+		if r := e.Exec(cmd); !r.HasError || r.Int != ErrNotPermitted {
+			t.Errorf("%s command should not be permitted", name)
+		}
+	}
+	if r := e.Exec(RunTemplateCommand(db, "byNameOrTag", map[string]string{"name": "John", "tag": "none"}, 0)); r.HasError || len(r.Items) != 1 {
+		t.Errorf("RunTemplate command returned %v, %s when only templates are permitted", r.Items, r.Str)
+	}
+}
+
+func TestFindStream(t *testing.T) {
+	tmp, _ := ioutil.TempFile("", "minidb-exec-testing-*")
+	defer os.Remove(tmp.Name())
+	db := CommandDB(tmp.Name())
+	e := NewExecutor()
+	defer e.CloseAllDBs()
+	e.Exec(OpenCommand("sqlite3", tmp.Name()))
+	e.Exec(AddTableCommand(db, "Person", []Field{Field{Name: "Name", Sort: DBString}}))
+	mdb := e.lookupDB(db).db
+	tx, _ := mdb.Begin()
+	rows := make([][]FieldValue, 25)
+	for i := range rows {
+		name := "John"
+		if i%5 == 0 {
+			name = "Anna"
+		}
+		rows[i] = []FieldValue{{Field: "Name", Values: []Value{NewString(name)}}}
+	}
+	tx.NewItems("Person", rows)
+	tx.Commit()
+	query, _ := ParseQuery("Person Name=John")
+	all, _ := mdb.Find(query, 0)
+	sort.Slice(all, func(i, j int) bool { return all[i] < all[j] })
+
+	if items, err := mdb.FindAfter(query, all[3], 2); err != nil || len(items) != 2 || items[0] != all[4] || items[1] != all[5] {
+		t.Errorf("FindAfter() returned %v, %v", items, err)
+	}
+	stream := func(limit int64, chunk int64) ([]Item, int) {
+		r := e.Exec(FindStreamCommand(db, query, limit, chunk))
+		result := r.Items
+		chunks := 1
+		for !r.HasError && r.Str != "" {
+			if int64(len(r.Items)) != chunk {
+				t.Errorf("FindStream returned a chunk of %d items, expected %d", len(r.Items), chunk)
+			}
+			r = e.Exec(FindNextCommand(db, r.Str))
+			result = append(result, r.Items...)
+			chunks++
+		}
+		if r.HasError {
+			t.Errorf("FindStream failed: %s", r.Str)
+		}
+		return result, chunks
+	}
+	if items, chunks := stream(0, 7); !reflect.DeepEqual(items, all) || chunks != 3 {
+		t.Errorf("FindStream returned %v in %d chunks, expected %v in 3 chunks", items, chunks, all)
+	}
+	if items, chunks := stream(0, 5); len(items) != 20 || chunks != 4 {
+		t.Errorf("FindStream returned %d items in %d chunks, expected 20 in 4 chunks", len(items), chunks)
+	}
+	if items, chunks := stream(12, 5); !reflect.DeepEqual(items, all[:12]) || chunks != 3 {
+		t.Errorf("FindStream with limit returned %v in %d chunks", items, chunks)
+	}
+	if len(e.cursors) != 0 {
+		t.Errorf("exhausted cursors were not removed: %d left", len(e.cursors))
+	}
+
+	r := e.Exec(FindStreamCommand(db, query, 0, 2))
+	if r.Str == "" || len(r.Items) != 2 {
+		t.Errorf("FindStream returned %v without a cursor", r.Items)
+	}
+	if next := e.Exec(FindNextCommand(CommandDB("other"), r.Str)); !next.HasError {
+		t.Errorf("FindNext should fail for another database")
+	}
+	e.Exec(FindCloseCommand(db, r.Str))
+	if next := e.Exec(FindNextCommand(db, r.Str)); !next.HasError || next.Int != ErrUnknownCursor {
+		t.Errorf("FindNext should fail for a closed cursor")
+	}
+	e.cursors["old"] = &findCursor{db: db, query: *query, chunk: 2, used: time.Now().Add(-2 * cursorTimeout)}
+	e.Exec(FindStreamCommand(db, query, 0, 2))
+	if _, ok := e.cursors["old"]; ok {
+		t.Errorf("an expired cursor was not removed")
+	}
+	bad, _ := ParseQuery("Person Nothing=John")
+	if r := e.Exec(FindStreamCommand(db, bad, 0, 2)); !r.HasError || r.Int != ErrFindFailed {
+		t.Errorf("FindStream should fail for an invalid query")
+	}
+}
+
+func TestFindOpenFetch(t *testing.T) {
+	tmp, _ := ioutil.TempFile("", "minidb-exec-testing-*")
+	defer os.Remove(tmp.Name())
+	db := CommandDB(tmp.Name())
+	e := NewExecutor()
+	defer e.CloseAllDBs()
+	e.Exec(OpenCommand("sqlite3", tmp.Name()))
+	e.Exec(AddTableCommand(db, "Person", []Field{Field{Name: "Name", Sort: DBString}}))
+	mdb := e.lookupDB(db).db
+	tx, _ := mdb.Begin()
+	rows := make([][]FieldValue, 12)
+	for i := range rows {
+		rows[i] = []FieldValue{{Field: "Name", Values: []Value{NewString(fmt.Sprintf("John %d", i))}}}
+	}
+	tx.NewItems("Person", rows)
+	tx.Commit()
+	query, _ := ParseQuery("Person Name=John%")
+	all, _ := mdb.Find(query, 0)
+	sort.Slice(all, func(i, j int) bool { return all[i] < all[j] })
+
+	r := e.Exec(FindOpenCommand(db, query, 0, "Name"))
+	if r.HasError || r.Str == "" || len(r.Items) != 0 {
+		t.Fatalf("FindOpen returned %v", r)
+	}
+	cursor := r.Str
+	items := make([]Item, 0)
+	fetches := 0
+	for cursor != "" {
+		r = e.Exec(FetchCommand(db, cursor, 5))
+		if r.HasError {
+			t.Fatalf("Fetch failed: %s", r.Str)
+		}
+		for _, item := range r.Items {
+			name, _ := mdb.Get("Person", item, "Name")
+			if v := r.ItemValues[item]; len(v) != 1 || v[0].Str != name[0].Str {
+				t.Errorf("Fetch returned the values %v for item %d, expected %v", v, item, name)
+			}
+		}
+		items = append(items, r.Items...)
+		cursor = r.Str
+		fetches++
+	}
+	if !reflect.DeepEqual(items, all) || fetches != 3 {
+		t.Errorf("Fetch returned %v in %d chunks, expected %v in 3 chunks", items, fetches, all)
+	}
+	if len(e.cursors) != 0 {
+		t.Errorf("an exhausted cursor was not removed")
+	}
+
+	r = e.Exec(FindOpenCommand(db, query, 3, ""))
+	if r = e.Exec(FetchCommand(db, r.Str, 0)); len(r.Items) != 3 || r.ItemValues != nil || r.Str != "" {
+		t.Errorf("Fetch with a limit and without a field returned %v", r)
+	}
+	r = e.Exec(FindOpenCommand(db, query, 0, ""))
+	e.Exec(CursorCloseCommand(db, r.Str))
+	if r = e.Exec(FetchCommand(db, r.Str, 1)); !r.HasError || r.Int != ErrUnknownCursor {
+		t.Errorf("Fetch should fail for a closed cursor")
+	}
+	if r = e.Exec(FindOpenCommand(db, query, 0, "Nothing")); !r.HasError || !errors.Is(r.Err(), ErrFieldNotFound) {
+		t.Errorf("FindOpen should fail for an unknown field, returned %v", r)
+	}
+}
+
+func TestWriteQueue(t *testing.T) {
+	tmp, _ := ioutil.TempFile("", "minidb-exec-testing-*")
+	defer os.Remove(tmp.Name())
+	db := CommandDB(tmp.Name())
+	e := NewExecutor()
+	defer e.CloseAllDBs()
+	e.Exec(OpenCommand("sqlite3", tmp.Name()))
+	e.Exec(AddTableCommand(db, "Person", []Field{Field{Name: "Name", Sort: DBString}}))
+
+	// concurrent writes of many clients all succeed
+	var wg sync.WaitGroup
+	failed := make(chan string, 50)
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if r := e.Exec(NewItemCommand(db, 0, "Person")); r.HasError {
+				failed <- r.Str
+			}
+		}()
+	}
+	wg.Wait()
+	close(failed)
+	for msg := range failed {
+		t.Errorf("concurrent write failed: %s", msg)
+	}
+	if r := e.Exec(CountCommand(db, "Person")); r.Int != 50 {
+		t.Errorf("expected 50 items after concurrent writes, found %d", r.Int)
+	}
+
+	// a full backlog rejects writes while reads keep flowing
+	e.SetWriteBacklog(2)
+	entered := make(chan bool)
+	release := make(chan bool)
+	blocked := false
+	stop := e.lookupDB(db).db.OnChange(func(event ChangeEvent) {
+		if !blocked {
+			blocked = true
+			entered <- true
+			<-release
+		}
+	})
+	defer stop()
+	results := make(chan *Result, 3)
+	for i := 0; i < 3; i++ {
+		go func() { results <- e.Exec(NewItemCommand(db, 0, "Person")) }()
+		if i == 0 {
+			<-entered
+		}
+	}
+	for {
+		entry := e.lookupDB(db)
+		entry.mutex.Lock()
+		n := len(entry.queue.jobs)
+		entry.mutex.Unlock()
+		if n == 2 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if r := e.Exec(NewItemCommand(db, 0, "Person")); !r.HasError || r.Int != ErrWriteQueueFull {
+		t.Errorf("a write should be rejected when the backlog is full")
+	}
+	if r := e.Exec(CountCommand(db, "Person")); r.HasError || r.Int != 51 {
+		t.Errorf("a read was blocked by pending writes: %v", r)
+	}
+	close(release)
+	for i := 0; i < 3; i++ {
+		if r := <-results; r.HasError {
+			t.Errorf("a queued write failed: %s", r.Str)
+		}
+	}
+
+	// without a backlog, writes are executed directly
+	e.SetWriteBacklog(0)
+	if r := e.Exec(NewItemCommand(db, 0, "Person")); r.HasError {
+		t.Errorf("a direct write failed: %s", r.Str)
+	}
+	if e.lookupDB(db).queue != nil {
+		t.Errorf("a write queue was created although the backlog is 0")
+	}
+}
+
+func TestExecutorPerDBLocking(t *testing.T) {
+	busy, _ := ioutil.TempFile("", "minidb-exec-testing-*")
+	defer os.Remove(busy.Name())
+	other, _ := ioutil.TempFile("", "minidb-exec-testing-*")
+	defer os.Remove(other.Name())
+	e := NewExecutor()
+	defer e.CloseAllDBs()
+	for _, name := range []string{busy.Name(), other.Name()} {
+		if r := e.Exec(OpenCommand("sqlite3", name)); r.HasError {
+			t.Fatalf("Open command failed: %s", r.Str)
+		}
+		e.Exec(AddTableCommand(CommandDB(name), "Person", []Field{Field{Name: "Name", Sort: DBString}}))
+	}
+
+	// a write that hangs on one database does not stall commands on another
+	entered := make(chan bool)
+	release := make(chan bool)
+	stop := e.lookupDB(CommandDB(busy.Name())).db.OnChange(func(event ChangeEvent) {
+		entered <- true
+		<-release
+	})
+	defer stop()
+	done := make(chan *Result)
+	go func() { done <- e.Exec(NewItemCommand(CommandDB(busy.Name()), 0, "Person")) }()
+	<-entered
+	db := CommandDB(other.Name())
+	if r := e.Exec(NewItemCommand(db, 0, "Person")); r.HasError {
+		t.Errorf("a write on another database failed: %s", r.Str)
+	}
+	if r := e.Exec(CountCommand(db, "Person")); r.HasError || r.Int != 1 {
+		t.Errorf("a read on another database failed: %v", r)
+	}
+	r := e.Exec(BeginCommand(db))
+	if r.HasError {
+		t.Fatalf("Begin command failed: %s", r.Str)
+	}
+	if r = e.Exec(CommitCommand(db, TxID(r.Int))); r.HasError {
+		t.Errorf("Commit command failed: %s", r.Str)
+	}
+	if r = e.Exec(CloseCommand(db)); r.HasError {
+		t.Errorf("Close command failed: %s", r.Str)
+	}
+	if e.lookupDB(db) != nil {
+		t.Errorf("a closed database is still registered")
+	}
+	close(release)
+	if r = <-done; r.HasError {
+		t.Errorf("the blocked write failed: %s", r.Str)
+	}
+}
+
+func TestExecBatch(t *testing.T) {
+	tmp, _ := ioutil.TempFile("", "minidb-exec-testing-*")
+	defer os.Remove(tmp.Name())
+	db := CommandDB(tmp.Name())
+	e := NewExecutor()
+	defer e.CloseAllDBs()
+	results := e.ExecBatch([]Command{*OpenCommand("sqlite3", tmp.Name()),
+		*AddTableCommand(db, "Person", []Field{Field{Name: "Name", Sort: DBString}}),
+		*NewItemCommand(db, 0, "Person"), *NewItemCommand(db, 0, "Person")}, false)
+	if len(results) != 4 || results[3].HasError {
+		t.Fatalf("ExecBatch returned %v", results)
+	}
+	item := results[2].Items[0]
+
+	// commands in a transaction see each other's writes and are committed together
+	results = e.ExecBatch([]Command{*SetCommand(db, 0, "Person", item, "Name", []Value{NewString("John")}),
+		*SetStrKeyCommand(db, 0, "greeting", "hello"), *GetCommand(db, "Person", item, "Name")}, true)
+	if len(results) != 3 || results[2].HasError || len(results[2].Values) != 1 || results[2].Values[0].Str != "John" {
+		t.Errorf("ExecBatch in a transaction returned %v", results)
+	}
+	if r := e.Exec(GetStrKeyCommand(db, "greeting")); r.Str != "hello" {
+		t.Errorf("ExecBatch did not commit its transaction, GetStrKey returned '%s'", r.Str)
+	}
+
+	// a failing command stops the batch and rolls back its transaction
+	results = e.ExecBatch([]Command{*SetCommand(db, 0, "Person", item, "Name", []Value{NewString("Jane")}),
+		*SetCommand(db, 0, "Nobody", item, "Name", []Value{NewString("Jane")}),
+		*SetStrKeyCommand(db, 0, "greeting", "goodbye")}, true)
+	if len(results) != 2 || !results[1].HasError || results[1].Int != ErrSetFailed {
+		t.Errorf("ExecBatch with a failing command returned %v", results)
+	}
+	if r := e.Exec(GetCommand(db, "Person", item, "Name")); len(r.Values) != 1 || r.Values[0].Str != "John" {
+		t.Errorf("ExecBatch did not roll back its transaction, Get returned %v", r.Values)
+	}
+
+	// commands that cannot be executed in a transaction are rejected
+	results = e.ExecBatch([]Command{*NewItemCommand(db, 0, "Person")}, true)
+	if len(results) != 1 || results[0].Int != ErrInvalidCommand {
+		t.Errorf("ExecBatch in a transaction returned %v for NewItem, expected ErrInvalidCommand", results)
+	}
+	if r := e.Exec(CountCommand(db, "Person")); r.Int != 2 {
+		t.Errorf("expected 2 items after the batches, found %d", r.Int)
+	}
+}
+
+func TestEncodings(t *testing.T) {
+	blob := []byte{0, 1, 2, 127, '"'}
+	cmd := SetCommand("test.db", 1, "Person", 2, "Photo", []Value{NewBytes(blob)})
+	batch := &Batch{Commands: []Command{*cmd, *PingCommand()}, Atomic: true}
+	result := &Result{Str: "hello", Int: 3, Bytes: blob, Values: []Value{NewBytes(blob)},
+		ItemValues: map[Item][]Value{4: []Value{NewInt(5)}}}
+	for _, enc := range []Encoding{EncodingJSON, EncodingBinary} {
+		msg, err := EncodeRequest(cmd, nil, enc)
+		if err != nil {
+			t.Fatalf("EncodeRequest(%d) failed: %s", enc, err)
+		}
+		decoded, _, decodedEnc, err := DecodeRequest(msg)
+		if err != nil || decodedEnc != enc || !reflect.DeepEqual(decoded, cmd) {
+			t.Errorf("DecodeRequest(%d) returned %v, %d, %v, expected %v", enc, decoded, decodedEnc, err, cmd)
+		}
+		msg, _ = EncodeRequest(nil, batch, enc)
+		if _, decodedBatch, _, err := DecodeRequest(msg); err != nil || decodedBatch == nil ||
+			!decodedBatch.Atomic || len(decodedBatch.Commands) != 2 || decodedBatch.Commands[1].ID != CmdPing {
+			t.Errorf("DecodeRequest(%d) returned the batch %v, %v", enc, decodedBatch, err)
+		}
+		msg, _ = EncodeReply(result, nil, enc)
+		if decodedResult, _, err := DecodeReply(msg); err != nil || !reflect.DeepEqual(decodedResult, result) {
+			t.Errorf("DecodeReply(%d) returned %v, %v, expected %v", enc, decodedResult, err, result)
+		}
+		msg, _ = EncodeReply(nil, []Result{*result, Result{}}, enc)
+		if _, results, err := DecodeReply(msg); err != nil || len(results) != 2 || results[0].Str != "hello" {
+			t.Errorf("DecodeReply(%d) returned the results %v, %v", enc, results, err)
+		}
+		msg, _ = EncodeReply(&Result{}, nil, enc)
+		if decodedResult, _, err := DecodeReply(msg); err != nil || decodedResult == nil || decodedResult.HasError {
+			t.Errorf("DecodeReply(%d) returned %v, %v for an empty result", enc, decodedResult, err)
+		}
+	}
+	binaryMsg, _ := EncodeReply(result, nil, EncodingBinary)
+	jsonMsg, _ := EncodeReply(result, nil, EncodingJSON)
+	if len(binaryMsg) >= len(jsonMsg) {
+		t.Errorf("binary encoding with %d bytes is not smaller than JSON encoding with %d bytes", len(binaryMsg), len(jsonMsg))
+	}
+	// unlike JSON, the binary encoding keeps blobs that are not valid UTF-8
+	cmd.ValueArgs[0] = NewBytes([]byte{0, 255, 254})
+	var decoded Command
+	if data, err := cmd.MarshalBinary(); err != nil || decoded.UnmarshalBinary(data) != nil || !reflect.DeepEqual(&decoded, cmd) {
+		t.Errorf("Command.UnmarshalBinary() returned %v, expected %v", decoded, cmd)
+	}
+}
+
+func TestHello(t *testing.T) {
+	e := NewExecutor()
+	defer e.CloseAllDBs()
+	r := e.Exec(HelloCommand())
+	if r.HasError || r.Version != CommandVersion {
+		t.Fatalf("Hello command returned %v", r)
+	}
+	info := ParseHello(r)
+	if info.Version != CommandVersion || !info.HasCapability(CapabilityBatch) || info.HasCapability(CapabilityMultiUser) {
+		t.Errorf("Hello command returned version %d and capabilities %v", info.Version, info.Capabilities)
+	}
+	if !info.Supports(CmdOpen) || !info.Supports(CmdHello) || info.Supports(CmdNewUser) || info.Supports(maxCommand) {
+		t.Errorf("Hello command returned the wrong commands %v", info.Commands)
+	}
+	e.SetRoles(RoleReadOnly)
+	if info := ParseHello(e.Exec(HelloCommand())); !info.Supports(CmdGet) || info.Supports(CmdSet) {
+		t.Errorf("Hello command with role readonly returned the commands %v", info.Commands)
+	}
+	if r := e.Exec(&Command{ID: maxCommand + 10}); !r.HasError || r.Int != ErrUnsupportedCommand {
+		t.Errorf("a command unknown to the executor returned %v, expected ErrUnsupportedCommand", r)
+	}
+	if r := e.Exec(&Command{ID: CmdCount, Version: CommandVersion + 1}); !r.HasError || r.Int != ErrUnsupportedCommand {
+		t.Errorf("a command of a newer version returned %v, expected ErrUnsupportedCommand", r)
+	}
+}
+
+func TestPing(t *testing.T) {
+	tmp, _ := ioutil.TempFile("", "minidb-exec-testing-*")
+	defer os.Remove(tmp.Name())
+	db := CommandDB(tmp.Name())
+	e := NewExecutor()
+	defer e.CloseAllDBs()
+	e.Exec(OpenCommand("sqlite3", tmp.Name()))
+	e.Exec(OpenCommand("sqlite3", tmp.Name()))
+	time.Sleep(5 * time.Millisecond)
+	r := e.Exec(PingCommand(db, "unknown.sqlite"))
+	if r.HasError {
+		t.Fatalf("Ping command failed: %s", r.Str)
+	}
+	info := ParsePing(r)
+	if info.Version != CommandVersion || info.Uptime < 5*time.Millisecond {
+		t.Errorf("Ping command returned version %d and uptime %s", info.Version, info.Uptime)
+	}
+	if !info.IsOpen(db) || info.Clients[db] != 2 || info.IsOpen("unknown.sqlite") {
+		t.Errorf("Ping command returned the clients %v", info.Clients)
+	}
+	e.Exec(CloseCommand(db))
+	e.Exec(CloseCommand(db))
+	if info := ParsePing(e.Exec(PingCommand(db))); info.IsOpen(db) {
+		t.Errorf("Ping command reports a closed database as open")
+	}
+}
+
+func TestTxTimeout(t *testing.T) {
+	tmp, _ := ioutil.TempFile("", "minidb-exec-testing-*")
+	defer os.Remove(tmp.Name())
+	db := CommandDB(tmp.Name())
+	e := NewExecutor()
+	defer e.CloseAllDBs()
+	e.Exec(OpenCommand("sqlite3", tmp.Name()))
+	e.Exec(AddTableCommand(db, "Person", []Field{Field{Name: "Name", Sort: DBString}}))
+	item := e.Exec(NewItemCommand(db, 0, "Person")).Items[0]
+	e.SetTxTimeout(100 * time.Millisecond)
+
+	// an idle transaction is rolled back and reported as expired once
+	r := e.Exec(BeginCommand(db))
+	if r.HasError {
+		t.Fatalf("Begin command failed: %s", r.Str)
+	}
+	idle := TxID(r.Int)
+	if r = e.Exec(SetCommand(db, idle, "Person", item, "Name", []Value{NewString("John")})); r.HasError {
+		t.Fatalf("Set command failed: %s", r.Str)
+	}
+	r = e.Exec(BeginReadCommand(db))
+	if r.HasError {
+		t.Fatalf("Begin command failed: %s", r.Str)
+	}
+	used := TxID(r.Int)
+	if txs := ParseListTx(e.Exec(ListTxCommand(db))); len(txs) != 2 || txs[0].ID != idle || txs[1].ID != used {
+		t.Errorf("ListTx command returned %v", txs)
+	}
+	// a transaction that is used keeps its lease
+	for i := 0; i < 4; i++ {
+		time.Sleep(40 * time.Millisecond)
+		if r = e.Exec(CountCommand(db, "Person").InTx(used)); r.HasError {
+			t.Fatalf("Count command in a used transaction failed: %s", r.Str)
+		}
+	}
+	time.Sleep(50 * time.Millisecond)
+	if r = e.Exec(CommitCommand(db, idle)); !r.HasError || r.Int != ErrTxExpired {
+		t.Errorf("Commit of an expired transaction returned %v, expected ErrTxExpired", r)
+	}
+	if r = e.Exec(CommitCommand(db, idle)); !r.HasError || r.Int != ErrUnknownTx {
+		t.Errorf("second Commit of an expired transaction returned %v, expected ErrUnknownTx", r)
+	}
+	if r = e.Exec(GetCommand(db, "Person", item, "Name")); r.HasError || (len(r.Values) > 0 && r.Values[0].Str == "John") {
+		t.Errorf("the writes of an expired transaction were not rolled back: %v", r)
+	}
+	if r = e.Exec(CommitCommand(db, used)); r.HasError {
+		t.Errorf("Commit command failed: %s", r.Str)
+	}
+	if txs := ParseListTx(e.Exec(ListTxCommand(db))); len(txs) != 0 {
+		t.Errorf("ListTx command returned %v after all transactions ended", txs)
+	}
+	// expired transactions are forgotten after a while
+	e.expiredTxs.Store(TxID(1000), time.Now().Add(-2*expiredTxRetention))
+	r = e.Exec(BeginCommand(db))
+	time.Sleep(150 * time.Millisecond)
+	if _, ok := e.expiredTxs.Load(TxID(1000)); ok {
+		t.Errorf("an expired transaction was remembered for longer than %s", expiredTxRetention)
+	}
+	if _, ok := e.expiredTxs.Load(TxID(r.Int)); !ok {
+		t.Errorf("a transaction that has just expired was not remembered")
+	}
+
+	// without a timeout, transactions do not expire
+	e.SetTxTimeout(0)
+	r = e.Exec(BeginCommand(db))
+	time.Sleep(150 * time.Millisecond)
+	if r = e.Exec(RollbackCommand(db, TxID(r.Int))); r.HasError {
+		t.Errorf("Rollback command failed: %s", r.Str)
+	}
+}
+
+func TestCommandWrappers(t *testing.T) {
+	tmp, _ := ioutil.TempFile("", "minidb-exec-testing-*")
+	defer os.Remove(tmp.Name())
+	db := CommandDB(tmp.Name())
+	e := NewExecutor()
+	defer e.CloseAllDBs()
+	e.Exec(OpenCommand("sqlite3", tmp.Name()))
+	e.Exec(AddTableCommand(db, "Person", []Field{Field{Name: "Name", Sort: DBString}}))
+	john := e.Exec(NewItemCommand(db, 0, "Person")).Items[0]
+	e.Exec(NewItemCommand(db, 0, "Person"))
+	tx := TxID(e.Exec(BeginCommand(db)).Int)
+	if r := e.Exec(EnableHistoryCommand(db, tx, "Person", 0)); r.HasError {
+		t.Errorf("EnableHistory command failed: %s", r.Str)
+	}
+	e.Exec(SetCommand(db, tx, "Person", john, "Name", []Value{NewString("John")}))
+	e.Exec(SetCommand(db, tx, "Person", john, "Name", []Value{NewString("Johnny")}))
+	for key := int64(1); key <= 5; key++ {
+		e.Exec(SetIntCommand(db, tx, key, key*10))
+		e.Exec(SetStrCommand(db, tx, key, fmt.Sprintf("value %d", key)))
+		e.Exec(SetFloatCommand(db, tx, key, float64(key)/2))
+	}
+	if r := e.Exec(CommitCommand(db, tx)); r.HasError {
+		t.Fatalf("Commit command failed: %s", r.Str)
+	}
+
+	query, _ := ParseQuery("Person Name=John%")
+	if r := e.Exec(CountQueryCommand(db, query)); r.HasError || r.Int != 1 {
+		t.Errorf("CountQuery returned %v, expected 1", r)
+	}
+	bad, _ := ParseQuery("Person Nothing=John")
+	if r := e.Exec(CountQueryCommand(db, bad)); !r.HasError || r.Int != ErrCountFailed {
+		t.Errorf("CountQuery should fail for an invalid query")
+	}
+	if r := e.Exec(HasTimestampsCommand(db, "Person")); r.HasError || r.Bool {
+		t.Errorf("HasTimestamps returned %v, expected false", r)
+	}
+	if size := ParseDatabaseSize(e.Exec(DatabaseSizeCommand(db))); size.FileSize <= 0 || size.PageSize <= 0 {
+		t.Errorf("DatabaseSize returned %v", size)
+	}
+	if r := e.Exec(TableSizeCommand(db, "Person")); r.HasError && r.Int != ErrSizeFailed {
+		t.Errorf("TableSize returned %v", r)
+	}
+	if r := e.Exec(ListIntRangeCommand(db, 2, 4)); !reflect.DeepEqual(r.Ints, []int64{2, 3, 4}) {
+		t.Errorf("ListIntRange returned %v", r.Ints)
+	}
+	if r := e.Exec(ListStrRangeCommand(db, 4, 9)); !reflect.DeepEqual(r.Ints, []int64{4, 5}) {
+		t.Errorf("ListStrRange returned %v", r.Ints)
+	}
+	if r := e.Exec(ListFloatRangeCommand(db, 0, 1)); !reflect.DeepEqual(r.Ints, []int64{1}) {
+		t.Errorf("ListFloatRange returned %v", r.Ints)
+	}
+	if r := e.Exec(ListBlobRangeCommand(db, 0, 10)); len(r.Ints) != 0 {
+		t.Errorf("ListBlobRange returned %v", r.Ints)
+	}
+	if r := e.Exec(FindStrValueCommand(db, "value 3")); !reflect.DeepEqual(r.Ints, []int64{3}) {
+		t.Errorf("FindStrValue returned %v", r.Ints)
+	}
+	if r := e.Exec(FindFloatValuesCommand(db, 1, 2)); !reflect.DeepEqual(r.Ints, []int64{2, 3, 4}) {
+		t.Errorf("FindFloatValues returned %v", r.Ints)
+	}
+	r := e.Exec(HistoryOfCommand(db, "Person", john, "Name"))
+	if r.HasError {
+		t.Fatalf("HistoryOf failed: %s", r.Str)
+	}
+	changes := ParseHistory(r)
+	expected, _ := e.lookupDB(db).db.HistoryOf("Person", john, "Name")
+	if len(changes) != len(expected) || len(changes) == 0 ||
+		changes[len(changes)-1].Values[0].Str != "Johnny" || !changes[0].Time.Equal(expected[0].Time) {
+		t.Errorf("HistoryOf returned %v, expected %v", changes, expected)
+	}
+	if r := e.Exec(HistoryOfCommand(db, "Nothing", john, "Name")); !r.HasError || r.Int != ErrHistoryFailed {
+		t.Errorf("HistoryOf should fail for an unknown table")
+	}
+}
+
+func TestJobs(t *testing.T) {
+	tmp, _ := ioutil.TempFile("", "minidb-exec-testing-*")
+	defer os.Remove(tmp.Name())
+	db := CommandDB(tmp.Name())
+	e := NewExecutor()
+	defer e.CloseAllDBs()
+	e.Exec(OpenCommand("sqlite3", tmp.Name()))
+	e.Exec(AddTableCommand(db, "Person", []Field{Field{Name: "Name", Sort: DBString}}))
+	e.Exec(NewItemCommand(db, 0, "Person"))
+
+	r := e.Exec(SubmitCommand(ExportJSONCommand(db)))
+	if r.HasError || r.Str == "" {
+		t.Fatalf("Submit command failed: %v", r)
+	}
+	job := r.Str
+	for {
+		r = e.Exec(JobStatusCommand(db, job))
+		if r.HasError {
+			t.Fatalf("JobStatus command failed: %s", r.Str)
+		}
+		if r.Bool {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if r = e.Exec(JobResultCommand(db, job)); r.HasError || !strings.Contains(r.Str, "Person") {
+		t.Errorf("JobResult command returned %v", r)
+	}
+	if r = e.Exec(JobResultCommand(db, job)); !r.HasError || r.Int != ErrUnknownJob {
+		t.Errorf("the result of a job should only be returned once")
+	}
+
+	// the result of a failed command is the result of its job
+	r = e.Exec(SubmitCommand(FindCommand(db, &Query{Data: "Nothing"}, 0)))
+	job = r.Str
+	for !e.Exec(JobStatusCommand(db, job)).Bool {
+		time.Sleep(time.Millisecond)
+	}
+	if r = e.Exec(JobResultCommand(db, job)); !r.HasError || r.Int != ErrFindFailed {
+		t.Errorf("JobResult command of a failed Find returned %v, expected ErrFindFailed", r)
+	}
+
+	// pending jobs can be cancelled before they run
+	for i := 0; i < maxRunningJobs; i++ {
+		e.jobSlots <- struct{}{}
+	}
+	job = e.Exec(SubmitCommand(CompactCommand(db))).Str
+	if r = e.Exec(JobStatusCommand(db, job)); r.HasError || JobState(r.Int) != JobPending {
+		t.Errorf("JobStatus command returned %v, expected JobPending", r)
+	}
+	if r = e.Exec(JobResultCommand(db, job)); !r.HasError || r.Int != ErrJobNotDone {
+		t.Errorf("JobResult command of a pending job returned %v, expected ErrJobNotDone", r)
+	}
+	if r = e.Exec(JobCancelCommand(db, job)); r.HasError {
+		t.Errorf("JobCancel command failed: %s", r.Str)
+	}
+	for i := 0; i < maxRunningJobs; i++ {
+		<-e.jobSlots
+	}
+	if r = e.Exec(JobStatusCommand(db, job)); !r.HasError || r.Int != ErrUnknownJob {
+		t.Errorf("a cancelled job should be unknown, JobStatus returned %v", r)
+	}
+	if r = e.Exec(JobStatusCommand(CommandDB("other"), job)); !r.HasError {
+		t.Errorf("JobStatus should fail for another database")
+	}
+	if r = e.Exec(SubmitCommand(NewItemCommand(db, 0, "Person"))); !r.HasError || r.Int != ErrInvalidCommand {
+		t.Errorf("Submit command of a NewItem command returned %v, expected ErrInvalidCommand", r)
+	}
+}
+
+func TestServerShutdown(t *testing.T) {
+	e := NewExecutor()
+	defer e.CloseAllDBs()
+	if r := e.Exec(ServerShutdownCommand()); !r.HasError || r.Int != ErrUnsupportedCommand {
+		t.Errorf("ServerShutdown command without a shutdown handler returned %v", r)
+	}
+	if e.hello().Supports(CmdServerShutdown) {
+		t.Errorf("Hello command lists ServerShutdown without a shutdown handler")
+	}
+	calls := 0
+	e.SetShutdownHandler(func() { calls++ })
+	if r := e.Exec(ServerShutdownCommand()); !r.HasError || r.Int != ErrNotPermitted || calls != 0 {
+		t.Errorf("ServerShutdown command of a server without authentication returned %v", r)
+	}
+	e.SetAuthTokens("secret")
+	if !e.hello().Supports(CmdServerShutdown) {
+		t.Errorf("Hello command does not list ServerShutdown with a shutdown handler")
+	}
+	e.SetReadOnly(true)
+	if r := e.Exec(ServerShutdownCommand()); !r.HasError || calls != 0 {
+		t.Errorf("ServerShutdown command of a read-only executor returned %v", r)
+	}
+	e.SetReadOnly(false)
+	e.SetRoles(RoleUser)
+	if r := e.Exec(ServerShutdownCommand()); !r.HasError || r.Int != ErrNotPermitted || calls != 0 {
+		t.Errorf("ServerShutdown command of an executor with RoleUser returned %v", r)
+	}
+	// the command itself needs admin rights, whatever a custom role permits
+	e.DefineRole("operator", func(id CommandID) bool { return id == CmdServerShutdown })
+	e.SetRoles("operator")
+	if r := e.Exec(ServerShutdownCommand()); !r.HasError || r.Int != ErrNotPermitted || calls != 0 {
+		t.Errorf("ServerShutdown command of an executor with a custom role returned %v", r)
+	}
+	e.ClearRoles()
+	cmd := ServerShutdownCommand()
+	cmd.roles = []Role{RoleUser}
+	if r := e.serverShutdown(cmd); !r.HasError || r.Int != ErrNotPermitted || calls != 0 {
+		t.Errorf("ServerShutdown command of a client with RoleUser returned %v", r)
+	}
+	e.SetRoles(RoleAdmin)
+	if r := e.Exec(ServerShutdownCommand()); r.HasError || calls != 1 {
+		t.Errorf("ServerShutdown command failed or did not call the handler: %v", r)
+	}
+	if r := e.CheckAuth(ServerShutdownCommand(), false); r == nil {
+		t.Errorf("ServerShutdown command accepted on a connection that is not authenticated")
+	}
+}
+
+func TestStats(t *testing.T) {
+	tmp, _ := ioutil.TempFile("", "minidb-exec-testing-*")
+	defer os.Remove(tmp.Name())
+	db := CommandDB(tmp.Name())
+	e := NewExecutor()
+	defer e.CloseAllDBs()
+	e.Exec(OpenCommand("sqlite3", tmp.Name()))
+	e.Exec(AddTableCommand(db, "Person", []Field{Field{Name: "Name", Sort: DBString}}))
+	e.Exec(CountCommand(db, "Person"))
+	e.Exec(CountCommand(db, "Person"))
+	e.Exec(CountCommand(db, "Unknown"))
+
+	r := e.Exec(StatsCommand())
+	if r.HasError {
+		t.Fatalf("Stats command failed: %s", r.Str)
+	}
+	stats := ParseStats(r)
+	if len(stats.Bounds) != len(latencyBounds) || len(stats.Commands) != 3 {
+		t.Fatalf("Stats command returned %d bounds and the commands %v", len(stats.Bounds), stats.Commands)
+	}
+	var count *CommandStats
+	for i := range stats.Commands {
+		if stats.Commands[i].ID == CmdCount {
+			count = &stats.Commands[i]
+		}
+	}
+	if count == nil || count.Executed != 3 || count.Failed != 1 || len(count.Buckets) != len(latencyBounds)+1 {
+		t.Fatalf("Stats command returned %v for the Count commands", count)
+	}
+	var buckets int64
+	for _, n := range count.Buckets {
+		buckets += n
+	}
+	if buckets != 3 || count.Duration <= 0 {
+		t.Errorf("Stats command returned the buckets %v and duration %s", count.Buckets, count.Duration)
+	}
+	var buf bytes.Buffer
+	if err := stats.WritePrometheus(&buf); err != nil {
+		t.Fatalf("WritePrometheus failed: %s", err)
+	}
+	expected := fmt.Sprintf("minidb_commands_failed_total{command=\"%d\"} 1\n", CmdCount)
+	if !strings.Contains(buf.String(), expected) ||
+		!strings.Contains(buf.String(), fmt.Sprintf("minidb_command_duration_seconds_count{command=\"%d\"} 3\n", CmdCount)) {
+		t.Errorf("WritePrometheus wrote:\n%s", buf.String())
+	}
+}
+
+func TestSubscriptions(t *testing.T) {
+	tmp, _ := ioutil.TempFile("", "minidb-exec-testing-*")
+	defer os.Remove(tmp.Name())
+	db := CommandDB(tmp.Name())
+	e := NewExecutor()
+	defer e.CloseAllDBs()
+	e.Exec(OpenCommand("sqlite3", tmp.Name()))
+	e.Exec(AddTableCommand(db, "Person", []Field{Field{Name: "Name", Sort: DBString}}))
+	if r := e.Exec(SubscribeCommand(db, "Unknown")); !r.HasError || r.Int != ErrSubscribeFailed ||
+		!errors.Is(r.Err(), ErrTableNotFound) {
+		t.Errorf("Subscribe command should fail for an unknown table")
+	}
+	r := e.Exec(SubscribeCommand(db, "Person"))
+	if r.HasError {
+		t.Fatalf("Subscribe command failed: %s", r.Str)
+	}
+	table := r.Str
+	query, _ := ParseQuery("Person Name=John")
+	r = e.Exec(SubscribeQueryCommand(db, query))
+	if r.HasError {
+		t.Fatalf("SubscribeQuery command failed: %s", r.Str)
+	}
+	matching := r.Str
+
+	john := e.Exec(NewItemCommand(db, 0, "Person")).Items[0]
+	jane := e.Exec(NewItemCommand(db, 0, "Person")).Items[0]
+	tx := TxID(e.Exec(BeginCommand(db)).Int)
+	e.Exec(SetCommand(db, tx, "Person", john, "Name", []Value{NewString("John")}))
+	e.Exec(SetCommand(db, tx, "Person", jane, "Name", []Value{NewString("Jane")}))
+	e.Exec(RemoveItemCommand(db, tx, "Person", jane))
+	if events, _ := ParsePoll(e.Exec(PollCommand(db, table, 0))); len(events) != 2 {
+		t.Errorf("Poll command returned %v before the transaction has been committed", events)
+	}
+	if r = e.Exec(CommitCommand(db, tx)); r.HasError {
+		t.Fatalf("Commit command failed: %s", r.Str)
+	}
+
+	events, lost := ParsePoll(e.Exec(PollCommand(db, table, 0)))
+	if len(events) != 3 || lost || events[0].Kind != ChangeSet || events[0].Item != john ||
+		events[1].Kind != ChangeSet || events[1].Item != jane || events[1].Field != "Name" ||
+		events[2].Kind != ChangeRemoveItem || events[2].Item != jane {
+		t.Errorf("Poll command returned %v for the table", events)
+	}
+	events, _ = ParsePoll(e.Exec(PollCommand(db, matching, 0)))
+	if len(events) != 2 || events[0].Kind != ChangeSet || events[0].Item != john ||
+		events[1].Kind != ChangeRemoveItem || events[1].Table != "Person" {
+		t.Errorf("Poll command returned %v for the query", events)
+	}
+	if events, _ = ParsePoll(e.Exec(PollCommand(db, table, 0))); len(events) != 0 {
+		t.Errorf("Poll command returned %v again", events)
+	}
+
+	// a waiting poll returns as soon as there are events
+	done := make(chan *Result)
+	go func() { done <- e.Exec(PollCommand(db, table, 10*time.Second)) }()
+	time.Sleep(50 * time.Millisecond)
+	e.Exec(NewItemCommand(db, 0, "Person"))
+	select {
+	case r = <-done:
+		if events, _ = ParsePoll(r); len(events) != 1 || events[0].Kind != ChangeNewItem {
+			t.Errorf("waiting Poll command returned %v", events)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("waiting Poll command did not return")
+	}
+
+	if r = e.Exec(UnsubscribeCommand(db, table)); r.HasError {
+		t.Errorf("Unsubscribe command failed: %s", r.Str)
+	}
+	if r = e.Exec(PollCommand(db, table, 0)); !r.HasError || r.Int != ErrUnknownSubscription {
+		t.Errorf("Poll command should fail after Unsubscribe")
+	}
+	e.Exec(CloseCommand(db))
+	e.Exec(OpenCommand("sqlite3", tmp.Name()))
+	if r = e.Exec(PollCommand(db, matching, 0)); !r.HasError || r.Int != ErrUnknownSubscription {
+		t.Errorf("Poll command should fail after the database has been closed")
+	}
+}
@@ -0,0 +1,157 @@
+package minidb
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"time"
+)
+
+// ------------------------------------------------------------------------------
+// Cursors for streaming the results of Find commands
+// ------------------------------------------------------------------------------
+
+// DefaultStreamChunk is the number of items per result of FindStream and FindNext commands
+// if no chunk size is given.
+const DefaultStreamChunk = 1000
+
+// cursorTimeout is the time after which an executor forgets a cursor that has not been used.
+const cursorTimeout = 10 * time.Minute
+
+// findCursor is the position of a streamed Find in its results. Only the query and the last
+// item returned are kept, the next chunk is found with MDB.FindAfter. If field is not empty,
+// the values of the field are fetched together with the items.
+type findCursor struct {
+	db        CommandDB
+	tx        TxID
+	query     Query
+	field     string
+	last      Item
+	remaining int64
+	chunk     int64
+	used      time.Time
+}
+
+// newToken returns a random token for a cursor or another kind of handle that is hard to guess
+// for other clients of the executor.
+func newToken(kind string) (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", Fail("cannot create %s: %s", kind, err)
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// getCursor returns the cursor with the given token for the database.
+func (e *Executor) getCursor(db CommandDB, token string) (*findCursor, error) {
+	e.mutex.RLock()
+	defer e.mutex.RUnlock()
+	c, ok := e.cursors[token]
+	if !ok || c.db != db {
+		return nil, Fail("unknown or expired cursor '%s'", token)
+	}
+	return c, nil
+}
+
+// closeCursor removes the cursor with the given token.
+func (e *Executor) closeCursor(token string) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	delete(e.cursors, token)
+}
+
+// cursorDB returns the database or transaction view in which the cursor finds items. If the
+// cursor is in a transaction, it is returned as well and must be released with releaseTx.
+func (e *Executor) cursorDB(c *findCursor) (*MDB, *openTx, *Result) {
+	if c.tx == 0 {
+		db, errResult := e.getDB(&Command{DB: c.db})
+		return db, nil, errResult
+	}
+	tx, errResult := e.getTx(&Command{Tx: c.tx})
+	if errResult != nil {
+		return nil, nil, errResult
+	}
+	return tx.tx.View(), tx, nil
+}
+
+// streamChunk finds the next chunk of items for the cursor. It returns the token of the cursor
+// if there may be more items and the empty string otherwise. New cursors are registered under
+// a new token when more items follow, and exhausted cursors are removed.
+func (e *Executor) streamChunk(db *MDB, c *findCursor, token string) ([]Item, string, error) {
+	if c.chunk <= 0 {
+		c.chunk = DefaultStreamChunk
+	}
+	n := c.chunk
+	if c.remaining > 0 && c.remaining < n {
+		n = c.remaining
+	}
+	// one more item than needed tells whether the cursor is exhausted
+	items, err := db.FindAfter(&c.query, c.last, n+1)
+	if err != nil {
+		if token != "" {
+			e.closeCursor(token)
+		}
+		return nil, "", err
+	}
+	more := int64(len(items)) > n
+	if more {
+		items = items[:n]
+	}
+	if c.remaining > 0 {
+		c.remaining -= int64(len(items))
+		more = more && c.remaining > 0
+	}
+	if !more {
+		if token != "" {
+			e.closeCursor(token)
+		}
+		return items, "", nil
+	}
+	c.last = items[len(items)-1]
+	c.used = time.Now()
+	if token == "" {
+		if token, err = e.openCursor(c); err != nil {
+			return nil, "", err
+		}
+	}
+	return items, token, nil
+}
+
+// openCursor registers the cursor under a new token and returns the token. Cursors that have
+// not been used for cursorTimeout are removed.
+func (e *Executor) openCursor(c *findCursor) (string, error) {
+	token, err := newToken("cursor")
+	if err != nil {
+		return "", err
+	}
+	c.used = time.Now()
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	for other, old := range e.cursors {
+		if time.Since(old.used) > cursorTimeout {
+			delete(e.cursors, other)
+		}
+	}
+	e.cursors[token] = c
+	return token, nil
+}
+
+// checkCursorField returns an error if the table of the query does not exist or, if a field is
+// given, the table has no such field.
+func checkCursorField(db *MDB, query *Query, field string) error {
+	if !db.TableExists(query.Data) {
+		return tableNotFound(query.Data)
+	}
+	if field != "" && !db.FieldExists(query.Data, field) {
+		return fieldNotFound(query.Data, field)
+	}
+	return nil
+}
+
+// fetchValues returns the values of the field of the cursor for the items, or nil if the cursor
+// has no field.
+func fetchValues(db *MDB, c *findCursor, items []Item) (map[Item][]Value, error) {
+	if c.field == "" || len(items) == 0 {
+		return nil, nil
+	}
+	return db.GetMulti(c.query.Data, items, c.field)
+}
@@ -0,0 +1,144 @@
+package minidb
+
+import (
+	"strings"
+	"sync"
+)
+
+// ------------------------------------------------------------------------------
+// Custom field types
+// ------------------------------------------------------------------------------
+
+// FirstCustomType is the smallest code that can be used for a field type defined by RegisterType.
+const FirstCustomType FieldType = 1000
+
+// CustomType describes a field type defined by an application, such as an IP address or a UUID.
+// Values of the type are stored as values of a base type. Set and Get check that the stored
+// values are valid by unmarshalling them.
+type CustomType struct {
+	// Name is used like "int" or "string" in field descriptions and error messages.
+	Name string
+	// Base is the type in which values are stored, one of DBInt, DBString and DBBlob.
+	Base FieldType
+	// Marshal converts a Go value into a value of the base type.
+	Marshal func(x interface{}) (Value, error)
+	// Unmarshal converts a value of the base type into a Go value. It must return an
+	// error if the value does not represent a value of the type.
+	Unmarshal func(v Value) (interface{}, error)
+}
+
+var customTypes = struct {
+	sync.RWMutex
+	byCode map[FieldType]*CustomType
+}{byCode: make(map[FieldType]*CustomType)}
+
+// RegisterType defines a new field type with the given code, which must be at least
+// FirstCustomType. Fields of the type are single fields. The code is stored in the database
+// and in exported schemas, so an application must always register a type with the same code,
+// and must do so before opening a database that uses it.
+func RegisterType(code FieldType, t CustomType) error {
+	if code < FirstCustomType {
+		return Fail("custom type code %d is smaller than %d", code, FirstCustomType)
+	}
+	switch t.Base {
+	case DBInt, DBString, DBBlob:
+	default:
+		return Fail("custom type '%s' must be stored as int, string or blob", t.Name)
+	}
+	if !validFieldName.MatchString(t.Name) {
+		return Fail("invalid custom type name '%s'", t.Name)
+	}
+	if t.Marshal == nil || t.Unmarshal == nil {
+		return Fail("custom type '%s' needs marshal and unmarshal functions", t.Name)
+	}
+	if ft, err := parseFieldType(t.Name); err == nil {
+		return Fail("custom type name '%s' is already used by type %d", t.Name, ft)
+	}
+	customTypes.Lock()
+	defer customTypes.Unlock()
+	if other, ok := customTypes.byCode[code]; ok {
+		return Fail("custom type code %d is already used by type '%s'", code, other.Name)
+	}
+	customTypes.byCode[code] = &t
+	return nil
+}
+
+// lookupCustomType returns the custom type with the given code, or nil if there is none.
+func lookupCustomType(code FieldType) *CustomType {
+	if code < FirstCustomType {
+		return nil
+	}
+	customTypes.RLock()
+	defer customTypes.RUnlock()
+	return customTypes.byCode[code]
+}
+
+// customTypeByName returns the code of the custom type with the given name.
+func customTypeByName(name string) (FieldType, bool) {
+	customTypes.RLock()
+	defer customTypes.RUnlock()
+	for code, t := range customTypes.byCode {
+		if strings.EqualFold(t.Name, name) {
+			return code, true
+		}
+	}
+	return DBError, false
+}
+
+// MarshalValue converts a Go value into a value that can be stored in a field of the custom type.
+func MarshalValue(code FieldType, x interface{}) (Value, error) {
+	t := lookupCustomType(code)
+	if t == nil {
+		return Value{}, Fail("field type %d is not registered", code)
+	}
+	v, err := t.Marshal(x)
+	if err != nil {
+		return Value{}, Fail("cannot marshal %s value: %s", t.Name, err)
+	}
+	if v.Sort != t.Base {
+		return Value{}, Fail("marshalling a %s value returned a %s value, expected %s",
+			t.Name, GetUserTypeString(v.Sort), GetUserTypeString(t.Base))
+	}
+	return v, nil
+}
+
+// UnmarshalValue converts a value stored in a field of the custom type into a Go value.
+func UnmarshalValue(code FieldType, v Value) (interface{}, error) {
+	t := lookupCustomType(code)
+	if t == nil {
+		return nil, Fail("field type %d is not registered", code)
+	}
+	if v.Sort != t.Base {
+		return nil, Fail("type error: expected %s, encountered %s", t.Name, GetUserTypeString(v.Sort))
+	}
+	x, err := t.Unmarshal(v)
+	if err != nil {
+		return nil, Fail("invalid %s value '%s': %s", t.Name, v.String(), err)
+	}
+	return x, nil
+}
+
+// checkCustomValues returns an error if the field type is an unregistered custom type or one of
+// the values, which must be of the base type, cannot be unmarshalled. NULL values are valid.
+func checkCustomValues(t FieldType, data []Value) error {
+	if t < FirstCustomType {
+		return nil
+	}
+	for _, v := range data {
+		if v.Sort == DBNull {
+			continue
+		}
+		if _, err := UnmarshalValue(t, v); err != nil {
+			return err
+		}
+	}
+	if lookupCustomType(t) == nil {
+		return Fail("field type %d is not registered", t)
+	}
+	return nil
+}
+
+// validFieldType returns true for the built-in field types and registered custom types.
+func validFieldType(t FieldType) bool {
+	return (t >= DBInt && t <= DBDateList) || lookupCustomType(t) != nil
+}
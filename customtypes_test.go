@@ -0,0 +1,128 @@
+package minidb
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"net"
+	"os"
+	"strings"
+	"testing"
+)
+
+const testIPType = FirstCustomType
+
+func init() {
+	err := RegisterType(testIPType, CustomType{
+		Name: "ip",
+		Base: DBString,
+		Marshal: func(x interface{}) (Value, error) {
+			ip, ok := x.(net.IP)
+			if !ok {
+				return Value{}, errors.New("not an IP address")
+			}
+			return NewString(ip.String()), nil
+		},
+		Unmarshal: func(v Value) (interface{}, error) {
+			ip := net.ParseIP(v.String())
+			if ip == nil {
+				return nil, errors.New("not an IP address")
+			}
+			return ip, nil
+		},
+	})
+	if err != nil {
+		panic(err)
+	}
+}
+
+func TestCustomTypes(t *testing.T) {
+	noop := func(x interface{}) (Value, error) { return Value{}, nil }
+	unnoop := func(v Value) (interface{}, error) { return nil, nil }
+	for _, c := range []struct {
+		code FieldType
+		t    CustomType
+	}{
+		{testIPType, CustomType{Name: "address", Base: DBString, Marshal: noop, Unmarshal: unnoop}},
+		{DBDate, CustomType{Name: "address", Base: DBString, Marshal: noop, Unmarshal: unnoop}},
+		{testIPType + 1, CustomType{Name: "ip", Base: DBString, Marshal: noop, Unmarshal: unnoop}},
+		{testIPType + 1, CustomType{Name: "string", Base: DBString, Marshal: noop, Unmarshal: unnoop}},
+		{testIPType + 1, CustomType{Name: "address", Base: DBDate, Marshal: noop, Unmarshal: unnoop}},
+		{testIPType + 1, CustomType{Name: "address", Base: DBString}},
+	} {
+		if err := RegisterType(c.code, c.t); err == nil {
+			t.Errorf("RegisterType(%d, %v) should fail", c.code, c.t.Name)
+		}
+	}
+	if ToBaseType(testIPType) != DBString || GetUserTypeString(testIPType) != "ip" {
+		t.Errorf("custom type has base type %d and name %s", ToBaseType(testIPType), GetUserTypeString(testIPType))
+	}
+	fields, err := ParseFieldDesc([]string{"IP", "Address"})
+	if err != nil || fields[0].Sort != testIPType {
+		t.Errorf("ParseFieldDesc() returned %v, %v for a custom type", fields, err)
+	}
+	if _, err := MarshalValue(testIPType, "localhost"); err == nil {
+		t.Errorf("MarshalValue() should fail for an invalid value")
+	}
+	home, err := MarshalValue(testIPType, net.ParseIP("127.0.0.1"))
+	if err != nil || home.String() != "127.0.0.1" || home.Sort != DBString {
+		t.Errorf("MarshalValue() returned %v, %v", home, err)
+	}
+
+	tmp, _ := ioutil.TempFile("", "minidb-custom-testing-*")
+	defer os.Remove(tmp.Name())
+	db, err := Open("sqlite3", tmp.Name())
+	if err != nil {
+		t.Errorf("Open() failed: %s", err)
+	}
+	defer db.Close()
+	if err := db.AddTable("Host", []Field{Field{Name: "Address", Sort: testIPType}}); err != nil {
+		t.Errorf("AddTable() failed: %s", err)
+	}
+	if err := db.AddTable("Bad", []Field{Field{Name: "Address", Sort: testIPType + 1}}); err == nil {
+		t.Errorf("AddTable() should fail for an unregistered type")
+	}
+	item, _ := db.NewItem("Host")
+	tx, _ := db.Begin()
+	if err := tx.Set("Host", item, "Address", []Value{NewString("localhost")}); err == nil {
+		t.Errorf("Set() should fail for an invalid value of a custom type")
+	}
+	if err := tx.Set("Host", item, "Address", []Value{home}); err != nil {
+		t.Errorf("Set() failed: %s", err)
+	}
+	if _, err := tx.NewItems("Host", [][]FieldValue{{{Field: "Address", Values: []Value{NewString("x")}}}}); err == nil {
+		t.Errorf("NewItems() should fail for an invalid value of a custom type")
+	}
+	tx.Commit()
+	values, err := db.Get("Host", item, "Address")
+	if err != nil || len(values) != 1 {
+		t.Errorf("Get() failed: %v, %v", values, err)
+	}
+	ip, err := UnmarshalValue(testIPType, values[0])
+	if err != nil || !ip.(net.IP).Equal(net.ParseIP("127.0.0.1")) {
+		t.Errorf("UnmarshalValue() returned %v, %v", ip, err)
+	}
+	db.base.Exec(`UPDATE Host SET Address='garbage';`)
+	if _, err := db.Get("Host", item, "Address"); err == nil {
+		t.Errorf("Get() should fail for an invalid stored value")
+	}
+
+	exported, err := db.ExportSchema()
+	if err != nil || !strings.Contains(string(exported), `"name": "ip"`) {
+		t.Errorf("ExportSchema() did not include the custom type: %s %v", exported, err)
+	}
+	tmp2, _ := ioutil.TempFile("", "minidb-custom-testing-*")
+	defer os.Remove(tmp2.Name())
+	db2, _ := Open("sqlite3", tmp2.Name())
+	defer db2.Close()
+	if err := db2.ImportSchema(bytes.NewReader(exported)); err != nil {
+		t.Errorf("ImportSchema() failed: %s", err)
+	}
+	if db2.MustGetFieldType("Host", "Address") != testIPType {
+		t.Errorf("ImportSchema() did not preserve the custom type")
+	}
+	other := strings.Replace(string(exported), `"name": "ip"`, `"name": "uuid"`, 1)
+	if err := db2.ImportSchema(strings.NewReader(other)); err == nil {
+		t.Errorf("ImportSchema() should fail for a custom type registered under another name")
+	}
+}
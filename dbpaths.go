@@ -0,0 +1,124 @@
+package minidb
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ------------------------------------------------------------------------------
+// Database files that clients may open
+// ------------------------------------------------------------------------------
+
+// SetAllowedDirs restricts Open commands to database files in the directories or their
+// subdirectories, and Backup commands to destinations there, so that clients of a server cannot
+// open or write arbitrary files of the host. Symbolic links are resolved, both in the directories
+// and in the paths of the files, and names that are not plain paths, such as file: URIs, are
+// refused. Refused commands fail with ErrNotPermitted and a result whose Err wraps
+// ErrPathNotAllowed. Calling it without directories lifts the restriction. An error is returned
+// if a directory does not exist.
+func (e *Executor) SetAllowedDirs(dirs ...string) error {
+	resolved := make([]string, 0, len(dirs))
+	for _, dir := range dirs {
+		path, err := resolvePath(dir)
+		if err != nil {
+			return Fail("invalid database directory '%s': %w", dir, err)
+		}
+		resolved = append(resolved, path)
+	}
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.allowedDirs = resolved
+	return nil
+}
+
+// SetAllowCreate lets Open commands create database files that do not exist yet if on is true,
+// which is the default. Otherwise, only existing databases can be opened.
+func (e *Executor) SetAllowCreate(on bool) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.noCreate = !on
+}
+
+// checkOpenPath returns an error if an Open command may not open the database file.
+func (e *Executor) checkOpenPath(name string) error {
+	e.mutex.RLock()
+	dirs := e.allowedDirs
+	noCreate := e.noCreate
+	e.mutex.RUnlock()
+	if len(dirs) == 0 && !noCreate {
+		return nil
+	}
+	if strings.HasPrefix(name, "file:") || name == ":memory:" || name == "" {
+		return Fail("exec failed: cannot open '%s': %w", name, ErrPathNotAllowed)
+	}
+	_, err := os.Stat(name)
+	exists := err == nil
+	if noCreate && !exists {
+		return Fail("exec failed: cannot open '%s', new databases may not be created: %w", name, ErrPathNotAllowed)
+	}
+	if len(dirs) == 0 || inAllowedDir(dirs, name, exists) {
+		return nil
+	}
+	return Fail("exec failed: cannot open '%s', it is not in an allowed directory: %w", name, ErrPathNotAllowed)
+}
+
+// checkWritePath returns an error if a command may not write the file, such as the destination
+// of a Backup command, because it is not in one of the allowed directories.
+func (e *Executor) checkWritePath(name string) error {
+	e.mutex.RLock()
+	dirs := e.allowedDirs
+	e.mutex.RUnlock()
+	if len(dirs) == 0 {
+		return nil
+	}
+	if strings.HasPrefix(name, "file:") || name == ":memory:" || name == "" {
+		return Fail("exec failed: cannot write '%s': %w", name, ErrPathNotAllowed)
+	}
+	_, err := os.Stat(name)
+	if inAllowedDir(dirs, name, err == nil) {
+		return nil
+	}
+	return Fail("exec failed: cannot write '%s', it is not in an allowed directory: %w", name, ErrPathNotAllowed)
+}
+
+// inAllowedDir returns true if the file is in one of the directories or their subdirectories.
+// If it does not exist yet, its directory must.
+func inAllowedDir(dirs []string, name string, exists bool) bool {
+	var path string
+	var err error
+	if exists {
+		path, err = resolvePath(name)
+	} else {
+		if path, err = resolvePath(filepath.Dir(name)); err == nil {
+			path = filepath.Join(path, filepath.Base(name))
+		}
+	}
+	if err != nil {
+		return false
+	}
+	for _, dir := range dirs {
+		if within(dir, path) {
+			return true
+		}
+	}
+	return false
+}
+
+// resolvePath returns the absolute path of the existing file or directory with all symbolic
+// links resolved.
+func resolvePath(name string) (string, error) {
+	path, err := filepath.Abs(name)
+	if err != nil {
+		return "", err
+	}
+	return filepath.EvalSymlinks(path)
+}
+
+// within returns true if the path is in the directory or one of its subdirectories. Both must be
+// absolute and clean.
+func within(dir, path string) bool {
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) &&
+		!filepath.IsAbs(rel)
+}
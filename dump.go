@@ -0,0 +1,115 @@
+package minidb
+
+import (
+	"bufio"
+	"database/sql"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// ------------------------------------------------------------------------------
+// SQL dump
+// ------------------------------------------------------------------------------
+
+// Dump writes the database as SQL statements to w, in the same form as the .dump command of
+// the sqlite3 shell: a CREATE statement for every table, index, view and trigger, and an INSERT
+// statement for every row. The internal tables of minidb, such as _TABLES and _COLS, are dumped
+// like the others, so running the statements with the sqlite3 shell, e.g. sqlite3 new.db <
+// dump.sql, rebuilds a database that can be opened with Open. The statistics of the query
+// planner are not dumped, see Compact. The rows are read in a read-only transaction, see
+// BeginRead, so the dump reflects one state of the database.
+func (db *MDB) Dump(w io.Writer) error {
+	view := db
+	if db.tx == nil {
+		tx, err := db.BeginRead()
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback()
+		view = tx.View()
+	}
+	type object struct {
+		kind, name, sql string
+	}
+	rows, err := view.reader.Query(`SELECT type,name,sql FROM sqlite_master
+		WHERE sql IS NOT NULL AND name NOT LIKE 'sqlite\_%' ESCAPE '\' ORDER BY type<>'table',rowid;`)
+	if err != nil {
+		return Fail("cannot read schema: %s", err)
+	}
+	var objects []object
+	for rows.Next() {
+		var o object
+		if err := rows.Scan(&o.kind, &o.name, &o.sql); err != nil {
+			rows.Close()
+			return Fail("cannot read schema: %s", err)
+		}
+		objects = append(objects, o)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return Fail("cannot read schema: %s", err)
+	}
+	out := bufio.NewWriter(w)
+	out.WriteString("PRAGMA foreign_keys=OFF;\nBEGIN TRANSACTION;\n")
+	for _, o := range objects {
+		out.WriteString(o.sql + ";\n")
+		if o.kind == "table" {
+			if err := view.dumpRows(out, o.name); err != nil {
+				return err
+			}
+		}
+	}
+	out.WriteString("COMMIT;\n")
+	return out.Flush()
+}
+
+// dumpRows writes an INSERT statement for every row of the table. The values are quoted by
+// SQLite, so they are written exactly as they are stored.
+func (db *MDB) dumpRows(w *bufio.Writer, table string) error {
+	columns, err := db.reader.Query(fmt.Sprintf(`SELECT name FROM pragma_table_info('%s');`,
+		strings.Replace(table, "'", "''", -1)))
+	if err != nil {
+		return Fail("cannot read columns of table '%s': %s", table, err)
+	}
+	var quoted []string
+	for columns.Next() {
+		var name string
+		if err := columns.Scan(&name); err != nil {
+			columns.Close()
+			return Fail("cannot read columns of table '%s': %s", table, err)
+		}
+		quoted = append(quoted, `quote("`+strings.Replace(name, `"`, `""`, -1)+`")`)
+	}
+	columns.Close()
+	if len(quoted) == 0 {
+		return nil
+	}
+	name := `"` + strings.Replace(table, `"`, `""`, -1) + `"`
+	rows, err := db.reader.Query(fmt.Sprintf(`SELECT %s FROM %s;`, strings.Join(quoted, ","), name))
+	if err != nil {
+		return Fail("cannot read table '%s': %s", table, err)
+	}
+	defer rows.Close()
+	values := make([]sql.NullString, len(quoted))
+	dest := make([]interface{}, len(values))
+	for i := range values {
+		dest[i] = &values[i]
+	}
+	literals := make([]string, len(values))
+	for rows.Next() {
+		if err := rows.Scan(dest...); err != nil {
+			return Fail("cannot read table '%s': %s", table, err)
+		}
+		for i, v := range values {
+			literals[i] = v.String
+		}
+		if _, err := w.WriteString("INSERT INTO " + name + " VALUES(" + strings.Join(literals, ",") + ");\n"); err != nil {
+			return err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return Fail("cannot read table '%s': %s", table, err)
+	}
+	return nil
+}
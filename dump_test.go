@@ -0,0 +1,65 @@
+package minidb
+
+import (
+	"bytes"
+	"database/sql"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDump(t *testing.T) {
+	db, err := Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatalf("Open() failed: %s", err)
+	}
+	defer db.Close()
+	err = db.AddTable("Person", []Field{{Name: "Name", Sort: DBString}, {Name: "Photo", Sort: DBBlob},
+		{Name: "Tags", Sort: DBStringList}})
+	if err != nil {
+		t.Fatalf("AddTable() failed: %s", err)
+	}
+	item, _ := db.NewItem("Person")
+	tx, _ := db.Begin()
+	err = tx.SetItem("Person", item, map[string][]Value{"Name": {NewString("O'Brien")},
+		"Photo": {NewBytes([]byte{0, 255})}, "Tags": {NewString("a"), NewString("b")}})
+	if err != nil {
+		t.Fatalf("SetItem() failed: %s", err)
+	}
+	tx.Commit()
+
+	var buff bytes.Buffer
+	if err := db.Dump(&buff); err != nil {
+		t.Fatalf("Dump() failed: %s", err)
+	}
+	dump := buff.String()
+	for _, s := range []string{"BEGIN TRANSACTION;\n", "CREATE TABLE", `INSERT INTO "_TABLES"`, `INSERT INTO "_COLS"`,
+		`'O''Brien'`, `X'00FF'`, "COMMIT;\n"} {
+		if !strings.Contains(dump, s) {
+			t.Errorf("Dump() should contain %q:\n%s", s, dump)
+		}
+	}
+
+	file := filepath.Join(t.TempDir(), "restored.sqlite")
+	base, err := sql.Open("sqlite3", file)
+	if err != nil {
+		t.Fatalf("sql.Open() failed: %s", err)
+	}
+	if _, err := base.Exec(dump); err != nil {
+		t.Fatalf("executing the dump failed: %s", err)
+	}
+	base.Close()
+	restored, err := Open("sqlite3", file)
+	if err != nil {
+		t.Fatalf("Open() of the restored database failed: %s", err)
+	}
+	defer restored.Close()
+	values, err := restored.GetItem("Person", item)
+	if err != nil {
+		t.Fatalf("GetItem() failed: %s", err)
+	}
+	if values["Name"][0].Str != "O'Brien" || !bytes.Equal(values["Photo"][0].Bytes(), []byte{0, 255}) ||
+		len(values["Tags"]) != 2 {
+		t.Errorf("the restored database contains wrong values: %v", values)
+	}
+}
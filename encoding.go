@@ -0,0 +1,588 @@
+package minidb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"sort"
+)
+
+// ------------------------------------------------------------------------------
+// Encoding of commands and results exchanged by clients and servers
+// ------------------------------------------------------------------------------
+
+// Encoding is the encoding of the messages exchanged by clients and servers.
+type Encoding int
+
+const (
+	// EncodingJSON encodes messages as JSON documents. Blobs are Base64 encoded.
+	EncodingJSON Encoding = iota
+	// EncodingBinary encodes messages in a compact binary format that stores numbers as varints
+	// and strings and blobs as they are, which makes messages with many blobs considerably
+	// smaller than their JSON encoding.
+	EncodingBinary
+)
+
+// binaryMarker is the first byte of binary messages, which no JSON document starts with.
+// It is followed by one of the message kinds below.
+const binaryMarker = 0
+
+// Kinds of binary messages.
+const (
+	binaryCommand byte = iota + 1
+	binaryBatch
+	binaryResult
+	binaryResults
+)
+
+// binaryWriter appends the binary encoding of values to a buffer. Slices and maps are written
+// with their length plus 1, so that 0 stands for nil and nil slices are decoded as nil.
+type binaryWriter struct {
+	buf []byte
+}
+
+func (w *binaryWriter) uint(n uint64) {
+	var data [binary.MaxVarintLen64]byte
+	w.buf = append(w.buf, data[:binary.PutUvarint(data[:], n)]...)
+}
+
+func (w *binaryWriter) int(n int64) {
+	var data [binary.MaxVarintLen64]byte
+	w.buf = append(w.buf, data[:binary.PutVarint(data[:], n)]...)
+}
+
+func (w *binaryWriter) bool(b bool) {
+	if b {
+		w.buf = append(w.buf, 1)
+	} else {
+		w.buf = append(w.buf, 0)
+	}
+}
+
+func (w *binaryWriter) string(s string) {
+	w.uint(uint64(len(s)))
+	w.buf = append(w.buf, s...)
+}
+
+// length writes the length of a slice or map, which is nil if isNil is true.
+func (w *binaryWriter) length(n int, isNil bool) {
+	if isNil {
+		w.uint(0)
+		return
+	}
+	w.uint(uint64(n) + 1)
+}
+
+func (w *binaryWriter) bytes(b []byte) {
+	w.length(len(b), b == nil)
+	w.buf = append(w.buf, b...)
+}
+
+func (w *binaryWriter) strings(list []string) {
+	w.length(len(list), list == nil)
+	for _, s := range list {
+		w.string(s)
+	}
+}
+
+func (w *binaryWriter) items(list []Item) {
+	w.length(len(list), list == nil)
+	for _, item := range list {
+		w.int(int64(item))
+	}
+}
+
+func (w *binaryWriter) ints(list []int64) {
+	w.length(len(list), list == nil)
+	for _, n := range list {
+		w.int(n)
+	}
+}
+
+func (w *binaryWriter) value(v *Value) {
+	w.string(v.Str)
+	w.int(v.Num)
+	w.int(int64(v.Sort))
+}
+
+func (w *binaryWriter) values(list []Value) {
+	w.length(len(list), list == nil)
+	for i := range list {
+		w.value(&list[i])
+	}
+}
+
+func (w *binaryWriter) fields(list []Field) {
+	w.length(len(list), list == nil)
+	for _, field := range list {
+		w.string(field.Name)
+		w.int(int64(field.Sort))
+		w.bool(field.Required)
+		w.bool(field.Default != nil)
+		if field.Default != nil {
+			w.value(field.Default)
+		}
+		w.int(int64(field.Access))
+	}
+}
+
+func (w *binaryWriter) query(q *Query) {
+	w.int(int64(q.Sort))
+	w.string(q.Data)
+	w.length(len(q.Children), q.Children == nil)
+	for i := range q.Children {
+		w.query(&q.Children[i])
+	}
+}
+
+// valueMap writes a map of values in the order of its keys.
+func (w *binaryWriter) valueMap(m map[string][]Value) {
+	w.length(len(m), m == nil)
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		w.string(key)
+		w.values(m[key])
+	}
+}
+
+func (w *binaryWriter) command(cmd *Command) {
+	w.int(int64(cmd.ID))
+	w.string(string(cmd.DB))
+	w.int(int64(cmd.Tx))
+	w.strings(cmd.StrArgs)
+	w.int(int64(cmd.ItemArg))
+	w.items(cmd.ItemArgs)
+	w.fields(cmd.FieldArgs)
+	w.values(cmd.ValueArgs)
+	w.query(&cmd.QueryArg)
+	w.int(cmd.IntArg)
+	w.int(cmd.IntArg2)
+	w.valueMap(cmd.ValueMap)
+	w.int(int64(cmd.Version))
+}
+
+func (w *binaryWriter) result(r *Result) {
+	w.string(r.Str)
+	w.strings(r.Strings)
+	w.int(r.Int)
+	w.bool(r.Bool)
+	w.items(r.Items)
+	w.values(r.Values)
+	w.fields(r.Fields)
+	w.bytes(r.Bytes)
+	w.ints(r.Ints)
+	w.length(len(r.Links), r.Links == nil)
+	for _, link := range r.Links {
+		w.string(link.Table)
+		w.int(int64(link.Item))
+		w.string(link.Relation)
+	}
+	w.valueMap(r.Item)
+	w.length(len(r.ItemValues), r.ItemValues == nil)
+	items := make([]Item, 0, len(r.ItemValues))
+	for item := range r.ItemValues {
+		items = append(items, item)
+	}
+	sort.Slice(items, func(i, j int) bool { return items[i] < items[j] })
+	for _, item := range items {
+		w.int(int64(item))
+		w.values(r.ItemValues[item])
+	}
+	w.length(len(r.KVEntries), r.KVEntries == nil)
+	for i := range r.KVEntries {
+		entry := &r.KVEntries[i]
+		w.string(entry.Store)
+		w.string(entry.Bucket)
+		w.int(entry.Key)
+		w.string(entry.StrKey)
+		w.value(&entry.Value)
+	}
+	w.bool(r.HasError)
+	w.int(int64(r.Version))
+	w.int(r.Cause)
+}
+
+// binaryReader reads values written by a binaryWriter. After the first error, it reads zero
+// values and keeps the error.
+type binaryReader struct {
+	buf []byte
+	err error
+}
+
+func (r *binaryReader) fail() {
+	if r.err == nil {
+		r.err = Fail("cannot decode message: unexpected end of data")
+	}
+	r.buf = nil
+}
+
+func (r *binaryReader) uint() uint64 {
+	n, size := binary.Uvarint(r.buf)
+	if size <= 0 {
+		r.fail()
+		return 0
+	}
+	r.buf = r.buf[size:]
+	return n
+}
+
+func (r *binaryReader) int() int64 {
+	n, size := binary.Varint(r.buf)
+	if size <= 0 {
+		r.fail()
+		return 0
+	}
+	r.buf = r.buf[size:]
+	return n
+}
+
+func (r *binaryReader) bool() bool {
+	if len(r.buf) == 0 {
+		r.fail()
+		return false
+	}
+	b := r.buf[0] != 0
+	r.buf = r.buf[1:]
+	return b
+}
+
+// data returns the next n bytes.
+func (r *binaryReader) data(n uint64) []byte {
+	if n > uint64(len(r.buf)) {
+		r.fail()
+		return nil
+	}
+	b := r.buf[:n]
+	r.buf = r.buf[n:]
+	return b
+}
+
+func (r *binaryReader) string() string {
+	return string(r.data(r.uint()))
+}
+
+// length reads the length of a slice or map and returns false if it is nil. Lengths larger
+// than the rest of the data cannot be valid, since every element takes at least one byte.
+func (r *binaryReader) length() (int, bool) {
+	n := r.uint()
+	if n == 0 || r.err != nil {
+		return 0, false
+	}
+	if n-1 > uint64(len(r.buf)) {
+		r.fail()
+		return 0, false
+	}
+	return int(n - 1), true
+}
+
+func (r *binaryReader) bytes() []byte {
+	n, ok := r.length()
+	if !ok {
+		return nil
+	}
+	return append([]byte{}, r.data(uint64(n))...)
+}
+
+func (r *binaryReader) strings() []string {
+	n, ok := r.length()
+	if !ok {
+		return nil
+	}
+	list := make([]string, n)
+	for i := range list {
+		list[i] = r.string()
+	}
+	return list
+}
+
+func (r *binaryReader) items() []Item {
+	n, ok := r.length()
+	if !ok {
+		return nil
+	}
+	list := make([]Item, n)
+	for i := range list {
+		list[i] = Item(r.int())
+	}
+	return list
+}
+
+func (r *binaryReader) ints() []int64 {
+	n, ok := r.length()
+	if !ok {
+		return nil
+	}
+	list := make([]int64, n)
+	for i := range list {
+		list[i] = r.int()
+	}
+	return list
+}
+
+func (r *binaryReader) value() Value {
+	return Value{Str: r.string(), Num: r.int(), Sort: FieldType(r.int())}
+}
+
+func (r *binaryReader) values() []Value {
+	n, ok := r.length()
+	if !ok {
+		return nil
+	}
+	list := make([]Value, n)
+	for i := range list {
+		list[i] = r.value()
+	}
+	return list
+}
+
+func (r *binaryReader) fields() []Field {
+	n, ok := r.length()
+	if !ok {
+		return nil
+	}
+	list := make([]Field, n)
+	for i := range list {
+		list[i] = Field{Name: r.string(), Sort: FieldType(r.int()), Required: r.bool()}
+		if r.bool() {
+			v := r.value()
+			list[i].Default = &v
+		}
+		list[i].Access = FieldAccess(r.int())
+	}
+	return list
+}
+
+func (r *binaryReader) query() Query {
+	q := Query{Sort: QuerySort(r.int()), Data: r.string()}
+	if n, ok := r.length(); ok {
+		q.Children = make([]Query, n)
+		for i := range q.Children {
+			q.Children[i] = r.query()
+		}
+	}
+	return q
+}
+
+func (r *binaryReader) valueMap() map[string][]Value {
+	n, ok := r.length()
+	if !ok {
+		return nil
+	}
+	m := make(map[string][]Value, n)
+	for i := 0; i < n; i++ {
+		key := r.string()
+		m[key] = r.values()
+	}
+	return m
+}
+
+func (r *binaryReader) command() Command {
+	return Command{
+		ID:        CommandID(r.int()),
+		DB:        CommandDB(r.string()),
+		Tx:        TxID(r.int()),
+		StrArgs:   r.strings(),
+		ItemArg:   Item(r.int()),
+		ItemArgs:  r.items(),
+		FieldArgs: r.fields(),
+		ValueArgs: r.values(),
+		QueryArg:  r.query(),
+		IntArg:    r.int(),
+		IntArg2:   r.int(),
+		ValueMap:  r.valueMap(),
+		Version:   int(r.int()),
+	}
+}
+
+func (r *binaryReader) result() Result {
+	result := Result{
+		Str:     r.string(),
+		Strings: r.strings(),
+		Int:     r.int(),
+		Bool:    r.bool(),
+		Items:   r.items(),
+		Values:  r.values(),
+		Fields:  r.fields(),
+		Bytes:   r.bytes(),
+		Ints:    r.ints(),
+	}
+	if n, ok := r.length(); ok {
+		result.Links = make([]Link, n)
+		for i := range result.Links {
+			result.Links[i] = Link{Table: r.string(), Item: Item(r.int()), Relation: r.string()}
+		}
+	}
+	result.Item = r.valueMap()
+	if n, ok := r.length(); ok {
+		result.ItemValues = make(map[Item][]Value, n)
+		for i := 0; i < n; i++ {
+			item := Item(r.int())
+			result.ItemValues[item] = r.values()
+		}
+	}
+	if n, ok := r.length(); ok {
+		result.KVEntries = make([]KVEntry, n)
+		for i := range result.KVEntries {
+			result.KVEntries[i] = KVEntry{Store: r.string(), Bucket: r.string(), Key: r.int(),
+				StrKey: r.string(), Value: r.value()}
+		}
+	}
+	result.HasError = r.bool()
+	result.Version = int(r.int())
+	result.Cause = r.int()
+	return result
+}
+
+// MarshalBinary returns the binary encoding of the command.
+func (cmd *Command) MarshalBinary() ([]byte, error) {
+	var w binaryWriter
+	w.command(cmd)
+	return w.buf, nil
+}
+
+// UnmarshalBinary sets the command to the command in the binary encoding returned by MarshalBinary.
+func (cmd *Command) UnmarshalBinary(data []byte) error {
+	r := binaryReader{buf: data}
+	decoded := r.command()
+	if r.err != nil {
+		return r.err
+	}
+	*cmd = decoded
+	return nil
+}
+
+// MarshalBinary returns the binary encoding of the result.
+func (r *Result) MarshalBinary() ([]byte, error) {
+	var w binaryWriter
+	w.result(r)
+	return w.buf, nil
+}
+
+// UnmarshalBinary sets the result to the result in the binary encoding returned by MarshalBinary.
+func (r *Result) UnmarshalBinary(data []byte) error {
+	reader := binaryReader{buf: data}
+	decoded := reader.result()
+	if reader.err != nil {
+		return reader.err
+	}
+	*r = decoded
+	return nil
+}
+
+// EncodeRequest returns the message that sends the command, or the batch if it is not nil, to
+// a server in the given encoding.
+func EncodeRequest(cmd *Command, batch *Batch, enc Encoding) ([]byte, error) {
+	if enc == EncodingJSON {
+		if batch != nil {
+			return json.Marshal(batch)
+		}
+		return json.Marshal(cmd)
+	}
+	if batch == nil {
+		w := binaryWriter{buf: []byte{binaryMarker, binaryCommand}}
+		w.command(cmd)
+		return w.buf, nil
+	}
+	w := binaryWriter{buf: []byte{binaryMarker, binaryBatch}}
+	w.bool(batch.Atomic)
+	w.length(len(batch.Commands), batch.Commands == nil)
+	for i := range batch.Commands {
+		w.command(&batch.Commands[i])
+	}
+	return w.buf, nil
+}
+
+// DecodeRequest decodes a message of a client, which holds either a command or a batch, and
+// returns the encoding of the message, in which the server should reply, see EncodeReply.
+func DecodeRequest(msg []byte) (*Command, *Batch, Encoding, error) {
+	if len(msg) < 2 || msg[0] != binaryMarker {
+		var req struct {
+			Command
+			Batch
+		}
+		if err := json.Unmarshal(msg, &req); err != nil {
+			return nil, nil, EncodingJSON, Fail("cannot decode message: %s", err)
+		}
+		if req.Commands != nil {
+			return nil, &req.Batch, EncodingJSON, nil
+		}
+		return &req.Command, nil, EncodingJSON, nil
+	}
+	r := binaryReader{buf: msg[2:]}
+	switch msg[1] {
+	case binaryCommand:
+		cmd := r.command()
+		return &cmd, nil, EncodingBinary, r.err
+	case binaryBatch:
+		batch := Batch{Atomic: r.bool()}
+		if n, ok := r.length(); ok {
+			batch.Commands = make([]Command, n)
+			for i := range batch.Commands {
+				batch.Commands[i] = r.command()
+			}
+		}
+		return nil, &batch, EncodingBinary, r.err
+	}
+	return nil, nil, EncodingBinary, Fail("cannot decode message: unknown message kind %d", msg[1])
+}
+
+// EncodeReply returns the message that sends the result of a command, or the results of a
+// batch if result is nil, to a client in the given encoding.
+func EncodeReply(result *Result, results []Result, enc Encoding) ([]byte, error) {
+	if enc == EncodingJSON {
+		if result == nil {
+			return json.Marshal(results)
+		}
+		return json.Marshal(result)
+	}
+	if result != nil {
+		w := binaryWriter{buf: []byte{binaryMarker, binaryResult}}
+		w.result(result)
+		return w.buf, nil
+	}
+	w := binaryWriter{buf: []byte{binaryMarker, binaryResults}}
+	w.length(len(results), results == nil)
+	for i := range results {
+		w.result(&results[i])
+	}
+	return w.buf, nil
+}
+
+// DecodeReply decodes a message of a server written by EncodeReply. It returns the result of
+// a command, or nil and the results of a batch.
+func DecodeReply(msg []byte) (*Result, []Result, error) {
+	if len(msg) < 2 || msg[0] != binaryMarker {
+		if trimmed := bytes.TrimSpace(msg); len(trimmed) > 0 && trimmed[0] == '[' {
+			results := make([]Result, 0)
+			if err := json.Unmarshal(msg, &results); err != nil {
+				return nil, nil, Fail("cannot decode message: %s", err)
+			}
+			return nil, results, nil
+		}
+		var result Result
+		if err := json.Unmarshal(msg, &result); err != nil {
+			return nil, nil, Fail("cannot decode message: %s", err)
+		}
+		return &result, nil, nil
+	}
+	r := binaryReader{buf: msg[2:]}
+	switch msg[1] {
+	case binaryResult:
+		result := r.result()
+		return &result, nil, r.err
+	case binaryResults:
+		results := make([]Result, 0)
+		if n, ok := r.length(); ok {
+			results = make([]Result, n)
+			for i := range results {
+				results[i] = r.result()
+			}
+		}
+		return nil, results, r.err
+	}
+	return nil, nil, Fail("cannot decode message: unknown message kind %d", msg[1])
+}
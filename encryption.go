@@ -0,0 +1,127 @@
+package minidb
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// ------------------------------------------------------------------------------
+// Encryption with SQLCipher, see Options.EncryptionKey
+// ------------------------------------------------------------------------------
+
+// ErrEncryptionUnsupported is returned when a database is opened with an encryption key but the
+// sqlite3 driver has not been built with SQLCipher, which would silently ignore the key.
+var ErrEncryptionUnsupported = Fail("the sqlite3 driver does not support encryption, it must be built with SQLCipher")
+
+// maxIdleConns is the number of idle connections database/sql keeps by default.
+const maxIdleConns = 2
+
+// quoteKey returns the key as an SQL string literal for PRAGMA key and PRAGMA rekey.
+func quoteKey(key string) string {
+	return "'" + strings.Replace(key, "'", "''", -1) + "'"
+}
+
+// applyKey sets the encryption key of a new connection and checks that it opens the database.
+// Errors never contain the key.
+func applyKey(conn driver.Conn, key string) error {
+	if err := execPragma(conn, "PRAGMA key="+quoteKey(key)+";"); err != nil {
+		return Fail("cannot apply encryption key: %s", err)
+	}
+	// other builds of SQLite ignore unknown pragmas
+	version, err := queryPragma(conn, "PRAGMA cipher_version;")
+	if err != nil || version == "" {
+		return ErrEncryptionUnsupported
+	}
+	// SQLCipher only notices a wrong key when the database is read
+	if _, err := queryPragma(conn, "SELECT count(*) FROM sqlite_master;"); err != nil {
+		return Fail("cannot open encrypted database, the key may be wrong: %s", err)
+	}
+	return nil
+}
+
+// queryPragma executes a statement on a connection of a driver and returns the first column of
+// the first row as a string, or the empty string if there is no row.
+func queryPragma(conn driver.Conn, query string) (string, error) {
+	stmt, err := conn.Prepare(query)
+	if err != nil {
+		return "", err
+	}
+	defer stmt.Close()
+	rows, err := stmt.Query(nil)
+	if err != nil {
+		return "", err
+	}
+	defer rows.Close()
+	dest := make([]driver.Value, len(rows.Columns()))
+	if err := rows.Next(dest); err == io.EOF || len(dest) == 0 {
+		return "", nil
+	} else if err != nil {
+		return "", err
+	}
+	if b, ok := dest[0].([]byte); ok {
+		return string(b), nil
+	}
+	return fmt.Sprint(dest[0]), nil
+}
+
+// openWithKey opens another database file with the sqlite3 driver and the encryption key of
+// the database, if it is encrypted, so that backups can be copied to and from it.
+func (db *MDB) openWithKey(file string) (*sql.DB, error) {
+	key := ""
+	if db.connector != nil {
+		key = db.connector.currentKey()
+	}
+	other, _, err := openWithPragmas("sqlite3", file, nil, busyRetry{}, key)
+	return other, err
+}
+
+// Encrypted returns true if the database has been opened with an encryption key.
+func (db *MDB) Encrypted() bool {
+	return db.connector != nil && db.connector.currentKey() != ""
+}
+
+// Rekey changes the encryption key of a database opened with Options.EncryptionKey, which
+// encrypts the whole database file anew, and uses the new key for all connections opened later.
+// It waits for the transaction in progress like Begin. Read-only transactions must be finished
+// before, since their connections can no longer read the database afterwards.
+func (db *MDB) Rekey(newKey string) error {
+	if db.base == nil || db.globalLock == nil {
+		return Fail("cannot change the key of a closed database")
+	}
+	if db.tx != nil {
+		return Fail("cannot change the key in a view of a transaction")
+	}
+	if !db.Encrypted() {
+		return Fail("the database is not encrypted, it must be opened with an encryption key")
+	}
+	if newKey == "" {
+		return Fail("the new encryption key must not be empty")
+	}
+	if err := db.waitForTx(); err != nil {
+		return err
+	}
+	defer func() { <-db.txSlot }()
+	if err := db.initIfPending(); err != nil {
+		return err
+	}
+	ctx := context.Background()
+	conn, err := db.base.Conn(ctx)
+	if err != nil {
+		return Fail("cannot change encryption key: %s", err)
+	}
+	_, err = conn.ExecContext(ctx, "PRAGMA rekey="+quoteKey(newKey)+";")
+	conn.Close()
+	if err != nil {
+		return Fail("cannot change encryption key: %s", err)
+	}
+	db.connector.setKey(newKey)
+	db.options.EncryptionKey = newKey
+	// the idle connections still use the old key
+	db.base.SetMaxIdleConns(0)
+	db.base.SetMaxIdleConns(maxIdleConns)
+	return nil
+}
@@ -0,0 +1,60 @@
+package minidb
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestEncryption(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "encrypted.sqlite")
+	if _, err := OpenWithOptions("sqlite3", ":memory:", &Options{EncryptionKey: "secret"}); err == nil {
+		t.Errorf("OpenWithOptions() should fail for an encrypted in-memory database")
+	}
+	plain, err := Open("sqlite3", filepath.Join(dir, "plain.sqlite"))
+	if err != nil {
+		t.Fatalf("Open() failed: %s", err)
+	}
+	defer plain.Close()
+	if plain.Encrypted() {
+		t.Errorf("Encrypted() should be false without key")
+	}
+	if err := plain.Rekey("other"); err == nil {
+		t.Errorf("Rekey() should fail for a database that is not encrypted")
+	}
+
+	db, err := OpenWithOptions("sqlite3", file, &Options{EncryptionKey: "secret"})
+	if err == ErrEncryptionUnsupported {
+		t.Skip("the sqlite3 driver has not been built with SQLCipher")
+	}
+	if err != nil {
+		t.Fatalf("OpenWithOptions() failed: %s", err)
+	}
+	if !db.Encrypted() {
+		t.Errorf("Encrypted() should be true with key")
+	}
+	if err := db.AddTable("Person", []Field{{Name: "Name", Sort: DBString}}); err != nil {
+		t.Fatalf("AddTable() failed: %s", err)
+	}
+	if err := db.Rekey("new secret"); err != nil {
+		t.Fatalf("Rekey() failed: %s", err)
+	}
+	if !db.TableExists("Person") {
+		t.Errorf("the database cannot be read after Rekey()")
+	}
+	db.Close()
+	if _, err := OpenWithOptions("sqlite3", file, &Options{EncryptionKey: "secret"}); err == nil {
+		t.Errorf("OpenWithOptions() should fail with the old key")
+	}
+	if _, err := Open("sqlite3", file); err == nil {
+		t.Errorf("Open() should fail without key")
+	}
+	db, err = OpenWithOptions("sqlite3", file, &Options{EncryptionKey: "new secret"})
+	if err != nil {
+		t.Fatalf("OpenWithOptions() with the new key failed: %s", err)
+	}
+	defer db.Close()
+	if !db.TableExists("Person") {
+		t.Errorf("the table is missing after reopening")
+	}
+}
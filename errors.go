@@ -0,0 +1,157 @@
+package minidb
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ------------------------------------------------------------------------------
+// Typed errors
+// ------------------------------------------------------------------------------
+
+// Errors that can be tested with errors.Is. They are wrapped by the errors returned by the
+// package, which describe the table, field, or item concerned, see NotFoundError and
+// TypeMismatchError.
+var (
+	ErrTableNotFound = errors.New("table not found")
+	ErrFieldNotFound = errors.New("field not found")
+	ErrItemNotFound  = errors.New("item not found")
+	ErrTypeMismatch  = errors.New("type mismatch")
+	// ErrReadOnlyExecutor is the cause of commands rejected by a read-only executor, see
+	// Executor.SetReadOnly.
+	ErrReadOnlyExecutor = errors.New("the executor is read-only")
+	// ErrUnauthenticated is the cause of commands refused on connections that have not been
+	// authenticated, see Executor.CheckAuth.
+	ErrUnauthenticated = errors.New("the connection is not authenticated")
+	// ErrPathNotAllowed is the cause of Open commands refused for the path of their database,
+	// see Executor.SetAllowedDirs.
+	ErrPathNotAllowed = errors.New("the database path is not allowed")
+)
+
+// NotFoundError is returned if a table, a field of a table, or an item of a table does not
+// exist. It wraps ErrTableNotFound, ErrFieldNotFound, or ErrItemNotFound.
+type NotFoundError struct {
+	Err   error
+	Table string
+	Field string
+	Item  Item
+}
+
+func (e *NotFoundError) Error() string {
+	switch e.Err {
+	case ErrFieldNotFound:
+		return fmt.Sprintf("field '%s' does not exist in table '%s'", e.Field, e.Table)
+	case ErrItemNotFound:
+		return fmt.Sprintf("no %s %d", e.Table, e.Item)
+	default:
+		return fmt.Sprintf("table '%s' does not exist", e.Table)
+	}
+}
+
+func (e *NotFoundError) Unwrap() error {
+	return e.Err
+}
+
+// tableNotFound returns the error for a table that does not exist.
+func tableNotFound(table string) error {
+	return &NotFoundError{Err: ErrTableNotFound, Table: table}
+}
+
+// fieldNotFound returns the error for a field of a table that does not exist.
+func fieldNotFound(table, field string) error {
+	return &NotFoundError{Err: ErrFieldNotFound, Table: table, Field: field}
+}
+
+// itemNotFound returns the error for an item of a table that does not exist.
+func itemNotFound(table string, item Item) error {
+	return &NotFoundError{Err: ErrItemNotFound, Table: table, Item: item}
+}
+
+// TypeMismatchError is returned if a value does not have the type of the field it is
+// written to. It wraps ErrTypeMismatch.
+type TypeMismatchError struct {
+	Expected    FieldType
+	Encountered FieldType
+}
+
+func (e *TypeMismatchError) Error() string {
+	return fmt.Sprintf("expected %s, encountered %s", GetUserTypeString(e.Expected), GetUserTypeString(e.Encountered))
+}
+
+func (e *TypeMismatchError) Unwrap() error {
+	return ErrTypeMismatch
+}
+
+// Causes of errors returned by Exec in the Cause field of a Result. Unlike the error codes in
+// the Int field, which tell which command failed, they tell why it failed and are the same
+// for all commands.
+const (
+	CauseTableNotFound int64 = iota + 1
+	CauseFieldNotFound
+	CauseItemNotFound
+	CauseTypeMismatch
+	CauseVersionConflict
+	CauseDatabaseFull
+	CauseSubsystemDisabled
+	CauseReadOnly
+	CauseUnauthenticated
+	CausePathNotAllowed
+)
+
+// causes are the errors of the causes.
+var causes = map[int64]error{
+	CauseTableNotFound: ErrTableNotFound, CauseFieldNotFound: ErrFieldNotFound,
+	CauseItemNotFound: ErrItemNotFound, CauseTypeMismatch: ErrTypeMismatch,
+	CauseDatabaseFull: ErrDatabaseFull, CauseReadOnly: ErrReadOnlyExecutor,
+	CauseUnauthenticated: ErrUnauthenticated, CausePathNotAllowed: ErrPathNotAllowed,
+}
+
+// ErrorCause returns the cause of the error, or 0 if it has none of the known causes.
+func ErrorCause(err error) int64 {
+	var conflict *VersionConflictError
+	var disabled *SubsystemDisabledError
+	switch {
+	case errors.As(err, &conflict):
+		return CauseVersionConflict
+	case errors.As(err, &disabled):
+		return CauseSubsystemDisabled
+	}
+	for cause, target := range causes {
+		if errors.Is(err, target) {
+			return cause
+		}
+	}
+	return 0
+}
+
+// setError stores the error in the result of a failed command.
+func (r *Result) setError(err error) {
+	r.Str = err.Error()
+	r.Cause = ErrorCause(err)
+}
+
+// CommandError is the error of a failed command returned by Result.Err. Code is the error
+// code of the result and Cause its cause.
+type CommandError struct {
+	Code  int64
+	Cause int64
+	Msg   string
+}
+
+func (e *CommandError) Error() string {
+	return e.Msg
+}
+
+// Unwrap returns the error of the cause, such as ErrTableNotFound, so that errors.Is can be
+// used with errors returned by the server of a client.
+func (e *CommandError) Unwrap() error {
+	return causes[e.Cause]
+}
+
+// Err returns nil if the command succeeded and a *CommandError otherwise.
+func (r *Result) Err() error {
+	if !r.HasError {
+		return nil
+	}
+	return &CommandError{Code: r.Int, Cause: r.Cause, Msg: r.Str}
+}
@@ -0,0 +1,68 @@
+package minidb
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestTypedErrors(t *testing.T) {
+	db, err := Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatalf("Open() failed: %s", err)
+	}
+	defer db.Close()
+	if err := db.AddTable("Person", []Field{Field{Name: "Name", Sort: DBString}}); err != nil {
+		t.Fatalf("AddTable() failed: %s", err)
+	}
+	item, _ := db.NewItem("Person")
+	_, err = db.Get("Nobody", item, "Name")
+	var notFound *NotFoundError
+	if !errors.Is(err, ErrTableNotFound) || !errors.As(err, &notFound) || notFound.Table != "Nobody" {
+		t.Errorf("Get() for an unknown table returned %v, expected ErrTableNotFound", err)
+	}
+	if err.Error() != "table 'Nobody' does not exist" {
+		t.Errorf("Get() for an unknown table returned the message '%s'", err)
+	}
+	if _, err := db.Get("Person", item, "Age"); !errors.Is(err, ErrFieldNotFound) {
+		t.Errorf("Get() for an unknown field returned %v, expected ErrFieldNotFound", err)
+	}
+	if _, err := db.Get("Person", item+1, "Name"); !errors.Is(err, ErrItemNotFound) {
+		t.Errorf("Get() for an unknown item returned %v, expected ErrItemNotFound", err)
+	}
+	tx, _ := db.Begin()
+	err = tx.Set("Person", item, "Name", []Value{NewInt(1)})
+	tx.Rollback()
+	var mismatch *TypeMismatchError
+	if !errors.Is(err, ErrTypeMismatch) || !errors.As(err, &mismatch) || mismatch.Expected != DBString ||
+		mismatch.Encountered != DBInt {
+		t.Errorf("Set() with a value of the wrong type returned %v, expected ErrTypeMismatch", err)
+	}
+	if cause := ErrorCause(&VersionConflictError{}); cause != CauseVersionConflict {
+		t.Errorf("ErrorCause() returned %d for a version conflict, expected %d", cause, CauseVersionConflict)
+	}
+	if cause := ErrorCause(errors.New("other")); cause != 0 {
+		t.Errorf("ErrorCause() returned %d for an unknown error, expected 0", cause)
+	}
+}
+
+func TestResultErr(t *testing.T) {
+	tmp, _ := ioutil.TempFile("", "minidb-errors-testing-*")
+	defer os.Remove(tmp.Name())
+	db := CommandDB(tmp.Name())
+	e := NewExecutor()
+	defer e.CloseAllDBs()
+	if r := e.Exec(OpenCommand("sqlite3", tmp.Name())); r.Err() != nil {
+		t.Fatalf("Open command failed: %s", r.Err())
+	}
+	r := e.Exec(CountCommand(db, "Nobody"))
+	if r.Cause != CauseTableNotFound {
+		t.Errorf("Count command for an unknown table returned cause %d, expected %d", r.Cause, CauseTableNotFound)
+	}
+	err := r.Err()
+	var cmdErr *CommandError
+	if !errors.Is(err, ErrTableNotFound) || !errors.As(err, &cmdErr) || cmdErr.Code != ErrCountFailed {
+		t.Errorf("Result.Err() returned %v, expected ErrTableNotFound", err)
+	}
+}
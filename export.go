@@ -0,0 +1,385 @@
+package minidb
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"strings"
+)
+
+// ------------------------------------------------------------------------------
+// JSON export and import of tables
+// ------------------------------------------------------------------------------
+
+// importBatchSize is the number of items ImportJSON creates with one call of NewItems.
+const importBatchSize = 100
+
+// exportedItem is an item in a document written by ExportJSON.
+type exportedItem struct {
+	ID     Item                       `json:"id"`
+	Fields map[string]json.RawMessage `json:"fields"`
+}
+
+// ExportJSON writes the schema and all items of the tables as a JSON document to w, or of all
+// tables if none are given. The document has the form
+//
+//	{"schema":{...},"items":{"Person":[{"id":1,"fields":{"Name":"John","Tags":["a","b"]}},...]}}
+//
+// where the schema is written like by ExportSchema. Single fields hold a value or null and list
+// fields an array of values. Ints are numbers, strings are strings, blobs are Base64 encoded,
+// and dates are stored in RFC3339 format. Items are written one by one, so the document is never
+// held in memory as a whole. If no tables are given and the key-value store is enabled, the
+// document also holds its contents as member "kv" in the form written by ExportKV. All data is
+// read in a read-only transaction, see BeginRead, so the document reflects one state of the
+// database.
+func (db *MDB) ExportJSON(w io.Writer, tables ...string) error {
+	view := db
+	if db.tx == nil {
+		tx, err := db.BeginRead()
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback()
+		view = tx.View()
+	}
+	schema, err := view.GetSchema()
+	if err != nil {
+		return err
+	}
+	if len(tables) > 0 {
+		selected := make([]TableSchema, 0, len(tables))
+		for _, table := range tables {
+			found := false
+			for _, ts := range schema.Tables {
+				if ts.Name == table {
+					selected = append(selected, ts)
+					found = true
+				}
+			}
+			if !found {
+				return tableNotFound(table)
+			}
+		}
+		schema.Tables = selected
+		schema.Types = usedTypes(selected)
+	}
+	data, err := json.Marshal(schema)
+	if err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, `{"schema":`+string(data)+`,"items":{`); err != nil {
+		return err
+	}
+	for i, ts := range schema.Tables {
+		if err := view.exportTableJSON(w, ts, i == 0); err != nil {
+			return err
+		}
+	}
+	if _, err := io.WriteString(w, "}"); err != nil {
+		return err
+	}
+	if len(tables) == 0 && !db.kvDisabled {
+		kv, err := view.GetKVData()
+		if err != nil {
+			return err
+		}
+		data, err := json.Marshal(kv)
+		if err != nil {
+			return err
+		}
+		if _, err := io.WriteString(w, `,"kv":`+string(data)); err != nil {
+			return err
+		}
+	}
+	_, err = io.WriteString(w, "}\n")
+	return err
+}
+
+// exportTableJSON writes the items of a table as a member of the items object of ExportJSON.
+func (db *MDB) exportTableJSON(w io.Writer, ts TableSchema, first bool) error {
+	name, _ := json.Marshal(ts.Name)
+	prefix := ","
+	if first {
+		prefix = ""
+	}
+	if _, err := io.WriteString(w, prefix+string(name)+":["); err != nil {
+		return err
+	}
+	items, err := db.ListItems(ts.Name, 0)
+	if err != nil {
+		return err
+	}
+	for i, item := range items {
+		values, err := db.GetItem(ts.Name, item)
+		if err != nil {
+			return err
+		}
+		exported := exportedItem{ID: item, Fields: make(map[string]json.RawMessage)}
+		for _, field := range ts.Fields {
+			raw, err := valuesToJSON(field, values[field.Name])
+			if err != nil {
+				return Fail("cannot export %s %d field %s: %s", ts.Name, item, field.Name, err)
+			}
+			exported.Fields[field.Name] = raw
+		}
+		data, err := json.Marshal(exported)
+		if err != nil {
+			return err
+		}
+		if i > 0 {
+			data = append([]byte{','}, data...)
+		}
+		if _, err := w.Write(data); err != nil {
+			return err
+		}
+	}
+	_, err = io.WriteString(w, "]")
+	return err
+}
+
+// valuesToJSON returns the JSON representation of the values of a field in ExportJSON.
+func valuesToJSON(field Field, values []Value) (json.RawMessage, error) {
+	if !isListFieldType(field.Sort) {
+		if len(values) == 0 || values[0].Sort == DBNull {
+			return json.RawMessage("null"), nil
+		}
+		return json.Marshal(valueToJSON(values[0]))
+	}
+	list := make([]interface{}, len(values))
+	for i, v := range values {
+		list[i] = valueToJSON(v)
+	}
+	return json.Marshal(list)
+}
+
+// valueToJSON returns the value in the form encoded by ExportJSON.
+func valueToJSON(v Value) interface{} {
+	switch v.Sort {
+	case DBInt:
+		return v.Num
+	case DBBlob:
+		return base64.StdEncoding.EncodeToString(v.Bytes())
+	case DBNull:
+		return nil
+	default:
+		return v.Str
+	}
+}
+
+// jsonToValue parses a value of the base type in the form encoded by ExportJSON.
+func jsonToValue(raw json.RawMessage, t FieldType) (Value, error) {
+	if t == DBInt {
+		var n int64
+		if err := json.Unmarshal(raw, &n); err != nil {
+			return Value{}, Fail("expected an int, encountered %s", raw)
+		}
+		return NewInt(n), nil
+	}
+	var s string
+	if err := json.Unmarshal(raw, &s); err != nil {
+		return Value{}, Fail("expected a string, encountered %s", raw)
+	}
+	switch t {
+	case DBBlob:
+		b, err := base64.StdEncoding.DecodeString(s)
+		if err != nil {
+			return Value{}, Fail("invalid Base64 encoded blob: %s", err)
+		}
+		return NewBytes(b), nil
+	case DBDate:
+		d, err := ParseTime(s)
+		if err != nil {
+			return Value{}, err
+		}
+		return NewDate(d), nil
+	default:
+		return NewString(s), nil
+	}
+}
+
+// jsonToFieldValue parses the values of a field of an exported item. It returns false if the
+// field is a single field without value.
+func jsonToFieldValue(field Field, raw json.RawMessage) (FieldValue, bool, error) {
+	fv := FieldValue{Field: field.Name, Values: []Value{}}
+	t := ToBaseType(field.Sort)
+	if !isListFieldType(field.Sort) {
+		if strings.TrimSpace(string(raw)) == "null" {
+			return fv, false, nil
+		}
+		v, err := jsonToValue(raw, t)
+		if err != nil {
+			return fv, false, err
+		}
+		fv.Values = append(fv.Values, v)
+		return fv, true, nil
+	}
+	var list []json.RawMessage
+	if err := json.Unmarshal(raw, &list); err != nil {
+		return fv, false, Fail("expected an array, encountered %s", raw)
+	}
+	for _, elem := range list {
+		v, err := jsonToValue(elem, t)
+		if err != nil {
+			return fv, false, err
+		}
+		fv.Values = append(fv.Values, v)
+	}
+	return fv, true, nil
+}
+
+// ImportJSON reads a document written by ExportJSON. It first creates the tables, fields and
+// indexes of its schema that do not exist yet, see EnsureSchema, and then creates the items in
+// one transaction, so either all items are imported or none. The items are added to the items
+// already in the tables and get new IDs, in the order of the document. Single fields without
+// value get their default value, and the timestamp fields, see Tx.EnableTimestamps, are set to the
+// time of the import. The contents of the key-value store in the document are stored in the same
+// transaction, overwriting existing values with the same keys like ImportKV. Items are read one by
+// one, so the document is never held in memory as a whole.
+func (db *MDB) ImportJSON(r io.Reader) error {
+	dec := json.NewDecoder(r)
+	if err := expectDelim(dec, '{'); err != nil {
+		return err
+	}
+	var tx *Tx
+	defer func() {
+		if tx != nil {
+			tx.Rollback()
+		}
+	}()
+	for dec.More() {
+		key, err := dec.Token()
+		if err != nil {
+			return Fail("invalid JSON document: %s", err)
+		}
+		switch key {
+		case "schema":
+			if tx != nil {
+				return Fail("invalid JSON document: duplicate schema")
+			}
+			var schema Schema
+			if err := dec.Decode(&schema); err != nil {
+				return Fail("invalid schema: %s", err)
+			}
+			if err := db.EnsureSchema(schema); err != nil {
+				return err
+			}
+			if tx, err = db.Begin(); err != nil {
+				return err
+			}
+		case "items":
+			if tx == nil {
+				return Fail("invalid JSON document: the schema must precede the items")
+			}
+			if err := tx.importItemsJSON(dec); err != nil {
+				return err
+			}
+		case "kv":
+			if tx == nil {
+				return Fail("invalid JSON document: the schema must precede the key-value store")
+			}
+			var kv json.RawMessage
+			if err := dec.Decode(&kv); err != nil {
+				return Fail("invalid key-value data: %s", err)
+			}
+			if err := tx.ImportKV(bytes.NewReader(kv)); err != nil {
+				return err
+			}
+		default:
+			var skipped json.RawMessage
+			if err := dec.Decode(&skipped); err != nil {
+				return Fail("invalid JSON document: %s", err)
+			}
+		}
+	}
+	if err := expectDelim(dec, '}'); err != nil {
+		return err
+	}
+	if tx == nil {
+		return Fail("invalid JSON document: no schema")
+	}
+	err := tx.Commit()
+	tx = nil
+	return err
+}
+
+// importItemsJSON reads the items object of a document written by ExportJSON and creates the items.
+func (tx *Tx) importItemsJSON(dec *json.Decoder) error {
+	if err := expectDelim(dec, '{'); err != nil {
+		return err
+	}
+	for dec.More() {
+		token, err := dec.Token()
+		if err != nil {
+			return Fail("invalid JSON document: %s", err)
+		}
+		table, _ := token.(string)
+		view := tx.View()
+		if !view.TableExists(table) {
+			return tableNotFound(table)
+		}
+		fields, err := view.GetFields(table)
+		if err != nil {
+			return err
+		}
+		descs := make(map[string]Field)
+		for _, field := range fields {
+			descs[field.Name] = field
+		}
+		if err := expectDelim(dec, '['); err != nil {
+			return err
+		}
+		rows := make([][]FieldValue, 0, importBatchSize)
+		for dec.More() {
+			var item exportedItem
+			if err := dec.Decode(&item); err != nil {
+				return Fail("invalid item in table '%s': %s", table, err)
+			}
+			row := make([]FieldValue, 0, len(item.Fields))
+			for name, raw := range item.Fields {
+				desc, ok := descs[name]
+				if !ok {
+					return fieldNotFound(table, name)
+				}
+				if isTimestampField(name) {
+					continue
+				}
+				fv, ok, err := jsonToFieldValue(desc, raw)
+				if err != nil {
+					return Fail("invalid value of %s %d field %s: %s", table, item.ID, name, err)
+				}
+				if ok {
+					row = append(row, fv)
+				}
+			}
+			rows = append(rows, row)
+			if len(rows) == importBatchSize {
+				if _, err := tx.NewItems(table, rows); err != nil {
+					return err
+				}
+				rows = rows[:0]
+			}
+		}
+		if len(rows) > 0 {
+			if _, err := tx.NewItems(table, rows); err != nil {
+				return err
+			}
+		}
+		if err := expectDelim(dec, ']'); err != nil {
+			return err
+		}
+	}
+	return expectDelim(dec, '}')
+}
+
+// expectDelim reads the next token of the decoder and returns an error if it is not the delimiter.
+func expectDelim(dec *json.Decoder, delim json.Delim) error {
+	token, err := dec.Token()
+	if err != nil {
+		return Fail("invalid JSON document: %s", err)
+	}
+	if d, ok := token.(json.Delim); !ok || d != delim {
+		return Fail("invalid JSON document: expected '%s', encountered '%v'", delim, token)
+	}
+	return nil
+}
@@ -0,0 +1,116 @@
+package minidb
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestExportJSON(t *testing.T) {
+	db, err := Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatalf("Open() failed: %s", err)
+	}
+	defer db.Close()
+	err = db.AddTable("Person", []Field{{Name: "Name", Sort: DBString}, {Name: "Age", Sort: DBInt},
+		{Name: "Photo", Sort: DBBlob}, {Name: "Born", Sort: DBDate}, {Name: "Tags", Sort: DBStringList}})
+	if err != nil {
+		t.Fatalf("AddTable() failed: %s", err)
+	}
+	if err := db.AddTable("Note", []Field{{Name: "Text", Sort: DBString}}); err != nil {
+		t.Fatalf("AddTable() failed: %s", err)
+	}
+	born := time.Date(1990, 5, 17, 8, 30, 0, 0, time.UTC)
+	item, _ := db.NewItem("Person")
+	db.NewItem("Person")
+	db.NewItem("Note")
+	tx, _ := db.Begin()
+	err = tx.SetItem("Person", item, map[string][]Value{"Name": {NewString("John")}, "Age": {NewInt(42)},
+		"Photo": {NewBytes([]byte{0, 1, 2})}, "Born": {NewDate(born)}, "Tags": {NewString("a"), NewString("b")}})
+	if err != nil {
+		t.Fatalf("SetItem() failed: %s", err)
+	}
+	tx.Commit()
+
+	var buff bytes.Buffer
+	if err := db.ExportJSON(&buff, "Person"); err != nil {
+		t.Fatalf("ExportJSON() failed: %s", err)
+	}
+	var doc struct {
+		Schema Schema                    `json:"schema"`
+		Items  map[string][]exportedItem `json:"items"`
+	}
+	if err := json.Unmarshal(buff.Bytes(), &doc); err != nil {
+		t.Fatalf("ExportJSON() wrote invalid JSON: %s\n%s", err, buff.String())
+	}
+	if len(doc.Schema.Tables) != 1 || len(doc.Items) != 1 || len(doc.Items["Person"]) != 2 {
+		t.Fatalf("ExportJSON() should only export the given table: %s", buff.String())
+	}
+	fields := doc.Items["Person"][0].Fields
+	expected := map[string]string{"Name": `"John"`, "Age": `42`, "Photo": `"AAEC"`,
+		"Born": `"1990-05-17T08:30:00Z"`, "Tags": `["a","b"]`}
+	for name, value := range expected {
+		if string(fields[name]) != value {
+			t.Errorf("ExportJSON() wrote %s for %s, expected %s", fields[name], name, value)
+		}
+	}
+	if string(doc.Items["Person"][1].Fields["Name"]) != "null" || string(doc.Items["Person"][1].Fields["Tags"]) != "[]" {
+		t.Errorf("ExportJSON() wrote wrong empty values: %s", buff.String())
+	}
+	if err := db.ExportJSON(&buff, "Nobody"); err == nil {
+		t.Errorf("ExportJSON() should fail for nonexistent table")
+	}
+
+	kv, _ := db.Begin()
+	kv.SetInt(1, 7)
+	kv.SetStrKey("greeting", "hello")
+	kv.Bucket("config").SetStr(2, "on")
+	kv.Commit()
+	buff.Reset()
+	if err := db.ExportJSON(&buff); err != nil {
+		t.Fatalf("ExportJSON() failed: %s", err)
+	}
+	other, err := Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatalf("Open() failed: %s", err)
+	}
+	defer other.Close()
+	if err := other.ImportJSON(bytes.NewReader(buff.Bytes())); err != nil {
+		t.Fatalf("ImportJSON() failed: %s", err)
+	}
+	if n, _ := other.Count("Note"); n != 1 {
+		t.Errorf("ImportJSON() created %d notes, expected 1", n)
+	}
+	values, err := other.GetItem("Person", 1)
+	if err != nil {
+		t.Fatalf("GetItem() failed: %s", err)
+	}
+	if values["Name"][0].Str != "John" || values["Age"][0].Num != 42 || !bytes.Equal(values["Photo"][0].Bytes(), []byte{0, 1, 2}) ||
+		values["Born"][0].Str != NewDate(born).Str || len(values["Tags"]) != 2 || values["Tags"][1].Str != "b" {
+		t.Errorf("ImportJSON() imported wrong values: %v", values)
+	}
+	if other.GetInt(1) != 7 || other.GetStrKey("greeting") != "hello" || other.Bucket("config").GetStr(2) != "on" {
+		t.Errorf("ImportJSON() did not import the key-value store")
+	}
+	// importing again adds the items with new IDs
+	if err := other.ImportJSON(bytes.NewReader(buff.Bytes())); err != nil {
+		t.Fatalf("ImportJSON() failed: %s", err)
+	}
+	if items, _ := other.ListItems("Person", 0); len(items) != 4 {
+		t.Errorf("ImportJSON() should add items, found %v", items)
+	}
+
+	bad := `{"schema":{"tables":[{"name":"Person","fields":[{"name":"Age","sort":2}]}]},
+		"items":{"Person":[{"id":1,"fields":{"Age":5}},{"id":2,"fields":{"Age":"old"}}]}}`
+	if err := other.ImportJSON(strings.NewReader(bad)); err == nil {
+		t.Errorf("ImportJSON() should fail for a value of the wrong type")
+	}
+	if items, _ := other.ListItems("Person", 0); len(items) != 4 {
+		t.Errorf("a failed ImportJSON() should not import any items, found %v", items)
+	}
+	if err := other.ImportJSON(strings.NewReader(`{"items":{}}`)); err == nil {
+		t.Errorf("ImportJSON() should fail without schema")
+	}
+}
@@ -122,7 +122,7 @@ func consume1(state *pstate) []rune {
 }
 
 // parse parts like "Name=query" or "not every Name=John" or
-// "no Data=hello%"
+// "no Data=hello%" or "linked:owns Asset Name=Car%"
 func parseSearch(state *pstate) error {
 	peek := lookAhead1(state)
 	if string(peek) == "not" {
@@ -130,6 +130,12 @@ func parseSearch(state *pstate) error {
 		consume1(state)
 		peek = lookAhead1(state)
 	}
+	if string(peek) == "linked" && isLinkedTerm(state) {
+		if err := parseLinked(state); err != nil {
+			return err
+		}
+		return parseSearch(state)
+	}
 	switch string(peek) {
 	case "every":
 		state.ops.push(token{content: peek, sort: EveryTerm})
@@ -141,12 +147,40 @@ func parseSearch(state *pstate) error {
 	if err := parseField(state); err != nil {
 		return err
 	}
+	if string(lookAhead1(state)) == "is" {
+		return parseIsNull(state)
+	}
 	if err := parseInfixOP(state); err != nil {
 		return err
 	}
 	return parseSearchQuery(state)
 }
 
+// check whether the next token is "linked" immediately followed by a colon
+func isLinkedTerm(state *pstate) bool {
+	pos := state.pos
+	consume1(state)
+	result := state.pos < len(state.in) && state.in[state.pos] == ':'
+	state.pos = pos
+	return result
+}
+
+// parse "linked:relation Table", the search term for the linked items follows
+func parseLinked(state *pstate) error {
+	consume1(state)
+	state.pos++
+	start := state.pos
+	relation := consume1(state)
+	if len(relation) == 0 {
+		return Fail(`pos=%d: missing relation after "linked:"`, start)
+	}
+	if err := parseTable(state); err != nil {
+		return err
+	}
+	state.ops.push(token{content: relation, sort: LinkedTerm})
+	return nil
+}
+
 func maybeParseParens(state *pstate) error {
 	maybeParen := true
 	for maybeParen && state.pos < len(state.in) {
@@ -229,8 +263,7 @@ func parseSearchClause(state *pstate) error {
 	if err := parseTable(state); err != nil {
 		return err
 	}
-	parseComplexExpr(state)
-	return nil
+	return parseComplexExpr(state)
 }
 
 // parse the name of a table
@@ -280,13 +313,26 @@ func parseInfixOP(state *pstate) error {
 	return nil
 }
 
+// parse "is null" after a field name like in "Person Name is null"
+func parseIsNull(state *pstate) error {
+	op := consume1(state)
+	skipWS(state)
+	start := state.pos
+	if string(consume1(state)) != "null" {
+		return Fail(`pos=%d: expected "null" after "is"`, start)
+	}
+	state.ops.push(token{content: op, sort: InfixOP})
+	state.out.push(token{content: []rune("null"), sort: QueryString})
+	return nil
+}
+
 // parse the query part which may be a string or unquoted, e.g.
 // "%hello%" in the query "Person Name=%hello%"
 func parseSearchQuery(state *pstate) error {
 	var noDelimiter = regexp.MustCompile(`\S`).MatchString
 	skipWS(state)
 	start := state.pos
-	if state.in[state.pos] == '"' {
+	if state.pos < len(state.in) && state.in[state.pos] == '"' {
 		return parseString(state)
 	}
 	for state.pos < len(state.in) && noDelimiter(string(state.in[state.pos])) && state.in[state.pos] != ')' {
@@ -336,6 +382,18 @@ func convert(parse *pstate) (*Query, error) {
 		query := Query{Sort: token.sort, Data: string(token.content), Children: []Query{*larg, *rarg}}
 		return &query, nil
 
+	case LinkedTerm:
+		embeddedQuery, err := convert(parse)
+		if err != nil {
+			return nil, err
+		}
+		table, err := convert(parse)
+		if err != nil {
+			return nil, Fail(`invalid or missing linked table name: %s`, err)
+		}
+		query := Query{Sort: LinkedTerm, Data: string(token.content), Children: []Query{*table, *embeddedQuery}}
+		return &query, nil
+
 	case EveryTerm, NoTerm:
 		embeddedQuery, err := convert(parse)
 		if err != nil {
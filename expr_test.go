@@ -19,11 +19,23 @@ func TestParse(t *testing.T) {
 		{"Person not Name=John",
 			`SearchClause("Person",[LogicalNot("not",[InfixOP("=",[FieldString("Name",[]),QueryString("John",[])])])])`},
 		{"Person not every Name=John", `SearchClause("Person",[LogicalNot("not",[EveryTerm("every",[InfixOP("=",[FieldString("Name",[]),QueryString("John",[])])])])])`},
+		// null
+		{"Person Name is null",
+			`SearchClause("Person",[InfixOP("is",[FieldString("Name",[]),QueryString("null",[])])])`},
+		{"Person not Name is null and Age=42",
+			`SearchClause("Person",[LogicalAnd("and",[LogicalNot("not",[InfixOP("is",[FieldString("Name",[]),QueryString("null",[])])]),InfixOP("=",[FieldString("Age",[]),QueryString("42",[])])])])`},
 		// connectives
 		{"Person Name=John and Name=Smith", `SearchClause("Person",[LogicalAnd("and",[InfixOP("=",[FieldString("Name",[]),QueryString("John",[])]),InfixOP("=",[FieldString("Name",[]),QueryString("Smith",[])])])])`},
 		{"Person Name=John and Name=Smith or Name=Mueller", `SearchClause("Person",[LogicalOr("or",[LogicalAnd("and",[InfixOP("=",[FieldString("Name",[]),QueryString("John",[])]),InfixOP("=",[FieldString("Name",[]),QueryString("Smith",[])])]),InfixOP("=",[FieldString("Name",[]),QueryString("Mueller",[])])])])`},
 		{"Person (Name=John or Name=Bob)",
 			`SearchClause("Person",[LogicalOr("or",[InfixOP("=",[FieldString("Name",[]),QueryString("John",[])]),InfixOP("=",[FieldString("Name",[]),QueryString("Bob",[])])])])`},
+		// links
+		{"Person linked:owns Asset Name=Car%",
+			`SearchClause("Person",[LinkedTerm("owns",[TableString("Asset",[]),InfixOP("=",[FieldString("Name",[]),QueryString("Car%",[])])])])`},
+		{"Person Name=John and not linked:owns Asset no Tags=old",
+			`SearchClause("Person",[LogicalAnd("and",[InfixOP("=",[FieldString("Name",[]),QueryString("John",[])]),LogicalNot("not",[LinkedTerm("owns",[TableString("Asset",[]),NoTerm("no",[InfixOP("=",[FieldString("Tags",[]),QueryString("old",[])])])])])])])`},
+		{"Person linked=John",
+			`SearchClause("Person",[InfixOP("=",[FieldString("linked",[]),QueryString("John",[])])])`},
 		{"Person ((Name=John or Name=Bob) and Name=Theodore)",
 			`SearchClause("Person",[LogicalAnd("and",[LogicalOr("or",[InfixOP("=",[FieldString("Name",[]),QueryString("John",[])]),InfixOP("=",[FieldString("Name",[]),QueryString("Bob",[])])]),InfixOP("=",[FieldString("Name",[]),QueryString("Theodore",[])])])])`},
 	}
@@ -0,0 +1,345 @@
+package minidb
+
+import (
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// The query fuzzer creates a random table with random data, runs random queries against it
+// and compares the result of Find with a naive evaluation of the parsed query in memory.
+// Blob fields are not generated because their LIKE semantics depend on the Sqlite version.
+
+// fuzzItem holds the values of an item as Sqlite compares them in LIKE clauses.
+// A single field that has never been set is missing from the map (NULL).
+type fuzzItem struct {
+	id     Item
+	values map[string][]string
+}
+
+type fuzzTable struct {
+	fields []Field
+	sorts  map[string]FieldType
+	items  []fuzzItem
+}
+
+var fuzzTypes = []FieldType{DBInt, DBString, DBDate, DBIntList, DBStringList, DBDateList}
+
+// fuzzChars are used for strings and search terms, including quotes and wildcards.
+const fuzzChars = "abcAB1_%'"
+
+func randomFuzzString(r *rand.Rand, maxLen int) string {
+	n := r.Intn(maxLen + 1)
+	b := make([]byte, n)
+	for i := range b {
+		b[i] = fuzzChars[r.Intn(len(fuzzChars))]
+	}
+	return string(b)
+}
+
+func randomFuzzValue(r *rand.Rand, t FieldType) Value {
+	switch ToBaseType(t) {
+	case DBInt:
+		return NewInt(int64(r.Intn(41) - 20))
+	case DBDate:
+		return NewDate(time.Date(2018+r.Intn(3), time.Month(1+r.Intn(12)), 1+r.Intn(28),
+			r.Intn(24), 0, 0, 0, time.UTC))
+	default:
+		return NewString(randomFuzzString(r, 4))
+	}
+}
+
+// randomFuzzTerm returns a search term for the field, which is either derived from a value
+// of the field type or a random string with wildcards.
+func randomFuzzTerm(r *rand.Rand, t FieldType) string {
+	var s string
+	if r.Intn(3) == 0 {
+		s = randomFuzzString(r, 3)
+	} else {
+		v := randomFuzzValue(r, t)
+		s = v.String()
+		if len(s) > 0 && r.Intn(2) == 0 {
+			s = s[:r.Intn(len(s))] + "%"
+		}
+	}
+	if s == "" {
+		return "%"
+	}
+	return s
+}
+
+// newFuzzTable creates a table F with random fields and items in db.
+func newFuzzTable(t *testing.T, r *rand.Rand, db *MDB) *fuzzTable {
+	ft := &fuzzTable{sorts: make(map[string]FieldType)}
+	n := 1 + r.Intn(4)
+	for i := 0; i < n; i++ {
+		field := Field{Name: fmt.Sprintf("F%d", i), Sort: fuzzTypes[r.Intn(len(fuzzTypes))]}
+		ft.fields = append(ft.fields, field)
+		ft.sorts[field.Name] = field.Sort
+	}
+	if err := db.AddTable("F", ft.fields); err != nil {
+		t.Fatalf("AddTable() failed: %s", err)
+	}
+	items := make([]Item, r.Intn(12))
+	for i := range items {
+		var err error
+		if items[i], err = db.NewItem("F"); err != nil {
+			t.Fatalf("NewItem() failed: %s", err)
+		}
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatalf("Begin() failed: %s", err)
+	}
+	for _, item := range items {
+		fi := fuzzItem{id: item, values: make(map[string][]string)}
+		for _, field := range ft.fields {
+			k := 1
+			if isListFieldType(field.Sort) {
+				k = r.Intn(4)
+			} else if r.Intn(4) == 0 {
+				continue
+			}
+			values := make([]Value, k)
+			for j := range values {
+				values[j] = randomFuzzValue(r, field.Sort)
+				fi.values[field.Name] = append(fi.values[field.Name], values[j].String())
+			}
+			if k == 0 {
+				continue
+			}
+			if err := tx.Set("F", item, field.Name, values); err != nil {
+				t.Fatalf("Set() failed: %s", err)
+			}
+		}
+		ft.items = append(ft.items, fi)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("Commit() failed: %s", err)
+	}
+	return ft
+}
+
+// randomFuzzQuery returns a random query string for the table.
+func randomFuzzQuery(r *rand.Rand, ft *fuzzTable, depth int) string {
+	if depth > 0 && r.Intn(2) == 0 {
+		connective := "and"
+		if r.Intn(2) == 0 {
+			connective = "or"
+		}
+		s := randomFuzzQuery(r, ft, depth-1) + " " + connective + " " + randomFuzzQuery(r, ft, depth-1)
+		if r.Intn(3) == 0 {
+			s = "(" + s + ")"
+		}
+		return s
+	}
+	field := ft.fields[r.Intn(len(ft.fields))]
+	prefix := ""
+	if isListFieldType(field.Sort) {
+		switch r.Intn(4) {
+		case 0:
+			prefix = "every "
+		case 1:
+			prefix = "no "
+		}
+	}
+	if r.Intn(4) == 0 {
+		prefix = "not " + prefix
+	}
+	return prefix + field.Name + "=" + randomFuzzTerm(r, field.Sort)
+}
+
+// likeMatch implements the LIKE operator of Sqlite without ESCAPE clause,
+// which is case-insensitive for ASCII characters.
+func likeMatch(pattern, s string) bool {
+	p := []rune(strings.ToLower(pattern))
+	in := []rune(strings.ToLower(s))
+	var match func(i, j int) bool
+	match = func(i, j int) bool {
+		for i < len(p) {
+			switch p[i] {
+			case '%':
+				for k := j; k <= len(in); k++ {
+					if match(i+1, k) {
+						return true
+					}
+				}
+				return false
+			case '_':
+				if j >= len(in) {
+					return false
+				}
+			default:
+				if j >= len(in) || in[j] != p[i] {
+					return false
+				}
+			}
+			i++
+			j++
+		}
+		return j == len(in)
+	}
+	return match(0, 0)
+}
+
+// fuzzBool is a truth value of Sqlite's three-valued logic, nil represents NULL.
+type fuzzBool *bool
+
+func fuzzTruth(b bool) fuzzBool {
+	return &b
+}
+
+// naiveFind evaluates the query for every item of the table. Each clause on a list field
+// ranges over the elements of the list independently, and items with an empty list in such
+// a clause are never found, just like with the joins generated by ToSql.
+func naiveFind(q *Query, ft *fuzzTable) []Item {
+	var joined []*Query
+	var collect func(q *Query)
+	collect = func(q *Query) {
+		switch q.Sort {
+		case InfixOP:
+			if isListFieldType(ft.sorts[q.Children[0].Data]) {
+				joined = append(joined, q)
+			}
+		case LogicalAnd, LogicalOr, LogicalNot:
+			for i := range q.Children {
+				collect(&q.Children[i])
+			}
+		}
+	}
+	collect(q)
+	result := make([]Item, 0)
+	for _, item := range ft.items {
+		choice := make(map[*Query]string)
+		var try func(k int) bool
+		try = func(k int) bool {
+			if k == len(joined) {
+				b := naiveEval(q, ft, item, choice)
+				return b != nil && *b
+			}
+			for _, v := range item.values[joined[k].Children[0].Data] {
+				choice[joined[k]] = v
+				if try(k + 1) {
+					return true
+				}
+			}
+			return false
+		}
+		if try(0) {
+			result = append(result, item.id)
+		}
+	}
+	return result
+}
+
+func naiveEval(q *Query, ft *fuzzTable, item fuzzItem, choice map[*Query]string) fuzzBool {
+	switch q.Sort {
+	case LogicalAnd:
+		a := naiveEval(&q.Children[0], ft, item, choice)
+		b := naiveEval(&q.Children[1], ft, item, choice)
+		if (a != nil && !*a) || (b != nil && !*b) {
+			return fuzzTruth(false)
+		}
+		if a == nil || b == nil {
+			return nil
+		}
+		return fuzzTruth(true)
+	case LogicalOr:
+		a := naiveEval(&q.Children[0], ft, item, choice)
+		b := naiveEval(&q.Children[1], ft, item, choice)
+		if (a != nil && *a) || (b != nil && *b) {
+			return fuzzTruth(true)
+		}
+		if a == nil || b == nil {
+			return nil
+		}
+		return fuzzTruth(false)
+	case LogicalNot:
+		a := naiveEval(&q.Children[0], ft, item, choice)
+		if a == nil {
+			return nil
+		}
+		return fuzzTruth(!*a)
+	case NoTerm, EveryTerm:
+		clause := q.Children[0]
+		pattern := clause.Children[1].Data
+		for _, v := range item.values[clause.Children[0].Data] {
+			if likeMatch(pattern, v) != (q.Sort == EveryTerm) {
+				return fuzzTruth(false)
+			}
+		}
+		return fuzzTruth(true)
+	case InfixOP:
+		field := q.Children[0].Data
+		pattern := q.Children[1].Data
+		if isListFieldType(ft.sorts[field]) {
+			return fuzzTruth(likeMatch(pattern, choice[q]))
+		}
+		values, ok := item.values[field]
+		if !ok {
+			return nil
+		}
+		return fuzzTruth(likeMatch(pattern, values[0]))
+	}
+	panic(fmt.Sprintf("unexpected query element %s", QuerySortToStr(q.Sort)))
+}
+
+func sameItems(a, b []Item) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	found := make(map[Item]bool)
+	for _, item := range a {
+		found[item] = true
+	}
+	for _, item := range b {
+		if !found[item] {
+			return false
+		}
+	}
+	return true
+}
+
+// checkRandomQueries runs random queries on a random table generated from the seed.
+func checkRandomQueries(t *testing.T, seed int64) {
+	r := rand.New(rand.NewSource(seed))
+	tmp, _ := ioutil.TempFile("", "minidb-fuzz-testing-*")
+	tmp.Close()
+	defer os.Remove(tmp.Name())
+	db, err := Open("sqlite3", tmp.Name())
+	if err != nil {
+		t.Fatalf("Open() failed: %s", err)
+	}
+	defer db.Close()
+	ft := newFuzzTable(t, r, db)
+	for i := 0; i < 20; i++ {
+		s := "F " + randomFuzzQuery(r, ft, 2)
+		q, err := ParseQuery(s)
+		if err != nil {
+			t.Fatalf(`seed %d: ParseQuery("%s") failed: %s`, seed, s, err)
+		}
+		items, err := db.Find(q, 0)
+		if err != nil {
+			t.Fatalf(`seed %d: Find("%s") failed: %s`, seed, s, err)
+		}
+		expected := naiveFind(&q.Children[0], ft)
+		if !sameItems(items, expected) {
+			t.Fatalf(`seed %d: Find("%s") returned %v, expected %v`, seed, s, items, expected)
+		}
+	}
+}
+
+// FuzzFind cross-checks Find against a naive evaluation of queries. The seed corpus is run
+// by go test, use go test -fuzz=FuzzFind to search for further failures.
+func FuzzFind(f *testing.F) {
+	for seed := int64(1); seed <= 50; seed++ {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, seed int64) {
+		checkRandomQueries(t, seed)
+	})
+}
@@ -0,0 +1,254 @@
+package minidb
+
+import (
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// ------------------------------------------------------------------------------
+// Shared group databases of multiuser databases
+// ------------------------------------------------------------------------------
+
+// groupsDir is the directory below the base directory that contains the group directories.
+// It cannot clash with a user directory, since user names start with a letter.
+const groupsDir = "_groups"
+
+// Group represents a group of users that share a database in addition to their private ones.
+type Group struct {
+	name string
+	id   Item
+}
+
+// Name returns the name of the group.
+func (g *Group) Name() string {
+	return g.name
+}
+
+// ID returns the ID of the group.
+func (g *Group) ID() Item {
+	return g.id
+}
+
+// GroupDir returns the directory where the database of the group is stored.
+func (m *MultiDB) GroupDir(group *Group) string {
+	return filepath.Join(m.basepath, groupsDir, group.name)
+}
+
+func (m *MultiDB) groupDBFile(group *Group) string {
+	return filepath.Join(m.GroupDir(group), "data.sqlite")
+}
+
+// validGroup returns an error unless the group exists.
+func (m *MultiDB) validGroup(group *Group) (ErrCode, error) {
+	if group == nil || group.id == 0 || m.groupID(group.name) != group.id {
+		return ErrUnknownGroup, Fail(`unknown group`)
+	}
+	return OK, nil
+}
+
+func (m *MultiDB) groupID(name string) Item {
+	var id int64
+	if err := m.system.reader.QueryRow(`SELECT Id FROM UserGroup WHERE Name=?;`, name).Scan(&id); err != nil {
+		return 0
+	}
+	return Item(id)
+}
+
+// NewGroup creates a new group without members. Group names follow the rules of user names and
+// must be unique, otherwise ErrInvalidGroup or ErrGroupInUse is returned.
+func (m *MultiDB) NewGroup(name string) (*Group, ErrCode, error) {
+	if !validUserName(name) {
+		return nil, ErrInvalidGroup, Fail(`invalid group name "%s"`, name)
+	}
+	if m.groupID(name) != 0 {
+		return nil, ErrGroupInUse, Fail(`group "%s" already exists!`, name)
+	}
+	tx, err := m.Begin()
+	if err != nil {
+		return nil, ErrTransactionFail, err
+	}
+	defer tx.Rollback()
+	ids, err := tx.NewItems("UserGroup", [][]FieldValue{{
+		{Field: "Name", Values: []Value{NewString(name)}},
+		{Field: "Created", Values: []Value{NewDate(time.Now())}}}})
+	if err != nil {
+		return nil, ErrDBFail, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, ErrDBFail, Fail(`multiuser database error: %s`, err)
+	}
+	group := Group{name: name, id: ids[0]}
+	if err := CreateDirIfNotExist(m.GroupDir(&group)); err != nil {
+		return nil, ErrFileSystem, err
+	}
+	return &group, OK, nil
+}
+
+// FindGroup returns the group with the name.
+func (m *MultiDB) FindGroup(name string) (*Group, ErrCode, error) {
+	id := m.groupID(name)
+	if id == 0 {
+		return nil, ErrUnknownGroup, Fail(`group "%s" does not exist`, name)
+	}
+	return &Group{name: name, id: id}, OK, nil
+}
+
+// memberID returns the GroupMember item that makes the user a member of the group, or 0.
+func (m *MultiDB) memberID(group *Group, user *User) Item {
+	var id int64
+	err := m.system.reader.QueryRow(`SELECT Id FROM GroupMember WHERE GroupId=? AND User=?;`,
+		int64(group.id), int64(user.id)).Scan(&id)
+	if err != nil {
+		return 0
+	}
+	return Item(id)
+}
+
+// IsMember returns true if the user is a member of the group.
+func (m *MultiDB) IsMember(group *Group, user *User) bool {
+	return group != nil && user != nil && m.memberID(group, user) != 0
+}
+
+// AddMember makes the user a member of the group, which gives the user access to the group
+// database. Adding a member again does nothing.
+func (m *MultiDB) AddMember(group *Group, user *User) (ErrCode, error) {
+	if reply, err := m.validGroup(group); err != nil {
+		return reply, err
+	}
+	if user == nil || !m.ExistingUser(user.name) {
+		return ErrUnknownUser, Fail(`unknown user`)
+	}
+	if m.IsMember(group, user) {
+		return OK, nil
+	}
+	tx, err := m.Begin()
+	if err != nil {
+		return ErrTransactionFail, err
+	}
+	defer tx.Rollback()
+	_, err = tx.NewItems("GroupMember", [][]FieldValue{{
+		{Field: "GroupId", Values: []Value{NewInt(int64(group.id))}},
+		{Field: "User", Values: []Value{NewInt(int64(user.id))}}}})
+	if err != nil {
+		return ErrDBFail, err
+	}
+	if err := tx.Commit(); err != nil {
+		return ErrTransactionFail, Fail(`multiuser database error: %s`, err)
+	}
+	return OK, nil
+}
+
+// RemoveMember removes the user from the group. Removing a user who is not a member does nothing.
+func (m *MultiDB) RemoveMember(group *Group, user *User) (ErrCode, error) {
+	if reply, err := m.validGroup(group); err != nil {
+		return reply, err
+	}
+	if user == nil {
+		return ErrUnknownUser, Fail(`unknown user`)
+	}
+	id := m.memberID(group, user)
+	if id == 0 {
+		return OK, nil
+	}
+	tx, err := m.Begin()
+	if err != nil {
+		return ErrTransactionFail, err
+	}
+	defer tx.Rollback()
+	if err := tx.RemoveItem("GroupMember", id); err != nil {
+		return ErrDBFail, err
+	}
+	if err := tx.Commit(); err != nil {
+		return ErrTransactionFail, Fail(`multiuser database error: %s`, err)
+	}
+	return OK, nil
+}
+
+// Members returns the members of the group ordered by user name.
+func (m *MultiDB) Members(group *Group) ([]*User, ErrCode, error) {
+	if reply, err := m.validGroup(group); err != nil {
+		return nil, reply, err
+	}
+	return m.queryUsers(`WHERE Id IN (SELECT User FROM GroupMember WHERE GroupId=?) ORDER BY Username;`,
+		int64(group.id))
+}
+
+// Groups returns the groups of which the user is a member, ordered by name.
+func (m *MultiDB) Groups(user *User) ([]*Group, ErrCode, error) {
+	if user == nil {
+		return nil, ErrUnknownUser, Fail(`unknown user`)
+	}
+	rows, err := m.system.reader.Query(`SELECT Id,Name FROM UserGroup WHERE Id IN
+(SELECT GroupId FROM GroupMember WHERE User=?) ORDER BY Name;`, int64(user.id))
+	if err != nil {
+		return nil, ErrDBFail, Fail(`could not read groups: %s`, err)
+	}
+	defer rows.Close()
+	groups := make([]*Group, 0)
+	for rows.Next() {
+		var group Group
+		if err := rows.Scan(&group.id, &group.name); err != nil {
+			return nil, ErrDBFail, Fail(`could not read groups: %s`, err)
+		}
+		groups = append(groups, &group)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, ErrDBFail, Fail(`could not read groups: %s`, err)
+	}
+	return groups, OK, nil
+}
+
+// GroupDB returns the database of the group for the user, which must be a member of the group,
+// otherwise ErrNotMember is returned. All members share the same database, which stays open
+// until the MultiDB is closed or the group is deleted.
+func (m *MultiDB) GroupDB(group *Group, user *User) (*MDB, ErrCode, error) {
+	if reply, err := m.validGroup(group); err != nil {
+		return nil, reply, err
+	}
+	if !m.IsMember(group, user) {
+		return nil, ErrNotMember, Fail(`user is not a member of group "%s"`, group.name)
+	}
+	m.groupMutex.Lock()
+	defer m.groupMutex.Unlock()
+	if db := m.groupdbs[group.id]; db != nil {
+		return db, OK, nil
+	}
+	db, err := Open(m.driver, m.groupDBFile(group))
+	if err != nil {
+		return nil, ErrOpenFailed, err
+	}
+	m.groupdbs[group.id] = db
+	return db, OK, nil
+}
+
+// DeleteGroup deletes the group, its memberships, and its database. This action cannot be undone.
+func (m *MultiDB) DeleteGroup(group *Group) (ErrCode, error) {
+	if reply, err := m.validGroup(group); err != nil {
+		return reply, err
+	}
+	m.groupMutex.Lock()
+	if db := m.groupdbs[group.id]; db != nil {
+		db.Close()
+		delete(m.groupdbs, group.id)
+	}
+	m.groupMutex.Unlock()
+	tx, err := m.Begin()
+	if err != nil {
+		return ErrTransactionFail, err
+	}
+	defer tx.Rollback()
+	if err := tx.RemoveItem("UserGroup", group.id); err != nil {
+		return ErrDBFail, err
+	}
+	if err := removeItemsWhere(tx, "GroupMember", "GroupId", group.id); err != nil {
+		return ErrDBFail, err
+	}
+	if err := os.RemoveAll(m.GroupDir(group)); err != nil {
+		return ErrFileSystem, err
+	}
+	if err := tx.Commit(); err != nil {
+		return ErrTransactionFail, Fail(`multiuser database error: %s`, err)
+	}
+	return OK, nil
+}
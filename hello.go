@@ -0,0 +1,148 @@
+package minidb
+
+import "time"
+
+// ------------------------------------------------------------------------------
+// Handshake between clients and executors
+// ------------------------------------------------------------------------------
+
+// Capabilities of executors reported by a Hello command.
+const (
+	// CapabilityBatch means that the server accepts batches of commands, see ExecBatch.
+	CapabilityBatch = "batch"
+	// CapabilityBinary means that the server accepts messages in EncodingBinary.
+	CapabilityBinary = "binary"
+	// CapabilityMultiUser means that the executor has a multiuser database, see SetMultiDB.
+	CapabilityMultiUser = "multiuser"
+	// CapabilityAuth means that connections must be authenticated with an Auth command, see
+	// Executor.SetAuthTokens.
+	CapabilityAuth = "auth"
+)
+
+// ServerInfo describes an executor as returned by a Hello command.
+type ServerInfo struct {
+	Version      int
+	Capabilities []string
+	Commands     []CommandID
+}
+
+// hello returns the description of the executor. The commands are those the executor may
+// execute with its current roles and permissions.
+func (e *Executor) hello() *ServerInfo {
+	info := ServerInfo{Version: CommandVersion, Capabilities: []string{CapabilityBatch, CapabilityBinary},
+		Commands: make([]CommandID, 0, maxCommand)}
+	hasMultiDB := e.getMultiDB() != nil
+	readOnly := e.isReadOnly()
+	canShutdown := e.getShutdownHandler() != nil && e.AuthRequired()
+	if hasMultiDB {
+		info.Capabilities = append(info.Capabilities, CapabilityMultiUser)
+	}
+	if e.AuthRequired() {
+		info.Capabilities = append(info.Capabilities, CapabilityAuth)
+	}
+	for id := CmdOpen; id < maxCommand; id++ {
+		if id == CmdPing || id == CmdHello || id == CmdAuth ||
+			(e.permitted(id) && e.rolePermits(id) && (hasMultiDB || !multiUserCommands[id]) &&
+				(canShutdown || id != CmdServerShutdown) && (!readOnly || readOnlyPermits(id))) {
+			info.Commands = append(info.Commands, id)
+		}
+	}
+	return &info
+}
+
+// toResult stores the description in the result of a Hello command.
+func (info *ServerInfo) toResult(r *Result) {
+	r.Int = int64(info.Version)
+	r.Strings = info.Capabilities
+	r.Ints = make([]int64, len(info.Commands))
+	for i, id := range info.Commands {
+		r.Ints[i] = int64(id)
+	}
+}
+
+// ParseHello returns the description of the executor in the result of a Hello command.
+func ParseHello(r *Result) *ServerInfo {
+	info := ServerInfo{Version: int(r.Int), Capabilities: r.Strings, Commands: make([]CommandID, len(r.Ints))}
+	for i, id := range r.Ints {
+		info.Commands[i] = CommandID(id)
+	}
+	return &info
+}
+
+// PingInfo describes the state of an executor as returned by a Ping command. Clients holds the
+// number of clients that have opened each database given to PingCommand, which is 0 for databases
+// that are not open.
+type PingInfo struct {
+	Uptime  time.Duration
+	Version int
+	Clients map[CommandDB]int
+}
+
+// ping returns the state of the executor and of the databases.
+func (e *Executor) ping(dbs []string) *PingInfo {
+	info := PingInfo{Uptime: time.Since(e.started), Version: CommandVersion,
+		Clients: make(map[CommandDB]int, len(dbs))}
+	for _, name := range dbs {
+		info.Clients[CommandDB(name)] = 0
+		if entry := e.lookupDB(CommandDB(name)); entry != nil {
+			entry.mutex.Lock()
+			if !entry.closed {
+				info.Clients[CommandDB(name)] = entry.connections
+			}
+			entry.mutex.Unlock()
+		}
+	}
+	return &info
+}
+
+// toResult stores the state in the result of a Ping command. Int holds the uptime in
+// milliseconds, Strings the databases, and Ints the number of clients of each. The version is
+// that of every result.
+func (info *PingInfo) toResult(r *Result) {
+	r.Int = info.Uptime.Milliseconds()
+	r.Strings = make([]string, 0, len(info.Clients))
+	r.Ints = make([]int64, 0, len(info.Clients))
+	for db, clients := range info.Clients {
+		r.Strings = append(r.Strings, string(db))
+		r.Ints = append(r.Ints, int64(clients))
+	}
+}
+
+// ParsePing returns the state of the executor in the result of a Ping command. Servers of older
+// versions report no uptime.
+func ParsePing(r *Result) *PingInfo {
+	info := PingInfo{Uptime: time.Duration(r.Int) * time.Millisecond, Version: r.Version,
+		Clients: make(map[CommandDB]int, len(r.Strings))}
+	for i, db := range r.Strings {
+		if i < len(r.Ints) {
+			info.Clients[CommandDB(db)] = int(r.Ints[i])
+		}
+	}
+	return &info
+}
+
+// IsOpen returns true if a client has opened the database, which must have been given to
+// PingCommand.
+func (info *PingInfo) IsOpen(db CommandDB) bool {
+	return info.Clients[db] > 0
+}
+
+// Supports returns true if the executor executes commands of the type.
+func (info *ServerInfo) Supports(id CommandID) bool {
+	for _, supported := range info.Commands {
+		if supported == id {
+			return true
+		}
+	}
+	return false
+}
+
+// HasCapability returns true if the executor has the capability, such as CapabilityBatch.
+func (info *ServerInfo) HasCapability(capability string) bool {
+	for _, c := range info.Capabilities {
+		if c == capability {
+			return true
+		}
+	}
+	return false
+}
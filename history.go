@@ -0,0 +1,324 @@
+package minidb
+
+import (
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"time"
+)
+
+// ------------------------------------------------------------------------------
+// History
+// ------------------------------------------------------------------------------
+
+// Change is a change of a field value recorded by Tx.Set in a table whose history is enabled,
+// see Tx.EnableHistory. The time is the time when Set was called, not when the transaction was
+// committed.
+type Change struct {
+	Time   time.Time `json:"time"`
+	Values []Value   `json:"values"`
+}
+
+// Snapshot is a read-only view of the database as of a past time. It is returned by MDB.AsOf.
+type Snapshot struct {
+	db   *MDB
+	time time.Time
+}
+
+// EnableHistory records the changes that Set and SetItem make to the fields of the table from
+// now on, see MDB.HistoryOf and MDB.AsOf. Changes older than the retention are removed when the
+// field is set again, so that the history does not grow without limit, while a retention of 0
+// keeps all changes until they are removed with PruneHistory. Calling it again for the table
+// changes the retention. The setting is stored in the database and follows renamed tables.
+func (tx *Tx) EnableHistory(table string, retention time.Duration) error {
+	if !validTable.MatchString(table) {
+		return Fail("invalid table name '%s'", table)
+	}
+	if !tx.View().TableExists(table) {
+		return tableNotFound(table)
+	}
+	if retention < 0 {
+		return Fail("invalid history retention %s", retention)
+	}
+	_, err := tx.tx.Exec(`UPDATE _TABLES SET History=? WHERE Name=?;`, int64(retention), table)
+	if err != nil {
+		return Fail("cannot enable history of table %s: %s", table, err)
+	}
+	return nil
+}
+
+// DisableHistory stops recording the changes of the fields of the table and removes the changes
+// recorded so far.
+func (tx *Tx) DisableHistory(table string) error {
+	if !tx.View().TableExists(table) {
+		return tableNotFound(table)
+	}
+	if _, err := tx.tx.Exec(`UPDATE _TABLES SET History=NULL WHERE Name=?;`, table); err != nil {
+		return Fail("cannot disable history of table %s: %s", table, err)
+	}
+	if _, err := tx.removeHistory(`TableName=?`, table); err != nil {
+		return Fail("cannot remove history of table %s: %s", table, err)
+	}
+	return nil
+}
+
+// HasHistory returns true if the changes of the table are recorded, and their retention, which
+// is 0 if they are kept until they are pruned.
+func (db *MDB) HasHistory(table string) (bool, time.Duration) {
+	retention, ok := historyRetention(db.reader, table)
+	return ok, retention
+}
+
+// historyRetention returns the retention of the changes of the table and whether they are
+// recorded at all.
+func historyRetention(q querier, table string) (time.Duration, bool) {
+	var retention sql.NullInt64
+	if err := q.QueryRow(`SELECT History FROM _TABLES WHERE Name=?;`, table).Scan(&retention); err != nil {
+		return 0, false
+	}
+	return time.Duration(retention.Int64), retention.Valid
+}
+
+// recordChange adds the new values of a field to the change log if the history of the table is
+// enabled, and removes the changes of the field that are older than the retention. The values
+// are those written to the field, so large blobs are recorded as references to their files.
+func (tx *Tx) recordChange(table string, item Item, field string, t FieldType, data []Value) error {
+	retention, ok := historyRetention(tx.tx, table)
+	if !ok {
+		return nil
+	}
+	// NULL values are encoded as JSON null
+	strs := make([]*string, len(data))
+	for i := range data {
+		if !data[i].IsNull() {
+			s := data[i].String()
+			strs[i] = &s
+		}
+	}
+	encoded, err := json.Marshal(strs)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	_, err = tx.tx.Exec(`INSERT INTO _HISTORY (TableName,Item,Field,FieldType,Changed,Vals) VALUES (?,?,?,?,?,?);`,
+		table, item, field, t, now.UnixNano(), string(encoded))
+	if err != nil {
+		return Fail("cannot record change of %s %d %s: %s", table, item, field, err)
+	}
+	if retention > 0 {
+		_, err = tx.removeHistory(`TableName=? AND Item=? AND Field=? AND Changed<?`,
+			table, item, field, now.Add(-retention).UnixNano())
+		if err != nil {
+			return Fail("cannot remove old changes of %s %d %s: %s", table, item, field, err)
+		}
+	}
+	return nil
+}
+
+// removeHistory removes the changes that match the SQL condition from the change log and returns
+// their number. The external blobs they refer to are remembered like those of removed items, see
+// collectOrphans.
+func (tx *Tx) removeHistory(where string, args ...interface{}) (int64, error) {
+	if tx.mdb.blobs != nil {
+		rows, err := tx.tx.Query(`SELECT Vals FROM _HISTORY WHERE FieldType IN (?,?) AND `+where+`;`,
+			append([]interface{}{DBBlob, DBBlobList}, args...)...)
+		if err != nil {
+			return 0, err
+		}
+		for rows.Next() {
+			var encoded string
+			if err := rows.Scan(&encoded); err != nil {
+				rows.Close()
+				return 0, err
+			}
+			tx.orphans = append(tx.orphans, changeBlobRefs(encoded)...)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return 0, err
+		}
+	}
+	result, err := tx.tx.Exec(`DELETE FROM _HISTORY WHERE `+where+`;`, args...)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// changeBlobRefs returns the references to external blobs among the encoded values of a change
+// of a blob field.
+func changeBlobRefs(encoded string) []string {
+	var strs []*string
+	if err := json.Unmarshal([]byte(encoded), &strs); err != nil {
+		return nil
+	}
+	var refs []string
+	for _, s := range strs {
+		if s == nil {
+			continue
+		}
+		if b, err := base64.StdEncoding.DecodeString(*s); err == nil && isBlobRef(b) {
+			refs = append(refs, string(b))
+		}
+	}
+	return refs
+}
+
+// historyBlobReferenced returns true if a recorded change holds the reference to an external blob.
+func historyBlobReferenced(q querier, ref string) (bool, error) {
+	// blobs are encoded as base64 JSON strings, see recordChange
+	quoted := `"` + base64.StdEncoding.EncodeToString([]byte(ref)) + `"`
+	var found bool
+	err := q.QueryRow(`SELECT EXISTS (SELECT 1 FROM _HISTORY WHERE FieldType IN (?,?) AND instr(Vals,?)>0);`,
+		DBBlob, DBBlobList, quoted).Scan(&found)
+	return found, err
+}
+
+// historyBlobs adds the hashes of the external blobs that recorded changes refer to to used.
+func historyBlobs(q querier, used map[string]bool) error {
+	rows, err := q.Query(`SELECT Vals FROM _HISTORY WHERE FieldType IN (?,?);`, DBBlob, DBBlobList)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var encoded string
+		if err := rows.Scan(&encoded); err != nil {
+			return err
+		}
+		for _, ref := range changeBlobRefs(encoded) {
+			used[ref[len(blobRefPrefix):]] = true
+		}
+	}
+	return rows.Err()
+}
+
+// decodeChange reconstructs the values of a change log entry. References to external blobs are
+// not resolved, see MDB.loadBlobs.
+func decodeChange(t FieldType, encoded string) ([]Value, error) {
+	var strs []*string
+	if err := json.Unmarshal([]byte(encoded), &strs); err != nil {
+		return nil, Fail("corrupt change log entry: %s", err)
+	}
+	result := make([]Value, len(strs))
+	for i := range strs {
+		if strs[i] == nil {
+			result[i] = NewNull()
+			continue
+		}
+		v, err := parseValue(ToBaseType(t), *strs[i])
+		if err != nil {
+			return nil, err
+		}
+		result[i] = v
+	}
+	return result, nil
+}
+
+func (db *MDB) validateHistoryField(table string, field string) error {
+	if !validTable.MatchString(table) {
+		return Fail("invalid table name '%s'", table)
+	}
+	if !db.TableExists(table) {
+		return tableNotFound(table)
+	}
+	if !db.FieldExists(table, field) {
+		return fieldNotFound(table, field)
+	}
+	return nil
+}
+
+// HistoryOf returns the recorded changes of a field of an item, oldest first. Only changes
+// that have not been pruned by Tx.PruneHistory or the retention of the table are returned, and
+// none if the history of the table is not enabled. The history of an item is removed together
+// with the item.
+func (db *MDB) HistoryOf(table string, item Item, field string) ([]Change, error) {
+	if err := db.validateHistoryField(table, field); err != nil {
+		return nil, err
+	}
+	rows, err := db.reader.Query(`SELECT FieldType,Changed,Vals FROM _HISTORY WHERE TableName=? AND Item=? AND Field=?
+ORDER BY Changed, Id;`, table, item, field)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	result := make([]Change, 0)
+	for rows.Next() {
+		var t FieldType
+		var changed int64
+		var encoded string
+		if err := rows.Scan(&t, &changed, &encoded); err != nil {
+			return nil, err
+		}
+		values, err := decodeChange(t, encoded)
+		if err != nil {
+			return nil, err
+		}
+		if err := db.loadBlobs(Field{Sort: t}, values); err != nil {
+			return nil, err
+		}
+		result = append(result, Change{Time: time.Unix(0, changed), Values: values})
+	}
+	return result, rows.Err()
+}
+
+// AsOf returns a read-only view of the database that reconstructs field values as they
+// were at the given time from the change log.
+func (db *MDB) AsOf(t time.Time) *Snapshot {
+	return &Snapshot{db: db, time: t}
+}
+
+// Time returns the time of the snapshot.
+func (s *Snapshot) Time() time.Time {
+	return s.time
+}
+
+// Get returns the value(s) of a field of an item as they were at the time of the snapshot.
+// If the field has not changed since then, its current value is returned. An error is returned
+// if the field has changed since then but no earlier change has been retained.
+func (s *Snapshot) Get(table string, item Item, field string) ([]Value, error) {
+	db := s.db
+	if err := db.validateHistoryField(table, field); err != nil {
+		return nil, err
+	}
+	var t FieldType
+	var encoded string
+	err := db.reader.QueryRow(`SELECT FieldType,Vals FROM _HISTORY WHERE TableName=? AND Item=? AND Field=? AND Changed<=?
+ORDER BY Changed DESC, Id DESC LIMIT 1;`, table, item, field, s.time.UnixNano()).Scan(&t, &encoded)
+	if err == nil {
+		values, err := decodeChange(t, encoded)
+		if err != nil {
+			return nil, err
+		}
+		if err := db.loadBlobs(Field{Sort: t}, values); err != nil {
+			return nil, err
+		}
+		if len(values) == 0 {
+			return nil, Fail("no values for %s %d %s as of %s", table, item, field, s.time.Format(time.RFC3339))
+		}
+		return values, nil
+	}
+	if err != sql.ErrNoRows {
+		return nil, err
+	}
+	var later int64
+	err = db.reader.QueryRow(`SELECT COUNT(*) FROM _HISTORY WHERE TableName=? AND Item=? AND Field=? AND Changed>?;`,
+		table, item, field, s.time.UnixNano()).Scan(&later)
+	if err != nil {
+		return nil, err
+	}
+	if later > 0 {
+		return nil, Fail("no value for %s %d %s as of %s", table, item, field, s.time.Format(time.RFC3339))
+	}
+	return db.Get(table, item, field)
+}
+
+// PruneHistory removes all changes older than the given time from the change log
+// and returns the number of changes removed.
+func (tx *Tx) PruneHistory(before time.Time) (int64, error) {
+	n, err := tx.removeHistory(`Changed<?`, before.UnixNano())
+	if err != nil {
+		return 0, Fail("cannot prune history: %s", err)
+	}
+	return n, nil
+}
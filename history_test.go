@@ -0,0 +1,169 @@
+package minidb
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestHistory(t *testing.T) {
+	tmp, _ := ioutil.TempFile("", "minidb-history-testing-*")
+	defer os.Remove(tmp.Name())
+	db, err := Open("sqlite3", tmp.Name())
+	if err != nil {
+		t.Errorf("Open() failed: %s", err)
+	}
+	defer db.Close()
+	if err := db.AddTable("Person", []Field{Field{Name: "Name", Sort: DBString},
+		Field{Name: "Tags", Sort: DBStringList}}); err != nil {
+		t.Errorf("AddTable() failed: %s", err)
+	}
+	john, _ := db.NewItem("Person")
+	set := func(field string, values ...Value) time.Time {
+		tx, err := db.Begin()
+		if err != nil {
+			t.Errorf("Begin() failed: %s", err)
+		}
+		if err := tx.Set("Person", john, field, values); err != nil {
+			t.Errorf("Set() failed: %s", err)
+		}
+		if err := tx.Commit(); err != nil {
+			t.Errorf("Commit() failed: %s", err)
+		}
+		time.Sleep(time.Millisecond)
+		return time.Now()
+	}
+	set("Name", NewString("Nobody"))
+	if history, err := db.HistoryOf("Person", john, "Name"); err != nil || len(history) != 0 {
+		t.Errorf("HistoryOf() returned %v, %v for a table without history", history, err)
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		t.Errorf("Begin() failed: %s", err)
+	}
+	if err := tx.EnableHistory("Person", 0); err != nil {
+		t.Errorf("EnableHistory() failed: %s", err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Errorf("Commit() failed: %s", err)
+	}
+	if ok, retention := db.HasHistory("Person"); !ok || retention != 0 {
+		t.Errorf("HasHistory() returned %t, %s after EnableHistory()", ok, retention)
+	}
+	before := time.Now()
+	time.Sleep(time.Millisecond)
+	t1 := set("Name", NewString("John"))
+	set("Tags", NewString("a"), NewString("b"))
+	t2 := set("Name", NewString("Johnny"))
+	set("Tags")
+
+	history, err := db.HistoryOf("Person", john, "Name")
+	if err != nil {
+		t.Errorf("HistoryOf() failed: %s", err)
+	}
+	if len(history) != 2 || history[0].Values[0].String() != "John" || history[1].Values[0].String() != "Johnny" {
+		t.Errorf("HistoryOf() returned wrong changes: %v", history)
+	}
+	if !history[0].Time.Before(history[1].Time) {
+		t.Errorf("HistoryOf() returned changes in wrong order")
+	}
+	history, err = db.HistoryOf("Person", john, "Tags")
+	if err != nil || len(history) != 2 || len(history[0].Values) != 2 || len(history[1].Values) != 0 {
+		t.Errorf("HistoryOf() returned wrong changes for list field: %v %v", history, err)
+	}
+	if _, err := db.HistoryOf("Person", john, "Age"); err == nil {
+		t.Errorf("HistoryOf() should fail for nonexistent field")
+	}
+
+	values, err := db.AsOf(t1).Get("Person", john, "Name")
+	if err != nil || len(values) != 1 || values[0].String() != "John" {
+		t.Errorf("AsOf().Get() returned %v, %v, expected John", values, err)
+	}
+	values, err = db.AsOf(t1).Get("Person", john, "Tags")
+	if err == nil {
+		t.Errorf("AsOf().Get() should fail for a field without value at that time, returned %v", values)
+	}
+	values, err = db.AsOf(t2).Get("Person", john, "Tags")
+	if err != nil || len(values) != 2 || values[0].String() != "a" || values[1].String() != "b" {
+		t.Errorf("AsOf().Get() returned %v, %v, expected a b", values, err)
+	}
+	values, err = db.AsOf(time.Now()).Get("Person", john, "Name")
+	if err != nil || len(values) != 1 || values[0].String() != "Johnny" {
+		t.Errorf("AsOf().Get() returned %v, %v, expected Johnny", values, err)
+	}
+	if _, err := db.AsOf(before).Get("Person", john, "Name"); err == nil {
+		t.Errorf("AsOf().Get() should fail before the first change")
+	}
+
+	tx, err = db.Begin()
+	if err != nil {
+		t.Errorf("Begin() failed: %s", err)
+	}
+	n, err := tx.PruneHistory(t1)
+	if err != nil || n != 1 {
+		t.Errorf("PruneHistory() returned %d, %v, expected 1 change removed", n, err)
+	}
+	if err := tx.RenameTable("Person", "Human"); err != nil {
+		t.Errorf("RenameTable() failed: %s", err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Errorf("Commit() failed: %s", err)
+	}
+	history, err = db.HistoryOf("Human", john, "Name")
+	if err != nil || len(history) != 1 || history[0].Values[0].String() != "Johnny" {
+		t.Errorf("HistoryOf() returned %v, %v after pruning and renaming", history, err)
+	}
+	tx, err = db.Begin()
+	if err != nil {
+		t.Errorf("Begin() failed: %s", err)
+	}
+	if err := tx.RemoveItem("Human", john); err != nil {
+		t.Errorf("RemoveItem() failed: %s", err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Errorf("Commit() failed: %s", err)
+	}
+	history, err = db.HistoryOf("Human", john, "Name")
+	if err != nil || len(history) != 0 {
+		t.Errorf("HistoryOf() returned %v, %v for removed item", history, err)
+	}
+}
+
+func TestHistoryRetention(t *testing.T) {
+	tmp, _ := ioutil.TempFile("", "minidb-history-testing-*")
+	defer os.Remove(tmp.Name())
+	db, err := Open("sqlite3", tmp.Name())
+	if err != nil {
+		t.Errorf("Open() failed: %s", err)
+	}
+	defer db.Close()
+	db.AddTable("Person", []Field{Field{Name: "Name", Sort: DBString}})
+	john, _ := db.NewItem("Person")
+	tx, _ := db.Begin()
+	if err := tx.EnableHistory("Person", 50*time.Millisecond); err != nil {
+		t.Errorf("EnableHistory() failed: %s", err)
+	}
+	tx.Set("Person", john, "Name", []Value{NewString("John")})
+	tx.Commit()
+	time.Sleep(100 * time.Millisecond)
+	tx, _ = db.Begin()
+	tx.Set("Person", john, "Name", []Value{NewString("Johnny")})
+	tx.Commit()
+	history, err := db.HistoryOf("Person", john, "Name")
+	if err != nil || len(history) != 1 || history[0].Values[0].String() != "Johnny" {
+		t.Errorf("HistoryOf() returned %v, %v, expected the change within the retention", history, err)
+	}
+	tx, _ = db.Begin()
+	if err := tx.DisableHistory("Person"); err != nil {
+		t.Errorf("DisableHistory() failed: %s", err)
+	}
+	tx.Set("Person", john, "Name", []Value{NewString("Jack")})
+	tx.Commit()
+	if ok, _ := db.HasHistory("Person"); ok {
+		t.Errorf("HasHistory() returned true after DisableHistory()")
+	}
+	if history, _ := db.HistoryOf("Person", john, "Name"); len(history) != 0 {
+		t.Errorf("HistoryOf() returned %v after DisableHistory()", history)
+	}
+}
@@ -0,0 +1,6 @@
+// Package integration contains end-to-end tests of the command line tool cmd/minidb and the
+// server cmd/mdbserve. The tests build both executables, start the server on a free port, and
+// run every subcommand of the command line tool against it, so they cover the networked path
+// of the indirect API: the client, the JSON encoding of commands and results, and the argument
+// layouts of the commands. They are skipped with go test -short.
+package integration
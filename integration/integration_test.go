@@ -0,0 +1,214 @@
+package integration
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	minidb "github.com/rasteric/minidb"
+	"github.com/rasteric/minidb/client"
+)
+
+// harness runs the command line tool against a server started for a test.
+type harness struct {
+	t      *testing.T
+	cli    string
+	server string
+	url    string
+	db     string
+}
+
+// build builds the package with the go tool into the directory and returns the path of the executable.
+func build(t *testing.T, dir string, pkg string) string {
+	out := filepath.Join(dir, filepath.Base(pkg))
+	cmd := exec.Command("go", "build", "-o", out, pkg)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("cannot build %s: %s\n%s", pkg, err, output)
+	}
+	return out
+}
+
+// freeURL returns a tcp URL on localhost with a port that is not in use.
+func freeURL(t *testing.T) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("cannot find a free port: %s", err)
+	}
+	defer l.Close()
+	return fmt.Sprintf("tcp://127.0.0.1:%d", l.Addr().(*net.TCPAddr).Port)
+}
+
+// newHarness builds the executables, starts the server, and waits until it replies.
+func newHarness(t *testing.T) *harness {
+	if testing.Short() {
+		t.Skip("the integration tests build and run executables")
+	}
+	dir := t.TempDir()
+	h := &harness{
+		t:      t,
+		cli:    build(t, dir, "github.com/rasteric/minidb/cmd/minidb"),
+		server: build(t, dir, "github.com/rasteric/minidb/cmd/mdbserve"),
+		url:    freeURL(t),
+		db:     filepath.Join(dir, "test.sqlite"),
+	}
+	server := exec.Command(h.server, "--url", h.url, "timeout", "300")
+	server.Stderr = os.Stderr
+	if err := server.Start(); err != nil {
+		t.Fatalf("cannot start server: %s", err)
+	}
+	t.Cleanup(func() {
+		server.Process.Kill()
+		server.Wait()
+	})
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		conn, err := client.DialTimeout(time.Second, h.url)
+		if err == nil {
+			_, err = conn.Exec(minidb.PingCommand())
+			conn.Close()
+		}
+		if err == nil {
+			return h
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("server does not reply: %s", err)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+// run runs the command line tool with the arguments and returns its standard output and exit code.
+func (h *harness) run(args ...string) (string, int) {
+	args = append([]string{"--db", h.db, "--connection", h.url, "--connection-trials", "5",
+		"--server", h.server}, args...)
+	cmd := exec.Command(h.cli, args...)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+	code := 0
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		code = exitErr.ExitCode()
+	} else if err != nil {
+		h.t.Fatalf("cannot run minidb %s: %s", strings.Join(args, " "), err)
+	}
+	if code != 0 && stderr.Len() > 0 {
+		h.t.Logf("minidb %s: %s", strings.Join(args, " "), strings.TrimSpace(stderr.String()))
+	}
+	return stdout.String(), code
+}
+
+// step is a run of the command line tool with its expected output and exit code. If contains
+// is true, the expected output only needs to be part of the output.
+type step struct {
+	args     []string
+	output   string
+	code     int
+	contains bool
+}
+
+func (h *harness) check(steps []step) {
+	for _, s := range steps {
+		output, code := h.run(s.args...)
+		if code != s.code {
+			h.t.Errorf("minidb %s: exit code %d, expected %d", strings.Join(s.args, " "), code, s.code)
+		}
+		if (s.contains && !strings.Contains(output, s.output)) || (!s.contains && output != s.output) {
+			h.t.Errorf("minidb %s: output %q, expected %q", strings.Join(s.args, " "), output, s.output)
+		}
+	}
+}
+
+func args(s string) []string {
+	return strings.Fields(s)
+}
+
+func TestTables(t *testing.T) {
+	h := newHarness(t)
+	h.check([]step{
+		{args: args("table Person string Name int Age required default 7 string-list Tags")},
+		{args: args("list-tables"), output: "Person\n"},
+		{args: args("list-fields Person"), output: "string Name\nint Age required default 7\nstring-list Tags\n"},
+		{args: args("new Person"), output: "1\n"},
+		{args: args("set Person 1 Name John")},
+		{args: args("set Person 1 Tags a b")},
+		{args: args("get Person 1 Name"), output: "John\n"},
+		{args: args("get Person 1 Age"), output: "7\n"},
+		{args: args("get Person 1 Tags"), output: "a\nb\n"},
+		{args: args("set Person 1 Age old"), code: 12},
+		{args: args("clone Person 1"), output: "2\n"},
+		{args: args("get Person 2 Tags"), output: "a\nb\n"},
+		{args: args("count Person"), output: "2\n"},
+		{args: args("list Person"), output: "1 2\n"},
+		{args: args("find Person Tags=b"), output: "1 2\n"},
+		{args: args("--limit 1 find Person Name=John"), output: "1\n"},
+		{args: args("index Person Name")},
+		{args: args("reindex Person")},
+		{args: args("remove Person 2")},
+		{args: args("count Person"), output: "1\n"},
+		{args: args("get Person 2 Name"), code: 7},
+		{args: args("compact"), output: "\n", contains: true},
+		{args: args("dump"), output: `INSERT INTO "_TABLES"`, contains: true},
+		{args: args("purge-expired"), output: "0\n"},
+		{args: args("lint")},
+		{args: args("normalize-lists")},
+		{args: args("get Person 1 Tags"), output: "a\nb\n"},
+		{args: args("find Person Tags=a"), output: "1\n"},
+		{args: args("timestamps Person")},
+		{args: args("list-fields Person"), output: "date _Created\n", contains: true},
+		{args: args("rename-table Person People")},
+		{args: args("list-tables"), output: "People\n"},
+		{args: args("get People 1 Name"), output: "John\n"},
+		{args: args("drop-table People")},
+		{args: args("list-tables"), output: "\n"},
+		{args: args("count People"), code: 9},
+	})
+}
+
+func TestKeyValueStore(t *testing.T) {
+	h := newHarness(t)
+	export := filepath.Join(t.TempDir(), "kv.json")
+	h.check([]step{
+		{args: args("set-int 1 42")},
+		{args: args("set-str 2 hello")},
+		{args: args("set-blob 3 aGVsbG8=")},
+		{args: args("set-date 4 2020-01-02T03:04:05Z")},
+		{args: args("get-int 1"), output: "42\n"},
+		{args: args("get-str 2"), output: "hello\n"},
+		{args: args("get-blob 3"), output: "aGVsbG8=\n"},
+		{args: args("get-date 4"), output: "2020-01-02", contains: true},
+		{args: args("has-int 1"), output: "true\n"},
+		{args: args("has-int 2"), output: "false\n", code: 1},
+		{args: args("has-str 2"), output: "true\n"},
+		{args: args("has-blob 3"), output: "true\n"},
+		{args: args("has-date 4"), output: "true\n"},
+		{args: args("list-int"), output: "1\n"},
+		{args: args("list-str"), output: "2\n"},
+		{args: args("list-blob"), output: "3\n"},
+		{args: args("list-date"), output: "4\n"},
+		{args: args("export-kv " + export)},
+		{args: args("delete-int 1")},
+		{args: args("delete-str 2")},
+		{args: args("delete-blob 3")},
+		{args: args("delete-date 4")},
+		{args: args("has-int 1"), output: "false\n", code: 1},
+		{args: args("has-date 4"), output: "false\n", code: 1},
+		{args: args("import-kv " + export)},
+		{args: args("get-int 1"), output: "42\n"},
+		{args: args("get-str 2"), output: "hello\n"},
+		{args: args("set-blob 5 not-base64"), code: 14},
+		{args: args("set-float 6 2.5")},
+		{args: args("get-float 6"), output: "2.5\n"},
+		{args: args("has-float 6"), output: "true\n"},
+		{args: args("list-float"), output: "6\n"},
+		{args: args("delete-float 6")},
+		{args: args("has-float 6"), output: "false\n", code: 1},
+		{args: args("set-float 6 many"), code: 14},
+	})
+}
@@ -0,0 +1,197 @@
+package minidb
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// ------------------------------------------------------------------------------
+// Asynchronous jobs for long-running commands
+// ------------------------------------------------------------------------------
+
+// JobState is the state of a job submitted with a Submit command.
+type JobState int64
+
+const (
+	// JobPending jobs wait until fewer than maxRunningJobs jobs of the executor are running.
+	JobPending JobState = iota + 1
+	// JobRunning jobs are being executed.
+	JobRunning
+	// JobDone jobs have been executed and their result can be fetched with a JobResult command.
+	JobDone
+)
+
+// maxRunningJobs is the number of jobs an executor runs at the same time.
+const maxRunningJobs = 4
+
+// jobTimeout is the time after which an executor forgets a finished job whose result has not
+// been fetched.
+const jobTimeout = 10 * time.Minute
+
+// jobCommands are the commands that may be submitted as jobs. They may take long on large
+// databases and return no handles, such as cursors or transactions, that outlive the command.
+var jobCommands = map[CommandID]bool{
+	CmdFind: true, CmdFindWithin: true, CmdCountQuery: true, CmdRunTemplate: true, CmdListItems: true,
+	CmdGetMulti: true, CmdExportJSON: true, CmdExportKV: true, CmdDump: true, CmdDumpKV: true,
+	CmdBackup: true, CmdCompact: true, CmdLintSchema: true, CmdNormalizeLists: true,
+	CmdDatabaseSize: true, CmdTableSize: true,
+}
+
+// job is a command executed in the background. Its fields are protected by the mutex of the
+// executor.
+type job struct {
+	db        CommandDB
+	cmd       Command
+	state     JobState
+	cancelled bool
+	result    *Result
+	finished  time.Time
+}
+
+// submitJob starts executing the command in the background and returns the ID of its job. Jobs
+// that have finished more than jobTimeout ago are removed.
+func (e *Executor) submitJob(cmd *Command) (string, error) {
+	if !jobCommands[cmd.ID] {
+		return "", Fail("command %d cannot be submitted as a job", cmd.ID)
+	}
+	id, err := newToken("job")
+	if err != nil {
+		return "", err
+	}
+	j := &job{db: cmd.DB, cmd: *cmd, state: JobPending}
+	e.mutex.Lock()
+	for other, old := range e.jobs {
+		if old.state == JobDone && time.Since(old.finished) > jobTimeout {
+			delete(e.jobs, other)
+		}
+	}
+	e.jobs[id] = j
+	e.mutex.Unlock()
+	go e.runJob(j)
+	return id, nil
+}
+
+// runJob executes the command of the job once fewer than maxRunningJobs jobs are running,
+// unless the job has been cancelled in the meantime.
+func (e *Executor) runJob(j *job) {
+	e.jobSlots <- struct{}{}
+	defer func() { <-e.jobSlots }()
+	e.mutex.Lock()
+	if j.cancelled {
+		e.mutex.Unlock()
+		return
+	}
+	j.state = JobRunning
+	e.mutex.Unlock()
+	r := e.Exec(&j.cmd)
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	j.result = r
+	j.state = JobDone
+	j.finished = time.Now()
+}
+
+// getJob returns the job with the given ID for the database.
+func (e *Executor) getJob(db CommandDB, id string) (*job, error) {
+	e.mutex.RLock()
+	defer e.mutex.RUnlock()
+	j, ok := e.jobs[id]
+	if !ok || j.db != db {
+		return nil, Fail("unknown or expired job '%s'", id)
+	}
+	return j, nil
+}
+
+// jobState returns the state of the job.
+func (e *Executor) jobState(j *job) JobState {
+	e.mutex.RLock()
+	defer e.mutex.RUnlock()
+	return j.state
+}
+
+// takeJobResult returns the result of the job and removes the job if it is done, or nil if it
+// is not done yet.
+func (e *Executor) takeJobResult(id string, j *job) *Result {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	if j.state != JobDone {
+		return nil
+	}
+	delete(e.jobs, id)
+	return j.result
+}
+
+// cancelJob removes the job. A pending job is not executed anymore, and the result of a running
+// job is discarded, since commands cannot be interrupted.
+func (e *Executor) cancelJob(id string, j *job) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	j.cancelled = true
+	delete(e.jobs, id)
+}
+
+// removeJobs removes the jobs of the database.
+func (e *Executor) removeJobs(db CommandDB) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	for id, j := range e.jobs {
+		if j.db == db {
+			j.cancelled = true
+			delete(e.jobs, id)
+		}
+	}
+}
+
+// SubmitCommand returns a pointer to a command structure that executes the given command in the
+// background instead of waiting for its result, so that a client does not block its connection
+// while, for example, a large database is exported or backed up. The result contains the ID of
+// the job in Str, which is used with JobStatusCommand, JobResultCommand, and JobCancelCommand.
+// Only long-running commands that return no cursors or transactions can be submitted, such as
+// Find, ExportJSON, Dump, Backup, and Compact. The command is checked like any other command
+// when the job runs, so errors such as ErrNotPermitted are returned by JobResultCommand.
+func SubmitCommand(cmd *Command) *Command {
+	encoded, _ := json.Marshal(cmd)
+	return &Command{
+		ID:      CmdSubmit,
+		DB:      cmd.DB,
+		StrArgs: []string{string(encoded)},
+		Version: CommandVersion,
+	}
+}
+
+// JobStatusCommand returns a pointer to a command structure that returns the state of a job
+// submitted with SubmitCommand as a JobState in the Int field of the result. Bool is true if
+// the job is done.
+func JobStatusCommand(db CommandDB, job string) *Command {
+	return &Command{
+		ID:      CmdJobStatus,
+		DB:      db,
+		StrArgs: []string{job},
+		Version: CommandVersion,
+	}
+}
+
+// JobResultCommand returns a pointer to a command structure that returns the result of the
+// command of a job submitted with SubmitCommand and removes the job. It fails with ErrJobNotDone
+// if the job is not done yet. Finished jobs whose results are not fetched are removed after
+// ten minutes.
+func JobResultCommand(db CommandDB, job string) *Command {
+	return &Command{
+		ID:      CmdJobResult,
+		DB:      db,
+		StrArgs: []string{job},
+		Version: CommandVersion,
+	}
+}
+
+// JobCancelCommand returns a pointer to a command structure that removes a job submitted with
+// SubmitCommand. A job that is still pending is not executed, while a running job completes in
+// the background and its result is discarded.
+func JobCancelCommand(db CommandDB, job string) *Command {
+	return &Command{
+		ID:      CmdJobCancel,
+		DB:      db,
+		StrArgs: []string{job},
+		Version: CommandVersion,
+	}
+}
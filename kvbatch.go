@@ -0,0 +1,107 @@
+package minidb
+
+import (
+	"sort"
+	"strings"
+	"time"
+)
+
+// ------------------------------------------------------------------------------
+// Batch operations of the key-value store
+// ------------------------------------------------------------------------------
+
+// kvArg returns the argument used to store the value in the key-value store, which keeps
+// blobs and dates as strings.
+func kvArg(v Value) interface{} {
+	if v.Sort == DBInt {
+		return v.Num
+	}
+	return v.Str
+}
+
+// SetMany stores the values by key in the store for values of the type, which must be DBInt,
+// DBString, DBBlob, or DBDate, using multi-row statements. All values must be of the type.
+// It is much faster than setting many values one by one, for example when populating settings.
+func (tx *Tx) SetMany(t FieldType, values map[int64]Value) error {
+	if tx.mdb.kvDisabled {
+		return ErrKVDisabled
+	}
+	store, err := kvStore(t, false)
+	if err != nil {
+		return err
+	}
+	keys := make([]int64, 0, len(values))
+	for key, v := range values {
+		if v.Sort != t {
+			return Fail("the value for key %d is not of the type of the store", key)
+		}
+		if t == DBDate {
+			if _, err := ParseTime(v.Str); err != nil {
+				return Fail("invalid date for key %d: %s", key, err)
+			}
+		}
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i] < keys[j] })
+	if tx.mdb.audit {
+		for _, key := range keys {
+			if err := tx.auditKV(AuditSetKV, store, key, []Value{values[key]}); err != nil {
+				return err
+			}
+		}
+	}
+	batch := maxBulkParams / 2
+	for start := 0; start < len(keys); start += batch {
+		end := start + batch
+		if end > len(keys) {
+			end = len(keys)
+		}
+		placeholders := make([]string, 0, end-start)
+		args := make([]interface{}, 0, 2*(end-start))
+		for _, key := range keys[start:end] {
+			placeholders = append(placeholders, "(?, ?, NULL)")
+			args = append(args, key, kvArg(values[key]))
+		}
+		_, err := tx.tx.Exec(`INSERT OR REPLACE INTO `+store+` (Id, Value, Expires) VALUES `+
+			strings.Join(placeholders, ", ")+`;`, args...)
+		if err != nil {
+			return Fail("cannot set values: %s", err)
+		}
+	}
+	return nil
+}
+
+// GetMany returns the values for the keys in the store for values of the type like SetMany,
+// using one query for many keys. Keys that don't exist are missing in the result.
+func (db *MDB) GetMany(t FieldType, keys []int64) (map[int64]Value, error) {
+	if db.kvDisabled {
+		return nil, ErrKVDisabled
+	}
+	store, err := kvStore(t, false)
+	if err != nil {
+		return nil, err
+	}
+	result := make(map[int64]Value, len(keys))
+	for start := 0; start < len(keys); start += maxBulkParams {
+		end := start + maxBulkParams
+		if end > len(keys) {
+			end = len(keys)
+		}
+		args := make([]interface{}, 0, end-start+1)
+		for _, key := range keys[start:end] {
+			args = append(args, key)
+		}
+		args = append(args, time.Now().UnixNano())
+		rows, err := db.reader.Query(`SELECT Id,Value FROM `+store+` WHERE Id IN (?`+
+			strings.Repeat(",?", end-start-1)+`) AND `+kvLive+`;`, args...)
+		err = iterateKV(store, rows, err, func(key interface{}, value Value) bool {
+			n, _ := key.(int64)
+			result[n] = value
+			return true
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
@@ -0,0 +1,468 @@
+package minidb
+
+import (
+	"database/sql"
+	"strconv"
+	"time"
+)
+
+// ------------------------------------------------------------------------------
+// Buckets of the key-value store
+// ------------------------------------------------------------------------------
+
+// kvBucketStores are the internal tables of the buckets of the key-value store. Their primary
+// key consists of the bucket name and the key.
+var kvBucketStores = []string{"_KVBINT", "_KVBSTR", "_KVBBLOB", "_KVBDATE"}
+
+// Bucket is a named key-value store with int64 keys, which is separate from the flat key-value
+// store and from all other buckets. A bucket returned by MDB.Bucket writes each value in a
+// transaction of its own, while a bucket returned by Tx.Bucket reads and writes in the transaction.
+type Bucket struct {
+	mdb  *MDB
+	tx   *Tx
+	name string
+}
+
+// Bucket returns the bucket with the name. Buckets need not be created, a bucket exists as long
+// as it holds values.
+func (db *MDB) Bucket(name string) *Bucket {
+	return &Bucket{mdb: db, name: name}
+}
+
+// Bucket returns the bucket with the name, whose methods read and write in the transaction.
+func (tx *Tx) Bucket(name string) *Bucket {
+	return &Bucket{mdb: tx.mdb, tx: tx, name: name}
+}
+
+// Name returns the name of the bucket.
+func (b *Bucket) Name() string {
+	return b.name
+}
+
+// view returns the database to read the bucket from.
+func (b *Bucket) view() *MDB {
+	if b.tx != nil {
+		return b.tx.View()
+	}
+	return b.mdb
+}
+
+// write calls fn with the transaction of the bucket, or with a new transaction that is committed
+// if fn succeeds.
+func (b *Bucket) write(fn func(tx *Tx) error) error {
+	if b.mdb.kvDisabled {
+		return ErrKVDisabled
+	}
+	if b.tx != nil {
+		return fn(b.tx)
+	}
+	tx, err := b.mdb.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err := fn(tx); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// GetInt returns the int64 value for a key, 0 if the key doesn't exist.
+func (b *Bucket) GetInt(key int64) int64 {
+	n, _ := b.LookupInt(key)
+	return n
+}
+
+// LookupInt returns the int64 value for a key and true, or 0 and false if the key doesn't exist.
+func (b *Bucket) LookupInt(key int64) (int64, bool) {
+	view := b.view()
+	if view.kvDisabled {
+		return 0, false
+	}
+	var result sql.NullInt64
+	err := view.reader.QueryRow(`SELECT Value FROM _KVBINT WHERE Bucket=? AND Id=? AND `+kvLive,
+		b.name, key, time.Now().UnixNano()).Scan(&result)
+	if err != nil || !result.Valid {
+		return 0, false
+	}
+	return result.Int64, true
+}
+
+func (b *Bucket) fetchStr(key int64, store string) string {
+	s, _ := b.lookupStr(key, store)
+	return s
+}
+
+func (b *Bucket) lookupStr(key int64, store string) (string, bool) {
+	view := b.view()
+	if view.kvDisabled {
+		return "", false
+	}
+	var result sql.NullString
+	err := view.reader.QueryRow(`SELECT Value FROM `+store+` WHERE Bucket=? AND Id=? AND `+kvLive,
+		b.name, key, time.Now().UnixNano()).Scan(&result)
+	if err != nil || !result.Valid {
+		return "", false
+	}
+	return result.String, true
+}
+
+// GetStr returns the string value for a key, "" if the key doesn't exist.
+func (b *Bucket) GetStr(key int64) string {
+	return b.fetchStr(key, "_KVBSTR")
+}
+
+// GetBlob returns the blob value for a key, nil if the key doesn't exist.
+func (b *Bucket) GetBlob(key int64) []byte {
+	return []byte(b.fetchStr(key, "_KVBBLOB"))
+}
+
+// GetDate returns the time.Time value for a key, the zero time if the key doesn't exist.
+func (b *Bucket) GetDate(key int64) time.Time {
+	t, err := ParseTime(b.fetchStr(key, "_KVBDATE"))
+	if err != nil {
+		return time.Time{}
+	}
+	return t
+}
+
+// GetDateStr returns a date in RFC3339 string form, the empty string if the key doesn't exist.
+func (b *Bucket) GetDateStr(key int64) string {
+	return b.fetchStr(key, "_KVBDATE")
+}
+
+// LookupStr returns the string value for a key and true, or "" and false if the key doesn't exist.
+func (b *Bucket) LookupStr(key int64) (string, bool) {
+	return b.lookupStr(key, "_KVBSTR")
+}
+
+// LookupBlob returns the blob value for a key and true, or nil and false if the key doesn't exist.
+func (b *Bucket) LookupBlob(key int64) ([]byte, bool) {
+	s, ok := b.lookupStr(key, "_KVBBLOB")
+	if !ok {
+		return nil, false
+	}
+	return []byte(s), true
+}
+
+// LookupDate returns the time.Time value for a key and true, or the zero time and false if the
+// key doesn't exist.
+func (b *Bucket) LookupDate(key int64) (time.Time, bool) {
+	s, ok := b.lookupStr(key, "_KVBDATE")
+	if !ok {
+		return time.Time{}, false
+	}
+	t, err := ParseTime(s)
+	if err != nil {
+		return time.Time{}, true
+	}
+	return t, true
+}
+
+// set stores a value, which expires at the time given in Unix nanoseconds unless expires is nil.
+func (b *Bucket) set(store string, key int64, value interface{}, v Value, expires interface{}) error {
+	return b.write(func(tx *Tx) error {
+		if err := tx.auditBucket(AuditSetKV, store, b.name, key, []Value{v}); err != nil {
+			return err
+		}
+		_, err := tx.tx.Exec(`INSERT OR REPLACE INTO `+store+` (Bucket, Id, Value, Expires) VALUES (?, ?, ?, ?);`,
+			b.name, key, value, expires)
+		if err != nil {
+			return Fail("cannot set value for key %d in bucket '%s': %s", key, b.name, err)
+		}
+		return nil
+	})
+}
+
+// SetInt stores an int64 value by key.
+func (b *Bucket) SetInt(key int64, value int64) error {
+	return b.set("_KVBINT", key, value, NewInt(value), nil)
+}
+
+// SetStr stores a string value by key.
+func (b *Bucket) SetStr(key int64, value string) error {
+	return b.set("_KVBSTR", key, value, NewString(value), nil)
+}
+
+// SetBlob stores a byte array by key.
+func (b *Bucket) SetBlob(key int64, value []byte) error {
+	return b.set("_KVBBLOB", key, string(value), NewBytes(value), nil)
+}
+
+// SetDate stores a time.Time value by key.
+func (b *Bucket) SetDate(key int64, value time.Time) error {
+	return b.set("_KVBDATE", key, value.UTC().Format(time.RFC3339), NewDate(value), nil)
+}
+
+// SetDateStr stores a datetime in RFC3339 format by key. The correctness of the string is not validated.
+func (b *Bucket) SetDateStr(key int64, value string) error {
+	v, _ := rawToValue(value, DBDate)
+	return b.set("_KVBDATE", key, value, v, nil)
+}
+
+// SetIntTTL stores an int64 value by key that expires after the ttl, see Tx.SetIntTTL.
+func (b *Bucket) SetIntTTL(key int64, value int64, ttl time.Duration) error {
+	return b.set("_KVBINT", key, value, NewInt(value), expiry(ttl))
+}
+
+// SetStrTTL stores a string value by key that expires after the ttl, see Tx.SetIntTTL.
+func (b *Bucket) SetStrTTL(key int64, value string, ttl time.Duration) error {
+	return b.set("_KVBSTR", key, value, NewString(value), expiry(ttl))
+}
+
+// SetBlobTTL stores a byte array by key that expires after the ttl, see Tx.SetIntTTL.
+func (b *Bucket) SetBlobTTL(key int64, value []byte, ttl time.Duration) error {
+	return b.set("_KVBBLOB", key, string(value), NewBytes(value), expiry(ttl))
+}
+
+// SetDateTTL stores a time.Time value by key that expires after the ttl, see Tx.SetIntTTL.
+func (b *Bucket) SetDateTTL(key int64, value time.Time, ttl time.Duration) error {
+	return b.set("_KVBDATE", key, value.UTC().Format(time.RFC3339), NewDate(value), expiry(ttl))
+}
+
+func (b *Bucket) hasKey(key int64, store string) bool {
+	view := b.view()
+	if view.kvDisabled {
+		return false
+	}
+	var result int
+	err := view.reader.QueryRow(`SELECT EXISTS (SELECT 1 FROM `+store+` WHERE Bucket=? AND Id=? AND `+kvLive+`);`,
+		b.name, key, time.Now().UnixNano()).Scan(&result)
+	return err == nil && result != 0
+}
+
+// HasInt returns true if an int value is stored for the key, false otherwise.
+func (b *Bucket) HasInt(key int64) bool {
+	return b.hasKey(key, "_KVBINT")
+}
+
+// HasStr returns true if a string value is stored for the key, false otherwise.
+func (b *Bucket) HasStr(key int64) bool {
+	return b.hasKey(key, "_KVBSTR")
+}
+
+// HasBlob returns true if a byte array value is stored for the key, false otherwise.
+func (b *Bucket) HasBlob(key int64) bool {
+	return b.hasKey(key, "_KVBBLOB")
+}
+
+// HasDate returns true if a time.Time value is stored for the key, false otherwise.
+func (b *Bucket) HasDate(key int64) bool {
+	return b.hasKey(key, "_KVBDATE")
+}
+
+func (b *Bucket) delete(key int64, store string) error {
+	return b.write(func(tx *Tx) error {
+		if err := tx.auditBucket(AuditDeleteKV, store, b.name, key, nil); err != nil {
+			return err
+		}
+		if _, err := tx.tx.Exec(`DELETE FROM `+store+` WHERE Bucket=? AND Id=?;`, b.name, key); err != nil {
+			return Fail("cannot delete key %d in bucket '%s': %s", key, b.name, err)
+		}
+		return nil
+	})
+}
+
+// DeleteInt deletes the int value for the key. It has no effect if the key-value pair doesn't exist.
+func (b *Bucket) DeleteInt(key int64) error {
+	return b.delete(key, "_KVBINT")
+}
+
+// DeleteStr deletes the string value for the key. It has no effect if the key-value pair doesn't exist.
+func (b *Bucket) DeleteStr(key int64) error {
+	return b.delete(key, "_KVBSTR")
+}
+
+// DeleteBlob deletes the blob value for the key. It has no effect if the key-value pair doesn't exist.
+func (b *Bucket) DeleteBlob(key int64) error {
+	return b.delete(key, "_KVBBLOB")
+}
+
+// DeleteDate deletes the date value for the key. It has no effect if the key-value pair doesn't exist.
+func (b *Bucket) DeleteDate(key int64) error {
+	return b.delete(key, "_KVBDATE")
+}
+
+func (b *Bucket) list(store string) []int64 {
+	result := make([]int64, 0)
+	view := b.view()
+	if view.kvDisabled {
+		return result
+	}
+	rows, err := view.reader.Query(`SELECT Id FROM `+store+` WHERE Bucket=? AND `+kvLive+` ORDER BY Id;`,
+		b.name, time.Now().UnixNano())
+	if err != nil {
+		return result
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var n int64
+		if err := rows.Scan(&n); err == nil {
+			result = append(result, n)
+		}
+	}
+	return result
+}
+
+// ListInt lists the keys of all int values in the bucket in ascending order.
+func (b *Bucket) ListInt() []int64 {
+	return b.list("_KVBINT")
+}
+
+// ListStr lists the keys of all string values in the bucket in ascending order.
+func (b *Bucket) ListStr() []int64 {
+	return b.list("_KVBSTR")
+}
+
+// ListBlob lists the keys of all blob values in the bucket in ascending order.
+func (b *Bucket) ListBlob() []int64 {
+	return b.list("_KVBBLOB")
+}
+
+// ListDate lists the keys of all date values in the bucket in ascending order.
+func (b *Bucket) ListDate() []int64 {
+	return b.list("_KVBDATE")
+}
+
+// Clear deletes all values of the bucket, which other buckets and the flat key-value store keep.
+func (b *Bucket) Clear() error {
+	return b.write(func(tx *Tx) error {
+		for _, store := range kvBucketStores {
+			if tx.mdb.audit {
+				for _, key := range tx.Bucket(b.name).list(store) {
+					if err := tx.auditBucket(AuditDeleteKV, store, b.name, key, nil); err != nil {
+						return err
+					}
+				}
+			}
+			if _, err := tx.tx.Exec(`DELETE FROM `+store+` WHERE Bucket=?;`, b.name); err != nil {
+				return Fail("cannot clear bucket '%s': %s", b.name, err)
+			}
+		}
+		return nil
+	})
+}
+
+// ListBuckets returns the names of all buckets that hold values in ascending order.
+func (db *MDB) ListBuckets() []string {
+	result := make([]string, 0)
+	if db.kvDisabled {
+		return result
+	}
+	now := time.Now().UnixNano()
+	rows, err := db.reader.Query(`SELECT Bucket FROM _KVBINT WHERE `+kvLive+
+		` UNION SELECT Bucket FROM _KVBSTR WHERE `+kvLive+
+		` UNION SELECT Bucket FROM _KVBBLOB WHERE `+kvLive+
+		` UNION SELECT Bucket FROM _KVBDATE WHERE `+kvLive+` ORDER BY 1;`, now, now, now, now)
+	if err != nil {
+		return result
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err == nil {
+			result = append(result, name)
+		}
+	}
+	return result
+}
+
+// auditBucket records a change of a bucket like auditKV. The field of the entry is the name
+// of the bucket and the item is the key.
+func (tx *Tx) auditBucket(op AuditOp, store, bucket string, key int64, data []Value) error {
+	if !tx.mdb.audit {
+		return nil
+	}
+	var old []Value
+	var raw interface{}
+	err := tx.tx.QueryRow(`SELECT Value FROM `+store+` WHERE Bucket=? AND Id=?;`, bucket, key).Scan(&raw)
+	if err == nil {
+		if v, err := rawToValue(raw, kvStoreTypes[store]); err == nil {
+			old = []Value{v}
+		}
+	}
+	if op == AuditDeleteKV && old == nil {
+		return nil
+	}
+	return tx.audit(AuditEntry{Op: op, Table: store, Item: Item(key), Field: bucket, Old: old, New: data})
+}
+
+// getKVBucketData adds the values of all buckets to the data, see GetKVData.
+func (db *MDB) getKVBucketData(data *KVData) error {
+	data.Buckets = make(map[string]*KVData)
+	bucket := func(name string) *KVData {
+		if data.Buckets[name] == nil {
+			data.Buckets[name] = &KVData{Ints: make(map[int64]int64), Strs: make(map[int64]string),
+				Blobs: make(map[int64][]byte), Dates: make(map[int64]string)}
+		}
+		return data.Buckets[name]
+	}
+	for _, store := range kvBucketStores {
+		rows, err := db.reader.Query(`SELECT Bucket,Id,Value FROM `+store+` WHERE `+kvLive, time.Now().UnixNano())
+		if err != nil {
+			return Fail("cannot read bucket values: %s", err)
+		}
+		for rows.Next() {
+			var name, value string
+			var key int64
+			if err := rows.Scan(&name, &key, &value); err != nil {
+				rows.Close()
+				return Fail("cannot read bucket values: %s", err)
+			}
+			switch store {
+			case "_KVBINT":
+				n, _ := strconv.ParseInt(value, 10, 64)
+				bucket(name).Ints[key] = n
+			case "_KVBSTR":
+				bucket(name).Strs[key] = value
+			case "_KVBBLOB":
+				bucket(name).Blobs[key] = []byte(value)
+			case "_KVBDATE":
+				bucket(name).Dates[key] = value
+			}
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return Fail("cannot read bucket values: %s", err)
+		}
+	}
+	return nil
+}
+
+// importKVBucketData stores the values of the buckets of the data, see ImportKV.
+func (tx *Tx) importKVBucketData(data *KVData) error {
+	for name, values := range data.Buckets {
+		for key, value := range values.Dates {
+			if _, err := ParseTime(value); err != nil {
+				return Fail("invalid date for key %d in bucket '%s': %s", key, name, err)
+			}
+		}
+	}
+	for name, values := range data.Buckets {
+		if values == nil {
+			continue
+		}
+		b := tx.Bucket(name)
+		for key, value := range values.Ints {
+			if err := b.SetInt(key, value); err != nil {
+				return err
+			}
+		}
+		for key, value := range values.Strs {
+			if err := b.SetStr(key, value); err != nil {
+				return err
+			}
+		}
+		for key, value := range values.Blobs {
+			if err := b.SetBlob(key, value); err != nil {
+				return err
+			}
+		}
+		for key, value := range values.Dates {
+			if err := b.SetDateStr(key, value); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,91 @@
+package minidb
+
+import (
+	"time"
+)
+
+// ------------------------------------------------------------------------------
+// Listing the entries of the key-value store
+// ------------------------------------------------------------------------------
+
+// KVEntry is a key-value pair returned by DumpKV. Store is the name of the store as in the
+// JSON document of ExportKV, such as "int" for int values with int64 keys, "keystr" for string
+// values with string keys, or "bucketdate" for date values in buckets. Key holds int64 keys,
+// StrKey string keys, and Bucket the name of the bucket of an entry of a bucket. Float values
+// are strings in the shortest form that is parsed to the same float.
+type KVEntry struct {
+	Store  string `json:"store"`
+	Bucket string `json:"bucket,omitempty"`
+	Key    int64  `json:"key"`
+	StrKey string `json:"strkey,omitempty"`
+	Value  Value  `json:"value"`
+}
+
+// kvDumpStores are the stores listed by DumpKV in their order.
+var kvDumpStores = []struct {
+	name, table string
+	buckets     bool
+}{
+	{"int", "_KVINT", false}, {"str", "_KVSTR", false}, {"blob", "_KVBLOB", false},
+	{"date", "_KVDATE", false}, {"float", "_KVFLOAT", false},
+	{"keyint", "_KVSINT", false}, {"keystr", "_KVSSTR", false},
+	{"keyblob", "_KVSBLOB", false}, {"keydate", "_KVSDATE", false},
+	{"bucketint", "_KVBINT", true}, {"bucketstr", "_KVBSTR", true},
+	{"bucketblob", "_KVBBLOB", true}, {"bucketdate", "_KVBDATE", true},
+}
+
+// kvRawToValue converts a value read from a store of the key-value store.
+func kvRawToValue(store string, raw interface{}) (Value, error) {
+	if f, ok := raw.(float64); ok {
+		return floatValue(f), nil
+	}
+	return rawToValue(raw, kvStoreTypes[store])
+}
+
+// DumpKV returns all entries of all stores of the key-value store with their values, ordered
+// by store, bucket, and key. Expired entries are left out.
+func (db *MDB) DumpKV() ([]KVEntry, error) {
+	if db.kvDisabled {
+		return nil, ErrKVDisabled
+	}
+	entries := make([]KVEntry, 0)
+	now := time.Now().UnixNano()
+	for _, store := range kvDumpStores {
+		query := `SELECT '',Id,Value FROM ` + store.table + ` WHERE ` + kvLive + ` ORDER BY Id;`
+		if store.buckets {
+			query = `SELECT Bucket,Id,Value FROM ` + store.table + ` WHERE ` + kvLive + ` ORDER BY Bucket, Id;`
+		}
+		rows, err := db.reader.Query(query, now)
+		if err != nil {
+			return nil, Fail("cannot read key-value store: %s", err)
+		}
+		for rows.Next() {
+			var bucket string
+			var key, raw interface{}
+			if err := rows.Scan(&bucket, &key, &raw); err != nil {
+				rows.Close()
+				return nil, Fail("cannot read key-value store: %s", err)
+			}
+			value, err := kvRawToValue(store.table, raw)
+			if err != nil {
+				rows.Close()
+				return nil, err
+			}
+			entry := KVEntry{Store: store.name, Bucket: bucket, Value: value}
+			switch k := key.(type) {
+			case int64:
+				entry.Key = k
+			case string:
+				entry.StrKey = k
+			case []byte:
+				entry.StrKey = string(k)
+			}
+			entries = append(entries, entry)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return nil, Fail("cannot read key-value store: %s", err)
+		}
+	}
+	return entries, nil
+}
@@ -0,0 +1,122 @@
+package minidb
+
+import (
+	"database/sql"
+	"strconv"
+	"time"
+)
+
+// ------------------------------------------------------------------------------
+// Float values of the key-value store
+// ------------------------------------------------------------------------------
+
+// GetFloat returns the float64 value for a key, 0 if key doesn't exist.
+func (db *MDB) GetFloat(key int64) float64 {
+	f, _ := db.LookupFloat(key)
+	return f
+}
+
+// LookupFloat returns the float64 value for a key and true, or 0 and false if the key doesn't exist.
+func (db *MDB) LookupFloat(key int64) (float64, bool) {
+	if db.kvDisabled {
+		return 0, false
+	}
+	var result sql.NullFloat64
+	err := db.reader.QueryRow(`SELECT Value FROM _KVFLOAT WHERE Id=? AND `+kvLive, key, time.Now().UnixNano()).Scan(&result)
+	if err != nil || !result.Valid {
+		return 0, false
+	}
+	return result.Float64, true
+}
+
+// SetFloat stores a float64 value by key. NaN cannot be stored.
+func (tx *Tx) SetFloat(key int64, value float64) {
+	tx.setFloatValue(key, value, nil)
+}
+
+// SetFloatTTL stores a float64 value by key that expires after the ttl, see SetIntTTL.
+func (tx *Tx) SetFloatTTL(key int64, value float64, ttl time.Duration) {
+	tx.setFloatValue(key, value, expiry(ttl))
+}
+
+func (tx *Tx) setFloatValue(key int64, value float64, expires interface{}) {
+	if tx.mdb.kvDisabled {
+		return
+	}
+	tx.auditKV(AuditSetKV, "_KVFLOAT", key, []Value{floatValue(value)})
+	tx.tx.Exec("DELETE FROM _KVFLOAT WHERE Id=?", key)
+	tx.tx.Exec("INSERT INTO _KVFLOAT (Id, Value, Expires) VALUES (?, ?, ?)", key, value, expires)
+}
+
+// HasFloat returns true if a float value is stored for the key, false otherwise.
+func (db *MDB) HasFloat(key int64) bool {
+	return db.hasKey(key, "_KVFLOAT")
+}
+
+// DeleteFloat deletes the key and float value for given key. It has no effect if the key-value pair doesn't exist.
+func (tx *Tx) DeleteFloat(key int64) {
+	tx.deleteKV(key, "_KVFLOAT")
+}
+
+// ListFloat lists all float keys.
+func (db *MDB) ListFloat() []int64 {
+	return db.listKV("_KVFLOAT")
+}
+
+// ListFloatRange lists the float keys from from to to, both inclusive, in ascending order.
+func (db *MDB) ListFloatRange(from, to int64) []int64 {
+	return db.listKVRange("_KVFLOAT", from, to)
+}
+
+// FindFloatValues returns the keys of all float values from min to max, both inclusive, in
+// ascending order of the values.
+func (db *MDB) FindFloatValues(min, max float64) ([]int64, error) {
+	if db.kvDisabled {
+		return nil, ErrKVDisabled
+	}
+	result := make([]int64, 0)
+	rows, err := db.reader.Query(`SELECT Id FROM _KVFLOAT WHERE Value>=? AND Value<=? AND `+kvLive+` ORDER BY Value, Id;`,
+		min, max, time.Now().UnixNano())
+	if err != nil {
+		return nil, Fail("cannot search float values: %s", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var n int64
+		if err := rows.Scan(&n); err != nil {
+			return nil, Fail("cannot search float values: %s", err)
+		}
+		result = append(result, n)
+	}
+	return result, rows.Err()
+}
+
+// floatValue returns a float as string value, since values have no float type. It is used
+// to record float values in the audit log.
+func floatValue(f float64) Value {
+	return NewString(formatFloat(f))
+}
+
+// formatFloat returns the shortest representation of a float that is parsed to the same float.
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+// exportKVFloats returns all float values by key, see GetKVData.
+func (db *MDB) exportKVFloats() (map[int64]float64, error) {
+	result := make(map[int64]float64)
+	rows, err := db.reader.Query(`SELECT Id,Value FROM _KVFLOAT WHERE `+kvLive, time.Now().UnixNano())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var key int64
+		var value float64
+		if err := rows.Scan(&key, &value); err != nil {
+			return nil, err
+		}
+		result[key] = value
+	}
+	return result, rows.Err()
+}
@@ -0,0 +1,158 @@
+package minidb
+
+import (
+	"database/sql"
+	"math"
+	"time"
+	"unicode/utf8"
+)
+
+// ------------------------------------------------------------------------------
+// Iteration over the key-value store
+// ------------------------------------------------------------------------------
+
+// kvStore returns the internal table of the key-value store for values of the type, which must
+// be DBInt, DBString, DBBlob, or DBDate, with int64 keys or with string keys.
+func kvStore(t FieldType, stringKeys bool) (string, error) {
+	stores := map[FieldType]string{DBInt: "_KVINT", DBString: "_KVSTR", DBBlob: "_KVBLOB", DBDate: "_KVDATE"}
+	if stringKeys {
+		stores = map[FieldType]string{DBInt: "_KVSINT", DBString: "_KVSSTR", DBBlob: "_KVSBLOB", DBDate: "_KVSDATE"}
+	}
+	store, ok := stores[t]
+	if !ok {
+		return "", Fail("the key-value store has no values of type %d", t)
+	}
+	return store, nil
+}
+
+// iterateKV calls fn with the raw key and the value of each row until it returns false.
+func iterateKV(store string, rows *sql.Rows, err error, fn func(key interface{}, value Value) bool) error {
+	if err != nil {
+		return Fail("cannot read key-value store: %s", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var key, raw interface{}
+		if err := rows.Scan(&key, &raw); err != nil {
+			return Fail("cannot read key-value store: %s", err)
+		}
+		value, err := kvRawToValue(store, raw)
+		if err != nil {
+			return err
+		}
+		if !fn(key, value) {
+			break
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return Fail("cannot read key-value store: %s", err)
+	}
+	return nil
+}
+
+// IterateKV calls fn for the key and value of each entry of the store for values of the type
+// whose key lies between fromKey and toKey, both inclusive, in ascending order of the keys until
+// fn returns false. The type must be DBInt, DBString, DBBlob, or DBDate. Unlike listing the keys
+// and getting their values, it reads all entries with a single query.
+func (db *MDB) IterateKV(t FieldType, fromKey, toKey int64, fn func(key int64, value Value) bool) error {
+	if db.kvDisabled {
+		return ErrKVDisabled
+	}
+	store, err := kvStore(t, false)
+	if err != nil {
+		return err
+	}
+	rows, err := db.reader.Query(`SELECT Id,Value FROM `+store+` WHERE Id>=? AND Id<=? AND `+kvLive+` ORDER BY Id;`,
+		fromKey, toKey, time.Now().UnixNano())
+	return iterateKV(store, rows, err, func(key interface{}, value Value) bool {
+		n, _ := key.(int64)
+		return fn(n, value)
+	})
+}
+
+// IterateKVPrefix calls fn for the key and value of each entry with a string key that starts
+// with the prefix like IterateKV. The empty prefix selects all entries of the store.
+func (db *MDB) IterateKVPrefix(t FieldType, prefix string, fn func(key string, value Value) bool) error {
+	if db.kvDisabled {
+		return ErrKVDisabled
+	}
+	store, err := kvStore(t, true)
+	if err != nil {
+		return err
+	}
+	rows, err := db.reader.Query(`SELECT Id,Value FROM `+store+` WHERE substr(Id,1,?)=? AND `+kvLive+` ORDER BY Id;`,
+		utf8.RuneCountInString(prefix), prefix, time.Now().UnixNano())
+	return iterateKV(store, rows, err, func(key interface{}, value Value) bool {
+		switch k := key.(type) {
+		case string:
+			return fn(k, value)
+		case []byte:
+			return fn(string(k), value)
+		}
+		return true
+	})
+}
+
+// allKV returns the values of all entries of the store for values of the type with int64 keys.
+func (db *MDB) allKV(t FieldType) (map[int64]Value, error) {
+	result := make(map[int64]Value)
+	err := db.IterateKV(t, math.MinInt64, math.MaxInt64, func(key int64, value Value) bool {
+		result[key] = value
+		return true
+	})
+	return result, err
+}
+
+// GetAllInt returns all int values by key.
+func (db *MDB) GetAllInt() (map[int64]int64, error) {
+	values, err := db.allKV(DBInt)
+	if err != nil {
+		return nil, err
+	}
+	result := make(map[int64]int64, len(values))
+	for key, v := range values {
+		result[key] = v.Int()
+	}
+	return result, nil
+}
+
+// GetAllStr returns all string values by key.
+func (db *MDB) GetAllStr() (map[int64]string, error) {
+	values, err := db.allKV(DBString)
+	if err != nil {
+		return nil, err
+	}
+	result := make(map[int64]string, len(values))
+	for key, v := range values {
+		result[key] = v.String()
+	}
+	return result, nil
+}
+
+// GetAllBlob returns all blob values by key.
+func (db *MDB) GetAllBlob() (map[int64][]byte, error) {
+	values, err := db.allKV(DBBlob)
+	if err != nil {
+		return nil, err
+	}
+	result := make(map[int64][]byte, len(values))
+	for key, v := range values {
+		result[key] = v.Bytes()
+	}
+	return result, nil
+}
+
+// GetAllDate returns all date values by key. Values that are not valid dates are left out.
+func (db *MDB) GetAllDate() (map[int64]time.Time, error) {
+	values, err := db.allKV(DBDate)
+	if err != nil {
+		return nil, err
+	}
+	result := make(map[int64]time.Time, len(values))
+	for key, v := range values {
+		if t, err := ParseTime(v.String()); err == nil {
+			result[key] = t
+		}
+	}
+	return result, nil
+}
@@ -2,6 +2,8 @@ package minidb
 
 import (
 	"database/sql"
+	"encoding/json"
+	"io"
 	"time"
 )
 
@@ -9,25 +11,65 @@ import (
 // Key-Value Store
 // ------------------------------------------------------------------------------
 
+// The stores with int64 keys are _KVINT, _KVSTR, _KVBLOB, and _KVDATE. The stores with string
+// keys are _KVSINT, _KVSSTR, _KVSBLOB, and _KVSDATE. The internal functions take the key as
+// int64 or string accordingly.
+
 // GetInt returns the int64 value for a key, 0 if key doesn't exist.
 func (db *MDB) GetInt(key int64) int64 {
-	row := db.base.QueryRow(`SELECT Value FROM _KVINT WHERE Id=?`, key)
+	return db.fetchInt(key, "_KVINT")
+}
+
+func (db *MDB) fetchInt(key interface{}, store string) int64 {
+	n, _ := db.lookupInt(key, store)
+	return n
+}
+
+// lookupInt returns the int64 value for a key and true, or 0 and false if the key doesn't exist.
+func (db *MDB) lookupInt(key interface{}, store string) (int64, bool) {
+	if db.kvDisabled {
+		return 0, false
+	}
+	row := db.reader.QueryRow(`SELECT Value FROM `+store+` WHERE Id=? AND `+kvLive, key, time.Now().UnixNano())
 	var intResult sql.NullInt64
 	err := row.Scan(&intResult)
 	if err != nil || !intResult.Valid {
-		return 0
+		return 0, false
 	}
-	return intResult.Int64
+	return intResult.Int64, true
+}
+
+func (db *MDB) fetchStr(key interface{}, store string) string {
+	s, _ := db.lookupStr(key, store)
+	return s
 }
 
-func (db *MDB) fetchStr(key int64, store string) string {
-	row := db.base.QueryRow(`SELECT Value FROM `+store+` WHERE Id=?`, key)
+// lookupStr returns the value for a key as string and true, or "" and false if the key doesn't exist.
+func (db *MDB) lookupStr(key interface{}, store string) (string, bool) {
+	if db.kvDisabled {
+		return "", false
+	}
+	row := db.reader.QueryRow(`SELECT Value FROM `+store+` WHERE Id=? AND `+kvLive, key, time.Now().UnixNano())
 	var strResult sql.NullString
 	err := row.Scan(&strResult)
 	if err != nil || !strResult.Valid {
-		return ""
+		return "", false
+	}
+	return strResult.String, true
+}
+
+// lookupDate returns the date for a key and true, or the zero time and false if the key doesn't
+// exist. The zero time is also returned if the stored date is invalid.
+func (db *MDB) lookupDate(key interface{}, store string) (time.Time, bool) {
+	s, ok := db.lookupStr(key, store)
+	if !ok {
+		return time.Time{}, false
+	}
+	t, err := ParseTime(s)
+	if err != nil {
+		return time.Time{}, true
 	}
-	return strResult.String
+	return t, true
 }
 
 // GetStr returns the string value for a key, "" if key doesn't exist.
@@ -56,45 +98,91 @@ func (db *MDB) GetDateStr(key int64) string {
 	return db.fetchStr(key, "_KVDATE")
 }
 
+// LookupInt returns the int64 value for a key and true, or 0 and false if the key doesn't exist.
+// Unlike GetInt, it distinguishes a missing key from a stored 0.
+func (db *MDB) LookupInt(key int64) (int64, bool) {
+	return db.lookupInt(key, "_KVINT")
+}
+
+// LookupStr returns the string value for a key and true, or "" and false if the key doesn't exist.
+func (db *MDB) LookupStr(key int64) (string, bool) {
+	return db.lookupStr(key, "_KVSTR")
+}
+
+// LookupBlob returns the blob value for a key and true, or nil and false if the key doesn't exist.
+func (db *MDB) LookupBlob(key int64) ([]byte, bool) {
+	s, ok := db.lookupStr(key, "_KVBLOB")
+	if !ok {
+		return nil, false
+	}
+	return []byte(s), true
+}
+
+// LookupDate returns the time.Time value for a key and true, or the zero time and false if the
+// key doesn't exist.
+func (db *MDB) LookupDate(key int64) (time.Time, bool) {
+	return db.lookupDate(key, "_KVDATE")
+}
+
 // SetInt stores an int64 value by key.
 func (tx *Tx) SetInt(key int64, value int64) {
-	tx.tx.Exec("DELETE FROM _KVINT WHERE Id=?", key)
-	tx.tx.Exec("INSERT INTO _KVINT (Id, Value) VALUES (?, ?)", key, value)
+	tx.setIntValue("_KVINT", key, value, nil)
 }
 
-func (tx *Tx) setStrValue(store string, key int64, value string) {
+// setIntValue stores an int64 value, which expires at the time given in Unix nanoseconds
+// unless expires is nil.
+func (tx *Tx) setIntValue(store string, key interface{}, value int64, expires interface{}) {
+	if tx.mdb.kvDisabled {
+		return
+	}
+	tx.auditKV(AuditSetKV, store, key, []Value{NewInt(value)})
 	tx.tx.Exec("DELETE FROM "+store+" WHERE Id=?", key)
-	tx.tx.Exec("INSERT INTO "+store+" (Id, Value) VALUES (?, ?)", key, value)
+	tx.tx.Exec("INSERT INTO "+store+" (Id, Value, Expires) VALUES (?, ?, ?)", key, value, expires)
+}
+
+// setStrValue stores a value as string like setIntValue.
+func (tx *Tx) setStrValue(store string, key interface{}, value string, expires interface{}) {
+	if tx.mdb.kvDisabled {
+		return
+	}
+	if v, err := rawToValue(value, kvStoreTypes[store]); err == nil {
+		tx.auditKV(AuditSetKV, store, key, []Value{v})
+	}
+	tx.tx.Exec("DELETE FROM "+store+" WHERE Id=?", key)
+	tx.tx.Exec("INSERT INTO "+store+" (Id, Value, Expires) VALUES (?, ?, ?)", key, value, expires)
 }
 
 // SetStr stores a string value by key.
 func (tx *Tx) SetStr(key int64, value string) {
-	tx.setStrValue("_KVSTR", key, value)
+	tx.setStrValue("_KVSTR", key, value, nil)
 }
 
 // SetBlob stores a byte array by key.
 func (tx *Tx) SetBlob(key int64, value []byte) {
-	tx.setStrValue("_KVBLOB", key, string(value))
+	tx.setStrValue("_KVBLOB", key, string(value), nil)
 }
 
 // SetDate stores a time.Time value by key.
 func (tx *Tx) SetDate(key int64, value time.Time) {
-	tx.setStrValue("_KVDATE", key, value.UTC().Format(time.RFC3339))
+	tx.setStrValue("_KVDATE", key, value.UTC().Format(time.RFC3339), nil)
 }
 
 // SetDateStr stores a datetime in RFC3339 format by key. The correctness of the string is not validated.
 // Use this function in combination with GetDateStr to prevent unnecessary conversions.
 func (tx *Tx) SetDateStr(key int64, value string) {
-	tx.setStrValue("_KVDATE", key, value)
+	tx.setStrValue("_KVDATE", key, value, nil)
 }
 
-func (db *MDB) hasKey(key int64, store string) bool {
+func (db *MDB) hasKey(key interface{}, store string) bool {
+	if db.kvDisabled {
+		return false
+	}
 	var result int
-	err := db.base.QueryRow(`SELECT EXISTS (SELECT 1 FROM `+store+` WHERE Id=?);`, key).Scan(&result)
+	err := db.reader.QueryRow(`SELECT EXISTS (SELECT 1 FROM `+store+` WHERE Id=? AND `+kvLive+`);`, key, time.Now().UnixNano()).Scan(&result)
 	if err != nil {
 		return false
 	}
-	return true
+	return result != 0
 }
 
 // HasInt returns true if an int value is stored for the key, false otherwise.
@@ -117,7 +205,11 @@ func (db *MDB) HasDate(key int64) bool {
 	return db.hasKey(key, "_KVDATE")
 }
 
-func (tx *Tx) deleteKV(key int64, store string) {
+func (tx *Tx) deleteKV(key interface{}, store string) {
+	if tx.mdb.kvDisabled {
+		return
+	}
+	tx.auditKV(AuditDeleteKV, store, key, nil)
 	tx.tx.Exec(`DELETE FROM `+store+` WHERE Id=?;`, key)
 }
 
@@ -143,7 +235,10 @@ func (tx *Tx) DeleteDate(key int64) {
 
 func (db *MDB) listKV(store string) []int64 {
 	result := make([]int64, 0)
-	rows, err := db.base.Query(`SELECT Id FROM ` + store)
+	if db.kvDisabled {
+		return result
+	}
+	rows, err := db.reader.Query(`SELECT Id FROM `+store+` WHERE `+kvLive, time.Now().UnixNano())
 	defer rows.Close()
 	if err != nil {
 		return result
@@ -176,3 +271,470 @@ func (db *MDB) ListBlob() []int64 {
 func (db *MDB) ListDate() []int64 {
 	return db.listKV("_KVDATE")
 }
+
+func (db *MDB) listKVRange(store string, from, to int64) []int64 {
+	result := make([]int64, 0)
+	if db.kvDisabled {
+		return result
+	}
+	rows, err := db.reader.Query(`SELECT Id FROM `+store+` WHERE Id>=? AND Id<=? AND `+kvLive+` ORDER BY Id;`, from, to, time.Now().UnixNano())
+	if err != nil {
+		return result
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var n int64
+		if err := rows.Scan(&n); err == nil {
+			result = append(result, n)
+		}
+	}
+	return result
+}
+
+// ListIntRange lists the int keys from from to to, both inclusive, in ascending order.
+func (db *MDB) ListIntRange(from, to int64) []int64 {
+	return db.listKVRange("_KVINT", from, to)
+}
+
+// ListStrRange lists the string keys from from to to, both inclusive, in ascending order.
+func (db *MDB) ListStrRange(from, to int64) []int64 {
+	return db.listKVRange("_KVSTR", from, to)
+}
+
+// ListBlobRange lists the blob keys from from to to, both inclusive, in ascending order.
+func (db *MDB) ListBlobRange(from, to int64) []int64 {
+	return db.listKVRange("_KVBLOB", from, to)
+}
+
+// ListDateRange lists the date keys from from to to, both inclusive, in ascending order.
+func (db *MDB) ListDateRange(from, to int64) []int64 {
+	return db.listKVRange("_KVDATE", from, to)
+}
+
+// FindStrValue returns the keys of all string values that match the pattern, in ascending order.
+// The pattern uses the same syntax as field queries, where % matches any sequence of characters
+// and _ matches one character. Like in queries, the match is case-insensitive for ASCII characters.
+func (db *MDB) FindStrValue(pattern string) ([]int64, error) {
+	if db.kvDisabled {
+		return nil, ErrKVDisabled
+	}
+	result := make([]int64, 0)
+	rows, err := db.reader.Query(`SELECT Id FROM _KVSTR WHERE Value LIKE ? AND `+kvLive+` ORDER BY Id;`, pattern, time.Now().UnixNano())
+	if err != nil {
+		return nil, Fail("cannot search string values: %s", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var n int64
+		if err := rows.Scan(&n); err != nil {
+			return nil, Fail("cannot search string values: %s", err)
+		}
+		result = append(result, n)
+	}
+	return result, rows.Err()
+}
+
+// GetIntKey returns the int64 value for a string key, 0 if the key doesn't exist.
+// String keys are separate from int64 keys, so GetIntKey("1") and GetInt(1) return different values.
+func (db *MDB) GetIntKey(key string) int64 {
+	return db.fetchInt(key, "_KVSINT")
+}
+
+// GetStrKey returns the string value for a string key, "" if the key doesn't exist.
+func (db *MDB) GetStrKey(key string) string {
+	return db.fetchStr(key, "_KVSSTR")
+}
+
+// GetBlobKey returns the blob value for a string key, nil if the key doesn't exist.
+func (db *MDB) GetBlobKey(key string) []byte {
+	return []byte(db.fetchStr(key, "_KVSBLOB"))
+}
+
+// GetDateKey returns the time.Time value for a string key, January 1, year 1, 00:00:00.000000000 UTC
+// if the key doesn't exist.
+func (db *MDB) GetDateKey(key string) time.Time {
+	t, err := ParseTime(db.fetchStr(key, "_KVSDATE"))
+	if err != nil {
+		return time.Time{}
+	}
+	return t
+}
+
+// GetDateStrKey returns the date for a string key in RFC3339 string form, the empty string if the key doesn't exist.
+func (db *MDB) GetDateStrKey(key string) string {
+	return db.fetchStr(key, "_KVSDATE")
+}
+
+// LookupIntKey returns the int64 value for a string key and true, or 0 and false if the key doesn't exist.
+func (db *MDB) LookupIntKey(key string) (int64, bool) {
+	return db.lookupInt(key, "_KVSINT")
+}
+
+// LookupStrKey returns the string value for a string key and true, or "" and false if the key doesn't exist.
+func (db *MDB) LookupStrKey(key string) (string, bool) {
+	return db.lookupStr(key, "_KVSSTR")
+}
+
+// LookupBlobKey returns the blob value for a string key and true, or nil and false if the key doesn't exist.
+func (db *MDB) LookupBlobKey(key string) ([]byte, bool) {
+	s, ok := db.lookupStr(key, "_KVSBLOB")
+	if !ok {
+		return nil, false
+	}
+	return []byte(s), true
+}
+
+// LookupDateKey returns the time.Time value for a string key and true, or the zero time and false
+// if the key doesn't exist.
+func (db *MDB) LookupDateKey(key string) (time.Time, bool) {
+	return db.lookupDate(key, "_KVSDATE")
+}
+
+// SetIntKey stores an int64 value by string key.
+func (tx *Tx) SetIntKey(key string, value int64) {
+	tx.setIntValue("_KVSINT", key, value, nil)
+}
+
+// SetStrKey stores a string value by string key.
+func (tx *Tx) SetStrKey(key string, value string) {
+	tx.setStrValue("_KVSSTR", key, value, nil)
+}
+
+// SetBlobKey stores a byte array by string key.
+func (tx *Tx) SetBlobKey(key string, value []byte) {
+	tx.setStrValue("_KVSBLOB", key, string(value), nil)
+}
+
+// SetDateKey stores a time.Time value by string key.
+func (tx *Tx) SetDateKey(key string, value time.Time) {
+	tx.setStrValue("_KVSDATE", key, value.UTC().Format(time.RFC3339), nil)
+}
+
+// SetDateStrKey stores a datetime in RFC3339 format by string key. The correctness of the string is not validated.
+func (tx *Tx) SetDateStrKey(key string, value string) {
+	tx.setStrValue("_KVSDATE", key, value, nil)
+}
+
+// HasIntKey returns true if an int value is stored for the string key, false otherwise.
+func (db *MDB) HasIntKey(key string) bool {
+	return db.hasKey(key, "_KVSINT")
+}
+
+// HasStrKey returns true if a string value is stored for the string key, false otherwise.
+func (db *MDB) HasStrKey(key string) bool {
+	return db.hasKey(key, "_KVSSTR")
+}
+
+// HasBlobKey returns true if a byte array value is stored for the string key, false otherwise.
+func (db *MDB) HasBlobKey(key string) bool {
+	return db.hasKey(key, "_KVSBLOB")
+}
+
+// HasDateKey returns true if a time.Time value is stored for the string key, false otherwise.
+func (db *MDB) HasDateKey(key string) bool {
+	return db.hasKey(key, "_KVSDATE")
+}
+
+// DeleteIntKey deletes the int value for the string key. It has no effect if the key-value pair doesn't exist.
+func (tx *Tx) DeleteIntKey(key string) {
+	tx.deleteKV(key, "_KVSINT")
+}
+
+// DeleteStrKey deletes the string value for the string key. It has no effect if the key-value pair doesn't exist.
+func (tx *Tx) DeleteStrKey(key string) {
+	tx.deleteKV(key, "_KVSSTR")
+}
+
+// DeleteBlobKey deletes the blob value for the string key. It has no effect if the key-value pair doesn't exist.
+func (tx *Tx) DeleteBlobKey(key string) {
+	tx.deleteKV(key, "_KVSBLOB")
+}
+
+// DeleteDateKey deletes the date value for the string key. It has no effect if the key-value pair doesn't exist.
+func (tx *Tx) DeleteDateKey(key string) {
+	tx.deleteKV(key, "_KVSDATE")
+}
+
+func (db *MDB) listKVKeys(store string) []string {
+	result := make([]string, 0)
+	if db.kvDisabled {
+		return result
+	}
+	rows, err := db.reader.Query(`SELECT Id FROM `+store+` WHERE `+kvLive+` ORDER BY Id;`, time.Now().UnixNano())
+	if err != nil {
+		return result
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var key string
+		if err := rows.Scan(&key); err == nil {
+			result = append(result, key)
+		}
+	}
+	return result
+}
+
+// ListIntKeys lists the string keys of all int values in ascending order.
+func (db *MDB) ListIntKeys() []string {
+	return db.listKVKeys("_KVSINT")
+}
+
+// ListStrKeys lists the string keys of all string values in ascending order.
+func (db *MDB) ListStrKeys() []string {
+	return db.listKVKeys("_KVSSTR")
+}
+
+// ListBlobKeys lists the string keys of all blob values in ascending order.
+func (db *MDB) ListBlobKeys() []string {
+	return db.listKVKeys("_KVSBLOB")
+}
+
+// ListDateKeys lists the string keys of all date values in ascending order.
+func (db *MDB) ListDateKeys() []string {
+	return db.listKVKeys("_KVSDATE")
+}
+
+// KVData holds the contents of all key-value stores. It is written by ExportKV and read by ImportKV.
+// The float values, the values with string keys, and the buckets are omitted from the JSON document
+// if there are none.
+type KVData struct {
+	Ints     map[int64]int64    `json:"int"`
+	Strs     map[int64]string   `json:"str"`
+	Blobs    map[int64][]byte   `json:"blob"`
+	Dates    map[int64]string   `json:"date"`
+	Floats   map[int64]float64  `json:"float,omitempty"`
+	KeyInts  map[string]int64   `json:"keyint,omitempty"`
+	KeyStrs  map[string]string  `json:"keystr,omitempty"`
+	KeyBlobs map[string][]byte  `json:"keyblob,omitempty"`
+	KeyDates map[string]string  `json:"keydate,omitempty"`
+	Buckets  map[string]*KVData `json:"buckets,omitempty"`
+}
+
+func (db *MDB) exportKVStrings(store string) (map[int64]string, error) {
+	result := make(map[int64]string)
+	rows, err := db.reader.Query(`SELECT Id,Value FROM `+store+` WHERE `+kvLive, time.Now().UnixNano())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var key int64
+		var value string
+		if err := rows.Scan(&key, &value); err != nil {
+			return nil, err
+		}
+		result[key] = value
+	}
+	return result, rows.Err()
+}
+
+func (db *MDB) exportKVKeyStrings(store string) (map[string]string, error) {
+	result := make(map[string]string)
+	rows, err := db.reader.Query(`SELECT Id,Value FROM `+store+` WHERE `+kvLive, time.Now().UnixNano())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var key, value string
+		if err := rows.Scan(&key, &value); err != nil {
+			return nil, err
+		}
+		result[key] = value
+	}
+	return result, rows.Err()
+}
+
+// GetKVData returns the contents of all key-value stores.
+func (db *MDB) GetKVData() (*KVData, error) {
+	if db.kvDisabled {
+		return nil, ErrKVDisabled
+	}
+	data := &KVData{Ints: make(map[int64]int64), Blobs: make(map[int64][]byte)}
+	rows, err := db.reader.Query(`SELECT Id,Value FROM _KVINT WHERE `+kvLive, time.Now().UnixNano())
+	if err != nil {
+		return nil, Fail("cannot read int values: %s", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var key, value int64
+		if err := rows.Scan(&key, &value); err != nil {
+			return nil, Fail("cannot read int values: %s", err)
+		}
+		data.Ints[key] = value
+	}
+	if err := rows.Err(); err != nil {
+		return nil, Fail("cannot read int values: %s", err)
+	}
+	if data.Strs, err = db.exportKVStrings("_KVSTR"); err != nil {
+		return nil, Fail("cannot read string values: %s", err)
+	}
+	blobs, err := db.exportKVStrings("_KVBLOB")
+	if err != nil {
+		return nil, Fail("cannot read blob values: %s", err)
+	}
+	for key, value := range blobs {
+		data.Blobs[key] = []byte(value)
+	}
+	if data.Dates, err = db.exportKVStrings("_KVDATE"); err != nil {
+		return nil, Fail("cannot read date values: %s", err)
+	}
+	if data.Floats, err = db.exportKVFloats(); err != nil {
+		return nil, Fail("cannot read float values: %s", err)
+	}
+	if err := db.getKVKeyData(data); err != nil {
+		return nil, err
+	}
+	if err := db.getKVBucketData(data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// getKVKeyData adds the values with string keys to the data.
+func (db *MDB) getKVKeyData(data *KVData) error {
+	data.KeyInts = make(map[string]int64)
+	data.KeyBlobs = make(map[string][]byte)
+	rows, err := db.reader.Query(`SELECT Id,Value FROM _KVSINT WHERE `+kvLive, time.Now().UnixNano())
+	if err != nil {
+		return Fail("cannot read int values: %s", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var key string
+		var value int64
+		if err := rows.Scan(&key, &value); err != nil {
+			return Fail("cannot read int values: %s", err)
+		}
+		data.KeyInts[key] = value
+	}
+	if err := rows.Err(); err != nil {
+		return Fail("cannot read int values: %s", err)
+	}
+	if data.KeyStrs, err = db.exportKVKeyStrings("_KVSSTR"); err != nil {
+		return Fail("cannot read string values: %s", err)
+	}
+	blobs, err := db.exportKVKeyStrings("_KVSBLOB")
+	if err != nil {
+		return Fail("cannot read blob values: %s", err)
+	}
+	for key, value := range blobs {
+		data.KeyBlobs[key] = []byte(value)
+	}
+	if data.KeyDates, err = db.exportKVKeyStrings("_KVSDATE"); err != nil {
+		return Fail("cannot read date values: %s", err)
+	}
+	return nil
+}
+
+// ExportKV writes the contents of all key-value stores as a JSON document to w.
+// Blobs are Base64 encoded and dates are stored in RFC3339 format. Expired values are left out
+// and the expiry times of the others are not exported.
+func (db *MDB) ExportKV(w io.Writer) error {
+	data, err := db.GetKVData()
+	if err != nil {
+		return err
+	}
+	return json.NewEncoder(w).Encode(data)
+}
+
+// ImportKV reads a JSON document written by ExportKV and stores its key-value pairs.
+// Existing values with the same keys are overwritten, other values are not changed.
+func (tx *Tx) ImportKV(r io.Reader) error {
+	if tx.mdb.kvDisabled {
+		return ErrKVDisabled
+	}
+	var data KVData
+	if err := json.NewDecoder(r).Decode(&data); err != nil {
+		return Fail("invalid key-value data: %s", err)
+	}
+	for key, value := range data.Dates {
+		if _, err := ParseTime(value); err != nil {
+			return Fail("invalid date for key %d: %s", key, err)
+		}
+	}
+	for key, value := range data.KeyDates {
+		if _, err := ParseTime(value); err != nil {
+			return Fail("invalid date for key '%s': %s", key, err)
+		}
+	}
+	for key, value := range data.Ints {
+		if err := tx.auditKV(AuditSetKV, "_KVINT", key, []Value{NewInt(value)}); err != nil {
+			return err
+		}
+		if _, err := tx.tx.Exec(`INSERT OR REPLACE INTO _KVINT (Id, Value) VALUES (?, ?);`, key, value); err != nil {
+			return Fail("cannot import int value for key %d: %s", key, err)
+		}
+	}
+	stores := []struct {
+		name   string
+		values map[int64]string
+	}{{"_KVSTR", data.Strs}, {"_KVDATE", data.Dates}}
+	for _, store := range stores {
+		for key, value := range store.values {
+			v, _ := rawToValue(value, kvStoreTypes[store.name])
+			if err := tx.auditKV(AuditSetKV, store.name, key, []Value{v}); err != nil {
+				return err
+			}
+			if _, err := tx.tx.Exec(`INSERT OR REPLACE INTO `+store.name+` (Id, Value) VALUES (?, ?);`, key, value); err != nil {
+				return Fail("cannot import value for key %d: %s", key, err)
+			}
+		}
+	}
+	for key, value := range data.Blobs {
+		if err := tx.auditKV(AuditSetKV, "_KVBLOB", key, []Value{NewBytes(value)}); err != nil {
+			return err
+		}
+		if _, err := tx.tx.Exec(`INSERT OR REPLACE INTO _KVBLOB (Id, Value) VALUES (?, ?);`, key, string(value)); err != nil {
+			return Fail("cannot import blob value for key %d: %s", key, err)
+		}
+	}
+	for key, value := range data.Floats {
+		if err := tx.auditKV(AuditSetKV, "_KVFLOAT", key, []Value{floatValue(value)}); err != nil {
+			return err
+		}
+		if _, err := tx.tx.Exec(`INSERT OR REPLACE INTO _KVFLOAT (Id, Value) VALUES (?, ?);`, key, value); err != nil {
+			return Fail("cannot import float value for key %d: %s", key, err)
+		}
+	}
+	if err := tx.importKVKeyData(&data); err != nil {
+		return err
+	}
+	return tx.importKVBucketData(&data)
+}
+
+// importKVKeyData stores the values with string keys of the data.
+func (tx *Tx) importKVKeyData(data *KVData) error {
+	for key, value := range data.KeyInts {
+		if err := tx.auditKV(AuditSetKV, "_KVSINT", key, []Value{NewInt(value)}); err != nil {
+			return err
+		}
+		if _, err := tx.tx.Exec(`INSERT OR REPLACE INTO _KVSINT (Id, Value) VALUES (?, ?);`, key, value); err != nil {
+			return Fail("cannot import int value for key '%s': %s", key, err)
+		}
+	}
+	stores := []struct {
+		name   string
+		values map[string]string
+	}{{"_KVSSTR", data.KeyStrs}, {"_KVSDATE", data.KeyDates}}
+	for _, store := range stores {
+		for key, value := range store.values {
+			v, _ := rawToValue(value, kvStoreTypes[store.name])
+			if err := tx.auditKV(AuditSetKV, store.name, key, []Value{v}); err != nil {
+				return err
+			}
+			if _, err := tx.tx.Exec(`INSERT OR REPLACE INTO `+store.name+` (Id, Value) VALUES (?, ?);`, key, value); err != nil {
+				return Fail("cannot import value for key '%s': %s", key, err)
+			}
+		}
+	}
+	for key, value := range data.KeyBlobs {
+		if err := tx.auditKV(AuditSetKV, "_KVSBLOB", key, []Value{NewBytes(value)}); err != nil {
+			return err
+		}
+		if _, err := tx.tx.Exec(`INSERT OR REPLACE INTO _KVSBLOB (Id, Value) VALUES (?, ?);`, key, string(value)); err != nil {
+			return Fail("cannot import blob value for key '%s': %s", key, err)
+		}
+	}
+	return nil
+}
@@ -1,9 +1,11 @@
 package minidb
 
 import (
+	"bytes"
 	"io/ioutil"
 	"math/rand"
 	"os"
+	"strings"
 	"testing"
 	"time"
 )
@@ -125,3 +127,505 @@ func TestKVStore(t *testing.T) {
 	db.Close()
 	os.Remove(tmp.Name())
 }
+
+func TestKVQueries(t *testing.T) {
+	tmp, _ := ioutil.TempFile("", "minidb-kvquery-testing-*")
+	defer os.Remove(tmp.Name())
+	db, err := Open("sqlite3", tmp.Name())
+	if err != nil {
+		t.Errorf("Open() failed: %s", err)
+	}
+	defer db.Close()
+	tx, err := db.Begin()
+	if err != nil {
+		t.Errorf("db.Begin transaction failed: %s", err)
+	}
+	for _, key := range []int64{5, 1, 10, -3, 7} {
+		tx.SetInt(key, key*2)
+	}
+	tx.SetStr(1, "apple")
+	tx.SetStr(2, "Apricot")
+	tx.SetStr(3, "banana")
+	if err := tx.Commit(); err != nil {
+		t.Errorf("tx.Commit failed: %s", err)
+	}
+	keys := db.ListIntRange(0, 7)
+	if len(keys) != 3 || keys[0] != 1 || keys[1] != 5 || keys[2] != 7 {
+		t.Errorf("ListIntRange() returned %v, expected [1 5 7]", keys)
+	}
+	if keys := db.ListIntRange(11, 20); len(keys) != 0 {
+		t.Errorf("ListIntRange() returned %v for an empty range", keys)
+	}
+	if keys := db.ListStrRange(2, 3); len(keys) != 2 {
+		t.Errorf("ListStrRange() returned %v, expected [2 3]", keys)
+	}
+	keys, err = db.FindStrValue("ap%")
+	if err != nil || len(keys) != 2 || keys[0] != 1 || keys[1] != 2 {
+		t.Errorf("FindStrValue() returned %v, %v, expected [1 2]", keys, err)
+	}
+	keys, err = db.FindStrValue("%nan_")
+	if err != nil || len(keys) != 1 || keys[0] != 3 {
+		t.Errorf("FindStrValue() returned %v, %v, expected [3]", keys, err)
+	}
+}
+
+func TestExportImportKV(t *testing.T) {
+	tmp, _ := ioutil.TempFile("", "minidb-kvexport-testing-*")
+	defer os.Remove(tmp.Name())
+	db, err := Open("sqlite3", tmp.Name())
+	if err != nil {
+		t.Errorf("Open() failed: %s", err)
+	}
+	defer db.Close()
+	date := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	tx, _ := db.Begin()
+	tx.SetInt(1, 42)
+	tx.SetStr(2, "hello")
+	tx.SetBlob(3, []byte{0, 255, 1})
+	tx.SetDate(4, date)
+	tx.Commit()
+	var buff bytes.Buffer
+	if err := db.ExportKV(&buff); err != nil {
+		t.Errorf("ExportKV() failed: %s", err)
+	}
+	exported := buff.String()
+
+	tmp2, _ := ioutil.TempFile("", "minidb-kvexport-testing-*")
+	defer os.Remove(tmp2.Name())
+	db2, err := Open("sqlite3", tmp2.Name())
+	if err != nil {
+		t.Errorf("Open() failed: %s", err)
+	}
+	defer db2.Close()
+	tx, _ = db2.Begin()
+	tx.SetInt(1, 7)
+	tx.SetInt(5, 5)
+	if err := tx.ImportKV(strings.NewReader(exported)); err != nil {
+		t.Errorf("ImportKV() failed: %s", err)
+	}
+	tx.Commit()
+	if db2.GetInt(1) != 42 || db2.GetInt(5) != 5 || db2.GetStr(2) != "hello" ||
+		!bytes.Equal(db2.GetBlob(3), []byte{0, 255, 1}) || !db2.GetDate(4).Equal(date) {
+		t.Errorf("ImportKV() did not restore the exported values")
+	}
+	tx, _ = db2.Begin()
+	if err := tx.ImportKV(strings.NewReader(`{"date":{"1":"yesterday"}}`)); err == nil {
+		t.Errorf("ImportKV() should fail for an invalid date")
+	}
+	if err := tx.ImportKV(strings.NewReader(`{`)); err == nil {
+		t.Errorf("ImportKV() should fail for invalid JSON")
+	}
+	tx.Rollback()
+}
+
+func TestKVStringKeys(t *testing.T) {
+	tmp, _ := ioutil.TempFile("", "minidb-kvstore-testing-*")
+	defer os.Remove(tmp.Name())
+	db, err := Open("sqlite3", tmp.Name())
+	if err != nil {
+		t.Fatalf("Open() failed: %s", err)
+	}
+	defer db.Close()
+	date := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	tx, _ := db.Begin()
+	tx.SetInt(1, 1)
+	tx.SetIntKey("1", 2)
+	tx.SetIntKey("user/count", 42)
+	tx.SetStrKey("greeting", "hello")
+	tx.SetBlobKey("data", []byte{0, 255, 1})
+	tx.SetDateKey("start", date)
+	tx.Commit()
+	if db.GetInt(1) != 1 || db.GetIntKey("1") != 2 {
+		t.Errorf("string keys should be separate from int64 keys, got %d and %d", db.GetInt(1), db.GetIntKey("1"))
+	}
+	if db.GetIntKey("user/count") != 42 || db.GetStrKey("greeting") != "hello" ||
+		!bytes.Equal(db.GetBlobKey("data"), []byte{0, 255, 1}) || !db.GetDateKey("start").Equal(date) {
+		t.Errorf("the values stored by string key differ")
+	}
+	if db.GetIntKey("none") != 0 || db.GetStrKey("none") != "" || !db.GetDateKey("none").IsZero() {
+		t.Errorf("missing string keys should return zero values")
+	}
+	if !db.HasStrKey("greeting") || db.HasStrKey("data") || db.HasInt(2) {
+		t.Errorf("Has methods returned wrong results")
+	}
+	if keys := db.ListIntKeys(); len(keys) != 2 || keys[0] != "1" || keys[1] != "user/count" {
+		t.Errorf("ListIntKeys() returned %v", keys)
+	}
+
+	var buff bytes.Buffer
+	if err := db.ExportKV(&buff); err != nil {
+		t.Fatalf("ExportKV() failed: %s", err)
+	}
+	tx, _ = db.Begin()
+	tx.DeleteIntKey("user/count")
+	tx.DeleteStrKey("greeting")
+	tx.Commit()
+	if db.HasIntKey("user/count") || db.HasStrKey("greeting") || !db.HasIntKey("1") {
+		t.Errorf("Delete methods removed the wrong keys")
+	}
+	tx, _ = db.Begin()
+	if err := tx.ImportKV(&buff); err != nil {
+		t.Errorf("ImportKV() failed: %s", err)
+	}
+	tx.Commit()
+	if db.GetIntKey("user/count") != 42 || db.GetStrKey("greeting") != "hello" {
+		t.Errorf("ImportKV() did not restore the values with string keys")
+	}
+}
+
+func TestKVExpiry(t *testing.T) {
+	tmp, _ := ioutil.TempFile("", "minidb-kvstore-testing-*")
+	defer os.Remove(tmp.Name())
+	db, err := Open("sqlite3", tmp.Name())
+	if err != nil {
+		t.Fatalf("Open() failed: %s", err)
+	}
+	defer db.Close()
+	tx, _ := db.Begin()
+	tx.SetIntTTL(1, 10, -time.Second)
+	tx.SetStrTTL(1, "session", time.Hour)
+	tx.SetStrKeyTTL("token", "expired", -time.Second)
+	tx.SetIntTTL(2, 20, -time.Second)
+	tx.SetInt(2, 21)
+	tx.Commit()
+	if db.GetInt(1) != 0 || db.HasInt(1) || db.GetStrKey("token") != "" || len(db.ListStrKeys()) != 0 {
+		t.Errorf("expired values should be treated as missing")
+	}
+	if db.GetStr(1) != "session" || !db.HasStr(1) {
+		t.Errorf("a value that has not expired is missing")
+	}
+	if db.GetInt(2) != 21 {
+		t.Errorf("setting a value without TTL should make it permanent")
+	}
+	if ints := db.ListInt(); len(ints) != 1 || ints[0] != 2 {
+		t.Errorf("ListInt() returned %v, expected [2]", ints)
+	}
+	n, err := db.PurgeExpired()
+	if err != nil || n != 2 {
+		t.Errorf("PurgeExpired() returned %d, %v, expected 2", n, err)
+	}
+	var count int
+	db.Base().QueryRow(`SELECT COUNT(*) FROM _KVINT;`).Scan(&count)
+	if count != 1 {
+		t.Errorf("the store holds %d int values after purging, expected 1", count)
+	}
+}
+
+func TestKVBuckets(t *testing.T) {
+	tmp, _ := ioutil.TempFile("", "minidb-kvstore-testing-*")
+	defer os.Remove(tmp.Name())
+	db, err := Open("sqlite3", tmp.Name())
+	if err != nil {
+		t.Fatalf("Open() failed: %s", err)
+	}
+	defer db.Close()
+	settings := db.Bucket("settings")
+	if err := settings.SetStr(1, "dark"); err != nil {
+		t.Fatalf("SetStr() failed: %s", err)
+	}
+	settings.SetInt(2, 42)
+	db.Bucket("cache").SetStr(1, "cached")
+	tx, _ := db.Begin()
+	tx.SetStr(1, "flat")
+	tx.Bucket("cache").SetBlob(5, []byte{1, 2})
+	if !bytes.Equal(tx.Bucket("cache").GetBlob(5), []byte{1, 2}) {
+		t.Errorf("a bucket of a transaction should see its writes")
+	}
+	tx.Commit()
+	if settings.GetStr(1) != "dark" || db.Bucket("cache").GetStr(1) != "cached" || db.GetStr(1) != "flat" {
+		t.Errorf("buckets should be separate from each other and from the flat store")
+	}
+	if settings.GetInt(2) != 42 || !settings.HasInt(2) || settings.HasStr(2) || settings.GetStr(3) != "" {
+		t.Errorf("the bucket returned wrong values")
+	}
+	if buckets := db.ListBuckets(); len(buckets) != 2 || buckets[0] != "cache" || buckets[1] != "settings" {
+		t.Errorf("ListBuckets() returned %v", buckets)
+	}
+	if keys := db.Bucket("cache").ListBlob(); len(keys) != 1 || keys[0] != 5 {
+		t.Errorf("ListBlob() returned %v", keys)
+	}
+	var buff bytes.Buffer
+	if err := db.ExportKV(&buff); err != nil {
+		t.Fatalf("ExportKV() failed: %s", err)
+	}
+	if err := settings.DeleteInt(2); err != nil || settings.HasInt(2) {
+		t.Errorf("DeleteInt() failed: %v", err)
+	}
+	if err := db.Bucket("cache").Clear(); err != nil {
+		t.Fatalf("Clear() failed: %s", err)
+	}
+	if db.Bucket("cache").HasStr(1) || settings.GetStr(1) != "dark" || len(db.ListBuckets()) != 1 {
+		t.Errorf("Clear() should only remove the values of the bucket")
+	}
+	tx, _ = db.Begin()
+	if err := tx.ImportKV(&buff); err != nil {
+		t.Errorf("ImportKV() failed: %s", err)
+	}
+	tx.Commit()
+	if settings.GetInt(2) != 42 || db.Bucket("cache").GetStr(1) != "cached" {
+		t.Errorf("ImportKV() did not restore the buckets")
+	}
+}
+
+func TestIterateKV(t *testing.T) {
+	tmp, _ := ioutil.TempFile("", "minidb-kvstore-testing-*")
+	defer os.Remove(tmp.Name())
+	db, err := Open("sqlite3", tmp.Name())
+	if err != nil {
+		t.Fatalf("Open() failed: %s", err)
+	}
+	defer db.Close()
+	date := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	tx, _ := db.Begin()
+	for i := int64(1); i <= 5; i++ {
+		tx.SetStr(i, strings.Repeat("x", int(i)))
+	}
+	tx.SetInt(7, 70)
+	tx.SetDate(3, date)
+	tx.SetStrKey("user/1", "alice")
+	tx.SetStrKey("user/2", "bob")
+	tx.SetStrKey("group/1", "admins")
+	tx.Commit()
+	var keys []int64
+	err = db.IterateKV(DBString, 2, 4, func(key int64, value Value) bool {
+		if value.String() != strings.Repeat("x", int(key)) {
+			t.Errorf("IterateKV() passed '%s' for key %d", value.String(), key)
+		}
+		keys = append(keys, key)
+		return true
+	})
+	if err != nil || len(keys) != 3 || keys[0] != 2 || keys[2] != 4 {
+		t.Errorf("IterateKV() returned %v, %v", keys, err)
+	}
+	keys = nil
+	db.IterateKV(DBString, 1, 5, func(key int64, value Value) bool {
+		keys = append(keys, key)
+		return key < 2
+	})
+	if len(keys) != 2 {
+		t.Errorf("IterateKV() should stop when the function returns false, visited %v", keys)
+	}
+	var names []string
+	err = db.IterateKVPrefix(DBString, "user/", func(key string, value Value) bool {
+		names = append(names, key+"="+value.String())
+		return true
+	})
+	if err != nil || len(names) != 2 || names[0] != "user/1=alice" || names[1] != "user/2=bob" {
+		t.Errorf("IterateKVPrefix() returned %v, %v", names, err)
+	}
+	if err := db.IterateKV(DBStringList, 0, 1, func(int64, Value) bool { return true }); err == nil {
+		t.Errorf("IterateKV() should fail for a list type")
+	}
+	if ints, err := db.GetAllInt(); err != nil || len(ints) != 1 || ints[7] != 70 {
+		t.Errorf("GetAllInt() returned %v, %v", ints, err)
+	}
+	if strs, err := db.GetAllStr(); err != nil || len(strs) != 5 || strs[5] != "xxxxx" {
+		t.Errorf("GetAllStr() returned %v, %v", strs, err)
+	}
+	if dates, err := db.GetAllDate(); err != nil || !dates[3].Equal(date) {
+		t.Errorf("GetAllDate() returned %v, %v", dates, err)
+	}
+}
+
+func TestKVLookup(t *testing.T) {
+	tmp, _ := ioutil.TempFile("", "minidb-kvstore-testing-*")
+	defer os.Remove(tmp.Name())
+	db, err := Open("sqlite3", tmp.Name())
+	if err != nil {
+		t.Fatalf("Open() failed: %s", err)
+	}
+	defer db.Close()
+	tx, _ := db.Begin()
+	tx.SetInt(1, 0)
+	tx.SetStr(1, "")
+	tx.SetBlob(1, nil)
+	tx.SetStrKey("empty", "")
+	tx.Commit()
+	db.Bucket("b").SetInt(1, 0)
+	if n, ok := db.LookupInt(1); !ok || n != 0 {
+		t.Errorf("LookupInt() returned %d, %t for a stored 0", n, ok)
+	}
+	if _, ok := db.LookupInt(2); ok {
+		t.Errorf("LookupInt() returned true for a missing key")
+	}
+	if s, ok := db.LookupStr(1); !ok || s != "" {
+		t.Errorf("LookupStr() returned '%s', %t for a stored empty string", s, ok)
+	}
+	if b, ok := db.LookupBlob(2); ok || b != nil {
+		t.Errorf("LookupBlob() returned %v, %t for a missing key", b, ok)
+	}
+	if _, ok := db.LookupBlob(1); !ok {
+		t.Errorf("LookupBlob() returned false for an empty blob")
+	}
+	if d, ok := db.LookupDate(1); ok || !d.IsZero() {
+		t.Errorf("LookupDate() returned %v, %t for a missing key", d, ok)
+	}
+	if _, ok := db.LookupStrKey("empty"); !ok {
+		t.Errorf("LookupStrKey() returned false for a stored empty string")
+	}
+	if _, ok := db.LookupIntKey("empty"); ok {
+		t.Errorf("LookupIntKey() returned true for a missing key")
+	}
+	if _, ok := db.Bucket("b").LookupInt(1); !ok {
+		t.Errorf("Bucket.LookupInt() returned false for a stored 0")
+	}
+	if _, ok := db.Bucket("b").LookupStr(1); ok {
+		t.Errorf("Bucket.LookupStr() returned true for a missing key")
+	}
+}
+
+func TestKVBatch(t *testing.T) {
+	tmp, _ := ioutil.TempFile("", "minidb-kvstore-testing-*")
+	defer os.Remove(tmp.Name())
+	db, err := Open("sqlite3", tmp.Name())
+	if err != nil {
+		t.Fatalf("Open() failed: %s", err)
+	}
+	defer db.Close()
+	values := make(map[int64]Value)
+	keys := make([]int64, 0)
+	for i := int64(1); i <= 1000; i++ {
+		values[i] = NewInt(i * 10)
+		keys = append(keys, i)
+	}
+	tx, _ := db.Begin()
+	if err := tx.SetMany(DBInt, values); err != nil {
+		t.Fatalf("SetMany() failed: %s", err)
+	}
+	if err := tx.SetMany(DBInt, map[int64]Value{1: NewString("x")}); err == nil {
+		t.Errorf("SetMany() should fail for values of another type")
+	}
+	if err := tx.SetMany(DBDate, map[int64]Value{1: NewDateStr("never")}); err == nil {
+		t.Errorf("SetMany() should fail for an invalid date")
+	}
+	tx.SetMany(DBString, map[int64]Value{1: NewString("one"), 2: NewString("two")})
+	tx.Commit()
+	if db.GetInt(500) != 5000 || len(db.ListInt()) != 1000 {
+		t.Errorf("SetMany() did not store all values")
+	}
+	got, err := db.GetMany(DBInt, append(keys, 2000))
+	if err != nil || len(got) != 1000 || got[1000].Num != 10000 {
+		t.Errorf("GetMany() returned %d values, %v", len(got), err)
+	}
+	if _, ok := got[2000]; ok {
+		t.Errorf("GetMany() returned a value for a missing key")
+	}
+	if strs, err := db.GetMany(DBString, []int64{2}); err != nil || strs[2].Str != "two" {
+		t.Errorf("GetMany() returned %v, %v", strs, err)
+	}
+}
+
+func TestKVFloat(t *testing.T) {
+	tmp, _ := ioutil.TempFile("", "minidb-kvstore-testing-*")
+	defer os.Remove(tmp.Name())
+	db, err := Open("sqlite3", tmp.Name())
+	if err != nil {
+		t.Fatalf("Open() failed: %s", err)
+	}
+	defer db.Close()
+	tx, _ := db.Begin()
+	tx.SetFloat(1, 2.5)
+	tx.SetFloat(2, -0.125)
+	tx.SetFloat(3, 10)
+	tx.SetFloat(4, 1e-300)
+	tx.DeleteFloat(4)
+	tx.Commit()
+	if db.GetFloat(1) != 2.5 || db.GetFloat(2) != -0.125 || db.GetFloat(9) != 0 {
+		t.Errorf("GetFloat() returned wrong values")
+	}
+	if !db.HasFloat(3) || db.HasFloat(4) {
+		t.Errorf("HasFloat() returned wrong results")
+	}
+	if keys := db.ListFloat(); len(keys) != 3 {
+		t.Errorf("ListFloat() returned %v", keys)
+	}
+	// a range of values is compared numerically, 10 would be between 2.5 and 3 as string
+	if keys, err := db.FindFloatValues(-1, 3); err != nil || len(keys) != 2 || keys[0] != 2 || keys[1] != 1 {
+		t.Errorf("FindFloatValues() returned %v, %v", keys, err)
+	}
+	var buff bytes.Buffer
+	db.ExportKV(&buff)
+	tx, _ = db.Begin()
+	tx.DeleteFloat(1)
+	if err := tx.ImportKV(&buff); err != nil {
+		t.Errorf("ImportKV() failed: %s", err)
+	}
+	tx.Commit()
+	if db.GetFloat(1) != 2.5 {
+		t.Errorf("ImportKV() did not restore the float values")
+	}
+}
+
+func TestKVDump(t *testing.T) {
+	tmp, _ := ioutil.TempFile("", "minidb-kvstore-testing-*")
+	defer os.Remove(tmp.Name())
+	db, err := Open("sqlite3", tmp.Name())
+	if err != nil {
+		t.Fatalf("Open() failed: %s", err)
+	}
+	defer db.Close()
+	tx, _ := db.Begin()
+	tx.SetInt(2, 20)
+	tx.SetInt(1, 10)
+	tx.SetFloat(1, 0.5)
+	tx.SetStrKey("name", "minidb")
+	tx.Bucket("config").SetInt(7, 70)
+	tx.SetIntTTL(3, 30, -time.Second)
+	tx.Commit()
+	entries, err := db.DumpKV()
+	if err != nil {
+		t.Fatalf("DumpKV() failed: %s", err)
+	}
+	expected := []KVEntry{
+		{Store: "int", Key: 1, Value: NewInt(10)},
+		{Store: "int", Key: 2, Value: NewInt(20)},
+		{Store: "float", Key: 1, Value: NewString("0.5")},
+		{Store: "keystr", StrKey: "name", Value: NewString("minidb")},
+		{Store: "bucketint", Bucket: "config", Key: 7, Value: NewInt(70)},
+	}
+	if len(entries) != len(expected) {
+		t.Fatalf("DumpKV() returned %v, expected %v", entries, expected)
+	}
+	for i := range expected {
+		if entries[i] != expected[i] {
+			t.Errorf("DumpKV() returned %v at %d, expected %v", entries[i], i, expected[i])
+		}
+	}
+}
+
+func TestKVDisabled(t *testing.T) {
+	tmp, _ := ioutil.TempFile("", "minidb-kvstore-testing-*")
+	defer os.Remove(tmp.Name())
+	db, err := OpenWithOptions("sqlite3", tmp.Name(), &Options{DisableKV: true})
+	if err != nil {
+		t.Errorf("OpenWithOptions() failed: %s", err)
+	}
+	defer db.Close()
+	var n int
+	if err := db.Base().QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE name LIKE '\_KV%' ESCAPE '\';`).Scan(&n); err != nil || n != 0 {
+		t.Errorf("OpenWithOptions() created %d key-value tables despite DisableKV, %v", n, err)
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		t.Errorf("Begin() failed: %s", err)
+	}
+	tx.SetInt(1, 42)
+	tx.SetStr(1, "hello")
+	if err := tx.ImportKV(strings.NewReader(`{"int":{"2":3}}`)); err != ErrKVDisabled {
+		t.Errorf("ImportKV() returned %v, expected ErrKVDisabled", err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Errorf("Commit() failed: %s", err)
+	}
+	if db.GetInt(1) != 0 || db.GetStr(1) != "" || db.HasInt(1) || len(db.ListStr()) != 0 || !db.GetDate(1).IsZero() {
+		t.Errorf("key-value getters should return zero values if the store is disabled")
+	}
+	var buff bytes.Buffer
+	err = db.ExportKV(&buff)
+	if _, ok := err.(*SubsystemDisabledError); !ok {
+		t.Errorf("ExportKV() returned %v, expected a SubsystemDisabledError", err)
+	}
+	if err := db.AddTable("Person", []Field{Field{Name: "Name", Sort: DBString}}); err != nil {
+		t.Errorf("AddTable() failed with disabled key-value store: %s", err)
+	}
+}
@@ -0,0 +1,104 @@
+package minidb
+
+import (
+	"time"
+)
+
+// ------------------------------------------------------------------------------
+// Expiring values of the key-value store
+// ------------------------------------------------------------------------------
+
+// kvStoreNames are the internal tables of the key-value store.
+var kvStoreNames = []string{"_KVINT", "_KVSTR", "_KVBLOB", "_KVDATE", "_KVSINT", "_KVSSTR", "_KVSBLOB", "_KVSDATE",
+	"_KVBINT", "_KVBSTR", "_KVBBLOB", "_KVBDATE", "_KVFLOAT"}
+
+// kvLive is the condition that selects the values of a store that have not expired. Its
+// parameter is the current time in Unix nanoseconds. The Expires column holds the expiry
+// time of a value in Unix nanoseconds, or NULL if the value never expires.
+const kvLive = `(Expires IS NULL OR Expires>?)`
+
+// addKVExpiry adds the Expires column to the tables of the key-value store of databases
+// created by earlier versions.
+func (tx *Tx) addKVExpiry() error {
+	for _, store := range kvStoreNames {
+		if err := tx.addColumnIfMissing(store, "Expires", "INTEGER"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// expiry returns the expiry time in Unix nanoseconds of a value stored now with the ttl.
+func expiry(ttl time.Duration) int64 {
+	return time.Now().Add(ttl).UnixNano()
+}
+
+// SetIntTTL stores an int64 value by key that expires after the ttl. Expired values are treated
+// as missing by the getters and the Has and List methods, and removed by PurgeExpired. Setting
+// a value again without TTL makes it permanent.
+func (tx *Tx) SetIntTTL(key int64, value int64, ttl time.Duration) {
+	tx.setIntValue("_KVINT", key, value, expiry(ttl))
+}
+
+// SetStrTTL stores a string value by key that expires after the ttl, see SetIntTTL.
+func (tx *Tx) SetStrTTL(key int64, value string, ttl time.Duration) {
+	tx.setStrValue("_KVSTR", key, value, expiry(ttl))
+}
+
+// SetBlobTTL stores a byte array by key that expires after the ttl, see SetIntTTL.
+func (tx *Tx) SetBlobTTL(key int64, value []byte, ttl time.Duration) {
+	tx.setStrValue("_KVBLOB", key, string(value), expiry(ttl))
+}
+
+// SetDateTTL stores a time.Time value by key that expires after the ttl, see SetIntTTL.
+func (tx *Tx) SetDateTTL(key int64, value time.Time, ttl time.Duration) {
+	tx.setStrValue("_KVDATE", key, value.UTC().Format(time.RFC3339), expiry(ttl))
+}
+
+// SetIntKeyTTL stores an int64 value by string key that expires after the ttl, see SetIntTTL.
+func (tx *Tx) SetIntKeyTTL(key string, value int64, ttl time.Duration) {
+	tx.setIntValue("_KVSINT", key, value, expiry(ttl))
+}
+
+// SetStrKeyTTL stores a string value by string key that expires after the ttl, see SetIntTTL.
+func (tx *Tx) SetStrKeyTTL(key string, value string, ttl time.Duration) {
+	tx.setStrValue("_KVSSTR", key, value, expiry(ttl))
+}
+
+// SetBlobKeyTTL stores a byte array by string key that expires after the ttl, see SetIntTTL.
+func (tx *Tx) SetBlobKeyTTL(key string, value []byte, ttl time.Duration) {
+	tx.setStrValue("_KVSBLOB", key, string(value), expiry(ttl))
+}
+
+// SetDateKeyTTL stores a time.Time value by string key that expires after the ttl, see SetIntTTL.
+func (tx *Tx) SetDateKeyTTL(key string, value time.Time, ttl time.Duration) {
+	tx.setStrValue("_KVSDATE", key, value.UTC().Format(time.RFC3339), expiry(ttl))
+}
+
+// PurgeExpired removes the expired values from the key-value store and returns their number.
+// Expired values are already treated as missing, so purging only reclaims their space and is
+// not recorded in the audit log. It waits for the transaction in progress like Begin.
+func (db *MDB) PurgeExpired() (int64, error) {
+	if db.kvDisabled {
+		return 0, ErrKVDisabled
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	now := time.Now().UnixNano()
+	var purged int64
+	for _, store := range kvStoreNames {
+		result, err := tx.tx.Exec(`DELETE FROM `+store+` WHERE Expires<=?;`, now)
+		if err != nil {
+			tx.Rollback()
+			return 0, Fail("cannot purge expired values: %s", err)
+		}
+		n, _ := result.RowsAffected()
+		purged += n
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	return purged, nil
+}
@@ -0,0 +1,109 @@
+package minidb
+
+import (
+	"database/sql"
+)
+
+// ------------------------------------------------------------------------------
+// Links
+// ------------------------------------------------------------------------------
+
+// Link is the target of a directed, named relation between two items. Links are created by Tx.Link
+// and are returned by MDB.Linked.
+type Link struct {
+	Table    string `json:"table"`
+	Item     Item   `json:"item"`
+	Relation string `json:"relation"`
+}
+
+func (tx *Tx) validateLink(tableA string, itemA Item, tableB string, itemB Item, relation string) error {
+	view := tx.View()
+	for _, table := range []string{tableA, tableB} {
+		if !validTable.MatchString(table) {
+			return Fail("invalid table name '%s'", table)
+		}
+		if !view.TableExists(table) {
+			return tableNotFound(table)
+		}
+	}
+	if !validFieldName.MatchString(relation) {
+		return Fail("invalid relation '%s'", relation)
+	}
+	if !view.ItemExists(tableA, itemA) {
+		return itemNotFound(tableA, itemA)
+	}
+	if !view.ItemExists(tableB, itemB) {
+		return itemNotFound(tableB, itemB)
+	}
+	return nil
+}
+
+// Link links itemA in tableA to itemB in tableB by the given relation. Relations are directed and
+// their names must be valid field names. This can be used to express many-to-many relations
+// without having to create a join table. Linking items that are already linked has no effect.
+func (tx *Tx) Link(tableA string, itemA Item, tableB string, itemB Item, relation string) error {
+	if err := tx.validateLink(tableA, itemA, tableB, itemB, relation); err != nil {
+		return err
+	}
+	result, err := tx.tx.Exec(`INSERT OR IGNORE INTO _LINKS (TableA,ItemA,TableB,ItemB,Relation) VALUES (?,?,?,?,?);`,
+		tableA, itemA, tableB, itemB, relation)
+	if err != nil {
+		return Fail("cannot link %s %d to %s %d: %s", tableA, itemA, tableB, itemB, err)
+	}
+	if n, err := result.RowsAffected(); err == nil && n > 0 {
+		return tx.audit(AuditEntry{Op: AuditLink, Table: tableA, Item: itemA, Field: relation,
+			New: []Value{NewString(tableB), NewInt(int64(itemB))}})
+	}
+	return nil
+}
+
+// Unlink removes the link between itemA in tableA and itemB in tableB by the given relation.
+// It has no effect if the items are not linked.
+func (tx *Tx) Unlink(tableA string, itemA Item, tableB string, itemB Item, relation string) error {
+	if !validFieldName.MatchString(relation) {
+		return Fail("invalid relation '%s'", relation)
+	}
+	result, err := tx.tx.Exec(`DELETE FROM _LINKS WHERE TableA=? AND ItemA=? AND TableB=? AND ItemB=? AND Relation=?;`,
+		tableA, itemA, tableB, itemB, relation)
+	if err != nil {
+		return Fail("cannot unlink %s %d from %s %d: %s", tableA, itemA, tableB, itemB, err)
+	}
+	if n, err := result.RowsAffected(); err == nil && n > 0 {
+		return tx.audit(AuditEntry{Op: AuditUnlink, Table: tableA, Item: itemA, Field: relation,
+			Old: []Value{NewString(tableB), NewInt(int64(itemB))}})
+	}
+	return nil
+}
+
+// Linked returns the items that the item in table is linked to by the given relation.
+// If relation is the empty string, the items linked by any relation are returned.
+func (db *MDB) Linked(table string, item Item, relation string) ([]Link, error) {
+	if !validTable.MatchString(table) {
+		return nil, Fail("invalid table name '%s'", table)
+	}
+	if !db.TableExists(table) {
+		return nil, tableNotFound(table)
+	}
+	var rows *sql.Rows
+	var err error
+	if relation == "" {
+		rows, err = db.reader.Query(`SELECT TableB,ItemB,Relation FROM _LINKS WHERE TableA=? AND ItemA=?;`,
+			table, item)
+	} else {
+		rows, err = db.reader.Query(`SELECT TableB,ItemB,Relation FROM _LINKS WHERE TableA=? AND ItemA=? AND Relation=?;`,
+			table, item, relation)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	result := make([]Link, 0)
+	for rows.Next() {
+		var link Link
+		if err := rows.Scan(&link.Table, &link.Item, &link.Relation); err != nil {
+			return nil, err
+		}
+		result = append(result, link)
+	}
+	return result, rows.Err()
+}
@@ -0,0 +1,122 @@
+package minidb
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestLinks(t *testing.T) {
+	tmp, _ := ioutil.TempFile("", "minidb-links-testing-*")
+	defer os.Remove(tmp.Name())
+	db, err := Open("sqlite3", tmp.Name())
+	if err != nil {
+		t.Errorf("Open() failed: %s", err)
+	}
+	defer db.Close()
+	if err := db.AddTable("Person", []Field{Field{Name: "Name", Sort: DBString}}); err != nil {
+		t.Errorf("AddTable() failed: %s", err)
+	}
+	if err := db.AddTable("Asset", []Field{Field{Name: "Name", Sort: DBString}, Field{Name: "Tags", Sort: DBStringList}}); err != nil {
+		t.Errorf("AddTable() failed: %s", err)
+	}
+	john, _ := db.NewItem("Person")
+	bob, _ := db.NewItem("Person")
+	car, _ := db.NewItem("Asset")
+	bike, _ := db.NewItem("Asset")
+	tx, err := db.Begin()
+	if err != nil {
+		t.Errorf("Begin() failed: %s", err)
+	}
+	tx.Set("Person", john, "Name", []Value{NewString("John")})
+	tx.Set("Person", bob, "Name", []Value{NewString("Bob")})
+	tx.Set("Asset", car, "Name", []Value{NewString("Car")})
+	tx.Set("Asset", car, "Tags", []Value{NewString("old"), NewString("red")})
+	tx.Set("Asset", bike, "Name", []Value{NewString("Bike")})
+	tx.Set("Asset", bike, "Tags", []Value{NewString("new")})
+	if err := tx.Link("Person", john, "Asset", car, "owns"); err != nil {
+		t.Errorf("Link() failed: %s", err)
+	}
+	if err := tx.Link("Person", john, "Asset", car, "owns"); err != nil {
+		t.Errorf("Link() failed for existing link: %s", err)
+	}
+	if err := tx.Link("Person", bob, "Asset", bike, "owns"); err != nil {
+		t.Errorf("Link() failed: %s", err)
+	}
+	if err := tx.Link("Person", bob, "Person", john, "knows"); err != nil {
+		t.Errorf("Link() failed: %s", err)
+	}
+	if err := tx.Link("Person", bob, "Asset", 99, "owns"); err == nil {
+		t.Errorf("Link() should fail for nonexistent item")
+	}
+	if err := tx.Link("Person", bob, "Asset", car, "owns me"); err == nil {
+		t.Errorf("Link() should fail for invalid relation")
+	}
+	if err := tx.Commit(); err != nil {
+		t.Errorf("Commit() failed: %s", err)
+	}
+	links, err := db.Linked("Person", john, "owns")
+	if err != nil {
+		t.Errorf("Linked() failed: %s", err)
+	}
+	if len(links) != 1 || links[0].Table != "Asset" || links[0].Item != car {
+		t.Errorf("Linked() returned wrong links: %v", links)
+	}
+	links, err = db.Linked("Person", bob, "")
+	if err != nil || len(links) != 2 {
+		t.Errorf("Linked() for any relation returned wrong links: %v", links)
+	}
+	tables := []struct {
+		query string
+		out   []Item
+	}{
+		{"Person linked:owns Asset Name=Car%", []Item{john}},
+		{"Person linked:owns Asset Name=%", []Item{john, bob}},
+		{"Person not linked:owns Asset Name=Car", []Item{bob}},
+		{"Person linked:owns Asset no Tags=old", []Item{bob}},
+		{"Person linked:knows Person Name=John and Name=Bob", []Item{bob}},
+		{"Person linked:knows Person linked:owns Asset Tags=red", []Item{bob}},
+	}
+	for _, table := range tables {
+		q, err := ParseQuery(table.query)
+		if err != nil {
+			t.Errorf(`ParseQuery("%s") failed: %s`, table.query, err)
+			continue
+		}
+		results, err := db.Find(q, 0)
+		if err != nil {
+			t.Errorf(`Find("%s") failed: %s`, table.query, err)
+			continue
+		}
+		if len(results) != len(table.out) {
+			t.Errorf(`Find("%s") returned %v, expected %v`, table.query, results, table.out)
+			continue
+		}
+		for i := range results {
+			if results[i] != table.out[i] {
+				t.Errorf(`Find("%s") returned %v, expected %v`, table.query, results, table.out)
+			}
+		}
+	}
+	tx, err = db.Begin()
+	if err != nil {
+		t.Errorf("Begin() failed: %s", err)
+	}
+	if err := tx.Unlink("Person", john, "Asset", car, "owns"); err != nil {
+		t.Errorf("Unlink() failed: %s", err)
+	}
+	if err := tx.RemoveItem("Person", john); err != nil {
+		t.Errorf("RemoveItem() failed: %s", err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Errorf("Commit() failed: %s", err)
+	}
+	links, err = db.Linked("Person", john, "owns")
+	if err != nil || len(links) != 0 {
+		t.Errorf("Linked() returned links after Unlink()")
+	}
+	links, err = db.Linked("Person", bob, "knows")
+	if err != nil || len(links) != 0 {
+		t.Errorf("Linked() returned links to a removed item")
+	}
+}
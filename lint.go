@@ -0,0 +1,107 @@
+package minidb
+
+import (
+	"fmt"
+)
+
+// ------------------------------------------------------------------------------
+// Schema linter
+// ------------------------------------------------------------------------------
+
+// LintKind is the kind of a warning returned by LintSchema.
+type LintKind int
+
+const (
+	// LintLargeList indicates a list field with more than lintMaxListSize values for an item.
+	LintLargeList LintKind = iota + 1
+	// LintNumericStrings indicates a string field whose values are all integers.
+	LintNumericStrings
+	// LintNeverSet indicates a field that has no value for any item of a non-empty table.
+	LintNeverSet
+)
+
+// lintMaxListSize is the number of values of a list field for a single item above which
+// LintSchema suggests to use a separate table with links instead.
+const lintMaxListSize = 1000
+
+// LintWarning is a suggestion for improving the schema of a field.
+type LintWarning struct {
+	Kind    LintKind `json:"kind"`
+	Table   string   `json:"table"`
+	Field   string   `json:"field"`
+	Message string   `json:"message"`
+}
+
+// String returns a human-readable form of the warning.
+func (w LintWarning) String() string {
+	return fmt.Sprintf("%s %s: %s", w.Table, w.Field, w.Message)
+}
+
+// LintSchema examines the data stored in all tables and returns warnings for fields whose type
+// or structure is likely to be a bad choice, namely list fields with very many values for an item,
+// string fields that only contain integers, and fields that have never been set.
+func (db *MDB) LintSchema() ([]LintWarning, error) {
+	result := make([]LintWarning, 0)
+	for _, table := range db.GetTables() {
+		count, err := db.Count(table)
+		if err != nil {
+			return nil, err
+		}
+		if count == 0 {
+			continue
+		}
+		fields, err := db.GetFields(table)
+		if err != nil {
+			return nil, err
+		}
+		for _, field := range fields {
+			warnings, err := db.lintField(table, field)
+			if err != nil {
+				return nil, Fail("cannot examine %s %s: %s", table, field.Name, err)
+			}
+			result = append(result, warnings...)
+		}
+	}
+	return result, nil
+}
+
+// lintField returns the warnings for a field of a non-empty table.
+func (db *MDB) lintField(table string, field Field) ([]LintWarning, error) {
+	result := make([]LintWarning, 0)
+	source := table
+	if isListFieldType(field.Sort) {
+		source = listFieldToTableName(table, field.Name)
+	}
+	var set, numeric int64
+	err := db.reader.QueryRow(fmt.Sprintf(`SELECT COUNT("%[1]s"),
+COUNT(CASE WHEN CAST(CAST("%[1]s" AS INTEGER) AS TEXT)="%[1]s" THEN 1 END) FROM "%[2]s";`,
+		field.Name, source)).Scan(&set, &numeric)
+	if err != nil {
+		return nil, err
+	}
+	if set == 0 {
+		return append(result, LintWarning{LintNeverSet, table, field.Name,
+			"the field has never been set and might not be needed"}), nil
+	}
+	if ToBaseType(field.Sort) == DBString && numeric == set {
+		suggested := DBInt
+		if isListFieldType(field.Sort) {
+			suggested = DBIntList
+		}
+		result = append(result, LintWarning{LintNumericStrings, table, field.Name,
+			fmt.Sprintf("all values are integers, consider type %s", GetUserTypeString(suggested))})
+	}
+	if isListFieldType(field.Sort) {
+		var largest int64
+		err := db.reader.QueryRow(fmt.Sprintf(`SELECT MAX(n) FROM (SELECT COUNT(*) AS n FROM "%s" GROUP BY Owner);`,
+			source)).Scan(&largest)
+		if err != nil {
+			return nil, err
+		}
+		if largest > lintMaxListSize {
+			result = append(result, LintWarning{LintLargeList, table, field.Name,
+				fmt.Sprintf("an item has %d values, consider a separate table with links instead", largest)})
+		}
+	}
+	return result, nil
+}
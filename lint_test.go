@@ -0,0 +1,60 @@
+package minidb
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestLintSchema(t *testing.T) {
+	tmp, _ := ioutil.TempFile("", "minidb-lint-testing-*")
+	defer os.Remove(tmp.Name())
+	db, err := Open("sqlite3", tmp.Name())
+	if err != nil {
+		t.Errorf("Open() failed: %s", err)
+	}
+	defer db.Close()
+	if err := db.AddTable("Person", []Field{Field{Name: "Name", Sort: DBString}, Field{Name: "Zip", Sort: DBString},
+		Field{Name: "Phone", Sort: DBString}, Field{Name: "Visits", Sort: DBDateList}, Field{Name: "Notes", Sort: DBStringList}}); err != nil {
+		t.Errorf("AddTable() failed: %s", err)
+	}
+	if err := db.AddTable("Empty", []Field{Field{Name: "Name", Sort: DBString}}); err != nil {
+		t.Errorf("AddTable() failed: %s", err)
+	}
+	warnings, err := db.LintSchema()
+	if err != nil || len(warnings) != 0 {
+		t.Errorf("LintSchema() returned %v, %v for empty tables", warnings, err)
+	}
+	john, _ := db.NewItem("Person")
+	anna, _ := db.NewItem("Person")
+	notes := make([]Value, lintMaxListSize+1)
+	for i := range notes {
+		notes[i] = NewString("note")
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		t.Errorf("Begin() failed: %s", err)
+	}
+	tx.Set("Person", john, "Name", []Value{NewString("John")})
+	tx.Set("Person", john, "Zip", []Value{NewString("01234")})
+	tx.Set("Person", anna, "Zip", []Value{NewString("12345")})
+	tx.Set("Person", john, "Phone", []Value{NewString("5551234")})
+	tx.Set("Person", anna, "Phone", []Value{NewString("5554321")})
+	tx.Set("Person", john, "Notes", notes)
+	if err := tx.Commit(); err != nil {
+		t.Errorf("Commit() failed: %s", err)
+	}
+	warnings, err = db.LintSchema()
+	if err != nil {
+		t.Errorf("LintSchema() failed: %s", err)
+	}
+	expected := map[string]LintKind{"Phone": LintNumericStrings, "Visits": LintNeverSet, "Notes": LintLargeList}
+	if len(warnings) != len(expected) {
+		t.Errorf("LintSchema() returned %v", warnings)
+	}
+	for _, w := range warnings {
+		if w.Table != "Person" || expected[w.Field] != w.Kind {
+			t.Errorf("LintSchema() returned unexpected warning %s", w)
+		}
+	}
+}
@@ -0,0 +1,188 @@
+package minidb
+
+import "fmt"
+
+// ------------------------------------------------------------------------------
+// Normalized storage of list fields
+// ------------------------------------------------------------------------------
+
+// In the normalized layout, the values of all list fields are stored in the single table
+// _LISTVALUES instead of one table per list field. Every list field is then represented by a
+// view with the name and columns of the table it replaces, and triggers on the view write to
+// _LISTVALUES, so the SQL generated for list fields is the same in both layouts.
+
+// createListValuesTable creates the table of the normalized layout.
+func (tx *Tx) createListValuesTable() error {
+	_, err := tx.tx.Exec(`CREATE TABLE IF NOT EXISTS _LISTVALUES (Id INTEGER PRIMARY KEY,
+	TableId INTEGER NOT NULL,
+	FieldId INTEGER NOT NULL,
+	Item INTEGER NOT NULL,
+	Position INTEGER NOT NULL,
+	Value)`)
+	if err != nil {
+		return err
+	}
+	_, err = tx.tx.Exec(`CREATE INDEX IF NOT EXISTS _LISTVALUESIDX ON _LISTVALUES (TableId, FieldId, Item, Position)`)
+	return err
+}
+
+// detectListValues determines whether the database uses the normalized layout for list fields.
+func (db *MDB) detectListValues(q querier) error {
+	var n int
+	err := q.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE type='table' AND name='_LISTVALUES';`).Scan(&n)
+	if err != nil {
+		return err
+	}
+	db.listValues = n > 0
+	return nil
+}
+
+// NormalizedLists returns true if the values of all list fields are stored in a single table,
+// see Options.NormalizedLists.
+func (db *MDB) NormalizedLists() bool {
+	return db.listValues
+}
+
+// listFieldIDs returns the ids of the table and of the list field in the housekeeping tables.
+func (tx *Tx) listFieldIDs(table string, field string) (int64, int64, error) {
+	var tableID, fieldID int64
+	err := tx.tx.QueryRow(`SELECT _TABLES.Id,_COLS.Id FROM _TABLES JOIN _COLS ON _COLS.Owner=_TABLES.Id
+WHERE _TABLES.Name=? AND _COLS.Name=?;`, table, field).Scan(&tableID, &fieldID)
+	if err != nil {
+		return 0, 0, Fail("cannot find list field %s in table %s: %s", field, table, err)
+	}
+	return tableID, fieldID, nil
+}
+
+// createListView creates the view and triggers that represent a list field in the normalized
+// layout. The field must have been registered in the housekeeping tables.
+func (tx *Tx) createListView(table string, field string) error {
+	tableID, fieldID, err := tx.listFieldIDs(table, field)
+	if err != nil {
+		return err
+	}
+	view := listFieldToTableName(table, field)
+	stmts := []string{
+		fmt.Sprintf(`CREATE VIEW IF NOT EXISTS "%s" AS SELECT Id, Item AS Owner, Value AS "%s" FROM _LISTVALUES
+WHERE TableId=%d AND FieldId=%d;`, view, field, tableID, fieldID),
+		fmt.Sprintf(`CREATE TRIGGER IF NOT EXISTS "%[1]s_INSERT" INSTEAD OF INSERT ON "%[1]s" BEGIN
+INSERT INTO _LISTVALUES (Id,TableId,FieldId,Item,Position,Value) VALUES (NEW.Id,%[2]d,%[3]d,NEW.Owner,
+(SELECT COALESCE(MAX(Position),0)+1 FROM _LISTVALUES WHERE TableId=%[2]d AND FieldId=%[3]d AND Item=NEW.Owner),
+NEW."%[4]s"); END;`, view, tableID, fieldID, field),
+		fmt.Sprintf(`CREATE TRIGGER IF NOT EXISTS "%[1]s_UPDATE" INSTEAD OF UPDATE ON "%[1]s" BEGIN
+UPDATE _LISTVALUES SET Item=NEW.Owner, Value=NEW."%[2]s" WHERE Id=OLD.Id; END;`, view, field),
+		fmt.Sprintf(`CREATE TRIGGER IF NOT EXISTS "%[1]s_DELETE" INSTEAD OF DELETE ON "%[1]s" BEGIN
+DELETE FROM _LISTVALUES WHERE Id=OLD.Id; END;`, view),
+	}
+	for _, stmt := range stmts {
+		if _, err := tx.tx.Exec(stmt); err != nil {
+			return Fail("cannot create list field %s in table %s: %s", field, table, err)
+		}
+	}
+	return nil
+}
+
+// createListValuesIndex creates the index of a list field in the normalized layout. It is a partial
+// index of _LISTVALUES that only contains the values of the field.
+func (tx *Tx) createListValuesIndex(table string, field string) error {
+	tableID, fieldID, err := tx.listFieldIDs(table, field)
+	if err != nil {
+		return err
+	}
+	_, err = tx.tx.Exec(fmt.Sprintf(`CREATE INDEX IF NOT EXISTS %s ON _LISTVALUES(Value) WHERE TableId=%d AND FieldId=%d;`,
+		indexName(listFieldToTableName(table, field), field), tableID, fieldID))
+	if err != nil {
+		return Fail("failed to create index for field '%s' in table '%s': %s", field, table, err)
+	}
+	return nil
+}
+
+// dropListView removes the view of a list field in the normalized layout together with its
+// triggers and index, but not its values.
+func (tx *Tx) dropListView(table string, field string) error {
+	view := listFieldToTableName(table, field)
+	if _, err := tx.tx.Exec(fmt.Sprintf(`DROP VIEW IF EXISTS "%s";`, view)); err != nil {
+		return Fail("cannot drop list field %s in table %s: %s", field, table, err)
+	}
+	if _, err := tx.tx.Exec(fmt.Sprintf(`DROP INDEX IF EXISTS %s;`, indexName(view, field))); err != nil {
+		return Fail("cannot drop index of list field %s in table %s: %s", field, table, err)
+	}
+	return nil
+}
+
+// normalizeLists converts all list fields from one table per field to the normalized layout.
+// The order of the values of each list is preserved, and so are their indices.
+func (tx *Tx) normalizeLists() error {
+	if err := tx.createListValuesTable(); err != nil {
+		return Fail("cannot create list values table: %s", err)
+	}
+	tx.mdb.listValues = true
+	view := tx.View()
+	for _, table := range view.GetTables() {
+		fields, err := view.GetFields(table)
+		if err != nil {
+			return err
+		}
+		for _, field := range fields {
+			if !isListFieldType(field.Sort) {
+				continue
+			}
+			listTable := listFieldToTableName(table, field.Name)
+			var isView int
+			err := tx.tx.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE type='view' AND name=?;`, listTable).Scan(&isView)
+			if err != nil {
+				return err
+			}
+			if isView > 0 {
+				continue
+			}
+			tableID, fieldID, err := tx.listFieldIDs(table, field.Name)
+			if err != nil {
+				return err
+			}
+			indexed := view.hasIndex(table, field)
+			_, err = tx.tx.Exec(fmt.Sprintf(`INSERT INTO _LISTVALUES (TableId,FieldId,Item,Position,Value)
+SELECT ?,?,Owner,Id,"%s" FROM "%s" ORDER BY Id;`, field.Name, listTable), tableID, fieldID)
+			if err != nil {
+				return Fail("cannot move values of list field %s in table %s: %s", field.Name, table, err)
+			}
+			if _, err := tx.tx.Exec(fmt.Sprintf(`DROP TABLE "%s";`, listTable)); err != nil {
+				return Fail("cannot drop list table of field %s in table %s: %s", field.Name, table, err)
+			}
+			if err := tx.createListView(table, field.Name); err != nil {
+				return err
+			}
+			if indexed {
+				if err := tx.createListValuesIndex(table, field.Name); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// NormalizeLists converts a database from one table per list field to the normalized layout,
+// in which the values of all list fields are stored in the single table _LISTVALUES. This reduces
+// the number of tables for schemas with many list fields. Queries and all other methods work the
+// same in both layouts. Nothing is changed if the database uses the normalized layout already.
+// The conversion cannot be undone.
+func (db *MDB) NormalizeLists() error {
+	if db.listValues {
+		return nil
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err := tx.normalizeLists(); err != nil {
+		db.listValues = false
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		db.listValues = false
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,156 @@
+package minidb
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+// checkLists exercises list fields of a Person table with the list fields Tags and Scores.
+func checkLists(t *testing.T, db *MDB, layout string) {
+	tx, _ := db.Begin()
+	john, _ := db.NewItem("Person")
+	jane, _ := db.NewItem("Person")
+	if err := tx.Set("Person", john, "Tags", []Value{NewString("c"), NewString("a"), NewString("b")}); err != nil {
+		t.Errorf("%s: Set() failed: %s", layout, err)
+	}
+	tx.Set("Person", jane, "Tags", []Value{NewString("a")})
+	tx.Set("Person", jane, "Scores", []Value{NewInt(3), NewInt(1)})
+	tx.Commit()
+	if v, _ := db.Get("Person", john, "Tags"); len(v) != 3 || v[0].Str != "c" || v[1].Str != "a" || v[2].Str != "b" {
+		t.Errorf("%s: Tags are %v, expected c, a, b in this order", layout, v)
+	}
+	q, _ := ParseQuery("Person Tags=a")
+	if items, err := db.Find(q, 10); err != nil || len(items) != 2 {
+		t.Errorf("%s: Find() returned %v (%v), expected both items", layout, items, err)
+	}
+	q, _ = ParseQuery("Person Tags=b")
+	if items, _ := db.Find(q, 10); len(items) != 1 || items[0] != john {
+		t.Errorf("%s: Find() returned %v, expected %d", layout, items, john)
+	}
+
+	tx, _ = db.Begin()
+	if err := tx.Index("Person", "Tags"); err != nil {
+		t.Errorf("%s: Index() failed: %s", layout, err)
+	}
+	if err := tx.Reindex("Person"); err != nil {
+		t.Errorf("%s: Reindex() failed: %s", layout, err)
+	}
+	tx.Set("Person", john, "Tags", []Value{NewString("d")})
+	if _, err := tx.ChangeFieldType("Person", "Scores", DBStringList); err != nil {
+		t.Errorf("%s: ChangeFieldType() failed: %s", layout, err)
+	}
+	tx.Commit()
+	if v, _ := db.Get("Person", john, "Tags"); len(v) != 1 || v[0].Str != "d" {
+		t.Errorf("%s: Tags are %v after Set(), expected d", layout, v)
+	}
+	if v, _ := db.Get("Person", jane, "Scores"); len(v) != 2 || v[0].Str != "3" || v[1].Str != "1" {
+		t.Errorf("%s: Scores are %v after changing their type, expected 3, 1", layout, v)
+	}
+	fields, _ := db.GetFields("Person")
+	for _, field := range fields {
+		if field.Name == "Tags" && !db.hasIndex("Person", field) {
+			t.Errorf("%s: the index of Tags is missing", layout)
+		}
+	}
+
+	tx, _ = db.Begin()
+	if err := tx.RemoveItems("Person", []Item{jane}); err != nil {
+		t.Errorf("%s: RemoveItems() failed: %s", layout, err)
+	}
+	if err := tx.RenameTable("Person", "People"); err != nil {
+		t.Errorf("%s: RenameTable() failed: %s", layout, err)
+	}
+	tx.Commit()
+	if v, _ := db.Get("People", john, "Tags"); len(v) != 1 || v[0].Str != "d" {
+		t.Errorf("%s: Tags are %v after renaming the table, expected d", layout, v)
+	}
+	q, _ = ParseQuery("People Tags=a")
+	if items, _ := db.Find(q, 10); len(items) != 0 {
+		t.Errorf("%s: Find() returned %v for a removed item", layout, items)
+	}
+	tx, _ = db.Begin()
+	if err := tx.DropTable("People"); err != nil {
+		t.Errorf("%s: DropTable() failed: %s", layout, err)
+	}
+	tx.Commit()
+	if layout == "normalized" {
+		var n int
+		db.base.QueryRow(`SELECT COUNT(*) FROM _LISTVALUES;`).Scan(&n)
+		if n != 0 {
+			t.Errorf("%d list values left after DropTable(), expected none", n)
+		}
+	}
+}
+
+func TestNormalizedLists(t *testing.T) {
+	fields := []Field{Field{Name: "Name", Sort: DBString}, Field{Name: "Tags", Sort: DBStringList}}
+	for _, layout := range []string{"tables", "normalized"} {
+		tmp, _ := ioutil.TempFile("", "minidb-lists-testing-*")
+		defer os.Remove(tmp.Name())
+		db, err := OpenWithOptions("sqlite3", tmp.Name(), &Options{NormalizedLists: layout == "normalized"})
+		if err != nil {
+			t.Fatalf("OpenWithOptions() failed: %s", err)
+		}
+		if db.NormalizedLists() != (layout == "normalized") {
+			t.Errorf("%s: NormalizedLists() returned %v", layout, db.NormalizedLists())
+		}
+		db.AddTable("Person", fields)
+		err = db.EnsureSchema(Schema{Tables: []TableSchema{{Name: "Person",
+			Fields: append(fields, Field{Name: "Scores", Sort: DBIntList})}}})
+		if err != nil {
+			t.Errorf("%s: EnsureSchema() failed: %s", layout, err)
+		}
+		checkLists(t, db, layout)
+		db.Close()
+	}
+
+	// convert a database with values and an index
+	tmp, _ := ioutil.TempFile("", "minidb-lists-testing-*")
+	defer os.Remove(tmp.Name())
+	db, err := Open("sqlite3", tmp.Name())
+	if err != nil {
+		t.Fatalf("Open() failed: %s", err)
+	}
+	db.AddTable("Person", fields)
+	tx, _ := db.Begin()
+	john, _ := db.NewItem("Person")
+	tx.Set("Person", john, "Tags", []Value{NewString("z"), NewString("y")})
+	tx.Index("Person", "Tags")
+	tx.Commit()
+	if err := db.NormalizeLists(); err != nil {
+		t.Errorf("NormalizeLists() failed: %s", err)
+	}
+	if !db.NormalizedLists() {
+		t.Errorf("NormalizedLists() returned false after NormalizeLists()")
+	}
+	var n int
+	db.base.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE type='table' AND name=?;`,
+		listFieldToTableName("Person", "Tags")).Scan(&n)
+	if n != 0 {
+		t.Errorf("the list table still exists after NormalizeLists()")
+	}
+	if v, _ := db.Get("Person", john, "Tags"); len(v) != 2 || v[0].Str != "z" || v[1].Str != "y" {
+		t.Errorf("Tags are %v after NormalizeLists(), expected z, y", v)
+	}
+	if !db.hasIndex("Person", fields[1]) {
+		t.Errorf("the index of Tags has not been preserved by NormalizeLists()")
+	}
+	db.Close()
+
+	// the layout is detected when the database is opened again
+	db, err = Open("sqlite3", tmp.Name())
+	if err != nil {
+		t.Fatalf("Open() failed: %s", err)
+	}
+	defer db.Close()
+	if !db.NormalizedLists() {
+		t.Errorf("NormalizedLists() returned false for a converted database")
+	}
+	tx, _ = db.Begin()
+	tx.Set("Person", john, "Tags", []Value{NewString("x")})
+	tx.Commit()
+	if v, _ := db.Get("Person", john, "Tags"); len(v) != 1 || v[0].Str != "x" {
+		t.Errorf("Tags are %v in a converted database, expected x", v)
+	}
+}
@@ -0,0 +1,187 @@
+package minidb
+
+import (
+	"time"
+)
+
+// ------------------------------------------------------------------------------
+// Disabled users and login lockout of multiuser databases
+// ------------------------------------------------------------------------------
+
+// Default lockout parameters of a multiuser database, see MultiDB.SetLockout.
+const (
+	DefaultMaxFailures     = 5
+	DefaultFailureWindow   = 15 * time.Minute
+	DefaultLockoutDuration = 15 * time.Minute
+)
+
+// loginState is the login status of a user, stored in the Login table of the system database.
+type loginState struct {
+	item        Item
+	disabled    bool
+	failures    int64
+	since       time.Time
+	lockedUntil time.Time
+}
+
+// SetLockout sets how many failed attempts to authenticate a user within the window lock the
+// user for the duration. During the lockout Authenticate fails with ErrUserLocked even for the
+// correct password. A maxFailures of 0 or less turns the lockout off.
+func (m *MultiDB) SetLockout(maxFailures int, window, duration time.Duration) {
+	m.loginMutex.Lock()
+	defer m.loginMutex.Unlock()
+	m.maxFailures = maxFailures
+	m.failureWindow = window
+	m.lockoutDuration = duration
+}
+
+// nanoTime returns the time of Unix nanoseconds, or the zero time for 0.
+func nanoTime(n int64) time.Time {
+	if n == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, n)
+}
+
+// nanos returns the Unix nanoseconds of the time, or 0 for the zero time.
+func nanos(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.UnixNano()
+}
+
+// loginState returns the login status of the user with the ID. Users without entry in the
+// Login table are enabled and have no failed attempts.
+func (m *MultiDB) loginState(id Item) loginState {
+	var st loginState
+	var item, disabled, since, lockedUntil int64
+	err := m.system.reader.QueryRow(`SELECT Id,Disabled,Failures,Since,LockedUntil FROM Login WHERE User=?;`,
+		int64(id)).Scan(&item, &disabled, &st.failures, &since, &lockedUntil)
+	if err != nil {
+		return st
+	}
+	st.item, st.disabled = Item(item), disabled != 0
+	st.since, st.lockedUntil = nanoTime(since), nanoTime(lockedUntil)
+	return st
+}
+
+// saveLoginState stores the login status of the user with the ID.
+func (m *MultiDB) saveLoginState(id Item, st loginState) (ErrCode, error) {
+	disabled := int64(0)
+	if st.disabled {
+		disabled = 1
+	}
+	values := []FieldValue{
+		{Field: "User", Values: []Value{NewInt(int64(id))}},
+		{Field: "Disabled", Values: []Value{NewInt(disabled)}},
+		{Field: "Failures", Values: []Value{NewInt(st.failures)}},
+		{Field: "Since", Values: []Value{NewInt(nanos(st.since))}},
+		{Field: "LockedUntil", Values: []Value{NewInt(nanos(st.lockedUntil))}}}
+	tx, err := m.Begin()
+	if err != nil {
+		return ErrTransactionFail, err
+	}
+	defer tx.Rollback()
+	if st.item == 0 {
+		_, err = tx.NewItems("Login", [][]FieldValue{values})
+	} else {
+		fields := make(map[string][]Value, len(values))
+		for _, v := range values {
+			fields[v.Field] = v.Values
+		}
+		err = tx.SetItem("Login", st.item, fields)
+	}
+	if err != nil {
+		return ErrDBFail, Fail(`could not store the login status: %s`, err)
+	}
+	if err := tx.Commit(); err != nil {
+		return ErrTransactionFail, Fail(`multiuser database error: %s`, err)
+	}
+	return OK, nil
+}
+
+// checkLogin returns an error if the user with the ID is disabled or locked.
+func (m *MultiDB) checkLogin(username string, id Item) (ErrCode, error) {
+	st := m.loginState(id)
+	if st.disabled {
+		return ErrUserDisabled, Fail(`user "%s" is disabled`, username)
+	}
+	if time.Now().Before(st.lockedUntil) {
+		return ErrUserLocked, Fail(`user "%s" is locked after too many failed attempts to authenticate`, username)
+	}
+	return OK, nil
+}
+
+// loginFailed counts a failed attempt to authenticate the user with the ID and locks the user
+// after too many failed attempts within the failure window. Concurrent attempts are counted one
+// after the other, so that none of them is lost.
+func (m *MultiDB) loginFailed(id Item) {
+	m.loginMutex.Lock()
+	defer m.loginMutex.Unlock()
+	st := m.loginState(id)
+	now := time.Now()
+	if st.failures == 0 || now.Sub(st.since) > m.failureWindow {
+		st.failures, st.since = 0, now
+	}
+	st.failures++
+	if m.maxFailures > 0 && st.failures >= int64(m.maxFailures) {
+		st.failures, st.since, st.lockedUntil = 0, time.Time{}, now.Add(m.lockoutDuration)
+	}
+	m.saveLoginState(id, st)
+}
+
+// loginSucceeded resets the failed attempts to authenticate the user with the ID.
+func (m *MultiDB) loginSucceeded(id Item) {
+	m.loginMutex.Lock()
+	defer m.loginMutex.Unlock()
+	if st := m.loginState(id); st.failures != 0 {
+		st.failures, st.since = 0, time.Time{}
+		m.saveLoginState(id, st)
+	}
+}
+
+// DisableUser disables a user, so that Authenticate fails with ErrUserDisabled until the user
+// is enabled again. The user's data is kept.
+func (m *MultiDB) DisableUser(user *User) (ErrCode, error) {
+	if user == nil || !m.ExistingUser(user.name) {
+		return ErrUnknownUser, Fail(`unknown user`)
+	}
+	m.loginMutex.Lock()
+	defer m.loginMutex.Unlock()
+	st := m.loginState(user.id)
+	st.disabled = true
+	return m.saveLoginState(user.id, st)
+}
+
+// EnableUser enables a user disabled by DisableUser and lifts a lockout of the user.
+func (m *MultiDB) EnableUser(user *User) (ErrCode, error) {
+	if user == nil || !m.ExistingUser(user.name) {
+		return ErrUnknownUser, Fail(`unknown user`)
+	}
+	m.loginMutex.Lock()
+	defer m.loginMutex.Unlock()
+	st := m.loginState(user.id)
+	if st.item == 0 {
+		return OK, nil
+	}
+	st.disabled, st.failures, st.since, st.lockedUntil = false, 0, time.Time{}, time.Time{}
+	return m.saveLoginState(user.id, st)
+}
+
+// UserDisabled returns true if the user has been disabled with DisableUser.
+func (m *MultiDB) UserDisabled(user *User) bool {
+	return user != nil && m.loginState(user.id).disabled
+}
+
+// LockedUntil returns the time until which the user is locked after too many failed attempts
+// to authenticate, or the zero time if the user is not locked.
+func (m *MultiDB) LockedUntil(user *User) time.Time {
+	if user == nil {
+		return time.Time{}
+	}
+	if t := m.loginState(user.id).lockedUntil; time.Now().Before(t) {
+		return t
+	}
+	return time.Time{}
+}
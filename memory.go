@@ -0,0 +1,169 @@
+package minidb
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"sync/atomic"
+	"time"
+
+	sqlite3 "github.com/mattn/go-sqlite3"
+)
+
+// ------------------------------------------------------------------------------
+// In-memory databases and snapshots
+// ------------------------------------------------------------------------------
+
+// memoryCounter numbers the in-memory databases of the process.
+var memoryCounter int64
+
+// isMemory returns true if the database given in the form accepted by the sqlite3 driver is
+// a private in-memory database, which SQLite creates anew for every connection.
+func isMemory(file string) bool {
+	return file == ":memory:" || file == "file::memory:"
+}
+
+// memoryName returns the name of a new in-memory database that all connections of an MDB
+// share. It uses the memdb VFS of SQLite, which locks like a database file, so transactions
+// behave the same as for a database in a file.
+func memoryName() string {
+	return fmt.Sprintf("file:/minidb-memory-%d?vfs=memdb", atomic.AddInt64(&memoryCounter, 1))
+}
+
+// keepMemory holds a connection to an in-memory database, which would otherwise be lost when
+// the connection pool closes its last connection.
+func (db *MDB) keepMemory() error {
+	conn, err := db.base.Conn(context.Background())
+	if err != nil {
+		return Fail("cannot open in-memory database: %s", err)
+	}
+	db.memory = conn
+	return nil
+}
+
+// InMemory returns true if the database has been opened with the name ":memory:". Its
+// contents are lost when it is closed, unless they are saved with SaveTo.
+func (db *MDB) InMemory() bool {
+	return db.memory != nil
+}
+
+// SaveTo writes a consistent copy of the database to the file like Backup. The copy is an
+// ordinary database that can be opened with Open or loaded into another database with
+// LoadFrom, so this is the way to persist an in-memory database.
+func (db *MDB) SaveTo(file string) error {
+	return db.Backup(file)
+}
+
+// LoadFrom replaces the contents of the database by those of the database file, which may
+// have been written by SaveTo or be any other minidb database. It waits for the transaction
+// in progress like Begin. Listeners registered with OnChange receive a ChangeSchema event
+// without a table. The database must use the sqlite3 driver.
+func (db *MDB) LoadFrom(file string) error {
+	if db.base == nil || db.globalLock == nil {
+		return Fail("cannot load into a closed database")
+	}
+	if db.tx != nil {
+		return Fail("cannot load into a view of a transaction")
+	}
+	if _, err := os.Stat(file); err != nil {
+		return Fail("cannot load database: %s", err)
+	}
+	if err := db.waitForTx(); err != nil {
+		return err
+	}
+	defer func() { <-db.txSlot }()
+	src, err := db.openWithKey(file)
+	if err != nil {
+		return Fail("cannot load database: %s", err)
+	}
+	defer src.Close()
+	if err := copyDatabase(db.base, src, nil); err != nil {
+		return Fail("cannot load database: %s", err)
+	}
+	db.statsLock.Lock()
+	db.stats = make(map[string]*TableStats)
+	db.statsLock.Unlock()
+	// the loaded database may have been written by an older version or without the options
+	db.globalLock.Lock()
+	err = db.init()
+	if err == nil {
+		db.initPending = false
+		db.reader = db.base
+	}
+	db.globalLock.Unlock()
+	if err != nil {
+		return Fail("cannot initialize loaded database: %s", err)
+	}
+	db.notify(ChangeEvent{Kind: ChangeSchema})
+	return nil
+}
+
+// backupStepPages is the number of pages copied by a step of copyDatabase. The source is
+// only locked during a step, so other connections can write between the steps.
+const backupStepPages = 256
+
+// backupWait is the time copyDatabase waits if the source is locked.
+const backupWait = 10 * time.Millisecond
+
+// copyDatabase replaces the contents of the destination by those of the source with the
+// backup API of SQLite, which copies a consistent state of the source. If the source is
+// changed by another connection during the copy, the copy starts over. If progress is not
+// nil, it is called after each step with the number of pages copied and the total number.
+func copyDatabase(dest *sql.DB, src *sql.DB, progress func(copied, total int)) error {
+	ctx := context.Background()
+	destConn, err := dest.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer destConn.Close()
+	srcConn, err := src.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer srcConn.Close()
+	return destConn.Raw(func(d interface{}) error {
+		return srcConn.Raw(func(s interface{}) error {
+			destSQLite, ok1 := sqliteConn(d)
+			srcSQLite, ok2 := sqliteConn(s)
+			if !ok1 || !ok2 {
+				return Fail("the database does not use the sqlite3 driver")
+			}
+			backup, err := destSQLite.Backup("main", srcSQLite, "main")
+			if err != nil {
+				return err
+			}
+			remaining := -1
+			for {
+				done, err := backup.Step(backupStepPages)
+				if err != nil {
+					backup.Finish()
+					return err
+				}
+				if progress != nil {
+					total := backup.PageCount()
+					progress(total-backup.Remaining(), total)
+				}
+				if done {
+					break
+				}
+				// a step that copies nothing has found the source locked
+				if backup.Remaining() == remaining {
+					time.Sleep(backupWait)
+				}
+				remaining = backup.Remaining()
+			}
+			return backup.Finish()
+		})
+	})
+}
+
+// sqliteConn returns the connection of the sqlite3 driver of a driver connection, which may
+// be wrapped to retry statements, see Options.BusyRetries.
+func sqliteConn(conn interface{}) (*sqlite3.SQLiteConn, bool) {
+	if busy, ok := conn.(*busyConn); ok {
+		conn = busy.Conn
+	}
+	c, ok := conn.(*sqlite3.SQLiteConn)
+	return c, ok
+}
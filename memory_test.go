@@ -0,0 +1,90 @@
+package minidb
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestInMemory(t *testing.T) {
+	db, err := Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatalf("Open() failed: %s", err)
+	}
+	defer db.Close()
+	if !db.InMemory() {
+		t.Errorf("InMemory() returned false for :memory:")
+	}
+	other, _ := Open("sqlite3", ":memory:")
+	defer other.Close()
+	if err := db.AddTable("Person", []Field{Field{Name: "Name", Sort: DBString}}); err != nil {
+		t.Fatalf("AddTable() failed: %s", err)
+	}
+	if other.TableExists("Person") {
+		t.Errorf("in-memory databases share their tables")
+	}
+
+	// all connections see the same database, and transactions are isolated
+	item, _ := db.NewItem("Person")
+	read, err := db.BeginRead()
+	if err != nil {
+		t.Fatalf("BeginRead() failed: %s", err)
+	}
+	tx, _ := db.Begin()
+	if err := tx.Set("Person", item, "Name", []Value{NewString("John")}); err != nil {
+		t.Errorf("Set() failed: %s", err)
+	}
+	if v, _ := read.View().Get("Person", item, "Name"); len(v) == 1 && v[0].Str == "John" {
+		t.Errorf("a read-only transaction sees %v before the commit", v)
+	}
+	read.Rollback()
+	if err := tx.Commit(); err != nil {
+		t.Errorf("Commit() failed: %s", err)
+	}
+	if v, _ := db.Get("Person", item, "Name"); len(v) != 1 || v[0].Str != "John" {
+		t.Errorf("Get() returned %v, expected John", v)
+	}
+	tx, _ = db.Begin()
+	tx.SetInt(1, 42)
+	tx.Commit()
+
+	tmp, _ := ioutil.TempFile("", "minidb-memory-testing-*")
+	tmp.WriteString("replaced")
+	tmp.Close()
+	defer os.Remove(tmp.Name())
+	if err := db.SaveTo(tmp.Name()); err != nil {
+		t.Fatalf("SaveTo() failed: %s", err)
+	}
+	saved, err := OpenExisting("sqlite3", tmp.Name())
+	if err != nil {
+		t.Fatalf("cannot open saved database: %s", err)
+	}
+	if v, _ := saved.Get("Person", item, "Name"); len(v) != 1 || v[0].Str != "John" {
+		t.Errorf("the saved database contains %v, expected John", v)
+	}
+	saved.Close()
+
+	var events []ChangeEvent
+	other.OnChange(func(event ChangeEvent) { events = append(events, event) })
+	if err := other.LoadFrom(tmp.Name()); err != nil {
+		t.Fatalf("LoadFrom() failed: %s", err)
+	}
+	if len(events) != 1 || events[0].Kind != ChangeSchema {
+		t.Errorf("LoadFrom() reported %v, expected a schema change", events)
+	}
+	if v, _ := other.Get("Person", item, "Name"); len(v) != 1 || v[0].Str != "John" {
+		t.Errorf("the loaded database contains %v, expected John", v)
+	}
+	if n := other.GetInt(1); n != 42 {
+		t.Errorf("GetInt() returned %d after LoadFrom(), expected 42", n)
+	}
+	if err := other.LoadFrom(tmp.Name() + "-missing"); err == nil {
+		t.Errorf("LoadFrom() of a missing file should fail")
+	}
+	if err := db.Backup(tmp.Name()); err != nil {
+		t.Errorf("Backup() of an in-memory database failed: %s", err)
+	}
+	if v, _ := db.Get("Person", item, "Name"); len(v) != 1 {
+		t.Errorf("the in-memory database is lost after Backup()")
+	}
+}
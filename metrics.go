@@ -0,0 +1,175 @@
+package minidb
+
+import (
+	"fmt"
+	"io"
+	"sync/atomic"
+	"time"
+)
+
+// ------------------------------------------------------------------------------
+// Metrics of the commands executed by an executor
+// ------------------------------------------------------------------------------
+
+// latencyBounds are the upper bounds of the buckets of the latency histograms.
+var latencyBounds = [...]time.Duration{time.Millisecond, 5 * time.Millisecond, 10 * time.Millisecond,
+	50 * time.Millisecond, 100 * time.Millisecond, 500 * time.Millisecond, time.Second, 5 * time.Second}
+
+// commandMetrics are the counters of a command type, which are updated atomically. The last
+// bucket counts the executions that took longer than all bounds.
+type commandMetrics struct {
+	executed int64
+	failed   int64
+	nanos    int64
+	buckets  [len(latencyBounds) + 1]int64
+}
+
+// CommandStats are the statistics of a command type. Duration is the total time of all
+// executions. Buckets[i] is the number of executions that took at most Bounds[i] of the
+// ExecutorStats but longer than the previous bound, and the last bucket counts those that took
+// longer than all bounds.
+type CommandStats struct {
+	ID       CommandID
+	Executed int64
+	Failed   int64
+	Duration time.Duration
+	Buckets  []int64
+}
+
+// ExecutorStats are the statistics of the commands executed by an executor since it was created,
+// as returned by a Stats command. Only command types that have been executed are listed.
+type ExecutorStats struct {
+	Uptime   time.Duration
+	Bounds   []time.Duration
+	Commands []CommandStats
+}
+
+// recordCommand adds an execution of a command to the metrics of its type.
+func (e *Executor) recordCommand(id CommandID, failed bool, d time.Duration) {
+	if id <= 0 || id >= maxCommand {
+		return
+	}
+	m := &e.metrics[id]
+	atomic.AddInt64(&m.executed, 1)
+	if failed {
+		atomic.AddInt64(&m.failed, 1)
+	}
+	atomic.AddInt64(&m.nanos, int64(d))
+	bucket := len(latencyBounds)
+	for i, bound := range latencyBounds {
+		if d <= bound {
+			bucket = i
+			break
+		}
+	}
+	atomic.AddInt64(&m.buckets[bucket], 1)
+}
+
+// Stats returns the statistics of the commands executed by the executor, ordered by command ID.
+// The counters are read one after the other while commands are executed, so they may be slightly
+// inconsistent with each other.
+func (e *Executor) Stats() *ExecutorStats {
+	stats := ExecutorStats{Uptime: time.Since(e.started), Bounds: append([]time.Duration{}, latencyBounds[:]...),
+		Commands: make([]CommandStats, 0)}
+	for id := CmdOpen; id < maxCommand; id++ {
+		m := &e.metrics[id]
+		executed := atomic.LoadInt64(&m.executed)
+		if executed == 0 {
+			continue
+		}
+		cs := CommandStats{ID: id, Executed: executed, Failed: atomic.LoadInt64(&m.failed),
+			Duration: time.Duration(atomic.LoadInt64(&m.nanos)), Buckets: make([]int64, len(m.buckets))}
+		for i := range m.buckets {
+			cs.Buckets[i] = atomic.LoadInt64(&m.buckets[i])
+		}
+		stats.Commands = append(stats.Commands, cs)
+	}
+	return &stats
+}
+
+// toResult stores the statistics in the result of a Stats command. Int holds the uptime in
+// milliseconds. Ints holds the number of bounds, the bounds in nanoseconds, and then for each
+// command type its ID, the number of executions and failures, the total duration in nanoseconds,
+// and the buckets.
+func (stats *ExecutorStats) toResult(r *Result) {
+	r.Int = stats.Uptime.Milliseconds()
+	r.Ints = make([]int64, 0, 1+len(stats.Bounds)+len(stats.Commands)*(5+len(stats.Bounds)))
+	r.Ints = append(r.Ints, int64(len(stats.Bounds)))
+	for _, bound := range stats.Bounds {
+		r.Ints = append(r.Ints, int64(bound))
+	}
+	for _, cs := range stats.Commands {
+		r.Ints = append(r.Ints, int64(cs.ID), cs.Executed, cs.Failed, int64(cs.Duration))
+		r.Ints = append(r.Ints, cs.Buckets...)
+	}
+}
+
+// ParseStats returns the statistics in the result of a Stats command.
+func ParseStats(r *Result) *ExecutorStats {
+	stats := ExecutorStats{Uptime: time.Duration(r.Int) * time.Millisecond, Commands: make([]CommandStats, 0)}
+	if len(r.Ints) == 0 {
+		return &stats
+	}
+	n := int(r.Ints[0])
+	if n < 0 || 1+n > len(r.Ints) {
+		return &stats
+	}
+	stats.Bounds = make([]time.Duration, n)
+	for i := range stats.Bounds {
+		stats.Bounds[i] = time.Duration(r.Ints[1+i])
+	}
+	stride := 5 + n
+	for i := 1 + n; i+stride <= len(r.Ints); i += stride {
+		stats.Commands = append(stats.Commands, CommandStats{ID: CommandID(r.Ints[i]), Executed: r.Ints[i+1],
+			Failed: r.Ints[i+2], Duration: time.Duration(r.Ints[i+3]), Buckets: r.Ints[i+4 : i+stride]})
+	}
+	return &stats
+}
+
+// WritePrometheus writes the statistics to w in the text format of Prometheus. The commands are
+// labelled with their numeric IDs, which are those of the Cmd constants.
+func (stats *ExecutorStats) WritePrometheus(w io.Writer) error {
+	var err error
+	printf := func(format string, args ...interface{}) {
+		if err == nil {
+			_, err = fmt.Fprintf(w, format, args...)
+		}
+	}
+	printf("# HELP minidb_uptime_seconds Time since the executor was created.\n")
+	printf("# TYPE minidb_uptime_seconds gauge\n")
+	printf("minidb_uptime_seconds %g\n", stats.Uptime.Seconds())
+	printf("# HELP minidb_commands_total Number of executed commands.\n")
+	printf("# TYPE minidb_commands_total counter\n")
+	for _, cs := range stats.Commands {
+		printf("minidb_commands_total{command=\"%d\"} %d\n", cs.ID, cs.Executed)
+	}
+	printf("# HELP minidb_commands_failed_total Number of commands that failed.\n")
+	printf("# TYPE minidb_commands_failed_total counter\n")
+	for _, cs := range stats.Commands {
+		printf("minidb_commands_failed_total{command=\"%d\"} %d\n", cs.ID, cs.Failed)
+	}
+	printf("# HELP minidb_command_duration_seconds Time needed to execute commands.\n")
+	printf("# TYPE minidb_command_duration_seconds histogram\n")
+	for _, cs := range stats.Commands {
+		// Prometheus buckets are cumulative
+		var count int64
+		for i, bound := range stats.Bounds {
+			if i < len(cs.Buckets) {
+				count += cs.Buckets[i]
+			}
+			printf("minidb_command_duration_seconds_bucket{command=\"%d\",le=\"%g\"} %d\n", cs.ID, bound.Seconds(), count)
+		}
+		printf("minidb_command_duration_seconds_bucket{command=\"%d\",le=\"+Inf\"} %d\n", cs.ID, cs.Executed)
+		printf("minidb_command_duration_seconds_sum{command=\"%d\"} %g\n", cs.ID, cs.Duration.Seconds())
+		printf("minidb_command_duration_seconds_count{command=\"%d\"} %d\n", cs.ID, cs.Executed)
+	}
+	return err
+}
+
+// StatsCommand returns a pointer to a command structure for Executor.Stats(). The statistics
+// are returned in Int and Ints, see ParseStats.
+func StatsCommand() *Command {
+	return &Command{
+		ID: CmdStats,
+	}
+}
@@ -0,0 +1,90 @@
+package minidb
+
+import (
+	"sort"
+	"time"
+)
+
+// ------------------------------------------------------------------------------
+// Migrations
+// ------------------------------------------------------------------------------
+
+// Migration is a numbered change of the database, usually of its schema. Versions must be
+// positive and are applied in increasing order. Up is run in a transaction, which is rolled back
+// if Up returns an error. Up must not commit or roll back the transaction itself.
+type Migration struct {
+	Version int64
+	Up      func(tx *Tx) error
+}
+
+// SchemaVersion returns the version of the last migration applied by Migrate,
+// or 0 if no migration has been applied yet.
+func (db *MDB) SchemaVersion() (int64, error) {
+	var version int64
+	err := db.reader.QueryRow(`SELECT COALESCE(MAX(Version),0) FROM _MIGRATIONS;`).Scan(&version)
+	if err != nil {
+		return 0, Fail("cannot determine schema version: %s", err)
+	}
+	return version, nil
+}
+
+// Migrate applies all migrations whose version is higher than the current schema version,
+// each in its own transaction, and records their versions in the database. If a migration fails,
+// the migrations applied before it remain in effect and an error is returned. Concurrent calls
+// apply each migration only once.
+func (db *MDB) Migrate(migrations []Migration) error {
+	sorted := make([]Migration, len(migrations))
+	copy(sorted, migrations)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Version < sorted[j].Version })
+	for i := range sorted {
+		if sorted[i].Version <= 0 {
+			return Fail("invalid migration version %d, versions must be positive", sorted[i].Version)
+		}
+		if i > 0 && sorted[i].Version == sorted[i-1].Version {
+			return Fail("duplicate migration version %d", sorted[i].Version)
+		}
+		if sorted[i].Up == nil {
+			return Fail("migration %d has no Up function", sorted[i].Version)
+		}
+	}
+	current, err := db.SchemaVersion()
+	if err != nil {
+		return err
+	}
+	for _, m := range sorted {
+		if m.Version <= current {
+			continue
+		}
+		if err := db.applyMigration(m); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// applyMigration runs the migration in a transaction unless it has already been applied,
+// possibly by a concurrent call of Migrate, when the transaction begins.
+func (db *MDB) applyMigration(m Migration) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	var current int64
+	err = tx.tx.QueryRow(`SELECT COALESCE(MAX(Version),0) FROM _MIGRATIONS;`).Scan(&current)
+	if err != nil {
+		return Fail("cannot determine schema version: %s", err)
+	}
+	if m.Version <= current {
+		return nil
+	}
+	if err := m.Up(tx); err != nil {
+		return Fail("migration %d failed: %s", m.Version, err)
+	}
+	_, err = tx.tx.Exec(`INSERT INTO _MIGRATIONS (Version,Applied) VALUES (?,?);`,
+		m.Version, time.Now().Format(time.RFC3339))
+	if err != nil {
+		return Fail("cannot record migration %d: %s", m.Version, err)
+	}
+	return tx.Commit()
+}
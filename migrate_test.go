@@ -0,0 +1,114 @@
+package minidb
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"sync"
+	"testing"
+)
+
+func TestMigrate(t *testing.T) {
+	tmp, _ := ioutil.TempFile("", "minidb-migrate-testing-*")
+	defer os.Remove(tmp.Name())
+	db, err := Open("sqlite3", tmp.Name())
+	if err != nil {
+		t.Errorf("Open() failed: %s", err)
+	}
+	defer db.Close()
+	if v, err := db.SchemaVersion(); err != nil || v != 0 {
+		t.Errorf("SchemaVersion() returned %d, %v for a new database", v, err)
+	}
+	runs := 0
+	migrations := []Migration{
+		Migration{Version: 2, Up: func(tx *Tx) error {
+			runs++
+			return tx.Index("Person", "Name")
+		}},
+		Migration{Version: 1, Up: func(tx *Tx) error {
+			runs++
+			return tx.AddTable("Person", []Field{Field{Name: "Name", Sort: DBString}})
+		}},
+	}
+	if err := db.Migrate(migrations); err != nil {
+		t.Errorf("Migrate() failed: %s", err)
+	}
+	if v, err := db.SchemaVersion(); err != nil || v != 2 || runs != 2 {
+		t.Errorf("SchemaVersion() returned %d, %v after %d migrations, expected 2", v, err, runs)
+	}
+	if err := db.Migrate(migrations); err != nil || runs != 2 {
+		t.Errorf("Migrate() should not run applied migrations again: %v", err)
+	}
+	migrations = append(migrations, Migration{Version: 3, Up: func(tx *Tx) error {
+		if err := tx.RenameTable("Person", "Human"); err != nil {
+			return err
+		}
+		return errors.New("failed on purpose")
+	}})
+	if err := db.Migrate(migrations); err == nil {
+		t.Errorf("Migrate() should fail when a migration fails")
+	}
+	if v, _ := db.SchemaVersion(); v != 2 || !db.TableExists("Person") || db.TableExists("Human") {
+		t.Errorf("Migrate() did not roll back a failed migration")
+	}
+	dup := []Migration{Migration{Version: 4, Up: func(tx *Tx) error { return nil }},
+		Migration{Version: 4, Up: func(tx *Tx) error { return nil }}}
+	if err := db.Migrate(dup); err == nil {
+		t.Errorf("Migrate() should fail for duplicate versions")
+	}
+	if err := db.Migrate([]Migration{Migration{Version: 0, Up: func(tx *Tx) error { return nil }}}); err == nil {
+		t.Errorf("Migrate() should fail for version 0")
+	}
+}
+
+func TestMigrateWrites(t *testing.T) {
+	tmp, _ := ioutil.TempFile("", "minidb-migrate-testing-*")
+	defer os.Remove(tmp.Name())
+	db, err := Open("sqlite3", tmp.Name())
+	if err != nil {
+		t.Errorf("Open() failed: %s", err)
+	}
+	defer db.Close()
+	runs := 0
+	migrations := []Migration{
+		Migration{Version: 1, Up: func(tx *Tx) error {
+			runs++
+			if err := tx.AddTable("Person", []Field{Field{Name: "Name", Sort: DBString}}); err != nil {
+				return err
+			}
+			if err := tx.Index("Person", "Name"); err != nil {
+				return err
+			}
+			items, err := tx.NewItems("Person", [][]FieldValue{nil})
+			if err != nil {
+				return err
+			}
+			return tx.Set("Person", items[0], "Name", []Value{NewString("John")})
+		}},
+	}
+	// concurrent calls must apply each migration only once
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := db.Migrate(migrations); err != nil {
+				t.Errorf("Migrate() failed: %s", err)
+			}
+		}()
+	}
+	wg.Wait()
+	if v, err := db.SchemaVersion(); err != nil || v != 1 || runs != 1 {
+		t.Errorf("SchemaVersion() returned %d, %v after %d runs, expected 1", v, err, runs)
+	}
+	if !db.hasIndex("Person", Field{Name: "Name", Sort: DBString}) {
+		t.Errorf("Migrate() did not create the index")
+	}
+	items, err := db.ListItems("Person", 0)
+	if err != nil || len(items) != 1 {
+		t.Fatalf("expected one Person after Migrate(), given %v, %v", items, err)
+	}
+	if got, _ := db.Get("Person", items[0], "Name"); len(got) != 1 || got[0].String() != "John" {
+		t.Errorf("expected the Name John written by the migration, given %v", got)
+	}
+}
@@ -8,16 +8,19 @@ package minidb
 
 import (
 	"database/sql"
+	"database/sql/driver"
 	"encoding/base64"
 	"encoding/binary"
 	"errors"
 	"fmt"
-	"io"
 	"os"
+	"path/filepath"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	_ "github.com/mattn/go-sqlite3" // The driver for sqlite3 is pulled in.
@@ -25,11 +28,41 @@ import (
 
 // MDB is the main database object.
 type MDB struct {
-	base       *sql.DB
-	tx         *Tx
-	driver     string
-	location   string
-	globalLock *sync.Mutex
+	base           *sql.DB
+	reader         querier
+	tx             *Tx
+	driver         string
+	location       string
+	globalLock     *sync.Mutex
+	txSlot         chan struct{}
+	readTxs        *int64
+	keepOpen       bool
+	stats          map[string]*TableStats
+	statsLock      *sync.Mutex
+	maxSize        int64
+	emptyForAbsent bool
+	kvDisabled     bool
+	initPending    bool
+	hooks          *changeHooks
+	validators     *validators
+	audit          bool
+	auditOption    bool
+	auditUser      string
+	cache          *lookupCache
+	listValues     bool
+	listsOption    bool
+	options        Options
+	memory         *sql.Conn
+	connector      *pragmaConnector
+	blobs          *blobStore
+}
+
+// querier is implemented by sql.DB and sql.Tx. All reads of an MDB use its reader, which is
+// the database itself or, for a view returned by Tx.View, the transaction.
+type querier interface {
+	execer
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
 }
 
 // Tx represents a transaction similar to sql.Tx.
@@ -39,6 +72,10 @@ type Tx struct {
 	mdb       *MDB
 	savePoint uint
 	released  bool
+	readOnly  bool
+	events    []ChangeEvent
+	auditUser string
+	orphans   []string
 }
 
 var savePointCounter uint
@@ -68,9 +105,13 @@ const (
 	DBDate
 	// DBDateList is the type of a list of RFC 3339 dates field.
 	DBDateList
+	// DBNull is the type of a NULL value, which can be stored in any single field that is not
+	// required. It is not a field type.
+	DBNull
 )
 
-// ToBaseType converts a list type into the list's base type. A non-list type remains unchanged.
+// ToBaseType converts a list type into the list's base type and a custom type into the type in
+// which its values are stored. Other types remain unchanged.
 func ToBaseType(t FieldType) FieldType {
 	switch t {
 	case DBIntList:
@@ -82,14 +123,23 @@ func ToBaseType(t FieldType) FieldType {
 	case DBDateList:
 		return DBDate
 	default:
+		if custom := lookupCustomType(t); custom != nil {
+			return custom.Base
+		}
 		return t
 	}
 }
 
-// Field represents a database field.
+// Field represents a database field. A required field cannot be set to an empty value
+// and must have a default value, which is used for new items. The default of a list field
+// is stored as a list with one element. The access policy restricts which clients of an
+// Executor may write the field.
 type Field struct {
-	Name string    `json:"name"`
-	Sort FieldType `json:"sort"`
+	Name     string      `json:"name"`
+	Sort     FieldType   `json:"sort"`
+	Required bool        `json:"required"`
+	Default  *Value      `json:"default"`
+	Access   FieldAccess `json:"access"`
 }
 
 // Fail returns a new error message formatted with fmt.Sprintf.
@@ -117,7 +167,7 @@ func (v *Value) Int() int64 {
 
 // String returns the string value. It automatically converts int and blob,
 // where binary Blob data is Base64 encoded and the int is converted
-// to decimal format.
+// to decimal format. A NULL value is converted to the empty string.
 func (v *Value) String() string {
 	switch v.Sort {
 	case DBInt:
@@ -126,6 +176,8 @@ func (v *Value) String() string {
 		return v.Str
 	case DBBlob:
 		return base64.StdEncoding.EncodeToString([]byte(v.Str))
+	case DBNull:
+		return ""
 	default:
 		panic(fmt.Sprintf("cannot convert %s value to string",
 			GetUserTypeString(v.Sort)))
@@ -188,12 +240,74 @@ func NewDate(t time.Time) Value {
 	return Value{Str: t.UTC().Format(time.RFC3339), Sort: DBDate}
 }
 
+// NewNull creates a NULL value, which represents the absence of a value in a single field.
+func NewNull() Value {
+	return Value{Sort: DBNull}
+}
+
+// IsNull returns true if the value is NULL.
+func (v *Value) IsNull() bool {
+	return v.Sort == DBNull
+}
+
 // NewDateStr creates a value that holds a datetime given by a RFC3339 representation.
 // The correctness of the date string is not validated, so use this function with care.
 func NewDateStr(d string) Value {
 	return Value{Str: d, Sort: DBDate}
 }
 
+// Value implements driver.Valuer, so values can be used as arguments of queries against Base().
+// Ints are passed as int64, blobs as []byte, strings and dates as string, and NULL as nil, just
+// like minidb stores them.
+func (v Value) Value() (driver.Value, error) {
+	switch v.Sort {
+	case DBInt, DBString, DBBlob, DBDate, DBNull:
+		return sqlArg(v), nil
+	default:
+		return nil, Fail("cannot use %s value as query argument", GetUserTypeString(v.Sort))
+	}
+}
+
+// Scan implements sql.Scanner, so values can be scanned from the rows of queries against Base().
+// If the value already has a type, the column value is converted to that type. Otherwise, the type
+// is determined by the column value, where an int64 becomes an int, a []byte a blob, a string a string,
+// and a time.Time a date. Since blobs and dates may be returned as strings by the driver, set the type
+// to DBBlob or DBDate before scanning them. NULL is scanned as a NULL value.
+func (v *Value) Scan(src interface{}) error {
+	sort := v.Sort
+	switch x := src.(type) {
+	case nil:
+		*v = NewNull()
+		return nil
+	case time.Time:
+		if sort == 0 || ToBaseType(sort) == DBDate {
+			*v = NewDate(x)
+			return nil
+		}
+		src = x.UTC().Format(time.RFC3339)
+	case int64:
+		if sort == 0 {
+			sort = DBInt
+		}
+	case []byte:
+		if sort == 0 {
+			sort = DBBlob
+		}
+	case string:
+		if sort == 0 {
+			sort = DBString
+		}
+	default:
+		return Fail("cannot scan a value of type %T", src)
+	}
+	result, err := rawToValue(src, sort)
+	if err != nil {
+		return err
+	}
+	*v = result
+	return nil
+}
+
 var validTable *regexp.Regexp
 var validFieldName *regexp.Regexp
 var validItemName *regexp.Regexp
@@ -217,6 +331,10 @@ func listFieldToTableName(ownerTable string, field string) string {
 	return "_" + ownerTable + "_" + field
 }
 
+func indexName(realtable string, field string) string {
+	return field + "_" + realtable + "_IDX"
+}
+
 func getTypeString(field FieldType) string {
 	switch field {
 	case DBString, DBStringList:
@@ -226,6 +344,9 @@ func getTypeString(field FieldType) string {
 	case DBDate, DBDateList:
 		return "DATE"
 	default:
+		if custom := lookupCustomType(field); custom != nil {
+			return getTypeString(custom.Base)
+		}
 		return "INTEGER"
 	}
 }
@@ -249,7 +370,12 @@ func GetUserTypeString(field FieldType) string {
 		return "date"
 	case DBDateList:
 		return "date-list"
+	case DBNull:
+		return "null"
 	default:
+		if custom := lookupCustomType(field); custom != nil {
+			return custom.Name
+		}
 		return "unknown"
 	}
 }
@@ -274,21 +400,28 @@ func parseFieldType(ident string) (FieldType, error) {
 	case "date-list":
 		return DBDateList, nil
 	}
+	if code, ok := customTypeByName(s); ok {
+		return code, nil
+	}
 	return DBError,
 		Fail("Invalid field type '%s', should be one of int,string,blob,int-list,string-list,blob-list", ident)
 }
 
 // ParseFieldDesc parses the given string slice into a []Field slice based on
-// the format "type name", or returns an error. This can be used for command line parsing.
+// the format "type name [required] [default value] [readonly|adminonly]", or returns an error.
+// This can be used for command line parsing. Default values are given in the same
+// format as in ParseFieldValues, e.g. "int Age required default 0". The last modifiers
+// set the access policy of the field, see FieldAccess.
 func ParseFieldDesc(desc []string) ([]Field, error) {
 	result := make([]Field, 0)
-	if len(desc)%2 != 0 {
-		return nil, Fail("invalid field descriptions, they must be of the form <type> <fieldname>!")
-	}
 	if len(desc) == 0 {
 		return nil, Fail("no fields specified!")
 	}
-	for i := 0; i < len(desc)-1; i += 2 {
+	i := 0
+	for i < len(desc) {
+		if i+1 >= len(desc) {
+			return nil, Fail("invalid field descriptions, they must be of the form <type> <fieldname> [required] [default <value>]!")
+		}
 		ftype, err := parseFieldType(desc[i])
 		if err != nil {
 			return nil, err
@@ -299,22 +432,98 @@ func ParseFieldDesc(desc []string) ([]Field, error) {
 		if strings.ToLower(desc[i+1]) == "id" {
 			return nil, Fail("fields may not be called 'id'!")
 		}
-		result = append(result, Field{desc[i+1], ftype})
+		field := Field{Name: desc[i+1], Sort: ftype}
+		i += 2
+	modifiers:
+		for i < len(desc) {
+			switch strings.ToLower(desc[i]) {
+			case "required":
+				field.Required = true
+				i++
+			case "readonly":
+				field.Access = AccessReadOnly
+				i++
+			case "adminonly":
+				field.Access = AccessAdminOnly
+				i++
+			case "default":
+				if i+1 >= len(desc) {
+					return nil, Fail("missing default value for field '%s'", field.Name)
+				}
+				v, err := parseValue(ToBaseType(ftype), desc[i+1])
+				if err != nil {
+					return nil, Fail("invalid default value for field '%s': %s", field.Name, err)
+				}
+				field.Default = &v
+				i += 2
+			default:
+				break modifiers
+			}
+		}
+		result = append(result, field)
 	}
 	return result, nil
 }
 
+// validateFieldConstraints returns an error if the default value of the field does not
+// match its type or if the field is required and has no default value.
+func validateFieldConstraints(field Field) error {
+	if !validFieldType(field.Sort) {
+		return Fail("field '%s' has unknown type %d", field.Name, field.Sort)
+	}
+	if field.Default != nil && field.Default.Sort != ToBaseType(field.Sort) {
+		return Fail("type error in default value of field '%s': %w", field.Name,
+			&TypeMismatchError{Expected: ToBaseType(field.Sort), Encountered: field.Default.Sort})
+	}
+	if field.Required && field.Default == nil {
+		return Fail("required field '%s' needs a default value", field.Name)
+	}
+	if !validFieldAccess(field.Access) {
+		return Fail("field '%s' has unknown access policy %d", field.Name, field.Access)
+	}
+	if field.Default != nil {
+		return checkCustomValues(field.Sort, []Value{*field.Default})
+	}
+	return nil
+}
+
+// sqlLiteral returns the value as an SQL literal suitable for DEFAULT clauses.
+func sqlLiteral(v Value) string {
+	switch v.Sort {
+	case DBInt:
+		return strconv.FormatInt(v.Num, 10)
+	case DBBlob:
+		return fmt.Sprintf("X'%x'", v.Str)
+	default:
+		return "'" + strings.Replace(v.Str, "'", "''", -1) + "'"
+	}
+}
+
+// columnConstraints returns the NOT NULL and DEFAULT clauses for a column of a single field.
+func columnConstraints(field Field) string {
+	s := ""
+	if field.Required {
+		s += " NOT NULL"
+	}
+	if field.Default != nil {
+		s += " DEFAULT " + sqlLiteral(*field.Default)
+	}
+	return s
+}
+
 var errNilDB = Fail("db object is nil")
 
 func (db *MDB) init() error {
 	if db.base == nil {
 		return errNilDB
 	}
-	tx, err := db.Begin()
+	// the transaction is not registered in db.tx, because init may be called by Begin
+	sqltx, err := db.base.Begin()
 	if err != nil {
 		return err
 	}
-	defer tx.Rollback()
+	defer sqltx.Rollback()
+	tx := &Tx{tx: sqltx, mdb: db}
 	_, err = tx.tx.Exec(`CREATE TABLE IF NOT EXISTS _TABLES (Id INTEGER PRIMARY KEY,
 Name TEXT NOT NULL)`)
 	if err != nil {
@@ -328,10 +537,97 @@ Name TEXT NOT NULL)`)
 	 Name STRING NOT NULL,
 	 FieldType INTEGER NOT NULL,
 	Owner INTEGER NOT NULL,
+	Required INTEGER NOT NULL DEFAULT 0,
+	DefaultValue TEXT,
+	Access INTEGER NOT NULL DEFAULT 0,
 	FOREIGN KEY(Owner) REFERENCES _TABLES(Id))`)
 	if err != nil {
 		return err
 	}
+	// databases created by earlier versions lack the field constraint columns
+	if err = tx.addColumnIfMissing("_COLS", "Required", "INTEGER NOT NULL DEFAULT 0"); err != nil {
+		return err
+	}
+	if err = tx.addColumnIfMissing("_COLS", "DefaultValue", "TEXT"); err != nil {
+		return err
+	}
+	if err = tx.addColumnIfMissing("_COLS", "Access", "INTEGER NOT NULL DEFAULT 0"); err != nil {
+		return err
+	}
+	// the retention of the history of a table in nanoseconds, NULL if it is not recorded
+	if err = tx.addColumnIfMissing("_TABLES", "History", "INTEGER"); err != nil {
+		return err
+	}
+	_, err = tx.tx.Exec(`CREATE TABLE IF NOT EXISTS _LINKS (Id INTEGER PRIMARY KEY,
+	TableA TEXT NOT NULL,
+	ItemA INTEGER NOT NULL,
+	TableB TEXT NOT NULL,
+	ItemB INTEGER NOT NULL,
+	Relation TEXT NOT NULL)`)
+	if err != nil {
+		return err
+	}
+	_, err = tx.tx.Exec(`CREATE UNIQUE INDEX IF NOT EXISTS _LINKSAIDX ON _LINKS (TableA, ItemA, Relation, TableB, ItemB)`)
+	if err != nil {
+		return err
+	}
+	_, err = tx.tx.Exec(`CREATE INDEX IF NOT EXISTS _LINKSBIDX ON _LINKS (TableB, ItemB, Relation)`)
+	if err != nil {
+		return err
+	}
+	_, err = tx.tx.Exec(`CREATE TABLE IF NOT EXISTS _HISTORY (Id INTEGER PRIMARY KEY,
+	TableName TEXT NOT NULL,
+	Item INTEGER NOT NULL,
+	Field TEXT NOT NULL,
+	FieldType INTEGER NOT NULL,
+	Changed INTEGER NOT NULL,
+	Vals TEXT NOT NULL)`)
+	if err != nil {
+		return err
+	}
+	_, err = tx.tx.Exec(`CREATE INDEX IF NOT EXISTS _HISTORYIDX ON _HISTORY (TableName, Item, Field, Changed)`)
+	if err != nil {
+		return err
+	}
+	_, err = tx.tx.Exec(`CREATE TABLE IF NOT EXISTS _STATS (TableName TEXT PRIMARY KEY NOT NULL,
+	Reads INTEGER NOT NULL,
+	Writes INTEGER NOT NULL,
+	BytesWritten INTEGER NOT NULL)`)
+	if err != nil {
+		return err
+	}
+	_, err = tx.tx.Exec(`CREATE TABLE IF NOT EXISTS _VERSIONS (TableName TEXT NOT NULL,
+	Item INTEGER NOT NULL,
+	Version INTEGER NOT NULL,
+	PRIMARY KEY (TableName, Item))`)
+	if err != nil {
+		return err
+	}
+	_, err = tx.tx.Exec(`CREATE TABLE IF NOT EXISTS _MIGRATIONS (Version INTEGER PRIMARY KEY NOT NULL,
+	Applied TEXT NOT NULL)`)
+	if err != nil {
+		return err
+	}
+	if db.auditOption {
+		if err = tx.createAuditTable(); err != nil {
+			return err
+		}
+	}
+	if err = db.detectAudit(tx.tx); err != nil {
+		return err
+	}
+	if err = db.detectListValues(tx.tx); err != nil {
+		return err
+	}
+	if db.listsOption && !db.listValues {
+		if err = tx.normalizeLists(); err != nil {
+			db.listValues = false
+			return err
+		}
+	}
+	if db.kvDisabled {
+		return sqltx.Commit()
+	}
 	_, err = tx.tx.Exec(`CREATE TABLE IF NOT EXISTS _KVINT (Id INTEGER PRIMARY KEY NOT NULL, Value INTEGER NOT NULL)`)
 	if err != nil {
 		return err
@@ -348,13 +644,211 @@ Name TEXT NOT NULL)`)
 	if err != nil {
 		return err
 	}
-	return tx.Commit()
+	_, err = tx.tx.Exec(`CREATE TABLE IF NOT EXISTS _KVSINT (Id TEXT PRIMARY KEY NOT NULL, Value INTEGER NOT NULL)`)
+	if err != nil {
+		return err
+	}
+	_, err = tx.tx.Exec(`CREATE TABLE IF NOT EXISTS _KVSSTR (Id TEXT PRIMARY KEY NOT NULL, Value TEXT NOT NULL)`)
+	if err != nil {
+		return err
+	}
+	_, err = tx.tx.Exec(`CREATE TABLE IF NOT EXISTS _KVSBLOB (Id TEXT PRIMARY KEY NOT NULL, Value BLOB NOT NULL)`)
+	if err != nil {
+		return err
+	}
+	_, err = tx.tx.Exec(`CREATE TABLE IF NOT EXISTS _KVSDATE (Id TEXT PRIMARY KEY NOT NULL, Value TEXT NOT NULL)`)
+	if err != nil {
+		return err
+	}
+	_, err = tx.tx.Exec(`CREATE TABLE IF NOT EXISTS _KVBINT (Bucket TEXT NOT NULL, Id INTEGER NOT NULL, Value INTEGER NOT NULL,
+	Expires INTEGER, PRIMARY KEY (Bucket, Id))`)
+	if err != nil {
+		return err
+	}
+	_, err = tx.tx.Exec(`CREATE TABLE IF NOT EXISTS _KVBSTR (Bucket TEXT NOT NULL, Id INTEGER NOT NULL, Value TEXT NOT NULL,
+	Expires INTEGER, PRIMARY KEY (Bucket, Id))`)
+	if err != nil {
+		return err
+	}
+	_, err = tx.tx.Exec(`CREATE TABLE IF NOT EXISTS _KVBBLOB (Bucket TEXT NOT NULL, Id INTEGER NOT NULL, Value BLOB NOT NULL,
+	Expires INTEGER, PRIMARY KEY (Bucket, Id))`)
+	if err != nil {
+		return err
+	}
+	_, err = tx.tx.Exec(`CREATE TABLE IF NOT EXISTS _KVBDATE (Bucket TEXT NOT NULL, Id INTEGER NOT NULL, Value TEXT NOT NULL,
+	Expires INTEGER, PRIMARY KEY (Bucket, Id))`)
+	if err != nil {
+		return err
+	}
+	_, err = tx.tx.Exec(`CREATE TABLE IF NOT EXISTS _KVFLOAT (Id INTEGER PRIMARY KEY NOT NULL, Value REAL NOT NULL)`)
+	if err != nil {
+		return err
+	}
+	if err = tx.addKVExpiry(); err != nil {
+		return err
+	}
+	return sqltx.Commit()
+}
+
+// addColumnIfMissing adds a column to an internal table unless it exists already.
+func (tx *Tx) addColumnIfMissing(table, column, decl string) error {
+	var n int
+	err := tx.tx.QueryRow(`SELECT COUNT(*) FROM pragma_table_info(?) WHERE name=?;`, table, column).Scan(&n)
+	if err != nil {
+		return err
+	}
+	if n > 0 {
+		return nil
+	}
+	_, err = tx.tx.Exec(fmt.Sprintf(`ALTER TABLE %s ADD COLUMN %s %s;`, table, column, decl))
+	return err
 }
 
-// Open creates or opens a minidb.
+// Options control optional features of a database, see OpenWithOptions.
+type Options struct {
+	// DisableKV skips creating the tables of the key-value store. Key-value getters then return
+	// zero values, setters have no effect, and methods that return an error fail with ErrKVDisabled.
+	DisableKV bool
+	// MustExist makes opening fail with an error for which os.IsNotExist is true
+	// if the database file does not exist, instead of creating it.
+	MustExist bool
+	// DeferInit defers creating the housekeeping tables from opening the database to the
+	// first call of Begin. If the database file does not exist, it is not created before
+	// then and the database appears to be empty, so tools that only read do not leave
+	// empty database files behind.
+	DeferInit bool
+	// Audit enables the audit log, which records every change of items, links, the schema, and the
+	// key-value store with the user, time, and old and new values, see MDB.AuditLog. Once enabled,
+	// the audit log stays enabled for the database even if it is opened without this option.
+	Audit bool
+	// LookupCacheTTL enables a cache of the results of TableExists, ItemExists, and Count if it is
+	// positive. Results are kept for the given duration, which should be short, e.g. a second. Changes
+	// made through the MDB remove the affected results when they are committed, but changes by other
+	// processes are only seen after the results have expired. See MDB.LookupCacheStats.
+	LookupCacheTTL time.Duration
+	// NormalizedLists stores the values of all list fields in a single table instead of one table
+	// per list field. An existing database is converted when it is opened, see MDB.NormalizeLists.
+	// Once converted, a database keeps this layout even if it is opened without this option.
+	NormalizedLists bool
+	// JournalMode sets the journal mode of SQLite, such as "WAL", which lets readers continue
+	// while a transaction writes. The empty string keeps the mode of the database file.
+	JournalMode string
+	// ForeignKeys makes SQLite enforce foreign key constraints, which are off by default.
+	ForeignKeys bool
+	// BusyTimeout is the time SQLite waits for a database locked by another process before a
+	// statement fails. Zero keeps the default of the driver.
+	BusyTimeout time.Duration
+	// Synchronous sets how often SQLite waits for data to reach the disk: "OFF", "NORMAL",
+	// "FULL", or "EXTRA". The empty string keeps the default.
+	Synchronous string
+	// CacheSize sets the size of the page cache of each connection, in pages if positive and
+	// in KiB if negative, as PRAGMA cache_size. Zero keeps the default.
+	CacheSize int
+	// BusyRetries is the number of times a statement or commit that fails because another
+	// process has locked the database is retried, for example while mdbserve and another
+	// process write to the same file. Retries add to the waiting of BusyTimeout and also cover
+	// errors SQLite reports without waiting. Zero disables retries.
+	BusyRetries int
+	// BusyBackoff is the time waited before the first retry, which doubles for each further
+	// retry. Zero means 10 milliseconds.
+	BusyBackoff time.Duration
+	// EncryptionKey encrypts the database file with SQLCipher, using the key as passphrase. The
+	// sqlite3 driver must be built with SQLCipher, e.g. by replacing github.com/mattn/go-sqlite3
+	// with a fork that bundles SQLCipher in go.mod, otherwise opening fails with
+	// ErrEncryptionUnsupported. A new database is encrypted when it is created and an existing
+	// one must have been encrypted with the same key. Backups are encrypted with the same key.
+	// The key can be changed with MDB.Rekey. In-memory databases cannot be encrypted.
+	EncryptionKey string
+	// BlobDir stores the values of blob fields that are at least BlobThreshold bytes large in
+	// files in this directory instead of the database, which keeps the database file small and
+	// its backups fast. The database only holds the SHA-256 hash of such a blob, which names
+	// its file, so equal blobs are stored once. Get and Set handle this transparently, but Find
+	// cannot compare the values of such blobs. The files of removed items are removed when
+	// the transaction is committed, see also MDB.CollectBlobs. The directory is created if it
+	// does not exist and must be backed up separately. A database must always be opened with
+	// the same directory. Fields of custom types are not affected.
+	BlobDir string
+	// BlobThreshold is the size in bytes from which blobs are stored in BlobDir. Zero means 16 KiB.
+	BlobThreshold int
+}
+
+// SubsystemDisabledError is returned by methods of a subsystem that has been disabled by the
+// options passed to OpenWithOptions.
+type SubsystemDisabledError struct {
+	Subsystem string
+}
+
+func (e *SubsystemDisabledError) Error() string {
+	return fmt.Sprintf("the %s is disabled", e.Subsystem)
+}
+
+// ErrKVDisabled is returned by key-value methods if the key-value store has been disabled.
+var ErrKVDisabled error = &SubsystemDisabledError{Subsystem: "key-value store"}
+
+// Open creates or opens a minidb. If the file is ":memory:", a new database is created in memory,
+// which is shared by all connections of the MDB and lost when it is closed, see MDB.SaveTo.
+// Unlike a database file, it cannot be read outside of a transaction while a transaction writes.
 func Open(driver string, file string) (*MDB, error) {
+	return OpenWithOptions(driver, file, nil)
+}
+
+// OpenExisting opens a minidb like Open, but fails if the database file does not exist.
+// The housekeeping tables are only created by the first write, see Options.DeferInit.
+func OpenExisting(driver string, file string) (*MDB, error) {
+	return OpenWithOptions(driver, file, &Options{MustExist: true, DeferInit: true})
+}
+
+// databasePath returns the path of the file of a database given in the form accepted by
+// the sqlite3 driver, or the empty string for an in-memory database.
+func databasePath(file string) string {
+	if strings.HasPrefix(file, "file:") {
+		file = strings.TrimPrefix(file, "file:")
+		if i := strings.IndexByte(file, '?'); i >= 0 {
+			if strings.Contains(file[i:], "mode=memory") {
+				return ""
+			}
+			file = file[:i]
+		}
+	}
+	if file == ":memory:" {
+		return ""
+	}
+	return file
+}
+
+// OpenWithOptions creates or opens a minidb like Open, using the given options.
+// Passing nil options is the same as calling Open.
+func OpenWithOptions(driver string, file string, options *Options) (*MDB, error) {
+	if options == nil {
+		options = &Options{}
+	}
+	pragmas, err := options.pragmas()
+	if err != nil {
+		return nil, err
+	}
+	retry, err := options.busyRetry()
+	if err != nil {
+		return nil, err
+	}
+	blobs, err := options.newBlobStore()
+	if err != nil {
+		return nil, err
+	}
 	db := new(MDB)
-	base, err := sql.Open(driver, file)
+	db.blobs = blobs
+	db.options = *options
+	db.kvDisabled = options.DisableKV
+	db.auditOption = options.Audit
+	db.listsOption = options.NormalizedLists
+	// the connection settings are applied to every connection
+	name := file
+	if isMemory(file) {
+		if options.EncryptionKey != "" {
+			return nil, Fail("in-memory databases cannot be encrypted")
+		}
+		name = memoryName()
+	}
+	base, connector, err := openWithPragmas(driver, name, pragmas, retry, options.EncryptionKey)
 	if err != nil {
 		return nil, err
 	}
@@ -362,64 +856,147 @@ func Open(driver string, file string) (*MDB, error) {
 		return nil, errNilDB
 	}
 	db.globalLock = &sync.Mutex{}
+	db.txSlot = make(chan struct{}, 1)
+	db.readTxs = new(int64)
+	db.stats = make(map[string]*TableStats)
+	db.statsLock = &sync.Mutex{}
+	db.hooks = &changeHooks{}
+	db.validators = &validators{byField: make(map[string]map[string][]Validator)}
+	if options.LookupCacheTTL > 0 {
+		db.cache = newLookupCache(options.LookupCacheTTL)
+		db.OnChange(db.cache.invalidate)
+	}
 	db.base = base
+	db.reader = base
+	db.connector = connector
 	db.driver = driver
 	db.location = file
+	if name != file {
+		if err := db.keepMemory(); err != nil {
+			base.Close()
+			return nil, err
+		}
+	}
+	path := databasePath(file)
+	exists := true
+	if path != "" {
+		if _, err := os.Stat(path); err != nil {
+			if options.MustExist {
+				base.Close()
+				return nil, err
+			}
+			exists = false
+		}
+	}
+	// a missing driver support or a wrong key is reported right away instead of by the first query
+	if options.EncryptionKey != "" && (exists || !options.DeferInit) {
+		if err := base.Ping(); err != nil {
+			base.Close()
+			return nil, err
+		}
+	}
+	if options.DeferInit {
+		db.initPending = true
+		if !exists {
+			// reads fail on a closed handle without creating the file
+			closed, err := sql.Open(driver, file)
+			if err != nil {
+				return nil, err
+			}
+			closed.Close()
+			db.reader = closed
+		} else if err := db.detectAudit(base); err != nil {
+			return nil, Fail("cannot open database: %s", err)
+		} else if err := db.detectListValues(base); err != nil {
+			return nil, Fail("cannot open database: %s", err)
+		}
+		return db, nil
+	}
 	if err := db.init(); err != nil {
 		return nil, Fail("cannot initialize database: %s", err)
 	}
 	return db, nil
 }
 
-// Backup a database and return the original opened, not the backed up database.
-// The new MDB pointer needs to be used after this method unless an error
-// has occurred since the old one might have become invalid.
-// This function may result in a corrupt copy if the database is open by another
-// process, so you need to make sure that it isn't.
+// Backup copies the database to the destination file with the online backup API of SQLite,
+// replacing the file if it exists. The database stays open and can be used during the backup,
+// and the copy is consistent even if other connections or processes write to the database
+// meanwhile. Transactions that have not been committed are not included in the copy.
 func (db *MDB) Backup(destination string) error {
-	if db.base == nil {
+	return db.BackupWithProgress(destination, nil)
+}
+
+// BackupWithProgress copies the database to the destination file like Backup. If progress
+// is not nil, it is called after each step of the backup with the number of pages copied so
+// far and the total number of pages of the database, so the progress of backing up a large
+// database can be shown.
+func (db *MDB) BackupWithProgress(destination string, progress func(copied, total int)) error {
+	if db.base == nil || db.globalLock == nil {
 		return Fail("the database must be open to back it up, this one is closed")
 	}
-	// use manual copy for now (should use sqlite3 backup API for sqlite3)
-	src := db.location
-	driver := db.driver
-	db.Close()
-	sourceFileStat, err := os.Stat(src)
-	if err != nil {
-		return err
+	if db.tx != nil {
+		return Fail("cannot back up a view of a transaction")
 	}
-
-	if !sourceFileStat.Mode().IsRegular() {
-		return fmt.Errorf("backup: %s is not a regular file", src)
+	if db.initPending {
+		// the database file of a deferred initialization may not exist yet
+		if err := db.waitForTx(); err != nil {
+			return err
+		}
+		err := db.initIfPending()
+		<-db.txSlot
+		if err != nil {
+			return err
+		}
 	}
-
-	source, err := os.Open(src)
-	if err != nil {
+	if err := db.flushStats(db.base); err != nil {
 		return err
 	}
-	defer source.Close()
-
-	dest, err := os.Create(destination)
+	// the copy is written next to the destination and then renamed, so an existing file is
+	// not lost if the backup fails
+	tmp, err := os.CreateTemp(filepath.Dir(destination), filepath.Base(destination)+".tmp-*")
 	if err != nil {
-		return err
+		return Fail("backup failed: %s", err)
 	}
-	defer dest.Close()
-	_, err = io.Copy(dest, source)
-	if err != nil {
-		return err
+	tmpName := tmp.Name()
+	tmp.Close()
+	dest, err := db.openWithKey(tmpName)
+	if err == nil {
+		err = copyDatabase(dest, db.base, progress)
+		dest.Close()
+	}
+	if err == nil {
+		err = os.Rename(tmpName, destination)
 	}
-	newdb, err := Open(driver, src)
 	if err != nil {
-		return err
+		os.Remove(tmpName)
+		return Fail("backup failed: %s", err)
 	}
-	db = newdb
-	return err
+	return nil
 }
 
 // Close closes the database, making sure that all remaining transactions are finished.
+// Databases returned by MultiDB.UserDB are closed by the MultiDB, so Close does nothing for them.
 func (db *MDB) Close() error {
+	if db.keepOpen {
+		return nil
+	}
+	return db.close()
+}
+
+// close closes the database, even if it is kept open by a MultiDB.
+func (db *MDB) close() error {
 	if db.base != nil {
-		_, _ = db.base.Exec(`PRAGMA optimize;`)
+		// a database whose initialization has been deferred has not been written to
+		if !db.initPending {
+			if err := db.flushStats(db.base); err != nil {
+				return err
+			}
+			_, _ = db.base.Exec(`PRAGMA optimize;`)
+		}
+		if db.memory != nil {
+			db.memory.Close()
+			db.memory = nil
+		}
 		err := db.base.Close()
 		if err != nil {
 			return Fail("ERROR Failed to close database - %s.\n", err)
@@ -436,135 +1013,329 @@ func (db *MDB) Base() *sql.DB {
 	return db.base
 }
 
-// Begin starts a transaction.
+// Begin starts a new transaction. Every transaction begun by the MDB uses its own connection
+// and is isolated from the other transactions, which do not see its changes before it is committed.
+// Since SQLite allows only one transaction to write at a time, the transactions of an MDB are
+// serialized: Begin waits until the previous transaction has been committed or rolled back,
+// while reads outside of transactions continue. If the other transaction is not finished within
+// five seconds, Begin fails. Methods of the MDB that write, such as AddTable, begin transactions
+// themselves, so they must not be called by a goroutine while it has an open transaction. Use
+// the methods of the transaction instead, or Tx.Begin for a nested transaction.
+// If the MDB is a view returned by Tx.View, the new transaction is nested in the transaction
+// of the view.
 func (db *MDB) Begin() (*Tx, error) {
+	if db.tx != nil {
+		return db.tx.Begin()
+	}
 	if db.globalLock == nil {
 		return nil, errors.New("attempt to open a transaction on a closed DB")
 	}
-	db.globalLock.Lock()
-	defer db.globalLock.Unlock()
-	if db.tx == nil {
-		//fmt.Println("*** new real transaction")
-		sqltx, err := db.base.Begin()
-		if err != nil {
-			return nil, err
-		}
-		tx := &Tx{
-			tx:  sqltx,
-			mdb: db,
-		}
-		db.tx = tx
-		return tx, nil
+	if err := db.waitForTx(); err != nil {
+		return nil, err
 	}
-	savePointCounter++
-	tx := &Tx{
-		tx:        db.tx.tx,
-		mdb:       db,
-		prev:      db.tx,
-		savePoint: savePointCounter,
+	if err := db.initIfPending(); err != nil {
+		<-db.txSlot
+		return nil, err
 	}
-	db.tx = tx
-	_, err := db.tx.tx.Exec(fmt.Sprintf("SAVEPOINT SP%d;", tx.savePoint))
-	//fmt.Printf("*** New savepoint SP%d\n", savePoint)
+	sqltx, err := db.base.Begin()
 	if err != nil {
-		return nil, fmt.Errorf("minidb begin transaction failed, %s", err)
+		<-db.txSlot
+		return nil, err
 	}
-	return tx, nil
+	return &Tx{
+		tx:        sqltx,
+		mdb:       db,
+		auditUser: db.auditUser,
+	}, nil
 }
 
-// Commit the changes to the database.
-func (tx *Tx) Commit() error {
-	if tx.mdb.globalLock == nil {
-		return errors.New("attempt to commit a transaction of a closed DB")
-	}
-	tx.mdb.globalLock.Lock()
-	defer tx.mdb.globalLock.Unlock()
-	tx.mdb.tx = tx.prev
-	if tx.prev == nil {
-		//fmt.Println("*** real commit")
-		return tx.tx.Commit()
-	}
-	if tx.released {
-		return errors.New("nested transaction has already been rolled back or commmitted")
+// initIfPending creates the housekeeping tables if their creation has been deferred, see
+// Options.DeferInit. The caller must have reserved the database with waitForTx.
+func (db *MDB) initIfPending() error {
+	db.globalLock.Lock()
+	defer db.globalLock.Unlock()
+	if !db.initPending {
+		return nil
 	}
-	_, err := tx.tx.Exec(fmt.Sprintf("RELEASE SP%d;", tx.savePoint))
-	if err != nil {
-		return fmt.Errorf("minidb commit transaction failed, %s", err)
+	if err := db.init(); err != nil {
+		return Fail("cannot initialize database: %s", err)
 	}
-	//fmt.Printf("*** release savepoint SP%d\n", savePoint)
-	tx.released = true
+	db.initPending = false
+	db.reader = db.base
 	return nil
 }
 
-// Rollback the changes in the database.
-func (tx *Tx) Rollback() error {
-	if tx.mdb.globalLock == nil {
-		return errors.New("attempt to rollback a transaction of a closed DB")
+// BeginRead starts a read-only transaction. The read methods of its View, such as Get, Find,
+// and ListItems, all see the database as it was when the transaction began, so several queries
+// give consistent results even if other transactions commit changes in the meantime. Unlike
+// Begin, BeginRead does not wait for other transactions and read-only transactions do not block
+// each other. In the WAL journal mode of SQLite they do not block writers either, whereas in
+// the default journal mode a writer cannot commit while a read-only transaction is open.
+// Writes in a read-only transaction fail. It is finished by Commit or Rollback, which are the same.
+func (db *MDB) BeginRead() (*Tx, error) {
+	if db.tx != nil {
+		return nil, Fail("cannot begin a read-only transaction in a view of a transaction")
 	}
-	tx.mdb.globalLock.Lock()
-	defer tx.mdb.globalLock.Unlock()
-	if tx.released {
-		//fmt.Println("*** rollback after savepoint release (do nothing)")
-		return nil
+	if db.globalLock == nil {
+		return nil, errors.New("attempt to open a transaction on a closed DB")
 	}
-	tx.mdb.tx = tx.prev
-	tx.released = true
-	if tx.prev == nil {
-		//fmt.Println("*** real rollback")
-		return tx.tx.Rollback()
+	// like other reads, a read-only transaction does not create a database whose
+	// initialization is deferred, see Options.DeferInit
+	db.globalLock.Lock()
+	base, ok := db.reader.(*sql.DB)
+	db.globalLock.Unlock()
+	if !ok {
+		base = db.base
 	}
-	//fmt.Printf("*** rollback to savepoint SP%d\n", savePoint)
-	_, err := tx.tx.Exec(fmt.Sprintf("ROLLBACK TO SP%d", tx.savePoint))
+	sqltx, err := base.Begin()
 	if err != nil {
-		return fmt.Errorf("minidb rollback transaction failed, %s", err)
+		return nil, err
 	}
+	// the snapshot of a transaction is taken by its first read
+	_, err = sqltx.Exec(`PRAGMA query_only=ON;`)
+	if err == nil {
+		var n int64
+		err = sqltx.QueryRow(`SELECT COUNT(*) FROM sqlite_master;`).Scan(&n)
+	}
+	if err != nil {
+		sqltx.Exec(`PRAGMA query_only=OFF;`)
+		sqltx.Rollback()
+		return nil, Fail("cannot begin read-only transaction: %s", err)
+	}
+	atomic.AddInt64(db.readTxs, 1)
+	return &Tx{
+		tx:        sqltx,
+		mdb:       db,
+		auditUser: db.auditUser,
+		readOnly:  true,
+	}, nil
+}
+
+// endRead finishes a read-only transaction. The connection is made writable again before it
+// is returned to the pool of connections.
+func (tx *Tx) endRead() error {
+	atomic.AddInt64(tx.mdb.readTxs, -1)
+	_, err := tx.tx.Exec(`PRAGMA query_only=OFF;`)
+	if rollbackErr := tx.tx.Rollback(); err == nil {
+		err = rollbackErr
+	}
+	return err
+}
+
+// inTransaction returns true if a transaction of the MDB is open.
+func (db *MDB) inTransaction() bool {
+	return len(db.txSlot) > 0 || (db.readTxs != nil && atomic.LoadInt64(db.readTxs) > 0)
+}
+
+// txWaitTimeout is the time Begin waits for the previous transaction to finish. It is the
+// same as the time the sqlite3 driver waits for a locked database by default.
+const txWaitTimeout = 5 * time.Second
+
+// waitForTx waits until no other transaction of the MDB is open and reserves the database
+// for a new transaction.
+func (db *MDB) waitForTx() error {
+	timer := time.NewTimer(txWaitTimeout)
+	defer timer.Stop()
+	select {
+	case db.txSlot <- struct{}{}:
+		return nil
+	case <-timer.C:
+		return Fail("timed out waiting for another transaction to finish")
+	}
+}
+
+// Begin starts a transaction nested in this one. Its changes can be rolled back without
+// rolling back the changes of the enclosing transaction, and they become permanent when the
+// outermost transaction is committed.
+func (tx *Tx) Begin() (*Tx, error) {
+	if tx.mdb.globalLock == nil {
+		return nil, errors.New("attempt to open a transaction on a closed DB")
+	}
+	tx.mdb.globalLock.Lock()
+	savePointCounter++
+	sub := &Tx{
+		tx:        tx.tx,
+		mdb:       tx.mdb,
+		prev:      tx,
+		savePoint: savePointCounter,
+		auditUser: tx.auditUser,
+		readOnly:  tx.readOnly,
+	}
+	tx.mdb.globalLock.Unlock()
+	_, err := sub.tx.Exec(fmt.Sprintf("SAVEPOINT SP%d;", sub.savePoint))
+	if err != nil {
+		return nil, fmt.Errorf("minidb begin transaction failed, %s", err)
+	}
+	return sub, nil
+}
+
+// Commit the changes to the database. When the outermost transaction is committed,
+// the functions registered with OnChange are called for the changes made in it.
+func (tx *Tx) Commit() error {
+	if err := tx.commit(); err != nil {
+		return err
+	}
+	if tx.prev == nil {
+		events := tx.events
+		tx.events = nil
+		tx.mdb.hooks.deliver(events)
+	}
+	return nil
+}
+
+func (tx *Tx) commit() error {
+	if tx.mdb.globalLock == nil {
+		return errors.New("attempt to commit a transaction of a closed DB")
+	}
+	if tx.released {
+		return errors.New("transaction has already been rolled back or commmitted")
+	}
+	tx.released = true
+	if tx.prev == nil && tx.readOnly {
+		return tx.endRead()
+	}
+	if tx.prev == nil {
+		//fmt.Println("*** real commit")
+		defer func() { <-tx.mdb.txSlot }()
+		written, err := tx.mdb.writeStats(tx.tx)
+		if err != nil {
+			tx.tx.Rollback()
+			return err
+		}
+		unused, err := tx.unusedBlobs()
+		if err != nil {
+			tx.tx.Rollback()
+			return err
+		}
+		if err := tx.tx.Commit(); err != nil {
+			return err
+		}
+		tx.mdb.clearStats(written)
+		// a file that cannot be removed is only wasted space, see CollectBlobs
+		for _, path := range unused {
+			os.Remove(path)
+		}
+		return nil
+	}
+	_, err := tx.tx.Exec(fmt.Sprintf("RELEASE SP%d;", tx.savePoint))
+	if err != nil {
+		return fmt.Errorf("minidb commit transaction failed, %s", err)
+	}
+	//fmt.Printf("*** release savepoint SP%d\n", savePoint)
+	tx.prev.events = append(tx.prev.events, tx.events...)
+	tx.events = nil
+	tx.prev.orphans = append(tx.prev.orphans, tx.orphans...)
+	tx.orphans = nil
 	return nil
 }
 
+// Rollback the changes in the database.
+func (tx *Tx) Rollback() error {
+	if tx.mdb.globalLock == nil {
+		return errors.New("attempt to rollback a transaction of a closed DB")
+	}
+	if tx.released {
+		//fmt.Println("*** rollback after savepoint release (do nothing)")
+		return nil
+	}
+	tx.released = true
+	tx.events = nil
+	tx.orphans = nil
+	if tx.prev == nil && tx.readOnly {
+		return tx.endRead()
+	}
+	if tx.prev == nil {
+		//fmt.Println("*** real rollback")
+		defer func() { <-tx.mdb.txSlot }()
+		return tx.tx.Rollback()
+	}
+	//fmt.Printf("*** rollback to savepoint SP%d\n", savePoint)
+	_, err := tx.tx.Exec(fmt.Sprintf("ROLLBACK TO SP%d", tx.savePoint))
+	if err != nil {
+		return fmt.Errorf("minidb rollback transaction failed, %s", err)
+	}
+	return nil
+}
+
+// View returns a view of the database whose read methods, such as Get, Find, and the key-value
+// getters, see the uncommitted changes of the transaction. The view must only be used for reading
+// and only as long as the transaction is open. Begin on the view starts a transaction nested in
+// this one.
+func (tx *Tx) View() *MDB {
+	view := *tx.mdb
+	view.reader = tx.tx
+	view.tx = tx
+	return &view
+}
+
 // TableExists returns true if the table exists, false otherwise.
 func (db *MDB) TableExists(table string) bool {
-	var result int
-	err := db.base.QueryRow(`SELECT EXISTS (SELECT 1 FROM _TABLES WHERE Name=? LIMIT 1)`, table).Scan(&result)
+	key := cacheKey{kind: cacheTableExists, table: table}
+	cached, generation, ok := db.cached(key)
+	if ok {
+		return cached > 0
+	}
+	var result int64
+	err := db.reader.QueryRow(`SELECT EXISTS (SELECT 1 FROM _TABLES WHERE Name=? LIMIT 1)`, table).Scan(&result)
 	switch {
 	case err == sql.ErrNoRows:
 		return false
 	case err != nil:
 		return false
 	default:
+		db.storeCached(key, generation, result)
 		return result > 0
 	}
 }
 
 // FieldIsNull returns true if the field is null for the item in the table, false otherwise.
+// A single field is null if it has never been set or has been set to the NULL value, a list
+// field is null if it has no elements. False is returned if the item or field do not exist.
 func (db *MDB) FieldIsNull(table string, item Item, field string) bool {
+	if !validTable.MatchString(table) || !db.FieldExists(table, field) || !db.ItemExists(table, item) {
+		return false
+	}
+	if db.IsListField(table, field) {
+		return db.IsEmptyListField(table, item, field)
+	}
 	var result int
-	err := db.base.QueryRow(fmt.Sprintf(`SELECT EXISTS (SELECT 1 FROM "%s" WHERE ? IS NULL and Id=?);`, table), field, item).Scan(&result)
+	err := db.reader.QueryRow(fmt.Sprintf(`SELECT "%s" IS NULL FROM "%s" WHERE Id=?;`, field, table), item).Scan(&result)
 	if err != nil {
 		return false
 	}
-	return result == 0
+	return result != 0
 }
 
-// FieldIsEmpty returns true if the field is null or empty, false otherwise.
+// FieldIsEmpty returns true if the field is null or empty, false otherwise. A string or blob
+// field is empty if it contains the empty string or no bytes, a list field if it has no elements.
 func (db *MDB) FieldIsEmpty(table string, item Item, field string) bool {
 	if db.FieldIsNull(table, item, field) {
 		return true
 	}
+	if db.IsListField(table, field) || !db.FieldExists(table, field) {
+		return false
+	}
 	var result int
-	err := db.base.QueryRow(fmt.Sprintf(`SELECT EXISTS (SELECT 1 FROM "%s" WHERE ?='' and Id=?)`, table), field, item).Scan(&result)
+	err := db.reader.QueryRow(fmt.Sprintf(`SELECT length("%s")=0 FROM "%s" WHERE Id=?;`, field, table), item).Scan(&result)
 	if err != nil {
 		return false
 	}
-	return result == 0
+	return result != 0
 }
 
 // ItemExists returns true if the item exists in the table, false otherwise.
 func (db *MDB) ItemExists(table string, item Item) bool {
-	var result int
-	err := db.base.QueryRow(fmt.Sprintf(`SELECT EXISTS (SELECT 1 FROM "%s" WHERE Id=? LIMIT 1)`, table), item).Scan(&result)
+	key := cacheKey{kind: cacheItemExists, table: table, item: item}
+	cached, generation, ok := db.cached(key)
+	if ok {
+		return cached > 0
+	}
+	var result int64
+	err := db.reader.QueryRow(fmt.Sprintf(`SELECT EXISTS (SELECT 1 FROM "%s" WHERE Id=? LIMIT 1)`, table), item).Scan(&result)
 	if err != nil {
 		return false
 	}
+	db.storeCached(key, generation, result)
 	return result > 0
 }
 
@@ -581,7 +1352,8 @@ func (db *MDB) IsEmptyListField(table string, item Item, field string) bool {
 	if !db.IsListField(table, field) {
 		return false
 	}
-	err := db.base.QueryRow(fmt.Sprintf(`SELECT EXISTS (SELECT 1 FROM "%s" WHERE Owner=? AND %s IS NOT NULL LIMIT 1)`, table, field), item).Scan(&result)
+	err := db.reader.QueryRow(fmt.Sprintf(`SELECT EXISTS (SELECT 1 FROM "%s" WHERE Owner=? AND "%s" IS NOT NULL LIMIT 1)`,
+		listFieldToTableName(table, field), field), item).Scan(&result)
 	if err != nil {
 		return true
 	}
@@ -590,7 +1362,7 @@ func (db *MDB) IsEmptyListField(table string, item Item, field string) bool {
 
 func (db *MDB) getTableId(table string) (int64, error) {
 	var result int64
-	err := db.base.QueryRow(`SELECT Id FROM _TABLES WHERE Name=?`, table).Scan(&result)
+	err := db.reader.QueryRow(`SELECT Id FROM _TABLES WHERE Name=?`, table).Scan(&result)
 	if err != nil {
 		return 0, err
 	}
@@ -604,7 +1376,7 @@ func (db *MDB) FieldExists(table string, field string) bool {
 	if err != nil {
 		return false
 	}
-	err = db.base.QueryRow(`SELECT EXISTS (SELECT 1 FROM _COLS WHERE Owner=? AND Name=? LIMIT 1)`, id, field).Scan(&result)
+	err = db.reader.QueryRow(`SELECT EXISTS (SELECT 1 FROM _COLS WHERE Owner=? AND Name=? LIMIT 1)`, id, field).Scan(&result)
 	if err != nil {
 		fmt.Println(err)
 		return false
@@ -616,7 +1388,7 @@ func (db *MDB) FieldExists(table string, field string) bool {
 func (db *MDB) MustGetFieldType(table string, field string) FieldType {
 	id, _ := db.getTableId(table)
 	var result int64
-	db.base.QueryRow(`SELECT FieldType FROM _COLS WHERE Owner=? AND Name=? LIMIT 1;`, id, field).Scan(&result)
+	db.reader.QueryRow(`SELECT FieldType FROM _COLS WHERE Owner=? AND Name=? LIMIT 1;`, id, field).Scan(&result)
 	return FieldType(result)
 }
 
@@ -629,10 +1401,10 @@ func (db *MDB) ParseFieldValues(table string, field string, data []string) ([]Va
 		return nil, Fail("invalid table name '%s'", table)
 	}
 	if !db.TableExists(table) {
-		return nil, Fail("table '%s' does not exist", table)
+		return nil, tableNotFound(table)
 	}
 	if !db.FieldExists(table, field) {
-		return nil, Fail("field '%s' does not exist in table '%s'", field, table)
+		return nil, fieldNotFound(table, field)
 	}
 	if len(data) == 0 {
 		return nil, Fail("no input values given")
@@ -643,37 +1415,45 @@ func (db *MDB) ParseFieldValues(table string, field string, data []string) ([]Va
 	t := ToBaseType(db.MustGetFieldType(table, field))
 	result := make([]Value, 0, len(data))
 	for i := range data {
-		switch t {
-		case DBInt:
-			j, err := strconv.ParseInt(data[i], 10, 64)
-			if err != nil {
-				return nil, Fail("type error: expected int, given '%s'", data[i])
-			}
-			result = append(result, NewInt(j))
-		case DBBlob:
-			b, err := base64.StdEncoding.DecodeString(data[i])
-			if err != nil {
-				return nil, Fail("type error: expected binary data in Base64 format but the given data seems invalid")
-			}
-			result = append(result, NewBytes(b))
-		case DBString:
-			result = append(result, NewString(data[i]))
-		case DBDate:
-			var t time.Time
-			var err error
-			if t, err = ParseTime(data[i]); err != nil {
-				return nil, Fail("type error: expected datetime in RFC3339 format - %s", err)
-			}
-			result = append(result, NewDate(t))
-		default:
-			return nil,
-				Fail("internal error: %s %s expects type %d, which is unknown to this version of minidb",
-					table, field, t)
+		v, err := parseValue(t, data[i])
+		if err != nil {
+			return nil, err
 		}
+		result = append(result, v)
 	}
 	return result, nil
 }
 
+// parseValue parses a single value of the given base type from a string. Blobs must be
+// Base64 encoded and dates must be in RFC3339 format. This is the inverse of Value.String().
+func parseValue(t FieldType, s string) (Value, error) {
+	switch t {
+	case DBInt:
+		j, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return Value{}, Fail("type error: expected int, given '%s'", s)
+		}
+		return NewInt(j), nil
+	case DBBlob:
+		b, err := base64.StdEncoding.DecodeString(s)
+		if err != nil {
+			return Value{}, Fail("type error: expected binary data in Base64 format but the given data seems invalid")
+		}
+		return NewBytes(b), nil
+	case DBString:
+		return NewString(s), nil
+	case DBDate:
+		d, err := ParseTime(s)
+		if err != nil {
+			return Value{}, Fail("type error: expected datetime in RFC3339 format - %s", err)
+		}
+		return NewDate(d), nil
+	default:
+		return Value{},
+			Fail("internal error: type %d is unknown to this version of minidb", t)
+	}
+}
+
 // ParseTime parses a time string in RFC3339 format and returns the time or an error if
 // the format is wrong.
 func ParseTime(s string) (time.Time, error) {
@@ -693,40 +1473,42 @@ func (db *MDB) AddTable(table string, fields []Field) error {
 		return err
 	}
 	defer tx.Rollback()
+	if err := tx.AddTable(table, fields); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// AddTable creates a new table in the transaction like MDB.AddTable.
+func (tx *Tx) AddTable(table string, fields []Field) error {
+	sub, err := tx.Begin()
+	if err != nil {
+		return err
+	}
+	defer sub.Rollback()
 	if !validTable.MatchString(table) {
 		return Fail("invalid table name '%s'", table)
 	}
+	for _, field := range fields {
+		if err := validateFieldConstraints(field); err != nil {
+			return err
+		}
+	}
 	// normal fields are just columns
 	toExec := fmt.Sprintf(`CREATE TABLE IF NOT EXISTS "%s" (Id INTEGER PRIMARY KEY`, table)
 	for _, field := range fields {
 		if !isListFieldType(field.Sort) {
-			toExec += fmt.Sprintf(",\n\"%s\" %s", field.Name, getTypeString(field.Sort))
+			toExec += fmt.Sprintf(",\n\"%s\" %s%s", field.Name, getTypeString(field.Sort), columnConstraints(field))
 		}
 	}
 	toExec += ");"
-	_, err = tx.tx.Exec(toExec)
+	_, err = sub.tx.Exec(toExec)
 	if err != nil {
 		return Fail("cannot create maintenance table: %s", err)
 	}
-	// list fields are composite tables with name _Basetable_Fieldname
-	for _, field := range fields {
-		if isListFieldType(field.Sort) {
-			toExec = fmt.Sprintf(`CREATE TABLE IF NOT EXISTS "%s" (Id INTEGER PRIMARY KEY,
-Owner INTEGER NOT NULL,
-%s %s, 
-FOREIGN KEY(Owner) REFERENCES %s(Id))`, listFieldToTableName(table, field.Name),
-				field.Name,
-				getTypeString(field.Sort),
-				table)
-			_, err = tx.tx.Exec(toExec)
-			if err != nil {
-				return Fail("cannot create list field %s in table %s: %s", field.Name, table, err)
-			}
-		}
-	}
 	// update the internal housekeeping tables
 	toExec = "INSERT OR IGNORE INTO _TABLES (Name) VALUES (?)"
-	result, err := tx.tx.Exec(toExec, table)
+	result, err := sub.tx.Exec(toExec, table)
 	if err != nil {
 		return Fail("Failed to update maintenance table: %s", err)
 	}
@@ -735,135 +1517,835 @@ FOREIGN KEY(Owner) REFERENCES %s(Id))`, listFieldToTableName(table, field.Name),
 		return Fail("failed to update maintenance table: %s", err)
 	}
 	for _, field := range fields {
-		_, err := tx.tx.Exec(`INSERT INTO _COLS (Name,FieldType,Owner) VALUES (?,?,?)`, field.Name, field.Sort, tableID)
-		if err != nil {
-			return Fail("cannot insert maintenance field %s for table %s: %s",
-				field.Name, table, err)
+		if err := sub.insertFieldDesc(table, tableID, field); err != nil {
+			return err
 		}
+	}
+	// list fields are composite tables with name _Basetable_Fieldname
+	for _, field := range fields {
 		if isListFieldType(field.Sort) {
-			_, err = tx.tx.Exec(`INSERT INTO _TABLES (Name) VALUES (?)`, listFieldToTableName(table, field.Name))
-		}
-		if err != nil {
-			return Fail("cannot insert maintenance list table %s for table %s: %s",
-				listFieldToTableName(table, field.Name), table, err)
+			if err := sub.createListTable(table, field); err != nil {
+				return err
+			}
 		}
 	}
-	return tx.Commit()
-}
-
-// Index creates an index for field in table unless the index exists already.
-// An index increases the search speed of certain string queries on the field, such as "Person name=joh%".
-func (tx *Tx) Index(table, field string) error {
-	if !validTable.MatchString(table) {
-		return Fail("invalid table name '%s'", table)
+	descs := make([]Value, len(fields))
+	for i, field := range fields {
+		descs[i] = NewString(field.Name + " " + GetUserTypeString(field.Sort))
 	}
-	if !tx.mdb.FieldExists(table, field) {
-		return Fail("field '%s' does not exist in table '%s'", field, table)
+	if err := sub.audit(AuditEntry{Op: AuditAddTable, Table: table, New: descs}); err != nil {
+		return err
 	}
-	var realtable string
-	if tx.mdb.IsListField(table, field) {
-		realtable = listFieldToTableName(table, field)
-	} else {
-		realtable = table
+	sub.notify(ChangeEvent{Kind: ChangeSchema, Table: table})
+	return sub.Commit()
+}
+
+func (tx *Tx) createListTable(table string, field Field) error {
+	if tx.mdb.listValues {
+		return tx.createListView(table, field.Name)
 	}
-	indexName := field + "_" + realtable + "_IDX"
-	_, err := tx.tx.Exec(fmt.Sprintf(`CREATE INDEX IF NOT EXISTS %s ON %s(%s);`, indexName, realtable, field))
+	_, err := tx.tx.Exec(fmt.Sprintf(`CREATE TABLE IF NOT EXISTS "%s" (Id INTEGER PRIMARY KEY,
+Owner INTEGER NOT NULL,
+%s %s, 
+FOREIGN KEY(Owner) REFERENCES %s(Id))`, listFieldToTableName(table, field.Name),
+		field.Name,
+		getTypeString(field.Sort),
+		table))
 	if err != nil {
-		return Fail("failed to create index for field '%s' in table '%s': %s", field, table, err)
+		return Fail("cannot create list field %s in table %s: %s", field.Name, table, err)
 	}
 	return nil
 }
 
-// NewItem creates a new item in the table and returns its numerical ID.
-func (db *MDB) NewItem(table string) (Item, error) {
-	if !validTable.MatchString(table) {
-		return 0, Fail("invalid table name '%s'", table)
-	}
-	if !db.TableExists(table) {
-		return 0, Fail("table '%s' does not exist", table)
+// insertFieldDesc updates the internal housekeeping tables for a new field.
+func (tx *Tx) insertFieldDesc(table string, tableID int64, field Field) error {
+	var defaultValue sql.NullString
+	if field.Default != nil {
+		defaultValue = sql.NullString{String: field.Default.String(), Valid: true}
 	}
-
-	toExec := fmt.Sprintf("INSERT INTO %s DEFAULT VALUES;", table)
-	result, err := db.base.Exec(toExec)
+	_, err := tx.tx.Exec(`INSERT INTO _COLS (Name,FieldType,Owner,Required,DefaultValue,Access) VALUES (?,?,?,?,?,?)`,
+		field.Name, field.Sort, tableID, field.Required, defaultValue, field.Access)
 	if err != nil {
-		return 0, err
+		return Fail("cannot insert maintenance field %s for table %s: %s",
+			field.Name, table, err)
+	}
+	if isListFieldType(field.Sort) {
+		_, err = tx.tx.Exec(`INSERT INTO _TABLES (Name) VALUES (?)`, listFieldToTableName(table, field.Name))
 	}
-	id, err := result.LastInsertId()
 	if err != nil {
-		return 0, err
+		return Fail("cannot insert maintenance list table %s for table %s: %s",
+			listFieldToTableName(table, field.Name), table, err)
 	}
-	return Item(id), nil
+	return nil
 }
 
-// UseItem creates a new item with the given ID or returns the item with the given ID
-// if it already exists. This may be used when fixed IDs are needed, but should be avoided
-// when these are not strictly necessary.
-func (db *MDB) UseItem(table string, id uint64) (Item, error) {
+// Index creates an index for field in table unless the index exists already.
+// An index increases the search speed of certain string queries on the field, such as "Person name=joh%".
+func (tx *Tx) Index(table, field string) error {
+	view := tx.View()
 	if !validTable.MatchString(table) {
-		return 0, Fail("invalid table name '%s'", table)
+		return Fail("invalid table name '%s'", table)
 	}
-	if !db.TableExists(table) {
-		return 0, Fail("table '%s' does not exist", table)
+	if !view.FieldExists(table, field) {
+		return fieldNotFound(table, field)
 	}
-	if db.ItemExists(table, Item(id)) {
-		return Item(id), nil
+	return tx.createIndex(table, field, view.IsListField(table, field))
+}
+
+func (tx *Tx) createIndex(table, field string, isList bool) error {
+	if isList && tx.mdb.listValues {
+		return tx.createListValuesIndex(table, field)
 	}
-	toExec := fmt.Sprintf("INSERT INTO %s(Id) VALUES (?);", table)
-	_, err := db.base.Exec(toExec, id)
+	realtable := table
+	if isList {
+		realtable = listFieldToTableName(table, field)
+	}
+	_, err := tx.tx.Exec(fmt.Sprintf(`CREATE INDEX IF NOT EXISTS %s ON %s(%s);`,
+		indexName(realtable, field), realtable, field))
 	if err != nil {
-		return 0, err
+		return Fail("failed to create index for field '%s' in table '%s': %s", field, table, err)
 	}
-	return Item(id), nil
+	return nil
 }
 
-// RemoveItem remove an item from the table.
-func (tx *Tx) RemoveItem(table string, item Item) error {
+// hasIndex returns true if the field has an index created by Index.
+func (db *MDB) hasIndex(table string, field Field) bool {
+	realtable := table
+	if isListFieldType(field.Sort) {
+		realtable = listFieldToTableName(table, field.Name)
+	}
+	var n int
+	err := db.reader.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE type='index' AND name=?;`,
+		indexName(realtable, field.Name)).Scan(&n)
+	return err == nil && n > 0
+}
+
+// Reindex drops and recreates all indices of a table, including the indices of its list fields.
+// This may be used to recover from a corrupted index or to speed up bulk loads by creating
+// the indices after the data has been inserted.
+func (tx *Tx) Reindex(table string) error {
 	if !validTable.MatchString(table) {
-		return Fail(`invalid table name "%s"`, table)
+		return Fail("invalid table name '%s'", table)
+	}
+	if !tx.View().TableExists(table) {
+		return tableNotFound(table)
+	}
+	fields, err := tx.View().GetFields(table)
+	if err != nil {
+		return err
 	}
-	if !tx.mdb.TableExists(table) {
-		return Fail("table '%s' does not exist", table)
+	tables := []string{table}
+	// the indices of list fields in the normalized layout are indices of _LISTVALUES
+	listIndices := []string{""}
+	for _, field := range fields {
+		if isListFieldType(field.Sort) {
+			tables = append(tables, listFieldToTableName(table, field.Name))
+			listIndices = append(listIndices, indexName(listFieldToTableName(table, field.Name), field.Name))
+		}
 	}
-	if tx.mdb.ItemExists(table, item) {
-		_, err := tx.tx.Exec(fmt.Sprintf(`DELETE FROM %s WHERE Id=?;`, table), item)
+	for i, realtable := range tables {
+		// indices created automatically by Sqlite have no SQL definition and cannot be dropped
+		rows, err := tx.tx.Query(`SELECT name,sql FROM sqlite_master WHERE type='index' AND (tbl_name=? OR name=?)
+AND sql IS NOT NULL;`, realtable, listIndices[i])
 		if err != nil {
-			return Fail(`error while deleting %s %d`, table, item)
+			return Fail("cannot list indices of table '%s': %s", realtable, err)
+		}
+		names := make([]string, 0)
+		defs := make([]string, 0)
+		for rows.Next() {
+			var name, def string
+			if err := rows.Scan(&name, &def); err != nil {
+				rows.Close()
+				return Fail("cannot list indices of table '%s': %s", realtable, err)
+			}
+			names = append(names, name)
+			defs = append(defs, def)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return Fail("cannot list indices of table '%s': %s", realtable, err)
+		}
+		for i := range names {
+			if _, err := tx.tx.Exec(fmt.Sprintf(`DROP INDEX "%s";`, names[i])); err != nil {
+				return Fail("failed to drop index '%s': %s", names[i], err)
+			}
+			if _, err := tx.tx.Exec(defs[i]); err != nil {
+				return Fail("failed to recreate index '%s': %s", names[i], err)
+			}
 		}
 	}
 	return nil
 }
 
-// Count returns the number of items in the table.
-func (db *MDB) Count(table string) (int64, error) {
-	if !validTable.MatchString(table) {
-		return 0, Fail("invalid table name '%s'", table)
+// RenameTable renames a table, including the tables used internally for its list fields.
+// The new name is validated in the same way as in AddTable and must not be in use already.
+func (tx *Tx) RenameTable(oldName, newName string) error {
+	view := tx.View()
+	if !validTable.MatchString(oldName) {
+		return Fail("invalid table name '%s'", oldName)
 	}
-	if !db.TableExists(table) {
-		return 0, Fail("table '%s' does not exist", table)
+	if !validTable.MatchString(newName) {
+		return Fail("invalid table name '%s'", newName)
 	}
-	var result int64
-	err := db.base.QueryRow(fmt.Sprintf(`SELECT COUNT(*) FROM %s;`, table)).Scan(&result)
-	if err != nil {
-		return 0, err
+	if !view.TableExists(oldName) {
+		return tableNotFound(oldName)
 	}
-	return result, nil
-}
-
-// ListItems returns a list of items in the table.
-func (db *MDB) ListItems(table string, limit int64) ([]Item, error) {
-	empty := make([]Item, 0)
-	if !validTable.MatchString(table) {
-		return empty, Fail("invalid table name '%s'", table)
+	if view.TableExists(newName) {
+		return Fail("table '%s' already exists", newName)
 	}
-	if !db.TableExists(table) {
-		return empty, Fail("table '%s' does not exist", table)
+	fields, err := view.GetFields(oldName)
+	if err != nil {
+		return err
 	}
-	rows, err := db.base.Query(fmt.Sprintf(`SELECT (Id) FROM %s;`, table))
+	_, err = tx.tx.Exec(fmt.Sprintf(`ALTER TABLE "%s" RENAME TO "%s";`, oldName, newName))
 	if err != nil {
-		return empty, err
+		return Fail("cannot rename table '%s' to '%s': %s", oldName, newName, err)
 	}
-	defer rows.Close()
-	results := make([]Item, 0)
+	type listView struct {
+		field   string
+		indexed bool
+	}
+	views := make([]listView, 0)
+	for _, field := range fields {
+		if !isListFieldType(field.Sort) {
+			continue
+		}
+		oldList := listFieldToTableName(oldName, field.Name)
+		newList := listFieldToTableName(newName, field.Name)
+		if tx.mdb.listValues {
+			// the views are recreated under the new name once the table has been renamed
+			indexed := view.hasIndex(oldName, field)
+			if err := tx.dropListView(oldName, field.Name); err != nil {
+				return err
+			}
+			views = append(views, listView{field: field.Name, indexed: indexed})
+		} else {
+			_, err = tx.tx.Exec(fmt.Sprintf(`ALTER TABLE "%s" RENAME TO "%s";`, oldList, newList))
+			if err != nil {
+				return Fail("cannot rename list field %s in table %s: %s", field.Name, oldName, err)
+			}
+		}
+		_, err = tx.tx.Exec(`UPDATE _TABLES SET Name=? WHERE Name=?;`, newList, oldList)
+		if err != nil {
+			return Fail("failed to update maintenance table: %s", err)
+		}
+	}
+	_, err = tx.tx.Exec(`UPDATE _TABLES SET Name=? WHERE Name=?;`, newName, oldName)
+	if err != nil {
+		return Fail("failed to update maintenance table: %s", err)
+	}
+	for _, v := range views {
+		if err := tx.createListView(newName, v.field); err != nil {
+			return err
+		}
+		if v.indexed {
+			if err := tx.createListValuesIndex(newName, v.field); err != nil {
+				return err
+			}
+		}
+	}
+	_, err = tx.tx.Exec(`UPDATE _LINKS SET TableA=? WHERE TableA=?;`, newName, oldName)
+	if err != nil {
+		return Fail("failed to update links: %s", err)
+	}
+	_, err = tx.tx.Exec(`UPDATE _LINKS SET TableB=? WHERE TableB=?;`, newName, oldName)
+	if err != nil {
+		return Fail("failed to update links: %s", err)
+	}
+	_, err = tx.tx.Exec(`UPDATE _HISTORY SET TableName=? WHERE TableName=?;`, newName, oldName)
+	if err != nil {
+		return Fail("failed to update history: %s", err)
+	}
+	_, err = tx.tx.Exec(`UPDATE _VERSIONS SET TableName=? WHERE TableName=?;`, newName, oldName)
+	if err != nil {
+		return Fail("failed to update versions: %s", err)
+	}
+	if err := tx.audit(AuditEntry{Op: AuditRenameTable, Table: oldName, New: []Value{NewString(newName)}}); err != nil {
+		return err
+	}
+	tx.notify(ChangeEvent{Kind: ChangeSchema, Table: newName, OldTable: oldName})
+	return nil
+}
+
+// DropTable removes a table with all of its items, its list fields and indices.
+// This action cannot be undone once the transaction has been committed.
+func (tx *Tx) DropTable(table string) error {
+	if !validTable.MatchString(table) {
+		return Fail("invalid table name '%s'", table)
+	}
+	if !tx.View().TableExists(table) {
+		return tableNotFound(table)
+	}
+	id, err := tx.View().getTableId(table)
+	if err != nil {
+		return err
+	}
+	fields, err := tx.View().GetFields(table)
+	if err != nil {
+		return err
+	}
+	if err := tx.collectOrphans(table, fmt.Sprintf(`(SELECT Id FROM "%s")`, table), fields); err != nil {
+		return err
+	}
+	for _, field := range fields {
+		if !isListFieldType(field.Sort) {
+			continue
+		}
+		listTable := listFieldToTableName(table, field.Name)
+		if tx.mdb.listValues {
+			if err := tx.dropListView(table, field.Name); err != nil {
+				return err
+			}
+		} else {
+			_, err = tx.tx.Exec(fmt.Sprintf(`DROP TABLE IF EXISTS "%s";`, listTable))
+			if err != nil {
+				return Fail("cannot drop list field %s in table %s: %s", field.Name, table, err)
+			}
+		}
+		_, err = tx.tx.Exec(`DELETE FROM _TABLES WHERE Name=?;`, listTable)
+		if err != nil {
+			return Fail("failed to update maintenance table: %s", err)
+		}
+	}
+	_, err = tx.tx.Exec(fmt.Sprintf(`DROP TABLE IF EXISTS "%s";`, table))
+	if err != nil {
+		return Fail("cannot drop table '%s': %s", table, err)
+	}
+	if tx.mdb.listValues {
+		_, err = tx.tx.Exec(`DELETE FROM _LISTVALUES WHERE TableId=?;`, id)
+		if err != nil {
+			return Fail("cannot remove list values of table '%s': %s", table, err)
+		}
+	}
+	_, err = tx.tx.Exec(`DELETE FROM _COLS WHERE Owner=?;`, id)
+	if err != nil {
+		return Fail("failed to update maintenance table: %s", err)
+	}
+	_, err = tx.tx.Exec(`DELETE FROM _TABLES WHERE Id=?;`, id)
+	if err != nil {
+		return Fail("failed to update maintenance table: %s", err)
+	}
+	_, err = tx.tx.Exec(`DELETE FROM _LINKS WHERE TableA=? OR TableB=?;`, table, table)
+	if err != nil {
+		return Fail("failed to remove links: %s", err)
+	}
+	_, err = tx.removeHistory(`TableName=?`, table)
+	if err != nil {
+		return Fail("failed to remove history: %s", err)
+	}
+	_, err = tx.tx.Exec(`DELETE FROM _VERSIONS WHERE TableName=?;`, table)
+	if err != nil {
+		return Fail("failed to remove versions: %s", err)
+	}
+	if err := tx.audit(AuditEntry{Op: AuditDropTable, Table: table}); err != nil {
+		return err
+	}
+	tx.notify(ChangeEvent{Kind: ChangeSchema, Table: table})
+	return nil
+}
+
+// convertValue converts a value to the given base type or returns an error if this is not possible.
+// Strings are parsed as decimal integers and RFC3339 dates, integers are converted to dates
+// by interpreting them as Unix time in seconds.
+func convertValue(v Value, to FieldType) (Value, error) {
+	switch to {
+	case v.Sort:
+		return v, nil
+	case DBString:
+		switch v.Sort {
+		case DBBlob:
+			return NewString(v.Str), nil
+		default:
+			return NewString(v.String()), nil
+		}
+	case DBBlob:
+		return NewBytes(v.Bytes()), nil
+	case DBInt:
+		switch v.Sort {
+		case DBString, DBBlob:
+			n, err := strconv.ParseInt(strings.TrimSpace(v.Str), 10, 64)
+			if err != nil {
+				return v, Fail("cannot convert '%s' to int", v.Str)
+			}
+			return NewInt(n), nil
+		}
+	case DBDate:
+		switch v.Sort {
+		case DBString, DBBlob:
+			t, err := ParseTime(strings.TrimSpace(v.Str))
+			if err != nil {
+				return v, err
+			}
+			return NewDate(t), nil
+		case DBInt:
+			return NewDate(time.Unix(v.Num, 0)), nil
+		}
+	}
+	return v, Fail("cannot convert %s value to %s", GetUserTypeString(v.Sort), GetUserTypeString(to))
+}
+
+// ChangeFieldType changes the type of a field and converts all existing values to the new type.
+// A list field can only be changed into another list type and a single field only into another
+// single type. Values that cannot be converted are set to NULL, or removed from the list in case of a
+// list field. The items whose values could not be converted are returned, so the caller may inspect
+// them or roll back the transaction. Indices on the field are preserved.
+func (tx *Tx) ChangeFieldType(table string, field string, newType FieldType) ([]Item, error) {
+	view := tx.View()
+	if !validTable.MatchString(table) {
+		return nil, Fail("invalid table name '%s'", table)
+	}
+	if !view.TableExists(table) {
+		return nil, tableNotFound(table)
+	}
+	if !view.FieldExists(table, field) {
+		return nil, fieldNotFound(table, field)
+	}
+	if GetUserTypeString(newType) == "unknown" {
+		return nil, Fail("invalid field type %d", int(newType))
+	}
+	oldType := view.MustGetFieldType(table, field)
+	if isListFieldType(oldType) != isListFieldType(newType) {
+		return nil, Fail("cannot change %s field '%s' to %s, list fields can only be changed to list types",
+			GetUserTypeString(oldType), field, GetUserTypeString(newType))
+	}
+	failed := make([]Item, 0)
+	if oldType == newType {
+		return failed, nil
+	}
+	desc, err := view.getField(table, field)
+	if err != nil {
+		return nil, err
+	}
+	// the default value must be convertible, otherwise the field could not be changed consistently
+	var defaultValue sql.NullString
+	columnDefault := ""
+	if desc.Default != nil {
+		v, err := convertValue(*desc.Default, ToBaseType(newType))
+		if err == nil {
+			err = checkCustomValues(newType, []Value{v})
+		}
+		if err != nil {
+			return nil, Fail("cannot convert default value of %s %s: %s", table, field, err)
+		}
+		defaultValue = sql.NullString{String: v.String(), Valid: true}
+		if !isListFieldType(newType) {
+			columnDefault = " DEFAULT " + sqlLiteral(v)
+		}
+	}
+	realtable := table
+	ownerCol := "Id"
+	if isListFieldType(oldType) {
+		realtable = listFieldToTableName(table, field)
+		ownerCol = "Owner"
+	}
+	// read all existing values first
+	type oldRow struct {
+		id    int64
+		owner Item
+		value Value
+	}
+	rows, err := tx.tx.Query(fmt.Sprintf(`SELECT Id,%s,"%s" FROM "%s" WHERE "%s" IS NOT NULL;`,
+		ownerCol, field, realtable, field))
+	if err != nil {
+		return nil, Fail("cannot read values of %s %s: %s", table, field, err)
+	}
+	data := make([]oldRow, 0)
+	for rows.Next() {
+		var row oldRow
+		var intResult sql.NullInt64
+		var strResult sql.NullString
+		if ToBaseType(oldType) == DBInt {
+			err = rows.Scan(&row.id, &row.owner, &intResult)
+			row.value = NewInt(intResult.Int64)
+		} else {
+			err = rows.Scan(&row.id, &row.owner, &strResult)
+			row.value = Value{Str: strResult.String, Sort: ToBaseType(oldType)}
+		}
+		if err != nil {
+			rows.Close()
+			return nil, Fail("cannot read values of %s %s: %s", table, field, err)
+		}
+		data = append(data, row)
+	}
+	rows.Close()
+	// create a new column, fill it, and replace the old column by it, except for list fields
+	// in the normalized layout, whose values have no column type and are converted in place
+	inPlace := isListFieldType(oldType) && tx.mdb.listValues
+	tmpField := "__" + field
+	if inPlace {
+		tmpField = field
+	} else {
+		_, err = tx.tx.Exec(fmt.Sprintf(`ALTER TABLE "%s" ADD COLUMN "%s" %s%s;`, realtable, tmpField,
+			getTypeString(newType), columnDefault))
+		if err != nil {
+			return nil, Fail("cannot change type of %s %s: %s", table, field, err)
+		}
+	}
+	for _, row := range data {
+		v, err := convertValue(row.value, ToBaseType(newType))
+		if err == nil {
+			err = checkCustomValues(newType, []Value{v})
+		}
+		if err != nil {
+			failed = append(failed, row.owner)
+			if isListFieldType(oldType) {
+				_, err = tx.tx.Exec(fmt.Sprintf(`DELETE FROM "%s" WHERE Id=?;`, realtable), row.id)
+				if err != nil {
+					return nil, err
+				}
+			}
+			continue
+		}
+		switch v.Sort {
+		case DBInt:
+			_, err = tx.tx.Exec(fmt.Sprintf(`UPDATE "%s" SET "%s"=? WHERE Id=?;`, realtable, tmpField), v.Num, row.id)
+		case DBBlob:
+			_, err = tx.tx.Exec(fmt.Sprintf(`UPDATE "%s" SET "%s"=? WHERE Id=?;`, realtable, tmpField), v.Bytes(), row.id)
+		default:
+			_, err = tx.tx.Exec(fmt.Sprintf(`UPDATE "%s" SET "%s"=? WHERE Id=?;`, realtable, tmpField), v.Str, row.id)
+		}
+		if err != nil {
+			return nil, Fail("cannot convert value of %s %d %s: %s", table, row.owner, field, err)
+		}
+	}
+	if !inPlace {
+		var hasIndex int
+		err = tx.tx.QueryRow(`SELECT EXISTS (SELECT 1 FROM sqlite_master WHERE type='index' AND name=?);`,
+			indexName(realtable, field)).Scan(&hasIndex)
+		if err != nil {
+			return nil, err
+		}
+		if hasIndex > 0 {
+			if _, err = tx.tx.Exec(fmt.Sprintf(`DROP INDEX IF EXISTS %s;`, indexName(realtable, field))); err != nil {
+				return nil, Fail("cannot drop index of %s %s: %s", table, field, err)
+			}
+		}
+		if _, err = tx.tx.Exec(fmt.Sprintf(`ALTER TABLE "%s" DROP COLUMN "%s";`, realtable, field)); err != nil {
+			return nil, Fail("cannot change type of %s %s: %s", table, field, err)
+		}
+		if _, err = tx.tx.Exec(fmt.Sprintf(`ALTER TABLE "%s" RENAME COLUMN "%s" TO "%s";`, realtable, tmpField, field)); err != nil {
+			return nil, Fail("cannot change type of %s %s: %s", table, field, err)
+		}
+		if hasIndex > 0 {
+			_, err = tx.tx.Exec(fmt.Sprintf(`CREATE INDEX IF NOT EXISTS %s ON %s(%s);`,
+				indexName(realtable, field), realtable, field))
+			if err != nil {
+				return nil, Fail("failed to recreate index for field '%s' in table '%s': %s", field, table, err)
+			}
+		}
+	}
+	id, err := view.getTableId(table)
+	if err != nil {
+		return nil, err
+	}
+	_, err = tx.tx.Exec(`UPDATE _COLS SET FieldType=?,DefaultValue=? WHERE Owner=? AND Name=?;`,
+		newType, defaultValue, id, field)
+	if err != nil {
+		return nil, Fail("failed to update maintenance table: %s", err)
+	}
+	err = tx.audit(AuditEntry{Op: AuditChangeFieldType, Table: table, Field: field,
+		Old: []Value{NewString(GetUserTypeString(oldType))}, New: []Value{NewString(GetUserTypeString(newType))}})
+	if err != nil {
+		return nil, err
+	}
+	tx.notify(ChangeEvent{Kind: ChangeSchema, Table: table, Field: field})
+	return failed, nil
+}
+
+// NewItem creates a new item in the table and returns its numerical ID.
+func (db *MDB) NewItem(table string) (Item, error) {
+	if !validTable.MatchString(table) {
+		return 0, Fail("invalid table name '%s'", table)
+	}
+	if !db.TableExists(table) {
+		return 0, tableNotFound(table)
+	}
+	if err := db.checkSize(nil, 0); err != nil {
+		return 0, err
+	}
+
+	toExec := fmt.Sprintf("INSERT INTO %s DEFAULT VALUES;", table)
+	result, err := db.base.Exec(toExec)
+	if err != nil {
+		return 0, err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+	if err := db.setListDefaults(table, Item(id)); err != nil {
+		return 0, err
+	}
+	if err := db.stampNewItems(db.base, table, []Item{Item(id)}); err != nil {
+		return 0, err
+	}
+	if err := db.recordAudit(db.base, db.auditUser, AuditEntry{Op: AuditNewItem, Table: table, Item: Item(id)}); err != nil {
+		return 0, err
+	}
+	db.countWrite(table, nil)
+	db.notify(ChangeEvent{Kind: ChangeNewItem, Table: table, Item: Item(id)})
+	return Item(id), nil
+}
+
+// setListDefaults stores the default values of the list fields of a new item.
+// Default values of single fields are taken care of by the database.
+func (db *MDB) setListDefaults(table string, item Item) error {
+	fields, err := db.GetFields(table)
+	if err != nil {
+		return err
+	}
+	for _, field := range fields {
+		if !isListFieldType(field.Sort) || field.Default == nil {
+			continue
+		}
+		var arg interface{}
+		switch field.Default.Sort {
+		case DBInt:
+			arg = field.Default.Num
+		case DBBlob:
+			arg = field.Default.Bytes()
+		default:
+			arg = field.Default.Str
+		}
+		_, err = db.base.Exec(fmt.Sprintf(`INSERT INTO "%s"("%s",Owner) VALUES(?,?)`,
+			listFieldToTableName(table, field.Name), field.Name), arg, item)
+		if err != nil {
+			return Fail("cannot set default value of %s %d %s: %s", table, item, field.Name, err)
+		}
+	}
+	return nil
+}
+
+// UseItem creates a new item with the given ID or returns the item with the given ID
+// if it already exists. This may be used when fixed IDs are needed, but should be avoided
+// when these are not strictly necessary.
+func (db *MDB) UseItem(table string, id uint64) (Item, error) {
+	if !validTable.MatchString(table) {
+		return 0, Fail("invalid table name '%s'", table)
+	}
+	if !db.TableExists(table) {
+		return 0, tableNotFound(table)
+	}
+	if db.ItemExists(table, Item(id)) {
+		return Item(id), nil
+	}
+	if err := db.checkSize(nil, 0); err != nil {
+		return 0, err
+	}
+	toExec := fmt.Sprintf("INSERT INTO %s(Id) VALUES (?);", table)
+	_, err := db.base.Exec(toExec, id)
+	if err != nil {
+		return 0, err
+	}
+	if err := db.setListDefaults(table, Item(id)); err != nil {
+		return 0, err
+	}
+	if err := db.stampNewItems(db.base, table, []Item{Item(id)}); err != nil {
+		return 0, err
+	}
+	if err := db.recordAudit(db.base, db.auditUser, AuditEntry{Op: AuditNewItem, Table: table, Item: Item(id)}); err != nil {
+		return 0, err
+	}
+	db.countWrite(table, nil)
+	db.notify(ChangeEvent{Kind: ChangeNewItem, Table: table, Item: Item(id)})
+	return Item(id), nil
+}
+
+// CloneItem creates a new item in the table with the values of all single and list fields of
+// the given item and returns the new item. Links and the change log of the item are not copied.
+// If the table has timestamps, the new item gets the current time as creation and modification time.
+func (tx *Tx) CloneItem(table string, item Item) (Item, error) {
+	if !validTable.MatchString(table) {
+		return 0, Fail("invalid table name '%s'", table)
+	}
+	view := tx.View()
+	if !view.TableExists(table) {
+		return 0, tableNotFound(table)
+	}
+	if !view.ItemExists(table, item) {
+		return 0, itemNotFound(table, item)
+	}
+	if err := view.checkSize(tx, 0); err != nil {
+		return 0, err
+	}
+	fields, err := view.GetFields(table)
+	if err != nil {
+		return 0, err
+	}
+	columns := make([]string, 0, len(fields))
+	for _, field := range fields {
+		if !isListFieldType(field.Sort) && !isTimestampField(field.Name) {
+			columns = append(columns, fmt.Sprintf(`"%s"`, field.Name))
+		}
+	}
+	toExec := fmt.Sprintf(`INSERT INTO "%s" DEFAULT VALUES;`, table)
+	if len(columns) > 0 {
+		cols := strings.Join(columns, ",")
+		toExec = fmt.Sprintf(`INSERT INTO "%s" (%s) SELECT %s FROM "%s" WHERE Id=%d;`, table, cols, cols, table, item)
+	}
+	result, err := tx.tx.Exec(toExec)
+	if err != nil {
+		return 0, Fail("cannot clone %s %d: %s", table, item, err)
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return 0, Fail("cannot clone %s %d: %s", table, item, err)
+	}
+	clone := Item(id)
+	for _, field := range fields {
+		if !isListFieldType(field.Sort) {
+			continue
+		}
+		_, err = tx.tx.Exec(fmt.Sprintf(`INSERT INTO "%[1]s" (Owner,"%[2]s") SELECT ?,"%[2]s" FROM "%[1]s" WHERE Owner=? ORDER BY Id;`,
+			listFieldToTableName(table, field.Name), field.Name), clone, item)
+		if err != nil {
+			return 0, Fail("cannot clone %s %d %s: %s", table, item, field.Name, err)
+		}
+	}
+	if err := view.stampNewItems(tx.tx, table, []Item{clone}); err != nil {
+		return 0, err
+	}
+	if err := tx.audit(AuditEntry{Op: AuditNewItem, Table: table, Item: clone}); err != nil {
+		return 0, err
+	}
+	tx.mdb.countWrite(table, nil)
+	tx.notify(ChangeEvent{Kind: ChangeNewItem, Table: table, Item: clone})
+	return clone, nil
+}
+
+// RemoveItem remove an item from the table.
+func (tx *Tx) RemoveItem(table string, item Item) error {
+	return tx.RemoveItems(table, []Item{item})
+}
+
+// RemoveItems removes the items from the table together with the values of their list fields,
+// their links, and their change log, using one statement per internal table for up to
+// maxBulkParams items. Items that do not exist are ignored.
+func (tx *Tx) RemoveItems(table string, items []Item) error {
+	if !validTable.MatchString(table) {
+		return Fail(`invalid table name "%s"`, table)
+	}
+	if !tx.View().TableExists(table) {
+		return tableNotFound(table)
+	}
+	fields, err := tx.View().GetFields(table)
+	if err != nil {
+		return err
+	}
+	for start := 0; start < len(items); start += maxBulkParams {
+		end := start + maxBulkParams
+		if end > len(items) {
+			end = len(items)
+		}
+		ids := make([]string, 0, end-start)
+		for _, item := range items[start:end] {
+			ids = append(ids, strconv.FormatInt(int64(item), 10))
+		}
+		in := "(" + strings.Join(ids, ",") + ")"
+		if tx.mdb.audit || tx.mdb.hooks.active() {
+			removed, err := tx.existingItems(table, in)
+			if err != nil {
+				return err
+			}
+			if err := tx.auditRemoved(table, removed); err != nil {
+				return err
+			}
+			for _, item := range removed {
+				tx.notify(ChangeEvent{Kind: ChangeRemoveItem, Table: table, Item: item})
+			}
+		}
+		if err := tx.collectOrphans(table, in, fields); err != nil {
+			return err
+		}
+		// list values first, since they refer to their owners
+		for _, field := range fields {
+			if !isListFieldType(field.Sort) {
+				continue
+			}
+			_, err = tx.tx.Exec(fmt.Sprintf(`DELETE FROM "%s" WHERE Owner IN %s;`, listFieldToTableName(table, field.Name), in))
+			if err != nil {
+				return Fail(`error while deleting %s of items of %s: %s`, field.Name, table, err)
+			}
+		}
+		result, err := tx.tx.Exec(fmt.Sprintf(`DELETE FROM "%s" WHERE Id IN %s;`, table, in))
+		if err != nil {
+			return Fail(`error while deleting items of %s: %s`, table, err)
+		}
+		_, err = tx.tx.Exec(fmt.Sprintf(`DELETE FROM _LINKS WHERE (TableA=? AND ItemA IN %[1]s) OR (TableB=? AND ItemB IN %[1]s);`, in),
+			table, table)
+		if err != nil {
+			return Fail(`error while deleting links of items of %s: %s`, table, err)
+		}
+		_, err = tx.removeHistory(`TableName=? AND Item IN `+in, table)
+		if err != nil {
+			return Fail(`error while deleting history of items of %s: %s`, table, err)
+		}
+		_, err = tx.tx.Exec(fmt.Sprintf(`DELETE FROM _VERSIONS WHERE TableName=? AND Item IN %s;`, in), table)
+		if err != nil {
+			return Fail(`error while deleting versions of items of %s: %s`, table, err)
+		}
+		if n, err := result.RowsAffected(); err == nil {
+			tx.mdb.countOp(table, 0, n, 0)
+		}
+	}
+	return nil
+}
+
+// existingItems returns the items in the SQL list of ids that exist in the table.
+func (tx *Tx) existingItems(table string, in string) ([]Item, error) {
+	rows, err := tx.tx.Query(fmt.Sprintf(`SELECT Id FROM "%s" WHERE Id IN %s ORDER BY Id;`, table, in))
+	if err != nil {
+		return nil, Fail(`error while deleting items of %s: %s`, table, err)
+	}
+	defer rows.Close()
+	result := make([]Item, 0)
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, Fail(`error while deleting items of %s: %s`, table, err)
+		}
+		result = append(result, Item(id))
+	}
+	return result, rows.Err()
+}
+
+// Count returns the number of items in the table.
+func (db *MDB) Count(table string) (int64, error) {
+	if !validTable.MatchString(table) {
+		return 0, Fail("invalid table name '%s'", table)
+	}
+	if !db.TableExists(table) {
+		return 0, tableNotFound(table)
+	}
+	key := cacheKey{kind: cacheCount, table: table}
+	cached, generation, ok := db.cached(key)
+	if ok {
+		return cached, nil
+	}
+	var result int64
+	err := db.reader.QueryRow(fmt.Sprintf(`SELECT COUNT(*) FROM %s;`, table)).Scan(&result)
+	if err != nil {
+		return 0, err
+	}
+	db.storeCached(key, generation, result)
+	return result, nil
+}
+
+// ListItems returns a list of items in the table.
+func (db *MDB) ListItems(table string, limit int64) ([]Item, error) {
+	empty := make([]Item, 0)
+	if !validTable.MatchString(table) {
+		return empty, Fail("invalid table name '%s'", table)
+	}
+	if !db.TableExists(table) {
+		return empty, tableNotFound(table)
+	}
+	rows, err := db.reader.Query(fmt.Sprintf(`SELECT (Id) FROM %s;`, table))
+	if err != nil {
+		return empty, err
+	}
+	defer rows.Close()
+	results := make([]Item, 0)
 	var c int64
 	for rows.Next() {
 		var datum sql.NullInt64
@@ -875,33 +2357,299 @@ func (db *MDB) ListItems(table string, limit int64) ([]Item, error) {
 			break
 		}
 	}
-	return results, nil
+	return results, nil
+}
+
+// Get returns the value(s) of a field of an item in a table. If a single field has no value, a NULL
+// value is returned, unless SetEmptyForAbsent has been used to make Get behave like GetOrEmpty.
+func (db *MDB) Get(table string, item Item, field string) ([]Value, error) {
+	if db.emptyForAbsent {
+		return db.GetOrEmpty(table, item, field)
+	}
+	return db.get(table, item, field)
+}
+
+// GetOrEmpty returns the value(s) of a field of an item in a table like Get, but returns an empty
+// slice without error if a single field has no value or a list field has no elements.
+func (db *MDB) GetOrEmpty(table string, item Item, field string) ([]Value, error) {
+	if !validTable.MatchString(table) {
+		return nil, Fail("invalid table name '%s'", table)
+	}
+	if !db.TableExists(table) {
+		return nil, tableNotFound(table)
+	}
+	if !db.FieldExists(table, field) {
+		return nil, fieldNotFound(table, field)
+	}
+	if !db.ItemExists(table, item) {
+		return nil, itemNotFound(table, item)
+	}
+	absent, err := db.isNullOrEmpty(table, item, field, db.IsListField(table, field))
+	if err != nil {
+		return nil, err
+	}
+	if absent {
+		db.countRead(table)
+		return []Value{}, nil
+	}
+	return db.get(table, item, field)
+}
+
+// SetEmptyForAbsent determines whether Get returns an empty slice instead of a NULL value
+// for single fields without value and instead of an error for empty list fields, see GetOrEmpty. It is false by default for backwards compatibility.
+func (db *MDB) SetEmptyForAbsent(on bool) {
+	db.emptyForAbsent = on
+}
+
+func (db *MDB) get(table string, item Item, field string) ([]Value, error) {
+	if !validTable.MatchString(table) {
+		return nil, Fail("invalid table name '%s'", table)
+	}
+	if !db.TableExists(table) {
+		return nil, tableNotFound(table)
+	}
+	if !db.ItemExists(table, item) {
+		return nil, itemNotFound(table, item)
+	}
+	db.countRead(table)
+	var values []Value
+	var err error
+	if db.IsListField(table, field) {
+		values, err = db.getListField(table, item, field)
+	} else {
+		values, err = db.getSingleField(table, item, field)
+	}
+	if err != nil || db.blobs == nil {
+		return values, err
+	}
+	desc, err := db.getField(table, field)
+	if err != nil {
+		return nil, err
+	}
+	if err := db.loadBlobs(desc, values); err != nil {
+		return nil, err
+	}
+	return values, nil
+}
+
+// GetItem returns the values of all fields of an item in a table, using one query for all single
+// fields and one for all list fields. Fields without value are mapped to an empty slice.
+func (db *MDB) GetItem(table string, item Item) (map[string][]Value, error) {
+	if !validTable.MatchString(table) {
+		return nil, Fail("invalid table name '%s'", table)
+	}
+	if !db.TableExists(table) {
+		return nil, tableNotFound(table)
+	}
+	fields, err := db.GetFields(table)
+	if err != nil {
+		return nil, err
+	}
+	result := make(map[string][]Value)
+	singles := make([]Field, 0)
+	lists := make([]Field, 0)
+	for _, field := range fields {
+		result[field.Name] = []Value{}
+		if isListFieldType(field.Sort) {
+			lists = append(lists, field)
+		} else {
+			singles = append(singles, field)
+		}
+	}
+	// the Id is selected as well so that the query also works for tables without single fields
+	columns := "Id"
+	for _, field := range singles {
+		columns += fmt.Sprintf(`,"%s"`, field.Name)
+	}
+	raw := make([]interface{}, len(singles)+1)
+	dest := make([]interface{}, len(raw))
+	for i := range raw {
+		dest[i] = &raw[i]
+	}
+	err = db.reader.QueryRow(fmt.Sprintf(`SELECT %s FROM "%s" WHERE Id=?;`, columns, table), item).Scan(dest...)
+	if err == sql.ErrNoRows {
+		return nil, itemNotFound(table, item)
+	}
+	if err != nil {
+		return nil, Fail("cannot get %s %d: %s", table, item, err)
+	}
+	for i, field := range singles {
+		if raw[i+1] == nil {
+			continue
+		}
+		v, err := rawToValue(raw[i+1], field.Sort)
+		if err != nil {
+			return nil, Fail("cannot get %s %d %s: %s", table, item, field.Name, err)
+		}
+		result[field.Name] = []Value{v}
+	}
+	if len(lists) > 0 {
+		parts := make([]string, len(lists))
+		args := make([]interface{}, len(lists))
+		for i, field := range lists {
+			parts[i] = fmt.Sprintf(`SELECT %d,"%s",Id FROM "%s" WHERE Owner=?`, i, field.Name,
+				listFieldToTableName(table, field.Name))
+			args[i] = item
+		}
+		rows, err := db.reader.Query(strings.Join(parts, " UNION ALL ")+" ORDER BY 1,3;", args...)
+		if err != nil {
+			return nil, Fail("cannot get list fields of %s %d: %s", table, item, err)
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var k int
+			var id int64
+			var value interface{}
+			if err := rows.Scan(&k, &value, &id); err != nil {
+				return nil, Fail("cannot get list fields of %s %d: %s", table, item, err)
+			}
+			if value == nil {
+				continue
+			}
+			v, err := rawToValue(value, lists[k].Sort)
+			if err != nil {
+				return nil, Fail("cannot get %s %d %s: %s", table, item, lists[k].Name, err)
+			}
+			result[lists[k].Name] = append(result[lists[k].Name], v)
+		}
+		if err := rows.Err(); err != nil {
+			return nil, Fail("cannot get list fields of %s %d: %s", table, item, err)
+		}
+	}
+	for _, field := range fields {
+		if err := db.loadBlobs(field, result[field.Name]); err != nil {
+			return nil, Fail("cannot get %s %d %s: %s", table, item, field.Name, err)
+		}
+	}
+	db.countRead(table)
+	return result, nil
+}
+
+// GetMulti returns the value(s) of a field for several items of a table, using one query for
+// up to maxBulkParams items. Items without value are mapped to an empty slice and items that
+// do not exist are not contained in the result.
+func (db *MDB) GetMulti(table string, items []Item, field string) (map[Item][]Value, error) {
+	if !validTable.MatchString(table) {
+		return nil, Fail("invalid table name '%s'", table)
+	}
+	if !db.TableExists(table) {
+		return nil, tableNotFound(table)
+	}
+	desc, err := db.getField(table, field)
+	if err != nil {
+		return nil, err
+	}
+	result := make(map[Item][]Value)
+	for start := 0; start < len(items); start += maxBulkParams {
+		end := start + maxBulkParams
+		if end > len(items) {
+			end = len(items)
+		}
+		args := make([]interface{}, end-start)
+		for i, item := range items[start:end] {
+			args[i] = item
+		}
+		in := strings.TrimSuffix(strings.Repeat("?,", len(args)), ",")
+		var query string
+		if isListFieldType(desc.Sort) {
+			query = fmt.Sprintf(`SELECT t.Id,l."%s" FROM "%s" t LEFT JOIN "%s" l ON l.Owner=t.Id WHERE t.Id IN (%s) ORDER BY t.Id,l.Id;`,
+				field, table, listFieldToTableName(table, field), in)
+		} else {
+			query = fmt.Sprintf(`SELECT Id,"%s" FROM "%s" WHERE Id IN (%s);`, field, table, in)
+		}
+		if err := db.getMultiRows(query, args, desc, result); err != nil {
+			return nil, Fail("cannot get %s %s: %s", table, field, err)
+		}
+	}
+	db.countRead(table)
+	return result, nil
 }
 
-// Get returns the value(s) of a field of an item in a table.
-func (db *MDB) Get(table string, item Item, field string) ([]Value, error) {
-	if !validTable.MatchString(table) {
-		return nil, Fail("invalid table name '%s'", table)
+// getMultiRows adds the values of rows of item ids and values to the result.
+func (db *MDB) getMultiRows(query string, args []interface{}, desc Field, result map[Item][]Value) error {
+	rows, err := db.reader.Query(query, args...)
+	if err != nil {
+		return err
 	}
-	if !db.TableExists(table) {
-		return nil, Fail("table '%s' does not exist", table)
+	defer rows.Close()
+	for rows.Next() {
+		var item Item
+		var raw interface{}
+		if err := rows.Scan(&item, &raw); err != nil {
+			return err
+		}
+		if _, ok := result[item]; !ok {
+			result[item] = []Value{}
+		}
+		if raw == nil {
+			continue
+		}
+		v, err := rawToValue(raw, desc.Sort)
+		if err != nil {
+			return err
+		}
+		result[item] = append(result[item], v)
 	}
-	if !db.ItemExists(table, item) {
-		return nil, Fail("no %s %d", table, item)
+	if err := rows.Err(); err != nil {
+		return err
 	}
-	if db.IsListField(table, field) {
-		return db.getListField(table, item, field)
+	for _, values := range result {
+		if err := db.loadBlobs(desc, values); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// rawToValue converts a value scanned from the database into a value of the field type.
+func rawToValue(raw interface{}, t FieldType) (Value, error) {
+	var str string
+	switch x := raw.(type) {
+	case int64:
+		if ToBaseType(t) == DBInt {
+			return NewInt(x), nil
+		}
+		str = strconv.FormatInt(x, 10)
+	case []byte:
+		str = string(x)
+	case string:
+		str = x
+	case time.Time:
+		// the driver parses DATE columns, which hold dates in the format of NewDate
+		str = x.UTC().Format(time.RFC3339)
+	default:
+		return Value{}, Fail("unexpected value of type %T", raw)
+	}
+	switch ToBaseType(t) {
+	case DBInt:
+		n, err := strconv.ParseInt(str, 10, 64)
+		if err != nil {
+			return Value{}, Fail("invalid int value '%s'", str)
+		}
+		return NewInt(n), nil
+	case DBBlob:
+		return NewBytes([]byte(str)), nil
+	case DBDate:
+		if !isListFieldType(t) {
+			return NewDateStr(str), nil
+		}
+		d, err := ParseTime(str)
+		if err != nil {
+			return Value{}, Fail("invalid date representation '%s'", str)
+		}
+		return NewDate(d), nil
+	default:
+		return NewString(str), nil
 	}
-	return db.getSingleField(table, item, field)
 }
 
 func (db *MDB) getSingleField(table string, item Item, field string) ([]Value, error) {
 	if !db.FieldExists(table, field) {
-		return nil,
-			Fail(`no field %s in table %s`, field, table)
+		return nil, fieldNotFound(table, field)
 	}
-	t := db.MustGetFieldType(table, field)
-	row := db.base.QueryRow(fmt.Sprintf(`SELECT "%s" FROM "%s" WHERE Id=?;`, field, table), item)
+	custom := db.MustGetFieldType(table, field)
+	t := ToBaseType(custom)
+	row := db.reader.QueryRow(fmt.Sprintf(`SELECT "%s" FROM "%s" WHERE Id=?;`, field, table), item)
 	var intResult sql.NullInt64
 	var strResult sql.NullString
 	var err error
@@ -926,33 +2674,35 @@ func (db *MDB) getSingleField(table string, item Item, field string) ([]Value, e
 	switch t {
 	case DBInt:
 		if !intResult.Valid {
-			return nil,
-				Fail("no int value for %s %d %s", table, item, field)
+			vslice[0] = NewNull()
+			break
 		}
 		vslice[0] = NewInt(intResult.Int64)
 	case DBString, DBBlob, DBDate:
 		if !strResult.Valid {
-			return nil,
-				Fail("no string, blob, or date value for %s %d %s", table, item, field)
+			vslice[0] = NewNull()
+			break
 		}
 		vslice[0] = NewString(strResult.String)
 		vslice[0].Sort = t
 	}
+	if err := checkCustomValues(custom, vslice); err != nil {
+		return nil, Fail("invalid value of %s %d %s: %s", table, item, field, err)
+	}
 	return vslice, nil
 }
 
 func (db *MDB) getListField(table string, item Item, field string) ([]Value, error) {
 	tableName := listFieldToTableName(table, field)
 	if !db.TableExists(tableName) {
-		return nil,
-			Fail("list field %s does not exist in table %s", field, table)
+		return nil, fieldNotFound(table, field)
 	}
 	if db.IsEmptyListField(tableName, item, field) {
 		return nil,
 			Fail("no values for %s %d %s", table, item, field)
 	}
 	t := db.MustGetFieldType(table, field)
-	rows, err := db.base.Query(fmt.Sprintf(`SELECT "%s" FROM "%s" WHERE Owner=?`, field, tableName), item)
+	rows, err := db.reader.Query(fmt.Sprintf(`SELECT "%s" FROM "%s" WHERE Owner=?`, field, tableName), item)
 	if err != nil {
 		return nil,
 			Fail("cannot find values for %s %d %s: %s", table, item, field, err)
@@ -1020,40 +2770,241 @@ func (db *MDB) getListField(table string, item Item, field string) ([]Value, err
 }
 
 // Set the given values in the item in table and given field. An error is returned
-// if the field types don't match the data.
+// if the field types don't match the data or a validator added with AddValidator rejects
+// them. The change is recorded in the change log,
+// see MDB.HistoryOf and MDB.AsOf.
 func (tx *Tx) Set(table string, item Item, field string, data []Value) error {
+	view := tx.View()
 	if !validTable.MatchString(table) {
 		return Fail("invalid table name '%s'", table)
 	}
-	if !tx.mdb.TableExists(table) {
-		return Fail("table '%s' does not exist", table)
+	if !view.TableExists(table) {
+		return tableNotFound(table)
+	}
+	if !view.FieldExists(table, field) {
+		return fieldNotFound(table, field)
+	}
+	if !view.ItemExists(table, item) {
+		return itemNotFound(table, item)
+	}
+	desc, err := view.getField(table, field)
+	if err != nil {
+		return err
+	}
+	if err := tx.mdb.checkSize(tx, valuesSize(data)); err != nil {
+		return err
+	}
+	if err := checkFieldValues(table, item, desc, data); err != nil {
+		return err
+	}
+	if err := tx.mdb.validate(table, field, data); err != nil {
+		return err
+	}
+	var old []Value
+	if tx.mdb.audit {
+		old, _ = view.Get(table, item, field)
+	}
+	if err := tx.collectOrphans(table, fmt.Sprintf("(%d)", item), []Field{desc}); err != nil {
+		return err
+	}
+	stored, err := tx.mdb.storeBlobs(desc, data)
+	if err != nil {
+		return err
+	}
+	if isListFieldType(desc.Sort) {
+		err = tx.setListFields(table, item, field, stored)
+	} else {
+		err = tx.setSingleField(table, item, field, stored[0])
+	}
+	if err != nil {
+		return err
+	}
+	if err := tx.touch(table, item); err != nil {
+		return err
+	}
+	if err := tx.bumpVersion(table, item); err != nil {
+		return err
+	}
+	tx.mdb.countWrite(table, data)
+	if err := tx.recordChange(table, item, field, desc.Sort, stored); err != nil {
+		return err
+	}
+	err = tx.audit(AuditEntry{Op: AuditSet, Table: table, Item: item, Field: field, Old: old, New: append([]Value{}, data...)})
+	if err != nil {
+		return err
 	}
-	if !tx.mdb.FieldExists(table, field) {
-		return Fail("field '%s' does not exist in table '%s'", field, table)
+	tx.notify(ChangeEvent{Kind: ChangeSet, Table: table, Item: item, Field: field})
+	return nil
+}
+
+// checkFieldValues returns an error if the values cannot be stored in the field.
+func checkFieldValues(table string, item Item, desc Field, data []Value) error {
+	if isTimestampField(desc.Name) {
+		return Fail("field '%s' in table '%s' is maintained automatically and cannot be set", desc.Name, table)
 	}
-	if !tx.mdb.ItemExists(table, item) {
-		return Fail("no %s %d", table, item)
+	if desc.Required && len(data) == 0 {
+		return Fail("field '%s' in table '%s' is required and cannot be empty", desc.Name, table)
 	}
-	t := ToBaseType(tx.mdb.MustGetFieldType(table, field))
+	t := ToBaseType(desc.Sort)
 	for i := range data {
+		if data[i].Sort == DBNull && !isListFieldType(desc.Sort) {
+			if desc.Required {
+				return Fail("field '%s' in table '%s' is required and cannot be NULL", desc.Name, table)
+			}
+			continue
+		}
 		if data[i].Sort != t {
-			return Fail("type error %s %d %s: expected %s, encountered %s",
-				table, item, field, GetUserTypeString(t), GetUserTypeString(data[i].Sort))
+			return Fail("type error %s %d %s: %w", table, item, desc.Name,
+				&TypeMismatchError{Expected: t, Encountered: data[i].Sort})
 		}
 	}
-	if tx.mdb.IsListField(table, field) {
-		return tx.setListFields(table, item, field, data)
-	}
-	if len(data) > 1 {
+	if !isListFieldType(desc.Sort) && len(data) != 1 {
 		return Fail("attempt to set %d values in single field %s %d %s, should be just one value",
-			len(data), table, item, field)
+			len(data), table, item, desc.Name)
+	}
+	return checkCustomValues(desc.Sort, data)
+}
+
+// SetItem sets several fields of an item at once, using one UPDATE statement for all single
+// fields and one INSERT statement per list field. All values are checked before anything is written
+// and either all fields are set or none. The changes are recorded in the change log like with Set.
+func (tx *Tx) SetItem(table string, item Item, values map[string][]Value) error {
+	view := tx.View()
+	if !validTable.MatchString(table) {
+		return Fail("invalid table name '%s'", table)
+	}
+	if !view.TableExists(table) {
+		return tableNotFound(table)
+	}
+	if !view.ItemExists(table, item) {
+		return itemNotFound(table, item)
+	}
+	fields, err := view.GetFields(table)
+	if err != nil {
+		return err
+	}
+	descs := make(map[string]Field)
+	for _, field := range fields {
+		descs[field.Name] = field
+	}
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var size int64
+	for _, name := range names {
+		desc, ok := descs[name]
+		if !ok {
+			return fieldNotFound(table, name)
+		}
+		if err := checkFieldValues(table, item, desc, values[name]); err != nil {
+			return err
+		}
+		if err := tx.mdb.validate(table, name, values[name]); err != nil {
+			return err
+		}
+		size += valuesSize(values[name])
+	}
+	if err := tx.mdb.checkSize(tx, size); err != nil {
+		return err
+	}
+	old := make(map[string][]Value)
+	if tx.mdb.audit {
+		view := tx.View()
+		for _, name := range names {
+			old[name], _ = view.Get(table, item, name)
+		}
+	}
+	sub, err := tx.Begin()
+	if err != nil {
+		return err
+	}
+	defer sub.Rollback()
+	stored := make(map[string][]Value, len(names))
+	for _, name := range names {
+		if err := sub.collectOrphans(table, fmt.Sprintf("(%d)", item), []Field{descs[name]}); err != nil {
+			return err
+		}
+		if stored[name], err = tx.mdb.storeBlobs(descs[name], values[name]); err != nil {
+			return err
+		}
+	}
+	assignments := make([]string, 0)
+	args := make([]interface{}, 0)
+	for _, name := range names {
+		if !isListFieldType(descs[name].Sort) {
+			assignments = append(assignments, fmt.Sprintf(`"%s"=?`, name))
+			args = append(args, sqlArg(stored[name][0]))
+		}
+	}
+	if len(assignments) > 0 {
+		args = append(args, item)
+		_, err = sub.tx.Exec(fmt.Sprintf(`UPDATE "%s" SET %s WHERE Id=?;`, table, strings.Join(assignments, ",")), args...)
+		if err != nil {
+			return Fail("cannot set fields of %s %d: %s", table, item, err)
+		}
+	}
+	for _, name := range names {
+		if isListFieldType(descs[name].Sort) {
+			if err := sub.replaceListValues(table, item, name, stored[name]); err != nil {
+				return err
+			}
+		}
+	}
+	if err := sub.touch(table, item); err != nil {
+		return err
+	}
+	if err := sub.bumpVersion(table, item); err != nil {
+		return err
+	}
+	for _, name := range names {
+		tx.mdb.countWrite(table, values[name])
+		if err := sub.recordChange(table, item, name, descs[name].Sort, stored[name]); err != nil {
+			return err
+		}
+		err = sub.audit(AuditEntry{Op: AuditSet, Table: table, Item: item, Field: name, Old: old[name],
+			New: append([]Value{}, values[name]...)})
+		if err != nil {
+			return err
+		}
+		sub.notify(ChangeEvent{Kind: ChangeSet, Table: table, Item: item, Field: name})
+	}
+	return sub.Commit()
+}
+
+// replaceListValues replaces the values of a list field with one INSERT statement
+// for up to maxBulkParams/2 values.
+func (tx *Tx) replaceListValues(table string, item Item, field string, data []Value) error {
+	tableName := listFieldToTableName(table, field)
+	_, err := tx.tx.Exec(fmt.Sprintf(`DELETE FROM "%s" WHERE Owner=?;`, tableName), item)
+	if err != nil {
+		return Fail("cannot set %s %d %s: %s", table, item, field, err)
 	}
-	return tx.setSingleField(table, item, field, data[0])
+	chunk := maxBulkParams / 2
+	for start := 0; start < len(data); start += chunk {
+		end := start + chunk
+		if end > len(data) {
+			end = len(data)
+		}
+		args := make([]interface{}, 0, 2*(end-start))
+		for _, v := range data[start:end] {
+			args = append(args, sqlArg(v), item)
+		}
+		_, err = tx.tx.Exec(fmt.Sprintf(`INSERT INTO "%s" ("%s",Owner) VALUES (?,?)%s;`,
+			tableName, field, strings.Repeat(",(?,?)", end-start-1)), args...)
+		if err != nil {
+			return Fail("cannot set %s %d %s: %s", table, item, field, err)
+		}
+	}
+	return nil
 }
 
 func (tx *Tx) setSingleField(table string, item Item, field string, datum Value) error {
 	var err error
 	switch datum.Sort {
+	case DBNull:
+		_, err = tx.tx.Exec(fmt.Sprintf(`UPDATE "%s" SET "%s" = NULL WHERE Id=?;`, table, field), item)
 	case DBInt:
 		_, err = tx.tx.Exec(fmt.Sprintf(`UPDATE "%s" SET "%s" = ? WHERE Id=?;`, table, field), datum.Int(), item)
 	case DBBlob:
@@ -1068,7 +3019,7 @@ func (tx *Tx) setSingleField(table string, item Item, field string, datum Value)
 func (tx *Tx) setListFields(table string, item Item, field string, data []Value) error {
 	var err error
 	tableName := listFieldToTableName(table, field)
-	if !tx.mdb.TableExists(tableName) {
+	if !tx.View().TableExists(tableName) {
 		return Fail("internal error, table %s does not exist (database has been tampered)",
 			tableName)
 	}
@@ -1099,34 +3050,67 @@ func (tx *Tx) setListFields(table string, item Item, field string, data []Value)
 // GetFields returns the fields that belong to a table, including list fields.
 func (db *MDB) GetFields(table string) ([]Field, error) {
 	if !db.TableExists(table) {
-		return nil, Fail("table '%s' does not exist", table)
+		return nil, tableNotFound(table)
 	}
 	id, err := db.getTableId(table)
 	if err != nil {
 		return nil, err
 	}
-	rows, err := db.base.Query(`SELECT Name,FieldType FROM _COLS WHERE Owner=?;`, id)
+	rows, err := db.reader.Query(`SELECT Name,FieldType,Required,DefaultValue,Access FROM _COLS WHERE Owner=?;`, id)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 	result := make([]Field, 0)
 	for rows.Next() {
-		var s string
-		var n int64
-		if err := rows.Scan(&s, &n); err == nil {
-			result = append(result, Field{s, FieldType(n)})
-		} else {
+		field, err := scanField(rows)
+		if err != nil {
 			return nil, err
 		}
+		result = append(result, field)
 	}
 	return result, nil
 }
 
-// GetTables returns the tables in the database.
+// getField returns the field description of a field in a table.
+func (db *MDB) getField(table string, field string) (Field, error) {
+	id, err := db.getTableId(table)
+	if err != nil {
+		return Field{}, tableNotFound(table)
+	}
+	row := db.reader.QueryRow(`SELECT Name,FieldType,Required,DefaultValue,Access FROM _COLS WHERE Owner=? AND Name=?;`,
+		id, field)
+	result, err := scanField(row)
+	if err == sql.ErrNoRows {
+		return Field{}, fieldNotFound(table, field)
+	}
+	return result, err
+}
+
+// scanField scans a field description from a row of _COLS with columns
+// Name, FieldType, Required, DefaultValue, and Access.
+func scanField(row interface{ Scan(...interface{}) error }) (Field, error) {
+	var field Field
+	var n int64
+	var defaultValue sql.NullString
+	if err := row.Scan(&field.Name, &n, &field.Required, &defaultValue, &field.Access); err != nil {
+		return Field{}, err
+	}
+	field.Sort = FieldType(n)
+	if defaultValue.Valid {
+		v, err := parseValue(ToBaseType(field.Sort), defaultValue.String)
+		if err != nil {
+			return Field{}, Fail("invalid default value of field '%s': %s", field.Name, err)
+		}
+		field.Default = &v
+	}
+	return field, nil
+}
+
+// GetTables returns the tables in the database in the order of their creation.
 func (db *MDB) GetTables() []string {
 	result := make([]string, 0)
-	rows, err := db.base.Query(`SELECT Name FROM _TABLES;`)
+	rows, err := db.reader.Query(`SELECT Name FROM _TABLES ORDER BY Id;`)
 	if err != nil {
 		return result
 	}
@@ -1175,8 +3159,10 @@ const (
 	LeftParen
 	// RightParen is the type of ")" and variants, used internally.
 	RightParen
-	// InfixOP is the type of "=".
+	// InfixOP is the type of "=" and of "is" in "Name is null".
 	InfixOP
+	// LinkedTerm is the type of "linked:relation Table" in a query like "Person linked:owns Asset Name=Car%".
+	LinkedTerm
 )
 
 // QuerySortToStr convert the sort of a query to a string. This is merely used for debugging and testing.
@@ -1208,6 +3194,8 @@ func QuerySortToStr(s QuerySort) string {
 		return "RightParen"
 	case InfixOP:
 		return "InfixOP"
+	case LinkedTerm:
+		return "LinkedTerm"
 	default:
 		return "<unknown>"
 	}
@@ -1248,6 +3236,11 @@ func fPrintEscape(s string) string {
 	return strings.Replace(s, "%", "%%", -1)
 }
 
+// sqlQuoteEscape escapes single quotes in s for use in an SQL string literal.
+func sqlQuoteEscape(s string) string {
+	return strings.Replace(s, "'", "''", -1)
+}
+
 func blobQueryEscape(s string) string {
 	return strings.Replace(strings.Replace(s, `\`, `\\`, -1),
 		`%%`, `\%%`, -1)
@@ -1295,7 +3288,21 @@ func (db *MDB) toSqlSearchTerm(q *Query, table string,
 			return "", err
 		}
 		if !db.FieldExists(table, fieldName) {
-			return "", Fail("field '%s' does not exist in table '%s'", fieldName, table)
+			return "", fieldNotFound(table, fieldName)
+		}
+		if (*q).Data == "is" {
+			if (*q).Children[1].Data != "null" {
+				return "", Fail("expected null after is, given '%s'", (*q).Children[1].Data)
+			}
+			*paramStartIdx++
+			// a list field is NULL if it has no elements, which needs a subquery instead of a join
+			*fieldDescs = append(*fieldDescs, fieldDesc{fieldName, 1, []bool{false}, *paramStartIdx})
+			paramStr := "<P" + strconv.Itoa(*paramStartIdx) + ">"
+			if db.IsListField(table, fieldName) {
+				return fmt.Sprintf("NOT EXISTS (SELECT 1 FROM %s AS %s WHERE %s.Owner=%s.Id)",
+					listFieldToTableName(table, fieldName), paramStr, paramStr, table), nil
+			}
+			return fmt.Sprintf("%s.%s IS NULL", paramStr, fieldName), nil
 		}
 		searchTerm, err := db.toSqlSearchTerm(&(*q).Children[1], table, fieldDescs, paramStartIdx)
 		if err != nil {
@@ -1363,8 +3370,9 @@ func (db *MDB) toSqlSearchTerm(q *Query, table string,
 		switch (*q).Sort {
 		case NoTerm, EveryTerm:
 			*paramStartIdx++
-			searchTerm := (*q).Children[0].Children[1].Data
-			*fieldDescs = append(*fieldDescs, fieldDesc{name, 2, []bool{true, false}, *paramStartIdx})
+			searchTerm := sqlQuoteEscape((*q).Children[0].Children[1].Data)
+			// the subquery needs no join, so items with an empty list are found as well
+			*fieldDescs = append(*fieldDescs, fieldDesc{name, 1, []bool{false}, *paramStartIdx})
 			paramStr := "<P" + strconv.Itoa(*paramStartIdx) + ">"
 			maybeNegated := ""
 			if (*q).Sort == EveryTerm {
@@ -1376,14 +3384,36 @@ func (db *MDB) toSqlSearchTerm(q *Query, table string,
 			return "", Fail("unsupported search modifier %d (version too low?)", int((*q).Sort))
 		}
 
+	case LinkedTerm:
+		if len((*q).Children) != 2 {
+			return "", Fail("ill-formed LINKED clause, expected table and search term")
+		}
+		if (*q).Children[0].Sort != TableString {
+			return "", Fail("first part of a LINKED clause must be the table")
+		}
+		relation := (*q).Data
+		target := (*q).Children[0].Data
+		if !validFieldName.MatchString(relation) {
+			return "", Fail("invalid relation '%s'", relation)
+		}
+		if !validTable.MatchString(target) {
+			return "", Fail("invalid table name '%s'", target)
+		}
+		joins, condition, err := db.toSqlJoinsAndCondition(target, &(*q).Children[1])
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf(`EXISTS (SELECT 1 FROM _LINKS WHERE _LINKS.TableA='%s' AND _LINKS.ItemA=%s.Id AND _LINKS.Relation='%s' AND _LINKS.TableB='%s' AND _LINKS.ItemB IN (SELECT DISTINCT %s.Id FROM %s%s WHERE %s))`,
+			table, table, relation, target, target, target, joins, condition), nil
+
 	case FieldString:
-		if !validFieldName.MatchString((*q).Data) {
+		if !validFieldName.MatchString((*q).Data) && !isTimestampField((*q).Data) {
 			return "", Fail("invalid field name '%s'", (*q).Data)
 		}
 		return (*q).Data, nil
 
 	case QueryString:
-		return fPrintEscape((*q).Data), nil
+		return sqlQuoteEscape(fPrintEscape((*q).Data)), nil
 	default:
 		return "", Fail("unsupported query element %d (version too low?)", int((*q).Sort))
 	}
@@ -1392,8 +3422,26 @@ func (db *MDB) toSqlSearchTerm(q *Query, table string,
 // ToSql returns the sql query for the table, taking into account list fields,
 // or returns an error if the query structure is ill-formed.
 func (db *MDB) ToSql(table string, inquery *Query, limit int64) (string, error) {
+	joins, condition, err := db.toSqlJoinsAndCondition(table, inquery)
+	if err != nil {
+		return "", err
+	}
+	var result string
+	if limit > 0 {
+		result = fmt.Sprintf("SELECT DISTINCT %s.Id FROM %s%s WHERE %s LIMIT %d;",
+			table, table, joins, condition, limit)
+	} else {
+		result = fmt.Sprintf("SELECT DISTINCT %s.Id FROM %s%s WHERE %s;",
+			table, table, joins, condition)
+	}
+	return result, nil
+}
+
+// toSqlJoinsAndCondition returns the joins and the WHERE condition of the sql query for the table.
+// This is used by ToSql and FindWithin to build the final query.
+func (db *MDB) toSqlJoinsAndCondition(table string, inquery *Query) (string, string, error) {
 	if !db.TableExists(table) {
-		return "", Fail("table '%s' does not exist", table)
+		return "", "", tableNotFound(table)
 	}
 	// check if the query is embedded into a search clause
 	// if so, we check against the table name and remove the outer layer
@@ -1401,10 +3449,10 @@ func (db *MDB) ToSql(table string, inquery *Query, limit int64) (string, error)
 	var query *Query
 	if inquery.Sort == SearchClause {
 		if inquery.Data != table {
-			return "", Fail("query with SearchClause for table '%s' requested for table '%s'", inquery.Data, table)
+			return "", "", Fail("query with SearchClause for table '%s' requested for table '%s'", inquery.Data, table)
 		}
 		if len(inquery.Children) != 1 {
-			return "", Fail("query with malformed SearchClause, it should have one child node but contains %d",
+			return "", "", Fail("query with malformed SearchClause, it should have one child node but contains %d",
 				len(inquery.Children))
 		}
 		query = &inquery.Children[0]
@@ -1415,11 +3463,11 @@ func (db *MDB) ToSql(table string, inquery *Query, limit int64) (string, error)
 	c := 0
 	condition, err := db.toSqlSearchTerm(query, table, &fieldDescs, &c)
 	if err != nil {
-		return "", err
+		return "", "", err
 	}
 	for _, field := range fieldDescs {
 		if !db.FieldExists(table, field.name) {
-			return "", Fail("invalid query, %s %s field does not exist", table, field.name)
+			return "", "", Fail("invalid query - %w", fieldNotFound(table, field.name))
 		}
 	}
 	joins := ""
@@ -1439,15 +3487,7 @@ func (db *MDB) ToSql(table string, inquery *Query, limit int64) (string, error)
 			j++
 		}
 	}
-	var result string
-	if limit > 0 {
-		result = fmt.Sprintf("SELECT DISTINCT %s.Id FROM %s%s WHERE %s LIMIT %d;",
-			table, table, joins, condition, limit)
-	} else {
-		result = fmt.Sprintf("SELECT DISTINCT %s.Id FROM %s%s WHERE %s;",
-			table, table, joins, condition)
-	}
-	return result, nil
+	return joins, condition, nil
 }
 
 // Find items matching the query, return error if the query is ill-formed
@@ -1465,11 +3505,49 @@ func (db *MDB) Find(query *Query, limit int64) ([]Item, error) {
 		return result, Fail("invalid query - %s", err)
 	}
 	if !db.TableExists(table) {
-		return result, Fail("invalid query - table '%s' does not exist", table)
+		return result, Fail("invalid query - %w", tableNotFound(table))
 	}
+	db.countRead(table)
 
 	var rows *sql.Rows
-	rows, err = db.base.Query(toExec)
+	rows, err = db.reader.Query(toExec)
+	if err != nil {
+		return result, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var datum sql.NullInt64
+		if err := rows.Scan(&datum); err == nil && datum.Valid {
+			result = append(result, Item(datum.Int64))
+		}
+	}
+	return result, nil
+}
+
+// FindAfter finds at most limit items matching the query like Find, but only items whose ID is
+// larger than after, in ascending order of their IDs. Calling it again with the last item of the
+// result pages through all matching items without holding them in memory at once. A limit of 0
+// or less returns all remaining items.
+func (db *MDB) FindAfter(query *Query, after Item, limit int64) ([]Item, error) {
+	result := make([]Item, 0)
+	table := (*query).Data
+	if len((*query).Children) == 0 {
+		return result, Fail("incomplete query, only table given")
+	}
+	if !db.TableExists(table) {
+		return result, Fail("invalid query - %w", tableNotFound(table))
+	}
+	joins, condition, err := db.toSqlJoinsAndCondition(table, &query.Children[0])
+	if err != nil {
+		return result, Fail("invalid query - %s", err)
+	}
+	db.countRead(table)
+	toExec := fmt.Sprintf("SELECT DISTINCT %s.Id FROM %s%s WHERE (%s) AND %s.Id > ? ORDER BY %s.Id",
+		table, table, joins, condition, table, table)
+	if limit > 0 {
+		toExec += fmt.Sprintf(" LIMIT %d", limit)
+	}
+	rows, err := db.reader.Query(toExec+";", after)
 	if err != nil {
 		return result, err
 	}
@@ -1480,5 +3558,80 @@ func (db *MDB) Find(query *Query, limit int64) ([]Item, error) {
 			result = append(result, Item(datum.Int64))
 		}
 	}
+	return result, rows.Err()
+}
+
+// CountQuery returns the number of items matching the query without returning the items.
+func (db *MDB) CountQuery(query *Query) (int64, error) {
+	table := (*query).Data
+	if len((*query).Children) == 0 {
+		return 0, Fail("incomplete query, only table given")
+	}
+	if !db.TableExists(table) {
+		return 0, Fail("invalid query - %w", tableNotFound(table))
+	}
+	joins, condition, err := db.toSqlJoinsAndCondition(table, &query.Children[0])
+	if err != nil {
+		return 0, Fail("invalid query - %s", err)
+	}
+	db.countRead(table)
+	var result int64
+	err = db.reader.QueryRow(fmt.Sprintf("SELECT COUNT(DISTINCT %s.Id) FROM %s%s WHERE (%s);",
+		table, table, joins, condition)).Scan(&result)
+	return result, err
+}
+
+// findChunkSize is the maximum number of items that are put into one "Id IN (...)" clause
+// by FindWithin. It stays well below the default host parameter limit of Sqlite.
+const findChunkSize = 500
+
+// FindWithin finds items matching the query like Find, but only among the given items.
+// This can be used to constrain a query by the result of a previous query without having to
+// intersect the results manually. The items are passed to the database in chunks, so large
+// item sets are handled efficiently. A limit of 0 or less means no limit.
+func (db *MDB) FindWithin(query *Query, items []Item, limit int64) ([]Item, error) {
+	result := make([]Item, 0)
+	table := (*query).Data
+	if len((*query).Children) == 0 {
+		return result, Fail("incomplete query, only table given")
+	}
+	if !db.TableExists(table) {
+		return result, Fail("invalid query - %w", tableNotFound(table))
+	}
+	db.countRead(table)
+	joins, condition, err := db.toSqlJoinsAndCondition(table, &query.Children[0])
+	if err != nil {
+		return result, Fail("invalid query - %s", err)
+	}
+	for start := 0; start < len(items); start += findChunkSize {
+		end := start + findChunkSize
+		if end > len(items) {
+			end = len(items)
+		}
+		chunk := items[start:end]
+		args := make([]interface{}, len(chunk))
+		for i := range chunk {
+			args[i] = chunk[i]
+		}
+		toExec := fmt.Sprintf("SELECT DISTINCT %s.Id FROM %s%s WHERE (%s) AND %s.Id IN (?%s)",
+			table, table, joins, condition, table, strings.Repeat(",?", len(chunk)-1))
+		if limit > 0 {
+			toExec += fmt.Sprintf(" LIMIT %d", limit-int64(len(result)))
+		}
+		rows, err := db.reader.Query(toExec+";", args...)
+		if err != nil {
+			return result, err
+		}
+		for rows.Next() {
+			var datum sql.NullInt64
+			if err := rows.Scan(&datum); err == nil && datum.Valid {
+				result = append(result, Item(datum.Int64))
+			}
+		}
+		rows.Close()
+		if limit > 0 && int64(len(result)) >= limit {
+			break
+		}
+	}
 	return result, nil
 }
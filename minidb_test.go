@@ -2,11 +2,16 @@ package minidb
 
 import (
 	"bytes"
+	"context"
+	"database/sql"
 	"encoding/base64"
 	"fmt"
 	"io/ioutil"
 	"math/rand"
 	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
 	"testing"
 	"time"
 )
@@ -165,11 +170,11 @@ func TestParseFieldDesc(t *testing.T) {
 		in  []string
 		out []Field
 	}{
-		{[]string{"int", "Age"}, []Field{Field{"Age", DBInt}}},
+		{[]string{"int", "Age"}, []Field{Field{Name: "Age", Sort: DBInt}}},
 		{[]string{"string", "Name", "string-list", "Address"},
-			[]Field{Field{"Name", DBString}, Field{"Address", DBStringList}}},
-		{[]string{"date-list", "Meetings"}, []Field{Field{"Meetings", DBDateList}}},
-		{[]string{"blob", "foo"}, []Field{Field{"foo", DBBlob}}},
+			[]Field{Field{Name: "Name", Sort: DBString}, Field{Name: "Address", Sort: DBStringList}}},
+		{[]string{"date-list", "Meetings"}, []Field{Field{Name: "Meetings", Sort: DBDateList}}},
+		{[]string{"blob", "foo"}, []Field{Field{Name: "foo", Sort: DBBlob}}},
 	}
 	for _, table := range tables {
 		result, err := ParseFieldDesc(table.in)
@@ -184,6 +189,32 @@ func TestParseFieldDesc(t *testing.T) {
 	}
 }
 
+func TestParseFieldDescConstraints(t *testing.T) {
+	fields, err := ParseFieldDesc([]string{"int", "Age", "required", "default", "0", "string-list", "Tags",
+		"default", "new", "string", "Name"})
+	if err != nil {
+		t.Errorf("ParseFieldDesc() failed: %s", err)
+	}
+	if len(fields) != 3 {
+		t.Fatalf("ParseFieldDesc() returned %d fields, expected 3", len(fields))
+	}
+	if !fields[0].Required || fields[0].Default == nil || fields[0].Default.Int() != 0 {
+		t.Errorf("ParseFieldDesc() failed to parse required field with default")
+	}
+	if fields[1].Required || fields[1].Default == nil || fields[1].Default.String() != "new" {
+		t.Errorf("ParseFieldDesc() failed to parse list field with default")
+	}
+	if fields[2].Required || fields[2].Default != nil {
+		t.Errorf("ParseFieldDesc() added constraints to a plain field")
+	}
+	if _, err := ParseFieldDesc([]string{"int", "Age", "default", "none"}); err == nil {
+		t.Errorf("ParseFieldDesc() should fail on default value of the wrong type")
+	}
+	if _, err := ParseFieldDesc([]string{"int", "Age", "default"}); err == nil {
+		t.Errorf("ParseFieldDesc() should fail on missing default value")
+	}
+}
+
 func TestMDB(t *testing.T) {
 	var tx *Tx
 	db, err := Open("sqlite3", tmpfile.Name())
@@ -192,14 +223,14 @@ func TestMDB(t *testing.T) {
 	}
 	// test code here
 	err = db.AddTable("test", []Field{
-		Field{"Name", DBStringList},
-		Field{"Email", DBString},
-		Field{"Age", DBInt},
-		Field{"Scores", DBIntList},
-		Field{"Modified", DBDate},
-		Field{"Misc", DBBlob},
-		Field{"Data", DBBlobList},
-		Field{"Schedules", DBDateList},
+		Field{Name: "Name", Sort: DBStringList},
+		Field{Name: "Email", Sort: DBString},
+		Field{Name: "Age", Sort: DBInt},
+		Field{Name: "Scores", Sort: DBIntList},
+		Field{Name: "Modified", Sort: DBDate},
+		Field{Name: "Misc", Sort: DBBlob},
+		Field{Name: "Data", Sort: DBBlobList},
+		Field{Name: "Schedules", Sort: DBDateList},
 	})
 	if err != nil {
 		t.Errorf("AddTable() failed: %s", err)
@@ -1012,6 +1043,797 @@ func TestFind(t *testing.T) {
 	db.Close()
 }
 
+func TestFindWithin(t *testing.T) {
+	tmp, _ := ioutil.TempFile("", "minidb-findwithin-testing-*")
+	defer os.Remove(tmp.Name())
+	db, err := Open("sqlite3", tmp.Name())
+	if err != nil {
+		t.Errorf("Open() failed: %s", err)
+	}
+	defer db.Close()
+	err = db.AddTable("Person", []Field{Field{Name: "Name", Sort: DBStringList}, Field{Name: "Age", Sort: DBInt}})
+	if err != nil {
+		t.Errorf("AddTable() failed: %s", err)
+	}
+	const maxtest = 1200
+	for i := 0; i < maxtest; i++ {
+		if _, err := db.UseItem("Person", uint64(i+1)); err != nil {
+			t.Errorf("UseItem() failed: %s", err)
+		}
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		t.Errorf("Begin() failed: %s", err)
+	}
+	for i := 0; i < maxtest; i++ {
+		item := Item(i + 1)
+		if err := tx.Set("Person", item, "Age", []Value{NewInt(int64(i % 10))}); err != nil {
+			t.Errorf("Set() failed: %s", err)
+		}
+		if err := tx.Set("Person", item, "Name", []Value{NewString("John"), NewString(fmt.Sprintf("N%d", i%2))}); err != nil {
+			t.Errorf("Set() failed: %s", err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		t.Errorf("Commit() failed: %s", err)
+	}
+	q, err := ParseQuery("Person Age=3")
+	if err != nil {
+		t.Errorf("ParseQuery() failed: %s", err)
+	}
+	within, err := db.Find(q, 0)
+	if err != nil {
+		t.Errorf("Find() failed: %s", err)
+	}
+	if len(within) != maxtest/10 {
+		t.Errorf("Find() returned %d items, expected %d", len(within), maxtest/10)
+	}
+	q, err = ParseQuery("Person Name=N1")
+	if err != nil {
+		t.Errorf("ParseQuery() failed: %s", err)
+	}
+	results, err := db.FindWithin(q, within, 0)
+	if err != nil {
+		t.Errorf("FindWithin() failed: %s", err)
+	}
+	if len(results) != maxtest/10 {
+		t.Errorf("FindWithin() returned %d items, expected %d", len(results), maxtest/10)
+	}
+	q, err = ParseQuery("Person Age=%")
+	if err != nil {
+		t.Errorf("ParseQuery() failed: %s", err)
+	}
+	all, err := db.ListItems("Person", 0)
+	if err != nil {
+		t.Errorf("ListItems() failed: %s", err)
+	}
+	results, err = db.FindWithin(q, all, 0)
+	if err != nil {
+		t.Errorf("FindWithin() failed for several chunks: %s", err)
+	}
+	if len(results) != maxtest {
+		t.Errorf("FindWithin() returned %d items for several chunks, expected %d", len(results), maxtest)
+	}
+	results, err = db.FindWithin(q, all, 700)
+	if err != nil {
+		t.Errorf("FindWithin() failed with limit: %s", err)
+	}
+	if len(results) != 700 {
+		t.Errorf("FindWithin() returned %d items with limit 700", len(results))
+	}
+	results, err = db.FindWithin(q, []Item{}, 0)
+	if err != nil || len(results) != 0 {
+		t.Errorf("FindWithin() should return no items for an empty item set")
+	}
+}
+
+func TestRenameDropTable(t *testing.T) {
+	tmp, _ := ioutil.TempFile("", "minidb-rename-testing-*")
+	defer os.Remove(tmp.Name())
+	db, err := Open("sqlite3", tmp.Name())
+	if err != nil {
+		t.Errorf("Open() failed: %s", err)
+	}
+	defer db.Close()
+	err = db.AddTable("Person", []Field{Field{Name: "Name", Sort: DBStringList}, Field{Name: "Age", Sort: DBInt}})
+	if err != nil {
+		t.Errorf("AddTable() failed: %s", err)
+	}
+	item, err := db.NewItem("Person")
+	if err != nil {
+		t.Errorf("NewItem() failed: %s", err)
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		t.Errorf("Begin() failed: %s", err)
+	}
+	if err := tx.Set("Person", item, "Name", []Value{NewString("John"), NewString("Smith")}); err != nil {
+		t.Errorf("Set() failed: %s", err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Errorf("Commit() failed: %s", err)
+	}
+	tx, err = db.Begin()
+	if err != nil {
+		t.Errorf("Begin() failed: %s", err)
+	}
+	if err := tx.RenameTable("Person", "Human"); err != nil {
+		t.Errorf("RenameTable() failed: %s", err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Errorf("Commit() failed: %s", err)
+	}
+	if db.TableExists("Person") || db.IsListField("Person", "Name") {
+		t.Errorf("RenameTable() left the old table in place")
+	}
+	if !db.TableExists("Human") || !db.IsListField("Human", "Name") || !db.FieldExists("Human", "Age") {
+		t.Errorf("RenameTable() did not create the new table properly")
+	}
+	values, err := db.Get("Human", item, "Name")
+	if err != nil || len(values) != 2 || values[1].String() != "Smith" {
+		t.Errorf("Get() after RenameTable() returned wrong values: %v", err)
+	}
+	tx, err = db.Begin()
+	if err != nil {
+		t.Errorf("Begin() failed: %s", err)
+	}
+	if err := tx.RenameTable("Human", "Human"); err == nil {
+		t.Errorf("RenameTable() should fail for an existing table name")
+	}
+	if err := tx.DropTable("Human"); err != nil {
+		t.Errorf("DropTable() failed: %s", err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Errorf("Commit() failed: %s", err)
+	}
+	if db.TableExists("Human") || db.IsListField("Human", "Name") || db.FieldExists("Human", "Age") {
+		t.Errorf("DropTable() did not remove the table properly")
+	}
+	if len(db.GetTables()) != 0 {
+		t.Errorf("GetTables() returned tables after DropTable()")
+	}
+}
+
+func TestReindex(t *testing.T) {
+	tmp, _ := ioutil.TempFile("", "minidb-reindex-testing-*")
+	defer os.Remove(tmp.Name())
+	db, err := Open("sqlite3", tmp.Name())
+	if err != nil {
+		t.Errorf("Open() failed: %s", err)
+	}
+	defer db.Close()
+	err = db.AddTable("Person", []Field{Field{Name: "Name", Sort: DBString}, Field{Name: "Tags", Sort: DBStringList}})
+	if err != nil {
+		t.Errorf("AddTable() failed: %s", err)
+	}
+	item, _ := db.NewItem("Person")
+	tx, err := db.Begin()
+	if err != nil {
+		t.Errorf("Begin() failed: %s", err)
+	}
+	tx.Set("Person", item, "Name", []Value{NewString("John")})
+	tx.Set("Person", item, "Tags", []Value{NewString("friend")})
+	if err := tx.Index("Person", "Name"); err != nil {
+		t.Errorf("Index() failed: %s", err)
+	}
+	if err := tx.Index("Person", "Tags"); err != nil {
+		t.Errorf("Index() failed: %s", err)
+	}
+	if err := tx.Reindex("Person"); err != nil {
+		t.Errorf("Reindex() failed: %s", err)
+	}
+	if err := tx.Reindex("Nobody"); err == nil {
+		t.Errorf("Reindex() should fail for nonexistent table")
+	}
+	if err := tx.Commit(); err != nil {
+		t.Errorf("Commit() failed: %s", err)
+	}
+	var n int
+	err = db.Base().QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE type='index' AND name IN (?,?);`,
+		indexName("Person", "Name"), indexName(listFieldToTableName("Person", "Tags"), "Tags")).Scan(&n)
+	if err != nil || n != 2 {
+		t.Errorf("Reindex() did not recreate the indices, found %d: %v", n, err)
+	}
+	q, _ := ParseQuery("Person Tags=fr%")
+	items, err := db.Find(q, 0)
+	if err != nil || len(items) != 1 {
+		t.Errorf("Find() after Reindex() returned %v, %v", items, err)
+	}
+}
+
+func TestChangeFieldType(t *testing.T) {
+	tmp, _ := ioutil.TempFile("", "minidb-fieldtype-testing-*")
+	defer os.Remove(tmp.Name())
+	db, err := Open("sqlite3", tmp.Name())
+	if err != nil {
+		t.Errorf("Open() failed: %s", err)
+	}
+	defer db.Close()
+	err = db.AddTable("Person", []Field{Field{Name: "Age", Sort: DBString}, Field{Name: "Dates", Sort: DBStringList}})
+	if err != nil {
+		t.Errorf("AddTable() failed: %s", err)
+	}
+	item1, _ := db.NewItem("Person")
+	item2, _ := db.NewItem("Person")
+	tx, err := db.Begin()
+	if err != nil {
+		t.Errorf("Begin() failed: %s", err)
+	}
+	tx.Set("Person", item1, "Age", []Value{NewString("42")})
+	tx.Set("Person", item2, "Age", []Value{NewString("forty-two")})
+	tx.Set("Person", item1, "Dates", []Value{NewString("2019-01-01T10:00:00Z"), NewString("yesterday")})
+	tx.Set("Person", item2, "Dates", []Value{NewString("2019-03-01T10:00:00Z")})
+	if err := tx.Index("Person", "Age"); err != nil {
+		t.Errorf("Index() failed: %s", err)
+	}
+	failed, err := tx.ChangeFieldType("Person", "Age", DBInt)
+	if err != nil {
+		t.Errorf("ChangeFieldType() failed: %s", err)
+	}
+	if len(failed) != 1 || failed[0] != item2 {
+		t.Errorf("ChangeFieldType() should report item %d as failed, given %v", item2, failed)
+	}
+	failed, err = tx.ChangeFieldType("Person", "Dates", DBDateList)
+	if err != nil {
+		t.Errorf("ChangeFieldType() failed for list field: %s", err)
+	}
+	if len(failed) != 1 || failed[0] != item1 {
+		t.Errorf("ChangeFieldType() should report item %d as failed, given %v", item1, failed)
+	}
+	if _, err := tx.ChangeFieldType("Person", "Dates", DBInt); err == nil {
+		t.Errorf("ChangeFieldType() should not allow changing a list field to a single field")
+	}
+	if err := tx.Commit(); err != nil {
+		t.Errorf("Commit() failed: %s", err)
+	}
+	if db.MustGetFieldType("Person", "Age") != DBInt {
+		t.Errorf("ChangeFieldType() did not update the field type")
+	}
+	values, err := db.Get("Person", item1, "Age")
+	if err != nil || len(values) != 1 || values[0].Int() != 42 {
+		t.Errorf("ChangeFieldType() did not convert the value correctly: %v", err)
+	}
+	if values, err := db.Get("Person", item2, "Age"); err != nil || len(values) != 1 || !values[0].IsNull() {
+		t.Errorf("ChangeFieldType() should have set an unconvertible value to NULL")
+	}
+	values, err = db.Get("Person", item1, "Dates")
+	if err != nil || len(values) != 1 || values[0].Sort != DBDate {
+		t.Errorf("ChangeFieldType() did not convert the list correctly: %v", err)
+	}
+	q, _ := ParseQuery("Person Age=42")
+	items, err := db.Find(q, 0)
+	if err != nil || len(items) != 1 || items[0] != item1 {
+		t.Errorf("Find() after ChangeFieldType() failed: %v", err)
+	}
+}
+
+func TestRequiredAndDefault(t *testing.T) {
+	tmp, _ := ioutil.TempFile("", "minidb-constraints-testing-*")
+	defer os.Remove(tmp.Name())
+	db, err := Open("sqlite3", tmp.Name())
+	if err != nil {
+		t.Errorf("Open() failed: %s", err)
+	}
+	defer db.Close()
+	if err := db.AddTable("Broken", []Field{Field{Name: "Age", Sort: DBInt, Required: true}}); err == nil {
+		t.Errorf("AddTable() should fail for a required field without default")
+	}
+	wrong := NewString("zero")
+	if err := db.AddTable("Broken", []Field{Field{Name: "Age", Sort: DBInt, Default: &wrong}}); err == nil {
+		t.Errorf("AddTable() should fail for a default value of the wrong type")
+	}
+	zero := NewInt(0)
+	tag := NewString("new")
+	err = db.AddTable("Person", []Field{
+		Field{Name: "Age", Sort: DBInt, Required: true, Default: &zero},
+		Field{Name: "Tags", Sort: DBStringList, Required: true, Default: &tag},
+		Field{Name: "Name", Sort: DBString},
+	})
+	if err != nil {
+		t.Errorf("AddTable() failed: %s", err)
+	}
+	fields, err := db.GetFields("Person")
+	if err != nil || len(fields) != 3 {
+		t.Fatalf("GetFields() failed: %v", err)
+	}
+	for _, field := range fields {
+		switch field.Name {
+		case "Age":
+			if !field.Required || field.Default == nil || field.Default.Int() != 0 {
+				t.Errorf("GetFields() did not return the constraints of Age")
+			}
+		case "Tags":
+			if !field.Required || field.Default == nil || field.Default.String() != "new" {
+				t.Errorf("GetFields() did not return the constraints of Tags")
+			}
+		case "Name":
+			if field.Required || field.Default != nil {
+				t.Errorf("GetFields() returned constraints for Name")
+			}
+		}
+	}
+	item, err := db.NewItem("Person")
+	if err != nil {
+		t.Errorf("NewItem() failed: %s", err)
+	}
+	values, err := db.Get("Person", item, "Age")
+	if err != nil || len(values) != 1 || values[0].Int() != 0 {
+		t.Errorf("NewItem() did not set the default value of Age: %v", err)
+	}
+	values, err = db.Get("Person", item, "Tags")
+	if err != nil || len(values) != 1 || values[0].String() != "new" {
+		t.Errorf("NewItem() did not set the default value of Tags: %v", err)
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		t.Errorf("Begin() failed: %s", err)
+	}
+	if err := tx.Set("Person", item, "Tags", []Value{}); err == nil {
+		t.Errorf("Set() should fail for an empty required field")
+	}
+	if err := tx.Set("Person", item, "Age", []Value{NewInt(42)}); err != nil {
+		t.Errorf("Set() failed: %s", err)
+	}
+	if _, err := tx.ChangeFieldType("Person", "Age", DBString); err != nil {
+		t.Errorf("ChangeFieldType() failed on field with default: %s", err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Errorf("Commit() failed: %s", err)
+	}
+	field, err := db.getField("Person", "Age")
+	if err != nil || field.Default == nil || field.Default.Sort != DBString || field.Default.String() != "0" {
+		t.Errorf("ChangeFieldType() did not convert the default value: %v", err)
+	}
+}
+
+func TestGetOrEmpty(t *testing.T) {
+	tmp, _ := ioutil.TempFile("", "minidb-getorempty-testing-*")
+	defer os.Remove(tmp.Name())
+	db, err := Open("sqlite3", tmp.Name())
+	if err != nil {
+		t.Errorf("Open() failed: %s", err)
+	}
+	defer db.Close()
+	if err := db.AddTable("Person", []Field{Field{Name: "Name", Sort: DBString}, Field{Name: "Tags", Sort: DBStringList}}); err != nil {
+		t.Errorf("AddTable() failed: %s", err)
+	}
+	item, _ := db.NewItem("Person")
+	for _, field := range []string{"Name", "Tags"} {
+		values, err := db.GetOrEmpty("Person", item, field)
+		if err != nil || values == nil || len(values) != 0 {
+			t.Errorf("GetOrEmpty() returned %v, %v for absent %s, expected an empty slice", values, err, field)
+		}
+	}
+	if values, err := db.Get("Person", item, "Name"); err != nil || len(values) != 1 || !values[0].IsNull() {
+		t.Errorf("Get() returned %v, %v for a NULL field, expected a NULL value", values, err)
+	}
+	db.SetEmptyForAbsent(true)
+	values, err := db.Get("Person", item, "Name")
+	if err != nil || len(values) != 0 {
+		t.Errorf("Get() returned %v, %v for a NULL field with SetEmptyForAbsent(true)", values, err)
+	}
+	if _, err := db.Get("Person", item, "Age"); err == nil {
+		t.Errorf("Get() should fail for an unknown field with SetEmptyForAbsent(true)")
+	}
+	if _, err := db.Get("Person", item+1, "Name"); err == nil {
+		t.Errorf("Get() should fail for a nonexistent item with SetEmptyForAbsent(true)")
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		t.Errorf("Begin() failed: %s", err)
+	}
+	tx.Set("Person", item, "Name", []Value{NewString("John")})
+	tx.Commit()
+	values, err = db.GetOrEmpty("Person", item, "Name")
+	if err != nil || len(values) != 1 || values[0].String() != "John" {
+		t.Errorf("GetOrEmpty() returned %v, %v, expected John", values, err)
+	}
+}
+
+func TestGetItem(t *testing.T) {
+	tmp, _ := ioutil.TempFile("", "minidb-getitem-testing-*")
+	defer os.Remove(tmp.Name())
+	db, err := Open("sqlite3", tmp.Name())
+	if err != nil {
+		t.Errorf("Open() failed: %s", err)
+	}
+	defer db.Close()
+	fields := []Field{Field{Name: "Name", Sort: DBString}, Field{Name: "Age", Sort: DBInt},
+		Field{Name: "Photo", Sort: DBBlob}, Field{Name: "Born", Sort: DBDate},
+		Field{Name: "Tags", Sort: DBStringList}, Field{Name: "Scores", Sort: DBIntList},
+		Field{Name: "Visits", Sort: DBDateList}}
+	if err := db.AddTable("Person", fields); err != nil {
+		t.Errorf("AddTable() failed: %s", err)
+	}
+	item, _ := db.NewItem("Person")
+	tx, err := db.Begin()
+	if err != nil {
+		t.Errorf("Begin() failed: %s", err)
+	}
+	tx.Set("Person", item, "Name", []Value{NewString("John")})
+	tx.Set("Person", item, "Age", []Value{NewInt(42)})
+	tx.Set("Person", item, "Photo", []Value{NewBytes([]byte{0, 1, 2})})
+	tx.Set("Person", item, "Born", []Value{NewDateStr("1970-01-02T03:04:05Z")})
+	tx.Set("Person", item, "Tags", []Value{NewString("b"), NewString("a")})
+	tx.Set("Person", item, "Scores", []Value{NewInt(3), NewInt(1), NewInt(2)})
+	if err := tx.Commit(); err != nil {
+		t.Errorf("Commit() failed: %s", err)
+	}
+	values, err := db.GetItem("Person", item)
+	if err != nil {
+		t.Errorf("GetItem() failed: %s", err)
+	}
+	if len(values) != len(fields) {
+		t.Errorf("GetItem() returned %d fields, expected %d", len(values), len(fields))
+	}
+	for _, field := range fields {
+		expected, err := db.GetOrEmpty("Person", item, field.Name)
+		if err != nil {
+			t.Errorf("GetOrEmpty() failed: %s", err)
+		}
+		if !reflect.DeepEqual(values[field.Name], expected) {
+			t.Errorf("GetItem() returned %v for %s, expected %v", values[field.Name], field.Name, expected)
+		}
+	}
+	if _, err := db.GetItem("Person", item+1); err == nil {
+		t.Errorf("GetItem() should fail for a nonexistent item")
+	}
+	if _, err := db.GetItem("Nobody", item); err == nil {
+		t.Errorf("GetItem() should fail for a nonexistent table")
+	}
+}
+
+func TestSetItem(t *testing.T) {
+	tmp, _ := ioutil.TempFile("", "minidb-setitem-testing-*")
+	defer os.Remove(tmp.Name())
+	db, err := Open("sqlite3", tmp.Name())
+	if err != nil {
+		t.Errorf("Open() failed: %s", err)
+	}
+	defer db.Close()
+	if err := db.AddTable("Person", []Field{Field{Name: "Name", Sort: DBString}, Field{Name: "Age", Sort: DBInt},
+		Field{Name: "Tags", Sort: DBStringList}}); err != nil {
+		t.Errorf("AddTable() failed: %s", err)
+	}
+	item, _ := db.NewItem("Person")
+	tx, err := db.Begin()
+	if err != nil {
+		t.Errorf("Begin() failed: %s", err)
+	}
+	if err := tx.EnableHistory("Person", 0); err != nil {
+		t.Errorf("EnableHistory() failed: %s", err)
+	}
+	err = tx.SetItem("Person", item, map[string][]Value{
+		"Name": []Value{NewString("John")},
+		"Age":  []Value{NewInt(42)},
+		"Tags": []Value{NewString("a"), NewString("b")},
+	})
+	if err != nil {
+		t.Errorf("SetItem() failed: %s", err)
+	}
+	err = tx.SetItem("Person", item, map[string][]Value{
+		"Name": []Value{NewString("Bob")},
+		"Age":  []Value{NewString("old")},
+	})
+	if err == nil {
+		t.Errorf("SetItem() should fail for a type error")
+	}
+	err = tx.SetItem("Person", item, map[string][]Value{
+		"Name":   []Value{NewString("Bob")},
+		"Height": []Value{NewInt(180)},
+	})
+	if err == nil {
+		t.Errorf("SetItem() should fail for an unknown field")
+	}
+	if err := tx.Commit(); err != nil {
+		t.Errorf("Commit() failed: %s", err)
+	}
+	values, err := db.GetItem("Person", item)
+	if err != nil {
+		t.Errorf("GetItem() failed: %s", err)
+	}
+	if values["Name"][0].String() != "John" || values["Age"][0].Int() != 42 || len(values["Tags"]) != 2 {
+		t.Errorf("SetItem() stored wrong values: %v", values)
+	}
+	if history, _ := db.HistoryOf("Person", item, "Tags"); len(history) != 1 {
+		t.Errorf("SetItem() did not record the changes")
+	}
+}
+
+func TestCloneItem(t *testing.T) {
+	tmp, _ := ioutil.TempFile("", "minidb-testing-*")
+	defer os.Remove(tmp.Name())
+	db, err := Open("sqlite3", tmp.Name())
+	if err != nil {
+		t.Errorf("Open() failed: %s", err)
+	}
+	defer db.Close()
+	if err := db.AddTable("Person", []Field{Field{Name: "Name", Sort: DBString}, Field{Name: "Age", Sort: DBInt},
+		Field{Name: "Tags", Sort: DBStringList}, Field{Name: "Notes", Sort: DBStringList}}); err != nil {
+		t.Errorf("AddTable() failed: %s", err)
+	}
+	if err := db.AddTable("Empty", []Field{Field{Name: "Tags", Sort: DBStringList}}); err != nil {
+		t.Errorf("AddTable() failed: %s", err)
+	}
+	john, _ := db.NewItem("Person")
+	empty, _ := db.NewItem("Empty")
+	tx, _ := db.Begin()
+	defer tx.Rollback()
+	tx.SetItem("Person", john, map[string][]Value{"Name": []Value{NewString("John")}, "Age": []Value{NewInt(30)},
+		"Tags": []Value{NewString("b"), NewString("a"), NewString("c")}})
+	clone, err := tx.CloneItem("Person", john)
+	if err != nil || clone == john {
+		t.Errorf("CloneItem() returned %d, %v", clone, err)
+	}
+	original, _ := tx.View().GetItem("Person", john)
+	copied, err := tx.View().GetItem("Person", clone)
+	if err != nil || !reflect.DeepEqual(original, copied) {
+		t.Errorf("CloneItem() did not copy all fields in order:\n%v\n%v", original, copied)
+	}
+	tx.Set("Person", clone, "Name", []Value{NewString("Anna")})
+	if values, _ := tx.View().Get("Person", john, "Name"); values[0].String() != "John" {
+		t.Errorf("changing a clone changed the original item")
+	}
+	if _, err := tx.CloneItem("Empty", empty); err != nil {
+		t.Errorf("CloneItem() failed for a table without single fields: %s", err)
+	}
+	if _, err := tx.CloneItem("Person", 999); err == nil {
+		t.Errorf("CloneItem() should fail for a nonexistent item")
+	}
+}
+
+func TestRemoveItems(t *testing.T) {
+	tmp, _ := ioutil.TempFile("", "minidb-testing-*")
+	defer os.Remove(tmp.Name())
+	db, err := Open("sqlite3", tmp.Name())
+	if err != nil {
+		t.Errorf("Open() failed: %s", err)
+	}
+	defer db.Close()
+	if err := db.AddTable("Person", []Field{Field{Name: "Name", Sort: DBString}, Field{Name: "Tags", Sort: DBStringList}}); err != nil {
+		t.Errorf("AddTable() failed: %s", err)
+	}
+	tx, _ := db.Begin()
+	rows := make([][]FieldValue, 2000)
+	for i := range rows {
+		rows[i] = []FieldValue{{Field: "Tags", Values: []Value{NewString("a")}}}
+	}
+	items, err := tx.NewItems("Person", rows)
+	if err != nil {
+		t.Errorf("NewItems() failed: %s", err)
+	}
+	tx.Link("Person", items[0], "Person", items[1999], "knows")
+	tx.Set("Person", items[1], "Name", []Value{NewString("John")})
+	if err := tx.RemoveItems("Person", append(append([]Item{}, items[:1999]...), 99999)); err != nil {
+		t.Errorf("RemoveItems() failed: %s", err)
+	}
+	tx.Commit()
+	if n, _ := db.Count("Person"); n != 1 {
+		t.Errorf("RemoveItems() left %d items, expected 1", n)
+	}
+	for _, internal := range []string{"_Person_Tags", "_LINKS", "_HISTORY"} {
+		var n int
+		db.base.QueryRow(fmt.Sprintf(`SELECT COUNT(*) FROM %s;`, internal)).Scan(&n)
+		expected := 0
+		if internal == "_Person_Tags" {
+			expected = 1
+		}
+		if n != expected {
+			t.Errorf("RemoveItems() left %d rows in %s, expected %d", n, internal, expected)
+		}
+	}
+	tx, _ = db.Begin()
+	defer tx.Rollback()
+	if err := tx.RemoveItems("Nobody", items); err == nil {
+		t.Errorf("RemoveItems() should fail for a nonexistent table")
+	}
+}
+
+func TestGetMulti(t *testing.T) {
+	tmp, _ := ioutil.TempFile("", "minidb-getmulti-testing-*")
+	defer os.Remove(tmp.Name())
+	db, err := Open("sqlite3", tmp.Name())
+	if err != nil {
+		t.Errorf("Open() failed: %s", err)
+	}
+	defer db.Close()
+	if err := db.AddTable("Person", []Field{Field{Name: "Name", Sort: DBString},
+		Field{Name: "Tags", Sort: DBStringList}}); err != nil {
+		t.Errorf("AddTable() failed: %s", err)
+	}
+	items := make([]Item, 1000)
+	for i := range items {
+		items[i], _ = db.NewItem("Person")
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		t.Errorf("Begin() failed: %s", err)
+	}
+	for i, item := range items[:len(items)-1] {
+		tx.Set("Person", item, "Name", []Value{NewString(fmt.Sprintf("P%d", i))})
+		tx.Set("Person", item, "Tags", []Value{NewString("a"), NewString(fmt.Sprintf("t%d", i))})
+	}
+	if err := tx.Commit(); err != nil {
+		t.Errorf("Commit() failed: %s", err)
+	}
+	last := items[len(items)-1]
+	names, err := db.GetMulti("Person", append(items, last+1), "Name")
+	if err != nil {
+		t.Errorf("GetMulti() failed: %s", err)
+	}
+	if len(names) != len(items) || names[items[500]][0].String() != "P500" || len(names[last]) != 0 {
+		t.Errorf("GetMulti() returned wrong values for single field")
+	}
+	tags, err := db.GetMulti("Person", items, "Tags")
+	if err != nil {
+		t.Errorf("GetMulti() failed: %s", err)
+	}
+	if len(tags) != len(items) || len(tags[items[950]]) != 2 || tags[items[950]][1].String() != "t950" ||
+		len(tags[last]) != 0 {
+		t.Errorf("GetMulti() returned wrong values for list field")
+	}
+	if _, err := db.GetMulti("Person", items, "Age"); err == nil {
+		t.Errorf("GetMulti() should fail for nonexistent field")
+	}
+}
+
+func TestGetDates(t *testing.T) {
+	tmp, _ := ioutil.TempFile("", "minidb-getdates-testing-*")
+	defer os.Remove(tmp.Name())
+	db, err := Open("sqlite3", tmp.Name())
+	if err != nil {
+		t.Errorf("Open() failed: %s", err)
+	}
+	defer db.Close()
+	if err := db.AddTable("Person", []Field{Field{Name: "Born", Sort: DBDate},
+		Field{Name: "Visits", Sort: DBDateList}}); err != nil {
+		t.Errorf("AddTable() failed: %s", err)
+	}
+	born := time.Date(1970, 1, 2, 3, 4, 5, 0, time.UTC)
+	visit := time.Date(2020, 6, 7, 8, 9, 10, 0, time.UTC)
+	item, _ := db.NewItem("Person")
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatalf("Begin() failed: %s", err)
+	}
+	tx.Set("Person", item, "Born", []Value{NewDate(born)})
+	tx.Set("Person", item, "Visits", []Value{NewDate(visit)})
+	if err := tx.Commit(); err != nil {
+		t.Errorf("Commit() failed: %s", err)
+	}
+	values, err := db.GetItem("Person", item)
+	if err != nil {
+		t.Fatalf("GetItem() failed: %s", err)
+	}
+	if len(values["Born"]) != 1 || !values["Born"][0].Datetime().Equal(born) {
+		t.Errorf("GetItem() returned %v for a date, expected %s", values["Born"], born)
+	}
+	if len(values["Visits"]) != 1 || !values["Visits"][0].Datetime().Equal(visit) {
+		t.Errorf("GetItem() returned %v for a date list, expected %s", values["Visits"], visit)
+	}
+	dates, err := db.GetMulti("Person", []Item{item}, "Born")
+	if err != nil {
+		t.Fatalf("GetMulti() failed: %s", err)
+	}
+	if len(dates[item]) != 1 || !dates[item][0].Datetime().Equal(born) {
+		t.Errorf("GetMulti() returned %v for a date, expected %s", dates[item], born)
+	}
+	dates, err = db.GetMulti("Person", []Item{item}, "Visits")
+	if err != nil {
+		t.Fatalf("GetMulti() failed: %s", err)
+	}
+	if len(dates[item]) != 1 || !dates[item][0].Datetime().Equal(visit) {
+		t.Errorf("GetMulti() returned %v for a date list, expected %s", dates[item], visit)
+	}
+}
+
+func TestValueScanValuer(t *testing.T) {
+	tmp, _ := ioutil.TempFile("", "minidb-valuer-testing-*")
+	defer os.Remove(tmp.Name())
+	db, err := Open("sqlite3", tmp.Name())
+	if err != nil {
+		t.Errorf("Open() failed: %s", err)
+	}
+	defer db.Close()
+	if err := db.AddTable("Person", []Field{Field{Name: "Name", Sort: DBString}, Field{Name: "Age", Sort: DBInt},
+		Field{Name: "Photo", Sort: DBBlob}, Field{Name: "Born", Sort: DBDate}}); err != nil {
+		t.Errorf("AddTable() failed: %s", err)
+	}
+	born := time.Date(1970, 3, 4, 5, 6, 7, 0, time.UTC)
+	_, err = db.Base().Exec(`INSERT INTO Person (Name,Age,Photo,Born) VALUES (?,?,?,?);`,
+		NewString("John"), NewInt(42), NewBytes([]byte{0, 1, 2}), NewDate(born))
+	if err != nil {
+		t.Errorf("Exec() with values as arguments failed: %s", err)
+	}
+	values, err := db.GetItem("Person", 1)
+	if err != nil || values["Name"][0].String() != "John" || values["Age"][0].Int() != 42 ||
+		!bytes.Equal(values["Photo"][0].Bytes(), []byte{0, 1, 2}) || !values["Born"][0].Datetime().Equal(born) {
+		t.Errorf("values were not stored correctly: %v %v", values, err)
+	}
+	var name, age, photo, date Value
+	photo.Sort = DBBlob
+	date.Sort = DBDate
+	err = db.Base().QueryRow(`SELECT Name,Age,Photo,Born FROM Person WHERE Id=1;`).Scan(&name, &age, &photo, &date)
+	if err != nil {
+		t.Errorf("Scan() into values failed: %s", err)
+	}
+	if name.Sort != DBString || name.String() != "John" || age.Sort != DBInt || age.Int() != 42 ||
+		photo.Sort != DBBlob || !bytes.Equal(photo.Bytes(), []byte{0, 1, 2}) ||
+		date.Sort != DBDate || !date.Datetime().Equal(born) {
+		t.Errorf("Scan() returned wrong values %v %v %v %v", name, age, photo, date)
+	}
+	if err := db.Base().QueryRow(`SELECT NULL;`).Scan(&name); err != nil || !name.IsNull() {
+		t.Errorf("Scan() returned %v, %v for NULL, expected a NULL value", name, err)
+	}
+	if _, err := (Value{Sort: DBIntList}).Value(); err == nil {
+		t.Errorf("Value() should fail for a list type")
+	}
+}
+
+func TestNull(t *testing.T) {
+	tmp, _ := ioutil.TempFile("", "minidb-null-testing-*")
+	defer os.Remove(tmp.Name())
+	db, err := Open("sqlite3", tmp.Name())
+	if err != nil {
+		t.Errorf("Open() failed: %s", err)
+	}
+	defer db.Close()
+	zero := NewInt(0)
+	if err := db.AddTable("Person", []Field{Field{Name: "Name", Sort: DBString}, Field{Name: "Tags", Sort: DBStringList},
+		Field{Name: "Age", Sort: DBInt, Required: true, Default: &zero}}); err != nil {
+		t.Errorf("AddTable() failed: %s", err)
+	}
+	john, _ := db.NewItem("Person")
+	anna, _ := db.NewItem("Person")
+	tx, err := db.Begin()
+	if err != nil {
+		t.Errorf("Begin() failed: %s", err)
+	}
+	tx.EnableHistory("Person", 0)
+	tx.Set("Person", john, "Name", []Value{NewString("John")})
+	tx.Set("Person", john, "Tags", []Value{NewString("a")})
+	tx.Set("Person", anna, "Name", []Value{NewString("Anna")})
+	if err := tx.Set("Person", anna, "Name", []Value{NewNull()}); err != nil {
+		t.Errorf("Set() failed for a NULL value: %s", err)
+	}
+	if err := tx.Set("Person", anna, "Age", []Value{NewNull()}); err == nil {
+		t.Errorf("Set() should fail for a NULL value in a required field")
+	}
+	if err := tx.Set("Person", anna, "Tags", []Value{NewNull()}); err == nil {
+		t.Errorf("Set() should fail for a NULL value in a list field")
+	}
+	if err := tx.Commit(); err != nil {
+		t.Errorf("Commit() failed: %s", err)
+	}
+	values, err := db.Get("Person", anna, "Name")
+	if err != nil || len(values) != 1 || !values[0].IsNull() || values[0].String() != "" {
+		t.Errorf("Get() returned %v, %v, expected a NULL value", values, err)
+	}
+	history, err := db.HistoryOf("Person", anna, "Name")
+	if err != nil || len(history) != 2 || history[0].Values[0].String() != "Anna" || !history[1].Values[0].IsNull() {
+		t.Errorf("HistoryOf() returned %v, %v, expected Anna and NULL", history, err)
+	}
+	for query, expected := range map[string]Item{"Person Name is null": anna, "Person not Name is null": john,
+		"Person Tags is null": anna, "Person not Tags is null and Name=J%": john} {
+		q, err := ParseQuery(query)
+		if err != nil {
+			t.Errorf("ParseQuery(%s) failed: %s", query, err)
+			continue
+		}
+		items, err := db.Find(q, 0)
+		if err != nil || len(items) != 1 || items[0] != expected {
+			t.Errorf("Find(%s) returned %v, %v, expected %d", query, items, err, expected)
+		}
+	}
+	q, err := ParseQuery("Person Name is John")
+	if err == nil {
+		_, err = db.Find(q, 0)
+	}
+	if err == nil {
+		t.Errorf("Find() should fail for is without null")
+	}
+}
+
 func TestBackup(t *testing.T) {
 	db, err := Open("sqlite3", tmpfile.Name())
 	if err != nil {
@@ -1023,6 +1845,220 @@ func TestBackup(t *testing.T) {
 	}
 }
 
+func TestBackupWithProgress(t *testing.T) {
+	tmp, _ := ioutil.TempFile("", "minidb-testing-*")
+	defer os.Remove(tmp.Name())
+	db, err := Open("sqlite3", tmp.Name())
+	if err != nil {
+		t.Fatalf("Open() failed: %s", err)
+	}
+	defer db.Close()
+	db.AddTable("Note", []Field{Field{Name: "Text", Sort: DBString}})
+	text := NewString(string(bytes.Repeat([]byte("x"), 10000)))
+	items := make([]Item, 200)
+	for i := range items {
+		items[i], _ = db.NewItem("Note")
+	}
+	for _, item := range items {
+		tx, _ := db.Begin()
+		tx.Set("Note", item, "Text", []Value{text})
+		tx.Commit()
+	}
+
+	backup, _ := ioutil.TempFile("", "minidb-testing-*")
+	defer os.Remove(backup.Name())
+	steps, copied, total := 0, 0, 0
+	err = db.BackupWithProgress(backup.Name(), func(c, n int) {
+		steps++
+		copied, total = c, n
+	})
+	if err != nil {
+		t.Fatalf("BackupWithProgress() failed: %s", err)
+	}
+	if steps < 2 || copied != total || total == 0 {
+		t.Errorf("progress was reported %d times, last with %d of %d pages", steps, copied, total)
+	}
+	// the database is still open after the backup
+	if _, err := db.NewItem("Note"); err != nil {
+		t.Errorf("NewItem() after a backup failed: %s", err)
+	}
+	copy, err := Open("sqlite3", backup.Name())
+	if err != nil {
+		t.Fatalf("cannot open backup: %s", err)
+	}
+	defer copy.Close()
+	if n, _ := copy.Count("Note"); n != 200 {
+		t.Errorf("the backup contains %d items, expected 200", n)
+	}
+	if err := db.Backup(filepath.Join(backup.Name(), "missing", "file")); err == nil {
+		t.Errorf("Backup() to a missing directory should fail")
+	}
+}
+
+func TestOpenExisting(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "minidb-testing-*")
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "db.sqlite")
+	if _, err := OpenExisting("sqlite3", file); !os.IsNotExist(err) {
+		t.Errorf("OpenExisting() should fail for a missing file, returned %v", err)
+	}
+	if _, err := os.Stat(file); err == nil {
+		t.Errorf("OpenExisting() created the database file")
+	}
+
+	lazy, err := OpenWithOptions("sqlite3", file, &Options{DeferInit: true})
+	if err != nil {
+		t.Errorf("OpenWithOptions() failed: %s", err)
+	}
+	if lazy.TableExists("Person") || len(lazy.GetTables()) != 0 || lazy.GetStr(1) != "" {
+		t.Errorf("a database that has not been created yet should be empty")
+	}
+	if err := lazy.Close(); err != nil {
+		t.Errorf("Close() failed: %s", err)
+	}
+	if _, err := os.Stat(file); err == nil {
+		t.Errorf("reading a database with deferred initialization created the file")
+	}
+
+	lazy, _ = OpenWithOptions("sqlite3", file, &Options{DeferInit: true})
+	if err := lazy.AddTable("Person", []Field{Field{Name: "Name", Sort: DBString}}); err != nil {
+		t.Errorf("AddTable() failed: %s", err)
+	}
+	if !lazy.TableExists("Person") {
+		t.Errorf("the first write did not create the database")
+	}
+	item, _ := lazy.NewItem("Person")
+	lazy.Close()
+
+	existing, err := OpenExisting("sqlite3", file)
+	if err != nil {
+		t.Errorf("OpenExisting() failed for an existing file: %s", err)
+	}
+	defer existing.Close()
+	if !existing.ItemExists("Person", item) {
+		t.Errorf("OpenExisting() did not open the existing database")
+	}
+}
+
+func TestConcurrentTransactions(t *testing.T) {
+	tmp, _ := ioutil.TempFile("", "minidb-testing-*")
+	defer os.Remove(tmp.Name())
+	db, err := Open("sqlite3", tmp.Name())
+	if err != nil {
+		t.Errorf("Open() failed: %s", err)
+	}
+	defer db.Close()
+	db.AddTable("Person", []Field{Field{Name: "Name", Sort: DBString}})
+	items := make([]Item, 20)
+	for i := range items {
+		items[i], _ = db.NewItem("Person")
+	}
+
+	// every goroutine has its own transaction, half of them are rolled back
+	var wg sync.WaitGroup
+	for i, item := range items {
+		wg.Add(1)
+		go func(i int, item Item) {
+			defer wg.Done()
+			tx, err := db.Begin()
+			if err != nil {
+				t.Errorf("Begin() failed: %s", err)
+				return
+			}
+			tx.Set("Person", item, "Name", []Value{NewString(fmt.Sprint(i))})
+			if i%2 == 0 {
+				tx.Commit()
+			} else {
+				tx.Rollback()
+			}
+		}(i, item)
+	}
+	wg.Wait()
+	for i, item := range items {
+		got, _ := db.Get("Person", item, "Name")
+		if i%2 == 0 && (len(got) != 1 || got[0].Str != fmt.Sprint(i)) {
+			t.Errorf("committed change of transaction %d is missing: %v", i, got)
+		}
+		if i%2 == 1 && len(got) == 1 && !got[0].IsNull() {
+			t.Errorf("rolled back change of transaction %d is present: %v", i, got)
+		}
+	}
+
+	// nested transactions, also begun on a view, belong to the enclosing transaction
+	tx, _ := db.Begin()
+	tx.Set("Person", items[1], "Name", []Value{NewString("outer")})
+	sub, _ := tx.Begin()
+	sub.Set("Person", items[3], "Name", []Value{NewString("inner")})
+	sub.Rollback()
+	sub, err = tx.View().Begin()
+	if err != nil {
+		t.Errorf("Begin() on a view failed: %s", err)
+	}
+	sub.Set("Person", items[5], "Name", []Value{NewString("inner")})
+	sub.Commit()
+	if got, _ := db.Get("Person", items[5], "Name"); len(got) == 1 && !got[0].IsNull() {
+		t.Errorf("a nested transaction was committed before the enclosing transaction")
+	}
+	tx.Commit()
+	for _, c := range []struct {
+		item Item
+		want string
+	}{{items[1], "outer"}, {items[3], ""}, {items[5], "inner"}} {
+		got, _ := db.Get("Person", c.item, "Name")
+		if (c.want == "" && len(got) == 1 && !got[0].IsNull()) || (c.want != "" && (len(got) != 1 || got[0].Str != c.want)) {
+			t.Errorf("expected '%s' after nested transactions, found %v", c.want, got)
+		}
+	}
+	if err := tx.Commit(); err == nil {
+		t.Errorf("committing a transaction twice should fail")
+	}
+}
+
+func TestSchemaChangesInTransaction(t *testing.T) {
+	tmp, _ := ioutil.TempFile("", "minidb-testing-*")
+	defer os.Remove(tmp.Name())
+	db, err := Open("sqlite3", tmp.Name())
+	if err != nil {
+		t.Fatalf("Open() failed: %s", err)
+	}
+	defer db.Close()
+
+	// the checks of the transaction see the table it has created
+	tx, _ := db.Begin()
+	if err := tx.AddTable("Person", []Field{Field{Name: "Name", Sort: DBString}, Field{Name: "Tags", Sort: DBStringList}}); err != nil {
+		t.Fatalf("AddTable() failed: %s", err)
+	}
+	if err := tx.Index("Person", "Name"); err != nil {
+		t.Errorf("Index() failed on a table created in the transaction: %s", err)
+	}
+	items, err := tx.NewItems("Person", [][]FieldValue{nil, nil})
+	if err != nil {
+		t.Fatalf("NewItems() failed: %s", err)
+	}
+	john, jane := items[0], items[1]
+	if err := tx.Set("Person", john, "Name", []Value{NewString("John")}); err != nil {
+		t.Errorf("Set() failed on a table created in the transaction: %s", err)
+	}
+	if err := tx.SetItem("Person", john, map[string][]Value{"Tags": []Value{NewString("new")}}); err != nil {
+		t.Errorf("SetItem() failed on a table created in the transaction: %s", err)
+	}
+	if err := tx.RemoveItems("Person", []Item{jane}); err != nil {
+		t.Errorf("RemoveItems() failed on a table created in the transaction: %s", err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("Commit() failed: %s", err)
+	}
+	if got, _ := db.Get("Person", john, "Name"); len(got) != 1 || got[0].String() != "John" {
+		t.Errorf("expected John after the commit, found %v", got)
+	}
+	if db.ItemExists("Person", jane) {
+		t.Errorf("the removed item exists after the commit")
+	}
+	if !db.hasIndex("Person", Field{Name: "Name", Sort: DBString}) {
+		t.Errorf("the index created in the transaction is missing")
+	}
+}
+
 func setup() {
 	tmpfile, _ = ioutil.TempFile("", "minidb-testing-*")
 	tmpfile2, _ = ioutil.TempFile("", "minidb-testing-*")
@@ -1046,3 +2082,204 @@ func TestMain(m *testing.M) {
 	teardown()
 	os.Exit(retCode)
 }
+
+func TestReadTransactions(t *testing.T) {
+	tmp, _ := ioutil.TempFile("", "minidb-testing-*")
+	defer os.Remove(tmp.Name())
+	defer os.Remove(tmp.Name() + "-wal")
+	defer os.Remove(tmp.Name() + "-shm")
+	db, err := Open("sqlite3", tmp.Name())
+	if err != nil {
+		t.Errorf("Open() failed: %s", err)
+	}
+	defer db.Close()
+	if _, err := db.Base().Exec(`PRAGMA journal_mode=WAL;`); err != nil {
+		t.Errorf("cannot enable WAL mode: %s", err)
+	}
+	db.AddTable("Person", []Field{Field{Name: "Name", Sort: DBString}})
+	john, _ := db.NewItem("Person")
+	tx, _ := db.Begin()
+	tx.Set("Person", john, "Name", []Value{NewString("John")})
+	tx.Commit()
+
+	rtx, err := db.BeginRead()
+	if err != nil {
+		t.Fatalf("BeginRead() failed: %s", err)
+	}
+	other, err := db.BeginRead()
+	if err != nil {
+		t.Errorf("BeginRead() failed while another read-only transaction is open: %s", err)
+	} else {
+		other.Rollback()
+	}
+	// a writer commits while the read-only transaction is open
+	db.NewItem("Person")
+	tx, err = db.Begin()
+	if err != nil {
+		t.Fatalf("Begin() failed while a read-only transaction is open: %s", err)
+	}
+	tx.Set("Person", john, "Name", []Value{NewString("Jim")})
+	if err := tx.Commit(); err != nil {
+		t.Errorf("Commit() failed while a read-only transaction is open: %s", err)
+	}
+	view := rtx.View()
+	if got, _ := view.Get("Person", john, "Name"); len(got) != 1 || got[0].Str != "John" {
+		t.Errorf("read-only transaction sees %v, expected the name when it began", got)
+	}
+	if n, _ := view.Count("Person"); n != 1 {
+		t.Errorf("read-only transaction counts %d items, expected 1", n)
+	}
+	q, _ := ParseQuery("Person Name=Jim")
+	if items, _ := view.Find(q, 10); len(items) != 0 {
+		t.Errorf("read-only transaction finds %v, expected nothing", items)
+	}
+	if err := rtx.Set("Person", john, "Name", []Value{NewString("Jack")}); err == nil {
+		t.Errorf("Set() in a read-only transaction should fail")
+	}
+	if err := rtx.Commit(); err != nil {
+		t.Errorf("Commit() of a read-only transaction failed: %s", err)
+	}
+
+	// the connections of read-only transactions can be used for writing afterwards
+	for i := 0; i < 5; i++ {
+		rtx, _ := db.BeginRead()
+		rtx.Rollback()
+		tx, _ := db.Begin()
+		if err := tx.Set("Person", john, "Name", []Value{NewString(fmt.Sprint(i))}); err != nil {
+			t.Errorf("Set() failed after a read-only transaction: %s", err)
+		}
+		tx.Commit()
+	}
+	if got, _ := db.Get("Person", john, "Name"); len(got) != 1 || got[0].Str != "4" {
+		t.Errorf("Name is %v, expected 4", got)
+	}
+}
+
+func TestOpenOptions(t *testing.T) {
+	tmp, _ := ioutil.TempFile("", "minidb-testing-*")
+	defer os.Remove(tmp.Name())
+	defer os.Remove(tmp.Name() + "-wal")
+	defer os.Remove(tmp.Name() + "-shm")
+	if _, err := OpenWithOptions("sqlite3", tmp.Name(), &Options{JournalMode: "fast"}); err == nil {
+		t.Errorf("OpenWithOptions() should fail with an invalid journal mode")
+	}
+	if _, err := OpenWithOptions("sqlite3", tmp.Name(), &Options{Synchronous: "sometimes"}); err == nil {
+		t.Errorf("OpenWithOptions() should fail with an invalid synchronous level")
+	}
+	db, err := OpenWithOptions("sqlite3", tmp.Name(), &Options{JournalMode: "wal", ForeignKeys: true,
+		BusyTimeout: 2 * time.Second, Synchronous: "normal", CacheSize: -4000})
+	if err != nil {
+		t.Fatalf("OpenWithOptions() failed: %s", err)
+	}
+	defer db.Close()
+	check := func(when string) {
+		// read-only transactions hold their own connections, so several connections are checked
+		txs := make([]*Tx, 3)
+		for i := range txs {
+			txs[i], err = db.BeginRead()
+			if err != nil {
+				t.Fatalf("BeginRead() failed: %s", err)
+			}
+			defer txs[i].Rollback()
+		}
+		for _, tx := range txs {
+			var mode string
+			var fk, timeout, sync, cache int64
+			tx.tx.QueryRow(`PRAGMA journal_mode;`).Scan(&mode)
+			tx.tx.QueryRow(`PRAGMA foreign_keys;`).Scan(&fk)
+			tx.tx.QueryRow(`PRAGMA busy_timeout;`).Scan(&timeout)
+			tx.tx.QueryRow(`PRAGMA synchronous;`).Scan(&sync)
+			tx.tx.QueryRow(`PRAGMA cache_size;`).Scan(&cache)
+			if mode != "wal" || fk != 1 || timeout != 2000 || sync != 1 || cache != -4000 {
+				t.Errorf("%s: connection has journal mode %s, foreign keys %d, busy timeout %d, synchronous %d, cache size %d",
+					when, mode, fk, timeout, sync, cache)
+			}
+		}
+	}
+	check("after opening")
+
+	// list values are removed before their owners, so foreign keys are satisfied
+	db.AddTable("Person", []Field{Field{Name: "Tags", Sort: DBStringList}})
+	item, _ := db.NewItem("Person")
+	tx, _ := db.Begin()
+	tx.Set("Person", item, "Tags", []Value{NewString("a")})
+	if err := tx.RemoveItem("Person", item); err != nil {
+		t.Errorf("RemoveItem() failed with foreign keys: %s", err)
+	}
+	tx.Commit()
+
+	backup, _ := ioutil.TempFile("", "minidb-testing-*")
+	defer os.Remove(backup.Name())
+	if err := db.Backup(backup.Name()); err != nil {
+		t.Errorf("Backup() failed: %s", err)
+	}
+	check("after a backup")
+}
+
+func TestBusyRetries(t *testing.T) {
+	tmp, _ := ioutil.TempFile("", "minidb-testing-*")
+	defer os.Remove(tmp.Name())
+	if _, err := OpenWithOptions("sqlite3", tmp.Name(), &Options{BusyRetries: -1}); err == nil {
+		t.Errorf("OpenWithOptions() should fail with a negative number of retries")
+	}
+	patient, err := OpenWithOptions("sqlite3", tmp.Name(), &Options{BusyTimeout: time.Millisecond,
+		BusyRetries: 10, BusyBackoff: 5 * time.Millisecond})
+	if err != nil {
+		t.Fatalf("OpenWithOptions() failed: %s", err)
+	}
+	defer patient.Close()
+	impatient, err := OpenWithOptions("sqlite3", tmp.Name(), &Options{BusyTimeout: time.Millisecond})
+	if err != nil {
+		t.Fatalf("OpenWithOptions() failed: %s", err)
+	}
+	defer impatient.Close()
+	patient.AddTable("Person", []Field{Field{Name: "Name", Sort: DBString}})
+
+	// another process locks the database for a while
+	ctx := context.Background()
+	other, _ := sql.Open("sqlite3", tmp.Name())
+	defer other.Close()
+	conn, err := other.Conn(ctx)
+	if err != nil {
+		t.Fatalf("cannot connect: %s", err)
+	}
+	defer conn.Close()
+	lock := func() chan struct{} {
+		if _, err := conn.ExecContext(ctx, `BEGIN EXCLUSIVE;`); err != nil {
+			t.Fatalf("cannot lock database: %s", err)
+		}
+		done := make(chan struct{})
+		go func() {
+			time.Sleep(100 * time.Millisecond)
+			conn.ExecContext(ctx, `COMMIT;`)
+			close(done)
+		}()
+		return done
+	}
+
+	done := lock()
+	if _, err := impatient.NewItem("Person"); err == nil {
+		t.Errorf("NewItem() without retries should fail while the database is locked")
+	}
+	item, err := patient.NewItem("Person")
+	if err != nil {
+		t.Errorf("NewItem() with retries failed: %s", err)
+	}
+	<-done
+
+	done = lock()
+	tx, err := patient.Begin()
+	if err != nil {
+		t.Fatalf("Begin() failed: %s", err)
+	}
+	if err := tx.Set("Person", item, "Name", []Value{NewString("John")}); err != nil {
+		t.Errorf("Set() with retries failed: %s", err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Errorf("Commit() with retries failed: %s", err)
+	}
+	<-done
+	if v, _ := impatient.Get("Person", item, "Name"); len(v) != 1 || v[0].Str != "John" {
+		t.Errorf("Get() returned %v, expected John", v)
+	}
+}
@@ -0,0 +1,187 @@
+package minidb
+
+import (
+	"strings"
+	"time"
+)
+
+// ------------------------------------------------------------------------------
+// Multiuser databases in the command API
+// ------------------------------------------------------------------------------
+
+// sessionDBPrefix starts the names of the user databases returned by a UserDB command. The rest
+// of the name is the session token, which is validated whenever the database is used.
+const sessionDBPrefix = "session:"
+
+// DefaultSessionTTL is the time a session created by an Authenticate command is valid if the
+// command gives no time.
+const DefaultSessionTTL = 24 * time.Hour
+
+// multiUserCommands are the commands executed on the multiuser database of the executor.
+var multiUserCommands = map[CommandID]bool{
+	CmdNewUser: true, CmdAuthenticate: true, CmdLogout: true, CmdUserDB: true, CmdDeleteUser: true,
+	CmdArchiveUser: true, CmdChangePassword: true,
+}
+
+// SetMultiDB sets the multiuser database whose users can be created and authenticated with
+// commands, for example NewUserCommand and AuthenticateCommand. A client gets the name of the
+// database of an authenticated user with a UserDBCommand and passes it to other commands like
+// the name of an open database. The multiuser database is not closed by the executor.
+func (e *Executor) SetMultiDB(m *MultiDB) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.multiDB = m
+}
+
+// getMultiDB returns the multiuser database of the executor, or nil.
+func (e *Executor) getMultiDB() *MultiDB {
+	e.mutex.RLock()
+	defer e.mutex.RUnlock()
+	return e.multiDB
+}
+
+// IsSessionDB returns true if the database name has been returned by a UserDB command. Such
+// names contain a session token and must not be logged.
+func IsSessionDB(db CommandDB) bool {
+	return strings.HasPrefix(string(db), sessionDBPrefix)
+}
+
+// sessionDB returns the database of the user of the session named by the database name.
+func (e *Executor) sessionDB(db CommandDB) (*MDB, *Result) {
+	r := Result{HasError: true, Int: ErrUnknownDB}
+	m := e.getMultiDB()
+	if m == nil {
+		r.Int = ErrNoMultiDB
+		r.Str = Fail("exec failed: the executor has no multiuser database").Error()
+		return nil, &r
+	}
+	user, code, err := m.ValidateSession(strings.TrimPrefix(string(db), sessionDBPrefix))
+	if err == nil {
+		var theDB *MDB
+		if theDB, code, err = m.UserDB(user); err == nil {
+			return theDB, nil
+		}
+	}
+	r.Ints = []int64{int64(code)}
+	r.Str = Fail("exec failed: %s", err).Error()
+	return nil, &r
+}
+
+// multiUserKey returns the salted key of the user for the password, generated with the
+// external salt stored for the user like a client would.
+func multiUserKey(m *MultiDB, username, password string) (*saltedKey, ErrCode, error) {
+	salt, code, err := m.ExternalSalt(username)
+	if err != nil {
+		return nil, code, err
+	}
+	return GenerateKey(password, salt, DefaultParams()), OK, nil
+}
+
+// execMultiUser executes a command on the multiuser database of the executor. The error code of
+// the multiuser database is returned in Ints if a command fails.
+func (e *Executor) execMultiUser(cmd *Command) *Result {
+	var r Result
+	m := e.getMultiDB()
+	if m == nil {
+		r.HasError = true
+		r.Int = ErrNoMultiDB
+		r.Str = Fail("exec failed: the executor has no multiuser database").Error()
+		return &r
+	}
+	fail := func(id int64, code ErrCode, err error) *Result {
+		r.HasError = true
+		r.Int = id
+		r.Ints = []int64{int64(code)}
+		r.setError(err)
+		return &r
+	}
+	if len(cmd.StrArgs) < multiUserArgs[cmd.ID] {
+		return fail(ErrInvalidCommand, ErrInvalidParams,
+			Fail("expected %d arguments, given %d", multiUserArgs[cmd.ID], len(cmd.StrArgs)))
+	}
+	switch cmd.ID {
+	case CmdNewUser:
+		p := DefaultParams()
+		key := GenerateKey(cmd.StrArgs[2], GenerateExternalSalt(p), p)
+		user, code, err := m.NewUser(cmd.StrArgs[0], cmd.StrArgs[1], key)
+		if err != nil {
+			return fail(ErrNewUserFailed, code, err)
+		}
+		r.Int = int64(user.ID())
+
+	case CmdAuthenticate:
+		key, code, err := multiUserKey(m, cmd.StrArgs[0], cmd.StrArgs[1])
+		if err != nil {
+			return fail(ErrAuthenticateFailed, code, err)
+		}
+		user, code, err := m.Authenticate(cmd.StrArgs[0], key)
+		if err != nil {
+			return fail(ErrAuthenticateFailed, code, err)
+		}
+		ttl := time.Duration(cmd.IntArg)
+		if ttl <= 0 {
+			ttl = DefaultSessionTTL
+		}
+		if r.Str, code, err = m.CreateSession(user, ttl); err != nil {
+			return fail(ErrAuthenticateFailed, code, err)
+		}
+		r.Int = int64(user.ID())
+
+	case CmdLogout:
+		if code, err := m.RevokeSession(cmd.StrArgs[0]); err != nil {
+			return fail(ErrLogoutFailed, code, err)
+		}
+
+	case CmdUserDB:
+		user, code, err := m.ValidateSession(cmd.StrArgs[0])
+		if err == nil {
+			_, code, err = m.UserDB(user)
+		}
+		if err != nil {
+			return fail(ErrUserDBFailed, code, err)
+		}
+		r.Str = sessionDBPrefix + cmd.StrArgs[0]
+
+	case CmdDeleteUser:
+		user, code, err := m.ValidateSession(cmd.StrArgs[0])
+		if err == nil {
+			code, err = m.DeleteUser(user)
+		}
+		if err != nil {
+			return fail(ErrDeleteUserFailed, code, err)
+		}
+
+	case CmdChangePassword:
+		user, code, err := m.ValidateSession(cmd.StrArgs[0])
+		var oldKey *saltedKey
+		if err == nil {
+			oldKey, code, err = multiUserKey(m, user.Name(), cmd.StrArgs[1])
+		}
+		if err == nil {
+			p := DefaultParams()
+			code, err = m.ChangePassword(user, oldKey, GenerateKey(cmd.StrArgs[2], GenerateExternalSalt(p), p))
+		}
+		if err != nil {
+			return fail(ErrChangePasswordFailed, code, err)
+		}
+
+	case CmdArchiveUser:
+		if !e.isAdmin(cmd) {
+			return fail(ErrNotPermitted, OK, Fail("exec failed: users can only be archived with admin rights"))
+		}
+		id := m.userID(cmd.StrArgs[0])
+		if id == 0 {
+			return fail(ErrArchiveUserFailed, ErrUnknownUser, Fail(`unknown user "%s"`, cmd.StrArgs[0]))
+		}
+		if code, err := m.ArchiveUser(&User{name: cmd.StrArgs[0], id: id}, cmd.StrArgs[1]); err != nil {
+			return fail(ErrArchiveUserFailed, code, err)
+		}
+	}
+	return &r
+}
+
+// multiUserArgs are the numbers of string arguments of the multiuser commands.
+var multiUserArgs = map[CommandID]int{
+	CmdNewUser: 3, CmdAuthenticate: 2, CmdLogout: 1, CmdUserDB: 1, CmdDeleteUser: 1,
+	CmdArchiveUser: 2, CmdChangePassword: 3,
+}
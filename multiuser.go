@@ -3,10 +3,12 @@ package minidb
 import (
 	"bytes"
 	"crypto/rand"
+	"database/sql"
 	"fmt"
 	"os"
 	"path/filepath"
 	"regexp"
+	"sync"
 	"time"
 
 	"github.com/rasteric/packdir"
@@ -46,10 +48,15 @@ func (p *Params) validate() bool {
 	return false
 }
 
-// User represents a user.
+// User represents a user. The email address and dates are only set for users returned by
+// Users and FindUsers.
 type User struct {
-	name string
-	id   Item
+	name     string
+	id       Item
+	email    string
+	created  time.Time
+	modified time.Time
+	dataKey  []byte
 }
 
 // Name returns the name of the user.
@@ -62,13 +69,36 @@ func (u *User) ID() Item {
 	return u.id
 }
 
+// Email returns the email address of the user.
+func (u *User) Email() string {
+	return u.email
+}
+
+// Created returns the date when the user was created.
+func (u *User) Created() time.Time {
+	return u.created
+}
+
+// Modified returns the date when the user was last modified.
+func (u *User) Modified() time.Time {
+	return u.modified
+}
+
 // MultiDB contains all information needed for housekeeping multiple DBs, except for the parameters
 // and context-specific information like passwords.
 type MultiDB struct {
 	basepath string
 	driver   string
 	system   *MDB
-	userdbs  map[Item]*MDB
+	userdbs  *userDBCache
+
+	maxFailures     int
+	failureWindow   time.Duration
+	lockoutDuration time.Duration
+	loginMutex      sync.Mutex // guards the lockout parameters and changes of the Login table
+	encryptUsers    bool
+	groupdbs        map[Item]*MDB
+	groupMutex      sync.Mutex
 }
 
 // NewMultiDB returns a new multi user database.
@@ -85,7 +115,9 @@ func NewMultiDB(basedir string, driver string) (*MultiDB, error) {
 	}
 	thedb.system = sys
 	thedb.driver = driver
-	thedb.userdbs = make(map[Item]*MDB)
+	thedb.userdbs = newUserDBCache()
+	thedb.groupdbs = make(map[Item]*MDB)
+	thedb.SetLockout(DefaultMaxFailures, DefaultFailureWindow, DefaultLockoutDuration)
 	err = sys.AddTable("User",
 		[]Field{Field{Name: "Username", Sort: DBString},
 			Field{Name: "Email", Sort: DBString},
@@ -97,6 +129,58 @@ func NewMultiDB(basedir string, driver string) (*MultiDB, error) {
 	if err != nil {
 		return nil, Fail(`could not create user table: %s`, err)
 	}
+	err = sys.AddTable("UserRole",
+		[]Field{Field{Name: "User", Sort: DBInt},
+			Field{Name: "Role", Sort: DBString}})
+	if err != nil {
+		return nil, Fail(`could not create role table: %s`, err)
+	}
+	err = sys.AddTable("Login",
+		[]Field{Field{Name: "User", Sort: DBInt},
+			Field{Name: "Disabled", Sort: DBInt},
+			Field{Name: "Failures", Sort: DBInt},
+			Field{Name: "Since", Sort: DBInt},
+			Field{Name: "LockedUntil", Sort: DBInt}})
+	if err != nil {
+		return nil, Fail(`could not create login table: %s`, err)
+	}
+	err = sys.AddTable("Session",
+		[]Field{Field{Name: "User", Sort: DBInt},
+			Field{Name: "Token", Sort: DBString},
+			Field{Name: "Expires", Sort: DBInt}})
+	if err != nil {
+		return nil, Fail(`could not create session table: %s`, err)
+	}
+	err = sys.AddTable("UserKey",
+		[]Field{Field{Name: "User", Sort: DBInt},
+			Field{Name: "WrapSalt", Sort: DBBlob},
+			Field{Name: "DataKey", Sort: DBBlob}})
+	if err != nil {
+		return nil, Fail(`could not create user key table: %s`, err)
+	}
+	err = sys.AddTable("UserParams",
+		[]Field{Field{Name: "User", Sort: DBInt},
+			Field{Name: "Argon2Memory", Sort: DBInt},
+			Field{Name: "Argon2Iterations", Sort: DBInt},
+			Field{Name: "Argon2Parallelism", Sort: DBInt},
+			Field{Name: "KeyLength", Sort: DBInt},
+			Field{Name: "InternalSaltLength", Sort: DBInt},
+			Field{Name: "ExternalSaltLength", Sort: DBInt}})
+	if err != nil {
+		return nil, Fail(`could not create user parameter table: %s`, err)
+	}
+	err = sys.AddTable("UserGroup",
+		[]Field{Field{Name: "Name", Sort: DBString},
+			Field{Name: "Created", Sort: DBDate}})
+	if err != nil {
+		return nil, Fail(`could not create group table: %s`, err)
+	}
+	err = sys.AddTable("GroupMember",
+		[]Field{Field{Name: "GroupId", Sort: DBInt},
+			Field{Name: "User", Sort: DBInt}})
+	if err != nil {
+		return nil, Fail(`could not create group member table: %s`, err)
+	}
 	return thedb, nil
 }
 
@@ -192,6 +276,17 @@ const (
 	ErrPackFail                                // Compressing user data failed.
 	ErrInvalidKey                              // A given salted key is invalid (either nil, or other problems).
 	ErrTransactionFail                         // Could not perform op because of a failed transaction.
+	ErrInvalidRole                             // The role name is invalid.
+	ErrUserDisabled                            // The user has been disabled.
+	ErrUserLocked                              // The user is locked after too many failed authentication attempts.
+	ErrInvalidSession                          // The session token is unknown.
+	ErrSessionExpired                          // The session has expired.
+	ErrUnpackFail                              // Unpacking an archive failed.
+	ErrInvalidGroup                            // The group name is invalid.
+	ErrGroupInUse                              // The group name is already being used.
+	ErrUnknownGroup                            // The group is not known.
+	ErrNotMember                               // The user is not a member of the group.
+	ErrExportFail                              // Exporting user data failed.
 )
 
 func (m *MultiDB) isExisting(field, query string) bool {
@@ -203,8 +298,8 @@ func (m *MultiDB) isExisting(field, query string) bool {
 	return true
 }
 
-func (m *MultiDB) userID(username string) Item {
-	q, err := ParseQuery(fmt.Sprintf("User Username=%s", username))
+func (m *MultiDB) findUser(field, query string) Item {
+	q, err := ParseQuery(fmt.Sprintf("User %s=%s", field, query))
 	if err != nil {
 		return 0
 	}
@@ -215,6 +310,14 @@ func (m *MultiDB) userID(username string) Item {
 	return results[0]
 }
 
+func (m *MultiDB) userID(username string) Item {
+	return m.findUser("Username", username)
+}
+
+func (m *MultiDB) emailID(email string) Item {
+	return m.findUser("Email", email)
+}
+
 // ExistingUser returns true if a user with the given user name exists, false otherwise.
 func (m *MultiDB) ExistingUser(username string) bool {
 	result := m.isExisting("Username", username)
@@ -270,22 +373,18 @@ func (m *MultiDB) NewUser(username, email string, key *saltedKey) (*User, ErrCod
 	if err := tx.Set("User", user.id, "Email", []Value{NewString(email)}); err != nil {
 		return nil, ErrDBFail, err
 	}
-	salt := make([]byte, key.p.InternalSaltLength)
-	n, err := rand.Read(salt)
-	if uint32(n) != key.p.InternalSaltLength || err != nil {
-		return nil, ErrCryptoRandFailure, Fail(`random number generator failed to generate salt`)
-	}
-	if err := tx.Set("User", user.id, "InternalSalt", []Value{NewBytes(salt)}); err != nil {
-		return nil, ErrDBFail, Fail(`could not store salt in multiuser database: %s`, err)
-	}
-	realkey := argon2.IDKey(key.pwd,
-		salt, key.p.Argon2Iterations, key.p.Argon2Memory,
-		key.p.Argon2Parallelism, key.p.KeyLength)
-	if err := tx.Set("User", user.id, "Key", []Value{NewBytes(realkey)}); err != nil {
-		return nil, ErrDBFail, Fail(`could not store key in multiuser database: %s`, err)
+	if reply, err := setUserKey(tx, user.id, key); err != nil {
+		return nil, reply, err
 	}
-	if err := tx.Set("User", user.id, "ExternalSalt", []Value{NewBytes(key.sel)}); err != nil {
-		return nil, ErrDBFail, Fail(`could not store the external salt in multiuser database: %s`, err)
+	if m.encryptUsers {
+		dataKey, reply, err := newDataKey()
+		if err != nil {
+			return nil, reply, err
+		}
+		if reply, err := storeDataKey(tx, user.id, key, dataKey); err != nil {
+			return nil, reply, err
+		}
+		user.dataKey = dataKey
 	}
 	now := NewDate(time.Now())
 	if err := tx.Set("User", user.id, "Created", []Value{now}); err != nil {
@@ -305,6 +404,176 @@ func (m *MultiDB) NewUser(username, email string, key *saltedKey) (*User, ErrCod
 	return &user, OK, nil
 }
 
+// setUserKey generates a new internal salt, derives the internal key from the salted key with
+// Argon2, and stores both together with the key's external salt and parameters for the user.
+func setUserKey(tx *Tx, id Item, key *saltedKey) (ErrCode, error) {
+	salt := make([]byte, key.p.InternalSaltLength)
+	n, err := rand.Read(salt)
+	if uint32(n) != key.p.InternalSaltLength || err != nil {
+		return ErrCryptoRandFailure, Fail(`random number generator failed to generate salt`)
+	}
+	if err := tx.Set("User", id, "InternalSalt", []Value{NewBytes(salt)}); err != nil {
+		return ErrDBFail, Fail(`could not store salt in multiuser database: %s`, err)
+	}
+	realkey := argon2.IDKey(key.pwd,
+		salt, key.p.Argon2Iterations, key.p.Argon2Memory,
+		key.p.Argon2Parallelism, key.p.KeyLength)
+	if err := tx.Set("User", id, "Key", []Value{NewBytes(realkey)}); err != nil {
+		return ErrDBFail, Fail(`could not store key in multiuser database: %s`, err)
+	}
+	if err := tx.Set("User", id, "ExternalSalt", []Value{NewBytes(key.sel)}); err != nil {
+		return ErrDBFail, Fail(`could not store the external salt in multiuser database: %s`, err)
+	}
+	return storeUserParams(tx, id, key.p)
+}
+
+// ChangePassword changes the password of a user. The old key is verified like by Authenticate,
+// then a new internal salt is generated and the internal key is derived from the new key with
+// Argon2. The new key should be generated with fresh external salt from GenerateExternalSalt,
+// which is stored as the user's new ExternalSalt. The keys, salts, and modification date are
+// updated in one transaction, so the old password remains valid if the change fails.
+func (m *MultiDB) ChangePassword(user *User, oldKey, newKey *saltedKey) (ErrCode, error) {
+	if user == nil {
+		return ErrUnknownUser, Fail(`user is nil`)
+	}
+	if reply, err := newKey.validate(); err != nil || reply != OK {
+		return reply, err
+	}
+	verified, reply, err := m.Authenticate(user.name, oldKey)
+	if err != nil {
+		return reply, err
+	}
+	tx, err := m.Begin()
+	if err != nil {
+		return ErrTransactionFail, err
+	}
+	defer tx.Rollback()
+	if reply, err := setUserKey(tx, verified.id, newKey); err != nil {
+		return reply, err
+	}
+	if verified.dataKey != nil {
+		if reply, err := storeDataKey(tx, verified.id, newKey, verified.dataKey); err != nil {
+			return reply, err
+		}
+	}
+	if err := tx.Set("User", verified.id, "Modified", []Value{NewDate(time.Now())}); err != nil {
+		return ErrDBFail, err
+	}
+	if err := tx.Commit(); err != nil {
+		return ErrTransactionFail, Fail(`multiuser database error: %s`, err)
+	}
+	return OK, nil
+}
+
+// UpdateEmail changes the email address of a user. Like in NewUser, ErrEmailInUse is returned
+// if another user has already registered the email address.
+func (m *MultiDB) UpdateEmail(user *User, email string) (ErrCode, error) {
+	if user == nil || !m.ExistingUser(user.name) {
+		return ErrUnknownUser, Fail(`unknown user`)
+	}
+	if id := m.emailID(email); id != 0 && id != user.id {
+		return ErrEmailInUse, Fail(`email "%s" is already in use!`, email)
+	}
+	tx, err := m.Begin()
+	if err != nil {
+		return ErrTransactionFail, err
+	}
+	defer tx.Rollback()
+	if err := tx.Set("User", user.id, "Email", []Value{NewString(email)}); err != nil {
+		return ErrDBFail, err
+	}
+	if err := tx.Set("User", user.id, "Modified", []Value{NewDate(time.Now())}); err != nil {
+		return ErrDBFail, err
+	}
+	if err := tx.Commit(); err != nil {
+		return ErrTransactionFail, Fail(`multiuser database error: %s`, err)
+	}
+	return OK, nil
+}
+
+// RenameUser changes the name of a user and renames the user's directory accordingly. The new
+// name must be a valid user name that is not in use yet, otherwise ErrInvalidUser or
+// ErrUsernameInUse is returned. The user database is closed before the directory is renamed
+// and the user is updated to the new name if successful.
+func (m *MultiDB) RenameUser(user *User, newname string) (ErrCode, error) {
+	if user == nil || !m.ExistingUser(user.name) {
+		return ErrUnknownUser, Fail(`unknown user`)
+	}
+	if err := validateUser(newname, m.BaseDir()); err != nil {
+		return ErrInvalidUser, err
+	}
+	if m.ExistingUser(newname) {
+		return ErrUsernameInUse, Fail(`user "%s" already exists!`, newname)
+	}
+	if err := m.userdbs.close(user.id); err != nil {
+		return ErrCloseFailed, err
+	}
+	tx, err := m.Begin()
+	if err != nil {
+		return ErrTransactionFail, err
+	}
+	defer tx.Rollback()
+	if err := tx.Set("User", user.id, "Username", []Value{NewString(newname)}); err != nil {
+		return ErrDBFail, err
+	}
+	if err := tx.Set("User", user.id, "Modified", []Value{NewDate(time.Now())}); err != nil {
+		return ErrDBFail, err
+	}
+	renamed := User{name: newname, id: user.id}
+	olddir, newdir := m.UserDir(user), m.UserDir(&renamed)
+	if err := os.Rename(olddir, newdir); err != nil {
+		return ErrFileSystem, Fail(`could not rename the directory of user "%s": %s`, user.name, err)
+	}
+	if err := tx.Commit(); err != nil {
+		os.Rename(newdir, olddir)
+		return ErrTransactionFail, Fail(`multiuser database error: %s`, err)
+	}
+	user.name = newname
+	return OK, nil
+}
+
+// queryUsers returns the users selected by the condition and arguments of the query.
+func (m *MultiDB) queryUsers(condition string, args ...interface{}) ([]*User, ErrCode, error) {
+	rows, err := m.system.reader.Query(`SELECT Id,Username,Email,Created,Modified FROM User `+condition, args...)
+	if err != nil {
+		return nil, ErrDBFail, Fail(`could not read users: %s`, err)
+	}
+	defer rows.Close()
+	users := make([]*User, 0)
+	for rows.Next() {
+		var user User
+		var name, email, created, modified sql.NullString
+		if err := rows.Scan(&user.id, &name, &email, &created, &modified); err != nil {
+			return nil, ErrDBFail, Fail(`could not read users: %s`, err)
+		}
+		user.name, user.email = name.String, email.String
+		user.created, _ = ParseTime(created.String)
+		user.modified, _ = ParseTime(modified.String)
+		users = append(users, &user)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, ErrDBFail, Fail(`could not read users: %s`, err)
+	}
+	return users, OK, nil
+}
+
+// Users returns at most limit users ordered by user name, skipping the first offset users,
+// together with their email addresses and creation and modification dates. A limit of 0
+// or less returns all users after the offset.
+func (m *MultiDB) Users(offset, limit int64) ([]*User, ErrCode, error) {
+	if limit <= 0 {
+		limit = -1
+	}
+	return m.queryUsers(`ORDER BY Username LIMIT ? OFFSET ?;`, limit, offset)
+}
+
+// FindUsers returns the users whose user name or email address matches the pattern like Users.
+// The pattern may contain the wildcards % and _ like queries, so "%smith%" finds all users with
+// "smith" in their name or email address, regardless of case.
+func (m *MultiDB) FindUsers(pattern string) ([]*User, ErrCode, error) {
+	return m.queryUsers(`WHERE Username LIKE ? OR Email LIKE ? ORDER BY Username;`, pattern, pattern)
+}
+
 // ExternalSalt is the salt associated with a user. It is stored in the database and may
 // be used for hashing the password prior to authentication. The external salt is not used
 // for internal key derivation.
@@ -385,7 +654,11 @@ func GenerateKey(password string, salt []byte, params *Params) *saltedKey {
 // Returns the user and OK if successful, otherwise nil, a numeric error code and the error.
 // Notice that the external salt is not passed to this function. Instead, the password string
 // should have been prepared (securely hashed, whitened, etc.) before calling this function
-// on the basis of the user's ExternalSalt.
+// on the basis of the user's ExternalSalt. Disabled users fail with ErrUserDisabled, and users
+// locked after too many failed attempts fail with ErrUserLocked, see SetLockout. The internal
+// key is derived with the parameters stored for the user rather than those of the key. If they
+// are weaker than DefaultParams, the internal key is derived anew with the stronger parameters,
+// so that the accounts of existing users benefit from updated security requirements.
 func (m *MultiDB) Authenticate(username string, key *saltedKey) (*User, ErrCode, error) {
 	if err := validateUser(username, m.BaseDir()); err != nil {
 		return nil, ErrInvalidUser, err
@@ -407,6 +680,15 @@ func (m *MultiDB) Authenticate(username string, key *saltedKey) (*User, ErrCode,
 	if user.id == 0 {
 		return nil, ErrUnknownUser, Fail(`user "%s" does not exist`, username)
 	}
+	if reply, err := m.checkLogin(username, user.id); err != nil {
+		return nil, reply, err
+	}
+	// the internal key is derived with the parameters stored for the user, if there are any
+	params := m.userParams(user.id)
+	used := key
+	if params != nil {
+		used = key.withParams(params)
+	}
 	// get the strong salt and hash with it using argon2, compare to stored key
 	result, err := m.system.Get("User", user.id, "InternalSalt")
 	if err != nil || len(result) != 1 {
@@ -414,13 +696,13 @@ func (m *MultiDB) Authenticate(username string, key *saltedKey) (*User, ErrCode,
 			Fail(`user "%s"'s internal salt was not found, the user database might be corrupted`, username)
 	}
 	salt := result[0].Bytes()
-	if len(salt) != int(key.p.InternalSaltLength) {
+	if len(salt) != int(used.p.InternalSaltLength) {
 		return nil, ErrInvalidParams,
-			Fail(`invalid params, user "%s"'s internal salt length does not match internal salt length in params, given %d, expected %d`, username, len(salt), key.p.InternalSaltLength)
+			Fail(`invalid params, user "%s"'s internal salt length does not match internal salt length in params, given %d, expected %d`, username, len(salt), used.p.InternalSaltLength)
 	}
-	keyA := argon2.IDKey(key.pwd,
-		salt, key.p.Argon2Iterations, key.p.Argon2Memory,
-		key.p.Argon2Parallelism, key.p.KeyLength)
+	keyA := argon2.IDKey(used.pwd,
+		salt, used.p.Argon2Iterations, used.p.Argon2Memory,
+		used.p.Argon2Parallelism, used.p.KeyLength)
 	keyresult, err := m.system.Get("User", user.id, "Key")
 	if err != nil || len(keyresult) != 1 {
 		return nil, ErrAuthenticationFailed,
@@ -428,27 +710,32 @@ func (m *MultiDB) Authenticate(username string, key *saltedKey) (*User, ErrCode,
 	}
 	keyB := keyresult[0].Bytes()
 	if !bytes.Equal(keyA, keyB) {
+		m.loginFailed(user.id)
 		return nil, ErrAuthenticationFailed, Fail(`authentication failure`)
 	}
+	m.loginSucceeded(user.id)
+	if user.dataKey, reply, err = m.loadDataKey(user.id, used); err != nil {
+		return nil, reply, err
+	}
+	// keys derived with outdated parameters are derived anew with the current ones
+	if upgraded := used.p.upgrade(DefaultParams()); params == nil || *upgraded != *used.p {
+		m.rehashUser(user.id, used.withParams(upgraded), user.dataKey)
+	}
 	return &user, OK, nil
 }
 
 // Close the MultiDB, closing the internal housekeeping and all open user databases.
 func (m *MultiDB) Close() (ErrCode, error) {
-	errcount := 0
-	s := ""
-	for _, v := range m.userdbs {
-		if v != nil {
-			err := v.Close()
-			if err != nil {
-				s = fmt.Sprintf("%s, %s", s, err.Error())
-				errcount++
-			}
+	errcount, s := m.userdbs.closeAll()
+	m.groupMutex.Lock()
+	for k, v := range m.groupdbs {
+		if err := v.Close(); err != nil {
+			s = fmt.Sprintf("%s, %s", s, err.Error())
+			errcount++
 		}
+		delete(m.groupdbs, k)
 	}
-	for k := range m.userdbs {
-		delete(m.userdbs, k)
-	}
+	m.groupMutex.Unlock()
 	if err := m.system.Close(); err != nil {
 		s = fmt.Sprintf("%s, %s", s, err.Error())
 		errcount++
@@ -459,27 +746,31 @@ func (m *MultiDB) Close() (ErrCode, error) {
 	return OK, nil
 }
 
-// UserDB returns the database of the given user.
+// UserDB returns the database of the given user. An encrypted database, see SetUserEncryption,
+// can only be opened for a user returned by NewUser or Authenticate, which holds the key.
+// The database stays open for further calls, see SetMaxOpenUserDBs and SetUserDBIdleTimeout,
+// until the MultiDB is closed, and its Close method does nothing.
 func (m *MultiDB) UserDB(user *User) (*MDB, ErrCode, error) {
-	var err error
 	if user.id == 0 {
 		return nil, ErrUnknownUser, Fail(`user "%s" does not exist`, user.name)
 	}
-	db := m.userdbs[user.id]
-	if db == nil {
-		db, err = Open(m.driver, m.userDBFile(user))
-		if err != nil {
-			return nil, ErrOpenFailed, err
-		}
+	if user.dataKey == nil && m.encryptedUserDB(user.id) {
+		return nil, ErrInvalidKey, Fail(`the database of user "%s" is encrypted, the user must be authenticated`, user.name)
 	}
-	return db, OK, nil
+	if db := m.userdbs.get(user.id); db != nil {
+		return db, OK, nil
+	}
+	db, reply, err := m.openUserDB(user)
+	if err != nil {
+		return nil, reply, err
+	}
+	return m.userdbs.put(user.id, db), OK, nil
 }
 
 // DeleteUserContent deletes a user's content in the multiuser database, i.e., all the user data.
 // This action cannot be undone.
 func (m *MultiDB) DeleteUserContent(user *User) (ErrCode, error) {
-	db, _, _ := m.UserDB(user)
-	db.Close()
+	m.userdbs.close(user.id)
 	if err := removeContents(m.UserDir(user)); err != nil {
 		return ErrFileSystem, err
 	}
@@ -496,8 +787,12 @@ func (m *MultiDB) DeleteUser(user *User) (ErrCode, error) {
 	if err := tx.RemoveItem("User", user.ID()); err != nil {
 		return ErrDBFail, err
 	}
+	for _, table := range []string{"UserRole", "Login", "Session", "UserKey", "GroupMember", "UserParams"} {
+		if err := removeUserItems(tx, table, user); err != nil {
+			return ErrDBFail, err
+		}
+	}
 	errcode, err := m.DeleteUserContent(user)
-	delete(m.userdbs, user.ID())
 	if err != nil {
 		return errcode, err
 	}
@@ -508,6 +803,31 @@ func (m *MultiDB) DeleteUser(user *User) (ErrCode, error) {
 	return OK, nil
 }
 
+// removeUserItems removes the items of the user from a table of the system database whose
+// User field holds the ID of the user.
+func removeUserItems(tx *Tx, table string, user *User) error {
+	return removeItemsWhere(tx, table, "User", user.id)
+}
+
+// removeItemsWhere removes the items from a table of the system database whose field holds the ID.
+func removeItemsWhere(tx *Tx, table, field string, id Item) error {
+	rows, err := tx.tx.Query(`SELECT Id FROM `+table+` WHERE `+field+`=?;`, int64(id))
+	if err != nil {
+		return Fail(`could not read %s: %s`, table, err)
+	}
+	ids := make([]Item, 0)
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return Fail(`could not read %s: %s`, table, err)
+		}
+		ids = append(ids, Item(id))
+	}
+	rows.Close()
+	return tx.RemoveItems(table, ids)
+}
+
 func removeContents(dir string) error {
 	d, err := os.Open(dir)
 	if err != nil {
@@ -539,15 +859,17 @@ func (m *MultiDB) Delete() (ErrCode, error) {
 }
 
 // ArchiveUser stores the user data in a packed zip file but does not close or remove the user.
-// This can be used for backups or for archiving.
+// This can be used for backups or for archiving. The archive also contains the user record
+// with the user's credentials, so that RestoreUser can restore a deleted user.
 func (m *MultiDB) ArchiveUser(user *User, archivedir string) (ErrCode, error) {
-	db, reply, err := m.UserDB(user)
-	if err != nil {
-		return reply, err
-	}
-	if err := db.Close(); err != nil {
+	if err := m.userdbs.close(user.id); err != nil {
 		return ErrCloseFailed, err
 	}
+	record, err := m.writeUserRecord(user)
+	if err != nil {
+		return ErrFileSystem, Fail(`could not write user record: %s`, err)
+	}
+	defer os.Remove(record)
 	source := m.UserDir(user)
 	filename := fmt.Sprintf("%s-%d_%s.multidb", user.Name(), int64(user.ID()), time.Now().UTC().Format(time.RFC3339))
 	result, err := packdir.Pack(source, filename, archivedir, packdir.GOOD_COMPRESSION, 0)
@@ -563,7 +885,6 @@ func (m *MultiDB) ArchiveUser(user *User, archivedir string) (ErrCode, error) {
 			Fail(`archiving failed, %d files were not properly archived (insufficient permissions?)`,
 				result.ArchiveErrNum)
 	}
-	delete(m.userdbs, user.ID())
 	return OK, nil
 }
 
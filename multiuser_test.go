@@ -1,8 +1,16 @@
 package minidb
 
 import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
 	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 )
 
 func TestMultiDB(t *testing.T) {
@@ -104,3 +112,719 @@ func TestMultiDB(t *testing.T) {
 		t.Errorf(`error %d deleting the MultiDB: %s`, reply, err)
 	}
 }
+
+func TestMultiDBChangePassword(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "multidb")
+	if err != nil {
+		t.Fatalf(`could not create temporary directory for testing`)
+	}
+	db, err := NewMultiDB(tmpdir, "sqlite3")
+	if err != nil {
+		t.Fatalf(`error creating MultiDB: %s`, err)
+	}
+	defer db.Delete()
+	p := DefaultParams()
+	salt := GenerateExternalSalt(p)
+	user, _, err := db.NewUser("John", "john@test.com", GenerateKey("old password", salt, p))
+	if err != nil {
+		t.Fatalf(`could not create new user "John": %s`, err)
+	}
+	newSalt := GenerateExternalSalt(p)
+	if code, _ := db.ChangePassword(user, GenerateKey("wrong password", salt, p),
+		GenerateKey("new password", newSalt, p)); code != ErrAuthenticationFailed {
+		t.Errorf(`expected errcode=%d for ChangePassword with a wrong password, given %d`, ErrAuthenticationFailed, code)
+	}
+	if code, err := db.ChangePassword(user, GenerateKey("old password", salt, p),
+		GenerateKey("new password", newSalt, p)); err != nil {
+		t.Errorf(`MultiDB.ChangePassword() failed with errcode=%d: %s`, code, err)
+	}
+	stored, _, err := db.ExternalSalt("John")
+	if err != nil || !bytes.Equal(stored, newSalt) {
+		t.Errorf(`MultiDB.ChangePassword() did not store the new external salt`)
+	}
+	if _, code, _ := db.Authenticate("John", GenerateKey("old password", salt, p)); code != ErrAuthenticationFailed {
+		t.Errorf(`the old password is still valid after ChangePassword, errcode=%d`, code)
+	}
+	if _, code, err := db.Authenticate("John", GenerateKey("new password", stored, p)); err != nil {
+		t.Errorf(`the new password is not valid after ChangePassword, errcode=%d: %s`, code, err)
+	}
+}
+
+func TestMultiDBUpdateUser(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "multidb")
+	if err != nil {
+		t.Fatalf(`could not create temporary directory for testing`)
+	}
+	db, err := NewMultiDB(tmpdir, "sqlite3")
+	if err != nil {
+		t.Fatalf(`error creating MultiDB: %s`, err)
+	}
+	defer db.Delete()
+	p := DefaultParams()
+	user, _, err := db.NewUser("John", "john@test.com", GenerateKey("a password", GenerateExternalSalt(p), p))
+	if err != nil {
+		t.Fatalf(`could not create new user "John": %s`, err)
+	}
+	if _, _, err := db.NewUser("Bob", "bob@test.com", GenerateKey("a password", GenerateExternalSalt(p), p)); err != nil {
+		t.Fatalf(`could not create new user "Bob": %s`, err)
+	}
+	if code, _ := db.UpdateEmail(user, "bob@test.com"); code != ErrEmailInUse {
+		t.Errorf(`expected errcode=%d for UpdateEmail with an email in use, given %d`, ErrEmailInUse, code)
+	}
+	if code, err := db.UpdateEmail(user, "johnny@test.com"); err != nil {
+		t.Errorf(`MultiDB.UpdateEmail() failed with errcode=%d: %s`, code, err)
+	}
+	if s, _, _ := db.UserEmail(user); s != "johnny@test.com" || !db.ExistingEmail("johnny@test.com") {
+		t.Errorf(`MultiDB.UpdateEmail() did not store the email, given "%s"`, s)
+	}
+	if code, _ := db.RenameUser(user, "Bob"); code != ErrUsernameInUse {
+		t.Errorf(`expected errcode=%d for RenameUser to a name in use, given %d`, ErrUsernameInUse, code)
+	}
+	if code, _ := db.RenameUser(user, "no spaces"); code != ErrInvalidUser {
+		t.Errorf(`expected errcode=%d for RenameUser to an invalid name, given %d`, ErrInvalidUser, code)
+	}
+	olddir := db.UserDir(user)
+	if code, err := db.RenameUser(user, "Johnny"); err != nil {
+		t.Errorf(`MultiDB.RenameUser() failed with errcode=%d: %s`, code, err)
+	}
+	if user.Name() != "Johnny" || db.ExistingUser("John") || !db.ExistingUser("Johnny") {
+		t.Errorf(`MultiDB.RenameUser() did not rename the user`)
+	}
+	if validDir(olddir) || !validDir(db.UserDir(user)) {
+		t.Errorf(`MultiDB.RenameUser() did not rename the user directory`)
+	}
+}
+
+func TestMultiDBUsers(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "multidb")
+	if err != nil {
+		t.Fatalf(`could not create temporary directory for testing`)
+	}
+	db, err := NewMultiDB(tmpdir, "sqlite3")
+	if err != nil {
+		t.Fatalf(`error creating MultiDB: %s`, err)
+	}
+	defer db.Delete()
+	p := DefaultParams()
+	for _, name := range []string{"Carol", "Alice", "Bob"} {
+		email := strings.ToLower(name) + "@test.com"
+		if _, _, err := db.NewUser(name, email, GenerateKey("a password", GenerateExternalSalt(p), p)); err != nil {
+			t.Fatalf(`could not create new user "%s": %s`, name, err)
+		}
+	}
+	users, code, err := db.Users(0, 0)
+	if err != nil {
+		t.Fatalf(`MultiDB.Users() failed with errcode=%d: %s`, code, err)
+	}
+	if len(users) != 3 || users[0].Name() != "Alice" || users[2].Name() != "Carol" {
+		t.Fatalf(`MultiDB.Users() returned %d users in the wrong order`, len(users))
+	}
+	if users[0].Email() != "alice@test.com" || users[0].Created().IsZero() || users[0].Modified().IsZero() {
+		t.Errorf(`MultiDB.Users() did not return the email and dates of the user`)
+	}
+	if users, _, _ := db.Users(1, 1); len(users) != 1 || users[0].Name() != "Bob" {
+		t.Errorf(`MultiDB.Users() with offset and limit returned %v`, users)
+	}
+	if users, _, _ := db.FindUsers("%AR%"); len(users) != 1 || users[0].Name() != "Carol" {
+		t.Errorf(`MultiDB.FindUsers() returned %v`, users)
+	}
+	if users, _, _ := db.FindUsers("bob@%"); len(users) != 1 || users[0].ID() == 0 {
+		t.Errorf(`MultiDB.FindUsers() by email returned %v`, users)
+	}
+}
+
+func TestMultiDBRoles(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "multidb")
+	if err != nil {
+		t.Fatalf(`could not create temporary directory for testing`)
+	}
+	db, err := NewMultiDB(tmpdir, "sqlite3")
+	if err != nil {
+		t.Fatalf(`error creating MultiDB: %s`, err)
+	}
+	defer db.Delete()
+	p := DefaultParams()
+	user, _, err := db.NewUser("John", "john@test.com", GenerateKey("a password", GenerateExternalSalt(p), p))
+	if err != nil {
+		t.Fatalf(`could not create new user "John": %s`, err)
+	}
+	if code, _ := db.AssignRole(user, "not a role"); code != ErrInvalidRole {
+		t.Errorf(`expected errcode=%d for AssignRole with an invalid role, given %d`, ErrInvalidRole, code)
+	}
+	for _, role := range []Role{RoleUser, "editor", RoleUser} {
+		if code, err := db.AssignRole(user, role); err != nil {
+			t.Errorf(`MultiDB.AssignRole() failed with errcode=%d: %s`, code, err)
+		}
+	}
+	if roles, _, err := db.Roles(user); err != nil || len(roles) != 2 || roles[0] != "editor" || roles[1] != RoleUser {
+		t.Errorf(`MultiDB.Roles() returned %v, %v`, roles, err)
+	}
+	if code, err := db.RevokeRole(user, "editor"); err != nil {
+		t.Errorf(`MultiDB.RevokeRole() failed with errcode=%d: %s`, code, err)
+	}
+	if db.HasRole(user, "editor") || !db.HasRole(user, RoleUser) {
+		t.Errorf(`MultiDB.HasRole() returned wrong results after RevokeRole`)
+	}
+	if _, err := db.DeleteUser(user); err != nil {
+		t.Errorf(`MultiDB.DeleteUser() failed: %s`, err)
+	}
+	if n, _ := db.system.Count("UserRole"); n != 0 {
+		t.Errorf(`MultiDB.DeleteUser() left %d roles of the user`, n)
+	}
+}
+
+func TestMultiDBLockout(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "multidb")
+	if err != nil {
+		t.Fatalf(`could not create temporary directory for testing`)
+	}
+	db, err := NewMultiDB(tmpdir, "sqlite3")
+	if err != nil {
+		t.Fatalf(`error creating MultiDB: %s`, err)
+	}
+	defer db.Delete()
+	p := DefaultParams()
+	salt := GenerateExternalSalt(p)
+	user, _, err := db.NewUser("John", "john@test.com", GenerateKey("a password", salt, p))
+	if err != nil {
+		t.Fatalf(`could not create new user "John": %s`, err)
+	}
+	if code, err := db.DisableUser(user); err != nil || !db.UserDisabled(user) {
+		t.Errorf(`MultiDB.DisableUser() failed with errcode=%d: %s`, code, err)
+	}
+	if _, code, _ := db.Authenticate("John", GenerateKey("a password", salt, p)); code != ErrUserDisabled {
+		t.Errorf(`expected errcode=%d for Authenticate of a disabled user, given %d`, ErrUserDisabled, code)
+	}
+	if code, err := db.EnableUser(user); err != nil || db.UserDisabled(user) {
+		t.Errorf(`MultiDB.EnableUser() failed with errcode=%d: %s`, code, err)
+	}
+	db.SetLockout(3, time.Minute, time.Hour)
+	for i := 0; i < 2; i++ {
+		db.Authenticate("John", GenerateKey("wrong password", salt, p))
+	}
+	// a successful authentication resets the failed attempts
+	if _, code, err := db.Authenticate("John", GenerateKey("a password", salt, p)); err != nil {
+		t.Errorf(`MultiDB.Authenticate() failed with errcode=%d: %s`, code, err)
+	}
+	for i := 0; i < 3; i++ {
+		if _, code, _ := db.Authenticate("John", GenerateKey("wrong password", salt, p)); code != ErrAuthenticationFailed {
+			t.Errorf(`expected errcode=%d for a wrong password, given %d`, ErrAuthenticationFailed, code)
+		}
+	}
+	if _, code, _ := db.Authenticate("John", GenerateKey("a password", salt, p)); code != ErrUserLocked {
+		t.Errorf(`expected errcode=%d for Authenticate of a locked user, given %d`, ErrUserLocked, code)
+	}
+	if db.LockedUntil(user).Before(time.Now().Add(59 * time.Minute)) {
+		t.Errorf(`MultiDB.LockedUntil() returned %s`, db.LockedUntil(user))
+	}
+	db.EnableUser(user)
+	if _, code, err := db.Authenticate("John", GenerateKey("a password", salt, p)); err != nil {
+		t.Errorf(`MultiDB.Authenticate() after EnableUser failed with errcode=%d: %s`, code, err)
+	}
+	// concurrent failed attempts must all be counted
+	db.SetLockout(8, time.Minute, time.Hour)
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			db.loginFailed(user.id)
+		}()
+	}
+	wg.Wait()
+	if !db.LockedUntil(user).After(time.Now()) {
+		t.Errorf(`expected the user to be locked after 8 concurrent failed attempts`)
+	}
+}
+
+func TestMultiDBSessions(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "multidb")
+	if err != nil {
+		t.Fatalf(`could not create temporary directory for testing`)
+	}
+	db, err := NewMultiDB(tmpdir, "sqlite3")
+	if err != nil {
+		t.Fatalf(`error creating MultiDB: %s`, err)
+	}
+	defer db.Delete()
+	p := DefaultParams()
+	user, _, err := db.NewUser("John", "john@test.com", GenerateKey("a password", GenerateExternalSalt(p), p))
+	if err != nil {
+		t.Fatalf(`could not create new user "John": %s`, err)
+	}
+	token, code, err := db.CreateSession(user, time.Hour)
+	if err != nil {
+		t.Fatalf(`MultiDB.CreateSession() failed with errcode=%d: %s`, code, err)
+	}
+	if found, code, err := db.ValidateSession(token); err != nil || found.Name() != "John" || found.ID() != user.ID() {
+		t.Errorf(`MultiDB.ValidateSession() failed with errcode=%d: %v`, code, err)
+	}
+	if _, code, _ := db.ValidateSession(token + "x"); code != ErrInvalidSession {
+		t.Errorf(`expected errcode=%d for an unknown token, given %d`, ErrInvalidSession, code)
+	}
+	expired, _, _ := db.CreateSession(user, -time.Second)
+	if _, code, _ := db.ValidateSession(expired); code != ErrSessionExpired {
+		t.Errorf(`expected errcode=%d for an expired session, given %d`, ErrSessionExpired, code)
+	}
+	db.DisableUser(user)
+	if _, code, _ := db.ValidateSession(token); code != ErrUserDisabled {
+		t.Errorf(`expected errcode=%d for a session of a disabled user, given %d`, ErrUserDisabled, code)
+	}
+	db.EnableUser(user)
+	if code, err := db.RevokeSession(token); err != nil {
+		t.Errorf(`MultiDB.RevokeSession() failed with errcode=%d: %s`, code, err)
+	}
+	if _, code, _ := db.ValidateSession(token); code != ErrInvalidSession {
+		t.Errorf(`expected errcode=%d for a revoked session, given %d`, ErrInvalidSession, code)
+	}
+	token, _, _ = db.CreateSession(user, time.Hour)
+	db.RevokeSessions(user)
+	if _, code, _ := db.ValidateSession(token); code != ErrInvalidSession {
+		t.Errorf(`expected errcode=%d after RevokeSessions, given %d`, ErrInvalidSession, code)
+	}
+}
+
+// zipDir packs the files of the directory below the prefix into a zip archive.
+func zipDir(t *testing.T, dir, prefix, archive string) {
+	f, err := os.Create(archive)
+	if err != nil {
+		t.Fatalf(`could not create archive: %s`, err)
+	}
+	defer f.Close()
+	w := zip.NewWriter(f)
+	files, _ := ioutil.ReadDir(dir)
+	for _, file := range files {
+		data, _ := ioutil.ReadFile(filepath.Join(dir, file.Name()))
+		entry, _ := w.Create(prefix + file.Name())
+		entry.Write(data)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf(`could not write archive: %s`, err)
+	}
+}
+
+func TestMultiDBRestoreUser(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "multidb")
+	if err != nil {
+		t.Fatalf(`could not create temporary directory for testing`)
+	}
+	archivedir, _ := ioutil.TempDir("", "multidb-archive")
+	defer os.RemoveAll(archivedir)
+	db, err := NewMultiDB(tmpdir, "sqlite3")
+	if err != nil {
+		t.Fatalf(`error creating MultiDB: %s`, err)
+	}
+	defer db.Delete()
+	p := DefaultParams()
+	salt := GenerateExternalSalt(p)
+	user, _, err := db.NewUser("John", "john@test.com", GenerateKey("a password", salt, p))
+	if err != nil {
+		t.Fatalf(`could not create new user "John": %s`, err)
+	}
+	userdb, _, _ := db.UserDB(user)
+	tx, _ := userdb.Begin()
+	tx.SetStr(1, "archived")
+	tx.Commit()
+	userdb.Close()
+	// like ArchiveUser, with the files in a top-level directory
+	if _, err := db.writeUserRecord(user); err != nil {
+		t.Fatalf(`MultiDB.writeUserRecord() failed: %s`, err)
+	}
+	archive := filepath.Join(archivedir, "John-1_2020.multidb")
+	zipDir(t, db.UserDir(user), "John/", archive)
+	if _, err := db.DeleteUser(user); err != nil {
+		t.Fatalf(`MultiDB.DeleteUser() failed: %s`, err)
+	}
+	restored, code, err := db.RestoreUser(archive)
+	if err != nil {
+		t.Fatalf(`MultiDB.RestoreUser() failed with errcode=%d: %s`, code, err)
+	}
+	if _, code, err := db.Authenticate("John", GenerateKey("a password", salt, p)); err != nil {
+		t.Errorf(`MultiDB.Authenticate() of a restored user failed with errcode=%d: %s`, code, err)
+	}
+	if s, _, _ := db.UserEmail(restored); s != "john@test.com" {
+		t.Errorf(`MultiDB.RestoreUser() restored the email "%s"`, s)
+	}
+	userdb, _, _ = db.UserDB(restored)
+	if s := userdb.GetStr(1); s != "archived" {
+		t.Errorf(`MultiDB.RestoreUser() did not restore the user data, given "%s"`, s)
+	}
+	// restoring an existing user replaces the data
+	tx, _ = userdb.Begin()
+	tx.SetStr(1, "changed")
+	tx.Commit()
+	if _, code, err := db.RestoreUser(archive); err != nil {
+		t.Fatalf(`MultiDB.RestoreUser() of an existing user failed with errcode=%d: %s`, code, err)
+	}
+	userdb, _, _ = db.UserDB(restored)
+	if s := userdb.GetStr(1); s != "archived" {
+		t.Errorf(`MultiDB.RestoreUser() of an existing user did not replace the data, given "%s"`, s)
+	}
+	// a registration that fails does not leave a user behind
+	before, _ := db.system.Count("User")
+	db.system.base.Exec(`DROP TABLE UserParams;`)
+	record := &userRecord{Username: "Jane", Email: "jane@test.com", Created: "2020-01-01T00:00:00Z",
+		Modified: "2020-01-01T00:00:00Z", Params: p}
+	if _, _, err := db.registerUser(record); err == nil {
+		t.Errorf(`MultiDB.registerUser() succeeded without the UserParams table`)
+	}
+	if after, _ := db.system.Count("User"); after != before {
+		t.Errorf(`a failed MultiDB.registerUser() changed the number of users from %d to %d`, before, after)
+	}
+}
+
+func TestMultiDBUserEncryption(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "multidb")
+	if err != nil {
+		t.Fatalf(`could not create temporary directory for testing`)
+	}
+	db, err := NewMultiDB(tmpdir, "sqlite3")
+	if err != nil {
+		t.Fatalf(`error creating MultiDB: %s`, err)
+	}
+	defer db.Delete()
+	p := DefaultParams()
+	plain, _, err := db.NewUser("Bob", "bob@test.com", GenerateKey("a password", GenerateExternalSalt(p), p))
+	if err != nil || plain.dataKey != nil {
+		t.Fatalf(`NewUser() without user encryption returned a key or failed: %v`, err)
+	}
+	db.SetUserEncryption(true)
+	salt := GenerateExternalSalt(p)
+	user, _, err := db.NewUser("John", "john@test.com", GenerateKey("old password", salt, p))
+	if err != nil {
+		t.Fatalf(`could not create new user "John": %s`, err)
+	}
+	if len(user.dataKey) != dataKeyLength {
+		t.Fatalf(`NewUser() with user encryption returned no database key`)
+	}
+	authenticated, _, err := db.Authenticate("John", GenerateKey("old password", salt, p))
+	if err != nil || !bytes.Equal(authenticated.dataKey, user.dataKey) {
+		t.Errorf(`MultiDB.Authenticate() did not unwrap the database key: %v`, err)
+	}
+	newSalt := GenerateExternalSalt(p)
+	if code, err := db.ChangePassword(user, GenerateKey("old password", salt, p), GenerateKey("new password", newSalt, p)); err != nil {
+		t.Fatalf(`MultiDB.ChangePassword() failed with errcode=%d: %s`, code, err)
+	}
+	authenticated, _, err = db.Authenticate("John", GenerateKey("new password", newSalt, p))
+	if err != nil || !bytes.Equal(authenticated.dataKey, user.dataKey) {
+		t.Errorf(`MultiDB.ChangePassword() did not keep the database key: %v`, err)
+	}
+	if _, code, _ := db.UserDB(&User{name: "John", id: user.id}); code != ErrInvalidKey {
+		t.Errorf(`expected errcode=%d for UserDB of an unauthenticated user, given %d`, ErrInvalidKey, code)
+	}
+	if _, _, err := db.UserDB(plain); err != nil {
+		t.Errorf(`MultiDB.UserDB() of a user without encryption failed: %s`, err)
+	}
+	userdb, _, err := db.UserDB(authenticated)
+	if err == ErrEncryptionUnsupported {
+		t.Skip("the sqlite3 driver has not been built with SQLCipher")
+	}
+	if err != nil {
+		t.Fatalf(`MultiDB.UserDB() of an encrypted user failed: %s`, err)
+	}
+	defer userdb.Close()
+	if !userdb.Encrypted() {
+		t.Errorf(`the database of user "John" is not encrypted`)
+	}
+}
+
+func TestMultiDBGroups(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "multidb")
+	if err != nil {
+		t.Fatalf(`could not create temporary directory for testing`)
+	}
+	db, err := NewMultiDB(tmpdir, "sqlite3")
+	if err != nil {
+		t.Fatalf(`error creating MultiDB: %s`, err)
+	}
+	defer db.Delete()
+	p := DefaultParams()
+	john, _, _ := db.NewUser("John", "john@test.com", GenerateKey("a password", GenerateExternalSalt(p), p))
+	bob, _, _ := db.NewUser("Bob", "bob@test.com", GenerateKey("a password", GenerateExternalSalt(p), p))
+	eve, _, _ := db.NewUser("Eve", "eve@test.com", GenerateKey("a password", GenerateExternalSalt(p), p))
+	group, code, err := db.NewGroup("Team")
+	if err != nil {
+		t.Fatalf(`MultiDB.NewGroup() failed with errcode=%d: %s`, code, err)
+	}
+	if _, code, _ := db.NewGroup("Team"); code != ErrGroupInUse {
+		t.Errorf(`expected errcode=%d for NewGroup with a name in use, given %d`, ErrGroupInUse, code)
+	}
+	for _, user := range []*User{john, bob} {
+		if code, err := db.AddMember(group, user); err != nil {
+			t.Errorf(`MultiDB.AddMember() failed with errcode=%d: %s`, code, err)
+		}
+	}
+	if members, _, err := db.Members(group); err != nil || len(members) != 2 || members[0].Name() != "Bob" {
+		t.Errorf(`MultiDB.Members() returned %v, %v`, members, err)
+	}
+	if groups, _, err := db.Groups(john); err != nil || len(groups) != 1 || groups[0].Name() != "Team" {
+		t.Errorf(`MultiDB.Groups() returned %v, %v`, groups, err)
+	}
+	johndb, code, err := db.GroupDB(group, john)
+	if err != nil {
+		t.Fatalf(`MultiDB.GroupDB() failed with errcode=%d: %s`, code, err)
+	}
+	tx, _ := johndb.Begin()
+	tx.SetStr(1, "shared")
+	tx.Commit()
+	found, _, _ := db.FindGroup("Team")
+	bobdb, _, err := db.GroupDB(found, bob)
+	if err != nil || bobdb.GetStr(1) != "shared" {
+		t.Errorf(`MultiDB.GroupDB() does not share the database between members: %v`, err)
+	}
+	if _, code, _ := db.GroupDB(group, eve); code != ErrNotMember {
+		t.Errorf(`expected errcode=%d for GroupDB of a non-member, given %d`, ErrNotMember, code)
+	}
+	db.RemoveMember(group, bob)
+	if db.IsMember(group, bob) || !db.IsMember(group, john) {
+		t.Errorf(`MultiDB.RemoveMember() did not remove the member`)
+	}
+	if code, err := db.DeleteGroup(group); err != nil {
+		t.Errorf(`MultiDB.DeleteGroup() failed with errcode=%d: %s`, code, err)
+	}
+	if _, code, _ := db.FindGroup("Team"); code != ErrUnknownGroup || validDir(db.GroupDir(group)) {
+		t.Errorf(`MultiDB.DeleteGroup() did not delete the group`)
+	}
+}
+
+func TestMultiDBUserDBCache(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "multidb")
+	if err != nil {
+		t.Fatalf(`could not create temporary directory for testing`)
+	}
+	db, err := NewMultiDB(tmpdir, "sqlite3")
+	if err != nil {
+		t.Fatalf(`error creating MultiDB: %s`, err)
+	}
+	defer db.Delete()
+	p := DefaultParams()
+	users := make([]*User, 0, 3)
+	for _, name := range []string{"Alice", "Bob", "Carol"} {
+		user, _, err := db.NewUser(name, strings.ToLower(name)+"@test.com", GenerateKey("a password", GenerateExternalSalt(p), p))
+		if err != nil {
+			t.Fatalf(`could not create new user "%s": %s`, name, err)
+		}
+		users = append(users, user)
+	}
+	first, _, _ := db.UserDB(users[0])
+	if again, _, _ := db.UserDB(users[0]); again != first {
+		t.Errorf(`MultiDB.UserDB() opened the database of a user again`)
+	}
+	db.SetMaxOpenUserDBs(2)
+	db.UserDB(users[1])
+	db.UserDB(users[0])
+	db.UserDB(users[2])
+	if n := db.OpenUserDBs(); n != 2 {
+		t.Errorf(`MultiDB.OpenUserDBs() returned %d, expected 2`, n)
+	}
+	// the least recently used database of Bob has been closed
+	if again, _, _ := db.UserDB(users[0]); again != first {
+		t.Errorf(`MultiDB.UserDB() evicted a recently used database`)
+	}
+	db.SetUserDBIdleTimeout(20 * time.Millisecond)
+	deadline := time.Now().Add(5 * time.Second)
+	for db.OpenUserDBs() > 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := db.OpenUserDBs(); n != 0 {
+		t.Errorf(`MultiDB.SetUserDBIdleTimeout() left %d idle databases open`, n)
+	}
+	userdb, _, err := db.UserDB(users[1])
+	if err != nil || userdb.GetStr(1) != "" {
+		t.Errorf(`MultiDB.UserDB() after closing idle databases failed: %v`, err)
+	}
+	// a database in a transaction is not evicted, and Close leaves cached databases open
+	db.SetUserDBIdleTimeout(0)
+	db.SetMaxOpenUserDBs(1)
+	alice, _, _ := db.UserDB(users[0])
+	tx, err := alice.Begin()
+	if err != nil {
+		t.Fatalf(`Begin() failed: %s`, err)
+	}
+	tx.SetStr(1, "hello")
+	db.UserDB(users[1])
+	if err := tx.Commit(); err != nil {
+		t.Errorf(`Commit() failed after opening another user database: %s`, err)
+	}
+	if again, _, _ := db.UserDB(users[0]); again != alice || alice.GetStr(1) != "hello" {
+		t.Errorf(`MultiDB.UserDB() evicted a database in a transaction`)
+	}
+	alice.Close()
+	if again, _, _ := db.UserDB(users[0]); again != alice || alice.GetStr(1) != "hello" {
+		t.Errorf(`MDB.Close() closed a database of the MultiDB`)
+	}
+}
+
+func TestMultiDBParamsUpgrade(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "multidb")
+	if err != nil {
+		t.Fatalf(`could not create temporary directory for testing`)
+	}
+	db, err := NewMultiDB(tmpdir, "sqlite3")
+	if err != nil {
+		t.Fatalf(`error creating MultiDB: %s`, err)
+	}
+	defer db.Delete()
+	weak := DefaultParams()
+	weak.Argon2Memory = 16 * 1024
+	weak.Argon2Iterations = 2
+	weak.InternalSaltLength = 64
+	salt := GenerateExternalSalt(weak)
+	user, _, err := db.NewUser("John", "john@test.com", GenerateKey("a password", salt, weak))
+	if err != nil {
+		t.Fatalf(`could not create new user "John": %s`, err)
+	}
+	if p := db.userParams(user.ID()); p == nil || *p != *weak {
+		t.Errorf(`MultiDB.NewUser() stored the parameters %v, expected %v`, p, weak)
+	}
+	if _, code, err := db.Authenticate("John", GenerateKey("a password", salt, weak)); err != nil {
+		t.Fatalf(`MultiDB.Authenticate() failed with errcode=%d: %s`, code, err)
+	}
+	if p := db.userParams(user.ID()); p == nil || *p != *DefaultParams() {
+		t.Errorf(`MultiDB.Authenticate() did not upgrade the parameters, given %v`, p)
+	}
+	if result, _ := db.system.Get("User", user.ID(), "InternalSalt"); len(result[0].Bytes()) != int(DefaultParams().InternalSaltLength) {
+		t.Errorf(`MultiDB.Authenticate() did not generate a new internal salt`)
+	}
+	// the upgraded key is verified with the stored parameters, whatever the caller passes
+	for _, p := range []*Params{weak, DefaultParams()} {
+		if _, code, err := db.Authenticate("John", GenerateKey("a password", salt, p)); err != nil {
+			t.Errorf(`MultiDB.Authenticate() after the upgrade failed with errcode=%d: %s`, code, err)
+		}
+	}
+	if _, code, _ := db.Authenticate("John", GenerateKey("wrong password", salt, weak)); code != ErrAuthenticationFailed {
+		t.Errorf(`expected errcode=%d for a wrong password after the upgrade, given %d`, ErrAuthenticationFailed, code)
+	}
+}
+
+func TestExecutorMultiDB(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "multidb")
+	if err != nil {
+		t.Fatalf(`could not create temporary directory for testing`)
+	}
+	db, err := NewMultiDB(tmpdir, "sqlite3")
+	if err != nil {
+		t.Fatalf(`error creating MultiDB: %s`, err)
+	}
+	defer db.Delete()
+	e := NewExecutor()
+	defer e.CloseAllDBs()
+	if r := e.Exec(NewUserCommand("John", "john@test.com", "a password")); !r.HasError || r.Int != ErrNoMultiDB {
+		t.Errorf(`NewUser command without a multiuser database returned %v, expected ErrNoMultiDB`, r)
+	}
+	e.SetMultiDB(db)
+	r := e.Exec(NewUserCommand("John", "john@test.com", "a password"))
+	if r.HasError || r.Int == 0 {
+		t.Fatalf(`NewUser command failed: %s`, r.Str)
+	}
+	if r := e.Exec(NewUserCommand("John", "john@test.com", "a password")); !r.HasError || r.Ints[0] != int64(ErrUsernameInUse) {
+		t.Errorf(`NewUser command for an existing user returned %v, expected ErrUsernameInUse`, r)
+	}
+	if r := e.Exec(AuthenticateCommand("John", "wrong password", 0)); !r.HasError || r.Ints[0] != int64(ErrAuthenticationFailed) {
+		t.Errorf(`Authenticate command with a wrong password returned %v, expected ErrAuthenticationFailed`, r)
+	}
+	r = e.Exec(AuthenticateCommand("John", "a password", time.Hour))
+	if r.HasError || r.Str == "" {
+		t.Fatalf(`Authenticate command failed: %s`, r.Str)
+	}
+	token := r.Str
+	r = e.Exec(UserDBCommand(token))
+	if r.HasError {
+		t.Fatalf(`UserDB command failed: %s`, r.Str)
+	}
+	userDB := CommandDB(r.Str)
+	if r := e.Exec(AddTableCommand(userDB, "Person", []Field{Field{Name: "Name", Sort: DBString}})); r.HasError {
+		t.Errorf(`AddTable command on the user database failed: %s`, r.Str)
+	}
+	if r := e.Exec(CountCommand(userDB, "Person")); r.HasError || r.Int != 0 {
+		t.Errorf(`Count command on the user database returned %v`, r)
+	}
+	if r := e.Exec(CloseCommand(userDB)); r.HasError {
+		t.Errorf(`Close command on the user database failed: %s`, r.Str)
+	}
+	if r := e.Exec(ChangePasswordCommand(token, "a password", "another password")); r.HasError {
+		t.Errorf(`ChangePassword command failed: %s`, r.Str)
+	}
+	if r := e.Exec(ArchiveUserCommand("John", tmpdir)); !r.HasError || r.Int != ErrNotPermitted {
+		t.Errorf(`ArchiveUser command without admin rights returned %v, expected ErrNotPermitted`, r)
+	}
+	if r := e.Exec(LogoutCommand(token)); r.HasError {
+		t.Errorf(`Logout command failed: %s`, r.Str)
+	}
+	if r := e.Exec(CountCommand(userDB, "Person")); !r.HasError {
+		t.Errorf(`Count command on the user database should fail after logging out`)
+	}
+	r = e.Exec(AuthenticateCommand("John", "another password", 0))
+	if r.HasError {
+		t.Fatalf(`Authenticate command with the new password failed: %s`, r.Str)
+	}
+	if r := e.Exec(DeleteUserCommand(r.Str)); r.HasError {
+		t.Errorf(`DeleteUser command failed: %s`, r.Str)
+	}
+	if db.ExistingUser("John") {
+		t.Errorf(`DeleteUser command did not delete the user`)
+	}
+}
+
+func TestMultiDBExportUserData(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "multidb")
+	if err != nil {
+		t.Fatalf(`could not create temporary directory for testing`)
+	}
+	db, err := NewMultiDB(tmpdir, "sqlite3")
+	if err != nil {
+		t.Fatalf(`error creating MultiDB: %s`, err)
+	}
+	defer db.Delete()
+	salt := GenerateExternalSalt(DefaultParams())
+	user, _, err := db.NewUser("John", "john@test.com", GenerateKey("a password", salt, DefaultParams()))
+	if err != nil {
+		t.Fatalf(`could not create new user "John": %s`, err)
+	}
+	db.AssignRole(user, RoleUser)
+	group, _, err := db.NewGroup("team")
+	if err != nil {
+		t.Fatalf(`could not create group "team": %s`, err)
+	}
+	db.AddMember(group, user)
+	userdb, _, err := db.UserDB(user)
+	if err != nil {
+		t.Fatalf(`MultiDB.UserDB() failed: %s`, err)
+	}
+	if err := userdb.AddTable("Person", []Field{Field{Name: "Name", Sort: DBString}}); err != nil {
+		t.Fatalf(`AddTable() failed: %s`, err)
+	}
+	tx, _ := userdb.Begin()
+	tx.NewItems("Person", [][]FieldValue{{{Field: "Name", Values: []Value{NewString("Jane")}}}})
+	tx.SetStrKey("nickname", "Johnny")
+	if err := tx.Commit(); err != nil {
+		t.Fatalf(`Commit() failed: %s`, err)
+	}
+	var buf bytes.Buffer
+	if code, err := db.ExportUserData(user, &buf); err != nil {
+		t.Fatalf(`MultiDB.ExportUserData() failed with errcode=%d: %s`, code, err)
+	}
+	var bundle struct {
+		User     exportedUser    `json:"user"`
+		Database json.RawMessage `json:"database"`
+		KeyValue json.RawMessage `json:"keyvalue"`
+	}
+	if err := json.Unmarshal(buf.Bytes(), &bundle); err != nil {
+		t.Fatalf(`MultiDB.ExportUserData() wrote invalid JSON: %s`, err)
+	}
+	if bundle.User.Username != "John" || bundle.User.Email != "john@test.com" || bundle.User.Created == "" {
+		t.Errorf(`MultiDB.ExportUserData() exported the user %v`, bundle.User)
+	}
+	if len(bundle.User.Roles) != 1 || bundle.User.Roles[0] != RoleUser || len(bundle.User.Groups) != 1 || bundle.User.Groups[0] != "team" {
+		t.Errorf(`MultiDB.ExportUserData() exported the roles %v and groups %v`, bundle.User.Roles, bundle.User.Groups)
+	}
+	if !strings.Contains(string(bundle.Database), `"Jane"`) || !strings.Contains(string(bundle.KeyValue), `"Johnny"`) {
+		t.Errorf(`MultiDB.ExportUserData() did not export the user database: %s`, buf.String())
+	}
+	if bytes.Contains(buf.Bytes(), []byte("salt")) {
+		t.Errorf(`MultiDB.ExportUserData() should not export salts: %s`, buf.String())
+	}
+	if code, _ := db.ExportUserData(&User{name: "Nobody"}, &buf); code != ErrUnknownUser {
+		t.Errorf(`expected errcode=%d when exporting an unknown user, given %d`, ErrUnknownUser, code)
+	}
+}
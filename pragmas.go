@@ -0,0 +1,132 @@
+package minidb
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// ------------------------------------------------------------------------------
+// Connection settings given by Options
+// ------------------------------------------------------------------------------
+
+// journalModes are the journal modes accepted by Options.JournalMode.
+var journalModes = map[string]bool{"DELETE": true, "TRUNCATE": true, "PERSIST": true, "MEMORY": true, "WAL": true, "OFF": true}
+
+// synchronousLevels are the levels accepted by Options.Synchronous.
+var synchronousLevels = map[string]bool{"OFF": true, "NORMAL": true, "FULL": true, "EXTRA": true}
+
+// pragmas returns the PRAGMA statements for the connection settings of the options.
+func (options *Options) pragmas() ([]string, error) {
+	pragmas := make([]string, 0)
+	if options.JournalMode != "" {
+		mode := strings.ToUpper(options.JournalMode)
+		if !journalModes[mode] {
+			return nil, Fail("invalid journal mode '%s'", options.JournalMode)
+		}
+		pragmas = append(pragmas, "PRAGMA journal_mode="+mode+";")
+	}
+	if options.ForeignKeys {
+		pragmas = append(pragmas, "PRAGMA foreign_keys=ON;")
+	}
+	if options.BusyTimeout < 0 {
+		return nil, Fail("invalid busy timeout %s", options.BusyTimeout)
+	}
+	if options.BusyTimeout > 0 {
+		pragmas = append(pragmas, fmt.Sprintf("PRAGMA busy_timeout=%d;", options.BusyTimeout.Milliseconds()))
+	}
+	if options.Synchronous != "" {
+		level := strings.ToUpper(options.Synchronous)
+		if !synchronousLevels[level] {
+			return nil, Fail("invalid synchronous level '%s'", options.Synchronous)
+		}
+		pragmas = append(pragmas, "PRAGMA synchronous="+level+";")
+	}
+	if options.CacheSize != 0 {
+		pragmas = append(pragmas, fmt.Sprintf("PRAGMA cache_size=%d;", options.CacheSize))
+	}
+	return pragmas, nil
+}
+
+// pragmaConnector opens connections of a driver and applies the pragmas to each of them, since
+// most settings of SQLite only apply to the connection that sets them, while sql.DB opens
+// new connections whenever it needs them. If the retry policy allows retries, the statements
+// of the connections are retried while the database is locked. If there is an encryption key,
+// it is applied before the pragmas, see Options.EncryptionKey.
+type pragmaConnector struct {
+	driver  driver.Driver
+	name    string
+	pragmas []string
+	retry   busyRetry
+	keyLock sync.Mutex
+	key     string
+}
+
+func (c *pragmaConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	conn, err := c.driver.Open(c.name)
+	if err != nil {
+		return nil, err
+	}
+	if key := c.currentKey(); key != "" {
+		if err := applyKey(conn, key); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	for _, pragma := range c.pragmas {
+		if err := execPragma(conn, pragma); err != nil {
+			conn.Close()
+			return nil, Fail("cannot apply %s: %s", strings.TrimSuffix(pragma, ";"), err)
+		}
+	}
+	if c.retry.retries > 0 {
+		return &busyConn{Conn: conn, retry: c.retry}, nil
+	}
+	return conn, nil
+}
+
+func (c *pragmaConnector) Driver() driver.Driver {
+	return c.driver
+}
+
+// execPragma executes a PRAGMA statement on a connection of a driver.
+func execPragma(conn driver.Conn, pragma string) error {
+	stmt, err := conn.Prepare(pragma)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	_, err = stmt.Exec(nil)
+	return err
+}
+
+// currentKey returns the encryption key applied to new connections.
+func (c *pragmaConnector) currentKey() string {
+	c.keyLock.Lock()
+	defer c.keyLock.Unlock()
+	return c.key
+}
+
+// setKey sets the encryption key applied to new connections.
+func (c *pragmaConnector) setKey(key string) {
+	c.keyLock.Lock()
+	defer c.keyLock.Unlock()
+	c.key = key
+}
+
+// openWithPragmas opens a database like sql.Open, applying the encryption key, the pragmas and
+// the retry policy to every connection. The connector is nil if there is nothing to apply.
+func openWithPragmas(driverName string, file string, pragmas []string, retry busyRetry,
+	key string) (*sql.DB, *pragmaConnector, error) {
+	db, err := sql.Open(driverName, file)
+	if err != nil || (len(pragmas) == 0 && retry.retries == 0 && key == "") {
+		return db, nil, err
+	}
+	d := db.Driver()
+	db.Close()
+	c := &pragmaConnector{driver: d, name: file, pragmas: pragmas, retry: retry, key: key}
+	return sql.OpenDB(c), c, nil
+}
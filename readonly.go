@@ -0,0 +1,30 @@
+package minidb
+
+// ------------------------------------------------------------------------------
+// Read-only executors
+// ------------------------------------------------------------------------------
+
+// SetReadOnly makes the executor reject all commands that may change a database if on is true.
+// They fail with ErrReadOnly and a result whose Err wraps ErrReadOnlyExecutor. Read commands,
+// read-only transactions, cursors, and sessions of a multiuser database remain available, so a
+// server can offer a reporting endpoint with a read-only executor next to one for its regular
+// clients. Batches that are executed in transactions use read-only transactions.
+func (e *Executor) SetReadOnly(on bool) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.readOnly = on
+}
+
+// isReadOnly returns true if the executor rejects commands that may change a database.
+func (e *Executor) isReadOnly() bool {
+	e.mutex.RLock()
+	defer e.mutex.RUnlock()
+	return e.readOnly
+}
+
+// readOnlyPermits returns true if a read-only executor may execute commands of the type. Begin
+// is rejected because a write transaction blocks the writers of other executors.
+func readOnlyPermits(id CommandID) bool {
+	return readCommands[id] || (sessionCommands[id] && id != CmdBegin) ||
+		id == CmdPing || id == CmdRegisterTemplate
+}
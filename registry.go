@@ -0,0 +1,109 @@
+package minidb
+
+import "sync"
+
+// ------------------------------------------------------------------------------
+// Registry of the databases and transactions opened by an executor
+// ------------------------------------------------------------------------------
+
+// openDB is a database opened by an executor. Its mutex protects the other fields, so that
+// commands on different databases never wait for each other.
+type openDB struct {
+	db          *MDB
+	connections int
+	backups     *BackupManager
+	queue       *writeQueue
+	closed      bool
+	mutex       sync.Mutex
+}
+
+// lookupDB returns the open database with the name, or nil if it is not open. It does not lock.
+func (e *Executor) lookupDB(name CommandDB) *openDB {
+	if entry, ok := e.dbs.Load(name); ok {
+		return entry.(*openDB)
+	}
+	return nil
+}
+
+// openDBs returns the open databases.
+func (e *Executor) openDBs() map[CommandDB]*openDB {
+	dbs := make(map[CommandDB]*openDB)
+	e.dbs.Range(func(name, entry interface{}) bool {
+		dbs[name.(CommandDB)] = entry.(*openDB)
+		return true
+	})
+	return dbs
+}
+
+// openDatabase opens the database for another client, or for the first one if it is not open
+// yet. Only opening and closing databases wait for each other.
+func (e *Executor) openDatabase(driver string, name CommandDB) error {
+	e.registry.Lock()
+	defer e.registry.Unlock()
+	if entry := e.lookupDB(name); entry != nil {
+		entry.mutex.Lock()
+		entry.connections++
+		entry.mutex.Unlock()
+		return nil
+	}
+	theDB, err := Open(driver, string(name))
+	if err != nil {
+		return err
+	}
+	entry := &openDB{db: theDB, connections: 1}
+	e.mutex.RLock()
+	schedule := e.backupSchedule
+	e.mutex.RUnlock()
+	if schedule != nil {
+		if entry.backups, err = NewBackupManager(theDB, *schedule); err != nil {
+			theDB.Close()
+			return err
+		}
+	}
+	e.dbs.Store(name, entry)
+	return nil
+}
+
+// closeDatabase closes the database for a client and returns true if it has been closed
+// because it was the last client.
+func (e *Executor) closeDatabase(name CommandDB) (bool, error) {
+	e.registry.Lock()
+	defer e.registry.Unlock()
+	entry := e.lookupDB(name)
+	if entry == nil {
+		return false, Fail("exec failed: db '%s' unknown", name)
+	}
+	entry.mutex.Lock()
+	if entry.connections > 1 {
+		entry.connections--
+		entry.mutex.Unlock()
+		return false, nil
+	}
+	e.dbs.Delete(name)
+	entry.close()
+	entry.mutex.Unlock()
+	return true, entry.db.Close()
+}
+
+// close stops the backups and the write queue of the database. Commands that are still waiting
+// in the queue are executed in the background. The database must be locked by the caller.
+func (entry *openDB) close() {
+	entry.closed = true
+	if entry.backups != nil {
+		entry.backups.Stop()
+		entry.backups = nil
+	}
+	if entry.queue != nil {
+		close(entry.queue.jobs)
+		entry.queue = nil
+	}
+}
+
+// lookupTx returns the open transaction with the ID, or nil if there is none. It does not lock,
+// see acquireTx.
+func (e *Executor) lookupTx(id TxID) *openTx {
+	if tx, ok := e.txs.Load(id); ok {
+		return tx.(*openTx)
+	}
+	return nil
+}
@@ -0,0 +1,262 @@
+package minidb
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// ------------------------------------------------------------------------------
+// Restoring archived users of multiuser databases
+// ------------------------------------------------------------------------------
+
+// userRecordFile is the file in the user directory that holds the user record while the user
+// is archived, so that RestoreUser can register the user again.
+const userRecordFile = "user.json"
+
+// userRecord is the record of a user in the system database as stored in archives.
+type userRecord struct {
+	Username     string  `json:"username"`
+	Email        string  `json:"email"`
+	Key          []byte  `json:"key"`
+	ExternalSalt []byte  `json:"externalsalt"`
+	InternalSalt []byte  `json:"internalsalt"`
+	Created      string  `json:"created"`
+	Modified     string  `json:"modified"`
+	WrapSalt     []byte  `json:"wrapsalt,omitempty"`
+	DataKey      []byte  `json:"datakey,omitempty"`
+	Params       *Params `json:"params,omitempty"`
+}
+
+// userRecord returns the record of the user in the system database.
+func (m *MultiDB) userRecord(user *User) (*userRecord, error) {
+	values, err := m.system.GetItem("User", user.id)
+	if err != nil {
+		return nil, err
+	}
+	first := func(field string) *Value {
+		if len(values[field]) == 0 {
+			return &Value{}
+		}
+		return &values[field][0]
+	}
+	salt, sealed := m.wrappedKey(user.id)
+	return &userRecord{
+		WrapSalt:     salt,
+		DataKey:      sealed,
+		Params:       m.userParams(user.id),
+		Username:     first("Username").String(),
+		Email:        first("Email").String(),
+		Key:          first("Key").Bytes(),
+		ExternalSalt: first("ExternalSalt").Bytes(),
+		InternalSalt: first("InternalSalt").Bytes(),
+		Created:      first("Created").String(),
+		Modified:     first("Modified").String(),
+	}, nil
+}
+
+// writeUserRecord writes the record of the user to the user directory and returns the path
+// of the file.
+func (m *MultiDB) writeUserRecord(user *User) (string, error) {
+	record, err := m.userRecord(user)
+	if err != nil {
+		return "", err
+	}
+	data, err := json.Marshal(record)
+	if err != nil {
+		return "", err
+	}
+	file := m.userFile(user, userRecordFile)
+	return file, ioutil.WriteFile(file, data, 0600)
+}
+
+// registerUser adds the user of the record to the system database.
+func (m *MultiDB) registerUser(record *userRecord) (*User, ErrCode, error) {
+	if m.ExistingEmail(record.Email) {
+		return nil, ErrEmailInUse, Fail(`email "%s" is already in use!`, record.Email)
+	}
+	tx, err := m.Begin()
+	if err != nil {
+		return nil, ErrTransactionFail, err
+	}
+	defer tx.Rollback()
+	ids, err := tx.NewItems("User", [][]FieldValue{{
+		{Field: "Username", Values: []Value{NewString(record.Username)}},
+		{Field: "Email", Values: []Value{NewString(record.Email)}},
+		{Field: "Key", Values: []Value{NewBytes(record.Key)}},
+		{Field: "ExternalSalt", Values: []Value{NewBytes(record.ExternalSalt)}},
+		{Field: "InternalSalt", Values: []Value{NewBytes(record.InternalSalt)}},
+		{Field: "Created", Values: []Value{NewDateStr(record.Created)}},
+		{Field: "Modified", Values: []Value{NewDateStr(record.Modified)}}}})
+	if err != nil {
+		return nil, ErrDBFail, Fail(`could not register user "%s": %s`, record.Username, err)
+	}
+	id := ids[0]
+	if record.DataKey != nil {
+		if reply, err := storeWrappedKey(tx, id, record.WrapSalt, record.DataKey); err != nil {
+			return nil, reply, err
+		}
+	}
+	if record.Params != nil {
+		if reply, err := storeUserParams(tx, id, record.Params); err != nil {
+			return nil, reply, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, ErrTransactionFail, Fail(`multiuser database error: %s`, err)
+	}
+	return &User{name: record.Username, id: id}, OK, nil
+}
+
+// archivePrefix returns the directory of the archive that contains the user data, which is
+// either the root or a single top-level directory.
+func archivePrefix(files []*zip.File) string {
+	prefix := ""
+	for i, f := range files {
+		name := strings.TrimPrefix(path.Clean(f.Name), "/")
+		if f.FileInfo().IsDir() {
+			name += "/"
+		}
+		j := strings.Index(name, "/")
+		if j < 0 {
+			return ""
+		}
+		dir := name[:j+1]
+		if i > 0 && dir != prefix {
+			return ""
+		}
+		prefix = dir
+	}
+	return prefix
+}
+
+// readUserRecord returns the user record stored in the archive, or nil if there is none.
+func readUserRecord(files []*zip.File, prefix string) (*userRecord, error) {
+	for _, f := range files {
+		if path.Clean(f.Name) != prefix+userRecordFile {
+			continue
+		}
+		r, err := f.Open()
+		if err != nil {
+			return nil, err
+		}
+		defer r.Close()
+		var record userRecord
+		if err := json.NewDecoder(r).Decode(&record); err != nil {
+			return nil, Fail(`invalid user record in archive: %s`, err)
+		}
+		return &record, nil
+	}
+	return nil, nil
+}
+
+// unpackArchive extracts the files below the prefix of the archive into the directory,
+// leaving out the user record.
+func unpackArchive(files []*zip.File, prefix, dir string) error {
+	for _, f := range files {
+		name := strings.TrimPrefix(path.Clean(f.Name), "/")
+		if !strings.HasPrefix(name, prefix) || name == prefix+userRecordFile {
+			continue
+		}
+		name = strings.TrimPrefix(name, prefix)
+		if name == "" || name == "." || name == ".." || strings.HasPrefix(name, "../") {
+			continue
+		}
+		target := filepath.Join(dir, filepath.FromSlash(name))
+		if f.FileInfo().IsDir() {
+			if err := os.MkdirAll(target, 0755); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return err
+		}
+		r, err := f.Open()
+		if err != nil {
+			return err
+		}
+		w, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+		if err != nil {
+			r.Close()
+			return err
+		}
+		_, err = io.Copy(w, r)
+		r.Close()
+		if cerr := w.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RestoreUser restores a user from an archive created by ArchiveUser. If the user still exists,
+// the user's data is replaced by that of the archive and the user's credentials are kept.
+// Otherwise the user is registered again with the credentials stored in the archive, which
+// fails with ErrEmailInUse if another user has registered the email address in the meantime.
+// Roles are not archived, so a user registered again has no roles. The user database is
+// opened again unless it is encrypted, and the restored user is returned.
+func (m *MultiDB) RestoreUser(archivePath string) (*User, ErrCode, error) {
+	archive, err := zip.OpenReader(archivePath)
+	if err != nil {
+		return nil, ErrUnpackFail, Fail(`could not open archive: %s`, err)
+	}
+	defer archive.Close()
+	prefix := archivePrefix(archive.File)
+	record, err := readUserRecord(archive.File, prefix)
+	if err != nil {
+		return nil, ErrUnpackFail, err
+	}
+	username := ""
+	if record != nil {
+		username = record.Username
+	} else if base := filepath.Base(archivePath); strings.Contains(base, "-") {
+		// archives without user record are named after the user by ArchiveUser
+		username = base[:strings.Index(base, "-")]
+	}
+	if err := validateUser(username, m.BaseDir()); err != nil {
+		return nil, ErrInvalidUser, err
+	}
+	var user *User
+	if m.ExistingUser(username) {
+		user = &User{name: username, id: m.userID(username)}
+		if err := m.userdbs.close(user.id); err != nil {
+			return nil, ErrCloseFailed, err
+		}
+		if err := CreateDirIfNotExist(m.UserDir(user)); err != nil {
+			return nil, ErrFileSystem, err
+		}
+		if err := removeContents(m.UserDir(user)); err != nil {
+			return nil, ErrFileSystem, err
+		}
+	} else {
+		if record == nil {
+			return nil, ErrUnknownUser, Fail(`user "%s" does not exist and the archive has no user record`, username)
+		}
+		var reply ErrCode
+		if user, reply, err = m.registerUser(record); err != nil {
+			return nil, reply, err
+		}
+		if err := CreateDirIfNotExist(m.UserDir(user)); err != nil {
+			return nil, ErrFileSystem, err
+		}
+	}
+	if err := unpackArchive(archive.File, prefix, m.UserDir(user)); err != nil {
+		return nil, ErrUnpackFail, Fail(`could not unpack archive: %s`, err)
+	}
+	if m.encryptedUserDB(user.id) {
+		return user, OK, nil
+	}
+	if _, reply, err := m.UserDB(user); err != nil {
+		return nil, reply, err
+	}
+	return user, OK, nil
+}
@@ -0,0 +1,242 @@
+package minidb
+
+// ------------------------------------------------------------------------------
+// Roles of users and their enforcement in the command API
+// ------------------------------------------------------------------------------
+
+// Role is a role of a user of a multiuser database, such as RoleAdmin, or a custom role defined
+// with Executor.DefineRole. Role names follow the rules of user names. An executor restricts
+// the commands of its clients to those permitted by its roles, see Executor.SetRoles.
+type Role string
+
+const (
+	// RoleAdmin may execute all commands and has admin rights, see Executor.SetAdmin.
+	RoleAdmin Role = "admin"
+	// RoleUser may execute all commands except for maintenance commands that need admin rights.
+	RoleUser Role = "user"
+	// RoleReadOnly may only open databases, use transactions, and execute commands that read
+	// from the database, see IsReadCommand.
+	RoleReadOnly Role = "readonly"
+)
+
+// RolePolicy returns true if a role may execute commands of the given type.
+type RolePolicy func(id CommandID) bool
+
+// adminCommands are the commands that need admin rights.
+var adminCommands = map[CommandID]bool{
+	CmdSetFieldAccess: true, CmdBackup: true, CmdCompact: true, CmdArchiveUser: true,
+	CmdServerShutdown: true,
+}
+
+// sessionCommands are the commands that any role may execute, since they neither read nor
+// write any data by themselves.
+var sessionCommands = map[CommandID]bool{
+	CmdOpen: true, CmdClose: true, CmdBegin: true, CmdBeginRead: true, CmdCommit: true,
+	CmdRollback: true, CmdFindNext: true, CmdFindClose: true, CmdAuthenticate: true, CmdLogout: true,
+	CmdUserDB: true, CmdListTx: true, CmdFetch: true, CmdCursorClose: true, CmdSubmit: true,
+	CmdJobStatus: true, CmdJobResult: true, CmdJobCancel: true, CmdStats: true,
+	CmdPoll: true, CmdUnsubscribe: true,
+}
+
+// builtinRoles are the policies of the predefined roles.
+var builtinRoles = map[Role]RolePolicy{
+	RoleAdmin:    func(id CommandID) bool { return true },
+	RoleUser:     func(id CommandID) bool { return !adminCommands[id] },
+	RoleReadOnly: func(id CommandID) bool { return readCommands[id] || sessionCommands[id] },
+}
+
+func validRole(role Role) bool {
+	return validUserName(string(role))
+}
+
+// DefineRole sets the policy of a custom role or replaces that of a predefined role for the
+// executor.
+func (e *Executor) DefineRole(role Role, policy RolePolicy) error {
+	if !validRole(role) {
+		return Fail(`invalid role name "%s"`, role)
+	}
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.rolePolicies[role] = policy
+	return nil
+}
+
+// SetRoles restricts the clients of the executor to the commands permitted by at least one
+// of the roles, for example the roles returned by MultiDB.Roles for an authenticated user.
+// Roles without policy permit no commands, so SetRoles without roles only permits pings.
+// If RoleAdmin is among the roles, the clients also have admin rights.
+func (e *Executor) SetRoles(roles ...Role) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.roles = append([]Role{}, roles...)
+}
+
+// ClearRoles removes the restriction of the executor set by SetRoles.
+func (e *Executor) ClearRoles() {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.roles = nil
+}
+
+// ExecWithRoles executes the command like Exec for a client that is restricted to the roles,
+// such as a user who has authenticated the connection of a server, see AuthRoles. Unlike SetRoles,
+// this does not affect the other clients of the executor. The command must be permitted by one
+// of the roles in addition to the roles of the executor, and the client has admin rights only if
+// RoleAdmin is among the roles. Nil roles do not restrict the client, so that the command is
+// executed as by Exec, whereas an empty list only permits pings.
+func (e *Executor) ExecWithRoles(cmd *Command, roles []Role) *Result {
+	restricted := *cmd
+	restricted.roles = roles
+	return e.Exec(&restricted)
+}
+
+// ExecBatchWithRoles executes the commands like ExecBatch for a client that is restricted to the
+// roles, see ExecWithRoles.
+func (e *Executor) ExecBatchWithRoles(cmds []Command, inTx bool, roles []Role) []Result {
+	restricted := make([]Command, len(cmds))
+	for i := range cmds {
+		restricted[i] = cmds[i]
+		restricted[i].roles = roles
+	}
+	return e.ExecBatch(restricted, inTx)
+}
+
+// hasRole returns true if the role is among the roles.
+func hasRole(roles []Role, role Role) bool {
+	for _, r := range roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// hasRoleLocked returns true if the executor has been given the role with SetRoles. The caller
+// must hold the mutex of the executor.
+func (e *Executor) hasRoleLocked(role Role) bool {
+	return hasRole(e.roles, role)
+}
+
+// permitsLocked returns true if one of the roles permits the command. The caller must hold the
+// mutex of the executor.
+func (e *Executor) permitsLocked(roles []Role, id CommandID) bool {
+	for _, role := range roles {
+		policy, ok := e.rolePolicies[role]
+		if !ok {
+			policy, ok = builtinRoles[role]
+		}
+		if ok && policy(id) {
+			return true
+		}
+	}
+	return false
+}
+
+// rolePermits returns true if the roles of the executor permit the command.
+func (e *Executor) rolePermits(id CommandID) bool {
+	e.mutex.RLock()
+	defer e.mutex.RUnlock()
+	return e.roles == nil || e.permitsLocked(e.roles, id)
+}
+
+// clientPermits returns true if the roles of the client of the command permit it, see
+// ExecWithRoles.
+func (e *Executor) clientPermits(cmd *Command) bool {
+	if cmd.roles == nil {
+		return true
+	}
+	e.mutex.RLock()
+	defer e.mutex.RUnlock()
+	return e.permitsLocked(cmd.roles, cmd.ID)
+}
+
+// roleID returns the UserRole item that assigns the role to the user, or 0 if there is none.
+func (m *MultiDB) roleID(user *User, role Role) Item {
+	var id int64
+	err := m.system.reader.QueryRow(`SELECT Id FROM UserRole WHERE User=? AND Role=?;`,
+		int64(user.id), string(role)).Scan(&id)
+	if err != nil {
+		return 0
+	}
+	return Item(id)
+}
+
+// AssignRole gives the role to the user. Assigning a role the user already has does nothing.
+func (m *MultiDB) AssignRole(user *User, role Role) (ErrCode, error) {
+	if user == nil || !m.ExistingUser(user.name) {
+		return ErrUnknownUser, Fail(`unknown user`)
+	}
+	if !validRole(role) {
+		return ErrInvalidRole, Fail(`invalid role name "%s"`, role)
+	}
+	if m.roleID(user, role) != 0 {
+		return OK, nil
+	}
+	tx, err := m.Begin()
+	if err != nil {
+		return ErrTransactionFail, err
+	}
+	defer tx.Rollback()
+	_, err = tx.NewItems("UserRole", [][]FieldValue{{
+		{Field: "User", Values: []Value{NewInt(int64(user.id))}},
+		{Field: "Role", Values: []Value{NewString(string(role))}}}})
+	if err != nil {
+		return ErrDBFail, err
+	}
+	if err := tx.Commit(); err != nil {
+		return ErrTransactionFail, Fail(`multiuser database error: %s`, err)
+	}
+	return OK, nil
+}
+
+// RevokeRole takes the role from the user. Revoking a role the user doesn't have does nothing.
+func (m *MultiDB) RevokeRole(user *User, role Role) (ErrCode, error) {
+	if user == nil || !m.ExistingUser(user.name) {
+		return ErrUnknownUser, Fail(`unknown user`)
+	}
+	id := m.roleID(user, role)
+	if id == 0 {
+		return OK, nil
+	}
+	tx, err := m.Begin()
+	if err != nil {
+		return ErrTransactionFail, err
+	}
+	defer tx.Rollback()
+	if err := tx.RemoveItem("UserRole", id); err != nil {
+		return ErrDBFail, err
+	}
+	if err := tx.Commit(); err != nil {
+		return ErrTransactionFail, Fail(`multiuser database error: %s`, err)
+	}
+	return OK, nil
+}
+
+// HasRole returns true if the user has been given the role.
+func (m *MultiDB) HasRole(user *User, role Role) bool {
+	return user != nil && m.roleID(user, role) != 0
+}
+
+// Roles returns the roles of the user in alphabetical order.
+func (m *MultiDB) Roles(user *User) ([]Role, ErrCode, error) {
+	if user == nil || !m.ExistingUser(user.name) {
+		return nil, ErrUnknownUser, Fail(`unknown user`)
+	}
+	rows, err := m.system.reader.Query(`SELECT Role FROM UserRole WHERE User=? ORDER BY Role;`, int64(user.id))
+	if err != nil {
+		return nil, ErrDBFail, Fail(`could not read roles: %s`, err)
+	}
+	defer rows.Close()
+	roles := make([]Role, 0)
+	for rows.Next() {
+		var role string
+		if err := rows.Scan(&role); err != nil {
+			return nil, ErrDBFail, Fail(`could not read roles: %s`, err)
+		}
+		roles = append(roles, Role(role))
+	}
+	if err := rows.Err(); err != nil {
+		return nil, ErrDBFail, Fail(`could not read roles: %s`, err)
+	}
+	return roles, OK, nil
+}
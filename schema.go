@@ -0,0 +1,219 @@
+package minidb
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// ------------------------------------------------------------------------------
+// Schema export and import
+// ------------------------------------------------------------------------------
+
+// TableSchema describes a table with its fields, including their constraints, and the
+// names of the fields that have an index.
+type TableSchema struct {
+	Name    string   `json:"name"`
+	Fields  []Field  `json:"fields"`
+	Indexes []string `json:"indexes"`
+}
+
+// TypeSchema describes a custom field type that is used by a schema, see RegisterType.
+type TypeSchema struct {
+	Code FieldType `json:"code"`
+	Name string    `json:"name"`
+	Base FieldType `json:"base"`
+}
+
+// Schema describes all tables of a database and the custom field types they use. It is returned
+// by GetSchema and can be written and read as JSON with ExportSchema and ImportSchema.
+type Schema struct {
+	Tables []TableSchema `json:"tables"`
+	Types  []TypeSchema  `json:"types,omitempty"`
+}
+
+// GetSchema returns the schema of the database.
+func (db *MDB) GetSchema() (*Schema, error) {
+	schema := &Schema{Tables: make([]TableSchema, 0)}
+	for _, table := range db.GetTables() {
+		fields, err := db.GetFields(table)
+		if err != nil {
+			return nil, err
+		}
+		ts := TableSchema{Name: table, Fields: fields, Indexes: make([]string, 0)}
+		for _, field := range fields {
+			if db.hasIndex(table, field) {
+				ts.Indexes = append(ts.Indexes, field.Name)
+			}
+		}
+		schema.Tables = append(schema.Tables, ts)
+	}
+	schema.Types = usedTypes(schema.Tables)
+	return schema, nil
+}
+
+// usedTypes returns the custom types used by the fields of the tables, ordered by their codes.
+func usedTypes(tables []TableSchema) []TypeSchema {
+	var types []TypeSchema
+	used := make(map[FieldType]bool)
+	for _, ts := range tables {
+		for _, field := range ts.Fields {
+			if custom := lookupCustomType(field.Sort); custom != nil && !used[field.Sort] {
+				used[field.Sort] = true
+				types = append(types, TypeSchema{Code: field.Sort, Name: custom.Name, Base: custom.Base})
+			}
+		}
+	}
+	sort.Slice(types, func(i, j int) bool { return types[i].Code < types[j].Code })
+	return types
+}
+
+// ExportSchema returns the schema of the database as a JSON document.
+func (db *MDB) ExportSchema() ([]byte, error) {
+	schema, err := db.GetSchema()
+	if err != nil {
+		return nil, err
+	}
+	return json.MarshalIndent(schema, "", "  ")
+}
+
+// ImportSchema reads a JSON document written by ExportSchema and creates the tables, fields
+// and indexes that do not exist yet, see EnsureSchema.
+func (db *MDB) ImportSchema(r io.Reader) error {
+	var schema Schema
+	if err := json.NewDecoder(r).Decode(&schema); err != nil {
+		return Fail("invalid schema: %s", err)
+	}
+	return db.EnsureSchema(schema)
+}
+
+// EnsureSchema creates the tables, fields and indexes of the schema that do not exist yet, so
+// that an application can declare the schema it needs and call this method at startup instead
+// of checking for every table and field. Existing fields must have the same type as in the schema,
+// otherwise nothing is changed and an error is returned. List fields added to an existing table
+// are filled with their default value, if there is one. Calling it again has no effect.
+func (db *MDB) EnsureSchema(schema Schema) error {
+	if err := db.validateSchema(&schema); err != nil {
+		return err
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	view := tx.View()
+	for _, ts := range schema.Tables {
+		if !view.TableExists(ts.Name) {
+			if err := tx.AddTable(ts.Name, ts.Fields); err != nil {
+				return err
+			}
+		}
+		for _, field := range ts.Fields {
+			if !view.FieldExists(ts.Name, field.Name) {
+				if err := tx.addField(ts.Name, field); err != nil {
+					return err
+				}
+			}
+		}
+		for _, name := range ts.Indexes {
+			for _, field := range ts.Fields {
+				if field.Name == name {
+					if err := tx.createIndex(ts.Name, name, isListFieldType(field.Sort)); err != nil {
+						return err
+					}
+				}
+			}
+		}
+	}
+	return tx.Commit()
+}
+
+// validateSchema checks that a schema can be imported into the database.
+func (db *MDB) validateSchema(schema *Schema) error {
+	for _, ts := range schema.Types {
+		custom := lookupCustomType(ts.Code)
+		if custom == nil {
+			return Fail("custom type '%s' with code %d is not registered", ts.Name, ts.Code)
+		}
+		if !strings.EqualFold(custom.Name, ts.Name) || custom.Base != ts.Base {
+			return Fail("custom type '%s' with code %d is registered as '%s' stored as %s",
+				ts.Name, ts.Code, custom.Name, GetUserTypeString(custom.Base))
+		}
+	}
+	for _, ts := range schema.Tables {
+		if !validTable.MatchString(ts.Name) {
+			return Fail("invalid table name '%s'", ts.Name)
+		}
+		names := make(map[string]bool)
+		for _, field := range ts.Fields {
+			if isTimestampField(field.Name) {
+				if field.Sort != DBDate || field.Required || field.Default != nil {
+					return Fail("timestamp field '%s' in table '%s' must be an optional date field", field.Name, ts.Name)
+				}
+			} else if !validFieldName.MatchString(field.Name) {
+				return Fail("invalid field name '%s' in table '%s'", field.Name, ts.Name)
+			}
+			if names[field.Name] {
+				return Fail("duplicate field '%s' in table '%s'", field.Name, ts.Name)
+			}
+			names[field.Name] = true
+			if err := validateFieldConstraints(field); err != nil {
+				return err
+			}
+			if db.FieldExists(ts.Name, field.Name) {
+				t := db.MustGetFieldType(ts.Name, field.Name)
+				if t != field.Sort {
+					return Fail("field '%s' in table '%s' has type %s, but the schema requires %s",
+						field.Name, ts.Name, GetUserTypeString(t), GetUserTypeString(field.Sort))
+				}
+			}
+		}
+		if names[CreatedField] != names[ModifiedField] {
+			return Fail("table '%s' must have both timestamp fields or neither", ts.Name)
+		}
+		for _, name := range ts.Indexes {
+			if !names[name] {
+				return Fail("index on unknown field '%s' in table '%s'", name, ts.Name)
+			}
+		}
+	}
+	return nil
+}
+
+// addField adds a field to an existing table.
+func (tx *Tx) addField(table string, field Field) error {
+	id, err := tx.View().getTableId(table)
+	if err != nil {
+		return err
+	}
+	// the field must be registered first, since the normalized layout of lists refers to its id
+	if err := tx.insertFieldDesc(table, id, field); err != nil {
+		return err
+	}
+	if isListFieldType(field.Sort) {
+		if err := tx.createListTable(table, field); err != nil {
+			return err
+		}
+		if field.Default != nil {
+			_, err = tx.tx.Exec(fmt.Sprintf(`INSERT INTO "%s"("%s",Owner) SELECT %s,Id FROM "%s";`,
+				listFieldToTableName(table, field.Name), field.Name, sqlLiteral(*field.Default), table))
+			if err != nil {
+				return Fail("cannot set default value of %s %s: %s", table, field.Name, err)
+			}
+		}
+	} else {
+		_, err = tx.tx.Exec(fmt.Sprintf(`ALTER TABLE "%s" ADD COLUMN "%s" %s%s;`,
+			table, field.Name, getTypeString(field.Sort), columnConstraints(field)))
+		if err != nil {
+			return Fail("cannot add field %s to table %s: %s", field.Name, table, err)
+		}
+	}
+	if err := tx.audit(AuditEntry{Op: AuditAddField, Table: table, Field: field.Name,
+		New: []Value{NewString(GetUserTypeString(field.Sort))}}); err != nil {
+		return err
+	}
+	tx.notify(ChangeEvent{Kind: ChangeSchema, Table: table, Field: field.Name})
+	return nil
+}
@@ -0,0 +1,145 @@
+package minidb
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestSchema(t *testing.T) {
+	tmp, _ := ioutil.TempFile("", "minidb-schema-testing-*")
+	defer os.Remove(tmp.Name())
+	db, err := Open("sqlite3", tmp.Name())
+	if err != nil {
+		t.Errorf("Open() failed: %s", err)
+	}
+	defer db.Close()
+	zero := NewInt(0)
+	none := NewString("none")
+	if err := db.AddTable("Person", []Field{Field{Name: "Name", Sort: DBString},
+		Field{Name: "Age", Sort: DBInt, Required: true, Default: &zero},
+		Field{Name: "Tags", Sort: DBStringList}}); err != nil {
+		t.Errorf("AddTable() failed: %s", err)
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		t.Errorf("Begin() failed: %s", err)
+	}
+	if err := tx.Index("Person", "Name"); err != nil {
+		t.Errorf("Index() failed: %s", err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Errorf("Commit() failed: %s", err)
+	}
+	exported, err := db.ExportSchema()
+	if err != nil {
+		t.Errorf("ExportSchema() failed: %s", err)
+	}
+
+	tmp2, _ := ioutil.TempFile("", "minidb-schema-testing-*")
+	defer os.Remove(tmp2.Name())
+	db2, err := Open("sqlite3", tmp2.Name())
+	if err != nil {
+		t.Errorf("Open() failed: %s", err)
+	}
+	defer db2.Close()
+	if err := db2.AddTable("Person", []Field{Field{Name: "Name", Sort: DBString}}); err != nil {
+		t.Errorf("AddTable() failed: %s", err)
+	}
+	item, _ := db2.NewItem("Person")
+	if err := db2.ImportSchema(bytes.NewReader(exported)); err != nil {
+		t.Errorf("ImportSchema() failed: %s", err)
+	}
+	exported2, err := db2.ExportSchema()
+	if err != nil {
+		t.Errorf("ExportSchema() failed: %s", err)
+	}
+	if !bytes.Equal(exported, exported2) {
+		t.Errorf("ImportSchema() did not reproduce the schema:\n%s\n%s", exported, exported2)
+	}
+	values, err := db2.Get("Person", item, "Age")
+	if err != nil || len(values) != 1 || values[0].Int() != 0 {
+		t.Errorf("ImportSchema() did not set the default of a new field: %v %v", values, err)
+	}
+	if err := db2.ImportSchema(bytes.NewReader(exported)); err != nil {
+		t.Errorf("ImportSchema() should have no effect on an identical schema: %s", err)
+	}
+
+	// list field defaults are stored for existing items
+	schema := `{"tables":[{"name":"Person","fields":[{"name":"Labels","sort":6,"required":true,
+"default":{"str":"none","num":0,"sort":3}}],"indexes":["Labels"]}]}`
+	if err := db2.ImportSchema(strings.NewReader(schema)); err != nil {
+		t.Errorf("ImportSchema() failed: %s", err)
+	}
+	values, err = db2.Get("Person", item, "Labels")
+	if err != nil || len(values) != 1 || values[0].String() != none.String() {
+		t.Errorf("ImportSchema() did not set the default of a new list field: %v %v", values, err)
+	}
+	if fields, _ := db2.GetFields("Person"); len(fields) != 4 {
+		t.Errorf("ImportSchema() added wrong fields: %v", fields)
+	}
+
+	if err := db2.ImportSchema(strings.NewReader(`{"tables":[{"name":"Person","fields":[{"name":"Age","sort":3}]}]}`)); err == nil {
+		t.Errorf("ImportSchema() should fail for a field with a different type")
+	}
+	if err := db2.ImportSchema(strings.NewReader(`{"tables":[{"name":"_X","fields":[]}]}`)); err == nil {
+		t.Errorf("ImportSchema() should fail for an invalid table name")
+	}
+	if err := db2.ImportSchema(strings.NewReader(`{"tables":[{"name":"X","fields":[],"indexes":["Y"]}]}`)); err == nil {
+		t.Errorf("ImportSchema() should fail for an index on an unknown field")
+	}
+	if db2.TableExists("X") {
+		t.Errorf("ImportSchema() changed the database despite an error")
+	}
+	if err := db2.ImportSchema(strings.NewReader(`not json`)); err == nil {
+		t.Errorf("ImportSchema() should fail for invalid JSON")
+	}
+}
+
+func TestEnsureSchema(t *testing.T) {
+	tmp, _ := ioutil.TempFile("", "minidb-schema-testing-*")
+	defer os.Remove(tmp.Name())
+	db, err := Open("sqlite3", tmp.Name())
+	if err != nil {
+		t.Errorf("Open() failed: %s", err)
+	}
+	defer db.Close()
+	schema := Schema{Tables: []TableSchema{
+		{Name: "Person", Fields: []Field{{Name: "Name", Sort: DBString}, {Name: "Tags", Sort: DBStringList}},
+			Indexes: []string{"Name"}},
+		{Name: "Asset", Fields: []Field{{Name: "Value", Sort: DBInt}}},
+	}}
+	for i := 0; i < 2; i++ {
+		if err := db.EnsureSchema(schema); err != nil {
+			t.Errorf("EnsureSchema() failed: %s", err)
+		}
+	}
+	got, _ := db.GetSchema()
+	if len(got.Tables) != 2 || len(got.Tables[0].Fields) != 2 || len(got.Tables[0].Indexes) != 1 {
+		t.Errorf("EnsureSchema() created %v", got.Tables)
+	}
+
+	// a new field in an existing table and a new table in one call
+	schema.Tables[1].Fields = append(schema.Tables[1].Fields, Field{Name: "Owner", Sort: DBString})
+	schema.Tables = append(schema.Tables, TableSchema{Name: "Place", Fields: []Field{{Name: "Name", Sort: DBString}}})
+	if err := db.EnsureSchema(schema); err != nil {
+		t.Errorf("EnsureSchema() failed: %s", err)
+	}
+	if !db.FieldExists("Asset", "Owner") || !db.TableExists("Place") {
+		t.Errorf("EnsureSchema() did not add the new field and table")
+	}
+
+	// nothing is changed if a field has the wrong type
+	bad := Schema{Tables: []TableSchema{
+		{Name: "Thing", Fields: []Field{{Name: "Name", Sort: DBString}}},
+		{Name: "Person", Fields: []Field{{Name: "Name", Sort: DBInt}}},
+	}}
+	if err := db.EnsureSchema(bad); err == nil {
+		t.Errorf("EnsureSchema() should fail for a field with a different type")
+	}
+	if db.TableExists("Thing") {
+		t.Errorf("EnsureSchema() changed the database despite an error")
+	}
+}
@@ -0,0 +1,122 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Level is the severity of a log entry.
+type Level int
+
+// Levels of log entries in increasing severity.
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+var levelNames = []string{"debug", "info", "warn", "error"}
+
+func (l Level) String() string {
+	if l < LevelDebug || l > LevelError {
+		return "level" + strconv.Itoa(int(l))
+	}
+	return levelNames[l]
+}
+
+// ParseLevel returns the level with the name, such as "info".
+func ParseLevel(name string) (Level, error) {
+	for i, levelName := range levelNames {
+		if strings.EqualFold(name, levelName) {
+			return Level(i), nil
+		}
+	}
+	return LevelInfo, fmt.Errorf("unknown log level '%s'", name)
+}
+
+// Field is a named value of a log entry.
+type Field struct {
+	Key   string
+	Value interface{}
+}
+
+// Logger receives the log entries of a server. Log may be called by several workers at the
+// same time.
+type Logger interface {
+	Log(level Level, msg string, fields ...Field)
+}
+
+// writerLogger writes the entries of at least its level to a writer, one per line.
+type writerLogger struct {
+	w     io.Writer
+	level Level
+	json  bool
+	mutex sync.Mutex
+}
+
+// NewLogger returns a logger that writes the entries of the given level and above to w, one per
+// line. The lines are JSON objects with the time, level, message, and fields if asJSON is true,
+// and otherwise text with the fields as key=value pairs.
+func NewLogger(w io.Writer, level Level, asJSON bool) Logger {
+	return &writerLogger{w: w, level: level, json: asJSON}
+}
+
+func (l *writerLogger) Log(level Level, msg string, fields ...Field) {
+	if level < l.level {
+		return
+	}
+	now := time.Now().Format(time.RFC3339Nano)
+	var line []byte
+	if l.json {
+		// the keys are written in order, which a map would not preserve
+		line = append(line, `{"time":`...)
+		line = appendJSON(line, now)
+		line = append(line, `,"level":`...)
+		line = appendJSON(line, level.String())
+		line = append(line, `,"msg":`...)
+		line = appendJSON(line, msg)
+		for _, f := range fields {
+			line = append(line, ',')
+			line = appendJSON(line, f.Key)
+			line = append(line, ':')
+			line = appendJSON(line, f.Value)
+		}
+		line = append(line, '}')
+	} else {
+		line = append(line, fmt.Sprintf("%s %-5s %s", now, strings.ToUpper(level.String()), msg)...)
+		for _, f := range fields {
+			value := fmt.Sprint(f.Value)
+			if value == "" || strings.ContainsAny(value, " \t\n\"=") {
+				value = strconv.Quote(value)
+			}
+			line = append(line, fmt.Sprintf(" %s=%s", f.Key, value)...)
+		}
+	}
+	line = append(line, '\n')
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.w.Write(line)
+}
+
+// appendJSON appends the JSON encoding of the value, or of its string form if it has none.
+func appendJSON(line []byte, value interface{}) []byte {
+	if err, ok := value.(error); ok {
+		value = err.Error()
+	}
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		encoded, _ = json.Marshal(fmt.Sprint(value))
+	}
+	return append(line, encoded...)
+}
+
+// nopLogger discards all entries.
+type nopLogger struct{}
+
+func (nopLogger) Log(level Level, msg string, fields ...Field) {}
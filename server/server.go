@@ -0,0 +1,358 @@
+// Package server serves minidb commands sent by clients, such as those of the package client,
+// with an executor. It is used by cmd/mdbserve and can be used to embed a server into other
+// programs. Servers listen on any mangos transport, and on tls+tcp URLs with a TLS configuration
+// that may require client certificates, see TLSConfig.
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"sync"
+	"time"
+
+	minidb "github.com/rasteric/minidb"
+	mangos "nanomsg.org/go/mangos/v2"
+	"nanomsg.org/go/mangos/v2/protocol/rep"
+
+	// register transports
+	_ "nanomsg.org/go/mangos/v2/transport/all"
+)
+
+// DefaultWorkers is the number of requests a server handles at the same time if Options.Workers
+// is 0.
+const DefaultWorkers = 8
+
+// pollInterval is the time after which a worker that waits for a request checks whether the
+// server stops.
+const pollInterval = 250 * time.Millisecond
+
+// Options configure a server.
+type Options struct {
+	// Workers is the number of requests that are handled at the same time. Poll commands wait
+	// for events in all workers but one at most, so that the last worker remains for the other
+	// requests, see minidb.PollCommand.
+	Workers int
+	// TLS is the TLS configuration of tls+tcp URLs. It must be given for such URLs and must not
+	// be given for others.
+	TLS *tls.Config
+	// OnError is called with the errors of requests that cannot be received, decoded, executed,
+	// or answered, which are of type *RequestError. Such errors are ignored if it is nil.
+	OnError func(error)
+	// Logger receives an entry for every command, at LevelDebug or at LevelInfo if it failed,
+	// and the errors of requests at LevelError, see NewLogger. Nothing is logged if it is nil.
+	Logger Logger
+}
+
+// Steps of handling a request that may fail, see RequestError.
+const (
+	OpReceive = "receive"
+	OpDecode  = "decode"
+	OpExecute = "execute"
+	OpEncode  = "encode"
+	OpSend    = "send"
+)
+
+// RequestError is an error of a request. Op is the step that failed, such as OpDecode.
+type RequestError struct {
+	Op  string
+	Err error
+}
+
+func (e *RequestError) Error() string {
+	return e.Op + " failed: " + e.Err.Error()
+}
+
+func (e *RequestError) Unwrap() error {
+	return e.Err
+}
+
+// Server receives commands on a socket and sends back their results. If the executor requires
+// authentication, see minidb.Executor.SetAuthTokens, the server remembers the connections that
+// have been authenticated with an Auth command until they are closed, and refuses the other
+// commands on the remaining connections. The commands of a connection authenticated by a user
+// are restricted to the roles of the user, see minidb.AuthRoles.
+type Server struct {
+	sock          mangos.Socket
+	opts          Options
+	authenticated sync.Map      // pipe ID -> []minidb.Role
+	polls         chan struct{} // a slot for every Poll command that may wait for events
+}
+
+// TLSConfig returns a TLS configuration for a server with the certificate and key in the PEM
+// files. If clientCAFile is not empty, clients must present a certificate signed by one of the
+// certificate authorities in that PEM file.
+func TLSConfig(certFile, keyFile, clientCAFile string) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("cannot load certificate: %w", err)
+	}
+	config := &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}
+	if clientCAFile != "" {
+		pem, err := ioutil.ReadFile(clientCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("cannot read client CA file: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in client CA file %s", clientCAFile)
+		}
+		config.ClientCAs = pool
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return config, nil
+}
+
+// Listen returns a server that listens on the URL, for example tcp://127.0.0.1:7873 or
+// tls+tcp://0.0.0.0:7873.
+func Listen(url string, opts Options) (*Server, error) {
+	isTLS := strings.HasPrefix(url, "tls+")
+	if isTLS && opts.TLS == nil {
+		return nil, errors.New("a TLS configuration is needed for " + url)
+	}
+	if !isTLS && opts.TLS != nil {
+		return nil, errors.New("a TLS configuration is given, but " + url + " is not a tls+tcp URL")
+	}
+	if opts.Workers <= 0 {
+		opts.Workers = DefaultWorkers
+	}
+	if opts.Logger == nil {
+		opts.Logger = nopLogger{}
+	}
+	sock, err := rep.NewSocket()
+	if err != nil {
+		return nil, fmt.Errorf("can't get new socket, %w", err)
+	}
+	if opts.TLS != nil {
+		if err = sock.SetOption(mangos.OptionTLSConfig, opts.TLS); err != nil {
+			sock.Close()
+			return nil, fmt.Errorf("can't configure TLS, %w", err)
+		}
+	}
+	s := &Server{sock: sock, opts: opts, polls: make(chan struct{}, opts.Workers-1)}
+	sock.SetPipeEventHook(s.pipeEvent)
+	if err = sock.Listen(url); err != nil {
+		sock.Close()
+		return nil, fmt.Errorf("can't listen, %w", err)
+	}
+	return s, nil
+}
+
+// Serve executes the commands received by the server with the executor until the context is
+// done or a client sends a ServerShutdown command, and then closes the server. Requests are
+// handled by a pool of workers, so a slow command only occupies one worker while the others serve
+// the remaining clients. The executor serializes the writes to each database and locks databases
+// individually, so requests for different databases run in parallel. When the server stops, the
+// requests that are being handled are finished and answered first, but the databases of the
+// executor are left open, see minidb.Executor.CloseAllDBs.
+func (s *Server) Serve(ctx context.Context, executor *minidb.Executor) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	executor.SetShutdownHandler(cancel)
+	defer executor.SetShutdownHandler(nil)
+	var wg sync.WaitGroup
+	var err error
+	for i := 0; i < s.opts.Workers; i++ {
+		// every context of a rep socket handles one request at a time
+		var sockCtx mangos.Context
+		if sockCtx, err = s.sock.OpenContext(); err != nil {
+			err = fmt.Errorf("can't open socket context, %w", err)
+			cancel()
+			break
+		}
+		// workers wake up regularly to notice that the server stops
+		sockCtx.SetOption(mangos.OptionRecvDeadline, pollInterval)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.work(ctx, sockCtx, executor)
+		}()
+	}
+	s.opts.Logger.Log(LevelInfo, "serving", Field{"workers", s.opts.Workers})
+	wg.Wait()
+	s.Close()
+	s.opts.Logger.Log(LevelInfo, "stopped")
+	return err
+}
+
+// Close closes the socket of the server.
+func (s *Server) Close() error {
+	return s.sock.Close()
+}
+
+// work receives requests on a socket context and sends the replies until the context is done or
+// the socket is closed.
+func (s *Server) work(ctx context.Context, sockCtx mangos.Context, executor *minidb.Executor) {
+	defer sockCtx.Close()
+	for ctx.Err() == nil {
+		req, err := sockCtx.RecvMsg()
+		if err != nil {
+			if err == mangos.ErrClosed {
+				return
+			}
+			if err == mangos.ErrRecvTimeout {
+				continue
+			}
+			s.fail(OpReceive, err)
+			continue
+		}
+		msg := s.handle(req.Pipe, req.Body, executor)
+		req.Free()
+		if err = sockCtx.Send(msg); err != nil {
+			s.fail(OpSend, err)
+		}
+	}
+}
+
+// handle executes a request received on the pipe and returns the reply. A panic while the
+// request is executed is reported as an error of the request and answered with ErrInternal, so
+// that the worker carries on with the next request.
+func (s *Server) handle(pipe mangos.Pipe, body []byte, executor *minidb.Executor) (msg []byte) {
+	// the reply is sent in the encoding of the request
+	cmd, batch, enc, err := minidb.DecodeRequest(body)
+	if err != nil {
+		s.fail(OpDecode, err)
+		cmd = &minidb.Command{}
+	}
+	defer func() {
+		if p := recover(); p != nil {
+			err := fmt.Errorf("panic: %v", p)
+			s.fail(OpExecute, err)
+			r := &minidb.Result{HasError: true, Int: minidb.ErrInternal, Str: err.Error(),
+				Version: minidb.CommandVersion}
+			if batch != nil {
+				msg, err = minidb.EncodeReply(nil, []minidb.Result{*r}, enc)
+			} else {
+				msg, err = minidb.EncodeReply(r, nil, enc)
+			}
+			if err != nil {
+				s.fail(OpEncode, err)
+			}
+		}
+	}()
+	if batch != nil {
+		msg, err = minidb.EncodeReply(nil, s.execBatch(pipe, batch, executor), enc)
+	} else {
+		msg, err = minidb.EncodeReply(s.exec(pipe, cmd, executor), nil, enc)
+	}
+	if err != nil {
+		s.fail(OpEncode, err)
+	}
+	return msg
+}
+
+// exec executes a command received on the pipe.
+func (s *Server) exec(pipe mangos.Pipe, cmd *minidb.Command, executor *minidb.Executor) *minidb.Result {
+	start := time.Now()
+	roles, authenticated := s.authentication(pipe)
+	r := executor.CheckAuth(cmd, authenticated)
+	if r == nil {
+		var release func()
+		cmd, release = s.limitPoll(cmd)
+		defer release()
+		r = executor.ExecWithRoles(cmd, roles)
+		if cmd.ID == minidb.CmdAuth && !r.HasError && pipe != nil {
+			s.authenticated.Store(pipe.ID(), minidb.AuthRoles(r))
+		}
+	}
+	s.logCommand(cmd, r, time.Since(start))
+	return r
+}
+
+// limitPoll returns the command unchanged if it is not a Poll command that waits for events or
+// if another worker may wait for them, and otherwise a copy that returns at once. The returned
+// function must be called once the command has been executed.
+func (s *Server) limitPoll(cmd *minidb.Command) (*minidb.Command, func()) {
+	if cmd.ID != minidb.CmdPoll || cmd.IntArg <= 0 {
+		return cmd, func() {}
+	}
+	select {
+	case s.polls <- struct{}{}:
+		return cmd, func() { <-s.polls }
+	default:
+		poll := *cmd
+		poll.IntArg = 0
+		return &poll, func() {}
+	}
+}
+
+// execBatch executes a batch received on the pipe. Auth commands in a batch do not authenticate
+// the connection, so a batch is refused as a whole on a connection that is not authenticated.
+func (s *Server) execBatch(pipe mangos.Pipe, batch *minidb.Batch, executor *minidb.Executor) []minidb.Result {
+	start := time.Now()
+	roles, authenticated := s.authentication(pipe)
+	var results []minidb.Result
+	for i := range batch.Commands {
+		if r := executor.CheckAuth(&batch.Commands[i], authenticated); r != nil {
+			results = []minidb.Result{*r}
+			break
+		}
+	}
+	if results == nil {
+		results = executor.ExecBatchWithRoles(batch.Commands, batch.Atomic, roles)
+	}
+	fields := []Field{{"commands", len(batch.Commands)}, {"atomic", batch.Atomic},
+		{"duration_ms", milliseconds(time.Since(start))}}
+	if n := len(results); n > 0 && results[n-1].HasError {
+		s.opts.Logger.Log(LevelInfo, "batch failed", append(fields, Field{"code", results[n-1].Int},
+			Field{"error", results[n-1].Str})...)
+	} else {
+		s.opts.Logger.Log(LevelDebug, "batch", fields...)
+	}
+	return results
+}
+
+// logCommand logs the execution of a command. The names of the databases of sessions are not
+// logged, since they contain the session token.
+func (s *Server) logCommand(cmd *minidb.Command, r *minidb.Result, d time.Duration) {
+	db := string(cmd.DB)
+	if minidb.IsSessionDB(cmd.DB) {
+		db = "session"
+	}
+	fields := []Field{{"db", db}, {"command", int(cmd.ID)}, {"duration_ms", milliseconds(d)}}
+	if cmd.Tx != 0 {
+		fields = append(fields, Field{"tx", int64(cmd.Tx)})
+	}
+	if r.HasError {
+		s.opts.Logger.Log(LevelInfo, "command failed", append(fields, Field{"code", r.Int}, Field{"error", r.Str})...)
+		return
+	}
+	s.opts.Logger.Log(LevelDebug, "command", fields...)
+}
+
+// milliseconds returns the duration in milliseconds with a precision of microseconds.
+func milliseconds(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
+
+// authentication returns true if an Auth command has succeeded on the pipe, together with the
+// roles the pipe is restricted to, which are nil unless a user has authenticated it.
+func (s *Server) authentication(pipe mangos.Pipe) ([]minidb.Role, bool) {
+	if pipe == nil {
+		return nil, false
+	}
+	roles, ok := s.authenticated.Load(pipe.ID())
+	if !ok {
+		return nil, false
+	}
+	return roles.([]minidb.Role), true
+}
+
+// pipeEvent forgets the authentication of a connection once it is closed.
+func (s *Server) pipeEvent(event mangos.PipeEvent, pipe mangos.Pipe) {
+	if event == mangos.PipeEventDetached {
+		s.authenticated.Delete(pipe.ID())
+	}
+}
+
+// fail reports the error of a step of a request.
+func (s *Server) fail(op string, err error) {
+	s.opts.Logger.Log(LevelError, "request failed", Field{"op", op}, Field{"error", err})
+	if s.opts.OnError != nil {
+		s.opts.OnError(&RequestError{Op: op, Err: err})
+	}
+}
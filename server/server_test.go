@@ -0,0 +1,450 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	minidb "github.com/rasteric/minidb"
+	"github.com/rasteric/minidb/client"
+	mangos "nanomsg.org/go/mangos/v2"
+	"nanomsg.org/go/mangos/v2/protocol/req"
+)
+
+// testServer is a server started for a test.
+type testServer struct {
+	url    string
+	cancel context.CancelFunc
+	done   chan struct{}
+	err    error
+}
+
+// recorder is a logger that records the entries of a server.
+type recorder struct {
+	mutex   sync.Mutex
+	entries []string
+}
+
+func (r *recorder) Log(level Level, msg string, fields ...Field) {
+	entry := level.String() + " " + msg
+	for _, field := range fields {
+		entry += fmt.Sprintf(" %s=%v", field.Key, field.Value)
+	}
+	r.mutex.Lock()
+	r.entries = append(r.entries, entry)
+	r.mutex.Unlock()
+}
+
+// logged returns true if an entry starts with the prefix.
+func (r *recorder) logged(prefix string) bool {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	for _, entry := range r.entries {
+		if strings.HasPrefix(entry, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// freeURL returns a tcp URL on localhost with a port that is not in use.
+func freeURL(t *testing.T) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("cannot find a free port: %s", err)
+	}
+	defer l.Close()
+	return fmt.Sprintf("tcp://127.0.0.1:%d", l.Addr().(*net.TCPAddr).Port)
+}
+
+// serve serves the executor on a free URL. When the test ends, the server is stopped and the
+// databases of the executor are closed.
+func serve(t *testing.T, executor *minidb.Executor, opts Options) *testServer {
+	url := freeURL(t)
+	s, err := Listen(url, opts)
+	if err != nil {
+		t.Fatalf("Listen() failed: %s", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	ts := &testServer{url: url, cancel: cancel, done: make(chan struct{})}
+	go func() {
+		ts.err = s.Serve(ctx, executor)
+		close(ts.done)
+	}()
+	t.Cleanup(executor.CloseAllDBs)
+	t.Cleanup(func() {
+		cancel()
+		ts.wait(t)
+	})
+	return ts
+}
+
+// wait waits until Serve has returned and returns its error.
+func (s *testServer) wait(t *testing.T) error {
+	select {
+	case <-s.done:
+		return s.err
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Serve() did not return")
+		return nil
+	}
+}
+
+// dial returns a client of the server that is closed when the test ends.
+func dial(t *testing.T, url string) *client.Client {
+	c, err := client.DialTimeout(time.Second, url)
+	if err != nil {
+		t.Fatalf("DialTimeout() failed: %s", err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+func TestListen(t *testing.T) {
+	if _, err := Listen("tls+tcp://127.0.0.1:0", Options{}); err == nil {
+		t.Errorf("Listen() accepted a tls+tcp URL without TLS configuration")
+	}
+	if _, err := Listen(freeURL(t), Options{TLS: &tls.Config{}}); err == nil {
+		t.Errorf("Listen() accepted a TLS configuration for a tcp URL")
+	}
+}
+
+func TestServe(t *testing.T) {
+	errs := make(chan error, 1)
+	log := &recorder{}
+	s := serve(t, minidb.NewExecutor(), Options{Workers: 2, OnError: func(err error) { errs <- err }, Logger: log})
+	c := dial(t, s.url)
+	file := filepath.Join(t.TempDir(), "test.sqlite")
+	if _, err := c.Exec(minidb.OpenCommand("sqlite3", file)); err != nil {
+		t.Fatalf("Open command failed: %s", err)
+	}
+	db := minidb.CommandDB(file)
+	if _, err := c.Exec(minidb.AddTableCommand(db, "Person", []minidb.Field{{Name: "Name", Sort: minidb.DBString}})); err != nil {
+		t.Errorf("AddTable command failed: %s", err)
+	}
+	result, err := c.Exec(minidb.GetTablesCommand(db))
+	if err != nil || len(result.Strings) != 1 || result.Strings[0] != "Person" {
+		t.Errorf("GetTables command returned %v, %v", result, err)
+	}
+	if !log.logged(fmt.Sprintf("debug command db=%s command=%d", file, minidb.CmdGetTables)) {
+		t.Errorf("the GetTables command was not logged: %q", log.entries)
+	}
+
+	// a request that cannot be decoded is answered and reported
+	sock, err := req.NewSocket()
+	if err != nil {
+		t.Fatalf("cannot create socket: %s", err)
+	}
+	defer sock.Close()
+	sock.SetOption(mangos.OptionRecvDeadline, time.Second)
+	if err = sock.Dial(s.url); err != nil {
+		t.Fatalf("cannot dial: %s", err)
+	}
+	if err = sock.Send([]byte("not a request")); err != nil {
+		t.Fatalf("cannot send: %s", err)
+	}
+	if _, err = sock.Recv(); err != nil {
+		t.Errorf("the server did not answer an invalid request: %s", err)
+	}
+	var reqErr *RequestError
+	select {
+	case err := <-errs:
+		if !errors.As(err, &reqErr) || reqErr.Op != OpDecode {
+			t.Errorf("OnError() was called with %v, expected a decode error", err)
+		}
+	case <-time.After(time.Second):
+		t.Errorf("OnError() was not called for an invalid request")
+	}
+	if !log.logged("error request failed op=decode") {
+		t.Errorf("the invalid request was not logged: %q", log.entries)
+	}
+
+	s.cancel()
+	if err := s.wait(t); err != nil {
+		t.Errorf("Serve() returned %s", err)
+	}
+}
+
+func TestAuth(t *testing.T) {
+	executor := minidb.NewExecutor()
+	executor.SetAuthTokens("secret")
+	s := serve(t, executor, Options{Workers: 2})
+	c := dial(t, s.url)
+	open := minidb.OpenCommand("sqlite3", filepath.Join(t.TempDir(), "test.sqlite"))
+	if _, err := c.Exec(open); !errors.Is(err, minidb.ErrUnauthenticated) {
+		t.Errorf("Open command on a connection that is not authenticated returned %v", err)
+	}
+	if err := c.Auth("wrong"); err == nil {
+		t.Errorf("Auth() with a wrong token succeeded")
+	}
+	if err := c.Auth("secret"); err != nil {
+		t.Fatalf("Auth() failed: %s", err)
+	}
+	if _, err := c.Exec(open); err != nil {
+		t.Errorf("Open command failed after Auth(): %s", err)
+	}
+
+	// the authentication belongs to the connection
+	other := dial(t, s.url)
+	if _, err := other.Exec(open); !errors.Is(err, minidb.ErrUnauthenticated) {
+		t.Errorf("Open command on another connection that is not authenticated returned %v", err)
+	}
+}
+
+func TestShutdown(t *testing.T) {
+	executor := minidb.NewExecutor()
+	executor.SetAuthTokens("secret")
+	s := serve(t, executor, Options{Workers: 2})
+	c := dial(t, s.url)
+	if _, err := c.Exec(minidb.ServerShutdownCommand()); !errors.Is(err, minidb.ErrUnauthenticated) {
+		t.Errorf("ServerShutdown command on a connection that is not authenticated returned %v", err)
+	}
+	if err := c.Auth("secret"); err != nil {
+		t.Fatalf("Auth() failed: %s", err)
+	}
+	if _, err := c.Exec(minidb.ServerShutdownCommand()); err != nil {
+		t.Errorf("ServerShutdown command failed: %s", err)
+	}
+	if err := s.wait(t); err != nil {
+		t.Errorf("Serve() returned %s", err)
+	}
+}
+
+func TestLogger(t *testing.T) {
+	var buff bytes.Buffer
+	logger := NewLogger(&buff, LevelInfo, false)
+	logger.Log(LevelDebug, "command", Field{"db", "test"})
+	logger.Log(LevelInfo, "command failed", Field{"db", "test"}, Field{"error", "table not found"})
+	lines := strings.Split(strings.TrimSpace(buff.String()), "\n")
+	if len(lines) != 1 || !strings.Contains(lines[0], `INFO  command failed db=test error="table not found"`) {
+		t.Errorf("the logger wrote %q", buff.String())
+	}
+
+	buff.Reset()
+	logger = NewLogger(&buff, LevelDebug, true)
+	logger.Log(LevelError, "request failed", Field{"op", OpDecode}, Field{"error", errors.New("invalid request")})
+	var entry map[string]interface{}
+	if err := json.Unmarshal(buff.Bytes(), &entry); err != nil {
+		t.Fatalf("the logger wrote invalid JSON %q: %s", buff.String(), err)
+	}
+	if entry["level"] != "error" || entry["msg"] != "request failed" || entry["op"] != "decode" ||
+		entry["error"] != "invalid request" {
+		t.Errorf("the logger wrote %v", entry)
+	}
+	if _, err := ParseLevel("verbose"); err == nil {
+		t.Errorf("ParseLevel() accepted an unknown level")
+	}
+	if level, err := ParseLevel("WARN"); err != nil || level != LevelWarn {
+		t.Errorf("ParseLevel() returned %s, %v", level, err)
+	}
+}
+
+func TestRoles(t *testing.T) {
+	m, err := minidb.NewMultiDB(t.TempDir(), "sqlite3")
+	if err != nil {
+		t.Fatalf("NewMultiDB() failed: %s", err)
+	}
+	t.Cleanup(func() { m.Close() })
+	p := minidb.DefaultParams()
+	for name, role := range map[string]minidb.Role{"Reader": minidb.RoleReadOnly, "Admin": minidb.RoleAdmin} {
+		user, _, err := m.NewUser(name, strings.ToLower(name)+"@test.com", minidb.GenerateKey("secret", minidb.GenerateExternalSalt(p), p))
+		if err != nil {
+			t.Fatalf("NewUser() failed: %s", err)
+		}
+		if _, err := m.AssignRole(user, role); err != nil {
+			t.Fatalf("AssignRole() failed: %s", err)
+		}
+	}
+	executor := minidb.NewExecutor()
+	executor.SetMultiDB(m)
+	executor.SetUserAuth(true)
+	s := serve(t, executor, Options{Workers: 2})
+	file := filepath.Join(t.TempDir(), "test.sqlite")
+	db := minidb.CommandDB(file)
+	addTable := minidb.AddTableCommand(db, "Person", []minidb.Field{{Name: "Name", Sort: minidb.DBString}})
+	notPermitted := func(err error) bool {
+		var cmdErr *minidb.CommandError
+		return errors.As(err, &cmdErr) && cmdErr.Code == minidb.ErrNotPermitted
+	}
+
+	// the connection of a read-only user may only read
+	reader := dial(t, s.url)
+	if err := reader.AuthUser("Reader", "secret"); err != nil {
+		t.Fatalf("AuthUser() failed: %s", err)
+	}
+	if _, err := reader.Exec(minidb.OpenCommand("sqlite3", file)); err != nil {
+		t.Fatalf("Open command of a read-only user failed: %s", err)
+	}
+	if _, err := reader.Exec(minidb.GetTablesCommand(db)); err != nil {
+		t.Errorf("GetTables command of a read-only user failed: %s", err)
+	}
+	if _, err := reader.Exec(addTable); !notPermitted(err) {
+		t.Errorf("AddTable command of a read-only user returned %v, expected ErrNotPermitted", err)
+	}
+	if _, err := reader.ExecBatch([]minidb.Command{*addTable}, false); !notPermitted(err) {
+		t.Errorf("a batch with an AddTable command of a read-only user returned %v, expected ErrNotPermitted", err)
+	}
+	if _, err := reader.Exec(minidb.ServerShutdownCommand()); !notPermitted(err) {
+		t.Errorf("ServerShutdown command of a read-only user returned %v, expected ErrNotPermitted", err)
+	}
+
+	// the roles belong to the connection
+	admin := dial(t, s.url)
+	if err := admin.AuthUser("Admin", "secret"); err != nil {
+		t.Fatalf("AuthUser() failed: %s", err)
+	}
+	if _, err := admin.Exec(addTable); err != nil {
+		t.Errorf("AddTable command of an admin failed: %s", err)
+	}
+	if _, err := admin.Exec(minidb.ServerShutdownCommand()); err != nil {
+		t.Errorf("ServerShutdown command of an admin failed: %s", err)
+	}
+	if err := s.wait(t); err != nil {
+		t.Errorf("Serve() returned %s", err)
+	}
+}
+
+func TestPollLimit(t *testing.T) {
+	s := serve(t, minidb.NewExecutor(), Options{Workers: 2})
+	c := dial(t, s.url)
+	file := filepath.Join(t.TempDir(), "test.sqlite")
+	if _, err := c.Exec(minidb.OpenCommand("sqlite3", file)); err != nil {
+		t.Fatalf("Open command failed: %s", err)
+	}
+	db := minidb.CommandDB(file)
+	if _, err := c.Exec(minidb.AddTableCommand(db, "Person", []minidb.Field{{Name: "Name", Sort: minidb.DBString}})); err != nil {
+		t.Fatalf("AddTable command failed: %s", err)
+	}
+	r, err := c.Exec(minidb.SubscribeCommand(db, "Person"))
+	if err != nil {
+		t.Fatalf("Subscribe command failed: %s", err)
+	}
+	token := r.Str
+	waiting, err := client.DialTimeout(5*time.Second, s.url)
+	if err != nil {
+		t.Fatalf("DialTimeout() failed: %s", err)
+	}
+	defer waiting.Close()
+	polled := make(chan error, 1)
+	go func() {
+		_, err := waiting.Exec(minidb.PollCommand(db, token, 3*time.Second))
+		polled <- err
+	}()
+	time.Sleep(200 * time.Millisecond)
+
+	// the first poll waits in one worker, so a second one must not take the last worker
+	start := time.Now()
+	if _, err := c.Exec(minidb.PollCommand(db, token, 3*time.Second)); err != nil {
+		t.Errorf("Poll command failed: %s", err)
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("a Poll command waited for %s although only one worker was left", d)
+	}
+	if _, err := c.Exec(minidb.UnsubscribeCommand(db, token)); err != nil {
+		t.Errorf("Unsubscribe command failed: %s", err)
+	}
+	select {
+	case err := <-polled:
+		if err != nil {
+			t.Errorf("the waiting Poll command failed: %s", err)
+		}
+	case <-time.After(time.Second):
+		t.Errorf("the waiting Poll command did not return after Unsubscribe")
+	}
+}
+
+func TestPanic(t *testing.T) {
+	errs := make(chan error, 1)
+	s := serve(t, minidb.NewExecutor(), Options{Workers: 2, OnError: func(err error) { errs <- err }})
+	c := dial(t, s.url)
+	file := filepath.Join(t.TempDir(), "test.sqlite")
+	if _, err := c.Exec(minidb.OpenCommand("sqlite3", file)); err != nil {
+		t.Fatalf("Open command failed: %s", err)
+	}
+	db := minidb.CommandDB(file)
+	// the executor panics on a Poll command without a subscription token
+	_, err := c.Exec(&minidb.Command{ID: minidb.CmdPoll, DB: db, Version: minidb.CommandVersion})
+	var cmdErr *minidb.CommandError
+	if !errors.As(err, &cmdErr) || cmdErr.Code != minidb.ErrInternal {
+		t.Errorf("a request that panics returned %v, expected ErrInternal", err)
+	}
+	var reqErr *RequestError
+	select {
+	case err := <-errs:
+		if !errors.As(err, &reqErr) || reqErr.Op != OpExecute {
+			t.Errorf("OnError() was called with %v, expected an execute error", err)
+		}
+	case <-time.After(time.Second):
+		t.Errorf("OnError() was not called for a request that panics")
+	}
+	// both workers still serve requests
+	for i := 0; i < 2; i++ {
+		if _, err := c.Exec(minidb.GetTablesCommand(db)); err != nil {
+			t.Errorf("GetTables command failed after a panic: %s", err)
+		}
+	}
+}
+
+func TestSlowRequest(t *testing.T) {
+	s := serve(t, minidb.NewExecutor(), Options{Workers: 2})
+	c := dial(t, s.url)
+	dir := t.TempDir()
+	a, b := minidb.CommandDB(filepath.Join(dir, "a.sqlite")), minidb.CommandDB(filepath.Join(dir, "b.sqlite"))
+	for _, db := range []minidb.CommandDB{a, b} {
+		if _, err := c.Exec(minidb.OpenCommand("sqlite3", string(db))); err != nil {
+			t.Fatalf("Open command failed: %s", err)
+		}
+		if _, err := c.Exec(minidb.AddTableCommand(db, "Person", []minidb.Field{{Name: "Name", Sort: minidb.DBString}})); err != nil {
+			t.Fatalf("AddTable command failed: %s", err)
+		}
+	}
+	r, err := c.Exec(minidb.SubscribeCommand(a, "Person"))
+	if err != nil {
+		t.Fatalf("Subscribe command failed: %s", err)
+	}
+	slow, err := client.DialTimeout(5*time.Second, s.url)
+	if err != nil {
+		t.Fatalf("DialTimeout() failed: %s", err)
+	}
+	defer slow.Close()
+	polled := make(chan error, 1)
+	go func() {
+		_, err := slow.Exec(minidb.PollCommand(a, r.Str, 3*time.Second))
+		polled <- err
+	}()
+	time.Sleep(200 * time.Millisecond)
+
+	// the poll waits for events of A in one worker while the other serves B
+	start := time.Now()
+	if _, err := c.Exec(minidb.NewItemCommand(b, 0, "Person")); err != nil {
+		t.Errorf("NewItem command on another database failed: %s", err)
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("a request on another database took %s while a poll was waiting", d)
+	}
+	select {
+	case <-polled:
+		t.Errorf("the poll returned although there were no events of its database")
+	default:
+	}
+	if _, err := c.Exec(minidb.NewItemCommand(a, 0, "Person")); err != nil {
+		t.Errorf("NewItem command failed: %s", err)
+	}
+	select {
+	case err := <-polled:
+		if err != nil {
+			t.Errorf("the waiting Poll command failed: %s", err)
+		}
+	case <-time.After(time.Second):
+		t.Errorf("the waiting Poll command did not return after a change of its database")
+	}
+}
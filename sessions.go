@@ -0,0 +1,114 @@
+package minidb
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"time"
+)
+
+// ------------------------------------------------------------------------------
+// Sessions of multiuser databases
+// ------------------------------------------------------------------------------
+
+// sessionTokenLength is the number of random bytes of a session token.
+const sessionTokenLength = 32
+
+// tokenHash returns the hash of a session token under which the session is stored, so the
+// tokens cannot be taken from the system database.
+func tokenHash(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// CreateSession creates a session for an authenticated user that is valid for the ttl and
+// returns its opaque token. Services can pass the token to ValidateSession on every request
+// instead of authenticating the user with the expensive key derivation each time.
+func (m *MultiDB) CreateSession(user *User, ttl time.Duration) (string, ErrCode, error) {
+	if user == nil || !m.ExistingUser(user.name) {
+		return "", ErrUnknownUser, Fail(`unknown user`)
+	}
+	buff := make([]byte, sessionTokenLength)
+	if n, err := rand.Read(buff); n != sessionTokenLength || err != nil {
+		return "", ErrCryptoRandFailure, Fail(`random number generator failed to generate session token`)
+	}
+	token := base64.RawURLEncoding.EncodeToString(buff)
+	tx, err := m.Begin()
+	if err != nil {
+		return "", ErrTransactionFail, err
+	}
+	defer tx.Rollback()
+	_, err = tx.NewItems("Session", [][]FieldValue{{
+		{Field: "User", Values: []Value{NewInt(int64(user.id))}},
+		{Field: "Token", Values: []Value{NewString(tokenHash(token))}},
+		{Field: "Expires", Values: []Value{NewInt(time.Now().Add(ttl).UnixNano())}}}})
+	if err != nil {
+		return "", ErrDBFail, Fail(`could not store session: %s`, err)
+	}
+	if err := tx.Commit(); err != nil {
+		return "", ErrTransactionFail, Fail(`multiuser database error: %s`, err)
+	}
+	return token, OK, nil
+}
+
+// ValidateSession returns the user of the session with the token. It fails with
+// ErrInvalidSession if there is no such session, with ErrSessionExpired if the session has
+// expired, and with ErrUserDisabled if the user has been disabled since it was created.
+func (m *MultiDB) ValidateSession(token string) (*User, ErrCode, error) {
+	var id, expires int64
+	var name string
+	err := m.system.reader.QueryRow(`SELECT Expires,User.Id,Username FROM Session JOIN User ON User.Id=Session.User
+WHERE Token=?;`, tokenHash(token)).Scan(&expires, &id, &name)
+	if err != nil {
+		return nil, ErrInvalidSession, Fail(`invalid session`)
+	}
+	if time.Now().UnixNano() >= expires {
+		m.RevokeSession(token)
+		return nil, ErrSessionExpired, Fail(`the session has expired`)
+	}
+	user := User{name: name, id: Item(id)}
+	if m.loginState(user.id).disabled {
+		return nil, ErrUserDisabled, Fail(`user "%s" is disabled`, name)
+	}
+	return &user, OK, nil
+}
+
+// RevokeSession ends the session with the token. Revoking an unknown session does nothing.
+func (m *MultiDB) RevokeSession(token string) (ErrCode, error) {
+	var id int64
+	if err := m.system.reader.QueryRow(`SELECT Id FROM Session WHERE Token=?;`, tokenHash(token)).Scan(&id); err != nil {
+		return OK, nil
+	}
+	tx, err := m.Begin()
+	if err != nil {
+		return ErrTransactionFail, err
+	}
+	defer tx.Rollback()
+	if err := tx.RemoveItem("Session", Item(id)); err != nil {
+		return ErrDBFail, err
+	}
+	if err := tx.Commit(); err != nil {
+		return ErrTransactionFail, Fail(`multiuser database error: %s`, err)
+	}
+	return OK, nil
+}
+
+// RevokeSessions ends all sessions of the user, for example after the password has been changed.
+func (m *MultiDB) RevokeSessions(user *User) (ErrCode, error) {
+	if user == nil {
+		return ErrUnknownUser, Fail(`unknown user`)
+	}
+	tx, err := m.Begin()
+	if err != nil {
+		return ErrTransactionFail, err
+	}
+	defer tx.Rollback()
+	if err := removeUserItems(tx, "Session", user); err != nil {
+		return ErrDBFail, err
+	}
+	if err := tx.Commit(); err != nil {
+		return ErrTransactionFail, Fail(`multiuser database error: %s`, err)
+	}
+	return OK, nil
+}
@@ -0,0 +1,74 @@
+package minidb
+
+// ------------------------------------------------------------------------------
+// Shutting down the server of an executor
+// ------------------------------------------------------------------------------
+
+// SetShutdownHandler sets the function that a ServerShutdown command calls to stop the server
+// of the executor. The function must return without waiting for the server to stop, since the
+// command is executed by the server itself. A nil function, the default, makes ServerShutdown
+// commands fail with ErrUnsupportedCommand. Servers of the package server set the handler while
+// they are serving. The command is refused with ErrNotPermitted unless the server requires
+// authentication, see SetAuthTokens and SetUserAuth, since anyone could stop it otherwise, and
+// unless the client has admin rights or neither the client nor the executor has roles.
+func (e *Executor) SetShutdownHandler(shutdown func()) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.shutdown = shutdown
+}
+
+// getShutdownHandler returns the function that stops the server of the executor, or nil.
+func (e *Executor) getShutdownHandler() func() {
+	e.mutex.RLock()
+	defer e.mutex.RUnlock()
+	return e.shutdown
+}
+
+// serverShutdown executes a ServerShutdown command.
+func (e *Executor) serverShutdown(cmd *Command) *Result {
+	var r Result
+	shutdown := e.getShutdownHandler()
+	if shutdown == nil {
+		r.HasError = true
+		r.Int = ErrUnsupportedCommand
+		r.Str = Fail("exec failed: the executor has no server that can be shut down").Error()
+		return &r
+	}
+	if !e.AuthRequired() {
+		r.HasError = true
+		r.Int = ErrNotPermitted
+		r.Str = Fail("exec failed: the server can only be shut down if it requires authentication").Error()
+		return &r
+	}
+	if !e.mayShutDown(cmd) {
+		r.HasError = true
+		r.Int = ErrNotPermitted
+		r.Str = Fail("exec failed: the server can only be shut down with admin rights").Error()
+		return &r
+	}
+	shutdown()
+	return &r
+}
+
+// mayShutDown returns true if the client of the command may shut down the server, which needs
+// admin rights unless neither the client nor the executor is restricted to roles, as for the
+// connections authenticated with an API token by an executor without roles.
+func (e *Executor) mayShutDown(cmd *Command) bool {
+	if e.isAdmin(cmd) {
+		return true
+	}
+	e.mutex.RLock()
+	defer e.mutex.RUnlock()
+	return cmd.roles == nil && e.roles == nil
+}
+
+// ServerShutdownCommand returns a pointer to a command structure that stops the server. The server
+// answers the requests it is handling, and mdbserve then closes all databases, which commits the
+// open transactions, and exits. The command is only accepted on authenticated connections of
+// servers that require authentication, and only from clients with admin rights if the client or
+// the executor has roles. Read-only executors refuse it.
+func ServerShutdownCommand() *Command {
+	return &Command{
+		ID: CmdServerShutdown,
+	}
+}
@@ -0,0 +1,167 @@
+package minidb
+
+import (
+	"errors"
+	"os"
+)
+
+// ------------------------------------------------------------------------------
+// Size and quota
+// ------------------------------------------------------------------------------
+
+// ErrDatabaseFull is returned by write operations that would make the database grow beyond
+// the maximum size set by SetMaxSize.
+var ErrDatabaseFull = errors.New("database is full")
+
+// DBSize contains the size of the database file and the number of pages used by Sqlite.
+// Free pages are part of the file but unused, they can be reclaimed by vacuuming the database.
+type DBSize struct {
+	FileSize      int64 `json:"filesize"`
+	PageSize      int64 `json:"pagesize"`
+	PageCount     int64 `json:"pages"`
+	FreelistCount int64 `json:"freepages"`
+}
+
+// DatabaseSize returns the size of the database file and its page counts.
+func (db *MDB) DatabaseSize() (DBSize, error) {
+	var size DBSize
+	if err := db.reader.QueryRow(`PRAGMA page_size;`).Scan(&size.PageSize); err != nil {
+		return size, Fail("cannot determine page size: %s", err)
+	}
+	if err := db.reader.QueryRow(`PRAGMA page_count;`).Scan(&size.PageCount); err != nil {
+		return size, Fail("cannot determine page count: %s", err)
+	}
+	if err := db.reader.QueryRow(`PRAGMA freelist_count;`).Scan(&size.FreelistCount); err != nil {
+		return size, Fail("cannot determine number of free pages: %s", err)
+	}
+	if info, err := os.Stat(db.location); err == nil {
+		size.FileSize = info.Size()
+	} else {
+		size.FileSize = size.PageSize * size.PageCount
+	}
+	return size, nil
+}
+
+// Compact rebuilds the database without its free pages with VACUUM and updates the statistics
+// used by SQLite to plan queries with ANALYZE. It returns the number of bytes by which the
+// database has shrunk. Databases shrink only by compacting, not by removing items. Compact waits
+// for the transaction in progress like Begin and blocks writes until it is finished. It needs
+// temporary disk space of about twice the size of the database.
+func (db *MDB) Compact() (int64, error) {
+	if db.tx != nil {
+		return 0, Fail("cannot compact a view of a transaction")
+	}
+	if db.globalLock == nil {
+		return 0, Fail("cannot compact a closed database")
+	}
+	if err := db.waitForTx(); err != nil {
+		return 0, err
+	}
+	defer func() { <-db.txSlot }()
+	if err := db.initIfPending(); err != nil {
+		return 0, err
+	}
+	before, err := db.DatabaseSize()
+	if err != nil {
+		return 0, err
+	}
+	if _, err := db.base.Exec(`VACUUM;`); err != nil {
+		return 0, Fail("vacuum failed: %s", err)
+	}
+	if _, err := db.base.Exec(`ANALYZE;`); err != nil {
+		return 0, Fail("analyze failed: %s", err)
+	}
+	// in the WAL journal mode the file only shrinks when the log is written back
+	if _, err := db.base.Exec(`PRAGMA wal_checkpoint(TRUNCATE);`); err != nil {
+		return 0, Fail("checkpoint failed: %s", err)
+	}
+	after, err := db.DatabaseSize()
+	if err != nil {
+		return 0, err
+	}
+	return before.PageSize*before.PageCount - after.PageSize*after.PageCount, nil
+}
+
+// TableSize returns the number of bytes used by a table, including its list fields and indices.
+// This requires an Sqlite version compiled with support for the dbstat virtual table.
+func (db *MDB) TableSize(table string) (int64, error) {
+	if !validTable.MatchString(table) {
+		return 0, Fail("invalid table name '%s'", table)
+	}
+	if !db.TableExists(table) {
+		return 0, tableNotFound(table)
+	}
+	fields, err := db.GetFields(table)
+	if err != nil {
+		return 0, err
+	}
+	tables := []interface{}{table}
+	placeholders := "?"
+	for _, field := range fields {
+		if isListFieldType(field.Sort) {
+			tables = append(tables, listFieldToTableName(table, field.Name))
+			placeholders += ",?"
+		}
+	}
+	var size int64
+	err = db.reader.QueryRow(`SELECT COALESCE(SUM(pgsize),0) FROM dbstat WHERE name IN
+(SELECT name FROM sqlite_master WHERE tbl_name IN (`+placeholders+`));`, tables...).Scan(&size)
+	if err != nil {
+		return 0, Fail("cannot determine size of table '%s': %s", table, err)
+	}
+	return size, nil
+}
+
+// SetMaxSize sets the maximum size of the database in bytes. Writes that would make the database
+// grow beyond this size fail with ErrDatabaseFull, before Sqlite runs out of disk space in the middle
+// of a transaction. The size of a write is estimated from the size of the values, so the limit is not
+// exact. A maximum size of 0 disables the check, which is the default.
+func (db *MDB) SetMaxSize(bytes int64) {
+	db.maxSize = bytes
+}
+
+// MaxSize returns the maximum size of the database set by SetMaxSize.
+func (db *MDB) MaxSize() int64 {
+	return db.maxSize
+}
+
+// valuesSize returns the number of bytes needed for the values, where an integer counts as 8 bytes.
+func valuesSize(data []Value) int64 {
+	var n int64
+	for i := range data {
+		if data[i].Sort == DBInt {
+			n += 8
+		} else {
+			n += int64(len(data[i].Str))
+		}
+	}
+	return n
+}
+
+// checkSize returns ErrDatabaseFull if writing n bytes would exceed the maximum size.
+// The size is determined within the given transaction or outside of any transaction if tx is nil.
+func (db *MDB) checkSize(tx *Tx, n int64) error {
+	if db.maxSize <= 0 {
+		return nil
+	}
+	var pageSize, pageCount int64
+	var err error
+	if tx != nil {
+		err = tx.tx.QueryRow(`PRAGMA page_size;`).Scan(&pageSize)
+		if err == nil {
+			err = tx.tx.QueryRow(`PRAGMA page_count;`).Scan(&pageCount)
+		}
+	} else {
+		err = db.reader.QueryRow(`PRAGMA page_size;`).Scan(&pageSize)
+		if err == nil {
+			err = db.reader.QueryRow(`PRAGMA page_count;`).Scan(&pageCount)
+		}
+	}
+	if err != nil {
+		return Fail("cannot determine database size: %s", err)
+	}
+	if pageSize*pageCount+n > db.maxSize {
+		return ErrDatabaseFull
+	}
+	return nil
+}
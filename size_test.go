@@ -0,0 +1,99 @@
+package minidb
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestSize(t *testing.T) {
+	tmp, _ := ioutil.TempFile("", "minidb-size-testing-*")
+	defer os.Remove(tmp.Name())
+	db, err := Open("sqlite3", tmp.Name())
+	if err != nil {
+		t.Errorf("Open() failed: %s", err)
+	}
+	defer db.Close()
+	if err := db.AddTable("Person", []Field{Field{Name: "Name", Sort: DBString}, Field{Name: "Tags", Sort: DBStringList}}); err != nil {
+		t.Errorf("AddTable() failed: %s", err)
+	}
+	item, _ := db.NewItem("Person")
+	size, err := db.DatabaseSize()
+	if err != nil {
+		t.Errorf("DatabaseSize() failed: %s", err)
+	}
+	if size.PageSize <= 0 || size.PageCount <= 0 || size.FileSize != size.PageSize*size.PageCount {
+		t.Errorf("DatabaseSize() returned inconsistent sizes: %v", size)
+	}
+	if n, err := db.TableSize("Person"); err == nil && n <= 0 {
+		t.Errorf("TableSize() returned %d", n)
+	} else if err != nil && !strings.Contains(err.Error(), "dbstat") {
+		t.Errorf("TableSize() failed: %s", err)
+	}
+	if _, err := db.TableSize("Nobody"); err == nil {
+		t.Errorf("TableSize() should fail for nonexistent table")
+	}
+
+	db.SetMaxSize(size.FileSize + 1000)
+	tx, err := db.Begin()
+	if err != nil {
+		t.Errorf("Begin() failed: %s", err)
+	}
+	if err := tx.Set("Person", item, "Name", []Value{NewString("John")}); err != nil {
+		t.Errorf("Set() failed below the maximum size: %s", err)
+	}
+	if err := tx.Set("Person", item, "Tags", []Value{NewString(strings.Repeat("x", 2000))}); err != ErrDatabaseFull {
+		t.Errorf("Set() returned %v above the maximum size, expected ErrDatabaseFull", err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Errorf("Commit() failed: %s", err)
+	}
+	db.SetMaxSize(1)
+	if _, err := db.NewItem("Person"); err != ErrDatabaseFull {
+		t.Errorf("NewItem() returned %v above the maximum size, expected ErrDatabaseFull", err)
+	}
+	db.SetMaxSize(0)
+	if _, err := db.NewItem("Person"); err != nil {
+		t.Errorf("NewItem() failed without maximum size: %s", err)
+	}
+}
+
+func TestCompact(t *testing.T) {
+	tmp, _ := ioutil.TempFile("", "minidb-size-testing-*")
+	defer os.Remove(tmp.Name())
+	db, err := Open("sqlite3", tmp.Name())
+	if err != nil {
+		t.Fatalf("Open() failed: %s", err)
+	}
+	defer db.Close()
+	db.AddTable("Note", []Field{Field{Name: "Text", Sort: DBString}})
+	items := make([]Item, 50)
+	for i := range items {
+		items[i], _ = db.NewItem("Note")
+	}
+	tx, _ := db.Begin()
+	for _, item := range items {
+		tx.Set("Note", item, "Text", []Value{NewString(strings.Repeat("x", 10000))})
+	}
+	tx.Commit()
+	tx, _ = db.Begin()
+	tx.RemoveItems("Note", items)
+	tx.Commit()
+	before, _ := db.DatabaseSize()
+	reclaimed, err := db.Compact()
+	if err != nil {
+		t.Fatalf("Compact() failed: %s", err)
+	}
+	after, _ := db.DatabaseSize()
+	if reclaimed <= 0 || after.FreelistCount != 0 || after.FileSize != before.FileSize-reclaimed {
+		t.Errorf("Compact() reclaimed %d bytes, size before %v and after %v", reclaimed, before, after)
+	}
+
+	e := NewExecutor()
+	defer e.CloseAllDBs()
+	e.Exec(OpenCommand("sqlite3", tmp.Name()))
+	if r := e.Exec(CompactCommand(CommandDB(tmp.Name()))); r.HasError || r.Int != 0 {
+		t.Errorf("Compact command returned %v, expected nothing to reclaim", r)
+	}
+}
@@ -0,0 +1,140 @@
+package minidb
+
+import (
+	"database/sql"
+	"sort"
+)
+
+// ------------------------------------------------------------------------------
+// Usage statistics
+// ------------------------------------------------------------------------------
+
+// TableStats contains the cumulative number of read and write operations on a table and
+// the number of bytes written to it. Reads are counted by Get, Find and FindWithin, writes
+// by NewItem, UseItem, Set and RemoveItem. The bytes written are the sizes of the values
+// passed to Set, where an integer counts as 8 bytes.
+type TableStats struct {
+	Table        string `json:"table"`
+	Reads        int64  `json:"reads"`
+	Writes       int64  `json:"writes"`
+	BytesWritten int64  `json:"bytes"`
+}
+
+// countRead records a read operation on the table.
+func (db *MDB) countRead(table string) {
+	db.countOp(table, 1, 0, 0)
+}
+
+// countWrite records a write operation on the table.
+func (db *MDB) countWrite(table string, data []Value) {
+	db.countOp(table, 0, 1, valuesSize(data))
+}
+
+func (db *MDB) countOp(table string, reads, writes, bytes int64) {
+	db.statsLock.Lock()
+	defer db.statsLock.Unlock()
+	stats, ok := db.stats[table]
+	if !ok {
+		stats = &TableStats{Table: table}
+		db.stats[table] = stats
+	}
+	stats.Reads += reads
+	stats.Writes += writes
+	stats.BytesWritten += bytes
+}
+
+// execer is implemented by sql.DB and sql.Tx.
+type execer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+// flushStats adds the statistics gathered since the last flush to the housekeeping table. It is
+// called when the database is closed or backed up, outside of transactions.
+func (db *MDB) flushStats(e execer) error {
+	written, err := db.writeStats(e)
+	if err != nil {
+		return err
+	}
+	db.clearStats(written)
+	return nil
+}
+
+// writeStats adds the statistics gathered since the last flush to the housekeeping table and
+// returns them. They remain pending until they are removed with clearStats, which the outermost
+// transaction does once it has been committed, so they are not lost if the commit fails.
+func (db *MDB) writeStats(e execer) (map[string]TableStats, error) {
+	db.statsLock.Lock()
+	written := make(map[string]TableStats, len(db.stats))
+	for table, stats := range db.stats {
+		written[table] = *stats
+	}
+	db.statsLock.Unlock()
+	for table, stats := range written {
+		_, err := e.Exec(`INSERT INTO _STATS (TableName,Reads,Writes,BytesWritten) VALUES (?,?,?,?)
+ON CONFLICT(TableName) DO UPDATE SET Reads=Reads+excluded.Reads,Writes=Writes+excluded.Writes,
+BytesWritten=BytesWritten+excluded.BytesWritten;`, table, stats.Reads, stats.Writes, stats.BytesWritten)
+		if err != nil {
+			return nil, Fail("cannot update usage statistics: %s", err)
+		}
+	}
+	return written, nil
+}
+
+// clearStats subtracts the statistics returned by writeStats from those gathered since the last
+// flush, keeping the operations counted in the meantime.
+func (db *MDB) clearStats(written map[string]TableStats) {
+	db.statsLock.Lock()
+	defer db.statsLock.Unlock()
+	for table, done := range written {
+		stats, ok := db.stats[table]
+		if !ok {
+			continue
+		}
+		stats.Reads -= done.Reads
+		stats.Writes -= done.Writes
+		stats.BytesWritten -= done.BytesWritten
+		if stats.Reads == 0 && stats.Writes == 0 && stats.BytesWritten == 0 {
+			delete(db.stats, table)
+		}
+	}
+}
+
+// UsageStats returns the usage statistics of all tables, sorted by table name. The statistics
+// are persisted in the database and count all operations since it has been created, including
+// those on tables that have been dropped or renamed in the meantime.
+func (db *MDB) UsageStats() ([]TableStats, error) {
+	rows, err := db.reader.Query(`SELECT TableName,Reads,Writes,BytesWritten FROM _STATS;`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	all := make(map[string]*TableStats)
+	for rows.Next() {
+		stats := &TableStats{}
+		if err := rows.Scan(&stats.Table, &stats.Reads, &stats.Writes, &stats.BytesWritten); err != nil {
+			return nil, err
+		}
+		all[stats.Table] = stats
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	db.statsLock.Lock()
+	for table, pending := range db.stats {
+		stats, ok := all[table]
+		if !ok {
+			stats = &TableStats{Table: table}
+			all[table] = stats
+		}
+		stats.Reads += pending.Reads
+		stats.Writes += pending.Writes
+		stats.BytesWritten += pending.BytesWritten
+	}
+	db.statsLock.Unlock()
+	result := make([]TableStats, 0, len(all))
+	for _, stats := range all {
+		result = append(result, *stats)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Table < result[j].Table })
+	return result, nil
+}
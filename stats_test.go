@@ -0,0 +1,91 @@
+package minidb
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestUsageStats(t *testing.T) {
+	tmp, _ := ioutil.TempFile("", "minidb-stats-testing-*")
+	defer os.Remove(tmp.Name())
+	db, err := Open("sqlite3", tmp.Name())
+	if err != nil {
+		t.Errorf("Open() failed: %s", err)
+	}
+	if err := db.AddTable("Person", []Field{Field{Name: "Name", Sort: DBString}, Field{Name: "Age", Sort: DBInt}}); err != nil {
+		t.Errorf("AddTable() failed: %s", err)
+	}
+	item, _ := db.NewItem("Person")
+	tx, err := db.Begin()
+	if err != nil {
+		t.Errorf("Begin() failed: %s", err)
+	}
+	tx.Set("Person", item, "Name", []Value{NewString("John")})
+	tx.Set("Person", item, "Age", []Value{NewInt(42)})
+	if err := tx.Commit(); err != nil {
+		t.Errorf("Commit() failed: %s", err)
+	}
+	db.Get("Person", item, "Name")
+	q, _ := ParseQuery("Person Name=J%")
+	db.Find(q, 0)
+	stats, err := db.UsageStats()
+	if err != nil {
+		t.Errorf("UsageStats() failed: %s", err)
+	}
+	expected := TableStats{Table: "Person", Reads: 2, Writes: 3, BytesWritten: 12}
+	if len(stats) != 1 || stats[0] != expected {
+		t.Errorf("UsageStats() returned %v, expected %v", stats, expected)
+	}
+	db.Close()
+
+	db, err = Open("sqlite3", tmp.Name())
+	if err != nil {
+		t.Errorf("Open() failed: %s", err)
+	}
+	defer db.Close()
+	db.Get("Person", item, "Age")
+	stats, err = db.UsageStats()
+	expected.Reads++
+	if err != nil || len(stats) != 1 || stats[0] != expected {
+		t.Errorf("UsageStats() returned %v, %v after reopening, expected %v", stats, err, expected)
+	}
+}
+
+func TestUsageStatsFailedCommit(t *testing.T) {
+	tmp, _ := ioutil.TempFile("", "minidb-stats-testing-*")
+	defer os.Remove(tmp.Name())
+	db, err := OpenWithOptions("sqlite3", tmp.Name(), &Options{ForeignKeys: true})
+	if err != nil {
+		t.Fatalf("OpenWithOptions() failed: %s", err)
+	}
+	defer db.Close()
+	if err := db.AddTable("Person", []Field{Field{Name: "Name", Sort: DBString}}); err != nil {
+		t.Errorf("AddTable() failed: %s", err)
+	}
+	if _, err := db.Base().Exec(`CREATE TABLE Ref (Person INTEGER REFERENCES Person(Id) DEFERRABLE INITIALLY DEFERRED);`); err != nil {
+		t.Fatalf("cannot create table: %s", err)
+	}
+	item, _ := db.NewItem("Person")
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatalf("Begin() failed: %s", err)
+	}
+	tx.Set("Person", item, "Name", []Value{NewString("John")})
+	// the violated constraint makes the commit fail
+	tx.tx.Exec(`INSERT INTO Ref (Person) VALUES (?);`, item+100)
+	if err := tx.Commit(); err == nil {
+		t.Fatalf("Commit() should fail with a violated foreign key constraint")
+	}
+	stats, err := db.UsageStats()
+	expected := TableStats{Table: "Person", Writes: 2, BytesWritten: 4}
+	if err != nil || len(stats) != 1 || stats[0] != expected {
+		t.Errorf("UsageStats() returned %v, %v after a failed commit, expected %v", stats, err, expected)
+	}
+	if err := db.flushStats(db.Base()); err != nil {
+		t.Errorf("flushStats() failed: %s", err)
+	}
+	if stats, _ := db.UsageStats(); len(stats) != 1 || stats[0] != expected {
+		t.Errorf("UsageStats() returned %v after flushing, expected %v", stats, expected)
+	}
+}
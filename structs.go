@@ -0,0 +1,256 @@
+package minidb
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"time"
+)
+
+// ------------------------------------------------------------------------------
+// Struct mapping
+// ------------------------------------------------------------------------------
+
+// Insert and Scan map struct fields to database fields by struct tags of the form
+// `minidb:"FieldName"`. Struct fields without such a tag or with the tag `minidb:"-"` are ignored.
+// Go integer types are mapped to int fields, string to string fields, []byte to blob fields and
+// time.Time to date fields. Slices of these types, except for []byte, are mapped to list fields.
+
+var timeType = reflect.TypeOf(time.Time{})
+
+type structMapping struct {
+	name  string
+	value reflect.Value
+}
+
+// structMappings returns the tagged fields of the struct pointed to by v.
+func structMappings(v interface{}) ([]structMapping, error) {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() || rv.Elem().Kind() != reflect.Struct {
+		return nil, Fail("expected a pointer to a struct, given %T", v)
+	}
+	rv = rv.Elem()
+	rt := rv.Type()
+	result := make([]structMapping, 0, rt.NumField())
+	for i := 0; i < rt.NumField(); i++ {
+		name, ok := rt.Field(i).Tag.Lookup("minidb")
+		if !ok || name == "" || name == "-" {
+			continue
+		}
+		if !rv.Field(i).CanSet() {
+			return nil, Fail("struct field %s with tag '%s' is not exported", rt.Field(i).Name, name)
+		}
+		result = append(result, structMapping{name: name, value: rv.Field(i)})
+	}
+	return result, nil
+}
+
+// isListGoType returns true if values of the type are stored in list fields.
+func isListGoType(t reflect.Type) bool {
+	return t.Kind() == reflect.Slice && t.Elem().Kind() != reflect.Uint8
+}
+
+func goToValue(rv reflect.Value) (Value, error) {
+	if rv.Type() == timeType {
+		return NewDate(rv.Interface().(time.Time)), nil
+	}
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return NewInt(rv.Int()), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if rv.Uint() > math.MaxInt64 {
+			return Value{}, Fail("value %d is too large for an int field", rv.Uint())
+		}
+		return NewInt(int64(rv.Uint())), nil
+	case reflect.String:
+		return NewString(rv.String()), nil
+	case reflect.Slice:
+		if rv.Type().Elem().Kind() == reflect.Uint8 {
+			return NewBytes(rv.Bytes()), nil
+		}
+	}
+	return Value{}, Fail("unsupported type %s", rv.Type())
+}
+
+func goToValues(rv reflect.Value) ([]Value, error) {
+	if !isListGoType(rv.Type()) {
+		v, err := goToValue(rv)
+		if err != nil {
+			return nil, err
+		}
+		return []Value{v}, nil
+	}
+	result := make([]Value, rv.Len())
+	for i := range result {
+		v, err := goToValue(rv.Index(i))
+		if err != nil {
+			return nil, err
+		}
+		result[i] = v
+	}
+	return result, nil
+}
+
+func valueToGo(v Value, rv reflect.Value) error {
+	if rv.Type() == timeType {
+		if v.Sort != DBDate {
+			return Fail("cannot store %s value in %s", GetUserTypeString(v.Sort), rv.Type())
+		}
+		t, err := ParseTime(v.Str)
+		if err != nil {
+			return err
+		}
+		rv.Set(reflect.ValueOf(t))
+		return nil
+	}
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if v.Sort != DBInt {
+			break
+		}
+		if rv.OverflowInt(v.Num) {
+			return Fail("value %d overflows %s", v.Num, rv.Type())
+		}
+		rv.SetInt(v.Num)
+		return nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if v.Sort != DBInt {
+			break
+		}
+		if v.Num < 0 || rv.OverflowUint(uint64(v.Num)) {
+			return Fail("value %d overflows %s", v.Num, rv.Type())
+		}
+		rv.SetUint(uint64(v.Num))
+		return nil
+	case reflect.String:
+		if v.Sort != DBString {
+			break
+		}
+		rv.SetString(v.Str)
+		return nil
+	case reflect.Slice:
+		if v.Sort != DBBlob || rv.Type().Elem().Kind() != reflect.Uint8 {
+			break
+		}
+		rv.SetBytes(v.Bytes())
+		return nil
+	}
+	return Fail("cannot store %s value in %s", GetUserTypeString(v.Sort), rv.Type())
+}
+
+// Insert creates a new item in the table and sets its fields to the values of the tagged fields
+// of the struct pointed to by v. Empty slices are not stored, so list fields with a default value
+// keep their default. If a field cannot be set, the new item is removed again and an error is returned.
+func (db *MDB) Insert(table string, v interface{}) (Item, error) {
+	mappings, err := structMappings(v)
+	if err != nil {
+		return 0, err
+	}
+	for _, m := range mappings {
+		if !db.FieldExists(table, m.name) {
+			return 0, fieldNotFound(table, m.name)
+		}
+	}
+	item, err := db.NewItem(table)
+	if err != nil {
+		return 0, err
+	}
+	if err := db.setStruct(table, item, mappings); err != nil {
+		if tx, txErr := db.Begin(); txErr == nil {
+			tx.RemoveItem(table, item)
+			tx.Commit()
+		}
+		return 0, err
+	}
+	return item, nil
+}
+
+func (db *MDB) setStruct(table string, item Item, mappings []structMapping) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, m := range mappings {
+		values, err := goToValues(m.value)
+		if err != nil {
+			return Fail("cannot convert struct field for %s %s: %s", table, m.name, err)
+		}
+		if isListGoType(m.value.Type()) && len(values) == 0 {
+			continue
+		}
+		if err := tx.Set(table, item, m.name, values); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// Scan reads the fields of an item into the tagged fields of the struct pointed to by v.
+// Fields without a value are set to the zero value of their Go type.
+func (db *MDB) Scan(table string, item Item, v interface{}) error {
+	mappings, err := structMappings(v)
+	if err != nil {
+		return err
+	}
+	if !validTable.MatchString(table) {
+		return Fail("invalid table name '%s'", table)
+	}
+	if !db.TableExists(table) {
+		return tableNotFound(table)
+	}
+	if !db.ItemExists(table, item) {
+		return itemNotFound(table, item)
+	}
+	for _, m := range mappings {
+		if !db.FieldExists(table, m.name) {
+			return fieldNotFound(table, m.name)
+		}
+		isList := db.IsListField(table, m.name)
+		if isList != isListGoType(m.value.Type()) {
+			return Fail("cannot scan %s field %s %s into %s", GetUserTypeString(db.MustGetFieldType(table, m.name)),
+				table, m.name, m.value.Type())
+		}
+		m.value.Set(reflect.Zero(m.value.Type()))
+		isNull, err := db.isNullOrEmpty(table, item, m.name, isList)
+		if err != nil {
+			return err
+		}
+		if isNull {
+			continue
+		}
+		values, err := db.Get(table, item, m.name)
+		if err != nil {
+			return err
+		}
+		if !isList {
+			if err := valueToGo(values[0], m.value); err != nil {
+				return Fail("cannot scan %s %d %s: %s", table, item, m.name, err)
+			}
+			continue
+		}
+		slice := reflect.MakeSlice(m.value.Type(), len(values), len(values))
+		for i := range values {
+			if err := valueToGo(values[i], slice.Index(i)); err != nil {
+				return Fail("cannot scan %s %d %s: %s", table, item, m.name, err)
+			}
+		}
+		m.value.Set(slice)
+	}
+	return nil
+}
+
+// isNullOrEmpty returns true if a single field is NULL or a list field has no elements.
+func (db *MDB) isNullOrEmpty(table string, item Item, field string, isList bool) (bool, error) {
+	var query string
+	if isList {
+		query = fmt.Sprintf(`SELECT NOT EXISTS (SELECT 1 FROM "%s" WHERE Owner=?);`, listFieldToTableName(table, field))
+	} else {
+		query = fmt.Sprintf(`SELECT "%s" IS NULL FROM "%s" WHERE Id=?;`, field, table)
+	}
+	var result bool
+	if err := db.reader.QueryRow(query, item).Scan(&result); err != nil {
+		return false, Fail("cannot read %s %d %s: %s", table, item, field, err)
+	}
+	return result, nil
+}
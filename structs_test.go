@@ -0,0 +1,78 @@
+package minidb
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+type structTestPerson struct {
+	ID       int64       `minidb:"-"`
+	Name     string      `minidb:"Name"`
+	Age      uint8       `minidb:"Age"`
+	Born     time.Time   `minidb:"Born"`
+	Photo    []byte      `minidb:"Photo"`
+	Nicks    []string    `minidb:"Nicks"`
+	Visits   []time.Time `minidb:"Visits"`
+	Internal string
+}
+
+func TestStructMapping(t *testing.T) {
+	tmp, _ := ioutil.TempFile("", "minidb-structs-testing-*")
+	defer os.Remove(tmp.Name())
+	db, err := Open("sqlite3", tmp.Name())
+	if err != nil {
+		t.Errorf("Open() failed: %s", err)
+	}
+	defer db.Close()
+	if err := db.AddTable("Person", []Field{Field{Name: "Name", Sort: DBString}, Field{Name: "Age", Sort: DBInt},
+		Field{Name: "Born", Sort: DBDate}, Field{Name: "Photo", Sort: DBBlob},
+		Field{Name: "Nicks", Sort: DBStringList}, Field{Name: "Visits", Sort: DBDateList}}); err != nil {
+		t.Errorf("AddTable() failed: %s", err)
+	}
+	born := time.Date(1970, 3, 4, 5, 6, 7, 0, time.UTC)
+	john := structTestPerson{Name: "John", Age: 42, Born: born, Photo: []byte{0, 1, 2},
+		Nicks: []string{"Johnny", "J"}, Internal: "not stored"}
+	item, err := db.Insert("Person", &john)
+	if err != nil {
+		t.Errorf("Insert() failed: %s", err)
+	}
+	var p structTestPerson
+	p.Visits = []time.Time{born}
+	if err := db.Scan("Person", item, &p); err != nil {
+		t.Errorf("Scan() failed: %s", err)
+	}
+	if p.Name != "John" || p.Age != 42 || !p.Born.Equal(born) || string(p.Photo) != string(john.Photo) ||
+		len(p.Nicks) != 2 || p.Nicks[1] != "J" || p.Visits != nil || p.Internal != "" {
+		t.Errorf("Scan() returned %v, expected %v", p, john)
+	}
+
+	type wrong struct {
+		Name int `minidb:"Name"`
+	}
+	if _, err := db.Insert("Person", &wrong{Name: 1}); err == nil {
+		t.Errorf("Insert() should fail for a type mismatch")
+	}
+	if n, _ := db.Count("Person"); n != 1 {
+		t.Errorf("Insert() did not remove the item after a failure, %d items", n)
+	}
+	if err := db.Scan("Person", item, &wrong{}); err == nil {
+		t.Errorf("Scan() should fail for a type mismatch")
+	}
+	type noList struct {
+		Nicks string `minidb:"Nicks"`
+	}
+	if err := db.Scan("Person", item, &noList{}); err == nil {
+		t.Errorf("Scan() should fail for a list field mapped to a string")
+	}
+	type unknown struct {
+		Height int `minidb:"Height"`
+	}
+	if _, err := db.Insert("Person", &unknown{}); err == nil {
+		t.Errorf("Insert() should fail for an unknown field")
+	}
+	if _, err := db.Insert("Person", john); err == nil {
+		t.Errorf("Insert() should fail for a struct that is not passed by pointer")
+	}
+}
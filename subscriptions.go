@@ -0,0 +1,257 @@
+package minidb
+
+import (
+	"sync"
+	"time"
+)
+
+// ------------------------------------------------------------------------------
+// Subscriptions of clients to the changes of tables
+// ------------------------------------------------------------------------------
+
+// maxSubscriptionEvents is the number of events a subscription keeps until they are polled. Older
+// events are dropped when more arrive.
+const maxSubscriptionEvents = 1000
+
+// MaxPollWait is the longest time a Poll command waits for events.
+const MaxPollWait = 30 * time.Second
+
+// subscriptionTimeout is the time after which an executor removes a subscription that has not
+// been polled.
+const subscriptionTimeout = 10 * time.Minute
+
+// subscription collects the change events of a table, optionally only for the items that match
+// a query, until a client polls them. Its mutex protects the other fields. Ready receives a
+// value when events arrive and done is closed when the subscription is removed.
+type subscription struct {
+	db     CommandDB
+	table  string
+	query  *Query
+	events []ChangeEvent
+	lost   bool
+	used   time.Time
+	stop   func()
+	ready  chan struct{}
+	done   chan struct{}
+	mutex  sync.Mutex
+}
+
+// subscribe registers a subscription to the changes of the table in the database, or to those
+// of the items of its table that match the query if it is not nil, and returns its token.
+// Subscriptions that have not been polled for subscriptionTimeout are removed.
+func (e *Executor) subscribe(db CommandDB, theDB *MDB, table string, query *Query) (string, error) {
+	if query != nil {
+		q := *query
+		query = &q
+		table = query.Data
+		if len(query.Children) == 0 {
+			return "", Fail("incomplete query, only table given")
+		}
+	}
+	if !theDB.TableExists(table) {
+		return "", tableNotFound(table)
+	}
+	token, err := newToken("subscription")
+	if err != nil {
+		return "", err
+	}
+	s := &subscription{db: db, table: table, query: query, used: time.Now(),
+		ready: make(chan struct{}, 1), done: make(chan struct{})}
+	s.stop = theDB.OnChange(func(event ChangeEvent) { s.publish(theDB, event) })
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	for other, old := range e.subscriptions {
+		old.mutex.Lock()
+		expired := time.Since(old.used) > subscriptionTimeout
+		old.mutex.Unlock()
+		if expired {
+			e.removeSubscriptionLocked(other)
+		}
+	}
+	e.subscriptions[token] = s
+	return token, nil
+}
+
+// publish adds the event to the subscription if it concerns its table. New and modified items
+// are only added if they match the query of the subscription, while removed items are always
+// added because they cannot be matched anymore. Events without a table concern all tables.
+func (s *subscription) publish(db *MDB, event ChangeEvent) {
+	s.mutex.Lock()
+	table := s.table
+	query := s.query
+	s.mutex.Unlock()
+	if event.Table != "" && event.Table != table && event.OldTable != table {
+		return
+	}
+	if query != nil && (event.Kind == ChangeNewItem || event.Kind == ChangeSet) {
+		items, err := db.FindWithin(query, []Item{event.Item}, 1)
+		if err != nil || len(items) == 0 {
+			return
+		}
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if event.OldTable == s.table {
+		// follow the renamed table
+		s.table = event.Table
+		if s.query != nil {
+			q := *s.query
+			q.Data = event.Table
+			s.query = &q
+		}
+	}
+	if len(s.events) >= maxSubscriptionEvents {
+		s.events = s.events[1:]
+		s.lost = true
+	}
+	s.events = append(s.events, event)
+	select {
+	case s.ready <- struct{}{}:
+	default:
+	}
+}
+
+// getSubscription returns the subscription with the given token for the database.
+func (e *Executor) getSubscription(db CommandDB, token string) (*subscription, error) {
+	e.mutex.RLock()
+	defer e.mutex.RUnlock()
+	s, ok := e.subscriptions[token]
+	if !ok || s.db != db {
+		return nil, Fail("unknown or expired subscription '%s'", token)
+	}
+	return s, nil
+}
+
+// poll returns the events of the subscription and whether events have been dropped since the
+// last poll. If there are none, it waits up to the given time, at most MaxPollWait, for events.
+func (s *subscription) poll(wait time.Duration) ([]ChangeEvent, bool) {
+	if wait > MaxPollWait {
+		wait = MaxPollWait
+	}
+	s.mutex.Lock()
+	s.used = time.Now()
+	if len(s.events) == 0 && wait > 0 {
+		s.mutex.Unlock()
+		timer := time.NewTimer(wait)
+		select {
+		case <-s.ready:
+		case <-s.done:
+		case <-timer.C:
+		}
+		timer.Stop()
+		s.mutex.Lock()
+	}
+	defer s.mutex.Unlock()
+	events, lost := s.events, s.lost
+	s.events, s.lost = nil, false
+	s.used = time.Now()
+	select {
+	case <-s.ready:
+	default:
+	}
+	return events, lost
+}
+
+// unsubscribe removes the subscription with the given token.
+func (e *Executor) unsubscribe(token string) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.removeSubscriptionLocked(token)
+}
+
+// removeSubscriptions removes the subscriptions of the database.
+func (e *Executor) removeSubscriptions(db CommandDB) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	for token, s := range e.subscriptions {
+		if s.db == db {
+			e.removeSubscriptionLocked(token)
+		}
+	}
+}
+
+// removeSubscriptionLocked removes the subscription with the given token from its database and
+// wakes up the clients polling it. The caller must hold the mutex of the executor.
+func (e *Executor) removeSubscriptionLocked(token string) {
+	if s, ok := e.subscriptions[token]; ok {
+		delete(e.subscriptions, token)
+		s.stop()
+		close(s.done)
+	}
+}
+
+// eventsToResult stores the events in the result of a Poll command. Ints holds the kind and item
+// of each event in turn, and Strings its table, field, and old table. Bool is true if events
+// have been dropped.
+func eventsToResult(events []ChangeEvent, lost bool, r *Result) {
+	r.Ints = make([]int64, 0, 2*len(events))
+	r.Strings = make([]string, 0, 3*len(events))
+	for _, event := range events {
+		r.Ints = append(r.Ints, int64(event.Kind), int64(event.Item))
+		r.Strings = append(r.Strings, event.Table, event.Field, event.OldTable)
+	}
+	r.Bool = lost
+}
+
+// ParsePoll returns the events in the result of a Poll command, oldest first. Lost is true if
+// the subscription has dropped events because they were not polled in time, so the client
+// should read the data it is interested in again.
+func ParsePoll(r *Result) (events []ChangeEvent, lost bool) {
+	events = make([]ChangeEvent, 0, len(r.Ints)/2)
+	for i := 0; 2*i+1 < len(r.Ints) && 3*i+2 < len(r.Strings); i++ {
+		events = append(events, ChangeEvent{Kind: ChangeKind(r.Ints[2*i]), Item: Item(r.Ints[2*i+1]),
+			Table: r.Strings[3*i], Field: r.Strings[3*i+1], OldTable: r.Strings[3*i+2]})
+	}
+	return events, r.Bool
+}
+
+// SubscribeCommand returns a pointer to a command structure that subscribes to the changes of
+// the table, which are reported as committed by any client of the executor. The result contains
+// the token of the subscription in Str, which is used with PollCommand and UnsubscribeCommand.
+// Subscriptions that are not polled for ten minutes are removed, and so are all subscriptions of
+// a database when it is closed.
+func SubscribeCommand(db CommandDB, table string) *Command {
+	return &Command{
+		ID:      CmdSubscribe,
+		DB:      db,
+		StrArgs: []string{table},
+		Version: CommandVersion,
+	}
+}
+
+// SubscribeQueryCommand returns a pointer to a command structure that subscribes to the changes
+// of the items of the table of the query, like SubscribeCommand. New and modified items are only
+// reported if they match the query, but all removed items and schema changes of the table are.
+func SubscribeQueryCommand(db CommandDB, query *Query) *Command {
+	return &Command{
+		ID:       CmdSubscribeQuery,
+		DB:       db,
+		QueryArg: *query,
+		Version:  CommandVersion,
+	}
+}
+
+// PollCommand returns a pointer to a command structure that returns the change events of a
+// subscription since the last poll, see ParsePoll. If there are none, the server waits up to
+// the given time, at most MaxPollWait, for events. A waiting Poll command occupies one of the
+// workers of a server, so servers with many subscribers need more workers. A server keeps one
+// worker for the other requests and answers further Poll commands at once, even without events.
+func PollCommand(db CommandDB, subscription string, wait time.Duration) *Command {
+	return &Command{
+		ID:      CmdPoll,
+		DB:      db,
+		StrArgs: []string{subscription},
+		IntArg:  wait.Milliseconds(),
+		Version: CommandVersion,
+	}
+}
+
+// UnsubscribeCommand returns a pointer to a command structure that removes a subscription.
+func UnsubscribeCommand(db CommandDB, subscription string) *Command {
+	return &Command{
+		ID:      CmdUnsubscribe,
+		DB:      db,
+		StrArgs: []string{subscription},
+		Version: CommandVersion,
+	}
+}
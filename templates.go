@@ -0,0 +1,135 @@
+package minidb
+
+import (
+	"regexp"
+	"sort"
+)
+
+// ------------------------------------------------------------------------------
+// Query templates
+// ------------------------------------------------------------------------------
+
+// validParam matches the search terms of a template that are replaced by parameters.
+var validParam = regexp.MustCompile(`^\$[a-zA-Z_][a-zA-Z_0-9]*$`)
+
+// queryTemplate is a parsed query whose parameters are bound by RunTemplate.
+type queryTemplate struct {
+	query  *Query
+	params []string
+}
+
+// RegisterTemplate parses the query and stores it under the given name, replacing a template
+// of the same name. A search term of the form $name, as in "Person Name=$name", is a parameter
+// whose value must be given when the template is run with a RunTemplate command. The value
+// is used as the search term, so it may contain the wildcards % and _, but it cannot
+// change the structure of the query.
+func (e *Executor) RegisterTemplate(name string, query string) error {
+	if !validFieldName.MatchString(name) {
+		return Fail("invalid template name '%s'", name)
+	}
+	q, err := ParseQuery(query)
+	if err != nil {
+		return Fail("cannot parse template '%s': %s", name, err)
+	}
+	params := make(map[string]bool)
+	collectParams(q, params)
+	t := &queryTemplate{query: q, params: make([]string, 0, len(params))}
+	for param := range params {
+		t.params = append(t.params, param)
+	}
+	sort.Strings(t.params)
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.templates[name] = t
+	return nil
+}
+
+// TemplateParams returns the sorted names of the parameters of a template, without the $.
+func (e *Executor) TemplateParams(name string) ([]string, error) {
+	e.mutex.RLock()
+	defer e.mutex.RUnlock()
+	t, ok := e.templates[name]
+	if !ok {
+		return nil, Fail("unknown template '%s'", name)
+	}
+	return append([]string(nil), t.params...), nil
+}
+
+// SetTemplatesOnly restricts the queries of clients to the registered templates if on is true.
+// Find, FindWithin, FindStream, FindOpen, and ToSQL commands and commands that register templates then fail with
+// ErrNotPermitted, so templates can only be registered with RegisterTemplate by the server.
+func (e *Executor) SetTemplatesOnly(on bool) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.templatesOnly = on
+}
+
+// adHocQueryCommands are the commands rejected by an executor that only runs templates.
+var adHocQueryCommands = map[CommandID]bool{
+	CmdFind: true, CmdFindWithin: true, CmdToSQL: true, CmdRegisterTemplate: true, CmdFindStream: true,
+	CmdFindOpen: true, CmdSubscribeQuery: true,
+}
+
+// permitted returns false if the command is not allowed by the executor.
+func (e *Executor) permitted(id CommandID) bool {
+	e.mutex.RLock()
+	defer e.mutex.RUnlock()
+	return !e.templatesOnly || !adHocQueryCommands[id]
+}
+
+// bindTemplate returns the query of the template with its parameters replaced by the given values.
+// Every parameter of the template must be given and no others.
+func (e *Executor) bindTemplate(name string, values map[string][]Value) (*Query, error) {
+	e.mutex.RLock()
+	t, ok := e.templates[name]
+	e.mutex.RUnlock()
+	if !ok {
+		return nil, Fail("unknown template '%s'", name)
+	}
+	params := make(map[string]string, len(values))
+	for param, v := range values {
+		if len(v) != 1 || v[0].Sort != DBString {
+			return nil, Fail("parameter '%s' of template '%s' must be a single string", param, name)
+		}
+		params[param] = v[0].Str
+	}
+	known := make(map[string]bool, len(t.params))
+	for _, param := range t.params {
+		if _, ok := params[param]; !ok {
+			return nil, Fail("missing parameter '%s' of template '%s'", param, name)
+		}
+		known[param] = true
+	}
+	for param := range params {
+		if !known[param] {
+			return nil, Fail("template '%s' has no parameter '%s'", name, param)
+		}
+	}
+	q := bindParams(*t.query, params)
+	return &q, nil
+}
+
+// collectParams adds the names of the parameters in the query to params.
+func collectParams(q *Query, params map[string]bool) {
+	if q.Sort == QueryString && validParam.MatchString(q.Data) {
+		params[q.Data[1:]] = true
+	}
+	for i := range q.Children {
+		collectParams(&q.Children[i], params)
+	}
+}
+
+// bindParams returns a copy of the query with the parameters replaced by their values.
+func bindParams(q Query, params map[string]string) Query {
+	if q.Sort == QueryString && validParam.MatchString(q.Data) {
+		q.Data = params[q.Data[1:]]
+	}
+	if q.Children != nil {
+		children := make([]Query, len(q.Children))
+		for i := range q.Children {
+			children[i] = bindParams(q.Children[i], params)
+		}
+		q.Children = children
+	}
+	return q
+}
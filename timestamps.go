@@ -0,0 +1,94 @@
+package minidb
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ------------------------------------------------------------------------------
+// Automatic timestamps
+// ------------------------------------------------------------------------------
+
+const (
+	// CreatedField is the date field in which the creation time of an item is stored
+	// if timestamps are enabled for its table.
+	CreatedField = "_Created"
+	// ModifiedField is the date field in which the time of the last change of an item
+	// is stored if timestamps are enabled for its table.
+	ModifiedField = "_Modified"
+)
+
+// isTimestampField returns true if the field name is one of the fields maintained
+// by EnableTimestamps.
+func isTimestampField(name string) bool {
+	return name == CreatedField || name == ModifiedField
+}
+
+// EnableTimestamps adds the date fields _Created and _Modified to the table. From then on
+// NewItem, UseItem and NewItems set both fields of new items to the current time, and Set
+// and SetItem set the _Modified field of the item that is changed. The fields can be read
+// and searched like any other field, e.g. with "Person _Modified=2019%", but they cannot be
+// set. Items that exist before timestamps are enabled have NULL in both fields.
+// Nothing is changed if the table has timestamps already.
+func (tx *Tx) EnableTimestamps(table string) error {
+	if !validTable.MatchString(table) {
+		return Fail("invalid table name '%s'", table)
+	}
+	if !tx.View().TableExists(table) {
+		return tableNotFound(table)
+	}
+	if tx.View().HasTimestamps(table) {
+		return nil
+	}
+	for _, name := range []string{CreatedField, ModifiedField} {
+		if err := tx.addField(table, Field{Name: name, Sort: DBDate}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// HasTimestamps returns true if EnableTimestamps has been called for the table.
+func (db *MDB) HasTimestamps(table string) bool {
+	return db.FieldExists(table, CreatedField)
+}
+
+// stampNewItems sets the _Created and _Modified fields of new items if the table has timestamps.
+func (db *MDB) stampNewItems(e execer, table string, items []Item) error {
+	if len(items) == 0 || !db.HasTimestamps(table) {
+		return nil
+	}
+	now := NewDate(time.Now()).Str
+	chunk := maxBulkParams
+	for start := 0; start < len(items); start += chunk {
+		end := start + chunk
+		if end > len(items) {
+			end = len(items)
+		}
+		ids := make([]string, 0, end-start)
+		for _, item := range items[start:end] {
+			ids = append(ids, strconv.FormatInt(int64(item), 10))
+		}
+		_, err := e.Exec(fmt.Sprintf(`UPDATE "%s" SET "%s"=?,"%s"=? WHERE Id IN (%s);`,
+			table, CreatedField, ModifiedField, strings.Join(ids, ",")), now, now)
+		if err != nil {
+			return Fail("cannot set timestamps of new items in table %s: %s", table, err)
+		}
+	}
+	return nil
+}
+
+// touch sets the _Modified field of the item if the table has timestamps.
+func (tx *Tx) touch(table string, item Item) error {
+	if !tx.View().HasTimestamps(table) {
+		return nil
+	}
+	_, err := tx.tx.Exec(fmt.Sprintf(`UPDATE "%s" SET "%s"=? WHERE Id=?;`, table, ModifiedField),
+		NewDate(time.Now()).Str, item)
+	if err != nil {
+		return Fail("cannot set modification time of %s %d: %s", table, item, err)
+	}
+	return nil
+}
@@ -0,0 +1,120 @@
+package minidb
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestTimestamps(t *testing.T) {
+	tmp, _ := ioutil.TempFile("", "minidb-timestamps-testing-*")
+	defer os.Remove(tmp.Name())
+	db, err := Open("sqlite3", tmp.Name())
+	if err != nil {
+		t.Errorf("Open() failed: %s", err)
+	}
+	defer db.Close()
+	if err := db.AddTable("Person", []Field{Field{Name: "Name", Sort: DBString}, Field{Name: "Tags", Sort: DBStringList}}); err != nil {
+		t.Errorf("AddTable() failed: %s", err)
+	}
+	old, _ := db.NewItem("Person")
+	if db.HasTimestamps("Person") {
+		t.Errorf("HasTimestamps() should be false before EnableTimestamps()")
+	}
+	tx, _ := db.Begin()
+	if err := tx.EnableTimestamps("Person"); err != nil {
+		t.Errorf("EnableTimestamps() failed: %s", err)
+	}
+	if err := tx.EnableTimestamps("Person"); err != nil {
+		t.Errorf("EnableTimestamps() should have no effect the second time: %s", err)
+	}
+	if err := tx.EnableTimestamps("Nobody"); err == nil {
+		t.Errorf("EnableTimestamps() should fail for an unknown table")
+	}
+	tx.Commit()
+	if !db.HasTimestamps("Person") {
+		t.Errorf("HasTimestamps() should be true after EnableTimestamps()")
+	}
+	values, err := db.Get("Person", old, CreatedField)
+	if err != nil || len(values) != 1 || !values[0].IsNull() {
+		t.Errorf("existing items should have no creation time: %v %v", values, err)
+	}
+
+	before := time.Now().Add(-time.Second)
+	item, err := db.NewItem("Person")
+	if err != nil {
+		t.Errorf("NewItem() failed: %s", err)
+	}
+	created, err := db.Get("Person", item, CreatedField)
+	if err != nil || len(created) != 1 || created[0].Datetime().Before(before) {
+		t.Errorf("NewItem() did not set the creation time: %v %v", created, err)
+	}
+	modified, err := db.Get("Person", item, ModifiedField)
+	if err != nil || len(modified) != 1 || modified[0].String() != created[0].String() {
+		t.Errorf("NewItem() did not set the modification time: %v %v", modified, err)
+	}
+	used, _ := db.UseItem("Person", 100)
+	if values, _ := db.Get("Person", used, CreatedField); len(values) != 1 || values[0].IsNull() {
+		t.Errorf("UseItem() did not set the creation time: %v", values)
+	}
+
+	tx, _ = db.Begin()
+	tx.tx.Exec(`UPDATE Person SET _Modified='2000-01-01T00:00:00Z';`)
+	if err := tx.Set("Person", item, "Name", []Value{NewString("John")}); err != nil {
+		t.Errorf("Set() failed: %s", err)
+	}
+	if err := tx.SetItem("Person", old, map[string][]Value{"Tags": []Value{NewString("a")}}); err != nil {
+		t.Errorf("SetItem() failed: %s", err)
+	}
+	if err := tx.Set("Person", item, ModifiedField, []Value{NewDate(before)}); err == nil {
+		t.Errorf("Set() should fail for a timestamp field")
+	}
+	if err := tx.SetItem("Person", item, map[string][]Value{CreatedField: []Value{NewDate(before)}}); err == nil {
+		t.Errorf("SetItem() should fail for a timestamp field")
+	}
+	items, err := tx.NewItems("Person", [][]FieldValue{{{Field: "Name", Values: []Value{NewString("Anna")}}}})
+	if err != nil {
+		t.Errorf("NewItems() failed: %s", err)
+	}
+	if _, err := tx.NewItems("Person", [][]FieldValue{{{Field: CreatedField, Values: []Value{NewDate(before)}}}}); err == nil {
+		t.Errorf("NewItems() should fail for a timestamp field")
+	}
+	tx.Commit()
+	for _, it := range []Item{item, old, items[0]} {
+		values, err := db.Get("Person", it, ModifiedField)
+		if err != nil || len(values) != 1 || values[0].Datetime().Before(before) {
+			t.Errorf("modification time of item %d was not updated: %v %v", it, values, err)
+		}
+	}
+
+	query, err := ParseQuery("Person _Modified=2000%")
+	if err != nil {
+		t.Errorf("ParseQuery() failed: %s", err)
+	}
+	found, err := db.Find(query, 0)
+	if err != nil || len(found) != 1 || found[0] != used {
+		t.Errorf("Find() by modification time returned %v, %v", found, err)
+	}
+	query, _ = ParseQuery("Person _Created is null")
+	found, err = db.Find(query, 0)
+	if err != nil || len(found) != 1 || found[0] != old {
+		t.Errorf("Find() by missing creation time returned %v, %v", found, err)
+	}
+
+	schema, err := db.ExportSchema()
+	if err != nil {
+		t.Errorf("ExportSchema() failed: %s", err)
+	}
+	tmp2, _ := ioutil.TempFile("", "minidb-timestamps-testing-*")
+	defer os.Remove(tmp2.Name())
+	db2, _ := Open("sqlite3", tmp2.Name())
+	defer db2.Close()
+	if err := db2.ImportSchema(bytes.NewReader(schema)); err != nil {
+		t.Errorf("importing a schema with timestamps failed: %s", err)
+	}
+	if !db2.HasTimestamps("Person") {
+		t.Errorf("importing a schema did not enable timestamps")
+	}
+}
@@ -0,0 +1,204 @@
+package minidb
+
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ------------------------------------------------------------------------------
+// Leases of the transactions opened by an executor
+// ------------------------------------------------------------------------------
+
+// DefaultTxTimeout is the time after which an executor rolls back a transaction that has not
+// been used, see SetTxTimeout.
+const DefaultTxTimeout = 10 * time.Minute
+
+// expiredTxRetention is the time for which an executor remembers the IDs of expired transactions
+// to report their expiry.
+const expiredTxRetention = time.Hour
+
+// openTx is a transaction begun by a command. Its mutex protects the other fields. A transaction
+// is active while commands are executed in it and expires if it has been idle for too long.
+type openTx struct {
+	tx      *Tx
+	db      CommandDB
+	begun   time.Time
+	used    time.Time
+	active  int
+	done    bool
+	expired bool
+	timeout time.Duration
+	timer   *time.Timer
+	mutex   sync.Mutex
+}
+
+// TxInfo describes a transaction that is open in an executor, as returned by a ListTx command.
+// Idle is the time since the last command of the transaction, or 0 if a command is executed
+// in it at the moment.
+type TxInfo struct {
+	ID   TxID
+	Age  time.Duration
+	Idle time.Duration
+}
+
+// SetTxTimeout sets the time after which the executor rolls back transactions that are not used,
+// so that clients that disconnect without finishing their transactions do not hold the locks of
+// the database forever. The first command in an expired transaction within an hour of its expiry
+// fails with ErrTxExpired, and later ones with ErrUnknownTx. The timeout
+// applies to the transactions begun from now on, and a timeout of 0 turns expiry off. The
+// default is DefaultTxTimeout.
+func (e *Executor) SetTxTimeout(timeout time.Duration) {
+	atomic.StoreInt64(&e.txTimeout, int64(timeout))
+}
+
+// registerTx adds the transaction of the database to the open transactions and returns its ID.
+func (e *Executor) registerTx(db CommandDB, tx *Tx) TxID {
+	id := TxID(atomic.AddInt64(&e.txCounter, 1))
+	now := time.Now()
+	entry := &openTx{tx: tx, db: db, begun: now, used: now,
+		timeout: time.Duration(atomic.LoadInt64(&e.txTimeout))}
+	entry.mutex.Lock()
+	defer entry.mutex.Unlock()
+	e.txs.Store(id, entry)
+	if entry.timeout > 0 {
+		entry.timer = time.AfterFunc(entry.timeout, func() { e.expireTx(id, entry) })
+	}
+	return id
+}
+
+// acquireTx returns the open transaction with the ID and marks it as active, so that it does not
+// expire before it is released with releaseTx.
+func (e *Executor) acquireTx(id TxID) (*openTx, *Result) {
+	entry := e.lookupTx(id)
+	if entry != nil {
+		entry.mutex.Lock()
+		defer entry.mutex.Unlock()
+		if !entry.done {
+			entry.active++
+			if entry.timer != nil {
+				entry.timer.Stop()
+			}
+			return entry, nil
+		}
+	}
+	if entry != nil && entry.expired {
+		return nil, txExpired(id)
+	}
+	if _, ok := e.expiredTxs.Load(id); ok {
+		// expiry is only reported once
+		e.expiredTxs.Delete(id)
+		return nil, txExpired(id)
+	}
+	r := Result{HasError: true, Int: ErrUnknownTx}
+	r.Str = Fail("exec failed: transaction '%d' unknown", int64(id)).Error()
+	return nil, &r
+}
+
+// txExpired returns the result of a command in a transaction that has expired.
+func txExpired(id TxID) *Result {
+	r := Result{HasError: true, Int: ErrTxExpired}
+	r.Str = Fail("exec failed: transaction '%d' has expired and was rolled back", int64(id)).Error()
+	return &r
+}
+
+// releaseTx marks the end of a command in a transaction returned by acquireTx. The idle time
+// of the transaction starts again once no more commands are executed in it. It does nothing if
+// the transaction is nil.
+func (e *Executor) releaseTx(entry *openTx) {
+	if entry == nil {
+		return
+	}
+	entry.mutex.Lock()
+	defer entry.mutex.Unlock()
+	entry.active--
+	entry.used = time.Now()
+	if entry.active == 0 && !entry.done && entry.timer != nil {
+		entry.timer.Reset(entry.timeout)
+	}
+}
+
+// finishTx removes a transaction that is committed or rolled back.
+func (e *Executor) finishTx(id TxID) {
+	if entry := e.lookupTx(id); entry != nil {
+		entry.finish()
+	}
+	e.txs.Delete(id)
+}
+
+// finish marks the transaction as finished and stops its expiry.
+func (entry *openTx) finish() {
+	entry.mutex.Lock()
+	defer entry.mutex.Unlock()
+	entry.done = true
+	if entry.timer != nil {
+		entry.timer.Stop()
+	}
+}
+
+// expireTx rolls back the transaction unless it has been used or finished in the meantime.
+// Its ID is remembered for expiredTxRetention, so that the next command in the transaction fails
+// with ErrTxExpired rather than ErrUnknownTx. The IDs of transactions that have expired before
+// are forgotten after that time.
+func (e *Executor) expireTx(id TxID, entry *openTx) {
+	entry.mutex.Lock()
+	if entry.done || entry.active > 0 {
+		entry.mutex.Unlock()
+		return
+	}
+	entry.done = true
+	entry.expired = true
+	entry.mutex.Unlock()
+	now := time.Now()
+	e.expiredTxs.Range(func(other, expired interface{}) bool {
+		if now.Sub(expired.(time.Time)) > expiredTxRetention {
+			e.expiredTxs.Delete(other)
+		}
+		return true
+	})
+	e.expiredTxs.Store(id, now)
+	e.txs.Delete(id)
+	entry.tx.Rollback()
+}
+
+// listTx returns the open transactions of the database ordered by their IDs.
+func (e *Executor) listTx(db CommandDB) []TxInfo {
+	now := time.Now()
+	txs := make([]TxInfo, 0)
+	e.txs.Range(func(id, tx interface{}) bool {
+		entry := tx.(*openTx)
+		entry.mutex.Lock()
+		defer entry.mutex.Unlock()
+		if entry.db != db || entry.done {
+			return true
+		}
+		info := TxInfo{ID: id.(TxID), Age: now.Sub(entry.begun)}
+		if entry.active == 0 {
+			info.Idle = now.Sub(entry.used)
+		}
+		txs = append(txs, info)
+		return true
+	})
+	sort.Slice(txs, func(i, j int) bool { return txs[i].ID < txs[j].ID })
+	return txs
+}
+
+// txListToResult stores the transactions in the result of a ListTx command. Ints holds the ID,
+// age, and idle time of each transaction in turn, with times in milliseconds.
+func txListToResult(txs []TxInfo, r *Result) {
+	r.Ints = make([]int64, 0, 3*len(txs))
+	for _, info := range txs {
+		r.Ints = append(r.Ints, int64(info.ID), info.Age.Milliseconds(), info.Idle.Milliseconds())
+	}
+}
+
+// ParseListTx returns the transactions in the result of a ListTx command.
+func ParseListTx(r *Result) []TxInfo {
+	txs := make([]TxInfo, 0, len(r.Ints)/3)
+	for i := 0; i+2 < len(r.Ints); i += 3 {
+		txs = append(txs, TxInfo{ID: TxID(r.Ints[i]), Age: time.Duration(r.Ints[i+1]) * time.Millisecond,
+			Idle: time.Duration(r.Ints[i+2]) * time.Millisecond})
+	}
+	return txs
+}
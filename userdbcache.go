@@ -0,0 +1,184 @@
+package minidb
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ------------------------------------------------------------------------------
+// Cache of open user databases of multiuser databases
+// ------------------------------------------------------------------------------
+
+// DefaultMaxOpenUserDBs is the number of user databases a MultiDB keeps open by default.
+const DefaultMaxOpenUserDBs = 64
+
+// userDBEntry is an open user database and the time it was last returned by UserDB.
+type userDBEntry struct {
+	db       *MDB
+	lastUsed time.Time
+}
+
+// userDBCache holds the open user databases of a MultiDB. When more than maxOpen databases
+// are open, the least recently used one is closed, and databases that have not been used for
+// the idle timeout are closed in the background. Databases with an open transaction, such as one
+// kept open by an executor between commands, are not closed until the transaction is finished.
+// The cached databases ignore Close, so that callers cannot close them behind the back of the
+// cache.
+type userDBCache struct {
+	entries map[Item]*userDBEntry
+	maxOpen int
+	idle    time.Duration
+	stop    chan struct{}
+	mutex   sync.Mutex
+}
+
+func newUserDBCache() *userDBCache {
+	return &userDBCache{entries: make(map[Item]*userDBEntry), maxOpen: DefaultMaxOpenUserDBs}
+}
+
+// get returns the open database of the user with the ID and marks it as used, or nil.
+func (c *userDBCache) get(id Item) *MDB {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	entry := c.entries[id]
+	if entry == nil {
+		return nil
+	}
+	entry.lastUsed = time.Now()
+	return entry.db
+}
+
+// put adds the open database of the user with the ID and closes the least recently used
+// databases beyond the maximum. If another database of the user has been added in the meantime,
+// the database is closed and the other one is returned.
+func (c *userDBCache) put(id Item, db *MDB) *MDB {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if entry := c.entries[id]; entry != nil {
+		db.Close()
+		entry.lastUsed = time.Now()
+		return entry.db
+	}
+	db.keepOpen = true
+	c.entries[id] = &userDBEntry{db: db, lastUsed: time.Now()}
+	c.evict(id)
+	return db
+}
+
+// evict closes the least recently used databases other than that of the user with the ID until
+// at most maxOpen databases are open. Databases in a transaction are skipped, so more than
+// maxOpen databases may remain open. The caller must hold the mutex.
+func (c *userDBCache) evict(keep Item) {
+	for c.maxOpen > 0 && len(c.entries) > c.maxOpen {
+		var oldest Item
+		for id, entry := range c.entries {
+			if id != keep && !entry.db.inTransaction() &&
+				(oldest == 0 || entry.lastUsed.Before(c.entries[oldest].lastUsed)) {
+				oldest = id
+			}
+		}
+		if oldest == 0 {
+			return
+		}
+		c.entries[oldest].db.close()
+		delete(c.entries, oldest)
+	}
+}
+
+// close closes and removes the database of the user with the ID, if it is open.
+func (c *userDBCache) close(id Item) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	entry := c.entries[id]
+	if entry == nil {
+		return nil
+	}
+	delete(c.entries, id)
+	return entry.db.close()
+}
+
+// closeAll closes all databases and stops closing idle databases. It returns the number of
+// databases that could not be closed and their errors.
+func (c *userDBCache) closeAll() (int, string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.stop != nil {
+		close(c.stop)
+		c.stop = nil
+	}
+	errcount := 0
+	s := ""
+	for id, entry := range c.entries {
+		if err := entry.db.close(); err != nil {
+			s = fmt.Sprintf("%s, %s", s, err.Error())
+			errcount++
+		}
+		delete(c.entries, id)
+	}
+	return errcount, s
+}
+
+// closeIdle closes the databases that have not been used for the idle timeout and are not in a
+// transaction.
+func (c *userDBCache) closeIdle() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	now := time.Now()
+	for id, entry := range c.entries {
+		if now.Sub(entry.lastUsed) >= c.idle && !entry.db.inTransaction() {
+			entry.db.close()
+			delete(c.entries, id)
+		}
+	}
+}
+
+// watchIdle closes idle databases periodically until stop is closed.
+func (c *userDBCache) watchIdle(interval time.Duration, stop chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			c.closeIdle()
+		}
+	}
+}
+
+// SetMaxOpenUserDBs sets the number of user databases that are kept open by UserDB. When it is
+// exceeded, the least recently used database is closed. A value of 0 or less keeps all of them
+// open. Callers should obtain a user database from UserDB for each use rather than keep it,
+// since it may be closed when it is evicted.
+func (m *MultiDB) SetMaxOpenUserDBs(n int) {
+	m.userdbs.mutex.Lock()
+	defer m.userdbs.mutex.Unlock()
+	m.userdbs.maxOpen = n
+	m.userdbs.evict(0)
+}
+
+// SetUserDBIdleTimeout closes user databases that have not been returned by UserDB for the
+// duration, checking a few times per duration in the background. A duration of 0 or less keeps
+// them open until they are evicted or the MultiDB is closed.
+func (m *MultiDB) SetUserDBIdleTimeout(d time.Duration) {
+	c := m.userdbs
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.stop != nil {
+		close(c.stop)
+		c.stop = nil
+	}
+	c.idle = d
+	if d > 0 {
+		c.stop = make(chan struct{})
+		go c.watchIdle(d/4+time.Millisecond, c.stop)
+	}
+}
+
+// OpenUserDBs returns the number of user databases that are open.
+func (m *MultiDB) OpenUserDBs() int {
+	m.userdbs.mutex.Lock()
+	defer m.userdbs.mutex.Unlock()
+	return len(m.userdbs.entries)
+}
@@ -0,0 +1,80 @@
+package minidb
+
+import (
+	"encoding/json"
+	"io"
+)
+
+// ------------------------------------------------------------------------------
+// Exporting the data of users of multiuser databases
+// ------------------------------------------------------------------------------
+
+// exportedUser is the user in a document written by ExportUserData. Unlike the user record in
+// archives, it holds no keys or salts.
+type exportedUser struct {
+	Username string   `json:"username"`
+	Email    string   `json:"email"`
+	Created  string   `json:"created"`
+	Modified string   `json:"modified"`
+	Disabled bool     `json:"disabled"`
+	Roles    []Role   `json:"roles"`
+	Groups   []string `json:"groups"`
+}
+
+// ExportUserData writes all data stored about the user as a JSON document to w, for example to
+// answer a subject access request. The document has the form
+//
+//	{"user":{"username":"John","email":"john@test.com",...},"database":{...},"keyvalue":{...}}
+//
+// where the user holds the record of the user in the system database with the roles and the
+// names of the groups of the user, but without keys and salts, the database holds the tables of
+// the user database as written by ExportJSON, and the keyvalue holds its key-value store as
+// written by ExportKV. Shared group databases are not included. The database of an encrypted
+// user can only be exported with a user returned by Authenticate.
+func (m *MultiDB) ExportUserData(user *User, w io.Writer) (ErrCode, error) {
+	if user == nil || user.id == 0 {
+		return ErrUnknownUser, Fail(`unknown user`)
+	}
+	record, err := m.userRecord(user)
+	if err != nil {
+		return ErrDBFail, Fail(`could not read user "%s": %s`, user.name, err)
+	}
+	roles, reply, err := m.Roles(user)
+	if err != nil {
+		return reply, err
+	}
+	groups, reply, err := m.Groups(user)
+	if err != nil {
+		return reply, err
+	}
+	exported := exportedUser{Username: record.Username, Email: record.Email, Created: record.Created,
+		Modified: record.Modified, Disabled: m.UserDisabled(user), Roles: roles,
+		Groups: make([]string, len(groups))}
+	for i, group := range groups {
+		exported.Groups[i] = group.Name()
+	}
+	db, reply, err := m.UserDB(user)
+	if err != nil {
+		return reply, err
+	}
+	data, err := json.Marshal(exported)
+	if err != nil {
+		return ErrExportFail, Fail(`could not export user "%s": %s`, user.name, err)
+	}
+	if _, err := io.WriteString(w, `{"user":`+string(data)+`,"database":`); err != nil {
+		return ErrExportFail, Fail(`could not export user "%s": %s`, user.name, err)
+	}
+	if err := db.ExportJSON(w); err != nil {
+		return ErrExportFail, Fail(`could not export the database of user "%s": %s`, user.name, err)
+	}
+	if _, err := io.WriteString(w, `,"keyvalue":`); err != nil {
+		return ErrExportFail, Fail(`could not export user "%s": %s`, user.name, err)
+	}
+	if err := db.ExportKV(w); err != nil {
+		return ErrExportFail, Fail(`could not export the key-value store of user "%s": %s`, user.name, err)
+	}
+	if _, err := io.WriteString(w, `}`); err != nil {
+		return ErrExportFail, Fail(`could not export user "%s": %s`, user.name, err)
+	}
+	return OK, nil
+}
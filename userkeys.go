@@ -0,0 +1,160 @@
+package minidb
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/hex"
+
+	"golang.org/x/crypto/argon2"
+)
+
+// ------------------------------------------------------------------------------
+// Encrypted user databases of multiuser databases
+// ------------------------------------------------------------------------------
+
+// dataKeyLength is the length of the keys that encrypt user databases and of the keys that
+// wrap them.
+const dataKeyLength = 32
+
+// SetUserEncryption determines whether NewUser creates users whose databases are encrypted with
+// SQLCipher, see Options.EncryptionKey. The random key of such a database is stored wrapped by
+// a key derived from the user's password with Argon2, so it can only be unwrapped by
+// Authenticate, and UserDB can only open the database of a user returned by NewUser or
+// Authenticate. Users created before are not affected.
+func (m *MultiDB) SetUserEncryption(on bool) {
+	m.encryptUsers = on
+}
+
+// wrappingKey derives the key that wraps the data key of a user from the salted key, using
+// another salt than the internal key stored for authentication.
+func wrappingKey(key *saltedKey, salt []byte) []byte {
+	return argon2.IDKey(key.pwd, salt, key.p.Argon2Iterations, key.p.Argon2Memory,
+		key.p.Argon2Parallelism, dataKeyLength)
+}
+
+// sealDataKey encrypts the data key with the wrapping key using AES-GCM.
+func sealDataKey(wrap, dataKey []byte) ([]byte, error) {
+	block, err := aes.NewCipher(wrap)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return gcm.Seal(nonce, nonce, dataKey, nil), nil
+}
+
+// openDataKey decrypts a data key encrypted by sealDataKey.
+func openDataKey(wrap, sealed []byte) ([]byte, error) {
+	block, err := aes.NewCipher(wrap)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return nil, Fail(`wrapped key is too short`)
+	}
+	return gcm.Open(nil, sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():], nil)
+}
+
+// newDataKey returns a new random data key.
+func newDataKey() ([]byte, ErrCode, error) {
+	dataKey := make([]byte, dataKeyLength)
+	if n, err := rand.Read(dataKey); n != dataKeyLength || err != nil {
+		return nil, ErrCryptoRandFailure, Fail(`random number generator failed to generate key`)
+	}
+	return dataKey, OK, nil
+}
+
+// storeDataKey wraps the data key of the user with the ID by the salted key and stores it in
+// the transaction, replacing a previously stored data key.
+func storeDataKey(tx *Tx, id Item, key *saltedKey, dataKey []byte) (ErrCode, error) {
+	salt := make([]byte, key.p.InternalSaltLength)
+	if n, err := rand.Read(salt); uint32(n) != key.p.InternalSaltLength || err != nil {
+		return ErrCryptoRandFailure, Fail(`random number generator failed to generate salt`)
+	}
+	sealed, err := sealDataKey(wrappingKey(key, salt), dataKey)
+	if err != nil {
+		return ErrInvalidKey, Fail(`could not wrap the database key: %s`, err)
+	}
+	return storeWrappedKey(tx, id, salt, sealed)
+}
+
+// storeWrappedKey stores the wrapped data key of the user with the ID and the salt of its
+// wrapping key in the transaction.
+func storeWrappedKey(tx *Tx, id Item, salt, sealed []byte) (ErrCode, error) {
+	values := map[string][]Value{
+		"User":     []Value{NewInt(int64(id))},
+		"WrapSalt": []Value{NewBytes(salt)},
+		"DataKey":  []Value{NewBytes(sealed)}}
+	var item int64
+	err := tx.tx.QueryRow(`SELECT Id FROM UserKey WHERE User=?;`, int64(id)).Scan(&item)
+	if err == nil {
+		err = tx.SetItem("UserKey", Item(item), values)
+	} else {
+		row := make([]FieldValue, 0, len(values))
+		for field, v := range values {
+			row = append(row, FieldValue{Field: field, Values: v})
+		}
+		_, err = tx.NewItems("UserKey", [][]FieldValue{row})
+	}
+	if err != nil {
+		return ErrDBFail, Fail(`could not store the database key: %s`, err)
+	}
+	return OK, nil
+}
+
+// wrappedKey returns the salt of the wrapping key and the wrapped data key of the user with
+// the ID, or nil if the database of the user is not encrypted.
+func (m *MultiDB) wrappedKey(id Item) (salt, sealed []byte) {
+	err := m.system.reader.QueryRow(`SELECT WrapSalt,DataKey FROM UserKey WHERE User=?;`, int64(id)).Scan(&salt, &sealed)
+	if err != nil {
+		return nil, nil
+	}
+	return salt, sealed
+}
+
+// loadDataKey returns the data key of the user with the ID unwrapped by the salted key, or nil
+// if the database of the user is not encrypted.
+func (m *MultiDB) loadDataKey(id Item, key *saltedKey) ([]byte, ErrCode, error) {
+	salt, sealed := m.wrappedKey(id)
+	if sealed == nil {
+		return nil, OK, nil
+	}
+	dataKey, err := openDataKey(wrappingKey(key, salt), sealed)
+	if err != nil {
+		return nil, ErrInvalidKey, Fail(`could not unwrap the database key: %s`, err)
+	}
+	return dataKey, OK, nil
+}
+
+// encryptedUserDB returns true if the database of the user with the ID is encrypted.
+func (m *MultiDB) encryptedUserDB(id Item) bool {
+	_, sealed := m.wrappedKey(id)
+	return sealed != nil
+}
+
+// openUserDB opens the database of the user, with the user's data key if the user has one.
+func (m *MultiDB) openUserDB(user *User) (*MDB, ErrCode, error) {
+	if user.dataKey == nil {
+		db, err := Open(m.driver, m.userDBFile(user))
+		if err != nil {
+			return nil, ErrOpenFailed, err
+		}
+		return db, OK, nil
+	}
+	db, err := OpenWithOptions(m.driver, m.userDBFile(user), &Options{EncryptionKey: hex.EncodeToString(user.dataKey)})
+	if err != nil {
+		return nil, ErrOpenFailed, err
+	}
+	return db, OK, nil
+}
@@ -0,0 +1,54 @@
+package minidb
+
+import "sync"
+
+// ------------------------------------------------------------------------------
+// Validation of field values
+// ------------------------------------------------------------------------------
+
+// Validator checks the values that are about to be stored in a field and returns an error
+// if they are not acceptable, e.g. because a string is not a valid email address.
+type Validator func(values []Value) error
+
+// validators holds the functions registered with AddValidator. It is shared by an MDB and its views.
+type validators struct {
+	sync.RWMutex
+	byField map[string]map[string][]Validator
+}
+
+// AddValidator registers a function that checks the values of the field before they are
+// written by Set, SetItem, and NewItems. The values have the type of the field and have
+// passed all other checks, and nothing is written if a validator returns an error.
+// Several validators can be added for the same field and are called in the order in which
+// they were added. The table and field do not need to exist yet.
+func (db *MDB) AddValidator(table string, field string, fn Validator) {
+	v := db.validators
+	v.Lock()
+	defer v.Unlock()
+	if v.byField[table] == nil {
+		v.byField[table] = make(map[string][]Validator)
+	}
+	v.byField[table][field] = append(v.byField[table][field], fn)
+}
+
+// RemoveValidators removes all validators of the field.
+func (db *MDB) RemoveValidators(table string, field string) {
+	v := db.validators
+	v.Lock()
+	defer v.Unlock()
+	delete(v.byField[table], field)
+}
+
+// validate calls the validators of the field and returns the first error.
+func (db *MDB) validate(table string, field string, data []Value) error {
+	v := db.validators
+	v.RLock()
+	fns := v.byField[table][field]
+	v.RUnlock()
+	for _, fn := range fns {
+		if err := fn(data); err != nil {
+			return Fail("invalid value for field '%s' in table '%s': %s", field, table, err)
+		}
+	}
+	return nil
+}
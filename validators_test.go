@@ -0,0 +1,67 @@
+package minidb
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestValidators(t *testing.T) {
+	tmp, _ := ioutil.TempFile("", "minidb-validators-testing-*")
+	defer os.Remove(tmp.Name())
+	db, err := Open("sqlite3", tmp.Name())
+	if err != nil {
+		t.Errorf("Open() failed: %s", err)
+	}
+	defer db.Close()
+	if err := db.AddTable("Person", []Field{Field{Name: "Email", Sort: DBString}, Field{Name: "Aliases", Sort: DBStringList}}); err != nil {
+		t.Errorf("AddTable() failed: %s", err)
+	}
+	email := func(values []Value) error {
+		for _, v := range values {
+			if !strings.Contains(v.String(), "@") {
+				return errors.New("not an email address")
+			}
+		}
+		return nil
+	}
+	db.AddValidator("Person", "Email", email)
+	db.AddValidator("Person", "Aliases", email)
+	calls := 0
+	db.AddValidator("Person", "Aliases", func(values []Value) error {
+		calls++
+		return nil
+	})
+	item, _ := db.NewItem("Person")
+	tx, _ := db.Begin()
+	defer tx.Rollback()
+	if err := tx.Set("Person", item, "Email", []Value{NewString("john")}); err == nil {
+		t.Errorf("Set() should fail for a value rejected by a validator")
+	}
+	if err := tx.Set("Person", item, "Email", []Value{NewString("john@example.com")}); err != nil {
+		t.Errorf("Set() failed: %s", err)
+	}
+	err = tx.SetItem("Person", item, map[string][]Value{"Email": []Value{NewString("jack@example.com")},
+		"Aliases": []Value{NewString("j@example.com"), NewString("jj")}})
+	if err == nil {
+		t.Errorf("SetItem() should fail for a value rejected by a validator")
+	}
+	if values, _ := tx.View().Get("Person", item, "Email"); len(values) != 1 || values[0].String() != "john@example.com" {
+		t.Errorf("SetItem() changed the item although a value was rejected: %v", values)
+	}
+	if calls != 0 {
+		t.Errorf("validators after a failing validator should not be called")
+	}
+	if _, err := tx.NewItems("Person", [][]FieldValue{{{Field: "Email", Values: []Value{NewString("anna")}}}}); err == nil {
+		t.Errorf("NewItems() should fail for a value rejected by a validator")
+	}
+	if err := tx.Set("Person", item, "Aliases", []Value{NewString("j@example.com")}); err != nil || calls != 1 {
+		t.Errorf("Set() with several validators returned %v after %d calls", err, calls)
+	}
+	db.RemoveValidators("Person", "Email")
+	if err := tx.Set("Person", item, "Email", []Value{NewString("john")}); err != nil {
+		t.Errorf("Set() failed after RemoveValidators(): %s", err)
+	}
+}
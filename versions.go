@@ -0,0 +1,69 @@
+package minidb
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// ------------------------------------------------------------------------------
+// Item versions for optimistic concurrency control
+// ------------------------------------------------------------------------------
+
+// VersionConflictError is returned by SetIfVersion if the item has been changed since
+// the expected version was read.
+type VersionConflictError struct {
+	Table    string
+	Item     Item
+	Expected int64
+	Actual   int64
+}
+
+func (e *VersionConflictError) Error() string {
+	return fmt.Sprintf("version conflict for %s %d: expected version %d, found %d",
+		e.Table, e.Item, e.Expected, e.Actual)
+}
+
+// Version returns the version of the item, which is 0 for a new item and increased by one
+// with every successful call of Set and SetItem for the item. Clients can read an item
+// together with its version and later write it with SetIfVersion, which fails if another
+// client has changed the item in the meantime.
+func (db *MDB) Version(table string, item Item) (int64, error) {
+	if !validTable.MatchString(table) {
+		return 0, Fail("invalid table name '%s'", table)
+	}
+	if !db.ItemExists(table, item) {
+		return 0, itemNotFound(table, item)
+	}
+	var version int64
+	err := db.reader.QueryRow(`SELECT Version FROM _VERSIONS WHERE TableName=? AND Item=?;`, table, item).Scan(&version)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, Fail("cannot get version of %s %d: %s", table, item, err)
+	}
+	return version, nil
+}
+
+// bumpVersion increases the version of the item by one.
+func (tx *Tx) bumpVersion(table string, item Item) error {
+	_, err := tx.tx.Exec(`INSERT INTO _VERSIONS (TableName,Item,Version) VALUES (?,?,1)
+	ON CONFLICT(TableName,Item) DO UPDATE SET Version=Version+1;`, table, item)
+	if err != nil {
+		return Fail("cannot update version of %s %d: %s", table, item, err)
+	}
+	return nil
+}
+
+// SetIfVersion sets the values of the field like Set, but only if the item still has the
+// expected version. Otherwise nothing is written and a *VersionConflictError is returned.
+func (tx *Tx) SetIfVersion(table string, item Item, field string, data []Value, expected int64) error {
+	version, err := tx.View().Version(table, item)
+	if err != nil {
+		return err
+	}
+	if version != expected {
+		return &VersionConflictError{Table: table, Item: item, Expected: expected, Actual: version}
+	}
+	return tx.Set(table, item, field, data)
+}
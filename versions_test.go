@@ -0,0 +1,67 @@
+package minidb
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestVersions(t *testing.T) {
+	tmp, _ := ioutil.TempFile("", "minidb-versions-testing-*")
+	defer os.Remove(tmp.Name())
+	db, err := Open("sqlite3", tmp.Name())
+	if err != nil {
+		t.Errorf("Open() failed: %s", err)
+	}
+	defer db.Close()
+	if err := db.AddTable("Person", []Field{Field{Name: "Name", Sort: DBString}, Field{Name: "Tags", Sort: DBStringList}}); err != nil {
+		t.Errorf("AddTable() failed: %s", err)
+	}
+	item, _ := db.NewItem("Person")
+	if v, err := db.Version("Person", item); err != nil || v != 0 {
+		t.Errorf("a new item has version %d (%v), expected 0", v, err)
+	}
+	if _, err := db.Version("Person", 12345); err == nil {
+		t.Errorf("Version() should fail for an item that does not exist")
+	}
+
+	tx, _ := db.Begin()
+	tx.Set("Person", item, "Name", []Value{NewString("John")})
+	tx.SetItem("Person", item, map[string][]Value{"Tags": []Value{NewString("a")}})
+	if v, _ := tx.View().Version("Person", item); v != 2 {
+		t.Errorf("the transaction sees version %d, expected 2", v)
+	}
+	tx.Commit()
+	if v, _ := db.Version("Person", item); v != 2 {
+		t.Errorf("version is %d after two changes, expected 2", v)
+	}
+
+	// two clients read version 2, the second write must fail
+	tx, _ = db.Begin()
+	if err := tx.SetIfVersion("Person", item, "Name", []Value{NewString("Jim")}, 2); err != nil {
+		t.Errorf("SetIfVersion() with the current version failed: %s", err)
+	}
+	err = tx.SetIfVersion("Person", item, "Name", []Value{NewString("Jack")}, 2)
+	conflict, ok := err.(*VersionConflictError)
+	if !ok || conflict.Expected != 2 || conflict.Actual != 3 || conflict.Item != item {
+		t.Errorf("SetIfVersion() with an old version returned %v, expected a conflict", err)
+	}
+	tx.Commit()
+	if v, _ := db.Get("Person", item, "Name"); len(v) != 1 || v[0].Str != "Jim" {
+		t.Errorf("Name is %v after a conflict, expected Jim", v)
+	}
+
+	tx, _ = db.Begin()
+	tx.RenameTable("Person", "People")
+	tx.Commit()
+	if v, _ := db.Version("People", item); v != 3 {
+		t.Errorf("version is %d after renaming the table, expected 3", v)
+	}
+	tx, _ = db.Begin()
+	tx.RemoveItem("People", item)
+	tx.Commit()
+	db.UseItem("People", uint64(item))
+	if v, _ := db.Version("People", item); v != 0 {
+		t.Errorf("a reused item has version %d, expected 0", v)
+	}
+}
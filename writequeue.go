@@ -0,0 +1,110 @@
+package minidb
+
+import "sync/atomic"
+
+// ------------------------------------------------------------------------------
+// Serialization of writes in the executor
+// ------------------------------------------------------------------------------
+
+// DefaultWriteBacklog is the number of write commands that may wait for execution per database
+// before Exec rejects further writes with ErrWriteQueueFull.
+const DefaultWriteBacklog = 64
+
+// unqueuedCommands are the commands that are not reads but are nevertheless executed directly
+// by Exec instead of being put into the write queue of their database. Transactions are
+// serialized by the database itself, see MDB.Begin.
+var unqueuedCommands = map[CommandID]bool{
+	CmdPing: true, CmdOpen: true, CmdClose: true, CmdRegisterTemplate: true,
+	CmdFindNext: true, CmdFindClose: true, CmdBegin: true, CmdCommit: true, CmdRollback: true,
+	CmdBeginRead: true, CmdListTx: true, CmdFetch: true, CmdCursorClose: true, CmdSubmit: true,
+	CmdJobStatus: true, CmdJobResult: true, CmdJobCancel: true, CmdStats: true,
+	CmdPoll: true, CmdUnsubscribe: true,
+}
+
+// writeJob is a command waiting in a write queue together with the channel for its result.
+type writeJob struct {
+	cmd    *Command
+	result chan *Result
+}
+
+// writeQueue is the backlog of write commands of a database, executed one after the other
+// in the order of their arrival by a single goroutine.
+type writeQueue struct {
+	jobs chan writeJob
+	done chan struct{}
+}
+
+// run executes the jobs of the queue until it is stopped.
+func (q *writeQueue) run(e *Executor) {
+	defer close(q.done)
+	for job := range q.jobs {
+		job.result <- e.exec(job.cmd)
+	}
+}
+
+// SetWriteBacklog sets the number of write commands that may wait for execution per database.
+// Write commands of all clients of a database that are not part of a transaction are executed
+// one after the other by a single goroutine, while read commands are executed immediately.
+// If the backlog of a database is full, Exec fails with ErrWriteQueueFull and the client should
+// try again later. A backlog of 0 or less turns the queue off, so writes are executed directly
+// and concurrent writes may fail with SQLite lock errors. The new size applies to queues created afterwards, i.e., to
+// databases without pending writes.
+func (e *Executor) SetWriteBacklog(n int) {
+	e.stopWriteQueues()
+	atomic.StoreInt64(&e.writeBacklog, int64(n))
+}
+
+// isQueuedWrite returns true if the command is executed by the write queue of its database.
+// Commands in a transaction are executed directly, because the transaction has exclusive
+// access to the database until it is finished and commands waiting in the queue would block it.
+func isQueuedWrite(cmd *Command) bool {
+	return cmd.Tx == 0 && !readCommands[cmd.ID] && !unqueuedCommands[cmd.ID]
+}
+
+// enqueueWrite puts the command into the write queue of its database and waits for its result.
+// It returns false if the command must be executed directly because the queue is turned off
+// or the database is not open in the executor. Only the database of the command is locked.
+func (e *Executor) enqueueWrite(cmd *Command) (*Result, bool) {
+	backlog := atomic.LoadInt64(&e.writeBacklog)
+	entry := e.lookupDB(cmd.DB)
+	if backlog <= 0 || entry == nil {
+		return nil, false
+	}
+	entry.mutex.Lock()
+	if entry.closed {
+		entry.mutex.Unlock()
+		return nil, false
+	}
+	if entry.queue == nil {
+		entry.queue = &writeQueue{jobs: make(chan writeJob, backlog), done: make(chan struct{})}
+		go entry.queue.run(e)
+	}
+	job := writeJob{cmd: cmd, result: make(chan *Result, 1)}
+	select {
+	case entry.queue.jobs <- job:
+	default:
+		entry.mutex.Unlock()
+		r := Result{HasError: true, Int: ErrWriteQueueFull}
+		r.Str = Fail("exec failed: too many pending writes for db '%s'", cmd.DB).Error()
+		return &r, true
+	}
+	entry.mutex.Unlock()
+	return <-job.result, true
+}
+
+// stopWriteQueues stops all write queues and waits until their pending commands have been executed.
+func (e *Executor) stopWriteQueues() {
+	queues := make([]*writeQueue, 0)
+	for _, entry := range e.openDBs() {
+		entry.mutex.Lock()
+		if entry.queue != nil {
+			close(entry.queue.jobs)
+			queues = append(queues, entry.queue)
+			entry.queue = nil
+		}
+		entry.mutex.Unlock()
+	}
+	for _, q := range queues {
+		<-q.done
+	}
+}